@@ -0,0 +1,340 @@
+// Command msupply-reports generates and administers the plugin's reports without the UI, e.g. from cron or a
+// deployment script. It works on the plugin's own database, so it runs on the Grafana server, from the directory
+// the plugin runs in or with -dir pointing at it.
+//
+// Usage:
+//
+//	msupply-reports [-dir directory] [-org id] <command> [arguments]
+//
+// The commands are:
+//
+//	schedules                    list the schedules
+//	run [-to a,b] [-wait] <id>   queue a schedule's report to be sent by the plugin now
+//	export [-o file] [id ...]    write the configuration of the schedules, all of them by default, as a bundle
+//	generate [-o directory] <id> write a schedule's report to files without sending it
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
+	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
+)
+
+const usage = `Usage: msupply-reports [-dir directory] [-org id] <command> [arguments]
+
+Commands:
+  schedules                    list the schedules
+  run [-to a,b] [-wait] <id>   queue a schedule's report to be sent by the plugin now
+  export [-o file] [id ...]    write the configuration of the schedules, all of them by default, as a bundle
+  generate [-o directory] <id> write a schedule's report to files without sending it
+
+Flags:
+`
+
+// Where the plugin keeps its database and writes its reports, relative to the directory it runs in
+var dataDirectory = filepath.Join("..", "data")
+
+// How often run -wait checks on the job it queued
+const pollInterval = 2 * time.Second
+
+type command func(ctx context.Context, db *dbstore.SQLiteDatasource, args []string) error
+
+var commands = map[string]command{
+	"schedules": listSchedules,
+	"run":       runSchedule,
+	"export":    exportBundle,
+	"generate":  generateReport,
+}
+
+// Directory the command was started in, which the paths given to it are relative to
+var workingDirectory string
+
+func main() {
+	dir := flag.String("dir", ".", "directory the plugin runs in, whose data is kept in ../data")
+	orgID := flag.Int64("org", 0, "Grafana organisation whose database is used, when each has their own")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := execute(run, *dir, *orgID, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "msupply-reports: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+func execute(run command, dir string, orgID int64, args []string) error {
+	var err error
+	workingDirectory, err = os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// The plugin's paths, e.g. of its database and the reports it writes, are relative to where it runs
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dataDirectory); err != nil {
+		return fmt.Errorf("%s isn't the directory the plugin runs in, there is no ../data next to it", dir)
+	}
+
+	if err := i18n.UseDirectory(i18n.Directory); err != nil {
+		return err
+	}
+
+	db := dbstore.GetDataSource()
+	if orgID != 0 {
+		db, err = db.Tenant(orgID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Stops what's running on Ctrl+C, e.g. so a report being generated isn't left half written
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		cancel()
+	}()
+
+	return run(ctx, db, args)
+}
+
+// outputPath is where a path given to the command is, relative to where it was started rather than the plugin
+func outputPath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(workingDirectory, path)
+}
+
+func listSchedules(ctx context.Context, db *dbstore.SQLiteDatasource, args []string) error {
+	flags := flag.NewFlagSet("schedules", flag.ExitOnError)
+	flags.Parse(args)
+
+	schedules, err := db.GetSchedules()
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tNEXT REPORT\tFORMATS\tSTATUS")
+	for _, schedule := range schedules {
+		next := "-"
+		if schedule.NextReportTime > 0 {
+			next = time.Unix(int64(schedule.NextReportTime), 0).Format("2006-01-02 15:04")
+		}
+		formats := schedule.Formats
+		if formats == "" {
+			formats = "xlsx"
+		}
+		status := "active"
+		if schedule.DisabledAt > 0 {
+			status = "disabled: " + schedule.DisabledReason
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", schedule.ID, schedule.Name, next, formats, status)
+	}
+	return table.Flush()
+}
+
+// runSchedule queues a schedule's report as a test email would be, for the plugin to send. It's only queued here,
+// rather than generated, so it's sent in turn with the plugin's other reports.
+func runSchedule(ctx context.Context, db *dbstore.SQLiteDatasource, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	to := flags.String("to", "", "comma separated addresses to send to instead of the schedule's report group")
+	wait := flags.Bool("wait", false, "wait for the report to be sent, failing if it isn't")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("run takes the id of one schedule")
+	}
+
+	schedule, err := db.GetSchedule(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	payload := reportEmailer.TestPayload{To: []string{}}
+	for _, address := range strings.Split(*to, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if err := dbstore.CheckEmailSyntax(address); err != nil {
+			return fmt.Errorf("-to: %s %s", address, err.Error())
+		}
+		payload.To = append(payload.To, address)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	job, err := db.CreateJob(dbstore.Job{Kind: jobs.KindTest, ScheduleID: schedule.ID, ScheduleName: schedule.Name, Priority: schedule.Priority, Payload: string(payloadJSON), RequestedBy: "msupply-reports"})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Queued job %s to send '%s'\n", job.ID, schedule.Name)
+	if !*wait {
+		return nil
+	}
+
+	status := job.Status
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting, job %s is still %s", job.ID, status)
+		case <-time.After(pollInterval):
+		}
+
+		job, err = db.GetJob(job.ID)
+		if err != nil {
+			return err
+		}
+		if job.Status != status {
+			status = job.Status
+			fmt.Println(capitalise(status))
+		}
+
+		switch job.Status {
+		case dbstore.JobStatusSucceeded:
+			return nil
+		case dbstore.JobStatusFailed:
+			return errors.New(job.Message)
+		case dbstore.JobStatusCancelled:
+			return errors.New("the job was cancelled")
+		}
+	}
+}
+
+func exportBundle(ctx context.Context, db *dbstore.SQLiteDatasource, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the bundle to, standard output by default")
+	flags.Parse(args)
+
+	var scheduleIDs []string
+	if flags.NArg() > 0 {
+		scheduleIDs = flags.Args()
+	}
+	bundle, err := db.ExportBundle(scheduleIDs)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(outputPath(*output))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bundle)
+}
+
+// generateReport writes a schedule's report as a preview would, as if it were due now, then copies its files to
+// the output directory. No one is emailed and no run is recorded.
+func generateReport(ctx context.Context, db *dbstore.SQLiteDatasource, args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	output := flags.String("o", ".", "directory to write the report's files to")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("generate takes the id of one schedule")
+	}
+
+	schedule, err := db.GetSchedule(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	authConfig, err := auth.NewAuthConfig(db)
+	if err != nil {
+		return err
+	}
+	settings, err := db.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reportEmailer.ReportTimeout(settings))
+	defer cancel()
+	preview, err := reportEmailer.NewReportEmailer(db).PreviewReport(ctx, *schedule, authConfig, settings.DatasourceID)
+	if err != nil {
+		return err
+	}
+	for _, panel := range preview.FailedPanels {
+		fmt.Fprintln(os.Stderr, "Failed: "+panel)
+	}
+
+	directory := outputPath(*output)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	previewDirectory := reportEmailer.PreviewDirectory(schedule.ID, preview.ID)
+	defer os.RemoveAll(previewDirectory)
+	for _, name := range preview.Files {
+		path := filepath.Join(previewDirectory, name)
+		// Named for the schedule, as it's not being previewed
+		written := filepath.Join(directory, strings.Replace(name, reportEmailer.PreviewName(*schedule), schedule.Name, 1))
+		if err := copyFile(path, written); err != nil {
+			return err
+		}
+		fmt.Println(written)
+	}
+	return nil
+}
+
+func copyFile(from string, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// capitalise upper-cases the first letter of a job status for printing
+func capitalise(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
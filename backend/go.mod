@@ -14,6 +14,7 @@ require (
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
 	github.com/magefile/mage v1.10.0 // indirect
 	github.com/mattn/go-sqlite3 v1.14.4
+	github.com/prometheus/client_golang v1.3.0
 	github.com/robfig/cron v1.2.0
 	github.com/robfig/cron/v3 v3.0.0
 	golang.org/x/sys v0.0.0-20201110211018-35f3e6cf4a65 // indirect
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -15,12 +17,113 @@ type TemplateVariable struct {
 	Definition string `json:"definition"`
 	Name       string `json:"name"`
 	Type       string `json:"type"`
+	Label      string `json:"label"`
+	Multi      bool   `json:"multi"`
+	IncludeAll bool   `json:"includeAll"`
+	Current    struct {
+		Value interface{} `json:"value"`
+	} `json:"current"`
+	// Values the variable can be set to, as last loaded by Grafana for query variables
+	Options []struct {
+		Text  interface{} `json:"text"`
+		Value interface{} `json:"value"`
+	} `json:"options"`
+	// The datasource and query of query variables, the query being saved as a string or an object holding it
+	Datasource DatasourceRef `json:"datasource"`
+	Query      interface{}   `json:"query"`
+}
+
+// CurrentValues are the options selected on the dashboard when it was last saved.
+// Grafana stores a single string for single-value variables and a list otherwise.
+func (variable TemplateVariable) CurrentValues() []string {
+	var values []string
+	switch value := variable.Current.Value.(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		for _, option := range value {
+			if str, ok := option.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+
+	for _, value := range values {
+		// "All" can't be expanded without running the variable's query
+		if value == "$__all" {
+			return nil
+		}
+	}
+
+	return values
 }
 
 type TemplateList struct {
 	List []TemplateVariable `json:"list"`
 }
 
+// DashboardPanel is a panel of a dashboard as Grafana saves it
+type DashboardPanel struct {
+	Datasource  DatasourceRef `json:"datasource"`
+	FieldConfig struct {
+		Defaults struct {
+			Custom struct {
+				Align      interface{} `json:"align"`
+				Filterable bool        `json:"filterable"`
+			} `json:"custom"`
+			Mappings   []interface{} `json:"mappings"`
+			Thresholds struct {
+				Mode  string `json:"mode"`
+				Steps []struct {
+					Color string      `json:"color"`
+					Value interface{} `json:"value"`
+				} `json:"steps"`
+			} `json:"thresholds"`
+		} `json:"defaults"`
+		Overrides []interface{} `json:"overrides"`
+	} `json:"fieldConfig"`
+	GridPos struct {
+		H int `json:"h"`
+		W int `json:"w"`
+		X int `json:"x"`
+		Y int `json:"y"`
+	} `json:"gridPos"`
+	ID      int `json:"id"`
+	Options struct {
+		ShowHeader bool `json:"showHeader"`
+		SortBy     []struct {
+			Desc        bool   `json:"desc"`
+			DisplayName string `json:"displayName"`
+		} `json:"sortBy"`
+	} `json:"options"`
+	PluginVersion string `json:"pluginVersion"`
+	Targets       []struct {
+		Datasource   DatasourceRef `json:"datasource"`
+		Format       string        `json:"format"`
+		Group        []interface{} `json:"group"`
+		MetricColumn string        `json:"metricColumn"`
+		RawQuery     bool          `json:"rawQuery"`
+		RawSQL       string        `json:"rawSql"`
+		RefID        string        `json:"refId"`
+		Select       [][]struct {
+			Params []string `json:"params"`
+			Type   string   `json:"type"`
+		} `json:"select"`
+		Table          string        `json:"table"`
+		TimeColumn     string        `json:"timeColumn"`
+		TimeColumnType string        `json:"timeColumnType"`
+		Where          []interface{} `json:"where"`
+	} `json:"targets"`
+	TimeFrom        interface{}   `json:"timeFrom"`
+	TimeShift       interface{}   `json:"timeShift"`
+	Title           string        `json:"title"`
+	Transformations []interface{} `json:"transformations"`
+	Type            string        `json:"type"`
+	// Set on a row, which keeps its panels while it's collapsed rather than the dashboard
+	Collapsed bool             `json:"collapsed"`
+	Panels    []DashboardPanel `json:"panels"`
+}
+
 type DashboardResponse struct {
 	Meta struct {
 		Type                  string    `json:"type"`
@@ -47,80 +150,25 @@ type DashboardResponse struct {
 	Dashboard struct {
 		Annotations struct {
 			List []struct {
-				BuiltIn    int    `json:"builtIn"`
-				Datasource string `json:"datasource"`
-				Enable     bool   `json:"enable"`
-				Hide       bool   `json:"hide"`
-				IconColor  string `json:"iconColor"`
-				Name       string `json:"name"`
-				Type       string `json:"type"`
+				BuiltIn    int           `json:"builtIn"`
+				Datasource DatasourceRef `json:"datasource"`
+				Enable     bool          `json:"enable"`
+				Hide       bool          `json:"hide"`
+				IconColor  string        `json:"iconColor"`
+				Name       string        `json:"name"`
+				Type       string        `json:"type"`
 			} `json:"list"`
 		} `json:"annotations"`
-		Editable     bool          `json:"editable"`
-		GnetID       interface{}   `json:"gnetId"`
-		GraphTooltip int           `json:"graphTooltip"`
-		ID           int           `json:"id"`
-		Links        []interface{} `json:"links"`
-		Panels       []struct {
-			Datasource  string `json:"datasource"`
-			FieldConfig struct {
-				Defaults struct {
-					Custom struct {
-						Align      interface{} `json:"align"`
-						Filterable bool        `json:"filterable"`
-					} `json:"custom"`
-					Mappings   []interface{} `json:"mappings"`
-					Thresholds struct {
-						Mode  string `json:"mode"`
-						Steps []struct {
-							Color string      `json:"color"`
-							Value interface{} `json:"value"`
-						} `json:"steps"`
-					} `json:"thresholds"`
-				} `json:"defaults"`
-				Overrides []interface{} `json:"overrides"`
-			} `json:"fieldConfig"`
-			GridPos struct {
-				H int `json:"h"`
-				W int `json:"w"`
-				X int `json:"x"`
-				Y int `json:"y"`
-			} `json:"gridPos"`
-			ID      int `json:"id"`
-			Options struct {
-				ShowHeader bool `json:"showHeader"`
-				SortBy     []struct {
-					Desc        bool   `json:"desc"`
-					DisplayName string `json:"displayName"`
-				} `json:"sortBy"`
-			} `json:"options"`
-			PluginVersion string `json:"pluginVersion"`
-			Targets       []struct {
-				Format       string        `json:"format"`
-				Group        []interface{} `json:"group"`
-				MetricColumn string        `json:"metricColumn"`
-				RawQuery     bool          `json:"rawQuery"`
-				RawSQL       string        `json:"rawSql"`
-				RefID        string        `json:"refId"`
-				Select       [][]struct {
-					Params []string `json:"params"`
-					Type   string   `json:"type"`
-				} `json:"select"`
-				Table          string        `json:"table"`
-				TimeColumn     string        `json:"timeColumn"`
-				TimeColumnType string        `json:"timeColumnType"`
-				Where          []interface{} `json:"where"`
-			} `json:"targets"`
-			TimeFrom        interface{}   `json:"timeFrom"`
-			TimeShift       interface{}   `json:"timeShift"`
-			Title           string        `json:"title"`
-			Transformations []interface{} `json:"transformations"`
-			Type            string        `json:"type"`
-		} `json:"panels"`
-		SchemaVersion int           `json:"schemaVersion"`
-		Style         string        `json:"style"`
-		Tags          []interface{} `json:"tags"`
-		Templating    TemplateList  `json:"templating"`
+		Editable      bool             `json:"editable"`
+		GnetID        interface{}      `json:"gnetId"`
+		GraphTooltip  int              `json:"graphTooltip"`
+		ID            int              `json:"id"`
+		Links         []interface{}    `json:"links"`
+		Panels        []DashboardPanel `json:"panels"`
+		SchemaVersion int              `json:"schemaVersion"`
+		Style         string           `json:"style"`
+		Tags          []interface{}    `json:"tags"`
+		Templating    TemplateList     `json:"templating"`
 		Time          struct {
 			From string `json:"from"`
 			To   string `json:"to"`
@@ -136,6 +184,8 @@ type DashboardResponse struct {
 
 type Dashboard struct {
 	Panels    []TablePanel `json:"panels"`
+	// Every panel but rows in the dashboard's order, the table panels as they are in Panels and the others image only
+	Layout    []TablePanel `json:"-"`
 	UID       string       `json:"uid"`
 	Variables TemplateList `json:"templating"`
 }
@@ -162,30 +212,73 @@ func NewDashboardResponse(response *http.Response) (*DashboardResponse, error) {
 	return &dashboardResponse, err
 }
 
-func NewDashboard(authConfig *auth.AuthConfig, uuid string, from string, to string, datasourceID int) (*Dashboard, error) {
-	url := authConfig.AuthURL() + "/api/dashboards/uid/" + uuid
-	response, err := http.Get(url)
+// NewDashboard loads the table panels of a dashboard, each querying the datasource it uses on the dashboard or
+// datasourceID when it uses the default
+func NewDashboard(ctx context.Context, authConfig *auth.AuthConfig, uuid string, from string, to string, datasourceID int) (*Dashboard, error) {
+	return newDashboard(ctx, authConfig, uuid, from, to, datasourceID, true)
+}
+
+// NewDashboardUsing loads the table panels of a dashboard, all querying datasourceID whichever they use on it
+func NewDashboardUsing(ctx context.Context, authConfig *auth.AuthConfig, uuid string, from string, to string, datasourceID int) (*Dashboard, error) {
+	return newDashboard(ctx, authConfig, uuid, from, to, datasourceID, false)
+}
+
+func newDashboard(ctx context.Context, authConfig *auth.AuthConfig, uuid string, from string, to string, datasourceID int, detectDatasources bool) (*Dashboard, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/dashboards/uid/"+uuid)
 	if err != nil {
 		log.DefaultLogger.Error("NewDashboard: HTTP Request %s", err.Error())
 		return nil, err
 	}
 
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrDashboardNotFound, uuid)
+	}
+
 	dashboardResponse, err := NewDashboardResponse(response)
 	if err != nil {
 		log.DefaultLogger.Error("NewDashboard: NewDashboardResponse", err.Error())
 		return nil, err
 	}
 
-	var panels []TablePanel
-	for _, panel := range dashboardResponse.Dashboard.Panels {
+	var panels, layout []TablePanel
+	datasources := newDatasourceResolver(authConfig, dashboardResponse.Dashboard.Templating, datasourceID)
+	for _, panel := range dashboardResponse.panels() {
 		if panel.Type == "table" || panel.Type == "msupplyfoundation-table" {
-			newPanel := NewTablePanel(panel.ID, panel.Title, panel.Targets[0].RawSQL, from, to, datasourceID)
+			// Panels mixing datasources save the one each query uses on the query instead
+			ref := panel.Datasource
+			if ref.IsDefault() {
+				ref = panel.Targets[0].Datasource
+			}
+			// A panel whose datasource can't be found, e.g. as it has since been deleted, queries the
+			// schedule's datasource rather than failing the rest of the dashboard
+			panelDatasourceID := datasourceID
+			if detectDatasources {
+				resolved, err := datasources.resolve(ctx, ref)
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if err != nil {
+					log.DefaultLogger.Warn(fmt.Sprintf("NewDashboard: datasources.resolve: panel %d of %s uses datasource %d instead: %s", panel.ID, uuid, datasourceID, err.Error()))
+				} else {
+					panelDatasourceID = resolved
+				}
+			}
+
+			newPanel := NewTablePanel(panel.ID, panel.Title, panel.Targets[0].RawSQL, from, to, panelDatasourceID)
+			newPanel.DashboardUID = dashboardResponse.Dashboard.UID
 			panels = append(panels, *newPanel)
+			layout = append(layout, *newPanel)
+		} else {
+			imagePanel := NewTablePanel(panel.ID, panel.Title, "", from, to, datasourceID)
+			imagePanel.DashboardUID = dashboardResponse.Dashboard.UID
+			imagePanel.ImageOnly = true
+			layout = append(layout, *imagePanel)
 		}
 	}
 
 
-	return &Dashboard{UID: dashboardResponse.Dashboard.UID, Panels: panels, Variables: dashboardResponse.Dashboard.Templating}, nil
+	return &Dashboard{UID: dashboardResponse.Dashboard.UID, Panels: panels, Layout: layout, Variables: dashboardResponse.Dashboard.Templating}, nil
 }
 
 func (dashboard *Dashboard) Panel(panelID int) *TablePanel {
@@ -202,11 +295,64 @@ func (dashboard *Dashboard) Panel(panelID int) *TablePanel {
 	return nil
 }
 
-func (resp *DashboardResponse) GetRawSQL(panelID int) string {
+// panels is every panel of the dashboard but its rows, in order, including those in collapsed rows which Grafana
+// keeps on the row rather than the dashboard
+func (resp *DashboardResponse) panels() []DashboardPanel {
+	var panels []DashboardPanel
 	for _, panel := range resp.Dashboard.Panels {
+		if panel.Type != "row" {
+			panels = append(panels, panel)
+			continue
+		}
+		for _, nested := range panel.Panels {
+			if nested.Type != "row" {
+				panels = append(panels, nested)
+			}
+		}
+	}
+	return panels
+}
+
+func (resp *DashboardResponse) GetRawSQL(panelID int) string {
+	for _, panel := range resp.panels() {
 		if panel.ID == panelID {
 			return panel.Targets[0].RawSQL
 		}
 	}
 	return ""
 }
+
+var ErrDashboardNotFound = errors.New("Dashboard not found")
+
+// PanelSummary identifies a panel of any type on a dashboard
+type PanelSummary struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	Type  string `json:"type"`
+}
+
+func GetDashboardPanels(ctx context.Context, authConfig *auth.AuthConfig, uid string) ([]PanelSummary, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/dashboards/uid/"+uid)
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardPanels: HTTP Request: " + err.Error())
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrDashboardNotFound
+	}
+
+	dashboardResponse, err := NewDashboardResponse(response)
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardPanels: NewDashboardResponse: " + err.Error())
+		return nil, err
+	}
+
+	panels := []PanelSummary{}
+	for _, panel := range dashboardResponse.panels() {
+		panels = append(panels, PanelSummary{ID: panel.ID, Title: panel.Title, Type: panel.Type})
+	}
+
+	return panels, nil
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// dashboardGrafana has a stock dashboard with a table panel querying the stock datasource (id 12), another
+// querying a datasource which has since been deleted and a chart between them under a row
+func dashboardGrafana(t *testing.T) *auth.AuthConfig {
+	dashboard := map[string]interface{}{
+		"dashboard": map[string]interface{}{
+			"uid": "stock",
+			"panels": []interface{}{
+				map[string]interface{}{"id": 1, "type": "table", "title": "Stock", "datasource": map[string]string{"uid": "stock-db"}, "targets": []interface{}{map[string]string{"rawSql": "SELECT * FROM stock"}}},
+				map[string]interface{}{"id": 4, "type": "row", "title": "Trends"},
+				map[string]interface{}{"id": 3, "type": "timeseries", "title": "Consumption", "datasource": map[string]string{"uid": "stock-db"}, "targets": []interface{}{map[string]string{"rawSql": "SELECT * FROM consumption"}}},
+				map[string]interface{}{"id": 2, "type": "table", "title": "Expiries", "datasource": map[string]string{"uid": "deleted-db"}, "targets": []interface{}{map[string]string{"rawSql": "SELECT * FROM expiry"}}},
+			},
+		},
+	}
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/api/dashboards/uid/stock":
+			json.NewEncoder(rw).Encode(dashboard)
+		case "/api/datasources/uid/stock-db":
+			json.NewEncoder(rw).Encode(map[string]interface{}{"id": 12, "uid": "stock-db"})
+		default:
+			http.NotFound(rw, request)
+		}
+	}))
+	t.Cleanup(grafana.Close)
+	return &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+}
+
+func TestDashboardPanelWithMissingDatasourceUsesSchedules(t *testing.T) {
+	authConfig := dashboardGrafana(t)
+
+	dashboard, err := newDashboard(context.Background(), authConfig, "stock", "now-7d", "now", 5, true)
+	if err != nil {
+		t.Fatalf("expected the dashboard despite one panel's datasource being missing, got %v", err)
+	}
+	if len(dashboard.Panels) != 2 {
+		t.Fatalf("expected both table panels, got %d", len(dashboard.Panels))
+	}
+	if id := dashboard.Panel(1).DatasourceID; id != 12 {
+		t.Errorf("expected the stock panel to query its own datasource, 12, got %d", id)
+	}
+	if id := dashboard.Panel(2).DatasourceID; id != 5 {
+		t.Errorf("expected the panel whose datasource is missing to query the schedule's, 5, got %d", id)
+	}
+}
+
+func TestDashboardLayoutIncludesPanelsOtherThanTables(t *testing.T) {
+	authConfig := dashboardGrafana(t)
+
+	dashboard, err := newDashboard(context.Background(), authConfig, "stock", "now-7d", "now", 5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dashboard.Panel(3) != nil {
+		t.Error("expected the chart not to be a panel which can be queried")
+	}
+
+	if len(dashboard.Layout) != 3 {
+		t.Fatalf("expected every panel but the row, got %d", len(dashboard.Layout))
+	}
+	for i, id := range []int{1, 3, 2} {
+		panel := dashboard.Layout[i]
+		if panel.ID != id {
+			t.Errorf("expected panel %d in the dashboard's order, got %d", id, panel.ID)
+		}
+		if panel.ImageOnly != (id == 3) {
+			t.Errorf("expected only the chart to be included as an image, panel %d is %v", id, panel.ImageOnly)
+		}
+	}
+	if chart := dashboard.Layout[1]; chart.RawSql != "" || chart.DashboardUID != "stock" || chart.Title != "Consumption" {
+		t.Errorf("expected the chart to be rendered from the dashboard without its query, got %+v", chart)
+	}
+}
+
+func TestDashboardPanelsSkipRowsAndIncludeCollapsedRowsPanels(t *testing.T) {
+	dashboard := map[string]interface{}{
+		"dashboard": map[string]interface{}{
+			"uid": "stock",
+			"panels": []interface{}{
+				map[string]interface{}{"id": 1, "type": "table", "title": "Stock"},
+				map[string]interface{}{"id": 2, "type": "row", "title": "Trends", "collapsed": true, "panels": []interface{}{
+					map[string]interface{}{"id": 3, "type": "timeseries", "title": "Consumption"},
+				}},
+				map[string]interface{}{"id": 4, "type": "row", "title": "Expiries", "collapsed": false, "panels": []interface{}{}},
+				map[string]interface{}{"id": 5, "type": "table", "title": "Expiring soon"},
+			},
+		},
+	}
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(rw).Encode(dashboard)
+	}))
+	defer grafana.Close()
+
+	panels, err := GetDashboardPanels(context.Background(), &auth.AuthConfig{URL: grafana.URL, Mode: dbstore.AuthModeBasic}, "stock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 3, 5}
+	if len(panels) != len(want) {
+		t.Fatalf("expected panels %v without the rows, got %+v", want, panels)
+	}
+	for i, id := range want {
+		if panels[i].ID != id {
+			t.Errorf("expected panel %d in the dashboard's order, got %d", id, panels[i].ID)
+		}
+	}
+}
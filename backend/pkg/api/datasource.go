@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// DatasourceRef is the datasource a panel queries, saved by Grafana as its name before 8.3 and as its uid since
+type DatasourceRef struct {
+	UID  string `json:"uid"`
+	Type string `json:"type"`
+	Name string `json:"-"`
+}
+
+func (ref *DatasourceRef) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		ref.Name = name
+		return nil
+	}
+
+	type datasourceRef DatasourceRef
+	return json.Unmarshal(data, (*datasourceRef)(ref))
+}
+
+// Names and uids Grafana saves for panels without a datasource of their own
+var defaultDatasources = map[string]bool{"": true, "default": true, "-- Grafana --": true, "grafana": true, "-- Mixed --": true, "-- Dashboard --": true}
+
+// IsDefault is whether the panel uses the dashboard's default datasource rather than one of its own
+func (ref DatasourceRef) IsDefault() bool {
+	return defaultDatasources[ref.UID] && defaultDatasources[ref.Name]
+}
+
+func (ref DatasourceRef) String() string {
+	if ref.UID != "" {
+		return ref.UID
+	}
+	return ref.Name
+}
+
+type grafanaDatasource struct {
+	ID int `json:"id"`
+}
+
+// GetDatasourceID finds the id of a datasource by its uid, or its name when it has none
+func GetDatasourceID(ctx context.Context, authConfig *auth.AuthConfig, ref DatasourceRef) (int, error) {
+	path := "/api/datasources/name/" + url.PathEscape(ref.Name)
+	if ref.UID != "" {
+		path = "/api/datasources/uid/" + url.PathEscape(ref.UID)
+	}
+
+	response, err := authConfig.GetWithContext(ctx, path)
+	if err != nil {
+		log.DefaultLogger.Error("GetDatasourceID: HTTP Request: " + err.Error())
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("looking up datasource %s returned %d", ref.String(), response.StatusCode)
+	}
+
+	var datasource grafanaDatasource
+	err = json.NewDecoder(response.Body).Decode(&datasource)
+	if err != nil {
+		log.DefaultLogger.Error("GetDatasourceID: json.Decode(): " + err.Error())
+		return 0, err
+	}
+
+	return datasource.ID, nil
+}
+
+// datasourceResolver finds the datasource each panel of a dashboard queries, looking each up in Grafana once
+type datasourceResolver struct {
+	authConfig *auth.AuthConfig
+	variables  TemplateList
+	// Used by panels without a datasource of their own
+	fallback int
+	ids      map[string]int
+}
+
+func newDatasourceResolver(authConfig *auth.AuthConfig, variables TemplateList, fallback int) *datasourceResolver {
+	return &datasourceResolver{authConfig: authConfig, variables: variables, fallback: fallback, ids: make(map[string]int)}
+}
+
+// resolve is the id of the datasource a panel uses. A datasource variable, e.g. $country, uses the option
+// selected when the dashboard was saved, which can be its name or uid.
+func (resolver *datasourceResolver) resolve(ctx context.Context, ref DatasourceRef) (int, error) {
+	if ref.IsDefault() {
+		return resolver.fallback, nil
+	}
+
+	key := ref.String()
+	if id, ok := resolver.ids[key]; ok {
+		return id, nil
+	}
+
+	if name := variableName(key); name != "" {
+		value := ""
+		for _, variable := range resolver.variables.List {
+			if variable.Name == name && variable.Type == "datasource" {
+				if values := variable.CurrentValues(); len(values) > 0 {
+					value = values[0]
+				}
+			}
+		}
+		if value == "" || defaultDatasources[value] {
+			resolver.ids[key] = resolver.fallback
+			return resolver.fallback, nil
+		}
+
+		id, err := GetDatasourceID(ctx, resolver.authConfig, DatasourceRef{Name: value})
+		if err != nil {
+			id, err = GetDatasourceID(ctx, resolver.authConfig, DatasourceRef{UID: value})
+		}
+		if err != nil {
+			return 0, err
+		}
+		resolver.ids[key] = id
+		return id, nil
+	}
+
+	id, err := GetDatasourceID(ctx, resolver.authConfig, ref)
+	if err != nil {
+		return 0, err
+	}
+	resolver.ids[key] = id
+	return id, nil
+}
+
+// variableName is the name of the variable a datasource refers to, e.g. country for $country or ${country}
+func variableName(datasource string) string {
+	if !strings.HasPrefix(datasource, "$") {
+		return ""
+	}
+	return strings.Trim(strings.TrimPrefix(datasource, "$"), "{}")
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// panelFingerprint is everything which decides what a panel's image looks like, other than its period
+type panelFingerprint struct {
+	DashboardUID     string          `json:"dashboardUID"`
+	PanelID          int             `json:"panelID"`
+	ContentVariables string          `json:"contentVariables"`
+	Options          *RenderOptions  `json:"options"`
+	Columns          []Column        `json:"columns"`
+	Rows             [][]interface{} `json:"rows"`
+}
+
+// Fingerprint is a hash of the panel's data and how it's rendered, which is the same for as long as its image would
+// look the same but for its time axis. The panel's data must have been fetched.
+func (panel *TablePanel) Fingerprint() (string, error) {
+	content, err := json.Marshal(panelFingerprint{
+		DashboardUID:     panel.DashboardUID,
+		PanelID:          panel.ID,
+		ContentVariables: panel.ContentVariables,
+		Options:          panel.RenderOptions,
+		Columns:          panel.Columns,
+		Rows:             panel.Rows,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:]), nil
+}
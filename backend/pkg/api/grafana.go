@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// How long a run waits for Grafana to come back while it is starting or restarting, before it is
+// queued for the next pass of the scheduler
+const GrafanaRestartWindow = 2 * time.Minute
+
+// How often Grafana is checked while waiting for it
+const grafanaPollInterval = 5 * time.Second
+
+// Time allowed for a single check of Grafana's health
+const grafanaCheckTimeout = 5 * time.Second
+
+var ErrGrafanaUnavailable = errors.New("grafana is unavailable")
+
+// GrafanaAvailable checks Grafana's HTTP API is answering. Only failures which mean Grafana is down or
+// still starting count as unavailable, anything else is left to the request which runs into it.
+func GrafanaAvailable(ctx context.Context, authConfig *auth.AuthConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, grafanaCheckTimeout)
+	defer cancel()
+
+	response, err := authConfig.GetWithContext(ctx, "/api/health")
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrGrafanaUnavailable, err.Error())
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: /api/health returned %d", ErrGrafanaUnavailable, response.StatusCode)
+	}
+
+	return nil
+}
+
+// WaitForGrafana returns once Grafana is available, or ErrGrafanaUnavailable when it still isn't after the window
+func WaitForGrafana(ctx context.Context, authConfig *auth.AuthConfig, window time.Duration) error {
+	err := GrafanaAvailable(ctx, authConfig)
+	if err == nil {
+		return nil
+	}
+
+	log.DefaultLogger.Warn(fmt.Sprintf("WaitForGrafana: %s, waiting up to %s for it to come back", err.Error(), window))
+	deadline := time.Now().Add(window)
+	ticker := time.NewTicker(grafanaPollInterval)
+	defer ticker.Stop()
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", ErrGrafanaUnavailable, ctx.Err().Error())
+		case <-ticker.C:
+		}
+
+		err = GrafanaAvailable(ctx, authConfig)
+		if err == nil {
+			log.DefaultLogger.Info("WaitForGrafana: Grafana is available again")
+			return nil
+		}
+	}
+
+	log.DefaultLogger.Error("WaitForGrafana: " + err.Error())
+	return err
+}
@@ -1,13 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
-	"net/http"
 	"regexp"
 	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
 )
 
 type Column struct {
@@ -24,12 +26,44 @@ type TablePanel struct {
 	Columns      []Column        `json:"columns"`
 	Variables    TemplateList    `json:"variables"`
 	DatasourceID int             `json:"DatasourceID"`
+	DashboardUID string          `json:"dashboardUID"`
+	// Selected variables of the report content, as JSON
+	ContentVariables string `json:"contentVariables"`
+	// When set, an image of the panel is included alongside its data
+	RenderOptions *RenderOptions `json:"renderOptions"`
+	Image         []byte         `json:"-"`
+	// When set, a native Excel chart of the panel's data is added to its sheet
+	ChartType string `json:"chartType"`
+	// Masks identifying data in what's logged about the panel's query, nothing is masked when nil
+	Masker *dbstore.Masker `json:"-"`
+	// Seconds allowed for the panel's query and render, 0 uses the report's timeout
+	QueryTimeout int `json:"-"`
+	// Heading of the section of the report the panel starts, empty when it carries on the section before
+	Section string `json:"-"`
+	// Markdown shown in place of a panel, for text content, which has nothing to query or render
+	Text string `json:"-"`
+	// Set for a whole dashboard's panels other than tables, e.g. charts, which have no query the report can run
+	// and are only included as images
+	ImageOnly bool `json:"-"`
+	// When set, variables the content selects no options of use those selected on the dashboard, as whole
+	// dashboard content shows it as it's saved. Otherwise they're left empty as they always have been.
+	UseDashboardValues bool `json:"-"`
 }
 
 func NewTablePanel(id int, title string, rawSql string, from string, to string, datasourceID int) *TablePanel {
 	return &TablePanel{ID: id, Title: title, RawSql: rawSql, From: from, To: to, DatasourceID: datasourceID}
 }
 
+// NewTextPanel is text content as a panel of the report, in its place among the others
+func NewTextPanel(text string) *TablePanel {
+	return &TablePanel{Text: text}
+}
+
+// IsText is set for text content, which is written into the report as it is rather than fetched
+func (panel *TablePanel) IsText() bool {
+	return panel.Text != ""
+}
+
 func (panel *TablePanel) usesVariable(variable TemplateVariable) bool {
 	return strings.Contains(panel.RawSql, "${"+variable.Name+"}") || strings.Contains(panel.RawSql, "${"+variable.Name+":sqlstring}")
 }
@@ -62,7 +96,7 @@ func (panel *TablePanel) injectMacros() {
 		panel.RawSql = timeFilter.ReplaceAllString(panel.RawSql, newSql)
 	}
 
-	log.DefaultLogger.Info("RawSQL After Injecting Macros (" + panel.Title + "):" + panel.RawSql)
+	log.DefaultLogger.Info("RawSQL After Injecting Macros (" + panel.Title + "):" + panel.Masker.Text(panel.RawSql))
 }
 
 func formatSqlString(vars []string) string {
@@ -120,6 +154,9 @@ func (panel *TablePanel) injectVariable(variable TemplateVariable, contentVariab
 	}
 
 	variableOptions := panel.GetSelectedVariableOptions(variable.Name, contentVariables)
+	if len(variableOptions) == 0 && panel.UseDashboardValues {
+		variableOptions = variable.CurrentValues()
+	}
 
 	format := "default"
 	if strings.Contains(panel.RawSql, "${"+variable.Name+":sqlstring}") {
@@ -130,11 +167,12 @@ func (panel *TablePanel) injectVariable(variable TemplateVariable, contentVariab
 	panel.RawSql = strings.Replace(panel.RawSql, "${"+variable.Name+"}", formatted, -1)
 	panel.RawSql = strings.Replace(panel.RawSql, "${"+variable.Name+":sqlstring}", formatted, -1)
 
-	log.DefaultLogger.Info("RawSQL For Panel (" + panel.Title + "):" + panel.RawSql)
+	log.DefaultLogger.Info("RawSQL For Panel (" + panel.Title + "):" + panel.Masker.Text(panel.RawSql))
 }
 
 func (panel *TablePanel) PrepSql(variables TemplateList, contentVariables string) {
-	log.DefaultLogger.Info(contentVariables)
+	log.DefaultLogger.Info(panel.Masker.Text(contentVariables))
+	panel.ContentVariables = contentVariables
 	for _, variable := range variables.List {
 		if panel.usesVariable(variable) {
 			panel.injectVariable(variable, contentVariables)
@@ -143,7 +181,7 @@ func (panel *TablePanel) PrepSql(variables TemplateList, contentVariables string
 	}
 }
 
-func (panel *TablePanel) GetData(authConfig auth.AuthConfig) error {
+func (panel *TablePanel) GetData(ctx context.Context, authConfig auth.AuthConfig) error {
 	log.DefaultLogger.Debug("Panel.GetData");
 	body, err := NewQueryRequest(panel.RawSql, panel.From, panel.To, panel.DatasourceID).ToRequestBody()
 	if err != nil {
@@ -151,8 +189,12 @@ func (panel *TablePanel) GetData(authConfig auth.AuthConfig) error {
 		return err
 	}
 
-	url := authConfig.AuthURL() + "/api/tsdb/query"
-	response, err := http.Post(url, "application/json", body)
+	if err := chaos.Query(ctx); err != nil {
+		log.DefaultLogger.Error("GetData: chaos.Query: " + err.Error())
+		return err
+	}
+
+	response, err := authConfig.PostWithContext(ctx, "/api/tsdb/query", "application/json", body)
 	if err != nil {
 		log.DefaultLogger.Error("GetData: http.Post: " + err.Error())
 		return err
@@ -170,6 +212,26 @@ func (panel *TablePanel) GetData(authConfig auth.AuthConfig) error {
 	return nil
 }
 
+// GetImage renders the panel with the renderer given, or Grafana's when it's nil
+func (panel *TablePanel) GetImage(ctx context.Context, authConfig auth.AuthConfig, renderer Renderer) error {
+	if panel.RenderOptions == nil {
+		return nil
+	}
+	if renderer == nil {
+		renderer = grafanaRenderer{}
+	}
+
+	request := RenderRequest{DashboardUID: panel.DashboardUID, PanelID: panel.ID, From: panel.From, To: panel.To, ContentVariables: panel.ContentVariables, Options: *panel.RenderOptions}
+	image, err := renderer.Render(ctx, &authConfig, request)
+	if err != nil {
+		log.DefaultLogger.Error("GetImage: renderer.Render: " + err.Error())
+		return err
+	}
+
+	panel.Image = image
+	return nil
+}
+
 func (panel *TablePanel) SetRows(rows [][]interface{}) {
 	panel.Rows = rows
 }
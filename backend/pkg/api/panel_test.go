@@ -0,0 +1,42 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func storeVariable(t *testing.T) TemplateList {
+	t.Helper()
+	var variables TemplateList
+	err := json.Unmarshal([]byte(`{"list": [{"name": "store", "type": "custom", "current": {"value": ["Central"]}}]}`), &variables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return variables
+}
+
+func TestPanelsOnlyUseDashboardValuesWhenSet(t *testing.T) {
+	query := "SELECT * FROM stock WHERE store IN (${store:sqlstring})"
+
+	// Panel content has always queried what it selects, nothing when it selects nothing
+	panel := NewTablePanel(1, "Stock", query, "now-7d", "now", 5)
+	panel.PrepSql(storeVariable(t), "{}")
+	if panel.RawSql != "SELECT * FROM stock WHERE store IN ()" {
+		t.Errorf("expected the query of panel content not to change, got %q", panel.RawSql)
+	}
+
+	panel = NewTablePanel(1, "Stock", query, "now-7d", "now", 5)
+	panel.UseDashboardValues = true
+	panel.PrepSql(storeVariable(t), "{}")
+	if panel.RawSql != "SELECT * FROM stock WHERE store IN ('Central')" {
+		t.Errorf("expected the dashboard's selection, got %q", panel.RawSql)
+	}
+
+	// What the content selects wins either way
+	panel = NewTablePanel(1, "Stock", query, "now-7d", "now", 5)
+	panel.UseDashboardValues = true
+	panel.PrepSql(storeVariable(t), `{"store": ["North"]}`)
+	if panel.RawSql != "SELECT * FROM stock WHERE store IN ('North')" {
+		t.Errorf("expected the content's selection, got %q", panel.RawSql)
+	}
+}
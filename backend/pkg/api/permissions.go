@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// Grafana's lowest permission level on a dashboard, edit and admin including it
+const dashboardPermissionView = 1
+
+// Grafana's organisation roles, each seeing what those below it can
+var orgRoleLevels = map[string]int{"Viewer": 1, "Editor": 2, "Admin": 3}
+
+var ErrDashboardForbidden = errors.New("doesn't have permission to view dashboard")
+
+// dashboardPermission is an entry of a dashboard's access list, including those it inherits from its folder. It
+// applies to a user, a team or everyone with an organisation role.
+type dashboardPermission struct {
+	UserID     int    `json:"userId"`
+	TeamID     int    `json:"teamId"`
+	Role       string `json:"role"`
+	Permission int    `json:"permission"`
+}
+
+type orgUser struct {
+	UserID int    `json:"userId"`
+	Login  string `json:"login"`
+	Role   string `json:"role"`
+}
+
+type orgUserSearch struct {
+	OrgUsers []orgUser `json:"orgUsers"`
+}
+
+// lookupOrgUser finds a user of the organisation by login, with their role in it
+func lookupOrgUser(ctx context.Context, authConfig *auth.AuthConfig, login string) (*orgUser, error) {
+	var search orgUserSearch
+	err := getJSON(ctx, authConfig, "/api/org/users/search?perpage=100&query="+url.QueryEscape(login), &search)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range search.OrgUsers {
+		if user.Login == login {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("%s isn't a user of the Grafana organisation", login)
+}
+
+// CheckDashboardAccess checks a Grafana user can view a dashboard, as the plugin fetches every dashboard with its own
+// Grafana user whatever the report's author can see. role is the user's organisation role, looked up when empty. It
+// returns ErrDashboardForbidden when they can't, and other errors when their access couldn't be checked, e.g. as
+// the plugin's Grafana user isn't an admin able to read permissions.
+func CheckDashboardAccess(ctx context.Context, authConfig *auth.AuthConfig, login string, role string, uid string) error {
+	user, err := lookupOrgUser(ctx, authConfig, login)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		role = user.Role
+	}
+	// Admins can see every dashboard of the organisation
+	if orgRoleLevels[role] >= orgRoleLevels["Admin"] {
+		return nil
+	}
+
+	var permissions []dashboardPermission
+	err = getJSON(ctx, authConfig, "/api/dashboards/uid/"+url.PathEscape(uid)+"/permissions", &permissions)
+	if err != nil {
+		return err
+	}
+
+	var teams map[int]bool
+	for _, permission := range permissions {
+		if permission.Permission < dashboardPermissionView {
+			continue
+		}
+		if permission.UserID > 0 && permission.UserID == user.UserID {
+			return nil
+		}
+		if permission.Role != "" && orgRoleLevels[role] >= orgRoleLevels[permission.Role] {
+			return nil
+		}
+		if permission.TeamID > 0 {
+			if teams == nil {
+				teams, err = userTeamIDs(ctx, authConfig, user.UserID)
+				if err != nil {
+					return err
+				}
+			}
+			if teams[permission.TeamID] {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%s %w %s", login, ErrDashboardForbidden, uid)
+}
+
+func userTeamIDs(ctx context.Context, authConfig *auth.AuthConfig, userID int) (map[int]bool, error) {
+	var teams []grafanaTeam
+	err := getJSON(ctx, authConfig, fmt.Sprintf("/api/users/%d/teams", userID), &teams)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int]bool)
+	for _, team := range teams {
+		ids[team.ID] = true
+	}
+	return ids, nil
+}
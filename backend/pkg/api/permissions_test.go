@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// permissionsGrafana has alice (user 2, in team 7) and bob (user 3), and a stock dashboard viewable by team 7.
+// Reading permissions answers with the given status, e.g. 403 when the plugin's user isn't an admin.
+func permissionsGrafana(t *testing.T, permissionsStatus int) *auth.AuthConfig {
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/api/org/users/search":
+			json.NewEncoder(rw).Encode(orgUserSearch{OrgUsers: []orgUser{{UserID: 2, Login: "alice", Role: "Viewer"}, {UserID: 3, Login: "bob", Role: "Viewer"}}})
+		case "/api/dashboards/uid/stock/permissions":
+			if permissionsStatus != http.StatusOK {
+				http.Error(rw, http.StatusText(permissionsStatus), permissionsStatus)
+				return
+			}
+			json.NewEncoder(rw).Encode([]dashboardPermission{{TeamID: 7, Permission: dashboardPermissionView}})
+		case "/api/users/2/teams":
+			json.NewEncoder(rw).Encode([]grafanaTeam{{ID: 7, Name: "Pharmacy"}})
+		case "/api/users/3/teams":
+			json.NewEncoder(rw).Encode([]grafanaTeam{})
+		default:
+			http.NotFound(rw, request)
+		}
+	}))
+	t.Cleanup(grafana.Close)
+	return &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+}
+
+func TestCheckDashboardAccessThroughTeam(t *testing.T) {
+	authConfig := permissionsGrafana(t, http.StatusOK)
+
+	if err := CheckDashboardAccess(context.Background(), authConfig, "alice", "", "stock"); err != nil {
+		t.Errorf("expected a member of a team with access to view the dashboard, got %v", err)
+	}
+	err := CheckDashboardAccess(context.Background(), authConfig, "bob", "", "stock")
+	if !errors.Is(err, ErrDashboardForbidden) {
+		t.Errorf("expected someone outside the team to be refused, got %v", err)
+	}
+}
+
+func TestCheckDashboardAccessFailsWhenPermissionsUnreadable(t *testing.T) {
+	authConfig := permissionsGrafana(t, http.StatusForbidden)
+
+	err := CheckDashboardAccess(context.Background(), authConfig, "alice", "", "stock")
+	if err == nil {
+		t.Fatal("expected an error when the dashboard's permissions can't be read")
+	}
+	if errors.Is(err, ErrDashboardForbidden) {
+		t.Errorf("expected the error not to be mistaken for a refusal, got %v", err)
+	}
+}
+
+func TestCheckDashboardAccessFailsForUnknownUser(t *testing.T) {
+	authConfig := permissionsGrafana(t, http.StatusOK)
+
+	if err := CheckDashboardAccess(context.Background(), authConfig, "mallory", "", "stock"); err == nil {
+		t.Error("expected an error for a login which isn't in the organisation")
+	}
+}
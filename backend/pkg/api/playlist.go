@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// Kinds of playlist item: a dashboard, or every dashboard with a tag
+const (
+	playlistItemDashboardByUID = "dashboard_by_uid"
+	playlistItemDashboardByID  = "dashboard_by_id"
+	playlistItemDashboardByTag = "dashboard_by_tag"
+)
+
+var ErrPlaylistNotFound = errors.New("there is no Grafana playlist")
+
+// Playlist is a Grafana playlist, for picking one a schedule reports on
+type Playlist struct {
+	UID  string `json:"uid"`
+	Name string `json:"name"`
+}
+
+type playlistItem struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type playlistResponse struct {
+	Playlist
+	Items []playlistItem `json:"items"`
+}
+
+type dashboardSearchHit struct {
+	UID string `json:"uid"`
+}
+
+func getJSON(ctx context.Context, authConfig *auth.AuthConfig, path string, value interface{}) error {
+	response, err := authConfig.GetWithContext(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", path, response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// GetPlaylists fetches every playlist in the organisation
+func GetPlaylists(ctx context.Context, authConfig *auth.AuthConfig) ([]Playlist, error) {
+	playlists := []Playlist{}
+	err := getJSON(ctx, authConfig, "/api/playlists", &playlists)
+	if err != nil {
+		log.DefaultLogger.Error("GetPlaylists: " + err.Error())
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+// GetPlaylist fetches a playlist, returning ErrPlaylistNotFound when Grafana doesn't have one with the UID
+func GetPlaylist(ctx context.Context, authConfig *auth.AuthConfig, uid string) (*Playlist, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/playlists/"+url.PathEscape(uid))
+	if err != nil {
+		log.DefaultLogger.Error("GetPlaylist: HTTP Request: " + err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w %s", ErrPlaylistNotFound, uid)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching Grafana playlist %s returned %d", uid, response.StatusCode)
+	}
+
+	var playlist Playlist
+	err = json.NewDecoder(response.Body).Decode(&playlist)
+	if err != nil {
+		log.DefaultLogger.Error("GetPlaylist: json.Decode(): " + err.Error())
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// GetPlaylistDashboards fetches the UIDs of the dashboards a playlist shows, in the order it shows them. Tags expand to
+// their dashboards in the order Grafana's search lists them, which is by title, and a dashboard is only included once.
+func GetPlaylistDashboards(ctx context.Context, authConfig *auth.AuthConfig, uid string) ([]string, error) {
+	var playlist playlistResponse
+	err := getJSON(ctx, authConfig, "/api/playlists/"+url.PathEscape(uid), &playlist)
+	if err != nil {
+		log.DefaultLogger.Error("GetPlaylistDashboards: " + err.Error())
+		return nil, err
+	}
+
+	// Grafana before 9.1 only returns the items from their own route
+	if playlist.Items == nil {
+		err = getJSON(ctx, authConfig, "/api/playlists/"+url.PathEscape(uid)+"/items", &playlist.Items)
+		if err != nil {
+			log.DefaultLogger.Error("GetPlaylistDashboards: " + err.Error())
+			return nil, err
+		}
+	}
+
+	uids := []string{}
+	seen := map[string]bool{}
+	for _, item := range playlist.Items {
+		var itemUIDs []string
+		switch item.Type {
+		case playlistItemDashboardByUID:
+			itemUIDs = []string{item.Value}
+		case playlistItemDashboardByID:
+			itemUIDs, err = searchDashboards(ctx, authConfig, "dashboardIds="+url.QueryEscape(item.Value))
+		case playlistItemDashboardByTag:
+			itemUIDs, err = searchDashboards(ctx, authConfig, "tag="+url.QueryEscape(item.Value))
+		default:
+			log.DefaultLogger.Warn("GetPlaylistDashboards: skipping playlist " + uid + " item of unknown type " + item.Type)
+		}
+		if err != nil {
+			log.DefaultLogger.Error("GetPlaylistDashboards: " + err.Error())
+			return nil, err
+		}
+
+		for _, itemUID := range itemUIDs {
+			if !seen[itemUID] {
+				seen[itemUID] = true
+				uids = append(uids, itemUID)
+			}
+		}
+	}
+
+	return uids, nil
+}
+
+func searchDashboards(ctx context.Context, authConfig *auth.AuthConfig, query string) ([]string, error) {
+	var hits []dashboardSearchHit
+	err := getJSON(ctx, authConfig, "/api/search?type=dash-db&"+query, &hits)
+	if err != nil {
+		return nil, err
+	}
+
+	uids := []string{}
+	for _, hit := range hits {
+		uids = append(uids, hit.UID)
+	}
+	return uids, nil
+}
@@ -1,12 +1,18 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
 )
 
 type Query struct {
@@ -98,3 +104,48 @@ func (qr *QueryResponse) Columns() []Column {
 
 	return nil
 }
+
+// queryNow runs a query against the datasource for the current moment
+func queryNow(ctx context.Context, authConfig auth.AuthConfig, caller string, rawSql string, datasourceID int) (*QueryResponse, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	body, err := NewQueryRequest(rawSql, now, now, datasourceID).ToRequestBody()
+	if err != nil {
+		log.DefaultLogger.Error(caller + ": NewQueryRequest: " + err.Error())
+		return nil, err
+	}
+
+	if err := chaos.Query(ctx); err != nil {
+		log.DefaultLogger.Error(caller + ": chaos.Query: " + err.Error())
+		return nil, err
+	}
+
+	response, err := authConfig.PostWithContext(ctx, "/api/tsdb/query", "application/json", body)
+	if err != nil {
+		log.DefaultLogger.Error(caller + ": authConfig.PostWithContext: " + err.Error())
+		return nil, err
+	}
+
+	qr, err := NewQueryResponse(response)
+	if err != nil {
+		log.DefaultLogger.Error(caller + ": NewQueryResponse: " + err.Error())
+		return nil, err
+	}
+
+	return qr, nil
+}
+
+// QueryValue runs a query against the datasource and returns the first value of the result as a string,
+// or an empty string if there are no rows
+func QueryValue(ctx context.Context, authConfig auth.AuthConfig, rawSql string, datasourceID int) (string, error) {
+	qr, err := queryNow(ctx, authConfig, "QueryValue", rawSql, datasourceID)
+	if err != nil {
+		return "", err
+	}
+
+	rows := qr.Rows()
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] == nil {
+		return "", nil
+	}
+
+	return fmt.Sprint(rows[0][0]), nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+)
+
+// Limits which stop a misconfigured schedule from overwhelming the image renderer
+const (
+	MaxRenderWidth   = 3000
+	MaxRenderHeight  = 3000
+	MaxRenderScale   = 3
+	RenderThemeLight = "light"
+	RenderThemeDark  = "dark"
+)
+
+type RenderOptions struct {
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Scale  float64 `json:"scale"`
+	Theme  string  `json:"theme"`
+}
+
+// Capped returns the options clamped to what the renderer is allowed to produce
+func (options RenderOptions) Capped() RenderOptions {
+	capped := options
+	if capped.Width > MaxRenderWidth {
+		capped.Width = MaxRenderWidth
+	}
+	if capped.Height > MaxRenderHeight {
+		capped.Height = MaxRenderHeight
+	}
+	if capped.Height <= 0 {
+		// Keep the aspect ratio of Grafana's default panel render size
+		capped.Height = capped.Width / 2
+	}
+	if capped.Scale > MaxRenderScale {
+		capped.Scale = MaxRenderScale
+	}
+	if capped.Scale < 1 {
+		capped.Scale = 1
+	}
+	if capped.Theme != RenderThemeDark {
+		capped.Theme = RenderThemeLight
+	}
+
+	return capped
+}
+
+// panelQuery is the query of the page showing just the panel, as it is rendered
+func panelQuery(panelID int, from string, to string, contentVariables string, options RenderOptions) url.Values {
+	query := url.Values{}
+	query.Set("panelId", strconv.Itoa(panelID))
+	query.Set("theme", options.Theme)
+	if from != "" && to != "" {
+		query.Set("from", from+"000")
+		query.Set("to", to+"000")
+	}
+
+	var vars map[string][]string
+	if err := json.Unmarshal([]byte(contentVariables), &vars); err == nil {
+		for name, values := range vars {
+			for _, value := range values {
+				query.Add("var-"+name, value)
+			}
+		}
+	}
+
+	return query
+}
+
+// RenderPanel fetches a PNG of the panel from Grafana's image renderer. from and to are unix seconds
+// and contentVariables is the JSON of the content's selected variables.
+func RenderPanel(ctx context.Context, authConfig *auth.AuthConfig, dashboardUID string, panelID int, from string, to string, contentVariables string, options RenderOptions) ([]byte, error) {
+	if err := chaos.Render(); err != nil {
+		log.DefaultLogger.Error("RenderPanel: " + err.Error())
+		return nil, err
+	}
+
+	options = options.Capped()
+	query := panelQuery(panelID, from, to, contentVariables, options)
+	query.Set("width", strconv.Itoa(options.Width))
+	query.Set("height", strconv.Itoa(options.Height))
+	query.Set("scale", strconv.FormatFloat(options.Scale, 'f', -1, 64))
+
+	response, err := authConfig.GetWithContext(ctx, "/render/d-solo/"+dashboardUID+"/_?"+query.Encode())
+	if err != nil {
+		log.DefaultLogger.Error("RenderPanel: authConfig.GetWithContext: " + err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.DefaultLogger.Error("RenderPanel: ioutil.ReadAll: " + err.Error())
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("renderer returned %d for panel %d of %s: %s", response.StatusCode, panelID, dashboardUID, string(body))
+		log.DefaultLogger.Error("RenderPanel: " + err.Error())
+		return nil, err
+	}
+
+	return body, nil
+}
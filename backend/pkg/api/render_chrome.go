@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+)
+
+// Names Chrome is installed under, looked for on the PATH when no path is configured
+var chromeNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// Longest Chrome waits for the panel's queries and animations before taking the screenshot
+const chromeRenderBudget = 30 * time.Second
+
+// chromeRenderer screenshots the panel with a headless Chrome started for each panel
+type chromeRenderer struct {
+	path string
+}
+
+func (renderer *chromeRenderer) executable() (string, error) {
+	if renderer.path != "" {
+		return renderer.path, nil
+	}
+
+	for _, name := range chromeNames {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("chrome was not found, install chromium or set chromePath")
+}
+
+func (renderer *chromeRenderer) Render(ctx context.Context, authConfig *auth.AuthConfig, request RenderRequest) ([]byte, error) {
+	if err := chaos.Render(); err != nil {
+		log.DefaultLogger.Error("chromeRenderer.Render: " + err.Error())
+		return nil, err
+	}
+
+	executable, err := renderer.executable()
+	if err != nil {
+		log.DefaultLogger.Error("chromeRenderer.Render: executable: " + err.Error())
+		return nil, err
+	}
+
+	request.Options = request.Options.Capped()
+	pageURL, revoke, err := panelURL(authConfig, request)
+	if err != nil {
+		log.DefaultLogger.Error("chromeRenderer.Render: panelURL: " + err.Error())
+		return nil, err
+	}
+	defer revoke()
+
+	// Each Chrome gets its own profile, so panels rendered at the same time don't share a session
+	dir, err := ioutil.TempDir("", "msupply-render-")
+	if err != nil {
+		log.DefaultLogger.Error("chromeRenderer.Render: ioutil.TempDir: " + err.Error())
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	screenshot := filepath.Join(dir, "panel.png")
+
+	budget := chromeRenderBudget
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < budget {
+		budget = time.Until(deadline)
+	}
+
+	cmd := exec.CommandContext(ctx, executable,
+		"--headless",
+		"--disable-gpu",
+		// Chrome's sandbox needs privileges containers usually don't have
+		"--no-sandbox",
+		"--hide-scrollbars",
+		"--user-data-dir="+filepath.Join(dir, "profile"),
+		fmt.Sprintf("--window-size=%d,%d", request.Options.Width, request.Options.Height),
+		"--force-device-scale-factor="+strconv.FormatFloat(request.Options.Scale, 'f', -1, 64),
+		"--virtual-time-budget="+strconv.FormatInt(budget.Milliseconds(), 10),
+		"--screenshot="+screenshot,
+		pageURL)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		err = fmt.Errorf("chrome failed to render panel %d of %s: %s: %s", request.PanelID, request.DashboardUID, err.Error(), strings.TrimSpace(string(output)))
+		log.DefaultLogger.Error("chromeRenderer.Render: " + err.Error())
+		return nil, err
+	}
+
+	image, err := ioutil.ReadFile(screenshot)
+	if err != nil {
+		log.DefaultLogger.Error("chromeRenderer.Render: ioutil.ReadFile: " + err.Error())
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func (renderer *chromeRenderer) Ping(ctx context.Context, authConfig *auth.AuthConfig) error {
+	executable, err := renderer.executable()
+	if err != nil {
+		return err
+	}
+
+	output, err := exec.CommandContext(ctx, executable, "--headless", "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
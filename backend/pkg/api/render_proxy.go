@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// Where renderers outside Grafana load panels through the plugin. Without an address the proxy listens on a port
+// of the loopback interface, which Chrome started by the plugin can reach but a remote renderer usually can't.
+const (
+	RenderProxyAddressEnv = "MSUPPLY_RENDER_PROXY_ADDRESS"
+	RenderProxyURLEnv     = "MSUPPLY_RENDER_PROXY_URL"
+)
+
+// Query parameter a panel's address has its render key in, and the cookie the key is kept in after that for the
+// page's scripts, styles and queries
+const (
+	renderKeyParameter = "msupplyRenderKey"
+	renderKeyCookie    = "msupply-render-key"
+)
+
+// renderProxy passes requests from a browser rendering a panel on to Grafana with the plugin's credentials, so
+// they're never in an address the browser or renderer could log. Each render gets a key, which only works until
+// the panel has been rendered.
+type renderProxy struct {
+	url   string
+	mutex sync.Mutex
+	keys  map[string]*auth.AuthConfig
+}
+
+var (
+	renderProxyOnce sync.Once
+	renderProxyErr  error
+	sharedProxy     *renderProxy
+)
+
+// startRenderProxy starts the proxy the first time it's needed
+func startRenderProxy() (*renderProxy, error) {
+	renderProxyOnce.Do(func() {
+		address := os.Getenv(RenderProxyAddressEnv)
+		if address == "" {
+			address = "127.0.0.1:0"
+		}
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			log.DefaultLogger.Error("startRenderProxy: net.Listen(): " + err.Error())
+			renderProxyErr = err
+			return
+		}
+
+		proxyURL := strings.TrimRight(os.Getenv(RenderProxyURLEnv), "/")
+		if proxyURL == "" {
+			proxyURL = "http://" + listener.Addr().String()
+		}
+		sharedProxy = &renderProxy{url: proxyURL, keys: make(map[string]*auth.AuthConfig)}
+
+		go func() {
+			if err := http.Serve(listener, sharedProxy); err != nil {
+				log.DefaultLogger.Error("renderProxy: http.Serve(): " + err.Error())
+			}
+		}()
+	})
+	return sharedProxy, renderProxyErr
+}
+
+// issue makes a key for a render to load pages with the credentials, which stops working when revoked
+func (proxy *renderProxy) issue(authConfig *auth.AuthConfig) (string, func(), error) {
+	generated := make([]byte, 32)
+	if _, err := rand.Read(generated); err != nil {
+		return "", nil, err
+	}
+	key := hex.EncodeToString(generated)
+
+	proxy.mutex.Lock()
+	proxy.keys[key] = authConfig
+	proxy.mutex.Unlock()
+
+	revoke := func() {
+		proxy.mutex.Lock()
+		delete(proxy.keys, key)
+		proxy.mutex.Unlock()
+	}
+	return key, revoke, nil
+}
+
+func (proxy *renderProxy) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	key := request.URL.Query().Get(renderKeyParameter)
+	if key != "" {
+		http.SetCookie(rw, &http.Cookie{Name: renderKeyCookie, Value: key, Path: "/", HttpOnly: true})
+		query := request.URL.Query()
+		query.Del(renderKeyParameter)
+		request.URL.RawQuery = query.Encode()
+	} else if cookie, err := request.Cookie(renderKeyCookie); err == nil {
+		key = cookie.Value
+	}
+
+	proxy.mutex.Lock()
+	authConfig, ok := proxy.keys[key]
+	proxy.mutex.Unlock()
+	if key == "" || !ok {
+		http.Error(rw, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	target, err := url.Parse(strings.TrimRight(authConfig.URL, "/"))
+	if err != nil {
+		log.DefaultLogger.Error("renderProxy: url.Parse(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	forward := httputil.NewSingleHostReverseProxy(target)
+	director := forward.Director
+	forward.Director = func(request *http.Request) {
+		director(request)
+		request.Host = target.Host
+		request.Header.Del("Authorization")
+		request.Header.Del("Cookie")
+		authConfig.Authenticate(request)
+	}
+	forward.ServeHTTP(rw, request)
+}
+
+// panelURL is the address of the page showing just the panel, for renderers which load it in a browser themselves,
+// through the render proxy with a key for this render. revoke stops the key working once the panel is rendered.
+func panelURL(authConfig *auth.AuthConfig, request RenderRequest) (string, func(), error) {
+	proxy, err := startRenderProxy()
+	if err != nil {
+		return "", nil, err
+	}
+	key, revoke, err := proxy.issue(authConfig)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := panelQuery(request.PanelID, request.From, request.To, request.ContentVariables, request.Options)
+	query.Set("render", "1")
+	query.Set(renderKeyParameter, key)
+
+	return proxy.url + "/d-solo/" + request.DashboardUID + "/_?" + query.Encode(), revoke, nil
+}
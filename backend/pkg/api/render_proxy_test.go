@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// fakeGrafana answers every request with 401 unless it has the basic credentials, recording the paths it served
+func fakeGrafana(t *testing.T) (*httptest.Server, *[]string) {
+	served := &[]string{}
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		username, password, ok := request.BasicAuth()
+		if !ok || username != "reports" || password != "s3cret" {
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if request.URL.Query().Get(renderKeyParameter) != "" {
+			t.Errorf("expected the render key not to be passed on to Grafana")
+		}
+		*served = append(*served, request.URL.Path)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(grafana.Close)
+	return grafana, served
+}
+
+func TestPanelURLHasNoCredentials(t *testing.T) {
+	grafana, _ := fakeGrafana(t)
+	authConfig := &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+
+	pageURL, revoke, err := panelURL(authConfig, RenderRequest{DashboardUID: "stock", PanelID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer revoke()
+
+	parsed, err := url.Parse(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.User != nil || strings.Contains(pageURL, "s3cret") || strings.Contains(pageURL, "reports") {
+		t.Errorf("expected no credentials in the panel's address, got %s", pageURL)
+	}
+}
+
+func TestRenderProxyAuthenticatesPageAndItsRequests(t *testing.T) {
+	grafana, served := fakeGrafana(t)
+	authConfig := &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+
+	pageURL, revoke, err := panelURL(authConfig, RenderRequest{DashboardUID: "stock", PanelID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A browser, which keeps the cookie the page sets for the page's own requests
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+
+	page, err := browser.Get(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Body.Close()
+	if page.StatusCode != http.StatusOK {
+		t.Fatalf("expected the panel's page, got %d", page.StatusCode)
+	}
+
+	parsed, _ := url.Parse(pageURL)
+	query, err := browser.Post(parsed.Scheme+"://"+parsed.Host+"/api/ds/query", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.Body.Close()
+	if query.StatusCode != http.StatusOK {
+		t.Fatalf("expected the page's query to be authenticated, got %d", query.StatusCode)
+	}
+	if len(*served) != 2 || (*served)[0] != "/d-solo/stock/_" || (*served)[1] != "/api/ds/query" {
+		t.Errorf("expected the page and its query from Grafana, got %v", *served)
+	}
+
+	// Once rendered, neither the address nor the cookie get anything from Grafana
+	revoke()
+	for _, target := range []string{pageURL, parsed.Scheme + "://" + parsed.Host + "/api/ds/query"} {
+		response, err := browser.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("expected %s to be refused after the render, got %d", target, response.StatusCode)
+		}
+	}
+}
+
+func TestRenderProxyRefusesRequestsWithoutKey(t *testing.T) {
+	grafana, served := fakeGrafana(t)
+	authConfig := &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+
+	pageURL, revoke, err := panelURL(authConfig, RenderRequest{DashboardUID: "stock", PanelID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer revoke()
+
+	parsed, _ := url.Parse(pageURL)
+	for _, key := range []string{"", "guess"} {
+		target := parsed.Scheme + "://" + parsed.Host + "/api/users?" + renderKeyParameter + "=" + key
+		response, err := http.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("expected a request with the key %q to be refused, got %d", key, response.StatusCode)
+		}
+	}
+	if len(*served) != 0 {
+		t.Errorf("expected nothing to reach Grafana, got %v", *served)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+)
+
+// remoteRenderer calls a grafana-image-renderer running as a service, the same way Grafana does when it's
+// configured with a remote renderer
+type remoteRenderer struct {
+	url   string
+	token string
+}
+
+func (renderer *remoteRenderer) get(ctx context.Context, path string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, renderer.url+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if renderer.token != "" {
+		request.Header.Set("X-Auth-Token", renderer.token)
+	}
+
+	return http.DefaultClient.Do(request)
+}
+
+func (renderer *remoteRenderer) Render(ctx context.Context, authConfig *auth.AuthConfig, request RenderRequest) ([]byte, error) {
+	if err := chaos.Render(); err != nil {
+		log.DefaultLogger.Error("remoteRenderer.Render: " + err.Error())
+		return nil, err
+	}
+
+	request.Options = request.Options.Capped()
+	pageURL, revoke, err := panelURL(authConfig, request)
+	if err != nil {
+		log.DefaultLogger.Error("remoteRenderer.Render: panelURL: " + err.Error())
+		return nil, err
+	}
+	defer revoke()
+
+	query := url.Values{}
+	query.Set("url", pageURL)
+	query.Set("width", strconv.Itoa(request.Options.Width))
+	query.Set("height", strconv.Itoa(request.Options.Height))
+	query.Set("deviceScaleFactor", strconv.FormatFloat(request.Options.Scale, 'f', -1, 64))
+	query.Set("encoding", "png")
+	// Seconds the renderer waits for the panel, kept inside the time this request has
+	if deadline, ok := ctx.Deadline(); ok {
+		query.Set("timeout", strconv.Itoa(int(time.Until(deadline).Seconds())))
+	}
+
+	response, err := renderer.get(ctx, "/render?"+query.Encode())
+	if err != nil {
+		log.DefaultLogger.Error("remoteRenderer.Render: get: " + err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		log.DefaultLogger.Error("remoteRenderer.Render: ioutil.ReadAll: " + err.Error())
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("remote renderer returned %d for panel %d of %s: %s", response.StatusCode, request.PanelID, request.DashboardUID, string(body))
+		log.DefaultLogger.Error("remoteRenderer.Render: " + err.Error())
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (renderer *remoteRenderer) Ping(ctx context.Context, authConfig *auth.AuthConfig) error {
+	response, err := renderer.get(ctx, "/")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote renderer returned %d", response.StatusCode)
+	}
+
+	return nil
+}
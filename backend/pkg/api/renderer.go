@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// RenderRequest is a panel to render, from and to are unix seconds and ContentVariables is the JSON of the
+// content's selected variables
+type RenderRequest struct {
+	DashboardUID     string
+	PanelID          int
+	From             string
+	To               string
+	ContentVariables string
+	Options          RenderOptions
+}
+
+// Renderer turns a panel into a PNG
+type Renderer interface {
+	Render(ctx context.Context, authConfig *auth.AuthConfig, request RenderRequest) ([]byte, error)
+	// Ping checks the renderer can be used, for the health check
+	Ping(ctx context.Context, authConfig *auth.AuthConfig) error
+}
+
+// NewRenderer is the renderer the settings choose, Grafana's when none is chosen
+func NewRenderer(settings *dbstore.Settings) Renderer {
+	switch settings.Renderer {
+	case dbstore.RendererRemote:
+		return &remoteRenderer{url: strings.TrimRight(settings.RendererURL, "/"), token: settings.RendererToken}
+	case dbstore.RendererChrome:
+		return &chromeRenderer{path: settings.ChromePath}
+	default:
+		return grafanaRenderer{}
+	}
+}
+
+// grafanaRenderer asks Grafana to render the panel with whichever renderer it's configured with
+type grafanaRenderer struct{}
+
+func (grafanaRenderer) Render(ctx context.Context, authConfig *auth.AuthConfig, request RenderRequest) ([]byte, error) {
+	return RenderPanel(ctx, authConfig, request.DashboardUID, request.PanelID, request.From, request.To, request.ContentVariables, request.Options)
+}
+
+func (grafanaRenderer) Ping(ctx context.Context, authConfig *auth.AuthConfig) error {
+	response, err := authConfig.GetWithContext(ctx, "/api/frontend/settings")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	var settings struct {
+		RendererAvailable bool `json:"rendererAvailable"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&settings); err != nil {
+		return err
+	}
+	if !settings.RendererAvailable {
+		return fmt.Errorf("no image renderer is installed or configured in Grafana")
+	}
+
+	return nil
+}
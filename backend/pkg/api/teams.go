@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+type grafanaUser struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+}
+
+type grafanaTeam struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// lookupGrafanaUser finds a Grafana user by login. It needs the plugin's Grafana user to be a server admin, as
+// Grafana only lets admins look up other users.
+func lookupGrafanaUser(ctx context.Context, authConfig *auth.AuthConfig, login string) (*grafanaUser, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/users/lookup?loginOrEmail="+url.QueryEscape(login))
+	if err != nil {
+		log.DefaultLogger.Error("lookupGrafanaUser: HTTP Request: " + err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("looking up Grafana user %s returned %d", login, response.StatusCode)
+	}
+
+	var user grafanaUser
+	err = json.NewDecoder(response.Body).Decode(&user)
+	if err != nil {
+		log.DefaultLogger.Error("lookupGrafanaUser: json.Decode(): " + err.Error())
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserEmail fetches the email address of a Grafana user
+func GetUserEmail(ctx context.Context, authConfig *auth.AuthConfig, login string) (string, error) {
+	user, err := lookupGrafanaUser(ctx, authConfig, login)
+	if err != nil {
+		return "", err
+	}
+
+	return user.Email, nil
+}
+
+// GetUserTeams fetches the names of the Grafana teams a user is in
+func GetUserTeams(ctx context.Context, authConfig *auth.AuthConfig, login string) ([]string, error) {
+	user, err := lookupGrafanaUser(ctx, authConfig, login)
+	if err != nil {
+		return nil, err
+	}
+
+	teamsResponse, err := authConfig.GetWithContext(ctx, fmt.Sprintf("/api/users/%d/teams", user.ID))
+	if err != nil {
+		log.DefaultLogger.Error("GetUserTeams: HTTP Request: " + err.Error())
+		return nil, err
+	}
+	defer teamsResponse.Body.Close()
+
+	if teamsResponse.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the teams of Grafana user %s returned %d", login, teamsResponse.StatusCode)
+	}
+
+	var teams []grafanaTeam
+	err = json.NewDecoder(teamsResponse.Body).Decode(&teams)
+	if err != nil {
+		log.DefaultLogger.Error("GetUserTeams: json.Decode(): " + err.Error())
+		return nil, err
+	}
+
+	names := []string{}
+	for _, team := range teams {
+		names = append(names, team.Name)
+	}
+	return names, nil
+}
+
+type teamSearchResponse struct {
+	Teams []grafanaTeam `json:"teams"`
+}
+
+// GetTeams fetches the names of every Grafana team in the organisation, for picking which team owns something
+func GetTeams(ctx context.Context, authConfig *auth.AuthConfig) ([]string, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/teams/search?perpage=1000")
+	if err != nil {
+		log.DefaultLogger.Error("GetTeams: HTTP Request: " + err.Error())
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searching Grafana teams returned %d", response.StatusCode)
+	}
+
+	var search teamSearchResponse
+	err = json.NewDecoder(response.Body).Decode(&search)
+	if err != nil {
+		log.DefaultLogger.Error("GetTeams: json.Decode(): " + err.Error())
+		return nil, err
+	}
+
+	names := []string{}
+	for _, team := range search.Teams {
+		names = append(names, team.Name)
+	}
+	return names, nil
+}
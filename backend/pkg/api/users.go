@@ -1,10 +1,13 @@
 package api
 
 import (
-	"net/http"
+	"context"
+	"fmt"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
 )
 
 type User struct {
@@ -12,8 +15,7 @@ type User struct {
 	Email string `json:"email"`
 }
 
-func GetEmails(authConfig auth.AuthConfig, userIDs []string, datasourceID int) ([]string, error) {
-	url := authConfig.AuthURL() + "/api/tsdb/query"
+func GetEmails(ctx context.Context, authConfig auth.AuthConfig, userIDs []string, datasourceID int) ([]string, error) {
 	queryString := "("
 	i := 0
 	for i < len(userIDs)-1 {
@@ -27,7 +29,7 @@ func GetEmails(authConfig auth.AuthConfig, userIDs []string, datasourceID int) (
 		return nil, err
 	}
 
-	response, err := http.Post(url, "application/json", body)
+	response, err := authConfig.PostWithContext(ctx, "/api/tsdb/query", "application/json", body)
 	if err != nil {
 		log.DefaultLogger.Error("GetEmails: http.Post: " + err.Error())
 		return nil, err
@@ -58,3 +60,135 @@ func GetEmails(authConfig auth.AuthConfig, userIDs []string, datasourceID int) (
 	return ids, nil
 
 }
+
+// QueryEmails runs a report group's member query, returning the addresses in its e_mail or email column, or its
+// first column when it has neither. Rows without a valid address are left out rather than failing the query, so
+// one bad record doesn't stop a report being sent to everyone else.
+func QueryEmails(ctx context.Context, authConfig auth.AuthConfig, rawSql string, datasourceID int) ([]string, error) {
+	qr, err := queryNow(ctx, authConfig, "QueryEmails", rawSql, datasourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	emailColumnIdx := 0
+	for i, column := range qr.Columns() {
+		if column.Text == "e_mail" || column.Text == "email" {
+			emailColumnIdx = i
+		}
+	}
+
+	emails := []string{}
+	seen := map[string]bool{}
+	for _, row := range qr.Rows() {
+		if len(row) <= emailColumnIdx || row[emailColumnIdx] == nil {
+			continue
+		}
+		email := strings.TrimSpace(fmt.Sprint(row[emailColumnIdx]))
+		if email == "" || seen[strings.ToLower(email)] {
+			continue
+		}
+		if err := dbstore.CheckEmailSyntax(email); err != nil {
+			log.DefaultLogger.Warn("QueryEmails: skipping " + email + ": " + err.Error())
+			continue
+		}
+		seen[strings.ToLower(email)] = true
+		emails = append(emails, email)
+	}
+
+	return emails, nil
+}
+
+// GroupEmails is the addresses of a report group's members: those added to it, and those its member query returns
+// when it has one
+func GroupEmails(ctx context.Context, authConfig auth.AuthConfig, userIDs []string, memberQuery string, datasourceID int) ([]string, error) {
+	emails := []string{}
+	if len(userIDs) > 0 {
+		memberEmails, err := GetEmails(ctx, authConfig, userIDs, datasourceID)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, memberEmails...)
+	}
+	if strings.TrimSpace(memberQuery) == "" {
+		return emails, nil
+	}
+
+	queried, err := QueryEmails(ctx, authConfig, memberQuery, datasourceID)
+	if err != nil {
+		return nil, fmt.Errorf("member query: %w", err)
+	}
+	for _, email := range queried {
+		if !containsFold(emails, email) {
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// DirectoryUser is an active user of mSupply, who can be added to report groups
+type DirectoryUser struct {
+	ID        string
+	Name      string
+	FirstName string
+	LastName  string
+	Email     string
+}
+
+func columnString(row []interface{}, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(row) || row[i] == nil {
+		return ""
+	}
+	if str, ok := row[i].(string); ok {
+		return str
+	}
+	return fmt.Sprint(row[i])
+}
+
+// GetActiveUsers queries mSupply for the users who are still active
+func GetActiveUsers(ctx context.Context, authConfig auth.AuthConfig, datasourceID int) ([]DirectoryUser, error) {
+	body, err := NewQueryRequest("SELECT id, name, first_name, last_name, e_mail FROM \"user\" WHERE active = true", "0", "0", datasourceID).ToRequestBody()
+	if err != nil {
+		log.DefaultLogger.Error("GetActiveUsers: NewQueryRequest: " + err.Error())
+		return nil, err
+	}
+
+	response, err := authConfig.PostWithContext(ctx, "/api/tsdb/query", "application/json", body)
+	if err != nil {
+		log.DefaultLogger.Error("GetActiveUsers: authConfig.PostWithContext: " + err.Error())
+		return nil, err
+	}
+
+	qr, err := NewQueryResponse(response)
+	if err != nil {
+		log.DefaultLogger.Error("GetActiveUsers: NewQueryResponse: " + err.Error())
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, column := range qr.Columns() {
+		columns[column.Text] = i
+	}
+
+	users := []DirectoryUser{}
+	for _, row := range qr.Rows() {
+		users = append(users, DirectoryUser{
+			ID:        columnString(row, columns, "id"),
+			Name:      columnString(row, columns, "name"),
+			FirstName: columnString(row, columns, "first_name"),
+			LastName:  columnString(row, columns, "last_name"),
+			Email:     columnString(row, columns, "e_mail"),
+		})
+	}
+
+	return users, nil
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Selects every option of a variable which includes "All"
+const allValue = "$__all"
+
+// DashboardVariable is a template variable of a dashboard, with the values content can set it to
+type DashboardVariable struct {
+	Name       string   `json:"name"`
+	Label      string   `json:"label"`
+	Type       string   `json:"type"`
+	Multi      bool     `json:"multi"`
+	IncludeAll bool     `json:"includeAll"`
+	Current    []string `json:"current"`
+	Options    []string `json:"options"`
+	// Only the options can be chosen, otherwise they are suggestions and any value can be used
+	Restricted bool `json:"restricted"`
+}
+
+// Variables whose options are written in the dashboard itself. The options of query variables are only
+// what the query returned when the dashboard was last saved, so they can't be relied on.
+var restrictedTypes = map[string]bool{"custom": true, "interval": true, "constant": true}
+
+func optionValues(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func newDashboardVariable(variable TemplateVariable) DashboardVariable {
+	dashboardVariable := DashboardVariable{Name: variable.Name, Label: variable.Label, Type: variable.Type, Multi: variable.Multi, IncludeAll: variable.IncludeAll, Current: optionValues(variable.Current.Value), Options: []string{}}
+	for _, option := range variable.Options {
+		for _, value := range optionValues(option.Value) {
+			if value != allValue {
+				dashboardVariable.Options = append(dashboardVariable.Options, value)
+			}
+		}
+	}
+	if dashboardVariable.Current == nil {
+		dashboardVariable.Current = []string{}
+	}
+	dashboardVariable.Restricted = restrictedTypes[variable.Type] && len(dashboardVariable.Options) > 0
+	return dashboardVariable
+}
+
+// GetDashboardVariables fetches the template variables of a dashboard from Grafana, sorted by name
+func GetDashboardVariables(ctx context.Context, authConfig *auth.AuthConfig, uid string) ([]DashboardVariable, error) {
+	response, err := authConfig.GetWithContext(ctx, "/api/dashboards/uid/"+uid)
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardVariables: HTTP Request: " + err.Error())
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrDashboardNotFound
+	}
+
+	dashboardResponse, err := NewDashboardResponse(response)
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardVariables: NewDashboardResponse: " + err.Error())
+		return nil, err
+	}
+
+	variables := []DashboardVariable{}
+	for _, variable := range dashboardResponse.Dashboard.Templating.List {
+		variables = append(variables, newDashboardVariable(variable))
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+
+	return variables, nil
+}
+
+// CheckContentVariables checks content only sets variables the dashboard has, to values they can take
+func CheckContentVariables(variables []DashboardVariable, selected dbstore.ContentVariables) error {
+	byName := make(map[string]DashboardVariable)
+	for _, variable := range variables {
+		byName[variable.Name] = variable
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		variable, ok := byName[name]
+		if !ok {
+			return errors.New("the dashboard has no variable " + name)
+		}
+
+		values := selected[name]
+		if len(values) > 1 && !variable.Multi {
+			return errors.New("variable " + name + " can only be set to one value")
+		}
+		if !variable.Restricted {
+			continue
+		}
+
+		allowed := make(map[string]bool)
+		for _, option := range variable.Options {
+			allowed[option] = true
+		}
+		for _, value := range values {
+			if value == allValue && variable.IncludeAll {
+				continue
+			}
+			if !allowed[value] {
+				return errors.New("variable " + name + " can't be set to " + value + ", it must be one of: " + strings.Join(variable.Options, ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+// VariableOption is a value a variable can be set to, with the label the dashboard shows for it
+type VariableOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// variableQuery is the SQL of a query variable, which Grafana saves as a string or, since 8, an object holding it
+func variableQuery(variable TemplateVariable) string {
+	switch query := variable.Query.(type) {
+	case string:
+		return query
+	case map[string]interface{}:
+		for _, key := range []string{"rawSql", "query"} {
+			if str, ok := query[key].(string); ok && str != "" {
+				return str
+			}
+		}
+	}
+	return variable.Definition
+}
+
+// CurrentVariableOptions are the values a variable of a dashboard can be set to now, rather than when the dashboard
+// was saved. Query variables run their query against the datasource they use on the dashboard, datasourceID when
+// they use the default, or always datasourceID when pinned is set. ok is false when they can't be known: the variable
+// is a free text box, or its query depends on other variables or the time range.
+func CurrentVariableOptions(ctx context.Context, authConfig *auth.AuthConfig, dashboard *Dashboard, variable TemplateVariable, datasourceID int, pinned bool) (options []VariableOption, ok bool, err error) {
+	if restrictedTypes[variable.Type] {
+		for _, option := range variable.Options {
+			texts := optionValues(option.Text)
+			for i, value := range optionValues(option.Value) {
+				if value == allValue {
+					continue
+				}
+				text := value
+				if i < len(texts) {
+					text = texts[i]
+				}
+				options = append(options, VariableOption{Text: text, Value: value})
+			}
+		}
+		return options, len(options) > 0, nil
+	}
+
+	query := variableQuery(variable)
+	if variable.Type != "query" || strings.TrimSpace(query) == "" || strings.Contains(query, "$") {
+		return nil, false, nil
+	}
+
+	id := datasourceID
+	if !pinned {
+		id, err = newDatasourceResolver(authConfig, dashboard.Variables, datasourceID).resolve(ctx, variable.Datasource)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	qr, err := queryNow(ctx, *authConfig, "CurrentVariableOptions", query, id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Like Grafana, the __text and __value columns are the label and value, otherwise the first column is both
+	textColumn, valueColumn := 0, 0
+	for i, column := range qr.Columns() {
+		switch column.Text {
+		case "__text":
+			textColumn = i
+		case "__value":
+			valueColumn = i
+		}
+	}
+	options = []VariableOption{}
+	for _, row := range qr.Rows() {
+		if len(row) <= textColumn || len(row) <= valueColumn || row[valueColumn] == nil {
+			continue
+		}
+		options = append(options, VariableOption{Text: fmt.Sprint(row[textColumn]), Value: fmt.Sprint(row[valueColumn])})
+	}
+	return options, true, nil
+}
@@ -1,7 +1,14 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
 	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
@@ -11,6 +18,27 @@ type AuthConfig struct {
 	Username string
 	Password string
 	URL      string
+	// dbstore.AuthModeBasic, dbstore.AuthModeProxy or dbstore.AuthModeServiceAccount
+	Mode        string
+	ProxyHeader string
+	ProxyUser   string
+	Token       string
+}
+
+var (
+	modeMutex  sync.Mutex
+	loggedMode string
+)
+
+// logMode logs the mode used to authenticate to Grafana whenever it changes, rather than for every request
+func logMode(mode string) {
+	modeMutex.Lock()
+	defer modeMutex.Unlock()
+
+	if mode != loggedMode {
+		log.DefaultLogger.Info("Authenticating to Grafana with mode '" + mode + "'")
+		loggedMode = mode
+	}
 }
 
 func NewAuthConfig(datasource *dbstore.SQLiteDatasource) (*AuthConfig, error) {
@@ -20,7 +48,83 @@ func NewAuthConfig(datasource *dbstore.SQLiteDatasource) (*AuthConfig, error) {
 		return nil, err
 	}
 
-	return &AuthConfig{Username: settings.GrafanaUsername, Password: settings.GrafanaPassword, URL: settings.GrafanaURL}, nil
+	proxyHeader := settings.GrafanaProxyHeader
+	if proxyHeader == "" {
+		proxyHeader = dbstore.DefaultProxyHeader
+	}
+
+	config := &AuthConfig{Username: settings.GrafanaUsername, Password: settings.GrafanaPassword, URL: settings.GrafanaURL, Mode: settings.GrafanaAuthMode, ProxyHeader: proxyHeader, ProxyUser: settings.GrafanaProxyUser}
+
+	// The service account Grafana provides the plugin with is only used when it's selected, so saved credentials are
+	// never swapped for it behind the admin's back
+	if config.Mode == dbstore.AuthModeServiceAccount {
+		config.Token = os.Getenv(dbstore.ServiceAccountTokenEnv)
+		if config.Token == "" {
+			err := errors.New("grafanaAuthMode is serviceAccount but Grafana has not provided a service account token in " + dbstore.ServiceAccountTokenEnv)
+			log.DefaultLogger.Error("NewAuthConfig: " + err.Error())
+			return nil, err
+		}
+		if config.URL == "" {
+			config.URL = os.Getenv(dbstore.ServiceAccountURLEnv)
+		}
+	}
+	if config.Mode == "" {
+		config.Mode = dbstore.AuthModeBasic
+	}
+	logMode(config.Mode)
+
+	return config, nil
+}
+
+// NewRequest creates a request to the Grafana API, authenticated using the configured mode
+func (config AuthConfig) NewRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(config.URL, "/")+path, body)
+	if err != nil {
+		log.DefaultLogger.Error("AuthConfig.NewRequest: http.NewRequest: " + err.Error())
+		return nil, err
+	}
+	config.Authenticate(request)
+
+	return request, nil
+}
+
+// Authenticate adds the configured credentials to a request to Grafana
+func (config AuthConfig) Authenticate(request *http.Request) {
+	switch config.Mode {
+	case dbstore.AuthModeProxy:
+		request.Header.Set(config.ProxyHeader, config.ProxyUser)
+	case dbstore.AuthModeServiceAccount:
+		request.Header.Set("Authorization", "Bearer "+config.Token)
+	default:
+		request.SetBasicAuth(config.Username, config.Password)
+	}
+}
+
+func (config AuthConfig) Get(path string) (*http.Response, error) {
+	return config.GetWithContext(context.Background(), path)
+}
+
+func (config AuthConfig) GetWithContext(ctx context.Context, path string) (*http.Response, error) {
+	request, err := config.NewRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(request)
+}
+
+func (config AuthConfig) Post(path string, contentType string, body io.Reader) (*http.Response, error) {
+	return config.PostWithContext(context.Background(), path, contentType, body)
+}
+
+func (config AuthConfig) PostWithContext(ctx context.Context, path string, contentType string, body io.Reader) (*http.Response, error) {
+	request, err := config.NewRequest(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	return http.DefaultClient.Do(request)
 }
 
 func (config AuthConfig) AuthString() string {
@@ -1,15 +1,53 @@
 package auth
 
 import (
+	"strings"
+	"time"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
 )
 
+// Most mail servers reject messages over 10MB
+const DefaultMaxAttachmentSize = 10 * 1024 * 1024
+
+const DefaultEmailTimeout = 60 * time.Second
+
+const sharedPath = "/api/plugins/msupplyfoundation-datasource/resources/shared/"
+
+const publicSharedPath = "/shared/"
+
+const unsubscribePath = "/api/plugins/msupplyfoundation-datasource/resources/unsubscribe"
+
+const publicUnsubscribePath = "/unsubscribe"
+
+const acknowledgePath = "/api/plugins/msupplyfoundation-datasource/resources/acknowledge"
+
+const publicAcknowledgePath = "/acknowledge"
+
+const runHistoryPath = "/api/plugins/msupplyfoundation-datasource/resources/report-run"
+
 type EmailConfig struct {
-	Email    string
-	Password string
-	Host     string
-	Port     int
+	Email             string
+	Password          string
+	Host              string
+	Port              int
+	MaxAttachmentSize int64
+	SharedURL         string
+	UnsubscribeURL    string
+	AcknowledgeURL    string
+	RunHistoryURL     string
+	// Time allowed for sending each email
+	Timeout time.Duration
+	// Most emails sent a minute and over each connection, 0 for no limit
+	RateLimit int
+	BatchSize int
+	// Display name, Reply-To address and extra headers of every email, which schedules can override
+	FromName string
+	ReplyTo  string
+	Headers  []dbstore.EmailHeader
+	// Certificate and key every email is signed with, nil when they aren't signed
+	Signing *dbstore.SigningIdentity
 }
 
 func NewEmailConfig(datasource *dbstore.SQLiteDatasource) (*EmailConfig, error) {
@@ -20,5 +58,49 @@ func NewEmailConfig(datasource *dbstore.SQLiteDatasource) (*EmailConfig, error)
 		return nil, err
 	}
 
-	return &EmailConfig{Email: settings.Email, Password: settings.EmailPassword, Host: settings.EmailHost, Port: settings.EmailPort}, nil
+	return NewSettingsEmailConfig(settings), nil
+}
+
+// NewSettingsEmailConfig is the email config of settings, e.g. with an email profile applied
+func NewSettingsEmailConfig(settings *dbstore.Settings) *EmailConfig {
+	maxAttachmentSize := int64(settings.MaxAttachmentSize)
+	if maxAttachmentSize <= 0 {
+		maxAttachmentSize = DefaultMaxAttachmentSize
+	}
+
+	// Files too large to attach are linked to as share links, which those outside Grafana can only follow publicly
+	sharedURL := strings.TrimRight(settings.GrafanaURL, "/") + sharedPath
+	if publicURL := dbstore.PublicURL(); publicURL != "" {
+		sharedURL = publicURL + publicSharedPath
+	}
+
+	// Recipients outside Grafana can only unsubscribe through the plugin's public links, Grafana users can either way
+	unsubscribeURL := strings.TrimRight(settings.GrafanaURL, "/") + unsubscribePath
+	if publicURL := dbstore.PublicURL(); publicURL != "" {
+		unsubscribeURL = publicURL + publicUnsubscribePath
+	}
+
+	acknowledgeURL := strings.TrimRight(settings.GrafanaURL, "/") + acknowledgePath
+	if publicURL := dbstore.PublicURL(); publicURL != "" {
+		acknowledgeURL = publicURL + publicAcknowledgePath
+	}
+
+	runHistoryURL := strings.TrimRight(settings.GrafanaURL, "/") + runHistoryPath
+
+	timeout := DefaultEmailTimeout
+	if settings.EmailTimeout > 0 {
+		timeout = time.Duration(settings.EmailTimeout) * time.Second
+	}
+
+	headers, err := dbstore.ParseEmailHeaders(settings.EmailHeaders)
+	if err != nil {
+		log.DefaultLogger.Warn("NewSettingsEmailConfig: ParseEmailHeaders(): " + err.Error())
+	}
+
+	signing, err := dbstore.ParseSigningIdentity(settings.SigningCertificate, settings.SigningKey)
+	if err != nil {
+		log.DefaultLogger.Warn("NewSettingsEmailConfig: ParseSigningIdentity(): " + err.Error())
+	}
+
+	return &EmailConfig{Email: settings.Email, Password: settings.EmailPassword, Host: settings.EmailHost, Port: settings.EmailPort, MaxAttachmentSize: maxAttachmentSize, SharedURL: sharedURL, UnsubscribeURL: unsubscribeURL, AcknowledgeURL: acknowledgeURL, RunHistoryURL: runHistoryURL, Timeout: timeout, RateLimit: settings.EmailRateLimit, BatchSize: settings.EmailBatchSize, FromName: settings.EmailFromName, ReplyTo: settings.EmailReplyTo, Headers: headers, Signing: signing}
 }
@@ -0,0 +1,104 @@
+// Package bounce reads the bounces sent back to the address reports are sent from, so recipients whose
+// mailboxes don't exist any more show up as delivery problems instead of reports silently going nowhere.
+package bounce
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Longest a check of the mailbox can take, so a slow mail server can't hold up the next one
+const timeout = 2 * time.Minute
+
+const inbox = "INBOX"
+
+type Poller struct {
+	sql *dbstore.SQLiteDatasource
+}
+
+func New(sql *dbstore.SQLiteDatasource) *Poller {
+	return &Poller{sql: sql}
+}
+
+// Poll records the failures in each unread bounce and marks it read, returning how many failures there were.
+// Nothing is checked when no bounce mailbox is configured.
+func (p *Poller) Poll(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	settings, err := p.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("Poller.Poll: GetSettings: " + err.Error())
+		return 0, err
+	}
+	if settings.BounceMailbox == "" {
+		return 0, nil
+	}
+
+	client, err := dialIMAP(ctx, settings.BounceMailbox)
+	if err != nil {
+		log.DefaultLogger.Error("Poller.Poll: dialIMAP: " + err.Error())
+		return 0, err
+	}
+	defer client.Close()
+
+	err = client.login(settings.Email, settings.EmailPassword)
+	if err != nil {
+		log.DefaultLogger.Error("Poller.Poll: login: " + err.Error())
+		return 0, err
+	}
+
+	err = client.selectMailbox(inbox)
+	if err != nil {
+		log.DefaultLogger.Error("Poller.Poll: selectMailbox: " + err.Error())
+		return 0, err
+	}
+
+	uids, err := client.searchBounces()
+	if err != nil {
+		log.DefaultLogger.Error("Poller.Poll: searchBounces: " + err.Error())
+		return 0, err
+	}
+
+	recorded := 0
+	for _, uid := range uids {
+		message, err := client.fetch(uid)
+		if err != nil {
+			log.DefaultLogger.Error("Poller.Poll: fetch: " + err.Error())
+			return recorded, err
+		}
+
+		// A bounce which can't be read is marked read anyway, so it isn't fetched every time
+		failures, err := parseDSN(message)
+		if err != nil {
+			log.DefaultLogger.Warn("Poller.Poll: parseDSN: " + uid + ": " + err.Error())
+		}
+
+		err = p.sql.RecordDeliveryFailures(failures)
+		if err != nil {
+			log.DefaultLogger.Error("Poller.Poll: RecordDeliveryFailures: " + err.Error())
+			return recorded, err
+		}
+		recorded += len(failures)
+
+		err = client.markRead(uid)
+		if err != nil {
+			log.DefaultLogger.Error("Poller.Poll: markRead: " + err.Error())
+			return recorded, err
+		}
+	}
+
+	client.logout()
+
+	log.DefaultLogger.Info(fmt.Sprintf("Read %d bounces with %d failed deliveries", len(uids), recorded))
+	return recorded, nil
+}
+
+// Run checks for bounces in the background, for the scheduler
+func (p *Poller) Run() {
+	p.Poll(context.Background())
+}
@@ -0,0 +1,119 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// What a delivery status notification says happened to a recipient, of RFC 3464's actions only these are failures
+const (
+	actionFailed  = "failed"
+	actionDelayed = "delayed"
+)
+
+// parseDSN reads the failed recipients from a delivery status notification (RFC 3464). Messages which aren't
+// one return no failures.
+func parseDSN(message []byte) ([]dbstore.DeliveryFailure, error) {
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, nil
+	}
+
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" && partType != "message/global-delivery-status" {
+			continue
+		}
+
+		status, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		return parseDeliveryStatus(status)
+	}
+}
+
+// parseDeliveryStatus reads the per-message fields and then the fields of each recipient, each a block of
+// headers separated by a blank line
+func parseDeliveryStatus(status []byte) ([]dbstore.DeliveryFailure, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(status)))
+
+	var failures []dbstore.DeliveryFailure
+	for first := true; ; first = false {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 && !first {
+			if failure := recipientFailure(fields); failure != nil {
+				failures = append(failures, *failure)
+			}
+		}
+		if err == io.EOF {
+			return failures, nil
+		}
+		if err != nil {
+			return failures, err
+		}
+	}
+}
+
+func recipientFailure(fields textproto.MIMEHeader) *dbstore.DeliveryFailure {
+	action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+	if action != actionFailed && action != actionDelayed {
+		return nil
+	}
+
+	address := strings.Trim(typedField(fields.Get("Final-Recipient")), "<>")
+	if address == "" {
+		address = strings.Trim(typedField(fields.Get("Original-Recipient")), "<>")
+	}
+	if address == "" {
+		return nil
+	}
+
+	status := strings.Fields(fields.Get("Status"))
+	failure := dbstore.DeliveryFailure{Address: address, Source: dbstore.DeliverySourceIMAP, Permanent: action == actionFailed}
+	if len(status) > 0 {
+		failure.Status = status[0]
+		failure.Permanent = failure.Permanent && strings.HasPrefix(failure.Status, "5")
+	}
+
+	// e.g. "smtp; 550 5.1.1 <someone@example.com>: Recipient address rejected"
+	diagnostic := typedField(fields.Get("Diagnostic-Code"))
+	failure.Message = diagnostic
+	if len(diagnostic) >= 3 {
+		failure.Code, _ = strconv.Atoi(diagnostic[:3])
+	}
+
+	return &failure
+}
+
+// typedField strips the type from a field like "rfc822; someone@example.com"
+func typedField(value string) string {
+	if i := strings.Index(value, ";"); i >= 0 {
+		value = value[i+1:]
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,176 @@
+package bounce
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A literal, the size of a string sent after the line announcing it, e.g. a message's body
+var literalSize = regexp.MustCompile(`\{([0-9]+)\}$`)
+
+// imapClient speaks just enough IMAP to find, read and mark the bounces in a mailbox
+type imapClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// imapResponse is an untagged response, the line with any literals removed and the literals themselves
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+func dialIMAP(ctx context.Context, address string) (*imapClient, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := tls.Dialer{Config: &tls.Config{ServerName: host}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := client.reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		conn.Close()
+		return nil, errors.New("unexpected IMAP greeting: " + strings.TrimSpace(greeting))
+	}
+
+	return client, nil
+}
+
+func (client *imapClient) Close() error {
+	return client.conn.Close()
+}
+
+// quote makes a string safe to send as an IMAP quoted string
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// readResponse reads a line along with any literals it announces
+func (client *imapClient) readResponse() (*imapResponse, error) {
+	response := &imapResponse{}
+	for {
+		line, err := client.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		match := literalSize.FindStringSubmatch(line)
+		if match == nil {
+			response.line += line
+			return response, nil
+		}
+
+		size, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(client.reader, literal); err != nil {
+			return nil, err
+		}
+		response.line += strings.TrimSuffix(line, match[0])
+		response.literals = append(response.literals, literal)
+	}
+}
+
+// command sends a command and returns its untagged responses, or an error when the server doesn't reply OK
+func (client *imapClient) command(command string) ([]imapResponse, error) {
+	client.tag++
+	tag := "A" + strconv.Itoa(client.tag)
+	if _, err := fmt.Fprintf(client.conn, "%s %s\r\n", tag, command); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		response, err := client.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(response.line, tag+" ") {
+			responses = append(responses, *response)
+			continue
+		}
+
+		status := strings.TrimPrefix(response.line, tag+" ")
+		if !strings.HasPrefix(status, "OK") {
+			// The first word of a LOGIN command is all that's safe to log
+			return nil, fmt.Errorf("IMAP %s failed: %s", strings.Fields(command)[0], status)
+		}
+		return responses, nil
+	}
+}
+
+func (client *imapClient) login(username string, password string) error {
+	_, err := client.command("LOGIN " + quote(username) + " " + quote(password))
+	return err
+}
+
+func (client *imapClient) selectMailbox(mailbox string) error {
+	_, err := client.command("SELECT " + quote(mailbox))
+	return err
+}
+
+// searchBounces returns the UIDs of the unread delivery status notifications
+func (client *imapClient) searchBounces() ([]string, error) {
+	responses, err := client.command(`UID SEARCH UNSEEN HEADER Content-Type "delivery-status"`)
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []string
+	for _, response := range responses {
+		if strings.HasPrefix(response.line, "* SEARCH") {
+			uids = append(uids, strings.Fields(strings.TrimPrefix(response.line, "* SEARCH"))...)
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the whole message, without marking it read
+func (client *imapClient) fetch(uid string) ([]byte, error) {
+	responses, err := client.command("UID FETCH " + uid + " BODY.PEEK[]")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		if strings.Contains(response.line, "FETCH") && len(response.literals) > 0 {
+			return response.literals[0], nil
+		}
+	}
+	return nil, errors.New("IMAP FETCH returned no message for " + uid)
+}
+
+func (client *imapClient) markRead(uid string) error {
+	_, err := client.command("UID STORE " + uid + ` +FLAGS.SILENT (\Seen)`)
+	return err
+}
+
+func (client *imapClient) logout() error {
+	_, err := client.command("LOGOUT")
+	return err
+}
@@ -0,0 +1,120 @@
+// Package chaos injects failures into report generation so QA can check retries, placeholders
+// and alerting end to end. Faults can only be set when QA turns them on, never otherwise.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Faults are the failures currently being injected. Rates are the chance from 0 to 1 of each call failing.
+type Faults struct {
+	RenderFailureRate float64 `json:"renderFailureRate"`
+	SMTPFailureRate   float64 `json:"smtpFailureRate"`
+	// Milliseconds added to every panel and trigger query
+	QueryDelay int `json:"queryDelay"`
+}
+
+func FaultsFields() string {
+	return "\n{\n\trenderFailureRate float (0-1)" +
+		"\n\tsmtpFailureRate float (0-1)" +
+		"\n\tqueryDelay int\n}"
+}
+
+func (faults Faults) Validate() error {
+	if faults.RenderFailureRate < 0 || faults.RenderFailureRate > 1 || faults.SMTPFailureRate < 0 || faults.SMTPFailureRate > 1 {
+		return errors.New("failure rates must be between 0 and 1")
+	}
+	if faults.QueryDelay < 0 {
+		return errors.New("queryDelay can't be negative")
+	}
+
+	return nil
+}
+
+var ErrInjectedRender = errors.New("chaos: injected renderer failure")
+var ErrInjectedSMTP = errors.New("chaos: injected SMTP failure")
+
+var (
+	mutex  sync.RWMutex
+	faults Faults
+)
+
+// Set to "1" to allow injecting faults, moving the clock and running load tests, on QA servers only. Without it,
+// or in production even with it, none of them are available.
+const EnabledEnv = "MSUPPLY_CHAOS"
+
+// Enabled is whether faults can be injected, only ever when turned on outside production
+func Enabled() bool {
+	return os.Getenv(EnabledEnv) == "1" && os.Getenv("APP_ENV") != "production"
+}
+
+func Get() Faults {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return faults
+}
+
+func Set(newFaults Faults) error {
+	if !Enabled() {
+		return errors.New("chaos: faults can only be injected with " + EnabledEnv + "=1")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	faults = newFaults
+	return nil
+}
+
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	faults = Faults{}
+}
+
+func fail(rate float64) bool {
+	return Enabled() && rate > 0 && rand.Float64() < rate
+}
+
+// Render returns an error when a renderer failure should be injected
+func Render() error {
+	if fail(Get().RenderFailureRate) {
+		return ErrInjectedRender
+	}
+
+	return nil
+}
+
+// SMTP returns an error when an SMTP failure should be injected
+func SMTP() error {
+	if fail(Get().SMTPFailureRate) {
+		return ErrInjectedSMTP
+	}
+
+	return nil
+}
+
+// Query waits for the injected query delay, returning early if the context is done
+func Query(ctx context.Context) error {
+	delay := Get().QueryDelay
+	if !Enabled() || delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+// Package clock is the time schedules are worked out against. When chaos is turned on it can be moved, so QA can
+// check what schedules do across a DST change or after days of downtime without waiting for it.
+package clock
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+// Fixed is a clock stopped at a time, for working out what schedules do at that moment
+type Fixed time.Time
+
+func (fixed Fixed) Now() time.Time {
+	return time.Time(fixed)
+}
+
+// Travel moves the clock to a time, from where it carries on unless it's frozen
+type Travel struct {
+	To     int  `json:"to"`
+	Frozen bool `json:"frozen"`
+}
+
+func TravelFields() string {
+	return "\n{\n\tto int (unix time)" +
+		"\n\tfrozen bool\n}"
+}
+
+func (travel Travel) Validate() error {
+	if travel.To <= 0 {
+		return errors.New("to must be a unix time")
+	}
+
+	return nil
+}
+
+// State is where the clock is, and how far it has been moved from the real time
+type State struct {
+	Now    int  `json:"now"`
+	Offset int  `json:"offset"`
+	Frozen bool `json:"frozen"`
+}
+
+var (
+	mutex  sync.RWMutex
+	offset time.Duration
+	frozen *time.Time
+)
+
+type systemClock struct{}
+
+// System is the clock the plugin runs on, the real time unless it has been moved while chaos is enabled
+var System Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	if !chaos.Enabled() {
+		return time.Now()
+	}
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	if frozen != nil {
+		return *frozen
+	}
+	return time.Now().Add(offset)
+}
+
+func Get() State {
+	now := System.Now()
+
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return State{Now: int(now.Unix()), Offset: int(now.Sub(time.Now()).Seconds()), Frozen: frozen != nil}
+}
+
+func Set(travel Travel) error {
+	if !chaos.Enabled() {
+		return errors.New("clock: the time can only be moved with " + chaos.EnabledEnv + "=1")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	to := time.Unix(int64(travel.To), 0)
+	offset = time.Until(to)
+	frozen = nil
+	if travel.Frozen {
+		frozen = &to
+	}
+	return nil
+}
+
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	offset = 0
+	frozen = nil
+}
@@ -0,0 +1,192 @@
+package dbstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Name of the secret acknowledgement links are signed with
+const AcknowledgeSecret = "acknowledge"
+
+// ErrNotSentTo is returned acknowledging a run for an address it wasn't sent to
+var ErrNotSentTo = errors.New("this report wasn't sent to that address")
+
+// Acknowledgement is a report sent to a recipient of a schedule which tracks acknowledgements, and when they
+// marked it as reviewed, 0 until they do
+type Acknowledgement struct {
+	RunID          string `json:"runID"`
+	ScheduleID     string `json:"scheduleID"`
+	Address        string `json:"address"`
+	SentAt         int    `json:"sentAt"`
+	AcknowledgedAt int    `json:"acknowledgedAt"`
+}
+
+// RunAcknowledgements is how many recipients of a run have acknowledged it
+type RunAcknowledgements struct {
+	RunID        string  `json:"runID"`
+	ScheduledAt  int     `json:"scheduledAt"`
+	Recipients   int     `json:"recipients"`
+	Acknowledged int     `json:"acknowledged"`
+	Rate         float64 `json:"rate"`
+}
+
+// AcknowledgementSummary is how many of a schedule's reports were acknowledged, overall and by run, most recent
+// run first
+type AcknowledgementSummary struct {
+	ScheduleID   string                `json:"scheduleID"`
+	ScheduleName string                `json:"scheduleName"`
+	Sent         int                   `json:"sent"`
+	Acknowledged int                   `json:"acknowledged"`
+	Rate         float64               `json:"rate"`
+	Runs         []RunAcknowledgements `json:"runs"`
+	// The recipients who haven't acknowledged the latest run yet
+	Outstanding []string `json:"outstanding"`
+}
+
+// SignAcknowledgement is the signature of an acknowledgement link, so a link only acknowledges the run for the
+// address it was sent to
+func SignAcknowledgement(secret []byte, runID string, address string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(runID + "\n" + strings.ToLower(strings.TrimSpace(address))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CheckAcknowledgement(secret []byte, runID string, address string, signature string) bool {
+	expected := SignAcknowledgement(secret, runID, address)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// ExpectAcknowledgements records that a run was sent to the addresses, which are then waiting to acknowledge it
+func (datasource *SQLiteDatasource) ExpectAcknowledgements(runID string, scheduleID string, addresses []string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ExpectAcknowledgements: sql.Open(): ", err.Error())
+		return err
+	}
+
+	now := time.Now().Unix()
+	err = retryBusy("ExpectAcknowledgements", func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, address := range addresses {
+			_, err = tx.Exec("INSERT OR IGNORE INTO Acknowledgement (runID, scheduleID, address, sentAt, acknowledgedAt) VALUES (?, ?, ?, ?, 0)", runID, scheduleID, strings.TrimSpace(address), now)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		log.DefaultLogger.Error("ExpectAcknowledgements: tx.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Acknowledge marks a run as reviewed by the address, keeping the time it was first acknowledged when it already
+// has been. It returns ErrNotSentTo when the run wasn't sent to the address.
+func (datasource *SQLiteDatasource) Acknowledge(runID string, address string) (*Acknowledgement, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("Acknowledge: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	err = retryBusy("Acknowledge", func() error {
+		_, err := db.Exec("UPDATE Acknowledgement SET acknowledgedAt = ? WHERE runID = ? AND address = ? AND acknowledgedAt = 0", time.Now().Unix(), runID, strings.TrimSpace(address))
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("Acknowledge: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	var acknowledgement Acknowledgement
+	err = db.QueryRow("SELECT runID, scheduleID, address, sentAt, acknowledgedAt FROM Acknowledgement WHERE runID = ? AND address = ?", runID, strings.TrimSpace(address)).
+		Scan(&acknowledgement.RunID, &acknowledgement.ScheduleID, &acknowledgement.Address, &acknowledgement.SentAt, &acknowledgement.AcknowledgedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotSentTo
+	}
+	if err != nil {
+		log.DefaultLogger.Error("Acknowledge: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &acknowledgement, nil
+}
+
+// GetAcknowledgementSummary totals the acknowledgements of a schedule's runs sent since the time given
+func (datasource *SQLiteDatasource) GetAcknowledgementSummary(schedule Schedule, since int) (*AcknowledgementSummary, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetAcknowledgementSummary: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT a.runID, COALESCE(r.scheduledAt, 0), COUNT(*), SUM(CASE WHEN a.acknowledgedAt > 0 THEN 1 ELSE 0 END) FROM Acknowledgement a LEFT JOIN ReportRun r ON r.id = a.runID WHERE a.scheduleID = ? AND a.sentAt >= ? GROUP BY a.runID ORDER BY MAX(a.sentAt) DESC", schedule.ID, since)
+	if err != nil {
+		log.DefaultLogger.Error("GetAcknowledgementSummary: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := AcknowledgementSummary{ScheduleID: schedule.ID, ScheduleName: schedule.Name, Runs: []RunAcknowledgements{}, Outstanding: []string{}}
+	for rows.Next() {
+		var run RunAcknowledgements
+		err = rows.Scan(&run.RunID, &run.ScheduledAt, &run.Recipients, &run.Acknowledged)
+		if err != nil {
+			log.DefaultLogger.Error("GetAcknowledgementSummary: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		run.Rate = acknowledgementRate(run.Acknowledged, run.Recipients)
+		summary.Sent += run.Recipients
+		summary.Acknowledged += run.Acknowledged
+		summary.Runs = append(summary.Runs, run)
+	}
+	rows.Close()
+	summary.Rate = acknowledgementRate(summary.Acknowledged, summary.Sent)
+
+	if len(summary.Runs) == 0 {
+		return &summary, nil
+	}
+
+	outstanding, err := db.Query("SELECT address FROM Acknowledgement WHERE runID = ? AND acknowledgedAt = 0 ORDER BY address", summary.Runs[0].RunID)
+	if err != nil {
+		log.DefaultLogger.Error("GetAcknowledgementSummary: db.Query(): outstanding: ", err.Error())
+		return nil, err
+	}
+	defer outstanding.Close()
+	for outstanding.Next() {
+		var address string
+		if err := outstanding.Scan(&address); err != nil {
+			log.DefaultLogger.Error("GetAcknowledgementSummary: rows.Scan(): outstanding: ", err.Error())
+			return nil, err
+		}
+		summary.Outstanding = append(summary.Outstanding, address)
+	}
+
+	return &summary, nil
+}
+
+// acknowledgementRate is the fraction of the recipients who acknowledged, 0 when there were none
+func acknowledgementRate(acknowledged int, recipients int) float64 {
+	if recipients == 0 {
+		return 0
+	}
+	return float64(acknowledged) / float64(recipients)
+}
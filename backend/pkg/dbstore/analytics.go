@@ -0,0 +1,55 @@
+package dbstore
+
+import (
+	"database/sql"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// MonthlyCost is the estimated spend on sending reports in a UTC month, formatted as e.g. 2021-06
+type MonthlyCost struct {
+	Period        string  `json:"period"`
+	Runs          int     `json:"runs"`
+	MessagesSent  int     `json:"messagesSent"`
+	EstimatedCost float64 `json:"estimatedCost"`
+	Currency      string  `json:"currency"`
+}
+
+// GetMonthlyCosts totals the estimated cost of every run by the UTC month it started in, most recent first.
+// Passing a schedule ID limits the totals to that schedule's runs.
+func (datasource *SQLiteDatasource) GetMonthlyCosts(scheduleID string) ([]MonthlyCost, error) {
+	settings, err := datasource.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("GetMonthlyCosts: GetSettings(): ", err.Error())
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetMonthlyCosts: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT strftime('%Y-%m', startedAt, 'unixepoch') AS period, COUNT(*), TOTAL(messagesSent), TOTAL(estimatedCost) FROM ReportRun WHERE (? = '' OR scheduleID = ?) AND test = 0 GROUP BY period ORDER BY period DESC", scheduleID, scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetMonthlyCosts: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	costs := []MonthlyCost{}
+	for rows.Next() {
+		cost := MonthlyCost{Currency: settings.CostCurrency}
+		var messagesSent float64
+		err = rows.Scan(&cost.Period, &cost.Runs, &messagesSent, &cost.EstimatedCost)
+		if err != nil {
+			log.DefaultLogger.Error("GetMonthlyCosts: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		cost.MessagesSent = int(messagesSent)
+		costs = append(costs, cost)
+	}
+
+	return costs, nil
+}
@@ -0,0 +1,113 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionImport  = "import"
+	AuditActionRestore = "restore"
+	AuditActionErase   = "erase"
+	AuditActionPurge   = "purge"
+	AuditActionEnable  = "enable"
+	AuditActionCancel  = "cancel"
+	// A request made by a super-admin as another user, recorded whether or not it changes anything
+	AuditActionImpersonate = "impersonate"
+)
+
+// AuditEntry records a change to the configuration, Before and After are the JSON of the changed entity
+type AuditEntry struct {
+	ID         string `json:"id"`
+	Timestamp  int    `json:"timestamp"`
+	Actor      string `json:"actor"`
+	Action     string `json:"action"`
+	EntityType string `json:"entityType"`
+	EntityID   string `json:"entityID"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+}
+
+type AuditLogPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+}
+
+// AuditLogFilter limits the entries returned, empty fields match everything
+type AuditLogFilter struct {
+	EntityType string
+	EntityID   string
+	Actor      string
+}
+
+const auditEntryColumns = "id, timestamp, actor, action, entityType, entityID, before, after"
+
+func (datasource *SQLiteDatasource) CreateAuditEntry(entry AuditEntry) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateAuditEntry: sql.Open(): ", err.Error())
+		return err
+	}
+
+	entry.ID = uuid.New().String()
+	entry.Timestamp = int(time.Now().Unix())
+	err = retryBusy("CreateAuditEntry", func() error {
+		_, err := db.Exec("INSERT INTO AuditLog ("+auditEntryColumns+") VALUES (?,?,?,?,?,?,?,?)",
+			entry.ID, entry.Timestamp, entry.Actor, entry.Action, entry.EntityType, entry.EntityID, entry.Before, entry.After)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CreateAuditEntry: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditLog returns a page of the audit log, most recent first
+func (datasource *SQLiteDatasource) GetAuditLog(filter AuditLogFilter, offset int, limit int) (*AuditLogPage, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetAuditLog: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	where := " WHERE (? = '' OR entityType = ?) AND (? = '' OR entityID = ?) AND (? = '' OR actor = ?)"
+	args := []interface{}{filter.EntityType, filter.EntityType, filter.EntityID, filter.EntityID, filter.Actor, filter.Actor}
+
+	page := AuditLogPage{Entries: []AuditEntry{}, Offset: offset, Limit: limit}
+	err = db.QueryRow("SELECT COUNT(*) FROM AuditLog"+where, args...).Scan(&page.Total)
+	if err != nil {
+		log.DefaultLogger.Error("GetAuditLog: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+auditEntryColumns+" FROM AuditLog"+where+" ORDER BY timestamp DESC, rowid DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		log.DefaultLogger.Error("GetAuditLog: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		err = rows.Scan(&entry.ID, &entry.Timestamp, &entry.Actor, &entry.Action, &entry.EntityType, &entry.EntityID, &entry.Before, &entry.After)
+		if err != nil {
+			log.DefaultLogger.Error("GetAuditLog: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return &page, nil
+}
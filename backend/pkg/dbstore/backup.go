@@ -0,0 +1,300 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	backupPrefix     = "msupply-"
+	backupExtension  = ".db"
+	backupTimeFormat = "20060102-150405"
+)
+
+// Backup is a copy of the plugin database, identified by its file name within the backup directory
+type Backup struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int    `json:"createdAt"`
+}
+
+type RestoreRequest struct {
+	Name string `json:"name"`
+}
+
+func RestoreRequestFields() string {
+	return "\n{\n\tname string\n}"
+}
+
+// Directory in the data directory backups used to be written to by default
+const legacyBackupsDirectory = "backups"
+
+// BackupDirectory is where backups are written, the configured directory or by default the organisation's directory
+// next to the data directory. Backups hold every secret in the database, so they're kept out of the data directory
+// and only listed and restored by admins.
+func (datasource *SQLiteDatasource) BackupDirectory(settings *Settings) string {
+	if settings != nil && strings.TrimSpace(settings.BackupDirectory) != "" {
+		return settings.BackupDirectory
+	}
+
+	root := datasource.DataDirectory()
+	if filepath.Base(filepath.Dir(root)) == TenantsDirectory {
+		root = filepath.Dir(filepath.Dir(root))
+	}
+	directory := filepath.Clean(root) + "-backups"
+	if orgID := datasource.OrgID(); orgID != defaultOrgID {
+		directory = filepath.Join(directory, tenantDirectory(orgID))
+	}
+	return directory
+}
+
+// MoveLegacyBackups moves the backups written to the data directory by earlier versions to the default backup
+// directory, so none are left next to the reports
+func (datasource *SQLiteDatasource) MoveLegacyBackups() {
+	legacy := filepath.Join(datasource.DataDirectory(), legacyBackupsDirectory)
+	backups, err := datasource.GetBackups(legacy)
+	if err != nil || len(backups) == 0 {
+		return
+	}
+
+	directory := datasource.BackupDirectory(nil)
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.DefaultLogger.Error("MoveLegacyBackups: os.MkdirAll(): ", err.Error())
+		return
+	}
+	for _, backup := range backups {
+		if err := os.Rename(filepath.Join(legacy, backup.Name), filepath.Join(directory, backup.Name)); err != nil {
+			log.DefaultLogger.Error("MoveLegacyBackups: os.Rename(): ", err.Error())
+			return
+		}
+	}
+	os.Remove(legacy)
+	log.DefaultLogger.Info(fmt.Sprintf("Moved %d backups out of the data directory to %s", len(backups), directory))
+}
+
+// CreateBackup writes a consistent copy of the database with VACUUM INTO, which is safe while the database is in use.
+// A label is added to the file name when set, e.g. for the backup taken before a restore.
+func (datasource *SQLiteDatasource) CreateBackup(directory string, label string) (*Backup, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		log.DefaultLogger.Error("CreateBackup: os.MkdirAll(): ", err.Error())
+		return nil, err
+	}
+
+	name := backupPrefix + time.Now().Format(backupTimeFormat)
+	if label != "" {
+		name += "-" + label
+	}
+	name += backupExtension
+	path := filepath.Join(directory, name)
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateBackup: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	_, err = db.Exec("VACUUM INTO ?", path)
+	if err != nil {
+		log.DefaultLogger.Error("CreateBackup: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		log.DefaultLogger.Error("CreateBackup: os.Stat(): ", err.Error())
+		return nil, err
+	}
+
+	log.DefaultLogger.Info("Backed up the database to " + path)
+	return &Backup{Name: name, Size: info.Size(), CreatedAt: int(info.ModTime().Unix())}, nil
+}
+
+// GetBackups lists the backups in a directory, most recent first
+func (datasource *SQLiteDatasource) GetBackups(directory string) ([]Backup, error) {
+	backups := []Backup{}
+
+	files, err := ioutil.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return backups, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetBackups: ioutil.ReadDir(): ", err.Error())
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isBackupName(file.Name()) {
+			continue
+		}
+		backups = append(backups, Backup{Name: file.Name(), Size: file.Size(), CreatedAt: int(file.ModTime().Unix())})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt > backups[j].CreatedAt })
+	return backups, nil
+}
+
+func isBackupName(name string) bool {
+	return filepath.Base(name) == name && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExtension)
+}
+
+// PruneBackups deletes all but the most recent backups in a directory
+func (datasource *SQLiteDatasource) PruneBackups(directory string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	backups, err := datasource.GetBackups(directory)
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(directory, backups[i].Name)); err != nil {
+			log.DefaultLogger.Error("PruneBackups: os.Remove(): ", err.Error())
+			return err
+		}
+		log.DefaultLogger.Info("Deleted old backup " + backups[i].Name)
+	}
+
+	return nil
+}
+
+func checkIntegrity(path string) error {
+	db, err := sql.Open("sqlite3", path)
+	defer db.Close()
+	if err != nil {
+		return err
+	}
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("integrity check failed: " + result)
+	}
+
+	return nil
+}
+
+// RestoreBackup replaces the database with a backup, after backing up the current database so the
+// restore itself can be undone. Migrations are run afterwards, so backups from older versions can be used.
+func (datasource *SQLiteDatasource) RestoreBackup(directory string, name string) (*Backup, error) {
+	if !isBackupName(name) {
+		return nil, fmt.Errorf("%s is not a backup", name)
+	}
+
+	path := filepath.Join(directory, name)
+	if _, err := os.Stat(path); err != nil {
+		log.DefaultLogger.Error("RestoreBackup: os.Stat(): ", err.Error())
+		return nil, err
+	}
+	if err := checkIntegrity(path); err != nil {
+		log.DefaultLogger.Error("RestoreBackup: checkIntegrity(): ", err.Error())
+		return nil, err
+	}
+	plan, err := PlanUpgrade(path)
+	if err != nil {
+		log.DefaultLogger.Error("RestoreBackup: PlanUpgrade(): ", err.Error())
+		return nil, err
+	}
+	if !plan.Compatible {
+		return nil, errors.New("the backup can't be upgraded to this version: " + strings.Join(plan.Problems, "; "))
+	}
+
+	preRestore, err := datasource.CreateBackup(directory, "pre-restore")
+	if err != nil {
+		return nil, err
+	}
+
+	// Copied next to the database then renamed over it, so the database is never left half written
+	tmpPath := datasource.Path + ".restore"
+	if err := copyFile(path, tmpPath); err != nil {
+		log.DefaultLogger.Error("RestoreBackup: copyFile(): ", err.Error())
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, datasource.Path); err != nil {
+		os.Remove(tmpPath)
+		log.DefaultLogger.Error("RestoreBackup: os.Rename(): ", err.Error())
+		return nil, err
+	}
+
+	log.DefaultLogger.Info("Restored the database from " + path)
+	datasource.Init()
+
+	return preRestore, nil
+}
+
+// PlanBackupUpgrade checks a backup can be upgraded to this version of the plugin before it's restored
+func (datasource *SQLiteDatasource) PlanBackupUpgrade(directory string, name string) (*UpgradePlan, error) {
+	if !isBackupName(name) {
+		return nil, fmt.Errorf("%s is not a backup", name)
+	}
+
+	return PlanUpgrade(filepath.Join(directory, name))
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(destination, source); err != nil {
+		destination.Close()
+		os.Remove(to)
+		return err
+	}
+	if err := destination.Sync(); err != nil {
+		destination.Close()
+		os.Remove(to)
+		return err
+	}
+
+	return destination.Close()
+}
+
+// CreateScheduledBackup backs up the database when the configured backup interval has passed since the last backup
+func (datasource *SQLiteDatasource) CreateScheduledBackup() {
+	settings, err := datasource.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("CreateScheduledBackup: GetSettings(): ", err.Error())
+		return
+	}
+	if settings.BackupInterval <= 0 {
+		return
+	}
+
+	directory := datasource.BackupDirectory(settings)
+	backups, err := datasource.GetBackups(directory)
+	if err != nil {
+		return
+	}
+
+	interval := time.Duration(settings.BackupInterval) * time.Hour
+	if len(backups) > 0 && time.Since(time.Unix(int64(backups[0].CreatedAt), 0)) < interval {
+		return
+	}
+
+	if _, err := datasource.CreateBackup(directory, ""); err != nil {
+		return
+	}
+	datasource.PruneBackups(directory, settings.BackupRetention)
+}
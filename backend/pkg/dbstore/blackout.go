@@ -0,0 +1,379 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Kinds of blackout, when a schedule's reports are not generated
+const (
+	// Every day from startDate to endDate inclusive, e.g. a stocktake
+	BlackoutTypeRange = "range"
+	// Every Saturday and Sunday
+	BlackoutTypeWeekends = "weekends"
+	// Every holiday in a holiday calendar
+	BlackoutTypeCalendar = "calendar"
+)
+
+// What happens to a run which falls in a blackout
+const (
+	// Send it on the first day after the blackout, at the usual time
+	BlackoutPolicyPostpone = "postpone"
+	// Leave it out and wait until the schedule is next due outside a blackout
+	BlackoutPolicySkip = "skip"
+)
+
+// Dates are in this format, in the schedule's time zone
+const blackoutDateFormat = "2006-01-02"
+
+// Blackout is a period in which a schedule doesn't send reports
+type Blackout struct {
+	ID         string `json:"id"`
+	ScheduleID string `json:"scheduleID"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	StartDate  string `json:"startDate"`
+	EndDate    string `json:"endDate"`
+	CalendarID string `json:"calendarID"`
+}
+
+func BlackoutFields() string {
+	return "\n{\n\tname string" +
+		"\n\ttype string (range|weekends|calendar)" +
+		"\n\tstartDate string (YYYY-MM-DD)" +
+		"\n\tendDate string (YYYY-MM-DD)" +
+		"\n\tcalendarID string\n}"
+}
+
+func (blackout *Blackout) Validate() error {
+	switch blackout.Type {
+	case BlackoutTypeRange:
+		start, err := time.Parse(blackoutDateFormat, blackout.StartDate)
+		if err != nil {
+			return errors.New("startDate must be a date formatted YYYY-MM-DD")
+		}
+		end, err := time.Parse(blackoutDateFormat, blackout.EndDate)
+		if err != nil {
+			return errors.New("endDate must be a date formatted YYYY-MM-DD")
+		}
+		if end.Before(start) {
+			return errors.New("endDate can't be before startDate")
+		}
+	case BlackoutTypeWeekends:
+	case BlackoutTypeCalendar:
+		if strings.TrimSpace(blackout.CalendarID) == "" {
+			return errors.New("calendarID is required when type is calendar")
+		}
+	default:
+		return errors.New("type must be one of: range, weekends, calendar")
+	}
+
+	return nil
+}
+
+// HolidayCalendar is a list of public holidays, which any schedule can be blacked out on
+type HolidayCalendar struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Holidays []Holiday `json:"holidays"`
+}
+
+type Holiday struct {
+	Date string `json:"date"`
+	Name string `json:"name"`
+}
+
+func HolidayCalendarFields() string {
+	return "\n{\n\tname string" +
+		"\n\tholidays []{date string (YYYY-MM-DD), name string}\n}"
+}
+
+func (calendar *HolidayCalendar) Validate() error {
+	if strings.TrimSpace(calendar.Name) == "" {
+		return errors.New("name is required")
+	}
+	for _, holiday := range calendar.Holidays {
+		if _, err := time.Parse(blackoutDateFormat, holiday.Date); err != nil {
+			return errors.New("holiday dates must be formatted YYYY-MM-DD: " + holiday.Date)
+		}
+	}
+
+	return nil
+}
+
+// BlackoutRules are the blackouts of a schedule along with the holidays of any calendars they use
+type BlackoutRules struct {
+	Blackouts []Blackout
+	// Holiday dates of each calendar
+	holidays map[string]map[string]bool
+}
+
+// Covering is the first of the blackouts which the given time falls in, nil if there isn't one
+func (rules *BlackoutRules) Covering(t time.Time) *Blackout {
+	date := t.Format(blackoutDateFormat)
+	for i, blackout := range rules.Blackouts {
+		switch blackout.Type {
+		case BlackoutTypeRange:
+			// Dates in the same format compare in order as strings
+			if date >= blackout.StartDate && date <= blackout.EndDate {
+				return &rules.Blackouts[i]
+			}
+		case BlackoutTypeWeekends:
+			if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+				return &rules.Blackouts[i]
+			}
+		case BlackoutTypeCalendar:
+			if rules.holidays[blackout.CalendarID][date] {
+				return &rules.Blackouts[i]
+			}
+		}
+	}
+	return nil
+}
+
+const blackoutColumns = "id, scheduleID, name, type, startDate, endDate, calendarID"
+
+func (datasource *SQLiteDatasource) GetBlackouts(scheduleID string) ([]Blackout, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetBlackouts: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+blackoutColumns+" FROM Blackout WHERE scheduleID = ? ORDER BY startDate", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetBlackouts: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	blackouts := []Blackout{}
+	for rows.Next() {
+		var blackout Blackout
+		err = rows.Scan(&blackout.ID, &blackout.ScheduleID, &blackout.Name, &blackout.Type, &blackout.StartDate, &blackout.EndDate, &blackout.CalendarID)
+		if err != nil {
+			log.DefaultLogger.Error("GetBlackouts: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		blackouts = append(blackouts, blackout)
+	}
+
+	return blackouts, nil
+}
+
+func (datasource *SQLiteDatasource) CreateBlackout(blackout Blackout) (*Blackout, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateBlackout: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	blackout.ID = uuid.New().String()
+	_, err = db.Exec("INSERT INTO Blackout ("+blackoutColumns+") VALUES (?,?,?,?,?,?,?)", blackout.ID, blackout.ScheduleID, blackout.Name, blackout.Type, blackout.StartDate, blackout.EndDate, blackout.CalendarID)
+	if err != nil {
+		log.DefaultLogger.Error("CreateBlackout: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &blackout, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteBlackout(scheduleID string, id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteBlackout: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM Blackout WHERE scheduleID = ? AND id = ?", scheduleID, id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteBlackout: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetBlackoutRules loads what's needed to check whether a schedule is blacked out at a given time
+func (datasource *SQLiteDatasource) GetBlackoutRules(scheduleID string) (*BlackoutRules, error) {
+	blackouts, err := datasource.GetBlackouts(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := BlackoutRules{Blackouts: blackouts, holidays: make(map[string]map[string]bool)}
+	for _, blackout := range blackouts {
+		if blackout.Type != BlackoutTypeCalendar {
+			continue
+		}
+		if _, ok := rules.holidays[blackout.CalendarID]; ok {
+			continue
+		}
+
+		calendar, err := datasource.GetHolidayCalendar(blackout.CalendarID)
+		if err != nil {
+			return nil, err
+		}
+		dates := make(map[string]bool)
+		if calendar != nil {
+			for _, holiday := range calendar.Holidays {
+				dates[holiday.Date] = true
+			}
+		}
+		rules.holidays[blackout.CalendarID] = dates
+	}
+
+	return &rules, nil
+}
+
+func (datasource *SQLiteDatasource) GetHolidayCalendars() ([]HolidayCalendar, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetHolidayCalendars: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id FROM HolidayCalendar ORDER BY name")
+	if err != nil {
+		log.DefaultLogger.Error("GetHolidayCalendars: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.DefaultLogger.Error("GetHolidayCalendars: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	calendars := []HolidayCalendar{}
+	for _, id := range ids {
+		calendar, err := datasource.GetHolidayCalendar(id)
+		if err != nil {
+			return nil, err
+		}
+		if calendar != nil {
+			calendars = append(calendars, *calendar)
+		}
+	}
+
+	return calendars, nil
+}
+
+// GetHolidayCalendar is a calendar with its holidays in date order, nil if it doesn't exist
+func (datasource *SQLiteDatasource) GetHolidayCalendar(id string) (*HolidayCalendar, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetHolidayCalendar: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	calendar := HolidayCalendar{Holidays: []Holiday{}}
+	err = db.QueryRow("SELECT id, name FROM HolidayCalendar WHERE id = ?", id).Scan(&calendar.ID, &calendar.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetHolidayCalendar: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT date, name FROM Holiday WHERE calendarID = ? ORDER BY date", id)
+	if err != nil {
+		log.DefaultLogger.Error("GetHolidayCalendar: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var holiday Holiday
+		if err := rows.Scan(&holiday.Date, &holiday.Name); err != nil {
+			log.DefaultLogger.Error("GetHolidayCalendar: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		calendar.Holidays = append(calendar.Holidays, holiday)
+	}
+
+	return &calendar, nil
+}
+
+// SaveHolidayCalendar creates a calendar, or replaces the name and holidays of an existing one
+func (datasource *SQLiteDatasource) SaveHolidayCalendar(calendar HolidayCalendar) (*HolidayCalendar, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SaveHolidayCalendar: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("SaveHolidayCalendar: db.Begin(): ", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if calendar.ID == "" {
+		calendar.ID = uuid.New().String()
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO HolidayCalendar (id, name) VALUES (?, ?)", calendar.ID, calendar.Name)
+	if err != nil {
+		log.DefaultLogger.Error("SaveHolidayCalendar: tx.Exec(): HolidayCalendar: ", err.Error())
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM Holiday WHERE calendarID = ?", calendar.ID)
+	if err != nil {
+		log.DefaultLogger.Error("SaveHolidayCalendar: tx.Exec(): DELETE Holiday: ", err.Error())
+		return nil, err
+	}
+	for _, holiday := range calendar.Holidays {
+		_, err = tx.Exec("INSERT OR REPLACE INTO Holiday (calendarID, date, name) VALUES (?, ?, ?)", calendar.ID, holiday.Date, holiday.Name)
+		if err != nil {
+			log.DefaultLogger.Error("SaveHolidayCalendar: tx.Exec(): Holiday: ", err.Error())
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.DefaultLogger.Error("SaveHolidayCalendar: tx.Commit(): ", err.Error())
+		return nil, err
+	}
+
+	return datasource.GetHolidayCalendar(calendar.ID)
+}
+
+// DeleteHolidayCalendar removes a calendar along with the blackouts using it
+func (datasource *SQLiteDatasource) DeleteHolidayCalendar(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteHolidayCalendar: sql.Open(): ", err.Error())
+		return err
+	}
+
+	for _, statement := range []string{"DELETE FROM Blackout WHERE type = 'calendar' AND calendarID = ?", "DELETE FROM Holiday WHERE calendarID = ?", "DELETE FROM HolidayCalendar WHERE id = ?"} {
+		_, err = db.Exec(statement, id)
+		if err != nil {
+			log.DefaultLogger.Error("DeleteHolidayCalendar: db.Exec(): ", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
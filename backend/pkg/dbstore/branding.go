@@ -0,0 +1,138 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Largest logo which can be uploaded, as it's inlined into every report
+const MaxLogoSize = 512 * 1024
+
+// Logos are shown as images in every report, so only formats every mail client and browser can show are taken. SVG
+// isn't, as it can carry scripts.
+var logoTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true}
+
+var accentColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var ErrLogoTooLarge = fmt.Errorf("the logo can be at most %d KB", MaxLogoSize/1024)
+var ErrLogoType = errors.New("the logo must be a PNG, JPEG or GIF image")
+
+// Branding marks generated reports as the organisation's, e.g. a ministry's coat of arms on every page
+type Branding struct {
+	HeaderText string `json:"headerText"`
+	FooterText string `json:"footerText"`
+	// Colour of the reports' headings and rules, as #rrggbb, empty for the default
+	AccentColor string `json:"accentColor"`
+	// Content type of the uploaded logo, empty when there isn't one
+	LogoType  string `json:"logoType"`
+	UpdatedBy string `json:"updatedBy"`
+	UpdatedAt int    `json:"updatedAt"`
+	// Uploaded and served on its own, rather than with the rest
+	Logo []byte `json:"-"`
+}
+
+func BrandingFields() string {
+	return "\n{\n\theaderText string\n\t" +
+		"footerText string\n\t" +
+		"accentColor string (#rrggbb)" +
+		"\n}"
+}
+
+func (branding *Branding) Validate() error {
+	if branding.AccentColor != "" && !accentColor.MatchString(branding.AccentColor) {
+		return errors.New("accentColor must be a colour like #1f4e79")
+	}
+	if len(branding.HeaderText) > 500 || len(branding.FooterText) > 500 {
+		return errors.New("headerText and footerText can be at most 500 characters")
+	}
+	return nil
+}
+
+// LogoType is the content type of an uploaded logo, checked from its contents rather than what the upload claims
+func LogoType(logo []byte) (string, error) {
+	if len(logo) > MaxLogoSize {
+		return "", ErrLogoTooLarge
+	}
+	logoType := http.DetectContentType(logo)
+	if !logoTypes[logoType] {
+		return "", ErrLogoType
+	}
+	return logoType, nil
+}
+
+// GetBranding is the reports' branding with its logo, empty when none has been set
+func (datasource *SQLiteDatasource) GetBranding() (*Branding, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetBranding: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var branding Branding
+	err = db.QueryRow("SELECT headerText, footerText, accentColor, logoType, logo, updatedBy, updatedAt FROM Branding WHERE id = 1").Scan(&branding.HeaderText, &branding.FooterText, &branding.AccentColor, &branding.LogoType, &branding.Logo, &branding.UpdatedBy, &branding.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &Branding{}, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetBranding: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &branding, nil
+}
+
+// UpdateBranding changes the branding's text and colour, keeping its logo
+func (datasource *SQLiteDatasource) UpdateBranding(branding Branding, by string) (*Branding, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UpdateBranding: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	err = retryBusy("UpdateBranding", func() error {
+		_, err := db.Exec("INSERT INTO Branding (id, headerText, footerText, accentColor, updatedBy, updatedAt) VALUES (1,?,?,?,?,?) "+
+			"ON CONFLICT(id) DO UPDATE SET headerText = excluded.headerText, footerText = excluded.footerText, accentColor = excluded.accentColor, updatedBy = excluded.updatedBy, updatedAt = excluded.updatedAt",
+			branding.HeaderText, branding.FooterText, branding.AccentColor, by, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("UpdateBranding: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return datasource.GetBranding()
+}
+
+// SetBrandingLogo replaces the branding's logo, removing it when logo is empty
+func (datasource *SQLiteDatasource) SetBrandingLogo(logo []byte, logoType string, by string) (*Branding, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SetBrandingLogo: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	if len(logo) == 0 {
+		logo, logoType = nil, ""
+	}
+	err = retryBusy("SetBrandingLogo", func() error {
+		_, err := db.Exec("INSERT INTO Branding (id, logo, logoType, updatedBy, updatedAt) VALUES (1,?,?,?,?) "+
+			"ON CONFLICT(id) DO UPDATE SET logo = excluded.logo, logoType = excluded.logoType, updatedBy = excluded.updatedBy, updatedAt = excluded.updatedAt",
+			logo, logoType, by, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("SetBrandingLogo: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return datasource.GetBranding()
+}
@@ -0,0 +1,348 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Version of the bundle format, increased when a change would stop older bundles importing correctly
+const BundleVersion = 1
+
+// What an import does with an entity whose ID already exists
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictDuplicate = "duplicate"
+)
+
+// Bundle is a portable copy of the report configuration, for moving it between Grafana servers.
+// Settings are left out as they hold credentials and are specific to each server.
+type Bundle struct {
+	Version                int                     `json:"version"`
+	ExportedAt             int                     `json:"exportedAt"`
+	Schedules              []Schedule              `json:"schedules"`
+	ReportContent          []ReportContent         `json:"reportContent"`
+	ReportGroups           []ReportGroup           `json:"reportGroups"`
+	ReportGroupMemberships []ReportGroupMembership `json:"reportGroupMemberships"`
+}
+
+func BundleFields() string {
+	return "\n{\n\tversion int" +
+		"\n\tschedules []Schedule" +
+		"\n\treportContent []ReportContent" +
+		"\n\treportGroups []ReportGroup" +
+		"\n\treportGroupMemberships []ReportGroupMembership\n}"
+}
+
+func (bundle *Bundle) Validate() error {
+	if bundle.Version != BundleVersion {
+		return fmt.Errorf("bundle version %d is not supported, expected %d", bundle.Version, BundleVersion)
+	}
+	for _, schedule := range bundle.Schedules {
+		if schedule.ID == "" {
+			return errors.New("every schedule needs an id")
+		}
+		if err := schedule.Validate(); err != nil {
+			return errors.New(schedule.Name + ": " + err.Error())
+		}
+	}
+	for _, content := range bundle.ReportContent {
+		if err := content.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportCounts are what happened to each entity of one type
+type ImportCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+type ImportResult struct {
+	Conflict               string       `json:"conflict"`
+	Schedules              ImportCounts `json:"schedules"`
+	ReportContent          ImportCounts `json:"reportContent"`
+	ReportGroups           ImportCounts `json:"reportGroups"`
+	ReportGroupMemberships ImportCounts `json:"reportGroupMemberships"`
+}
+
+// ExportBundle copies every schedule, or only those given, along with their content, report groups and memberships
+func (datasource *SQLiteDatasource) ExportBundle(scheduleIDs []string) (*Bundle, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ExportBundle: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	bundle := Bundle{Version: BundleVersion, ExportedAt: int(time.Now().Unix()), Schedules: []Schedule{}, ReportContent: []ReportContent{}, ReportGroups: []ReportGroup{}, ReportGroupMemberships: []ReportGroupMembership{}}
+
+	wanted := make(map[string]bool)
+	for _, id := range scheduleIDs {
+		wanted[id] = true
+	}
+
+	rows, err := db.Query("SELECT " + scheduleColumns + " FROM Schedule WHERE deletedAt = 0")
+	if err != nil {
+		log.DefaultLogger.Error("ExportBundle: db.Query(): Schedule: ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	groupIDs := make(map[string]bool)
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			log.DefaultLogger.Error("ExportBundle: rows.Scan(): Schedule: ", err.Error())
+			return nil, err
+		}
+		if len(wanted) > 0 && !wanted[schedule.ID] {
+			continue
+		}
+		// Only meaningful to the scheduler of the server it came from
+		schedule.TriggerValue = ""
+		bundle.Schedules = append(bundle.Schedules, *schedule)
+		groupIDs[schedule.ReportGroupID] = true
+	}
+
+	exported := make(map[string]bool)
+	for _, schedule := range bundle.Schedules {
+		exported[schedule.ID] = true
+	}
+
+	contentRows, err := db.Query("SELECT " + reportContentColumns + " FROM ReportContent")
+	if err != nil {
+		log.DefaultLogger.Error("ExportBundle: db.Query(): ReportContent: ", err.Error())
+		return nil, err
+	}
+	defer contentRows.Close()
+
+	for contentRows.Next() {
+		content, err := scanReportContent(contentRows)
+		if err != nil {
+			log.DefaultLogger.Error("ExportBundle: rows.Scan(): ReportContent: ", err.Error())
+			return nil, err
+		}
+		if exported[content.ScheduleID] {
+			bundle.ReportContent = append(bundle.ReportContent, *content)
+		}
+	}
+
+	// Attachment passwords stay in the database they were set in, only whether a group encrypts is exported
+	groupRows, err := db.Query("SELECT id, name, description, tags, ownerTeam, memberQuery, encryptAttachments FROM ReportGroup WHERE deletedAt = 0")
+	if err != nil {
+		log.DefaultLogger.Error("ExportBundle: db.Query(): ReportGroup: ", err.Error())
+		return nil, err
+	}
+	defer groupRows.Close()
+
+	for groupRows.Next() {
+		var group ReportGroup
+		err = groupRows.Scan(&group.ID, &group.Name, &group.Description, &group.Tags, &group.OwnerTeam, &group.MemberQuery, &group.EncryptAttachments)
+		if err != nil {
+			log.DefaultLogger.Error("ExportBundle: rows.Scan(): ReportGroup: ", err.Error())
+			return nil, err
+		}
+		if len(wanted) == 0 || groupIDs[group.ID] {
+			bundle.ReportGroups = append(bundle.ReportGroups, group)
+		}
+	}
+
+	exportedGroups := make(map[string]bool)
+	for _, group := range bundle.ReportGroups {
+		exportedGroups[group.ID] = true
+	}
+
+	membershipRows, err := db.Query("SELECT id, userID, reportGroupID FROM ReportGroupMembership")
+	if err != nil {
+		log.DefaultLogger.Error("ExportBundle: db.Query(): ReportGroupMembership: ", err.Error())
+		return nil, err
+	}
+	defer membershipRows.Close()
+
+	for membershipRows.Next() {
+		var membership ReportGroupMembership
+		err = membershipRows.Scan(&membership.ID, &membership.UserID, &membership.ReportGroupID)
+		if err != nil {
+			log.DefaultLogger.Error("ExportBundle: rows.Scan(): ReportGroupMembership: ", err.Error())
+			return nil, err
+		}
+		if exportedGroups[membership.ReportGroupID] {
+			bundle.ReportGroupMemberships = append(bundle.ReportGroupMemberships, membership)
+		}
+	}
+
+	return &bundle, nil
+}
+
+func rowExists(tx *sql.Tx, table string, id string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = ?", id).Scan(&count)
+	return count > 0, err
+}
+
+// importRow writes one entity according to the conflict mode, returning the ID it was saved under.
+// Duplicated entities get a new ID, which the caller uses to update anything referring to them.
+func importRow(tx *sql.Tx, table string, columns string, id string, conflict string, counts *ImportCounts, values func(id string) []interface{}) (string, error) {
+	exists, err := rowExists(tx, table, id)
+	if err != nil {
+		return "", err
+	}
+
+	verb := "INSERT"
+	if exists {
+		switch conflict {
+		case ConflictSkip:
+			counts.Skipped++
+			return id, nil
+		case ConflictOverwrite:
+			verb = "INSERT OR REPLACE"
+		case ConflictDuplicate:
+			id = uuid.New().String()
+		}
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(strings.Split(columns, ","))), ",")
+	_, err = tx.Exec(verb+" INTO "+table+" ("+columns+") VALUES ("+placeholders+")", values(id)...)
+	if err != nil {
+		return "", err
+	}
+
+	if exists && conflict == ConflictOverwrite {
+		counts.Updated++
+	} else {
+		counts.Created++
+	}
+	return id, nil
+}
+
+// ImportBundle writes a bundle in a single transaction, so a failed import changes nothing
+func (datasource *SQLiteDatasource) ImportBundle(bundle Bundle, conflict string) (*ImportResult, error) {
+	switch conflict {
+	case ConflictSkip, ConflictOverwrite, ConflictDuplicate:
+	default:
+		return nil, errors.New("conflict must be one of: skip, overwrite, duplicate")
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ImportBundle: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("ImportBundle: db.Begin(): ", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := ImportResult{Conflict: conflict}
+
+	groupIDs := make(map[string]string)
+	for _, group := range bundle.ReportGroups {
+		group := group
+		id, err := importRow(tx, "ReportGroup", "id, name, description, tags, ownerTeam, memberQuery, encryptAttachments", group.ID, conflict, &result.ReportGroups, func(id string) []interface{} {
+			return []interface{}{id, group.Name, group.Description, group.Tags, group.OwnerTeam, group.MemberQuery, group.EncryptAttachments}
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ImportBundle: ReportGroup: ", err.Error())
+			return nil, err
+		}
+		groupIDs[group.ID] = id
+	}
+
+	for _, membership := range bundle.ReportGroupMemberships {
+		membership := membership
+		if id, ok := groupIDs[membership.ReportGroupID]; ok {
+			membership.ReportGroupID = id
+		}
+		_, err := importRow(tx, "ReportGroupMembership", "id, userID, reportGroupID", membership.ID, conflict, &result.ReportGroupMemberships, func(id string) []interface{} {
+			return []interface{}{id, membership.UserID, membership.ReportGroupID}
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ImportBundle: ReportGroupMembership: ", err.Error())
+			return nil, err
+		}
+	}
+
+	scheduleIDs := make(map[string]string)
+	for _, schedule := range bundle.Schedules {
+		schedule := schedule
+		if id, ok := groupIDs[schedule.ReportGroupID]; ok {
+			schedule.ReportGroupID = id
+		}
+		schedule.TriggerValue = ""
+		// Email profiles hold credentials so aren't bundled, those missing here send with the settings' account
+		if schedule.EmailProfileID != "" {
+			var profiles int
+			err := tx.QueryRow("SELECT COUNT(*) FROM EmailProfile WHERE id = ?", schedule.EmailProfileID).Scan(&profiles)
+			if err != nil {
+				log.DefaultLogger.Error("ImportBundle: EmailProfile: ", err.Error())
+				return nil, err
+			}
+			if profiles == 0 {
+				schedule.EmailProfileID = ""
+			}
+		}
+		// Templates aren't bundled either, those missing here are left off rather than failing every run
+		if schedule.TemplateID != "" {
+			var templates int
+			err := tx.QueryRow("SELECT COUNT(*) FROM ReportTemplate WHERE id = ?", schedule.TemplateID).Scan(&templates)
+			if err != nil {
+				log.DefaultLogger.Error("ImportBundle: ReportTemplate: ", err.Error())
+				return nil, err
+			}
+			if templates == 0 {
+				schedule.TemplateID, schedule.TemplateVersion = "", 0
+			}
+		}
+		schedule.UpdateNextReportTime()
+		id, err := importRow(tx, "Schedule", scheduleColumns, schedule.ID, conflict, &result.Schedules, func(id string) []interface{} {
+			saved := schedule
+			saved.ID = id
+			return saved.values()
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ImportBundle: Schedule: ", err.Error())
+			return nil, err
+		}
+		scheduleIDs[schedule.ID] = id
+	}
+
+	for _, content := range bundle.ReportContent {
+		content := content
+		if id, ok := scheduleIDs[content.ScheduleID]; ok {
+			content.ScheduleID = id
+		}
+		content.Type = contentType(content.Type)
+		_, err := importRow(tx, "ReportContent", reportContentColumns, content.ID, conflict, &result.ReportContent, func(id string) []interface{} {
+			saved := content
+			saved.ID = id
+			return saved.values()
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ImportBundle: ReportContent: ", err.Error())
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.DefaultLogger.Error("ImportBundle: tx.Commit(): ", err.Error())
+		return nil, err
+	}
+
+	return &result, nil
+}
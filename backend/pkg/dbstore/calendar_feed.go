@@ -0,0 +1,96 @@
+package dbstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// CalendarFeedToken lets a user's calendar client read their feed of upcoming reports without Grafana credentials,
+// which calendar clients can't send. Each user has at most one, and only a hash of it is kept, so a new one is made
+// whenever the URL is lost.
+type CalendarFeedToken struct {
+	Login string `json:"login"`
+	// The user's role when the token was made, as there's no Grafana request to read it from when the feed is
+	Role      string `json:"role"`
+	CreatedAt int    `json:"createdAt"`
+}
+
+func hashCalendarFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateCalendarFeedToken makes a new token for the user's feed, replacing any they had before
+func (datasource *SQLiteDatasource) CreateCalendarFeedToken(login string, role string) (string, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateCalendarFeedToken: sql.Open(): ", err.Error())
+		return "", err
+	}
+
+	generated := make([]byte, secretSize)
+	_, err = rand.Read(generated)
+	if err != nil {
+		log.DefaultLogger.Error("CreateCalendarFeedToken: rand.Read(): ", err.Error())
+		return "", err
+	}
+	token := hex.EncodeToString(generated)
+
+	err = retryBusy("CreateCalendarFeedToken", func() error {
+		_, err := db.Exec("INSERT OR REPLACE INTO CalendarFeedToken (login, tokenHash, role, createdAt) VALUES (?,?,?,?)", login, hashCalendarFeedToken(token), role, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CreateCalendarFeedToken: db.Exec(): ", err.Error())
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetCalendarFeedToken is who a feed's token is for, or nil when it isn't a current token
+func (datasource *SQLiteDatasource) GetCalendarFeedToken(token string) (*CalendarFeedToken, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetCalendarFeedToken: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var feedToken CalendarFeedToken
+	err = db.QueryRow("SELECT login, role, createdAt FROM CalendarFeedToken WHERE tokenHash = ?", hashCalendarFeedToken(token)).Scan(&feedToken.Login, &feedToken.Role, &feedToken.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetCalendarFeedToken: row.Scan(): ", err.Error())
+		return nil, err
+	}
+	return &feedToken, nil
+}
+
+// DeleteCalendarFeedToken stops the user's feed URL working
+func (datasource *SQLiteDatasource) DeleteCalendarFeedToken(login string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteCalendarFeedToken: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("DeleteCalendarFeedToken", func() error {
+		_, err := db.Exec("DELETE FROM CalendarFeedToken WHERE login = ?", login)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("DeleteCalendarFeedToken: db.Exec(): ", err.Error())
+		return err
+	}
+	return nil
+}
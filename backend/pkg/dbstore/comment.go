@@ -0,0 +1,136 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Longest a comment can be, they are notes rather than documents
+const MaxCommentLength = 2000
+
+// Comment is a note left on a schedule, or on one of its runs when RunID is set, e.g. why a run was late
+type Comment struct {
+	ID         string `json:"id"`
+	ScheduleID string `json:"scheduleID"`
+	RunID      string `json:"runID"`
+	// Login of the Grafana user who wrote it
+	Author    string `json:"author"`
+	Text      string `json:"text"`
+	CreatedAt int    `json:"createdAt"`
+}
+
+func CommentFields() string {
+	return "\n{\n\ttext string" +
+		"\n\trunID string (optional)\n}"
+}
+
+func (comment *Comment) Validate() error {
+	text := strings.TrimSpace(comment.Text)
+	if text == "" {
+		return errors.New("text is required")
+	}
+	if len(text) > MaxCommentLength {
+		return errors.New("text can't be longer than 2000 characters")
+	}
+
+	return nil
+}
+
+const commentColumns = "id, scheduleID, runID, author, text, createdAt"
+
+// GetComments lists a schedule's comments oldest first, or only those on one of its runs when runID isn't empty
+func (datasource *SQLiteDatasource) GetComments(scheduleID string, runID string) ([]Comment, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetComments: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	query := "SELECT " + commentColumns + " FROM Comment WHERE scheduleID = ?"
+	args := []interface{}{scheduleID}
+	if runID != "" {
+		query += " AND runID = ?"
+		args = append(args, runID)
+	}
+
+	rows, err := db.Query(query+" ORDER BY createdAt, rowid", args...)
+	if err != nil {
+		log.DefaultLogger.Error("GetComments: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		var comment Comment
+		err = rows.Scan(&comment.ID, &comment.ScheduleID, &comment.RunID, &comment.Author, &comment.Text, &comment.CreatedAt)
+		if err != nil {
+			log.DefaultLogger.Error("GetComments: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, nil
+}
+
+func (datasource *SQLiteDatasource) GetComment(scheduleID string, id string) (*Comment, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetComment: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var comment Comment
+	err = db.QueryRow("SELECT "+commentColumns+" FROM Comment WHERE scheduleID = ? AND id = ?", scheduleID, id).Scan(&comment.ID, &comment.ScheduleID, &comment.RunID, &comment.Author, &comment.Text, &comment.CreatedAt)
+	if err != nil {
+		log.DefaultLogger.Error("GetComment: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+func (datasource *SQLiteDatasource) CreateComment(comment Comment) (*Comment, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateComment: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	comment.ID = uuid.New().String()
+	comment.Text = strings.TrimSpace(comment.Text)
+	comment.CreatedAt = int(time.Now().Unix())
+	_, err = db.Exec("INSERT INTO Comment ("+commentColumns+") VALUES (?,?,?,?,?,?)", comment.ID, comment.ScheduleID, comment.RunID, comment.Author, comment.Text, comment.CreatedAt)
+	if err != nil {
+		log.DefaultLogger.Error("CreateComment: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteComment(scheduleID string, id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteComment: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM Comment WHERE scheduleID = ? AND id = ?", scheduleID, id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteComment: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
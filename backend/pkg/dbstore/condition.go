@@ -0,0 +1,69 @@
+package dbstore
+
+import (
+	"errors"
+	"strings"
+)
+
+// How a schedule's condition compares its value to its threshold
+const (
+	ConditionGreater        = ">"
+	ConditionGreaterOrEqual = ">="
+	ConditionLess           = "<"
+	ConditionLessOrEqual    = "<="
+	ConditionEqual          = "="
+	ConditionNotEqual       = "!="
+)
+
+var conditionOperators = []string{ConditionGreater, ConditionGreaterOrEqual, ConditionLess, ConditionLessOrEqual, ConditionEqual, ConditionNotEqual}
+
+// HasCondition is whether the schedule is only sent when its condition is met
+func (schedule *Schedule) HasCondition() bool {
+	return schedule.ConditionOperator != ""
+}
+
+// ConditionMet compares the value of the schedule's condition to its threshold. Schedules without one always send.
+func (schedule *Schedule) ConditionMet(value float64) bool {
+	threshold := schedule.ConditionThreshold
+	switch schedule.ConditionOperator {
+	case ConditionGreater:
+		return value > threshold
+	case ConditionGreaterOrEqual:
+		return value >= threshold
+	case ConditionLess:
+		return value < threshold
+	case ConditionLessOrEqual:
+		return value <= threshold
+	case ConditionEqual:
+		return value == threshold
+	case ConditionNotEqual:
+		return value != threshold
+	}
+	return true
+}
+
+func (schedule *Schedule) validateCondition() error {
+	schedule.ConditionQuery = strings.TrimSpace(schedule.ConditionQuery)
+	schedule.ConditionDashboardID = strings.TrimSpace(schedule.ConditionDashboardID)
+	if !schedule.HasCondition() {
+		if schedule.ConditionQuery != "" || schedule.ConditionDashboardID != "" {
+			return errors.New("conditionOperator is required with a conditionQuery or conditionDashboardID")
+		}
+		return nil
+	}
+
+	valid := false
+	for _, operator := range conditionOperators {
+		valid = valid || schedule.ConditionOperator == operator
+	}
+	if !valid {
+		return errors.New("conditionOperator must be one of: " + strings.Join(conditionOperators, ", "))
+	}
+	if (schedule.ConditionQuery == "") == (schedule.ConditionDashboardID == "") {
+		return errors.New("a condition needs one of conditionQuery or conditionDashboardID")
+	}
+	if schedule.ConditionDashboardID != "" && schedule.ConditionPanelID <= 0 {
+		return errors.New("conditionPanelID is required with a conditionDashboardID")
+	}
+	return nil
+}
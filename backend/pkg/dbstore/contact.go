@@ -0,0 +1,113 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Channels a report can be delivered through
+const (
+	ChannelEmail = "email"
+)
+
+// Contact links an address to a person, so a person reachable through several addresses or
+// channels is only sent each report once. Of a person's contacts which are recipients of a
+// report, the one with the lowest priority is used.
+type Contact struct {
+	ID       string `json:"id"`
+	PersonID string `json:"personID"`
+	Channel  string `json:"channel"`
+	Address  string `json:"address"`
+	Priority int    `json:"priority"`
+}
+
+func ContactFields() string {
+	return "\n{\n\tpersonID string" +
+		"\n\tchannel string (email)" +
+		"\n\taddress string" +
+		"\n\tpriority int\n}"
+}
+
+func (contact *Contact) Validate() error {
+	if strings.TrimSpace(contact.PersonID) == "" {
+		return errors.New("personID is required")
+	}
+	if contact.Channel != ChannelEmail {
+		return errors.New("channel must be one of: email")
+	}
+	if strings.TrimSpace(contact.Address) == "" {
+		return errors.New("address is required")
+	}
+
+	return nil
+}
+
+const contactColumns = "id, personID, channel, address, priority"
+
+func (datasource *SQLiteDatasource) GetContacts() ([]Contact, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetContacts: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + contactColumns + " FROM Contact ORDER BY personID, priority")
+	if err != nil {
+		log.DefaultLogger.Error("GetContacts: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var contact Contact
+		err = rows.Scan(&contact.ID, &contact.PersonID, &contact.Channel, &contact.Address, &contact.Priority)
+		if err != nil {
+			log.DefaultLogger.Error("GetContacts: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+
+	return contacts, nil
+}
+
+func (datasource *SQLiteDatasource) CreateContact(contact Contact) (*Contact, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateContact: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	contact.ID = uuid.New().String()
+	_, err = db.Exec("INSERT INTO Contact ("+contactColumns+") VALUES (?,?,?,?,?)", contact.ID, contact.PersonID, contact.Channel, contact.Address, contact.Priority)
+	if err != nil {
+		log.DefaultLogger.Error("CreateContact: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteContact(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteContact: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM Contact WHERE id = ?", id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteContact: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
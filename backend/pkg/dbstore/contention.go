@@ -0,0 +1,127 @@
+package dbstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Writes finding the database locked by another connection are tried again this many times, waiting twice as long
+// each time from busyBackoff, before giving up. The driver itself waits up to 5s for the lock on each attempt, so
+// a busy error means the database has been locked for a while already.
+const (
+	busyRetries = 3
+	busyBackoff = 50 * time.Millisecond
+)
+
+// SQLite is reported as persistently contended once writes have given up, or there have been contentionThreshold
+// busy errors, within contentionWindow. By then a server database such as Postgres is worth moving to.
+const (
+	contentionWindow    = time.Hour
+	contentionThreshold = 50
+)
+
+// ContentionStats is how often writes found the database locked since the plugin started
+type ContentionStats struct {
+	BusyTotal   int `json:"busyTotal"`
+	FailedTotal int `json:"failedTotal"`
+	// Within the last contentionWindow
+	RecentBusy   int `json:"recentBusy"`
+	RecentFailed int `json:"recentFailed"`
+	LastBusyAt   int `json:"lastBusyAt"`
+}
+
+// Persistent is whether the database is contended enough that operators should know
+func (stats ContentionStats) Persistent() bool {
+	return stats.RecentFailed > 0 || stats.RecentBusy >= contentionThreshold
+}
+
+func (stats ContentionStats) String() string {
+	return fmt.Sprintf("%d writes found the database busy and %d gave up in the last %s", stats.RecentBusy, stats.RecentFailed, contentionWindow)
+}
+
+type busyEvent struct {
+	at     time.Time
+	failed bool
+}
+
+var (
+	contentionMutex sync.Mutex
+	contention      ContentionStats
+	busyEvents      []busyEvent
+)
+
+// Contention is how contended the database has been, across every organisation's database
+func Contention() ContentionStats {
+	contentionMutex.Lock()
+	defer contentionMutex.Unlock()
+
+	pruneBusyEvents(time.Now())
+	stats := contention
+	for _, event := range busyEvents {
+		stats.RecentBusy++
+		if event.failed {
+			stats.RecentFailed++
+		}
+	}
+	return stats
+}
+
+func pruneBusyEvents(now time.Time) {
+	kept := busyEvents[:0]
+	for _, event := range busyEvents {
+		if now.Sub(event.at) < contentionWindow {
+			kept = append(kept, event)
+		}
+	}
+	busyEvents = kept
+}
+
+func recordBusy(operation string, failed bool) {
+	metrics.ObserveDBBusy(operation, failed)
+
+	contentionMutex.Lock()
+	defer contentionMutex.Unlock()
+
+	now := time.Now()
+	pruneBusyEvents(now)
+	busyEvents = append(busyEvents, busyEvent{at: now, failed: failed})
+	contention.BusyTotal++
+	if failed {
+		contention.FailedTotal++
+	}
+	contention.LastBusyAt = int(now.Unix())
+}
+
+// isBusy is whether an error is SQLite finding the database or a table locked by another connection
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// retryBusy runs a write, trying it again with backoff while the database is locked by another connection.
+// Writes in a transaction must be retried as a whole, as SQLite rolls back a transaction which hit the lock.
+func retryBusy(operation string, write func() error) error {
+	delay := busyBackoff
+	for attempt := 1; ; attempt++ {
+		err := write()
+		if !isBusy(err) {
+			return err
+		}
+
+		if attempt > busyRetries {
+			recordBusy(operation, true)
+			log.DefaultLogger.Error(fmt.Sprintf("%s: the database was still busy after %d retries: %s", operation, busyRetries, err.Error()))
+			return err
+		}
+		recordBusy(operation, false)
+		log.DefaultLogger.Warn(fmt.Sprintf("%s: the database is busy, retrying in %s", operation, delay))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -3,19 +3,16 @@ package dbstore
 import (
 	"context"
 	"database/sql"
-	"errors"
 
 	"encoding/json"
 	"net/http"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
-	"github.com/grafana/grafana-plugin-sdk-go/data"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -38,7 +35,8 @@ type InstanceSettings struct {
 }
 
 type queryModel struct {
-	Format string `json:"format"`
+	Format    string `json:"format"`
+	QueryType string `json:"queryType"`
 }
 
 func GetDataSource() *SQLiteDatasource {
@@ -73,11 +71,20 @@ func (datasource *SQLiteDatasource) QueryData(ctx context.Context, request *back
 		log.DefaultLogger.Info("QueryData", "request", request)
 	}
 
-	// TODO: Use context to timeout/cancel?
+	tenant, err := datasource.Tenant(request.PluginContext.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
 	response := backend.NewQueryDataResponse()
 
 	for _, query := range request.Queries {
-		res := datasource.query(ctx, query)
+		// Grafana cancels the context when the dashboard is closed or the query times out
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		res := tenant.query(ctx, query)
 		response.Responses[query.RefID] = res
 	}
 
@@ -89,52 +96,27 @@ func (datasource *SQLiteDatasource) query(ctx context.Context, query backend.Dat
 
 	response := backend.DataResponse{}
 	response.Error = json.Unmarshal(query.JSON, &queryModel)
-	response.Error = errors.New("Queries are not supported!")
 	if response.Error != nil {
 		return response
 	}
 
-	if queryModel.Format == "" {
-		log.DefaultLogger.Warn("format is empty. defaulting to time series")
+	// Grafana sets the query type on the query itself from 7.x, older panels only have it in the model
+	queryType := query.QueryType
+	if queryType == "" {
+		queryType = queryModel.QueryType
 	}
 
-	frame := data.NewFrame("response")
-	frame.Fields = append(frame.Fields,
-		data.NewField("time", nil, []time.Time{query.TimeRange.From, query.TimeRange.To}),
-	)
-	frame.Fields = append(frame.Fields,
-		data.NewField("values", nil, []int64{10, 20}),
-	)
-	response.Frames = append(response.Frames, frame)
-
-	return response
-}
-
-// CheckHealth handles health checks sent from Grafana to the plugin.
-// The main use case for these health checks is the test button on the
-// datasource configuration page which allows users to verify that
-// a datasource is working as expected.
-func (datasource *SQLiteDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-
-	err := datasource.Ping()
-
-	var status backend.HealthStatus
-	var message string
+	frame, err := datasource.queryFrame(queryType, query.TimeRange)
 	if err != nil {
-		status = backend.HealthStatusError
-		message = "Could not ping the database: " + err.Error()
-	} else {
-		status = backend.HealthStatusOk
-		message = "Yeah, nah, All good"
+		response.Error = err
+		return response
 	}
 
-	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
-	}, nil
+	response.Frames = append(response.Frames, frame)
+	return response
 }
 
-func (datasource *SQLiteDatasource) Ping() error {
+func (datasource *SQLiteDatasource) Ping(ctx context.Context) error {
 	log.DefaultLogger.Info("Pinging Database")
 
 	db, err := sql.Open("sqlite3", datasource.Path)
@@ -145,7 +127,7 @@ func (datasource *SQLiteDatasource) Ping() error {
 		return err
 	}
 
-	err = db.Ping()
+	err = db.PingContext(ctx)
 	if err != nil {
 		log.DefaultLogger.Warn("Ping - sql.Ping: ", err.Error())
 		return err
@@ -157,7 +139,7 @@ func (datasource *SQLiteDatasource) Ping() error {
 func (datasource *SQLiteDatasource) Init() {
 	log.DefaultLogger.Info("Initializing Database")
 
-	err := datasource.Ping()
+	err := datasource.Ping(context.Background())
 	if err != nil {
 		log.DefaultLogger.Error("FATAL. Init - Ping. ", err.Error())
 		panic(err)
@@ -207,5 +189,314 @@ func (datasource *SQLiteDatasource) Init() {
 		panic(err)
 	}
 
+	stmt, err = db.Prepare("CREATE INDEX IF NOT EXISTS ReportContentDashboardIndex ON ReportContent (dashboardID, scheduleID)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ReportContentDashboardIndex:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ReportRun (id TEXT PRIMARY KEY, scheduleID TEXT, startedAt INTEGER, finishedAt INTEGER, status TEXT, attachmentMode TEXT, message TEXT, FOREIGN KEY(scheduleID) REFERENCES Schedule(id))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ReportRun:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS SLOCompliance (scheduleID TEXT, period TEXT, due INTEGER, onTime INTEGER, compliance REAL, target REAL, met INTEGER, updatedAt INTEGER, PRIMARY KEY(scheduleID, period), FOREIGN KEY(scheduleID) REFERENCES Schedule(id))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create SLOCompliance:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Contact (id TEXT PRIMARY KEY, personID TEXT, channel TEXT, address TEXT, priority INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Contact:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS AuditLog (id TEXT PRIMARY KEY, timestamp INTEGER, actor TEXT, action TEXT, entityType TEXT, entityID TEXT, before TEXT, after TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create AuditLog:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ScheduleShare (scheduleID TEXT, login TEXT, sharedBy TEXT, createdAt INTEGER, PRIMARY KEY (scheduleID, login))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ScheduleShare:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ReportGroupPermission (reportGroupID TEXT, granteeType TEXT, grantee TEXT, permission TEXT, grantedBy TEXT, createdAt INTEGER, PRIMARY KEY (reportGroupID, granteeType, grantee))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ReportGroupPermission:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS UserTeams (login TEXT PRIMARY KEY, teams TEXT, fetchedAt INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create UserTeams:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Comment (id TEXT PRIMARY KEY, scheduleID TEXT, runID TEXT, author TEXT, text TEXT, createdAt INTEGER, FOREIGN KEY(scheduleID) REFERENCES Schedule(id))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Comment:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Outbox (scheduleID TEXT, scheduledAt INTEGER, address TEXT, status TEXT, enqueuedAt INTEGER, sentAt INTEGER, PRIMARY KEY (scheduleID, scheduledAt, address))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Outbox:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Favorite (login TEXT, kind TEXT, itemID TEXT, createdAt INTEGER, PRIMARY KEY (login, kind, itemID))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Favorite:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS RecentItem (login TEXT, kind TEXT, itemID TEXT, usedAt INTEGER, PRIMARY KEY (login, kind, itemID))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create RecentItem:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS SettingsLock (setting TEXT PRIMARY KEY, lockedBy TEXT, lockedAt INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create SettingsLock:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS MaskingRule (id TEXT PRIMARY KEY, name TEXT, type TEXT, pattern TEXT, replacement TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create MaskingRule:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS EmailProfile (id TEXT PRIMARY KEY, name TEXT, email TEXT, password TEXT, host TEXT, port INTEGER, fromName TEXT DEFAULT '', replyTo TEXT DEFAULT '')")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create EmailProfile:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Lease (name TEXT PRIMARY KEY, holder TEXT, acquiredAt INTEGER, expiresAt INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Lease:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Blackout (id TEXT PRIMARY KEY, scheduleID TEXT, name TEXT, type TEXT, startDate TEXT, endDate TEXT, calendarID TEXT, FOREIGN KEY(scheduleID) REFERENCES Schedule(id))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Blackout:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS HolidayCalendar (id TEXT PRIMARY KEY, name TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create HolidayCalendar:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Holiday (calendarID TEXT, date TEXT, name TEXT, PRIMARY KEY (calendarID, date))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Holiday:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ResidencyRule (id TEXT PRIMARY KEY, tag TEXT, channel TEXT, allowedHosts TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ResidencyRule:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS DirectoryUser (id TEXT PRIMARY KEY, name TEXT, firstName TEXT, lastName TEXT, email TEXT, syncedAt INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create DirectoryUser:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS DeliveryFailure (id TEXT PRIMARY KEY, timestamp INTEGER, address TEXT, scheduleID TEXT, source TEXT, code INTEGER, status TEXT, permanent INTEGER, message TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create DeliveryFailure:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS BouncingRecipient (address TEXT PRIMARY KEY COLLATE NOCASE, firstFailedAt INTEGER, lastFailedAt INTEGER, failures INTEGER, lastStatus TEXT, lastMessage TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create BouncingRecipient:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Secret (name TEXT PRIMARY KEY, value TEXT)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Secret:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Unsubscribe (scheduleID TEXT, address TEXT COLLATE NOCASE, timestamp INTEGER, PRIMARY KEY (scheduleID, address))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Unsubscribe:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS PanelImage (scheduleID TEXT, fingerprint TEXT, image BLOB, renderedAt INTEGER, PRIMARY KEY (scheduleID, fingerprint))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create PanelImage:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Job (id TEXT PRIMARY KEY, kind TEXT, scheduleID TEXT, scheduleName TEXT, priority TEXT, status TEXT, payload TEXT, requestedBy TEXT, startAt INTEGER, createdAt INTEGER, startedAt INTEGER DEFAULT 0, finishedAt INTEGER DEFAULT 0, heartbeatAt INTEGER DEFAULT 0, instance TEXT DEFAULT '', cancelRequested INTEGER DEFAULT 0, message TEXT DEFAULT '')")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Job:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE INDEX IF NOT EXISTS JobStatusIndex ON Job (status, startAt)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create JobStatusIndex:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ReportTemplate (id TEXT PRIMARY KEY, name TEXT, description TEXT DEFAULT '', version INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ReportTemplate:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Branding (id INTEGER PRIMARY KEY CHECK (id = 1), headerText TEXT DEFAULT '', footerText TEXT DEFAULT '', accentColor TEXT DEFAULT '', logo BLOB, logoType TEXT DEFAULT '', updatedBy TEXT DEFAULT '', updatedAt INTEGER DEFAULT 0)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Branding:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ReportTemplateVersion (templateID TEXT, version INTEGER, content TEXT, formats TEXT DEFAULT '', subject TEXT DEFAULT '', body TEXT DEFAULT '', createdBy TEXT DEFAULT '', createdAt INTEGER, PRIMARY KEY (templateID, version))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ReportTemplateVersion:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS Acknowledgement (runID TEXT, scheduleID TEXT, address TEXT COLLATE NOCASE, sentAt INTEGER, acknowledgedAt INTEGER DEFAULT 0, PRIMARY KEY (runID, address))")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create Acknowledgement:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS ShareLink (id TEXT PRIMARY KEY, file TEXT, createdBy TEXT DEFAULT '', createdAt INTEGER, expiresAt INTEGER, revokedAt INTEGER DEFAULT 0, revokedBy TEXT DEFAULT '', downloads INTEGER DEFAULT 0)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create ShareLink:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	stmt, err = db.Prepare("CREATE TABLE IF NOT EXISTS CalendarFeedToken (login TEXT PRIMARY KEY, tokenHash TEXT UNIQUE, role TEXT DEFAULT '', createdAt INTEGER)")
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create CalendarFeedToken:", err.Error())
+		panic(err)
+	}
+	stmt.Exec()
+
+	err = datasource.migrate(db)
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not upgrade the database:", err.Error())
+		panic(err)
+	}
+
+	// Indexes on columns added by migrations, which the schedule and report group lists filter and sort by
+	for _, index := range listIndexes {
+		_, err = db.Exec("CREATE INDEX IF NOT EXISTS " + index)
+		if err != nil {
+			log.DefaultLogger.Error("FATAL. Could not create index "+index+":", err.Error())
+			panic(err)
+		}
+	}
+
+	err = createCascadeTriggers(db)
+	if err != nil {
+		log.DefaultLogger.Error("FATAL. Could not create cascade triggers:", err.Error())
+		panic(err)
+	}
+
+	// Requests to Grafana fail until the credentials are moved, which shouldn't stop the plugin starting
+	if err := datasource.migrateLegacyGrafanaURL(); err != nil {
+		log.DefaultLogger.Error("Init - migrateLegacyGrafanaURL: ", err.Error())
+	}
+	if err := datasource.sealAttachmentPasswords(); err != nil {
+		log.DefaultLogger.Error("Init - sealAttachmentPasswords: ", err.Error())
+	}
+
 	log.DefaultLogger.Info("Database initialized!")
 }
+
+// addColumn adds a column to a table created by an earlier version of the plugin.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so new columns
+// are added here instead and always appended after the original ones.
+func addColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		log.DefaultLogger.Error("addColumn: db.Query(): ", err.Error())
+		return err
+	}
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue interface{}
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey)
+		if err != nil {
+			rows.Close()
+			log.DefaultLogger.Error("addColumn: rows.Scan(): ", err.Error())
+			return err
+		}
+
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+
+	if exists {
+		return nil
+	}
+
+	log.DefaultLogger.Info("Adding column " + table + "." + column)
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.DefaultLogger.Error("addColumn: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,233 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Where a failed delivery was found out about
+const (
+	// The mail server rejected the recipient while the report was being sent
+	DeliverySourceSMTP = "smtp"
+	// A bounce message arrived in the mailbox reports are sent from
+	DeliverySourceIMAP = "imap"
+	// The email provider reported it
+	DeliverySourceWebhook = "webhook"
+)
+
+// DeliveryFailure is one report which didn't reach an address. Status is the enhanced status code, e.g. 5.1.1,
+// and Code the SMTP reply code, when they are known.
+type DeliveryFailure struct {
+	ID         string `json:"id"`
+	Timestamp  int    `json:"timestamp"`
+	Address    string `json:"address"`
+	ScheduleID string `json:"scheduleID"`
+	Source     string `json:"source"`
+	Code       int    `json:"code"`
+	Status     string `json:"status"`
+	Permanent  bool   `json:"permanent"`
+	Message    string `json:"message"`
+}
+
+func DeliveryFailureFields() string {
+	return "\n[{\n\taddress string" +
+		"\n\tcode int" +
+		"\n\tstatus string" +
+		"\n\tpermanent bool" +
+		"\n\tmessage string\n}]"
+}
+
+func (failure *DeliveryFailure) Validate() error {
+	if strings.TrimSpace(failure.Address) == "" {
+		return errors.New("address is required")
+	}
+
+	return nil
+}
+
+// BouncingRecipient is an address which has permanently failed, so reports sent to it aren't arriving
+type BouncingRecipient struct {
+	Address       string `json:"address"`
+	FirstFailedAt int    `json:"firstFailedAt"`
+	LastFailedAt  int    `json:"lastFailedAt"`
+	Failures      int    `json:"failures"`
+	LastStatus    string `json:"lastStatus"`
+	LastMessage   string `json:"lastMessage"`
+}
+
+type DeliveryProblemsPage struct {
+	Recipients []BouncingRecipient `json:"recipients"`
+	Total      int                 `json:"total"`
+	Offset     int                 `json:"offset"`
+	Limit      int                 `json:"limit"`
+}
+
+type DeliveryFailurePage struct {
+	Failures []DeliveryFailure `json:"failures"`
+	Total    int               `json:"total"`
+	Offset   int               `json:"offset"`
+	Limit    int               `json:"limit"`
+}
+
+const deliveryFailureColumns = "id, timestamp, address, scheduleID, source, code, status, permanent, message"
+
+// RecordDeliveryFailures saves the failures, marking the addresses of permanent ones as bouncing
+func (datasource *SQLiteDatasource) RecordDeliveryFailures(failures []DeliveryFailure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RecordDeliveryFailures: sql.Open(): ", err.Error())
+		return err
+	}
+
+	return retryBusy("RecordDeliveryFailures", func() error {
+		return recordDeliveryFailures(db, failures)
+	})
+}
+
+func recordDeliveryFailures(db *sql.DB, failures []DeliveryFailure) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("RecordDeliveryFailures: db.Begin(): ", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	now := int(time.Now().Unix())
+	for _, failure := range failures {
+		failure.ID = uuid.New().String()
+		failure.Address = strings.TrimSpace(failure.Address)
+		if failure.Timestamp == 0 {
+			failure.Timestamp = now
+		}
+
+		_, err = tx.Exec("INSERT INTO DeliveryFailure ("+deliveryFailureColumns+") VALUES (?,?,?,?,?,?,?,?,?)",
+			failure.ID, failure.Timestamp, failure.Address, failure.ScheduleID, failure.Source, failure.Code, failure.Status, failure.Permanent, failure.Message)
+		if err != nil {
+			log.DefaultLogger.Error("RecordDeliveryFailures: tx.Exec(): DeliveryFailure: ", err.Error())
+			return err
+		}
+
+		if !failure.Permanent {
+			continue
+		}
+		_, err = tx.Exec("INSERT INTO BouncingRecipient (address, firstFailedAt, lastFailedAt, failures, lastStatus, lastMessage) VALUES (?,?,?,1,?,?) "+
+			"ON CONFLICT(address) DO UPDATE SET lastFailedAt = MAX(lastFailedAt, excluded.lastFailedAt), failures = failures + 1, lastStatus = excluded.lastStatus, lastMessage = excluded.lastMessage",
+			failure.Address, failure.Timestamp, failure.Timestamp, failure.Status, failure.Message)
+		if err != nil {
+			log.DefaultLogger.Error("RecordDeliveryFailures: tx.Exec(): BouncingRecipient: ", err.Error())
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.DefaultLogger.Error("RecordDeliveryFailures: tx.Commit(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetDeliveryProblems returns a page of the bouncing addresses, most recently failed first
+func (datasource *SQLiteDatasource) GetDeliveryProblems(offset int, limit int) (*DeliveryProblemsPage, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryProblems: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	page := DeliveryProblemsPage{Recipients: []BouncingRecipient{}, Offset: offset, Limit: limit}
+	err = db.QueryRow("SELECT COUNT(*) FROM BouncingRecipient").Scan(&page.Total)
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryProblems: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT address, firstFailedAt, lastFailedAt, failures, lastStatus, lastMessage FROM BouncingRecipient ORDER BY lastFailedAt DESC, address LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryProblems: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var recipient BouncingRecipient
+		err = rows.Scan(&recipient.Address, &recipient.FirstFailedAt, &recipient.LastFailedAt, &recipient.Failures, &recipient.LastStatus, &recipient.LastMessage)
+		if err != nil {
+			log.DefaultLogger.Error("GetDeliveryProblems: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		page.Recipients = append(page.Recipients, recipient)
+	}
+
+	return &page, nil
+}
+
+// GetDeliveryFailures returns a page of the failed deliveries, to one address or any when it's empty, most recent first
+func (datasource *SQLiteDatasource) GetDeliveryFailures(address string, offset int, limit int) (*DeliveryFailurePage, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryFailures: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	where := " WHERE (? = '' OR address = ? COLLATE NOCASE)"
+	args := []interface{}{address, address}
+
+	page := DeliveryFailurePage{Failures: []DeliveryFailure{}, Offset: offset, Limit: limit}
+	err = db.QueryRow("SELECT COUNT(*) FROM DeliveryFailure"+where, args...).Scan(&page.Total)
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryFailures: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+deliveryFailureColumns+" FROM DeliveryFailure"+where+" ORDER BY timestamp DESC, rowid DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		log.DefaultLogger.Error("GetDeliveryFailures: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var failure DeliveryFailure
+		err = rows.Scan(&failure.ID, &failure.Timestamp, &failure.Address, &failure.ScheduleID, &failure.Source, &failure.Code, &failure.Status, &failure.Permanent, &failure.Message)
+		if err != nil {
+			log.DefaultLogger.Error("GetDeliveryFailures: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		page.Failures = append(page.Failures, failure)
+	}
+
+	return &page, nil
+}
+
+// ClearBouncing stops treating an address as bouncing, e.g. once the recipient's mailbox has been fixed.
+// Its failures are kept as history.
+func (datasource *SQLiteDatasource) ClearBouncing(address string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ClearBouncing: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM BouncingRecipient WHERE address = ?", address)
+	if err != nil {
+		log.DefaultLogger.Error("ClearBouncing: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package dbstore
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// DirectoryUser is an active mSupply user, copied from mSupply by the user sync so report groups can be
+// given members without querying mSupply each time
+type DirectoryUser struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	// When the user was last copied from mSupply, as unix seconds
+	SyncedAt int `json:"syncedAt"`
+}
+
+type DirectoryUserPage struct {
+	Users  []DirectoryUser `json:"users"`
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+}
+
+const directoryUserColumns = "id, name, firstName, lastName, email, syncedAt"
+
+// ReplaceDirectoryUsers replaces the directory with the users from the latest sync, so users who have been
+// deactivated in mSupply are removed
+func (datasource *SQLiteDatasource) ReplaceDirectoryUsers(users []DirectoryUser) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ReplaceDirectoryUsers: sql.Open(): ", err.Error())
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("ReplaceDirectoryUsers: db.Begin(): ", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM DirectoryUser")
+	if err != nil {
+		log.DefaultLogger.Error("ReplaceDirectoryUsers: tx.Exec(): DELETE: ", err.Error())
+		return err
+	}
+
+	syncedAt := int(time.Now().Unix())
+	for _, user := range users {
+		_, err = tx.Exec("INSERT INTO DirectoryUser ("+directoryUserColumns+") VALUES (?,?,?,?,?,?)", user.ID, user.Name, user.FirstName, user.LastName, user.Email, syncedAt)
+		if err != nil {
+			log.DefaultLogger.Error("ReplaceDirectoryUsers: tx.Exec(): INSERT: ", err.Error())
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.DefaultLogger.Error("ReplaceDirectoryUsers: tx.Commit(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for use with ESCAPE '\'
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// SearchDirectoryUsers returns a page of the users whose username, first or last name or email starts with
+// the prefix, ignoring case, sorted by username
+func (datasource *SQLiteDatasource) SearchDirectoryUsers(prefix string, offset int, limit int) (*DirectoryUserPage, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SearchDirectoryUsers: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	pattern := escapeLike(strings.TrimSpace(prefix)) + "%"
+	where := ` WHERE name LIKE ? ESCAPE '\' OR firstName LIKE ? ESCAPE '\' OR lastName LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`
+	args := []interface{}{pattern, pattern, pattern, pattern}
+
+	page := DirectoryUserPage{Users: []DirectoryUser{}, Offset: offset, Limit: limit}
+	err = db.QueryRow("SELECT COUNT(*) FROM DirectoryUser"+where, args...).Scan(&page.Total)
+	if err != nil {
+		log.DefaultLogger.Error("SearchDirectoryUsers: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+directoryUserColumns+" FROM DirectoryUser"+where+" ORDER BY name COLLATE NOCASE, id LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		log.DefaultLogger.Error("SearchDirectoryUsers: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user DirectoryUser
+		err = rows.Scan(&user.ID, &user.Name, &user.FirstName, &user.LastName, &user.Email, &user.SyncedAt)
+		if err != nil {
+			log.DefaultLogger.Error("SearchDirectoryUsers: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		page.Users = append(page.Users, user)
+	}
+
+	return &page, nil
+}
@@ -0,0 +1,131 @@
+package dbstore
+
+// Where an effective setting's value came from, from least to most specific
+const (
+	SettingSourceDefault      = "default"
+	SettingSourceOrganization = "organization"
+	SettingSourceSchedule     = "schedule"
+	SettingSourceContent      = "content"
+)
+
+// Built in defaults, used when neither the organisation nor the schedule sets a value
+const (
+	DefaultRenderTheme = "light"
+	DefaultLocale      = "en"
+	DefaultCatchUp     = CatchUpOnce
+	// Most seconds a content item can allow its panels when the settings don't say
+	DefaultMaxQueryTimeout = 10 * 60
+)
+
+// EffectiveSettings are the options a report is generated with once the organisation defaults,
+// schedule and content overrides have been layered. Sources records the layer each came from.
+type EffectiveSettings struct {
+	RenderWidth  int     `json:"renderWidth"`
+	RenderHeight int     `json:"renderHeight"`
+	RenderScale  float64 `json:"renderScale"`
+	RenderTheme  string  `json:"renderTheme"`
+	Locale       string  `json:"locale"`
+	// Times a failing report is retried before it is skipped until the next time it is due, unset retries until
+	// it's sent
+	MaxRetries RetryLimit `json:"maxRetries"`
+	// What the scheduler does with runs missed while Grafana was down
+	CatchUp string `json:"catchUp"`
+	// Seconds allowed for each panel query and render, 0 uses the renderer's default
+	QueryTimeout int `json:"queryTimeout"`
+	// Who the report's emails are from and where replies go, empty for the bare sending address
+	FromName     string            `json:"fromName"`
+	ReplyTo      string            `json:"replyTo"`
+	EmailHeaders []EmailHeader     `json:"emailHeaders"`
+	Sources      map[string]string `json:"sources"`
+}
+
+// layerHeaders merges headers over those from the layers below, as each layer may add its own
+func (effective *EffectiveSettings) layerHeaders(source string, text string) {
+	headers, _ := ParseEmailHeaders(text)
+	if len(headers) > 0 {
+		effective.EmailHeaders = MergeEmailHeaders(effective.EmailHeaders, headers)
+		effective.Sources["emailHeaders"] = source
+	}
+}
+
+func (effective *EffectiveSettings) layerInt(name string, target *int, source string, value int) {
+	if value > 0 {
+		*target = value
+		effective.Sources[name] = source
+	}
+}
+
+func (effective *EffectiveSettings) layerRetries(name string, target *RetryLimit, source string, value RetryLimit) {
+	if value.Set {
+		*target = value
+		effective.Sources[name] = source
+	}
+}
+
+func (effective *EffectiveSettings) layerFloat(name string, target *float64, source string, value float64) {
+	if value > 0 {
+		*target = value
+		effective.Sources[name] = source
+	}
+}
+
+func (effective *EffectiveSettings) layerString(name string, target *string, source string, value string) {
+	if value != "" {
+		*target = value
+		effective.Sources[name] = source
+	}
+}
+
+// ResolveSettings layers the organisation defaults, then the schedule, then the content item if there is one
+func ResolveSettings(settings *Settings, schedule Schedule, content *ReportContent) EffectiveSettings {
+	effective := EffectiveSettings{RenderTheme: DefaultRenderTheme, Locale: DefaultLocale, CatchUp: DefaultCatchUp, EmailHeaders: []EmailHeader{}, Sources: map[string]string{}}
+	for _, name := range []string{"renderWidth", "renderHeight", "renderScale", "renderTheme", "locale", "maxRetries", "catchUp", "fromName", "replyTo", "emailHeaders", "queryTimeout"} {
+		effective.Sources[name] = SettingSourceDefault
+	}
+
+	if settings != nil {
+		effective.layerInt("renderWidth", &effective.RenderWidth, SettingSourceOrganization, settings.DefaultRenderWidth)
+		effective.layerInt("renderHeight", &effective.RenderHeight, SettingSourceOrganization, settings.DefaultRenderHeight)
+		effective.layerFloat("renderScale", &effective.RenderScale, SettingSourceOrganization, settings.DefaultRenderScale)
+		effective.layerString("renderTheme", &effective.RenderTheme, SettingSourceOrganization, settings.DefaultRenderTheme)
+		effective.layerString("locale", &effective.Locale, SettingSourceOrganization, settings.DefaultLocale)
+		effective.layerRetries("maxRetries", &effective.MaxRetries, SettingSourceOrganization, settings.DefaultMaxRetries)
+		effective.layerString("catchUp", &effective.CatchUp, SettingSourceOrganization, settings.DefaultCatchUp)
+		effective.layerString("fromName", &effective.FromName, SettingSourceOrganization, settings.EmailFromName)
+		effective.layerString("replyTo", &effective.ReplyTo, SettingSourceOrganization, settings.EmailReplyTo)
+		effective.layerHeaders(SettingSourceOrganization, settings.EmailHeaders)
+		effective.layerInt("queryTimeout", &effective.QueryTimeout, SettingSourceOrganization, settings.RenderTimeout)
+	}
+
+	effective.layerInt("renderWidth", &effective.RenderWidth, SettingSourceSchedule, schedule.RenderWidth)
+	effective.layerInt("renderHeight", &effective.RenderHeight, SettingSourceSchedule, schedule.RenderHeight)
+	effective.layerFloat("renderScale", &effective.RenderScale, SettingSourceSchedule, schedule.RenderScale)
+	effective.layerString("renderTheme", &effective.RenderTheme, SettingSourceSchedule, schedule.RenderTheme)
+	effective.layerString("locale", &effective.Locale, SettingSourceSchedule, schedule.Locale)
+	effective.layerRetries("maxRetries", &effective.MaxRetries, SettingSourceSchedule, schedule.MaxRetries)
+	effective.layerString("catchUp", &effective.CatchUp, SettingSourceSchedule, schedule.CatchUp)
+	effective.layerString("fromName", &effective.FromName, SettingSourceSchedule, schedule.FromName)
+	effective.layerString("replyTo", &effective.ReplyTo, SettingSourceSchedule, schedule.ReplyTo)
+	effective.layerHeaders(SettingSourceSchedule, schedule.EmailHeaders)
+
+	if content != nil {
+		effective.layerInt("renderWidth", &effective.RenderWidth, SettingSourceContent, content.RenderWidth)
+		effective.layerInt("renderHeight", &effective.RenderHeight, SettingSourceContent, content.RenderHeight)
+		effective.layerFloat("renderScale", &effective.RenderScale, SettingSourceContent, content.RenderScale)
+		effective.layerString("renderTheme", &effective.RenderTheme, SettingSourceContent, content.RenderTheme)
+		effective.layerInt("queryTimeout", &effective.QueryTimeout, SettingSourceContent, content.QueryTimeout)
+		if maximum := MaxQueryTimeout(settings); content.QueryTimeout > maximum {
+			effective.QueryTimeout = maximum
+		}
+	}
+
+	return effective
+}
+
+// MaxQueryTimeout is the most seconds a content item can allow its panels' queries and renders
+func MaxQueryTimeout(settings *Settings) int {
+	if settings == nil || settings.MaxQueryTimeout <= 0 {
+		return DefaultMaxQueryTimeout
+	}
+	return settings.MaxQueryTimeout
+}
@@ -0,0 +1,116 @@
+package dbstore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Longest a domain's mail servers are looked up for before the address is accepted anyway
+const domainLookupTimeout = 5 * time.Second
+
+// FieldError is a problem with one field of a request, which the frontend shows against that field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type FieldErrors []FieldError
+
+func (errs FieldErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Field+": "+err.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// CheckEmailSyntax checks an address is a bare email address, without a display name, at a domain
+func CheckEmailSyntax(address string) error {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address {
+		return errors.New("is not a valid email address")
+	}
+
+	domain := address[strings.LastIndex(address, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return errors.New("is not at a valid domain")
+	}
+
+	return nil
+}
+
+// CheckEmailDomain checks the address's domain has a mail server, or an address to deliver to when it has none.
+// Lookups which fail, rather than finding the domain doesn't exist, don't reject the address as it may be fine.
+func CheckEmailDomain(ctx context.Context, address string) error {
+	domain := address[strings.LastIndex(address, "@")+1:]
+
+	ctx, cancel := context.WithTimeout(ctx, domainLookupTimeout)
+	defer cancel()
+
+	records, err := net.DefaultResolver.LookupMX(ctx, domain)
+	if err == nil && len(records) > 0 {
+		// A single "." is a null MX, where the domain declares it never receives mail
+		if len(records) == 1 && records[0].Host == "." {
+			return errors.New("is at " + domain + " which doesn't accept email")
+		}
+		return nil
+	}
+	if err != nil && !isNotFound(err) {
+		log.DefaultLogger.Warn("CheckEmailDomain: LookupMX: " + err.Error())
+		return nil
+	}
+
+	hosts, err := net.DefaultResolver.LookupHost(ctx, domain)
+	if err == nil && len(hosts) > 0 {
+		return nil
+	}
+	if err != nil && !isNotFound(err) {
+		log.DefaultLogger.Warn("CheckEmailDomain: LookupHost: " + err.Error())
+		return nil
+	}
+
+	return errors.New("is at " + domain + " which has no mail server")
+}
+
+func isNotFound(err error) bool {
+	var dnsError *net.DNSError
+	return errors.As(err, &dnsError) && dnsError.IsNotFound
+}
+
+// CheckEmailAddresses checks the address of each field, skipping empty ones, returning FieldErrors for those which
+// aren't valid or nil when they all are. Their domains are only looked up when verifyDomains is set.
+func CheckEmailAddresses(ctx context.Context, addresses map[string]string, verifyDomains bool) error {
+	fields := make([]string, 0, len(addresses))
+	for field := range addresses {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var errs FieldErrors
+	for _, field := range fields {
+		address := strings.TrimSpace(addresses[field])
+		if address == "" {
+			continue
+		}
+
+		err := CheckEmailSyntax(address)
+		if err == nil && verifyDomains {
+			err = CheckEmailDomain(ctx, address)
+		}
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: address + " " + err.Error()})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
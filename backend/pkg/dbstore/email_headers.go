@@ -0,0 +1,74 @@
+package dbstore
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// EmailHeader is a header added to every report email, e.g. X-Program: Immunisation
+type EmailHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var headerName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// Headers the emailer sets itself, which custom headers can't replace
+var reservedHeaders = map[string]bool{
+	"from": true, "to": true, "cc": true, "bcc": true, "subject": true, "reply-to": true, "sender": true,
+	"date": true, "message-id": true, "mime-version": true, "content-type": true, "content-transfer-encoding": true,
+	"list-unsubscribe": true, "return-path": true,
+}
+
+// ParseEmailHeaders reads custom headers written one a line as "Name: value", skipping blank lines
+func ParseEmailHeaders(text string) ([]EmailHeader, error) {
+	headers := []EmailHeader{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, errors.New("header '" + line + "' must be written as Name: value")
+		}
+		header := EmailHeader{Name: strings.TrimSpace(line[:colon]), Value: strings.TrimSpace(line[colon+1:])}
+		if !headerName.MatchString(header.Name) {
+			return nil, errors.New("'" + header.Name + "' is not a valid header name")
+		}
+		if reservedHeaders[strings.ToLower(header.Name)] {
+			return nil, errors.New(header.Name + " is set by the emailer and can't be a custom header")
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// MergeEmailHeaders is the base headers with any of the same name replaced by the overrides, followed by the rest
+// of the overrides
+func MergeEmailHeaders(base []EmailHeader, overrides []EmailHeader) []EmailHeader {
+	merged := []EmailHeader{}
+	overridden := make(map[string]bool)
+	for _, header := range overrides {
+		overridden[strings.ToLower(header.Name)] = true
+	}
+	for _, header := range base {
+		if !overridden[strings.ToLower(header.Name)] {
+			merged = append(merged, header)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+// checkFromName checks a display name for the From header can't break out of it
+func checkFromName(name string) error {
+	if strings.ContainsAny(name, "\r\n") {
+		return errors.New("can't span more than one line")
+	}
+	if len(name) > 100 {
+		return errors.New("can be at most 100 characters")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+var ErrEmailProfileInUse = errors.New("schedules still send with this email profile, move them to another first")
+
+// The profile made from the email account in the settings, which schedules without a profile of their own send with
+const (
+	DefaultEmailProfileID   = "default"
+	DefaultEmailProfileName = "Default"
+)
+
+// EmailProfile is a named mail account and server, so programs can send their reports from their own address.
+// Empty sender fields fall back to the settings.
+type EmailProfile struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"emailPassword,omitempty"`
+	Host     string `json:"emailHost"`
+	Port     int    `json:"emailPort"`
+	FromName string `json:"fromName"`
+	ReplyTo  string `json:"replyTo"`
+	// Whether a password is saved, as it's never sent back
+	HasPassword bool `json:"hasPassword"`
+}
+
+func EmailProfileFields() string {
+	return "\n{\n\tname string" +
+		"\n\temail string" +
+		"\n\temailPassword string (empty keeps the saved one)" +
+		"\n\temailHost string" +
+		"\n\temailPort int" +
+		"\n\tfromName string" +
+		"\n\treplyTo string\n}"
+}
+
+func (profile *EmailProfile) Validate() error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	if profile.Name == "" {
+		return errors.New("name is required")
+	}
+	if strings.EqualFold(profile.Name, DefaultEmailProfileName) {
+		return errors.New(DefaultEmailProfileName + " is the name of the profile from the settings")
+	}
+	if err := CheckEmailSyntax(profile.Email); err != nil {
+		return errors.New("email " + err.Error())
+	}
+	if strings.TrimSpace(profile.Host) == "" {
+		return errors.New("emailHost is required")
+	}
+	if profile.Port <= 0 || profile.Port > 65535 {
+		return errors.New("emailPort must be between 1 and 65535")
+	}
+	if err := checkFromName(profile.FromName); err != nil {
+		return errors.New("fromName " + err.Error())
+	}
+	if profile.ReplyTo != "" {
+		if err := CheckEmailSyntax(profile.ReplyTo); err != nil {
+			return errors.New("replyTo " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Apply is the settings with the profile's account, and its sender where it has one, in place of their own
+func (profile *EmailProfile) Apply(settings Settings) Settings {
+	settings.Email = profile.Email
+	settings.EmailPassword = profile.Password
+	settings.EmailHost = profile.Host
+	settings.EmailPort = profile.Port
+	if profile.FromName != "" {
+		settings.EmailFromName = profile.FromName
+	}
+	if profile.ReplyTo != "" {
+		settings.EmailReplyTo = profile.ReplyTo
+	}
+	return settings
+}
+
+// defaultEmailProfile is the profile of the email account in the settings
+func defaultEmailProfile(settings *Settings) EmailProfile {
+	return EmailProfile{ID: DefaultEmailProfileID, Name: DefaultEmailProfileName, Email: settings.Email, Password: settings.EmailPassword, Host: settings.EmailHost, Port: settings.EmailPort, FromName: settings.EmailFromName, ReplyTo: settings.EmailReplyTo}
+}
+
+const emailProfileColumns = "id, name, email, password, host, port, fromName, replyTo"
+
+// GetEmailProfiles is every profile, the default first and the rest by name, without their passwords
+func (datasource *SQLiteDatasource) GetEmailProfiles() ([]EmailProfile, error) {
+	settings, err := datasource.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("GetEmailProfiles: GetSettings(): ", err.Error())
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetEmailProfiles: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + emailProfileColumns + " FROM EmailProfile ORDER BY name")
+	if err != nil {
+		log.DefaultLogger.Error("GetEmailProfiles: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []EmailProfile{defaultEmailProfile(settings)}
+	for rows.Next() {
+		var profile EmailProfile
+		err = rows.Scan(&profile.ID, &profile.Name, &profile.Email, &profile.Password, &profile.Host, &profile.Port, &profile.FromName, &profile.ReplyTo)
+		if err != nil {
+			log.DefaultLogger.Error("GetEmailProfiles: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+
+	for i := range profiles {
+		profiles[i].HasPassword = profiles[i].Password != ""
+		profiles[i].Password = ""
+	}
+	return profiles, nil
+}
+
+// GetEmailProfile is a profile with its password, for sending with
+func (datasource *SQLiteDatasource) GetEmailProfile(id string) (*EmailProfile, error) {
+	if id == "" || id == DefaultEmailProfileID {
+		settings, err := datasource.GetSettings()
+		if err != nil {
+			log.DefaultLogger.Error("GetEmailProfile: GetSettings(): ", err.Error())
+			return nil, err
+		}
+		profile := defaultEmailProfile(settings)
+		return &profile, nil
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetEmailProfile: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var profile EmailProfile
+	err = db.QueryRow("SELECT "+emailProfileColumns+" FROM EmailProfile WHERE id = ?", id).Scan(&profile.ID, &profile.Name, &profile.Email, &profile.Password, &profile.Host, &profile.Port, &profile.FromName, &profile.ReplyTo)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("there is no email profile " + id)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetEmailProfile: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	profile.HasPassword = profile.Password != ""
+	return &profile, nil
+}
+
+func (datasource *SQLiteDatasource) CreateEmailProfile(profile EmailProfile) (*EmailProfile, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateEmailProfile: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	profile.ID = uuid.New().String()
+	_, err = db.Exec("INSERT INTO EmailProfile ("+emailProfileColumns+") VALUES (?,?,?,?,?,?,?,?)", profile.ID, profile.Name, profile.Email, profile.Password, profile.Host, profile.Port, profile.FromName, profile.ReplyTo)
+	if err != nil {
+		log.DefaultLogger.Error("CreateEmailProfile: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	profile.HasPassword = profile.Password != ""
+	return &profile, nil
+}
+
+// UpdateEmailProfile saves a profile, keeping its password when it's sent without one
+func (datasource *SQLiteDatasource) UpdateEmailProfile(id string, profile EmailProfile) (*EmailProfile, error) {
+	existing, err := datasource.GetEmailProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Password == "" {
+		profile.Password = existing.Password
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UpdateEmailProfile: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	profile.ID = id
+	_, err = db.Exec("UPDATE EmailProfile SET name = ?, email = ?, password = ?, host = ?, port = ?, fromName = ?, replyTo = ? WHERE id = ?", profile.Name, profile.Email, profile.Password, profile.Host, profile.Port, profile.FromName, profile.ReplyTo, id)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateEmailProfile: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	profile.HasPassword = profile.Password != ""
+	return &profile, nil
+}
+
+// DeleteEmailProfile deletes a profile no schedule sends with any more
+func (datasource *SQLiteDatasource) DeleteEmailProfile(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteEmailProfile: sql.Open(): ", err.Error())
+		return err
+	}
+
+	var inUse int
+	err = db.QueryRow("SELECT COUNT(*) FROM Schedule WHERE emailProfileID = ?", id).Scan(&inUse)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteEmailProfile: db.QueryRow(): ", err.Error())
+		return err
+	}
+	if inUse > 0 {
+		return ErrEmailProfileInUse
+	}
+
+	_, err = db.Exec("DELETE FROM EmailProfile WHERE id = ?", id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteEmailProfile: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
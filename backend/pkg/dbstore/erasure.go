@@ -0,0 +1,575 @@
+package dbstore
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How a data subject's personal data is erased
+const (
+	// Delete every record about them, redacting them from records which belong to others such as the changes
+	// audited of other people
+	ErasureModePurge = "purge"
+	// Delete what's only needed to reach them, replacing them with a pseudonym everywhere else
+	// so history such as the audit log still hangs together
+	ErasureModeAnonymize = "anonymize"
+)
+
+// Written in place of a purged data subject in records which are kept
+const redacted = "[redacted]"
+
+// ErasureRequest identifies a person by any of their email address, Grafana user ID and Grafana login
+type ErasureRequest struct {
+	Email  string `json:"email"`
+	UserID string `json:"userID"`
+	Login  string `json:"login"`
+	Mode   string `json:"mode"`
+	// Reports what would be erased without changing anything
+	DryRun bool `json:"dryRun"`
+}
+
+func ErasureRequestFields() string {
+	return "\n{\n\temail string" +
+		"\n\tuserID string" +
+		"\n\tlogin string" +
+		"\n\tmode string (purge|anonymize)" +
+		"\n\tdryRun bool\n}"
+}
+
+func (request *ErasureRequest) Validate() error {
+	request.Email = strings.TrimSpace(request.Email)
+	request.UserID = strings.TrimSpace(request.UserID)
+	request.Login = strings.TrimSpace(request.Login)
+
+	if request.Email == "" && request.UserID == "" && request.Login == "" {
+		return errors.New("one of email, userID or login is required")
+	}
+	if request.Email != "" && !strings.Contains(request.Email, "@") {
+		return errors.New("email is not an email address")
+	}
+	switch request.Mode {
+	case ErasureModePurge, ErasureModeAnonymize:
+	default:
+		return errors.New("mode must be one of: purge, anonymize")
+	}
+
+	return nil
+}
+
+// Pseudonym stands in for the data subject, the same for every request about them so their anonymized
+// records can still be related to each other
+func (request *ErasureRequest) Pseudonym() string {
+	sum := sha256.Sum256([]byte(strings.ToLower(request.Email) + "|" + request.UserID + "|" + request.Login))
+	return "subject-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// ErasureChange is what was done to one table
+type ErasureChange struct {
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	Rows   int64  `json:"rows"`
+}
+
+// ErasureReport lists what was erased, without any of the personal data itself
+type ErasureReport struct {
+	Subject  string          `json:"subject"`
+	Mode     string          `json:"mode"`
+	DryRun   bool            `json:"dryRun"`
+	ErasedAt int             `json:"erasedAt"`
+	Changes  []ErasureChange `json:"changes"`
+}
+
+type erasure struct {
+	tx     *sql.Tx
+	report *ErasureReport
+	// Erasing a backup, which may have been taken before some of the tables or columns were added
+	backup bool
+}
+
+func (e *erasure) exec(table string, action string, query string, args ...interface{}) error {
+	result, err := e.tx.Exec(query, args...)
+	if err != nil && e.backup && isMissingSchema(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.New(table + ": " + err.Error())
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return errors.New(table + ": " + err.Error())
+	}
+
+	e.report.count(table, action, rows)
+	return nil
+}
+
+func (report *ErasureReport) count(table string, action string, rows int64) {
+	for i, change := range report.Changes {
+		if change.Table == table && change.Action == action {
+			report.Changes[i].Rows += rows
+			return
+		}
+	}
+	report.Changes = append(report.Changes, ErasureChange{Table: table, Action: action, Rows: rows})
+}
+
+func isMissingSchema(err error) bool {
+	return strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "no such column")
+}
+
+// erasureIdentifier is text which identifies the data subject in records such as run messages and audited changes,
+// and what it's replaced with
+type erasureIdentifier struct {
+	text  string
+	value string
+}
+
+// redactText replaces an identifier in a table's text columns. SQL only finds the rows it might be in, whether it's
+// the whole of an address or login rather than part of a longer one, e.g. jo@x.org in mojo@x.org, is checked here.
+func (e *erasure) redactText(table string, columns []string, identifier erasureIdentifier) error {
+	var conditions []string
+	var args []interface{}
+	for _, column := range columns {
+		conditions = append(conditions, "instr(lower("+column+"), ?) > 0")
+		args = append(args, lowerASCII(identifier.text))
+	}
+
+	rows, err := e.tx.Query("SELECT id, "+strings.Join(columns, ", ")+" FROM "+table+" WHERE "+strings.Join(conditions, " OR "), args...)
+	if err != nil && e.backup && isMissingSchema(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.New(table + ": " + err.Error())
+	}
+
+	type redaction struct {
+		id     string
+		values []interface{}
+	}
+	var redactions []redaction
+	for rows.Next() {
+		var id string
+		texts := make([]sql.NullString, len(columns))
+		fields := []interface{}{&id}
+		for i := range texts {
+			fields = append(fields, &texts[i])
+		}
+		if err := rows.Scan(fields...); err != nil {
+			rows.Close()
+			return errors.New(table + ": " + err.Error())
+		}
+
+		redacted := redaction{id: id, values: make([]interface{}, len(columns))}
+		replaced := 0
+		for i, text := range texts {
+			if !text.Valid {
+				continue
+			}
+			value, count := replaceWhole(text.String, identifier.text, identifier.value)
+			redacted.values[i] = value
+			replaced += count
+		}
+		if replaced > 0 {
+			redactions = append(redactions, redacted)
+		}
+	}
+	rows.Close()
+
+	var assignments []string
+	for _, column := range columns {
+		assignments = append(assignments, column+" = ?")
+	}
+	for _, redacted := range redactions {
+		_, err := e.tx.Exec("UPDATE "+table+" SET "+strings.Join(assignments, ", ")+" WHERE id = ?", append(redacted.values, redacted.id)...)
+		if err != nil {
+			return errors.New(table + ": " + err.Error())
+		}
+	}
+
+	e.report.count(table, "redacted", int64(len(redactions)))
+	return nil
+}
+
+// isAddressCharacter is whether a character can be part of an email address or login, so text next to one is part
+// of a longer address or login
+func isAddressCharacter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._%+-@", c) >= 0
+}
+
+func lowerASCII(text string) string {
+	lower := []byte(text)
+	for i, c := range lower {
+		if c >= 'A' && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	return string(lower)
+}
+
+// replaceWhole replaces, ignoring case, the occurrences of an identifier in text which aren't part of a longer
+// address or login, returning how many there were. A full stop after one is taken to end the sentence.
+func replaceWhole(text string, identifier string, value string) (string, int) {
+	lowerText := lowerASCII(text)
+	target := lowerASCII(identifier)
+	if target == "" {
+		return text, 0
+	}
+
+	var replaced strings.Builder
+	count, last := 0, 0
+	for i := 0; i < len(text); {
+		found := strings.Index(lowerText[i:], target)
+		if found < 0 {
+			break
+		}
+		start, end := i+found, i+found+len(target)
+
+		whole := start == 0 || !isAddressCharacter(text[start-1])
+		whole = whole && (end == len(text) || !isAddressCharacter(text[end]) ||
+			text[end] == '.' && (end+1 == len(text) || !isAddressCharacter(text[end+1])))
+		if !whole {
+			i = start + 1
+			continue
+		}
+
+		replaced.WriteString(text[last:start])
+		replaced.WriteString(value)
+		last, i = end, end
+		count++
+	}
+	if count == 0 {
+		return text, 0
+	}
+
+	replaced.WriteString(text[last:])
+	return replaced.String(), count
+}
+
+// EraseDataSubject removes or anonymizes a person's personal data, in the database and its backups and in the run
+// archive, reports and shared copies of them on disk. Nothing is changed for a dry run. Their contacts, memberships and
+// shares are always deleted, as they only exist to send them reports or give them access.
+func (datasource *SQLiteDatasource) EraseDataSubject(request ErasureRequest, backupDirectory string) (*ErasureReport, error) {
+	report := ErasureReport{Subject: request.Pseudonym(), Mode: request.Mode, DryRun: request.DryRun, ErasedAt: int(time.Now().Unix()), Changes: []ErasureChange{}}
+
+	identifiers, err := eraseDatabase(datasource.Path, request, &report, false)
+	if err != nil {
+		log.DefaultLogger.Error("EraseDataSubject: ", err.Error())
+		return nil, err
+	}
+
+	directories := map[string]string{
+		"archive": datasource.ArchiveDirectory(),
+		"reports": filepath.Join(datasource.DataDirectory(), ReportsDirectory),
+		"shared":  filepath.Join(datasource.DataDirectory(), sharedDirectory),
+	}
+	for name, directory := range directories {
+		if err := eraseFiles(name, directory, identifiers, &report); err != nil {
+			log.DefaultLogger.Error("EraseDataSubject: ", err.Error())
+			return nil, err
+		}
+	}
+
+	backups, err := datasource.GetBackups(backupDirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if err := eraseBackup(filepath.Join(backupDirectory, backup.Name), request, &report); err != nil {
+			log.DefaultLogger.Error("EraseDataSubject: "+backup.Name+": ", err.Error())
+			return nil, err
+		}
+	}
+
+	return &report, nil
+}
+
+// eraseBackup erases the data subject from a backup as from the database, keeping the time it was taken
+func eraseBackup(path string, request ErasureRequest, report *ErasureReport) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	backupReport := ErasureReport{Subject: report.Subject, Mode: report.Mode, DryRun: report.DryRun}
+	if _, err := eraseDatabase(path, request, &backupReport, true); err != nil {
+		return err
+	}
+
+	erased := false
+	for _, change := range backupReport.Changes {
+		erased = erased || change.Rows > 0
+	}
+	if !erased {
+		return nil
+	}
+
+	report.count("backups", "redacted", 1)
+	if request.DryRun {
+		return nil
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
+
+// eraseFiles redacts the data subject from the files in a directory. Files which can't be rewritten, such as
+// workbooks, are deleted instead, reports are written again by the schedule's next run. Encrypted files can't be
+// searched, and are left as they are.
+func eraseFiles(name string, directory string, identifiers []erasureIdentifier, report *ErasureReport) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+			if !zipMentions(data, identifiers) {
+				return nil
+			}
+			report.count(name, "deleted", 1)
+			if report.DryRun {
+				return nil
+			}
+			return os.Remove(path)
+		}
+
+		text, replaced := string(data), 0
+		for _, identifier := range identifiers {
+			var count int
+			text, count = replaceWhole(text, identifier.text, identifier.value)
+			replaced += count
+		}
+		if replaced == 0 {
+			return nil
+		}
+		report.count(name, "redacted", 1)
+		if report.DryRun {
+			return nil
+		}
+		return ioutil.WriteFile(path, []byte(text), info.Mode())
+	})
+}
+
+// zipMentions is whether any file in a zip, such as a workbook or presentation, mentions the data subject
+func zipMentions(data []byte, identifiers []erasureIdentifier) bool {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			continue
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			continue
+		}
+		for _, identifier := range identifiers {
+			if _, count := replaceWhole(string(content), identifier.text, ""); count > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// eraseDatabase erases the data subject from a database in a single transaction, which is rolled back for a dry run,
+// returning the text which identifies them for erasing them from files. Secure delete overwrites what's deleted, so
+// it isn't left in the database file.
+func eraseDatabase(path string, request ErasureRequest, report *ErasureReport, backup bool) ([]erasureIdentifier, error) {
+	db, err := sql.Open("sqlite3", path+"?_secure_delete=on")
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("eraseDatabase: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("eraseDatabase: db.Begin(): ", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	e := erasure{tx: tx, report: report, backup: backup}
+
+	replacement := redacted
+	if request.Mode == ErasureModeAnonymize {
+		replacement = report.Subject
+	}
+
+	// Every address of the person the email belongs to is erased, not just the one given
+	emails := make(map[string]bool)
+	if request.Email != "" {
+		emails[request.Email] = true
+		emails[strings.ToLower(request.Email)] = true
+
+		rows, err := tx.Query("SELECT address FROM Contact WHERE personID IN (SELECT personID FROM Contact WHERE address = ? COLLATE NOCASE)", request.Email)
+		if err != nil && !(backup && isMissingSchema(err)) {
+			log.DefaultLogger.Error("eraseDatabase: tx.Query(): Contact: ", err.Error())
+			return nil, err
+		}
+		for err == nil && rows.Next() {
+			var address string
+			if err := rows.Scan(&address); err != nil {
+				rows.Close()
+				log.DefaultLogger.Error("eraseDatabase: rows.Scan(): Contact: ", err.Error())
+				return nil, err
+			}
+			emails[address] = true
+		}
+		if err == nil {
+			rows.Close()
+		}
+
+		err = e.exec("Contact", "deleted", "DELETE FROM Contact WHERE personID IN (SELECT personID FROM Contact WHERE address = ? COLLATE NOCASE)", request.Email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Addresses are matched ignoring case, so each is only looked for once
+	var identifiers []erasureIdentifier
+	found := make(map[string]bool)
+	for email := range emails {
+		if !found[lowerASCII(email)] {
+			found[lowerASCII(email)] = true
+			identifiers = append(identifiers, erasureIdentifier{text: email, value: replacement})
+		}
+	}
+	if request.UserID != "" {
+		identifiers = append(identifiers, erasureIdentifier{text: `"userID":"` + request.UserID + `"`, value: `"userID":"` + replacement + `"`})
+	}
+	// Logins are only looked for as JSON values, e.g. a schedule's owner in an audited change, as a word in a message
+	// could be anything
+	if request.Login != "" {
+		identifiers = append(identifiers, erasureIdentifier{text: `:"` + request.Login + `"`, value: `:"` + replacement + `"`})
+	}
+
+	if request.UserID != "" {
+		err = e.exec("ReportGroupMembership", "deleted", "DELETE FROM ReportGroupMembership WHERE userID = ?", request.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for email := range emails {
+		steps := []struct{ table, action, query string }{
+			// The copy of the mSupply user, which comes back with the next sync unless they're removed from mSupply too
+			{"DirectoryUser", "deleted", "DELETE FROM DirectoryUser WHERE email = ? COLLATE NOCASE"},
+			{"DeliveryFailure", "deleted", "DELETE FROM DeliveryFailure WHERE address = ? COLLATE NOCASE"},
+			{"BouncingRecipient", "deleted", "DELETE FROM BouncingRecipient WHERE address = ?"},
+			{"Outbox", "deleted", "DELETE FROM Outbox WHERE address = ? COLLATE NOCASE"},
+			{"Unsubscribe", "deleted", "DELETE FROM Unsubscribe WHERE address = ?"},
+		}
+		for _, step := range steps {
+			if err := e.exec(step.table, step.action, step.query, email); err != nil {
+				return nil, err
+			}
+		}
+
+		// Kept under the pseudonym, so the schedule's acknowledgement rate doesn't change. One shared placeholder
+		// would merge the people erased from the same run.
+		err = e.exec("Acknowledgement", "redacted", "UPDATE OR REPLACE Acknowledgement SET address = ? WHERE address = ?", report.Subject, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if request.UserID != "" {
+		err = e.exec("DirectoryUser", "deleted", "DELETE FROM DirectoryUser WHERE id = ?", request.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if request.Login != "" {
+		steps := []struct{ table, action, query string }{
+			{"ScheduleShare", "deleted", "DELETE FROM ScheduleShare WHERE login = ?"},
+			{"ScheduleShare", "redacted", "UPDATE ScheduleShare SET sharedBy = ? WHERE sharedBy = ?"},
+			{"Schedule", "redacted", "UPDATE Schedule SET owner = ? WHERE owner = ?"},
+			{"Schedule", "redacted", "UPDATE Schedule SET deletedBy = ? WHERE deletedBy = ?"},
+			{"ReportGroup", "redacted", "UPDATE ReportGroup SET deletedBy = ? WHERE deletedBy = ?"},
+			// Deleting their permission could leave a group with none, which would open it to everyone
+			{"ReportGroupPermission", "redacted", "UPDATE OR REPLACE ReportGroupPermission SET grantee = ? WHERE granteeType = '" + GranteeUser + "' AND grantee = ?"},
+			{"ReportGroupPermission", "redacted", "UPDATE ReportGroupPermission SET grantedBy = ? WHERE grantedBy = ?"},
+			{"ReportTemplate", "redacted", "UPDATE ReportTemplate SET owner = ? WHERE owner = ?"},
+			{"ReportTemplateVersion", "redacted", "UPDATE ReportTemplateVersion SET createdBy = ? WHERE createdBy = ?"},
+			{"Job", "redacted", "UPDATE Job SET requestedBy = ? WHERE requestedBy = ?"},
+			{"ShareLink", "redacted", "UPDATE ShareLink SET createdBy = ? WHERE createdBy = ?"},
+			{"ShareLink", "redacted", "UPDATE ShareLink SET revokedBy = ? WHERE revokedBy = ?"},
+			{"SettingsLock", "redacted", "UPDATE SettingsLock SET lockedBy = ? WHERE lockedBy = ?"},
+			{"Branding", "redacted", "UPDATE Branding SET updatedBy = ? WHERE updatedBy = ?"},
+			{"Favorite", "deleted", "DELETE FROM Favorite WHERE login = ?"},
+			{"RecentItem", "deleted", "DELETE FROM RecentItem WHERE login = ?"},
+			{"UserTeams", "deleted", "DELETE FROM UserTeams WHERE login = ?"},
+			{"CalendarFeedToken", "deleted", "DELETE FROM CalendarFeedToken WHERE login = ?"},
+		}
+		if request.Mode == ErasureModePurge {
+			steps = append(steps,
+				struct{ table, action, query string }{"Comment", "deleted", "DELETE FROM Comment WHERE author = ?"},
+				struct{ table, action, query string }{"AuditLog", "deleted", "DELETE FROM AuditLog WHERE actor = ?"})
+		} else {
+			steps = append(steps,
+				struct{ table, action, query string }{"Comment", "redacted", "UPDATE Comment SET author = ? WHERE author = ?"},
+				struct{ table, action, query string }{"AuditLog", "redacted", "UPDATE AuditLog SET actor = ? WHERE actor = ?"})
+		}
+		for _, step := range steps {
+			args := []interface{}{request.Login}
+			if step.action == "redacted" {
+				args = []interface{}{replacement, request.Login}
+			}
+			if err := e.exec(step.table, step.action, step.query, args...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Other people's records which mention them are kept, with them redacted
+	texts := []struct {
+		table   string
+		columns []string
+	}{
+		{"ReportRun", []string{"message"}},
+		{"AuditLog", []string{"before", "after"}},
+		{"Comment", []string{"text"}},
+		{"Job", []string{"payload", "message"}},
+	}
+	for _, identifier := range identifiers {
+		for _, text := range texts {
+			if err := e.redactText(text.table, text.columns, identifier); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if request.DryRun {
+		return identifiers, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.DefaultLogger.Error("eraseDatabase: tx.Commit(): ", err.Error())
+		return nil, err
+	}
+
+	return identifiers, nil
+}
@@ -0,0 +1,124 @@
+package dbstore
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func execAll(t *testing.T, path string, queries ...string) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(query + ": " + err.Error())
+		}
+	}
+}
+
+func queryString(t *testing.T, path string, query string) string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var value string
+	if err := db.QueryRow(query).Scan(&value); err != nil {
+		t.Fatal(query + ": " + err.Error())
+	}
+	return value
+}
+
+func TestReplaceWholeLeavesLongerAddresses(t *testing.T) {
+	cases := []struct{ text, want string }{
+		{"sent to jo@x.org", "sent to [redacted]"},
+		{"sent to JO@X.ORG, mojo@x.org", "sent to [redacted], mojo@x.org"},
+		{"jo@x.org.uk and a.jo@x.org", "jo@x.org.uk and a.jo@x.org"},
+		{`{"to":["jo@x.org"]}`, `{"to":["[redacted]"]}`},
+		{"bounced for jo@x.org.", "bounced for [redacted]."},
+	}
+	for _, c := range cases {
+		if got, _ := replaceWhole(c.text, "jo@x.org", redacted); got != c.want {
+			t.Errorf("replaceWhole(%q) = %q, expected %q", c.text, got, c.want)
+		}
+	}
+}
+
+func TestPurgeRedactsOthersRecordsAndLeavesOtherAddresses(t *testing.T) {
+	datasource := newTestDatasource(t)
+	execAll(t, datasource.Path,
+		"INSERT INTO ReportRun (id, scheduleID, message) VALUES ('run', 'schedule', 'failed for jo@x.org, mojo@x.org')",
+		`INSERT INTO AuditLog (id, actor, before, after) VALUES ('change', 'alice', '{"email":"mojo@x.org"}', '{"email":"jo@x.org"}')`,
+		"INSERT INTO AuditLog (id, actor) VALUES ('own', 'jo')",
+		"INSERT INTO Unsubscribe (scheduleID, address) VALUES ('schedule', 'jo@x.org'), ('schedule', 'mojo@x.org')",
+		"INSERT INTO Comment (id, author, text) VALUES ('comment', 'jo', 'mine')",
+		"INSERT INTO UserTeams (login, teams) VALUES ('jo', '[]')",
+		"INSERT INTO ShareLink (id, createdBy) VALUES ('link', 'jo')",
+	)
+
+	if _, err := datasource.EraseDataSubject(ErasureRequest{Email: "jo@x.org", Login: "jo", Mode: ErasureModePurge}, filepath.Join(t.TempDir(), "backups")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryString(t, datasource.Path, "SELECT message FROM ReportRun WHERE id = 'run'"); got != "failed for [redacted], mojo@x.org" {
+		t.Errorf("expected only jo@x.org to be redacted from the run, got %q", got)
+	}
+	if got := queryString(t, datasource.Path, "SELECT before || after FROM AuditLog WHERE id = 'change'"); got != `{"email":"mojo@x.org"}{"email":"[redacted]"}` {
+		t.Errorf("expected alice's change to be kept and redacted, got %q", got)
+	}
+	if got := queryString(t, datasource.Path, "SELECT group_concat(address) FROM Unsubscribe"); got != "mojo@x.org" {
+		t.Errorf("expected only jo@x.org's unsubscribe to be deleted, got %q", got)
+	}
+	if got := queryString(t, datasource.Path, "SELECT createdBy FROM ShareLink"); got != redacted {
+		t.Errorf("expected who created the share link to be redacted, got %q", got)
+	}
+	for _, table := range []string{"Comment", "UserTeams"} {
+		if got := queryString(t, datasource.Path, "SELECT count(*) FROM "+table); got != "0" {
+			t.Errorf("expected jo's %s to be deleted, %s are left", table, got)
+		}
+	}
+	if got := queryString(t, datasource.Path, "SELECT count(*) FROM AuditLog WHERE id = 'own'"); got != "0" {
+		t.Error("expected jo's own audited changes to be purged")
+	}
+}
+
+func TestErasureReachesBackupsAndArchive(t *testing.T) {
+	datasource := newTestDatasource(t)
+	execAll(t, datasource.Path, "INSERT INTO Unsubscribe (scheduleID, address) VALUES ('schedule', 'jo@x.org')")
+
+	backups := filepath.Join(t.TempDir(), "backups")
+	backup, err := datasource.CreateBackup(backups, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := filepath.Join(datasource.ArchiveDirectory(), "run.json")
+	if err := os.MkdirAll(filepath.Dir(archived), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archived, []byte(`{"rows":[["jo@x.org","mojo@x.org"]]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := datasource.EraseDataSubject(ErasureRequest{Email: "jo@x.org", Mode: ErasureModeAnonymize}, backups); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryString(t, filepath.Join(backups, backup.Name), "SELECT count(*) FROM Unsubscribe"); got != "0" {
+		t.Errorf("expected jo@x.org to be erased from the backup, %s unsubscribes are left", got)
+	}
+	data, err := ioutil.ReadFile(archived)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := ErasureRequest{Email: "jo@x.org"}
+	if want := `{"rows":[["` + request.Pseudonym() + `","mojo@x.org"]]}`; string(data) != want {
+		t.Errorf("expected the archived run to be anonymized, got %s", data)
+	}
+}
@@ -0,0 +1,154 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// What can be starred or shown as recently used
+const (
+	ItemKindSchedule    = "schedule"
+	ItemKindReportGroup = "report-group"
+)
+
+// How many recently viewed or edited items are kept for each user
+const RecentItemsMax = 20
+
+func IsItemKind(kind string) bool {
+	return kind == ItemKindSchedule || kind == ItemKindReportGroup
+}
+
+// UserItem is a schedule or report group a user has starred or recently used, named as it is now
+type UserItem struct {
+	Kind   string `json:"kind"`
+	ItemID string `json:"itemID"`
+	Name   string `json:"name"`
+	// When it was starred, or last viewed or edited
+	At int `json:"at"`
+}
+
+// The current name of the item, from whichever table its kind is in
+const userItemName = "COALESCE((SELECT name FROM Schedule WHERE id = itemID AND kind = '" + ItemKindSchedule + "'), (SELECT name FROM ReportGroup WHERE id = itemID AND kind = '" + ItemKindReportGroup + "'), '')"
+
+// Leaves out items in the trash, which come back if they are restored
+const userItemNotTrashed = " AND itemID NOT IN (SELECT id FROM Schedule WHERE deletedAt > 0) AND itemID NOT IN (SELECT id FROM ReportGroup WHERE deletedAt > 0)"
+
+func getUserItems(db *sql.DB, query string, login string) ([]UserItem, error) {
+	rows, err := db.Query(query, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []UserItem{}
+	for rows.Next() {
+		var item UserItem
+		err = rows.Scan(&item.Kind, &item.ItemID, &item.Name, &item.At)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetFavorites is what the user has starred, most recently starred first
+func (datasource *SQLiteDatasource) GetFavorites(login string) ([]UserItem, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetFavorites: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	items, err := getUserItems(db, "SELECT kind, itemID, "+userItemName+", createdAt FROM Favorite WHERE login = ?"+userItemNotTrashed+" ORDER BY createdAt DESC, rowid DESC", login)
+	if err != nil {
+		log.DefaultLogger.Error("GetFavorites: getUserItems(): ", err.Error())
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (datasource *SQLiteDatasource) AddFavorite(login string, kind string, itemID string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("AddFavorite: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("INSERT OR IGNORE INTO Favorite (login, kind, itemID, createdAt) VALUES (?,?,?,?)", login, kind, itemID, time.Now().Unix())
+	if err != nil {
+		log.DefaultLogger.Error("AddFavorite: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (datasource *SQLiteDatasource) DeleteFavorite(login string, kind string, itemID string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteFavorite: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM Favorite WHERE login = ? AND kind = ? AND itemID = ?", login, kind, itemID)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteFavorite: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetRecentItems is what the user last viewed or edited, most recent first
+func (datasource *SQLiteDatasource) GetRecentItems(login string) ([]UserItem, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetRecentItems: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	items, err := getUserItems(db, "SELECT kind, itemID, "+userItemName+", usedAt FROM RecentItem WHERE login = ?"+userItemNotTrashed+" ORDER BY usedAt DESC, rowid DESC", login)
+	if err != nil {
+		log.DefaultLogger.Error("GetRecentItems: getUserItems(): ", err.Error())
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// RecordRecentItem moves an item to the top of the user's recent items, dropping the oldest beyond RecentItemsMax
+func (datasource *SQLiteDatasource) RecordRecentItem(login string, kind string, itemID string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RecordRecentItem: sql.Open(): ", err.Error())
+		return err
+	}
+
+	// Replaced rather than updated so the rowid orders items used within the same second
+	err = retryBusy("RecordRecentItem", func() error {
+		_, err := db.Exec("INSERT OR REPLACE INTO RecentItem (login, kind, itemID, usedAt) VALUES (?,?,?,?)", login, kind, itemID, time.Now().Unix())
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("RecordRecentItem: db.Exec(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM RecentItem WHERE login = ? AND rowid NOT IN (SELECT rowid FROM RecentItem WHERE login = ? ORDER BY usedAt DESC, rowid DESC LIMIT ?)", login, login, RecentItemsMax)
+	if err != nil {
+		log.DefaultLogger.Error("RecordRecentItem: db.Exec()2: ", err.Error())
+		return err
+	}
+
+	return nil
+}
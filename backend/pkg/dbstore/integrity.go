@@ -0,0 +1,167 @@
+package dbstore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The tables holding rows which belong to a schedule, by its scheduleID. Delivery failures and the audit log are
+// records of what happened, so they are kept after the schedule has gone.
+var scheduleDependents = []string{"ReportContent", "ReportRun", "SLOCompliance", "Comment", "Blackout", "ScheduleShare", "Unsubscribe", "Outbox", "PanelImage", "Job", "Acknowledgement"}
+
+// The tables holding rows which belong to a report group, by its reportGroupID
+var reportGroupDependents = []string{"ReportGroupMembership", "ReportGroupPermission"}
+
+// The tables' foreign keys were declared without ON DELETE CASCADE, which SQLite can't add to an existing table
+// without rebuilding it, and its connections don't enforce them unless asked to on each one. Deleting a schedule or
+// report group cascades through these triggers instead, so nothing can delete one and leave its rows behind.
+// Schedules of a deleted report group are kept, without a group.
+var cascadeTriggers = map[string]string{
+	"ScheduleCascadeDelete":    cascadeTrigger("Schedule", "scheduleID", scheduleDependents, ItemKindSchedule, ""),
+	"ReportGroupCascadeDelete": cascadeTrigger("ReportGroup", "reportGroupID", reportGroupDependents, ItemKindReportGroup, "UPDATE Schedule SET reportGroupID = '' WHERE reportGroupID = OLD.id;"),
+}
+
+func cascadeTrigger(table string, column string, dependents []string, kind string, extra string) string {
+	statements := []string{}
+	for _, dependent := range dependents {
+		statements = append(statements, "DELETE FROM "+dependent+" WHERE "+column+" = OLD.id;")
+	}
+	for _, userItems := range []string{"Favorite", "RecentItem"} {
+		statements = append(statements, "DELETE FROM "+userItems+" WHERE kind = '"+kind+"' AND itemID = OLD.id;")
+	}
+	if extra != "" {
+		statements = append(statements, extra)
+	}
+
+	return "AFTER DELETE ON " + table + " FOR EACH ROW BEGIN " + strings.Join(statements, " ") + " END"
+}
+
+// createCascadeTriggers replaces the triggers each time the plugin starts, so they cascade to tables added since
+func createCascadeTriggers(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, trigger := range cascadeTriggers {
+		_, err := tx.Exec("DROP TRIGGER IF EXISTS " + name)
+		if err != nil {
+			return fmt.Errorf("dropping trigger %s: %w", name, err)
+		}
+		_, err = tx.Exec("CREATE TRIGGER " + name + " " + trigger)
+		if err != nil {
+			return fmt.Errorf("creating trigger %s: %w", name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// purge deletes a schedule or report group, and the cascade triggers everything belonging to it in the same statement
+func (datasource *SQLiteDatasource) purge(caller string, table string, id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy(caller, func() error {
+		_, err := db.Exec("DELETE FROM "+table+" WHERE id = ?", id)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error(caller+": db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// orphanChecks find the rows left behind by deletes from before they cascaded, or by writes which raced one. Each is
+// the table and the condition its orphaned rows match.
+var orphanChecks = orphanConditions()
+
+type orphanCheck struct {
+	table string
+	where string
+}
+
+func orphanConditions() []orphanCheck {
+	checks := []orphanCheck{}
+	for _, table := range scheduleDependents {
+		checks = append(checks, orphanCheck{table, "scheduleID NOT IN (SELECT id FROM Schedule)"})
+	}
+	for _, table := range reportGroupDependents {
+		checks = append(checks, orphanCheck{table, "reportGroupID NOT IN (SELECT id FROM ReportGroup)"})
+	}
+	for _, table := range []string{"Favorite", "RecentItem"} {
+		checks = append(checks,
+			orphanCheck{table, "kind = '" + ItemKindSchedule + "' AND itemID NOT IN (SELECT id FROM Schedule)"},
+			orphanCheck{table, "kind = '" + ItemKindReportGroup + "' AND itemID NOT IN (SELECT id FROM ReportGroup)"})
+	}
+	return checks
+}
+
+// CleanOrphans deletes the rows belonging to schedules and report groups which no longer exist, and takes schedules
+// out of report groups which no longer exist, in one transaction. It returns how many rows it changed in each table.
+func (datasource *SQLiteDatasource) CleanOrphans() (map[string]int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CleanOrphans: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var cleaned map[string]int
+	err = retryBusy("CleanOrphans", func() error {
+		cleaned = map[string]int{}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, check := range orphanChecks {
+			result, err := tx.Exec("DELETE FROM " + check.table + " WHERE " + check.where)
+			if err != nil {
+				return err
+			}
+			if count, _ := result.RowsAffected(); count > 0 {
+				cleaned[check.table] += int(count)
+			}
+		}
+
+		result, err := tx.Exec("UPDATE Schedule SET reportGroupID = '' WHERE reportGroupID != '' AND reportGroupID NOT IN (SELECT id FROM ReportGroup)")
+		if err != nil {
+			return err
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			cleaned["Schedule"] += int(count)
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CleanOrphans: ", err.Error())
+		return nil, err
+	}
+
+	return cleaned, nil
+}
+
+// CleanOrphansJob cleans up orphaned rows, logging what it found, for running on a schedule
+func (datasource *SQLiteDatasource) CleanOrphansJob() {
+	cleaned, err := datasource.CleanOrphans()
+	if err != nil {
+		return
+	}
+
+	for table, count := range cleaned {
+		log.DefaultLogger.Warn(fmt.Sprintf("CleanOrphans: cleaned up %d orphaned rows in %s", count, table))
+	}
+}
@@ -0,0 +1,423 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/progress"
+)
+
+// Statuses a job moves through: queued, then running, then one of succeeded, failed or cancelled. A running job
+// whose instance stops without finishing it is queued again.
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+var ErrJobNotFound = errors.New("there is no job with this id")
+
+// ErrJobFinished is returned when cancelling a job which has already finished
+var ErrJobFinished = errors.New("the job has already finished")
+
+// Job is a report waiting to be generated or being generated, kept in the database so the queue survives restarts
+// and is shared by every instance
+type Job struct {
+	ID           string `json:"id"`
+	Kind         string `json:"kind"`
+	ScheduleID   string `json:"scheduleID"`
+	ScheduleName string `json:"scheduleName"`
+	// The schedule's priority, queued jobs of a higher priority starting first
+	Priority string `json:"priority"`
+	Status   string `json:"status"`
+	// What the kind of job needs to run, as JSON
+	Payload     string `json:"payload"`
+	RequestedBy string `json:"requestedBy"`
+	// When it may start, e.g. after being spread out from others due at the same time, when it was queued, started
+	// and finished, and when its worker last said it was still running, as unix seconds
+	StartAt     int `json:"startAt"`
+	CreatedAt   int `json:"createdAt"`
+	StartedAt   int `json:"startedAt"`
+	FinishedAt  int `json:"finishedAt"`
+	HeartbeatAt int `json:"heartbeatAt"`
+	// The instance running it
+	Instance        string `json:"instance"`
+	CancelRequested bool   `json:"cancelRequested"`
+	// Why it failed
+	Message string `json:"message"`
+	// How far it has got
+	progress.Progress
+}
+
+const jobColumns = "id, kind, scheduleID, scheduleName, priority, status, payload, requestedBy, startAt, createdAt, startedAt, finishedAt, heartbeatAt, instance, cancelRequested, message, stage, panelsTotal, panelsRendered, panelsFailed, emailsTotal, emailsSent, emailsFailed"
+
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	err := row.Scan(&job.ID, &job.Kind, &job.ScheduleID, &job.ScheduleName, &job.Priority, &job.Status, &job.Payload, &job.RequestedBy, &job.StartAt, &job.CreatedAt, &job.StartedAt, &job.FinishedAt, &job.HeartbeatAt, &job.Instance, &job.CancelRequested, &job.Message, &job.Stage, &job.PanelsTotal, &job.PanelsRendered, &job.PanelsFailed, &job.EmailsTotal, &job.EmailsSent, &job.EmailsFailed)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// jobOrder is the order queued jobs start in: highest priority first, then oldest first
+var jobOrder = "CASE priority WHEN '" + PriorityHigh + "' THEN 1 WHEN '" + PriorityLow + "' THEN -1 ELSE 0 END DESC, createdAt, rowid"
+
+// CreateJob queues a job, starting from its StartAt or straight away without one
+func (datasource *SQLiteDatasource) CreateJob(job Job) (*Job, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateJob: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	job.ID = uuid.New().String()
+	job.Status = JobStatusQueued
+	job.CreatedAt = int(time.Now().Unix())
+	if job.StartAt == 0 {
+		job.StartAt = job.CreatedAt
+	}
+
+	err = retryBusy("CreateJob", func() error {
+		_, err := db.Exec("INSERT INTO Job (id, kind, scheduleID, scheduleName, priority, status, payload, requestedBy, startAt, createdAt) VALUES (?,?,?,?,?,?,?,?,?,?)",
+			job.ID, job.Kind, job.ScheduleID, job.ScheduleName, job.Priority, job.Status, job.Payload, job.RequestedBy, job.StartAt, job.CreatedAt)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CreateJob: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (datasource *SQLiteDatasource) GetJob(id string) (*Job, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetJob: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	job, err := scanJob(db.QueryRow("SELECT "+jobColumns+" FROM Job WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetJob: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// GetActiveJobs lists the running jobs, then the queued ones in the order they will start
+func (datasource *SQLiteDatasource) GetActiveJobs() ([]Job, error) {
+	return datasource.getJobs("GetActiveJobs", "SELECT "+jobColumns+" FROM Job WHERE status IN (?, ?) ORDER BY status = ? DESC, "+jobOrder, JobStatusRunning, JobStatusQueued, JobStatusRunning)
+}
+
+// GetFinishedJobs lists the most recently finished jobs, newest first
+func (datasource *SQLiteDatasource) GetFinishedJobs(limit int) ([]Job, error) {
+	return datasource.getJobs("GetFinishedJobs", "SELECT "+jobColumns+" FROM Job WHERE status IN (?, ?, ?) ORDER BY finishedAt DESC, rowid DESC LIMIT ?", JobStatusSucceeded, JobStatusFailed, JobStatusCancelled, limit)
+}
+
+func (datasource *SQLiteDatasource) getJobs(caller string, query string, args ...interface{}) ([]Job, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.DefaultLogger.Error(caller+": db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			log.DefaultLogger.Error(caller+": rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// HasActiveJob is whether a job of the kind for the schedule is queued or running
+func (datasource *SQLiteDatasource) HasActiveJob(kind string, scheduleID string) (bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("HasActiveJob: sql.Open(): ", err.Error())
+		return false, err
+	}
+
+	var active bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM Job WHERE kind = ? AND scheduleID = ? AND status IN (?, ?))", kind, scheduleID, JobStatusQueued, JobStatusRunning).Scan(&active)
+	if err != nil {
+		log.DefaultLogger.Error("HasActiveJob: db.QueryRow(): ", err.Error())
+		return false, err
+	}
+
+	return active, nil
+}
+
+// ClaimJob starts the next queued job which is due for the instance, unless limit jobs are already running on any
+// instance. It returns nil when there's nothing to start. Only one instance can claim each job.
+func (datasource *SQLiteDatasource) ClaimJob(instance string, limit int) (*Job, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ClaimJob: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	for {
+		now := time.Now().Unix()
+		job, err := scanJob(db.QueryRow("SELECT "+jobColumns+" FROM Job WHERE status = ? AND startAt <= ? ORDER BY "+jobOrder+" LIMIT 1", JobStatusQueued, now))
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			log.DefaultLogger.Error("ClaimJob: db.QueryRow(): ", err.Error())
+			return nil, err
+		}
+
+		// Claimed only if it's still queued and there's still a free slot, so instances racing for it can't both
+		// start it or go over the limit between them
+		var claimed int64
+		err = retryBusy("ClaimJob", func() error {
+			result, err := db.Exec("UPDATE Job SET status = ?, instance = ?, startedAt = ?, heartbeatAt = ? WHERE id = ? AND status = ? AND (SELECT COUNT(*) FROM Job WHERE status = ?) < ?",
+				JobStatusRunning, instance, now, now, job.ID, JobStatusQueued, JobStatusRunning, limit)
+			if err != nil {
+				return err
+			}
+			claimed, _ = result.RowsAffected()
+			return nil
+		})
+		if err != nil {
+			log.DefaultLogger.Error("ClaimJob: db.Exec(): ", err.Error())
+			return nil, err
+		}
+		if claimed > 0 {
+			job.Status, job.Instance, job.StartedAt, job.HeartbeatAt = JobStatusRunning, instance, int(now), int(now)
+			return job, nil
+		}
+
+		// Either another instance took it, so the next one is tried, or every slot is taken
+		var running int
+		err = db.QueryRow("SELECT COUNT(*) FROM Job WHERE status = ?", JobStatusRunning).Scan(&running)
+		if err != nil {
+			log.DefaultLogger.Error("ClaimJob: db.QueryRow(): ", err.Error())
+			return nil, err
+		}
+		if running >= limit {
+			return nil, nil
+		}
+	}
+}
+
+// FinishJob records how a running job ended
+func (datasource *SQLiteDatasource) FinishJob(id string, status string, message string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("FinishJob: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("FinishJob", func() error {
+		_, err := db.Exec("UPDATE Job SET status = ?, message = ?, finishedAt = ? WHERE id = ? AND status = ?", status, message, time.Now().Unix(), id, JobStatusRunning)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("FinishJob: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// UpdateJobProgress records how far a running job has got
+func (datasource *SQLiteDatasource) UpdateJobProgress(id string, progress progress.Progress) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UpdateJobProgress: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("UpdateJobProgress", func() error {
+		_, err := db.Exec("UPDATE Job SET stage = ?, panelsTotal = ?, panelsRendered = ?, panelsFailed = ?, emailsTotal = ?, emailsSent = ?, emailsFailed = ? WHERE id = ? AND status = ?",
+			progress.Stage, progress.PanelsTotal, progress.PanelsRendered, progress.PanelsFailed, progress.EmailsTotal, progress.EmailsSent, progress.EmailsFailed, id, JobStatusRunning)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("UpdateJobProgress: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CancelJob cancels a queued job straight away, and asks the instance running a running job to stop it. It returns
+// the job as it is after.
+func (datasource *SQLiteDatasource) CancelJob(id string) (*Job, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CancelJob: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	err = retryBusy("CancelJob", func() error {
+		_, err := db.Exec("UPDATE Job SET status = ?, finishedAt = ? WHERE id = ? AND status = ?", JobStatusCancelled, time.Now().Unix(), id, JobStatusQueued)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec("UPDATE Job SET cancelRequested = 1 WHERE id = ? AND status = ?", id, JobStatusRunning)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CancelJob: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	job, err := datasource.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobStatusRunning && job.Status != JobStatusCancelled {
+		return job, ErrJobFinished
+	}
+	return job, nil
+}
+
+// Heartbeat records that the instance is still running the jobs, returning those which have been asked to stop
+func (datasource *SQLiteDatasource) Heartbeat(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("Heartbeat: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := []interface{}{time.Now().Unix()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	err = retryBusy("Heartbeat", func() error {
+		_, err := db.Exec("UPDATE Job SET heartbeatAt = ? WHERE id IN ("+placeholders+")", args...)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("Heartbeat: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id FROM Job WHERE cancelRequested = 1 AND id IN ("+placeholders+")", args[1:]...)
+	if err != nil {
+		log.DefaultLogger.Error("Heartbeat: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	cancelled := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			log.DefaultLogger.Error("Heartbeat: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		cancelled = append(cancelled, id)
+	}
+
+	return cancelled, nil
+}
+
+// RequeueStaleJobs queues again the running jobs whose instance hasn't said it's still running them since before,
+// as it stopped without finishing them. Those which had been asked to stop are cancelled instead. Only the kinds
+// which are resumable pick up where they left off, e.g. scheduled jobs from the outbox, so the others fail if they
+// had started sending rather than sending again to those they had reached.
+func (datasource *SQLiteDatasource) RequeueStaleJobs(before time.Time, resumable []string) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RequeueStaleJobs: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var requeued int64
+	err = retryBusy("RequeueStaleJobs", func() error {
+		_, err := db.Exec("UPDATE Job SET status = ?, finishedAt = ? WHERE status = ? AND heartbeatAt < ? AND cancelRequested = 1", JobStatusCancelled, time.Now().Unix(), JobStatusRunning, before.Unix())
+		if err != nil {
+			return err
+		}
+		args := []interface{}{JobStatusFailed, time.Now().Unix(), "stopped part way through sending, so it wasn't sent again", JobStatusRunning, before.Unix(), progress.StageSending}
+		for _, kind := range resumable {
+			args = append(args, kind)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(resumable)), ",")
+		_, err = db.Exec("UPDATE Job SET status = ?, finishedAt = ?, message = ? WHERE status = ? AND heartbeatAt < ? AND stage = ? AND kind NOT IN ("+placeholders+")", args...)
+		if err != nil {
+			return err
+		}
+		result, err := db.Exec("UPDATE Job SET status = ?, instance = '', startedAt = 0, heartbeatAt = 0, stage = '', panelsTotal = 0, panelsRendered = 0, panelsFailed = 0, emailsTotal = 0, emailsSent = 0, emailsFailed = 0 WHERE status = ? AND heartbeatAt < ?", JobStatusQueued, JobStatusRunning, before.Unix())
+		if err != nil {
+			return err
+		}
+		requeued, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Error("RequeueStaleJobs: db.Exec(): ", err.Error())
+		return 0, err
+	}
+
+	return int(requeued), nil
+}
+
+// DeleteFinishedJobs deletes the jobs which finished before the time, returning how many
+func (datasource *SQLiteDatasource) DeleteFinishedJobs(before time.Time) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteFinishedJobs: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var deleted int64
+	err = retryBusy("DeleteFinishedJobs", func() error {
+		result, err := db.Exec("DELETE FROM Job WHERE status IN (?, ?, ?) AND finishedAt < ?", JobStatusSucceeded, JobStatusFailed, JobStatusCancelled, before.Unix())
+		if err != nil {
+			return err
+		}
+		deleted, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Error("DeleteFinishedJobs: db.Exec(): ", err.Error())
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
@@ -0,0 +1,95 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Lease is held by one plugin instance at a time, so when several Grafana servers share the database
+// only one of them does work such as sending reports. A lease which isn't renewed before it expires
+// can be taken over by another instance.
+type Lease struct {
+	Name       string `json:"name"`
+	Holder     string `json:"holder"`
+	AcquiredAt int    `json:"acquiredAt"`
+	ExpiresAt  int    `json:"expiresAt"`
+}
+
+// AcquireLease takes a lease, or renews it if the holder already has it, returning whether the holder has the lease.
+// The check and the write are a single statement so two instances can't both take an expired lease.
+func (datasource *SQLiteDatasource) AcquireLease(name string, holder string, ttl time.Duration) (bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("AcquireLease: sql.Open(): ", err.Error())
+		return false, err
+	}
+
+	now := time.Now()
+	err = retryBusy("AcquireLease", func() error {
+		_, err := db.Exec("INSERT INTO Lease (name, holder, acquiredAt, expiresAt) VALUES (?, ?, ?, ?) "+
+			"ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expiresAt = excluded.expiresAt, "+
+			"acquiredAt = CASE WHEN Lease.holder = excluded.holder THEN Lease.acquiredAt ELSE excluded.acquiredAt END "+
+			"WHERE Lease.holder = excluded.holder OR Lease.expiresAt <= excluded.acquiredAt",
+			name, holder, now.Unix(), now.Add(ttl).Unix())
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("AcquireLease: db.Exec(): ", err.Error())
+		return false, err
+	}
+
+	var current string
+	err = db.QueryRow("SELECT holder FROM Lease WHERE name = ?", name).Scan(&current)
+	if err != nil {
+		log.DefaultLogger.Error("AcquireLease: db.QueryRow(): ", err.Error())
+		return false, err
+	}
+
+	return current == holder, nil
+}
+
+// ReleaseLease gives up a lease so another instance can take it straight away rather than waiting for it to expire
+func (datasource *SQLiteDatasource) ReleaseLease(name string, holder string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ReleaseLease: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("ReleaseLease", func() error {
+		_, err := db.Exec("DELETE FROM Lease WHERE name = ? AND holder = ?", name, holder)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("ReleaseLease: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetLease is the current holder of a lease, nil if nobody has taken it
+func (datasource *SQLiteDatasource) GetLease(name string) (*Lease, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetLease: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var lease Lease
+	err = db.QueryRow("SELECT name, holder, acquiredAt, expiresAt FROM Lease WHERE name = ?", name).Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetLease: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &lease, nil
+}
@@ -0,0 +1,101 @@
+package dbstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var ErrUnknownSort = errors.New("unknown sort")
+
+var listIndexes = []string{
+	"ScheduleNameIndex ON Schedule (name COLLATE NOCASE)",
+	"ScheduleOwnerIndex ON Schedule (owner)",
+	"ScheduleOwnerTeamIndex ON Schedule (ownerTeam)",
+	"ScheduleNextReportTimeIndex ON Schedule (nextReportTime)",
+	"ScheduleShareLoginIndex ON ScheduleShare (login)",
+	"ReportGroupNameIndex ON ReportGroup (name COLLATE NOCASE)",
+}
+
+// ListOptions narrows, orders and pages a list endpoint's rows. Sort is one of the list's sortable fields, and a
+// Limit of zero returns every row after Offset.
+type ListOptions struct {
+	Search string
+	Sort   string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+// IsPaged is whether the list is cut down to a page rather than returned in full
+func (options ListOptions) IsPaged() bool {
+	return options.Offset > 0 || options.Limit > 0
+}
+
+// listQuery holds what a list's rows can be searched and sorted by. sorts maps the names clients sort by to the
+// columns they order, so only known columns ever reach the SQL.
+type listQuery struct {
+	searches    []string
+	sorts       map[string]string
+	defaultSort string
+}
+
+// where is the condition searching the list adds to the given one, matching the search text anywhere in any of
+// the searched columns, ignoring case
+func (list listQuery) where(options ListOptions, where string, args []interface{}) (string, []interface{}) {
+	if options.Search == "" || len(list.searches) == 0 {
+		return where, args
+	}
+
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(options.Search) + "%"
+	matches := []string{}
+	for _, column := range list.searches {
+		matches = append(matches, column+` LIKE ? ESCAPE '\'`)
+		args = append(args, pattern)
+	}
+
+	search := "(" + strings.Join(matches, " OR ") + ")"
+	if where == "" {
+		return " WHERE " + search, args
+	}
+	return where + " AND " + search, args
+}
+
+// orderLimit is the ORDER BY, LIMIT and OFFSET clauses, breaking ties on id so pages don't overlap
+func (list listQuery) orderLimit(options ListOptions, args []interface{}) (string, []interface{}, error) {
+	column := list.sorts[list.defaultSort]
+	if options.Sort != "" {
+		var ok bool
+		column, ok = list.sorts[options.Sort]
+		if !ok {
+			return "", nil, fmt.Errorf("%w %s, sort by one of %s", ErrUnknownSort, options.Sort, strings.Join(list.sortFields(), ", "))
+		}
+	}
+
+	direction := " ASC"
+	if options.Desc {
+		direction = " DESC"
+	}
+	clauses := " ORDER BY " + column + direction + ", id" + direction
+
+	if options.IsPaged() {
+		limit := options.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		clauses += " LIMIT ? OFFSET ?"
+		args = append(args, limit, options.Offset)
+	}
+
+	return clauses, args, nil
+}
+
+func (list listQuery) sortFields() []string {
+	fields := []string{}
+	for field := range list.sorts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,63 @@
+package dbstore
+
+import "time"
+
+// Calendar periods a report can cover, ending before the period it is sent in
+const (
+	LookbackPreviousDay     = "previousDay"
+	LookbackPreviousWeek    = "previousWeek"
+	LookbackPreviousMonth   = "previousMonth"
+	LookbackPreviousQuarter = "previousQuarter"
+	LookbackPreviousYear    = "previousYear"
+	// From the start of the year up until the report is sent
+	LookbackYearToDate = "yearToDate"
+)
+
+var lookbackTypes = []string{LookbackPreviousDay, LookbackPreviousWeek, LookbackPreviousMonth, LookbackPreviousQuarter, LookbackPreviousYear, LookbackYearToDate}
+
+func isLookbackType(lookbackType string) bool {
+	if lookbackType == "" {
+		return true
+	}
+	for _, t := range lookbackTypes {
+		if t == lookbackType {
+			return true
+		}
+	}
+	return false
+}
+
+// LookbackPeriod is the calendar period a report sent at end covers, worked out in the schedule's timezone so
+// e.g. the previous month starts at midnight on the 1st where the report is read. The period ends the second
+// before the current one starts, as panels' time filters include both ends. ok is false for content without
+// a lookback type, which counts its lookback back from end instead.
+func LookbackPeriod(lookbackType string, end time.Time, location *time.Location) (from time.Time, to time.Time, ok bool) {
+	end = end.In(location)
+	today := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, location)
+
+	var current time.Time
+	switch lookbackType {
+	case LookbackPreviousDay:
+		current = today
+		from = current.AddDate(0, 0, -1)
+	case LookbackPreviousWeek:
+		// Weeks start on Monday
+		current = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		from = current.AddDate(0, 0, -7)
+	case LookbackPreviousMonth:
+		current = time.Date(end.Year(), end.Month(), 1, 0, 0, 0, 0, location)
+		from = current.AddDate(0, -1, 0)
+	case LookbackPreviousQuarter:
+		current = time.Date(end.Year(), end.Month()-(end.Month()-1)%3, 1, 0, 0, 0, 0, location)
+		from = current.AddDate(0, -3, 0)
+	case LookbackPreviousYear:
+		current = time.Date(end.Year(), 1, 1, 0, 0, 0, 0, location)
+		from = current.AddDate(-1, 0, 0)
+	case LookbackYearToDate:
+		return time.Date(end.Year(), 1, 1, 0, 0, 0, 0, location), end, true
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+
+	return from, current.Add(-time.Second), true
+}
@@ -0,0 +1,215 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How a masking rule finds what to hide
+const (
+	// Pattern matches text anywhere in the data, and in what's logged about queries, e.g. ID numbers
+	MaskingTypeRegex = "regex"
+	// Pattern matches the names of columns whose every value is hidden, e.g. patient_name
+	MaskingTypeColumn = "column"
+)
+
+const DefaultMaskReplacement = "***"
+
+// MaskingRule hides patient or staff identifying data in the reports the plugin writes and stores, their previews
+// and the queries it logs, even when a report author selects it. Patterns are case insensitive regular expressions.
+type MaskingRule struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	// What's written in place of the masked text, empty uses DefaultMaskReplacement
+	Replacement string `json:"replacement"`
+}
+
+func MaskingRuleFields() string {
+	return "\n{\n\tname string" +
+		"\n\ttype string (regex|column)" +
+		"\n\tpattern string" +
+		"\n\treplacement string\n}"
+}
+
+func (rule *MaskingRule) Validate() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	if rule.Type != MaskingTypeRegex && rule.Type != MaskingTypeColumn {
+		return errors.New("type must be one of: regex, column")
+	}
+	if rule.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	if _, err := rule.compile(); err != nil {
+		return fmt.Errorf("pattern isn't a valid regular expression: %s", err.Error())
+	}
+	return nil
+}
+
+func (rule *MaskingRule) compile() (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + rule.Pattern)
+}
+
+func (rule *MaskingRule) replacement() string {
+	if rule.Replacement == "" {
+		return DefaultMaskReplacement
+	}
+	return rule.Replacement
+}
+
+func (datasource *SQLiteDatasource) GetMaskingRules() ([]MaskingRule, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetMaskingRules: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id, name, type, pattern, replacement FROM MaskingRule ORDER BY name")
+	if err != nil {
+		log.DefaultLogger.Error("GetMaskingRules: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []MaskingRule{}
+	for rows.Next() {
+		var rule MaskingRule
+		err = rows.Scan(&rule.ID, &rule.Name, &rule.Type, &rule.Pattern, &rule.Replacement)
+		if err != nil {
+			log.DefaultLogger.Error("GetMaskingRules: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (datasource *SQLiteDatasource) CreateMaskingRule(rule MaskingRule) (*MaskingRule, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateMaskingRule: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rule.ID = uuid.New().String()
+	_, err = db.Exec("INSERT INTO MaskingRule (id, name, type, pattern, replacement) VALUES (?,?,?,?,?)", rule.ID, rule.Name, rule.Type, rule.Pattern, rule.Replacement)
+	if err != nil {
+		log.DefaultLogger.Error("CreateMaskingRule: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteMaskingRule(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteMaskingRule: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM MaskingRule WHERE id = ?", id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteMaskingRule: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+type compiledMask struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Masker applies the masking rules. A nil Masker masks nothing, so code which may run without the rules
+// loaded can use one unchecked.
+type Masker struct {
+	text    []compiledMask
+	columns []compiledMask
+}
+
+func NewMasker(rules []MaskingRule) *Masker {
+	masker := &Masker{}
+	for _, rule := range rules {
+		pattern, err := rule.compile()
+		if err != nil {
+			log.DefaultLogger.Warn("NewMasker: skipping masking rule " + rule.Name + ": " + err.Error())
+			continue
+		}
+		mask := compiledMask{pattern: pattern, replacement: rule.replacement()}
+		if rule.Type == MaskingTypeColumn {
+			masker.columns = append(masker.columns, mask)
+		} else {
+			masker.text = append(masker.text, mask)
+		}
+	}
+	return masker
+}
+
+// Masker is the masker for the rules in the database
+func (datasource *SQLiteDatasource) Masker() (*Masker, error) {
+	rules, err := datasource.GetMaskingRules()
+	if err != nil {
+		return nil, err
+	}
+	return NewMasker(rules), nil
+}
+
+// Text masks whatever the regex rules match in text
+func (masker *Masker) Text(text string) string {
+	if masker == nil {
+		return text
+	}
+	for _, mask := range masker.text {
+		text = mask.pattern.ReplaceAllLiteralString(text, mask.replacement)
+	}
+	return text
+}
+
+// Table masks query results in place: every value of the columns the column rules match, and what the regex
+// rules match in the rest. Only text values are searched by the regex rules.
+func (masker *Masker) Table(columns []string, rows [][]interface{}) {
+	if masker == nil {
+		return
+	}
+
+	replacements := make([]string, len(columns))
+	masked := make([]bool, len(columns))
+	for i, column := range columns {
+		for _, mask := range masker.columns {
+			if mask.pattern.MatchString(column) {
+				replacements[i], masked[i] = mask.replacement, true
+				break
+			}
+		}
+	}
+
+	for _, row := range rows {
+		for i, value := range row {
+			if i < len(masked) && masked[i] {
+				if value != nil {
+					row[i] = replacements[i]
+				}
+				continue
+			}
+			if text, ok := value.(string); ok {
+				row[i] = masker.Text(text)
+			}
+		}
+	}
+}
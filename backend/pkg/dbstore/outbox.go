@@ -0,0 +1,125 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	OutboxStatusQueued = "queued"
+	OutboxStatusSent   = "sent"
+)
+
+// EnqueueMessages adds a message to the outbox for each address a schedule's report which was due at scheduledAt is
+// to be sent to, before any are sent, so a run which stops part way through can pick up where it left off
+func (datasource *SQLiteDatasource) EnqueueMessages(scheduleID string, scheduledAt int, addresses []string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("EnqueueMessages: sql.Open(): ", err.Error())
+		return err
+	}
+
+	return retryBusy("EnqueueMessages", func() error {
+		return enqueueMessages(db, scheduleID, scheduledAt, addresses)
+	})
+}
+
+func enqueueMessages(db *sql.DB, scheduleID string, scheduledAt int, addresses []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.DefaultLogger.Error("EnqueueMessages: db.Begin(): ", err.Error())
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, address := range addresses {
+		_, err = tx.Exec("INSERT OR IGNORE INTO Outbox (scheduleID, scheduledAt, address, status, enqueuedAt, sentAt) VALUES (?,?,?,?,?,0)", scheduleID, scheduledAt, address, OutboxStatusQueued, now)
+		if err != nil {
+			log.DefaultLogger.Error("EnqueueMessages: tx.Exec(): ", err.Error())
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// QueuedAddresses is the addresses still waiting in the outbox for a schedule's report which was due at
+// scheduledAt, in the order they were enqueued, and whether it was ever enqueued at all
+func (datasource *SQLiteDatasource) QueuedAddresses(scheduleID string, scheduledAt int) ([]string, bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("QueuedAddresses: sql.Open(): ", err.Error())
+		return nil, false, err
+	}
+
+	rows, err := db.Query("SELECT address, status FROM Outbox WHERE scheduleID = ? AND scheduledAt = ? ORDER BY rowid", scheduleID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("QueuedAddresses: db.Query(): ", err.Error())
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	queued := []string{}
+	enqueued := false
+	for rows.Next() {
+		var address, status string
+		err = rows.Scan(&address, &status)
+		if err != nil {
+			log.DefaultLogger.Error("QueuedAddresses: rows.Scan(): ", err.Error())
+			return nil, false, err
+		}
+		enqueued = true
+		if status == OutboxStatusQueued {
+			queued = append(queued, address)
+		}
+	}
+
+	return queued, enqueued, nil
+}
+
+// MarkMessageSent takes a message out of the queue as soon as it has been sent
+func (datasource *SQLiteDatasource) MarkMessageSent(scheduleID string, scheduledAt int, address string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("MarkMessageSent: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("MarkMessageSent", func() error {
+		_, err := db.Exec("UPDATE Outbox SET status = ?, sentAt = ? WHERE scheduleID = ? AND scheduledAt = ? AND address = ? COLLATE NOCASE", OutboxStatusSent, time.Now().Unix(), scheduleID, scheduledAt, address)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("MarkMessageSent: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ClearOutbox removes a schedule's messages once it has moved on to its next due time, as they can't be resumed
+func (datasource *SQLiteDatasource) ClearOutbox(scheduleID string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ClearOutbox: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("ClearOutbox", func() error {
+		_, err := db.Exec("DELETE FROM Outbox WHERE scheduleID = ?", scheduleID)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("ClearOutbox: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// GetPanelImage is the image a schedule's last run rendered for a panel with the fingerprint, nil if it didn't
+func (datasource *SQLiteDatasource) GetPanelImage(scheduleID string, fingerprint string) ([]byte, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetPanelImage: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var image []byte
+	err = db.QueryRow("SELECT image FROM PanelImage WHERE scheduleID = ? AND fingerprint = ?", scheduleID, fingerprint).Scan(&image)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetPanelImage: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// ReplacePanelImages keeps the images of a schedule's latest run, by the fingerprints of their panels, in place of
+// the previous run's
+func (datasource *SQLiteDatasource) ReplacePanelImages(scheduleID string, images map[string][]byte) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ReplacePanelImages: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("ReplacePanelImages", func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec("DELETE FROM PanelImage WHERE scheduleID = ?", scheduleID)
+		if err != nil {
+			return err
+		}
+
+		renderedAt := time.Now().Unix()
+		for fingerprint, image := range images {
+			_, err = tx.Exec("INSERT INTO PanelImage (scheduleID, fingerprint, image, renderedAt) VALUES (?,?,?,?)", scheduleID, fingerprint, image, renderedAt)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		log.DefaultLogger.Error("ReplacePanelImages: ", err.Error())
+		return err
+	}
+
+	return nil
+}
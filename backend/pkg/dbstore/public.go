@@ -0,0 +1,21 @@
+package dbstore
+
+import (
+	"os"
+	"strings"
+)
+
+// Grafana only forwards signed in users to the plugin's resources, so the links in reports' emails which recipients
+// outside Grafana follow, e.g. to unsubscribe, are served by the plugin itself on this address, e.g. ":3100"
+const PublicAddressEnv = "MSUPPLY_PUBLIC_ADDRESS"
+
+// The URL recipients reach the public address at, usually through a reverse proxy, e.g. https://reports.example.org
+const PublicURLEnv = "MSUPPLY_PUBLIC_URL"
+
+// PublicURL is the base of the links recipients follow without signing in to Grafana, empty when they aren't served
+func PublicURL() string {
+	if os.Getenv(PublicAddressEnv) == "" {
+		return ""
+	}
+	return strings.TrimRight(os.Getenv(PublicURLEnv), "/")
+}
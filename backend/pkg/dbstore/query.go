@@ -0,0 +1,267 @@
+package dbstore
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Query types which can be used in Grafana panels to monitor the reporting itself
+const (
+	QueryTypeSchedules             = "schedules"
+	QueryTypeReportRuns            = "reportRuns"
+	QueryTypeUpcomingRuns          = "upcomingRuns"
+	QueryTypeReportGroupMembership = "reportGroupMembership"
+	QueryTypeSLOCompliance         = "sloCompliance"
+	// Whether each schedule is being sent on time, for alerting when the reporting system stops dispatching
+	QueryTypeDispatchStatus = "dispatchStatus"
+)
+
+const (
+	DispatchStatusUpcoming = "upcoming"
+	DispatchStatusOverdue  = "overdue"
+)
+
+func (datasource *SQLiteDatasource) queryFrame(queryType string, timeRange backend.TimeRange) (*data.Frame, error) {
+	switch queryType {
+	case QueryTypeSchedules:
+		return datasource.schedulesFrame(false)
+	case QueryTypeUpcomingRuns:
+		return datasource.schedulesFrame(true)
+	case QueryTypeReportRuns:
+		return datasource.reportRunsFrame(timeRange)
+	case QueryTypeReportGroupMembership:
+		return datasource.reportGroupMembershipFrame()
+	case QueryTypeSLOCompliance:
+		return datasource.sloComplianceFrame()
+	case QueryTypeDispatchStatus:
+		return datasource.dispatchStatusFrame(time.Now())
+	default:
+		return nil, errors.New("Unknown query type '" + queryType + "', expected one of: schedules, reportRuns, upcomingRuns, reportGroupMembership, sloCompliance, dispatchStatus")
+	}
+}
+
+func unixTime(seconds int) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+// schedulesFrame lists the schedules, or for upcoming runs those with a next run ordered by when it's due, overdue
+// ones first with a status saying so
+func (datasource *SQLiteDatasource) schedulesFrame(upcoming bool) (*data.Frame, error) {
+	schedules, err := datasource.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if upcoming {
+		var pending []Schedule
+		for _, schedule := range schedules {
+			// Only just created and not saved with a time yet
+			if schedule.NextReportTime != 0 {
+				pending = append(pending, schedule)
+			}
+		}
+		schedules = pending
+		sort.Slice(schedules, func(i, j int) bool { return schedules[i].NextReportTime < schedules[j].NextReportTime })
+	}
+
+	var ids, names, descriptions, groupIDs, triggerTypes, statuses []string
+	var nextReportTimes []time.Time
+	var intervals []int64
+	for _, schedule := range schedules {
+		status := DispatchStatusUpcoming
+		if unixTime(schedule.NextReportTime).Before(now) {
+			status = DispatchStatusOverdue
+		}
+		statuses = append(statuses, status)
+		ids = append(ids, schedule.ID)
+		names = append(names, schedule.Name)
+		descriptions = append(descriptions, schedule.Description)
+		groupIDs = append(groupIDs, schedule.ReportGroupID)
+		triggerTypes = append(triggerTypes, schedule.TriggerType)
+		nextReportTimes = append(nextReportTimes, unixTime(schedule.NextReportTime))
+		intervals = append(intervals, int64(schedule.Interval))
+	}
+
+	frame := data.NewFrame(QueryTypeSchedules,
+		data.NewField("id", nil, ids),
+		data.NewField("name", nil, names),
+		data.NewField("description", nil, descriptions),
+		data.NewField("nextReportTime", nil, nextReportTimes),
+		data.NewField("interval", nil, intervals),
+		data.NewField("reportGroupID", nil, groupIDs),
+		data.NewField("triggerType", nil, triggerTypes),
+	)
+	if upcoming {
+		frame.Name = QueryTypeUpcomingRuns
+		frame.Fields = append(frame.Fields, data.NewField("status", nil, statuses))
+	}
+
+	return frame, nil
+}
+
+func (datasource *SQLiteDatasource) reportRunsFrame(timeRange backend.TimeRange) (*data.Frame, error) {
+	runs, err := datasource.GetReportRunsBetween(int(timeRange.From.Unix()), int(timeRange.To.Unix()))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids, scheduleIDs, statuses, attachmentModes, messages []string
+	var startedAt, finishedAt []time.Time
+	var failedPanels, messagesSent []int64
+	var costs []float64
+	for _, run := range runs {
+		ids = append(ids, run.ID)
+		scheduleIDs = append(scheduleIDs, run.ScheduleID)
+		statuses = append(statuses, run.Status)
+		attachmentModes = append(attachmentModes, run.AttachmentMode)
+		messages = append(messages, run.Message)
+		startedAt = append(startedAt, unixTime(run.StartedAt))
+		finishedAt = append(finishedAt, unixTime(run.FinishedAt))
+		failedPanels = append(failedPanels, int64(len(run.FailedPanels)))
+		messagesSent = append(messagesSent, int64(run.MessagesSent))
+		costs = append(costs, run.EstimatedCost)
+	}
+
+	return data.NewFrame(QueryTypeReportRuns,
+		data.NewField("startedAt", nil, startedAt),
+		data.NewField("finishedAt", nil, finishedAt),
+		data.NewField("id", nil, ids),
+		data.NewField("scheduleID", nil, scheduleIDs),
+		data.NewField("status", nil, statuses),
+		data.NewField("attachmentMode", nil, attachmentModes),
+		data.NewField("failedPanels", nil, failedPanels),
+		data.NewField("messagesSent", nil, messagesSent),
+		data.NewField("estimatedCost", nil, costs),
+		data.NewField("message", nil, messages),
+	), nil
+}
+
+func (datasource *SQLiteDatasource) reportGroupMembershipFrame() (*data.Frame, error) {
+	groups, err := datasource.GetReportGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	var groupIDs, groupNames, userIDs []string
+	for _, group := range groups {
+		memberships, err := datasource.GetReportGroupMemberships(group.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, membership := range memberships {
+			groupIDs = append(groupIDs, group.ID)
+			groupNames = append(groupNames, group.Name)
+			userIDs = append(userIDs, membership.UserID)
+		}
+	}
+
+	return data.NewFrame(QueryTypeReportGroupMembership,
+		data.NewField("reportGroupID", nil, groupIDs),
+		data.NewField("reportGroupName", nil, groupNames),
+		data.NewField("userID", nil, userIDs),
+	), nil
+}
+
+func (datasource *SQLiteDatasource) sloComplianceFrame() (*data.Frame, error) {
+	schedules, err := datasource.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	var scheduleIDs, scheduleNames, periods []string
+	var due, onTime []int64
+	var compliance, target []float64
+	var met []bool
+	for _, schedule := range schedules {
+		compliances, err := datasource.GetSLOCompliance(schedule.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range compliances {
+			scheduleIDs = append(scheduleIDs, schedule.ID)
+			scheduleNames = append(scheduleNames, schedule.Name)
+			periods = append(periods, c.Period)
+			due = append(due, int64(c.Due))
+			onTime = append(onTime, int64(c.OnTime))
+			compliance = append(compliance, c.Compliance)
+			target = append(target, c.Target)
+			met = append(met, c.Met)
+		}
+	}
+
+	return data.NewFrame(QueryTypeSLOCompliance,
+		data.NewField("scheduleID", nil, scheduleIDs),
+		data.NewField("scheduleName", nil, scheduleNames),
+		data.NewField("period", nil, periods),
+		data.NewField("due", nil, due),
+		data.NewField("onTime", nil, onTime),
+		data.NewField("compliance", nil, compliance),
+		data.NewField("target", nil, target),
+		data.NewField("met", nil, met),
+	), nil
+}
+
+// dispatchStatusFrame has a row for every schedule with how many minutes its next run is overdue, 0 when it is
+// still upcoming. Its one number field lets a Grafana alert rule use it directly, e.g. firing when
+// overdue_minutes is above 30, with the text fields as the alert's labels.
+func (datasource *SQLiteDatasource) dispatchStatusFrame(now time.Time) (*data.Frame, error) {
+	schedules, err := datasource.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	lastDispatched, err := datasource.LastDispatchTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].NextReportTime < schedules[j].NextReportTime })
+
+	var ids, names, statuses, triggerTypes []string
+	var nextReportTimes []time.Time
+	var lastDispatchTimes []*time.Time
+	var overdueMinutes []float64
+	for _, schedule := range schedules {
+		// Only just created and not saved with a time yet
+		if schedule.NextReportTime == 0 {
+			continue
+		}
+
+		next := unixTime(schedule.NextReportTime)
+		status, overdue := DispatchStatusUpcoming, 0.0
+		if next.Before(now) {
+			status, overdue = DispatchStatusOverdue, now.Sub(next).Minutes()
+		}
+
+		var last *time.Time
+		if finishedAt, ok := lastDispatched[schedule.ID]; ok {
+			lastTime := unixTime(finishedAt)
+			last = &lastTime
+		}
+
+		ids = append(ids, schedule.ID)
+		names = append(names, schedule.Name)
+		statuses = append(statuses, status)
+		triggerTypes = append(triggerTypes, schedule.TriggerType)
+		nextReportTimes = append(nextReportTimes, next)
+		lastDispatchTimes = append(lastDispatchTimes, last)
+		overdueMinutes = append(overdueMinutes, overdue)
+	}
+
+	return data.NewFrame(QueryTypeDispatchStatus,
+		data.NewField("scheduleID", nil, ids),
+		data.NewField("scheduleName", nil, names),
+		data.NewField("status", nil, statuses),
+		data.NewField("triggerType", nil, triggerTypes),
+		data.NewField("nextReportTime", nil, nextReportTimes),
+		data.NewField("lastDispatchTime", nil, lastDispatchTimes),
+		data.NewField("overdue_minutes", nil, overdueMinutes),
+	), nil
+}
@@ -0,0 +1,32 @@
+package dbstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpcomingRunsIncludeOverdueSchedules(t *testing.T) {
+	datasource := newTestDatasource(t)
+	now := int(time.Now().Unix())
+	for _, next := range []int{now + 3600, now - 3600} {
+		schedule, err := datasource.CreateSchedule("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := datasource.SetNextReportTime(schedule.ID, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frame, err := datasource.schedulesFrame(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Rows() != 2 {
+		t.Fatalf("expected both schedules to be upcoming runs, got %d", frame.Rows())
+	}
+	status := frame.Fields[len(frame.Fields)-1]
+	if got := []interface{}{status.At(0), status.At(1)}; got[0] != DispatchStatusOverdue || got[1] != DispatchStatusUpcoming {
+		t.Errorf("expected the overdue schedule first, got statuses %v", got)
+	}
+}
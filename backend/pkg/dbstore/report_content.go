@@ -2,9 +2,13 @@ package dbstore
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
 )
 
 type ReportContent struct {
@@ -14,6 +18,82 @@ type ReportContent struct {
 	DashboardID string `json:"dashboardID"`
 	Lookback    int    `json:"lookback"`
 	Variables   string `json:"variables"`
+	// Calendar period the report covers, e.g. the previous month, empty counts lookback back from when it's sent
+	LookbackType string `json:"lookbackType"`
+	// Title and type of the panel when it was added, used to find it again if the dashboard changes
+	PanelTitle string `json:"panelTitle"`
+	PanelType  string `json:"panelType"`
+	Type       string `json:"type"`
+	// Overrides of the schedule's render defaults, 0 or empty uses the schedule's value
+	RenderWidth  int     `json:"renderWidth"`
+	RenderHeight int     `json:"renderHeight"`
+	RenderScale  float64 `json:"renderScale"`
+	RenderTheme  string  `json:"renderTheme"`
+	// Adds a native Excel chart of the panel's data to its sheet, empty for none
+	ChartType string `json:"chartType"`
+	// Grafana datasource the content's panels query, e.g. a country's mSupply database. 0 uses the one each
+	// panel uses on its dashboard, falling back to the settings' datasource.
+	DatasourceID int `json:"datasourceID"`
+	// Seconds allowed for each of the content's panel queries and renders, e.g. for heavy annual panels, up to the
+	// settings' maxQueryTimeout. 0 uses the settings' renderTimeout.
+	QueryTimeout int `json:"queryTimeout"`
+	// Heading of the section of the report the content starts, empty when it carries on the section before
+	SectionTitle string `json:"sectionTitle"`
+	// Where the content comes in its schedule's report, lowest first, content at the same position in the order it
+	// was added
+	Position int `json:"position"`
+	// Markdown written into the report's HTML in place of panels, for text content
+	Text string `json:"text"`
+}
+
+const (
+	// A single panel of a dashboard
+	ReportContentTypePanel = "panel"
+	// Every panel of a dashboard, panelID is ignored
+	ReportContentTypeDashboard = "dashboard"
+	// A block of text, e.g. an introduction to a section, with no dashboard
+	ReportContentTypeText = "text"
+)
+
+// contentType is the type content is saved as, a single panel unless it's one of the others
+func contentType(contentType string) string {
+	switch contentType {
+	case ReportContentTypeDashboard, ReportContentTypeText:
+		return contentType
+	}
+	return ReportContentTypePanel
+}
+
+// IsText is set for text content, which has no dashboard or panels
+func (content *ReportContent) IsText() bool {
+	return content.Type == ReportContentTypeText
+}
+
+// Native Excel charts which can be added to a panel's sheet
+const (
+	ChartTypeLine = "line"
+	ChartTypeBar  = "bar"
+)
+
+const reportContentColumns = "id, scheduleID, panelID, dashboardID, lookback, variables, panelTitle, panelType, contentType, renderWidth, renderHeight, renderScale, renderTheme, chartType, lookbackType, datasourceID, queryTimeout, sectionTitle, position, contentText"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// values are the content's reportContentColumns, for inserting it
+func (content *ReportContent) values() []interface{} {
+	return []interface{}{content.ID, content.ScheduleID, content.PanelID, content.DashboardID, content.Lookback, content.Variables, content.PanelTitle, content.PanelType, content.Type, content.RenderWidth, content.RenderHeight, content.RenderScale, content.RenderTheme, content.ChartType, content.LookbackType, content.DatasourceID, content.QueryTimeout, content.SectionTitle, content.Position, content.Text}
+}
+
+func scanReportContent(row rowScanner) (*ReportContent, error) {
+	var content ReportContent
+	err := row.Scan(&content.ID, &content.ScheduleID, &content.PanelID, &content.DashboardID, &content.Lookback, &content.Variables, &content.PanelTitle, &content.PanelType, &content.Type, &content.RenderWidth, &content.RenderHeight, &content.RenderScale, &content.RenderTheme, &content.ChartType, &content.LookbackType, &content.DatasourceID, &content.QueryTimeout, &content.SectionTitle, &content.Position, &content.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &content, nil
 }
 
 func ReportContentFields() string {
@@ -22,11 +102,56 @@ func ReportContentFields() string {
 		"PanelID string\n\t" +
 		"DashboardID string\n\t" +
 		"Lookback int\n\t" +
-		"Variables string" +
+		"Variables string\n\t" +
+		"PanelTitle string\n\t" +
+		"PanelType string\n\t" +
+		"Type string (panel|dashboard|text)\n\t" +
+		"RenderWidth int\n\t" +
+		"RenderHeight int\n\t" +
+		"RenderScale float\n\t" +
+		"RenderTheme string (light|dark)\n\t" +
+		"ChartType string (line|bar)\n\t" +
+		"LookbackType string (previousDay|previousWeek|previousMonth|previousQuarter|previousYear|yearToDate)\n\t" +
+		"DatasourceID int (0 uses the dashboard's)\n\t" +
+		"QueryTimeout int (seconds, 0 uses the settings')\n\t" +
+		"SectionTitle string\n\t" +
+		"Position int\n\t" +
+		"Text string (markdown, for text content)" +
 		"\n}"
 }
 
+func (content *ReportContent) Validate() error {
+	switch content.ChartType {
+	case "", ChartTypeLine, ChartTypeBar:
+	default:
+		return errors.New("chartType must be one of: line, bar")
+	}
+	if content.DatasourceID < 0 {
+		return errors.New("datasourceID must be 0 or the id of a Grafana datasource")
+	}
+	if content.QueryTimeout < 0 {
+		return errors.New("queryTimeout can't be negative")
+	}
+	content.SectionTitle = strings.TrimSpace(content.SectionTitle)
+	if content.Type == ReportContentTypeText && strings.TrimSpace(content.Text) == "" {
+		return errors.New("text is required for text content")
+	}
+	if !isLookbackType(content.LookbackType) {
+		return errors.New("lookbackType must be one of: " + strings.Join(lookbackTypes, ", "))
+	}
+
+	variables, err := ParseContentVariables(content.Variables)
+	if err != nil {
+		return err
+	}
+	content.Variables = variables.String()
+
+	return nil
+}
+
 func (datasource *SQLiteDatasource) GetReportContent(scheduleID string) ([]ReportContent, error) {
+	defer metrics.ObserveDB("GetReportContent", time.Now())
+
 	var reportContent []ReportContent
 
 	db, err := sql.Open("sqlite3", datasource.Path)
@@ -36,7 +161,7 @@ func (datasource *SQLiteDatasource) GetReportContent(scheduleID string) ([]Repor
 		return nil, err
 	}
 
-	rows, err := db.Query("SELECT * FROM ReportContent WHERE scheduleID = ?", scheduleID)
+	rows, err := db.Query("SELECT "+reportContentColumns+" FROM ReportContent WHERE scheduleID = ? ORDER BY position, rowid", scheduleID)
 	defer rows.Close()
 	if err != nil {
 		log.DefaultLogger.Error("GetReportContent: db.Query()", err.Error())
@@ -44,16 +169,13 @@ func (datasource *SQLiteDatasource) GetReportContent(scheduleID string) ([]Repor
 	}
 
 	for rows.Next() {
-		var ID, ScheduleID, DashboardID, Variables string
-		var Lookback, PanelID int
-		err = rows.Scan(&ID, &ScheduleID, &PanelID, &DashboardID, &Lookback, &Variables)
+		content, err := scanReportContent(rows)
 		if err != nil {
 			log.DefaultLogger.Error("GetReportContent: rows.Scan() ", err.Error())
 			return nil, err
 		}
 
-		content := ReportContent{ID, ScheduleID, PanelID, DashboardID, Lookback, Variables}
-		reportContent = append(reportContent, content)
+		reportContent = append(reportContent, *content)
 	}
 
 	return reportContent, nil
@@ -91,16 +213,17 @@ func (datasource *SQLiteDatasource) CreateReportContent(newReportContentValues R
 		return nil, err
 	}
 
-	reportContent := ReportContent{ID: uuid.New().String(), ScheduleID: newReportContentValues.ScheduleID, PanelID: newReportContentValues.PanelID, DashboardID: newReportContentValues.DashboardID, Lookback: 0, Variables: ""}
+	reportContent := ReportContent{ID: uuid.New().String(), ScheduleID: newReportContentValues.ScheduleID, PanelID: newReportContentValues.PanelID, DashboardID: newReportContentValues.DashboardID, Lookback: 0, Variables: "", PanelTitle: newReportContentValues.PanelTitle, PanelType: newReportContentValues.PanelType, Type: newReportContentValues.Type, RenderWidth: newReportContentValues.RenderWidth, RenderHeight: newReportContentValues.RenderHeight, RenderScale: newReportContentValues.RenderScale, RenderTheme: newReportContentValues.RenderTheme, ChartType: newReportContentValues.ChartType, LookbackType: newReportContentValues.LookbackType, DatasourceID: newReportContentValues.DatasourceID, QueryTimeout: newReportContentValues.QueryTimeout, SectionTitle: newReportContentValues.SectionTitle, Position: newReportContentValues.Position, Text: newReportContentValues.Text}
+	reportContent.Type = contentType(reportContent.Type)
 
-	stmt, err := db.Prepare("INSERT INTO ReportContent (id, scheduleID, panelID, dashboardID, lookback, variables) VALUES (?,?,?,?,?,?)")
+	stmt, err := db.Prepare("INSERT INTO ReportContent (id, scheduleID, panelID, dashboardID, lookback, variables, panelTitle, panelType, contentType, renderWidth, renderHeight, renderScale, renderTheme, chartType, lookbackType, datasourceID, queryTimeout, sectionTitle, position, contentText) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	defer stmt.Close()
 	if err != nil {
 		log.DefaultLogger.Error("CreateReportContent: ", err.Error())
 		return nil, err
 	}
 
-	_, err = stmt.Exec(reportContent.ID, reportContent.ScheduleID, reportContent.PanelID, reportContent.DashboardID, reportContent.Lookback, reportContent.Variables)
+	_, err = stmt.Exec(reportContent.ID, reportContent.ScheduleID, reportContent.PanelID, reportContent.DashboardID, reportContent.Lookback, reportContent.Variables, reportContent.PanelTitle, reportContent.PanelType, reportContent.Type, reportContent.RenderWidth, reportContent.RenderHeight, reportContent.RenderScale, reportContent.RenderTheme, reportContent.ChartType, reportContent.LookbackType, reportContent.DatasourceID, reportContent.QueryTimeout, reportContent.SectionTitle, reportContent.Position, reportContent.Text)
 	if err != nil {
 		log.DefaultLogger.Error("CreateReportContent: ", err.Error())
 		return nil, err
@@ -117,14 +240,16 @@ func (datasource *SQLiteDatasource) UpdateReportContent(id string, reportContent
 		return nil, err
 	}
 
-	stmt, err := db.Prepare("UPDATE ReportContent SET scheduleID = ?, panelID = ?, lookback = ?, variables = ? where id = ?")
+	reportContent.Type = contentType(reportContent.Type)
+
+	stmt, err := db.Prepare("UPDATE ReportContent SET scheduleID = ?, panelID = ?, lookback = ?, variables = ?, panelTitle = ?, panelType = ?, contentType = ?, renderWidth = ?, renderHeight = ?, renderScale = ?, renderTheme = ?, chartType = ?, lookbackType = ?, datasourceID = ?, queryTimeout = ?, sectionTitle = ?, position = ?, contentText = ? where id = ?")
 	defer stmt.Close()
 	if err != nil {
 		log.DefaultLogger.Error("UpdateReportContent: db.Prepare: ", err.Error())
 		return nil, err
 	}
 
-	_, err = stmt.Exec(reportContent.ScheduleID, reportContent.PanelID, reportContent.Lookback, reportContent.Variables, id)
+	_, err = stmt.Exec(reportContent.ScheduleID, reportContent.PanelID, reportContent.Lookback, reportContent.Variables, reportContent.PanelTitle, reportContent.PanelType, reportContent.Type, reportContent.RenderWidth, reportContent.RenderHeight, reportContent.RenderScale, reportContent.RenderTheme, reportContent.ChartType, reportContent.LookbackType, reportContent.DatasourceID, reportContent.QueryTimeout, reportContent.SectionTitle, reportContent.Position, reportContent.Text, id)
 	if err != nil {
 		log.DefaultLogger.Error("UpdateReportContent: db.Exec: ", err.Error())
 		return nil, err
@@ -132,3 +257,75 @@ func (datasource *SQLiteDatasource) UpdateReportContent(id string, reportContent
 
 	return &reportContent, nil
 }
+
+func (datasource *SQLiteDatasource) GetReportContentByID(id string) (*ReportContent, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportContentByID: sql.Open: ", err.Error())
+		return nil, err
+	}
+
+	row := db.QueryRow("SELECT "+reportContentColumns+" FROM ReportContent WHERE id = ?", id)
+	content, err := scanReportContent(row)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportContentByID: row.Scan() ", err.Error())
+		return nil, err
+	}
+
+	return content, nil
+}
+
+func (datasource *SQLiteDatasource) GetAllReportContent() ([]ReportContent, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetAllReportContent: sql.Open: ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + reportContentColumns + " FROM ReportContent ORDER BY dashboardID")
+	if err != nil {
+		log.DefaultLogger.Error("GetAllReportContent: db.Query()", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	reportContent := []ReportContent{}
+	for rows.Next() {
+		content, err := scanReportContent(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetAllReportContent: rows.Scan() ", err.Error())
+			return nil, err
+		}
+
+		reportContent = append(reportContent, *content)
+	}
+
+	return reportContent, nil
+}
+
+// RemapReportContent points a content item at a different panel, keeping its variables and lookback
+func (datasource *SQLiteDatasource) RemapReportContent(id string, dashboardID string, panelID int, panelTitle string, panelType string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RemapReportContent: sql.Open: ", err.Error())
+		return err
+	}
+
+	stmt, err := db.Prepare("UPDATE ReportContent SET dashboardID = ?, panelID = ?, panelTitle = ?, panelType = ? WHERE id = ?")
+	if err != nil {
+		log.DefaultLogger.Error("RemapReportContent: db.Prepare: ", err.Error())
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(dashboardID, panelID, panelTitle, panelType, id)
+	if err != nil {
+		log.DefaultLogger.Error("RemapReportContent: stmt.Exec: ", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -2,21 +2,70 @@ package dbstore
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
 )
 
 type ReportGroup struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// Comma separated labels such as "restricted", which data residency rules apply to
+	Tags string `json:"tags"`
+	// Name of the Grafana team whose members can edit the group, empty for none
+	OwnerTeam string `json:"ownerTeam"`
+	// Query against the mSupply datasource whose rows are more members' addresses, e.g. the store managers of a
+	// region, run each time a report is sent to the group. Empty when the group only has the members added to it.
+	MemberQuery string `json:"memberQuery"`
+	// Sends the group's reports as zips encrypted with the attachment password, for reports with data, e.g. on
+	// controlled substances, which mustn't travel unencrypted
+	EncryptAttachments bool   `json:"encryptAttachments"`
+	AttachmentPassword string `json:"attachmentPassword,omitempty"`
+	// Whether an attachment password is saved, as it's never sent back
+	HasAttachmentPassword bool `json:"hasAttachmentPassword"`
 }
 
 func ReportGroupFields() string {
 	return "\n{\n\tid string" +
 		"\n\tname string" +
-		"\n\tdescription string\n}"
+		"\n\tdescription string" +
+		"\n\ttags string" +
+		"\n\townerTeam string" +
+		"\n\tmemberQuery string" +
+		"\n\tencryptAttachments bool" +
+		"\n\tattachmentPassword string (empty keeps the saved one)\n}"
+}
+
+// Shortest attachment password a report group can have
+const MinAttachmentPasswordLength = 8
+
+// Validate checks a group encrypting its attachments has, or is given, a password long enough to be worth having.
+// hasPassword is whether the group already has one saved.
+func (reportGroup *ReportGroup) Validate(hasPassword bool) error {
+	if reportGroup.AttachmentPassword != "" && len(reportGroup.AttachmentPassword) < MinAttachmentPasswordLength {
+		return fmt.Errorf("attachmentPassword must be at least %d characters", MinAttachmentPasswordLength)
+	}
+	if reportGroup.EncryptAttachments && reportGroup.AttachmentPassword == "" && !hasPassword {
+		return errors.New("a report group encrypting its attachments needs an attachmentPassword")
+	}
+	return nil
+}
+
+// TagList is the group's tags, trimmed and lower cased
+func (reportGroup *ReportGroup) TagList() []string {
+	var tags []string
+	for _, tag := range strings.Split(reportGroup.Tags, ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
 }
 
 func NewReportGroup(ID string, name string, description string) *ReportGroup {
@@ -24,6 +73,8 @@ func NewReportGroup(ID string, name string, description string) *ReportGroup {
 }
 
 func (datasource *SQLiteDatasource) ReportGroupFromSchedule(schedule Schedule) (*ReportGroup, error) {
+	defer metrics.ObserveDB("ReportGroupFromSchedule", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -31,16 +82,23 @@ func (datasource *SQLiteDatasource) ReportGroupFromSchedule(schedule Schedule) (
 		return nil, err
 	}
 
-	row := db.QueryRow("SELECT * FROM ReportGroup WHERE ID = ?", schedule.ReportGroupID)
+	row := db.QueryRow("SELECT id, name, description, tags, ownerTeam, memberQuery, encryptAttachments, attachmentPassword != '' FROM ReportGroup WHERE ID = ? AND deletedAt = 0", schedule.ReportGroupID)
 
-	var ID, name, description string
-	err = row.Scan(&ID, &name, &description)
+	var ID, name, description, tags, ownerTeam, memberQuery string
+	var encryptAttachments, hasAttachmentPassword bool
+	err = row.Scan(&ID, &name, &description, &tags, &ownerTeam, &memberQuery, &encryptAttachments, &hasAttachmentPassword)
 	if err != nil {
 		log.DefaultLogger.Error("ReportGroupFromSchedule: rows.Scan(): ", err.Error())
 		return nil, err
 	}
 
-	return NewReportGroup(ID, name, description), nil
+	reportGroup := NewReportGroup(ID, name, description)
+	reportGroup.Tags = tags
+	reportGroup.OwnerTeam = ownerTeam
+	reportGroup.MemberQuery = memberQuery
+	reportGroup.EncryptAttachments = encryptAttachments
+	reportGroup.HasAttachmentPassword = hasAttachmentPassword
+	return reportGroup, nil
 }
 
 func (datasource *SQLiteDatasource) GetReportGroups() ([]ReportGroup, error) {
@@ -53,7 +111,7 @@ func (datasource *SQLiteDatasource) GetReportGroups() ([]ReportGroup, error) {
 
 	var reportGroups []ReportGroup
 
-	rows, err := db.Query("SELECT * FROM ReportGroup")
+	rows, err := db.Query("SELECT id, name, description, tags, ownerTeam, memberQuery, encryptAttachments, attachmentPassword != '' FROM ReportGroup WHERE deletedAt = 0")
 	defer rows.Close()
 	if err != nil {
 		log.DefaultLogger.Error("GetReportGroups: db.Query(): ", err.Error())
@@ -61,20 +119,69 @@ func (datasource *SQLiteDatasource) GetReportGroups() ([]ReportGroup, error) {
 	}
 
 	for rows.Next() {
-		var ID, Name, Description string
-		err = rows.Scan(&ID, &Name, &Description)
+		var reportGroup ReportGroup
+		err = rows.Scan(&reportGroup.ID, &reportGroup.Name, &reportGroup.Description, &reportGroup.Tags, &reportGroup.OwnerTeam, &reportGroup.MemberQuery, &reportGroup.EncryptAttachments, &reportGroup.HasAttachmentPassword)
 		if err != nil {
 			log.DefaultLogger.Error("GetReportGroups: rows.Scan(): ", err.Error())
 			return nil, err
 		}
 
-		reportGroup := ReportGroup{ID, Name, Description}
 		reportGroups = append(reportGroups, reportGroup)
 	}
 
 	return reportGroups, nil
 }
 
+// reportGroupList is what the report group list can be searched and sorted by
+var reportGroupList = listQuery{
+	searches:    []string{"name", "description", "tags"},
+	sorts:       map[string]string{"name": "name COLLATE NOCASE", "ownerTeam": "ownerTeam"},
+	defaultSort: "name",
+}
+
+// ListReportGroups returns a page of the report groups, along with how many match the options' search in total
+func (datasource *SQLiteDatasource) ListReportGroups(options ListOptions) ([]ReportGroup, int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("ListReportGroups: sql.Open(): ", err.Error())
+		return nil, 0, err
+	}
+
+	where, args := reportGroupList.where(options, " WHERE deletedAt = 0", nil)
+	orderLimit, pageArgs, err := reportGroupList.orderLimit(options, append([]interface{}{}, args...))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.QueryRow("SELECT COUNT(*) FROM ReportGroup"+where, args...).Scan(&total)
+	if err != nil {
+		log.DefaultLogger.Error("ListReportGroups: db.QueryRow(): ", err.Error())
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT id, name, description, tags, ownerTeam, memberQuery, encryptAttachments, attachmentPassword != '' FROM ReportGroup"+where+orderLimit, pageArgs...)
+	if err != nil {
+		log.DefaultLogger.Error("ListReportGroups: db.Query(): ", err.Error())
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	reportGroups := []ReportGroup{}
+	for rows.Next() {
+		var reportGroup ReportGroup
+		err = rows.Scan(&reportGroup.ID, &reportGroup.Name, &reportGroup.Description, &reportGroup.Tags, &reportGroup.OwnerTeam, &reportGroup.MemberQuery, &reportGroup.EncryptAttachments, &reportGroup.HasAttachmentPassword)
+		if err != nil {
+			log.DefaultLogger.Error("ListReportGroups: rows.Scan(): ", err.Error())
+			return nil, 0, err
+		}
+		reportGroups = append(reportGroups, reportGroup)
+	}
+
+	return reportGroups, total, nil
+}
+
 func (datasource *SQLiteDatasource) CreateReportGroup() (*ReportGroup, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
@@ -100,6 +207,8 @@ func (datasource *SQLiteDatasource) CreateReportGroup() (*ReportGroup, error) {
 	return &reportGroup, nil
 }
 
+// UpdateReportGroup saves a report group, keeping its attachment password when it's sent without one. The password
+// is sealed, and the group returned never has it, only whether it has one.
 func (datasource *SQLiteDatasource) UpdateReportGroup(id string, reportGroup ReportGroup) (*ReportGroup, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
@@ -108,39 +217,85 @@ func (datasource *SQLiteDatasource) UpdateReportGroup(id string, reportGroup Rep
 		return nil, err
 	}
 
-	stmt, err := db.Prepare("UPDATE ReportGroup SET name = ?, description = ? where id = ?")
+	password, err := datasource.seal(reportGroup.AttachmentPassword)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateReportGroup: seal(): ", err.Error())
+		return nil, err
+	}
+
+	stmt, err := db.Prepare("UPDATE ReportGroup SET name = ?, description = ?, tags = ?, ownerTeam = ?, memberQuery = ?, encryptAttachments = ?, attachmentPassword = CASE WHEN ? = '' THEN attachmentPassword ELSE ? END where id = ?")
 	if err != nil {
 		log.DefaultLogger.Error("UpdateReportGroup: db.Prepare(): ", err.Error())
 		return nil, err
 	}
-	_, err = stmt.Exec(reportGroup.Name, reportGroup.Description, id)
+	_, err = stmt.Exec(reportGroup.Name, reportGroup.Description, reportGroup.Tags, reportGroup.OwnerTeam, reportGroup.MemberQuery, reportGroup.EncryptAttachments, password, password, id)
 	defer stmt.Close()
 	if err != nil {
 		log.DefaultLogger.Error("UpdateReportGroup: stmt.Exec(): ", err.Error())
 		return nil, err
 	}
 
+	err = db.QueryRow("SELECT attachmentPassword != '' FROM ReportGroup WHERE id = ?", id).Scan(&reportGroup.HasAttachmentPassword)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateReportGroup: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+	reportGroup.AttachmentPassword = ""
+
 	return &reportGroup, nil
 }
 
-func (datasource *SQLiteDatasource) DeleteReportGroup(id string) error {
+// GetAttachmentPassword is the password a report group's attachments are encrypted with, empty when it has none.
+// It's only read to send the group's reports, nothing else returns it.
+func (datasource *SQLiteDatasource) GetAttachmentPassword(id string) (string, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
-		log.DefaultLogger.Error("DeleteReportGroup: sql.Open(): ", err.Error())
-		return err
+		log.DefaultLogger.Error("GetAttachmentPassword: sql.Open", err.Error())
+		return "", err
 	}
 
-	stmt, err := db.Prepare("DELETE FROM ReportGroup WHERE id = ?")
-	defer stmt.Close()
+	var password string
+	err = db.QueryRow("SELECT attachmentPassword FROM ReportGroup WHERE id = ?", id).Scan(&password)
 	if err != nil {
-		log.DefaultLogger.Error("DeleteReportGroup: db.Prepare(): ", err.Error())
-		return err
+		log.DefaultLogger.Error("GetAttachmentPassword: db.QueryRow(): ", err.Error())
+		return "", err
 	}
-	stmt.Exec(id)
 
-	stmt, err = db.Prepare("DELETE FROM ReportGroupMembership WHERE reportGroupID = ?")
-	stmt.Exec(id)
+	password, err = datasource.unseal(password)
+	if err != nil {
+		log.DefaultLogger.Error("GetAttachmentPassword: unseal(): ", err.Error())
+		return "", err
+	}
+	return password, nil
+}
 
-	return nil
+// DeleteReportGroup moves a report group to the trash, where it can be restored until it is purged. Schedules
+// sending to it fail until it is restored.
+func (datasource *SQLiteDatasource) DeleteReportGroup(id string, by string) error {
+	return datasource.moveToTrash("DeleteReportGroup", "ReportGroup", id, by)
+}
+
+// PurgeReportGroup deletes a report group for good, which deletes its members and permissions too and takes its
+// schedules out of it
+func (datasource *SQLiteDatasource) PurgeReportGroup(id string) error {
+	return datasource.purge("PurgeReportGroup", "ReportGroup", id)
+}
+
+func (datasource *SQLiteDatasource) GetReportGroup(id string) (*ReportGroup, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroup: sql.Open", err.Error())
+		return nil, err
+	}
+
+	var group ReportGroup
+	err = db.QueryRow("SELECT id, name, description, tags, ownerTeam, memberQuery, encryptAttachments, attachmentPassword != '' FROM ReportGroup WHERE id = ? AND deletedAt = 0", id).Scan(&group.ID, &group.Name, &group.Description, &group.Tags, &group.OwnerTeam, &group.MemberQuery, &group.EncryptAttachments, &group.HasAttachmentPassword)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroup: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &group, nil
 }
@@ -2,9 +2,11 @@ package dbstore
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
 )
 
 type ReportGroupMembership struct {
@@ -22,6 +24,8 @@ func NewReportGroupMembership(ID string, userID string, reportGroupID string) *R
 }
 
 func (datasource *SQLiteDatasource) GroupMemberUserIDs(reportGroup ReportGroup) ([]string, error) {
+	defer metrics.ObserveDB("GroupMemberUserIDs", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -86,6 +90,24 @@ func (datasource *SQLiteDatasource) GetReportGroupMemberships(groupID string) ([
 	return memberships, nil
 }
 
+func (datasource *SQLiteDatasource) GetReportGroupMembership(id string) (*ReportGroupMembership, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroupMembership: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	var membership ReportGroupMembership
+	err = db.QueryRow("SELECT id, userID, reportGroupID FROM ReportGroupMembership WHERE id = ?", id).Scan(&membership.ID, &membership.UserID, &membership.ReportGroupID)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroupMembership: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
 func (datasource *SQLiteDatasource) CreateReportGroupMembership(members []ReportGroupMembership) ([]ReportGroupMembership, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
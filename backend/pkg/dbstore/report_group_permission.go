@@ -0,0 +1,154 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// What a grant lets a user or team do with a report group. Each permission includes those before it, so
+// editors can also attach schedules and view the group.
+const (
+	GroupPermissionView   = "view"
+	GroupPermissionAttach = "attach"
+	GroupPermissionEdit   = "edit"
+)
+
+var groupPermissionLevels = map[string]int{
+	GroupPermissionView:   1,
+	GroupPermissionAttach: 2,
+	GroupPermissionEdit:   3,
+}
+
+const (
+	GranteeUser = "user"
+	GranteeTeam = "team"
+)
+
+// ReportGroupPermission grants a Grafana user, by login, or a Grafana team, by name, access to a report group.
+// A group without any is open to everyone the roles allow, once it has one only admins and its grantees can use it.
+type ReportGroupPermission struct {
+	ReportGroupID string `json:"reportGroupID"`
+	GranteeType   string `json:"granteeType"`
+	Grantee       string `json:"grantee"`
+	Permission    string `json:"permission"`
+	GrantedBy     string `json:"grantedBy"`
+	CreatedAt     int    `json:"createdAt"`
+}
+
+func ReportGroupPermissionFields() string {
+	return "\n{\n\tgranteeType \"user\" | \"team\"\n\tgrantee string\n\tpermission \"view\" | \"attach\" | \"edit\"\n}"
+}
+
+func (permission *ReportGroupPermission) Validate() error {
+	if permission.GranteeType != GranteeUser && permission.GranteeType != GranteeTeam {
+		return errors.New("granteeType must be one of: user, team")
+	}
+	if strings.TrimSpace(permission.Grantee) == "" {
+		return errors.New("grantee is required")
+	}
+	if _, ok := groupPermissionLevels[permission.Permission]; !ok {
+		return errors.New("permission must be one of: view, attach, edit")
+	}
+
+	return nil
+}
+
+// GroupPermissionIncludes is whether having one permission allows what another is needed for
+func GroupPermissionIncludes(has string, needs string) bool {
+	return groupPermissionLevels[has] >= groupPermissionLevels[needs]
+}
+
+const reportGroupPermissionColumns = "reportGroupID, granteeType, grantee, permission, grantedBy, createdAt"
+
+func (datasource *SQLiteDatasource) GetReportGroupPermissions(groupID string) ([]ReportGroupPermission, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroupPermissions: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+reportGroupPermissionColumns+" FROM ReportGroupPermission WHERE reportGroupID = ? ORDER BY granteeType, grantee", groupID)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportGroupPermissions: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []ReportGroupPermission{}
+	for rows.Next() {
+		var permission ReportGroupPermission
+		err = rows.Scan(&permission.ReportGroupID, &permission.GranteeType, &permission.Grantee, &permission.Permission, &permission.GrantedBy, &permission.CreatedAt)
+		if err != nil {
+			log.DefaultLogger.Error("GetReportGroupPermissions: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// SetReportGroupPermission grants a user or team a permission on a group, replacing what they had before
+func (datasource *SQLiteDatasource) SetReportGroupPermission(permission ReportGroupPermission) (*ReportGroupPermission, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SetReportGroupPermission: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	permission.CreatedAt = int(time.Now().Unix())
+	_, err = db.Exec("INSERT OR REPLACE INTO ReportGroupPermission ("+reportGroupPermissionColumns+") VALUES (?,?,?,?,?,?)", permission.ReportGroupID, permission.GranteeType, permission.Grantee, permission.Permission, permission.GrantedBy, permission.CreatedAt)
+	if err != nil {
+		log.DefaultLogger.Error("SetReportGroupPermission: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &permission, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteReportGroupPermission(groupID string, granteeType string, grantee string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteReportGroupPermission: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM ReportGroupPermission WHERE reportGroupID = ? AND granteeType = ? AND grantee = ?", groupID, granteeType, grantee)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteReportGroupPermission: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ReportGroupAccess is the highest permission a user has on a group through their login or any of their teams, and
+// whether the group is restricted at all. Unrestricted groups give everyone every permission.
+func ReportGroupAccess(permissions []ReportGroupPermission, login string, teams []string) (string, bool) {
+	if len(permissions) == 0 {
+		return GroupPermissionEdit, false
+	}
+
+	inTeam := make(map[string]bool)
+	for _, team := range teams {
+		inTeam[team] = true
+	}
+
+	access := ""
+	for _, permission := range permissions {
+		granted := (permission.GranteeType == GranteeUser && permission.Grantee == login) ||
+			(permission.GranteeType == GranteeTeam && inTeam[permission.Grantee])
+		if granted && groupPermissionLevels[permission.Permission] > groupPermissionLevels[access] {
+			access = permission.Permission
+		}
+	}
+
+	return access, true
+}
@@ -0,0 +1,380 @@
+package dbstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+)
+
+const (
+	ReportRunStatusRunning = "running"
+	ReportRunStatusSent    = "sent"
+	ReportRunStatusFailed  = "failed"
+	// Sent, but some panels could not be rendered
+	ReportRunStatusPartial = "partial"
+	// Stopped by someone before it was sent
+	ReportRunStatusCancelled = "cancelled"
+	// Not sent as the schedule's condition wasn't met
+	ReportRunStatusSkipped = "skipped"
+)
+
+// ReportRun is the history of a single attempt at generating and sending a schedule's report
+type ReportRun struct {
+	ID         string `json:"id"`
+	ScheduleID string `json:"scheduleID"`
+	// When the schedule was due, 0 for runs which weren't scheduled e.g. test emails
+	ScheduledAt    int    `json:"scheduledAt"`
+	StartedAt      int    `json:"startedAt"`
+	FinishedAt     int    `json:"finishedAt"`
+	Status         string `json:"status"`
+	AttachmentMode string `json:"attachmentMode"`
+	Message        string `json:"message"`
+	// Titles of the panels which could not be rendered
+	FailedPanels []string `json:"failedPanels"`
+	// Emails sent by the run, priced at the message unit cost in the settings at the time
+	MessagesSent  int     `json:"messagesSent"`
+	EstimatedCost float64 `json:"estimatedCost"`
+	// Parts of the report sent to anyone by this attempt at the run, which isn't stored
+	PartsDelivered int `json:"-"`
+	// The run failed in a way trying again won't fix, e.g. its dashboard was deleted
+	Permanent bool `json:"permanent"`
+	// Variables set to values their dashboards no longer offer, and what was done about each
+	VariableIssues []VariableIssue `json:"variableIssues"`
+	// Sent as a test email, which is kept in the schedule's history but isn't one of its runs, so it has no cost and
+	// counts towards none of its streaks or dispatch times
+	Test bool `json:"test"`
+}
+
+// What was done about a variable value its dashboard no longer offers
+const (
+	VariableOutcomeKept   = "kept"
+	VariableOutcomeMapped = "mapped"
+	VariableOutcomeFailed = "failed"
+)
+
+// VariableIssue is a value content set a variable to which its dashboard no longer offers when the report was
+// generated, e.g. a store which has since been renamed
+type VariableIssue struct {
+	DashboardID string `json:"dashboardID"`
+	Variable    string `json:"variable"`
+	Value       string `json:"value"`
+	Outcome     string `json:"outcome"`
+	// The option it was mapped to
+	MappedTo string `json:"mappedTo,omitempty"`
+}
+
+const reportRunColumns = "id, scheduleID, scheduledAt, startedAt, finishedAt, status, attachmentMode, message, failedPanels, messagesSent, estimatedCost, permanent, variableIssues, test"
+
+func scanReportRun(row rowScanner) (*ReportRun, error) {
+	var run ReportRun
+	var failedPanels, variableIssues string
+	err := row.Scan(&run.ID, &run.ScheduleID, &run.ScheduledAt, &run.StartedAt, &run.FinishedAt, &run.Status, &run.AttachmentMode, &run.Message, &failedPanels, &run.MessagesSent, &run.EstimatedCost, &run.Permanent, &variableIssues, &run.Test)
+	if err != nil {
+		return nil, err
+	}
+
+	run.FailedPanels = []string{}
+	if failedPanels != "" {
+		json.Unmarshal([]byte(failedPanels), &run.FailedPanels)
+	}
+	run.VariableIssues = []VariableIssue{}
+	if variableIssues != "" {
+		json.Unmarshal([]byte(variableIssues), &run.VariableIssues)
+	}
+
+	return &run, nil
+}
+
+func (run *ReportRun) failedPanelsJSON() string {
+	if len(run.FailedPanels) == 0 {
+		return ""
+	}
+
+	failedPanels, _ := json.Marshal(run.FailedPanels)
+	return string(failedPanels)
+}
+
+func (run *ReportRun) variableIssuesJSON() string {
+	if len(run.VariableIssues) == 0 {
+		return ""
+	}
+
+	variableIssues, _ := json.Marshal(run.VariableIssues)
+	return string(variableIssues)
+}
+
+func (datasource *SQLiteDatasource) CreateReportRun(scheduleID string, scheduledAt int) (*ReportRun, error) {
+	return datasource.createReportRun(ReportRun{ScheduleID: scheduleID, ScheduledAt: scheduledAt})
+}
+
+// CreateTestRun records a test email of the schedule's report, separately from its runs
+func (datasource *SQLiteDatasource) CreateTestRun(scheduleID string) (*ReportRun, error) {
+	return datasource.createReportRun(ReportRun{ScheduleID: scheduleID, Test: true})
+}
+
+func (datasource *SQLiteDatasource) createReportRun(run ReportRun) (*ReportRun, error) {
+	defer metrics.ObserveDB("CreateReportRun", time.Now())
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateReportRun: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	run.ID, run.StartedAt, run.Status = uuid.New().String(), int(time.Now().Unix()), ReportRunStatusRunning
+	stmt, err := db.Prepare("INSERT INTO ReportRun (id, scheduleID, scheduledAt, startedAt, finishedAt, status, attachmentMode, message, test) VALUES (?,?,?,?,?,?,?,?,?)")
+	if err != nil {
+		log.DefaultLogger.Error("CreateReportRun: db.Prepare(): ", err.Error())
+		return nil, err
+	}
+	defer stmt.Close()
+
+	err = retryBusy("CreateReportRun", func() error {
+		_, err := stmt.Exec(run.ID, run.ScheduleID, run.ScheduledAt, run.StartedAt, run.FinishedAt, run.Status, run.AttachmentMode, run.Message, run.Test)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CreateReportRun: stmt.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &run, nil
+}
+
+func (datasource *SQLiteDatasource) UpdateReportRun(run ReportRun) error {
+	defer metrics.ObserveDB("UpdateReportRun", time.Now())
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UpdateReportRun: sql.Open(): ", err.Error())
+		return err
+	}
+
+	stmt, err := db.Prepare("UPDATE ReportRun SET finishedAt = ?, status = ?, attachmentMode = ?, message = ?, failedPanels = ?, messagesSent = ?, estimatedCost = ?, permanent = ?, variableIssues = ? WHERE id = ?")
+	if err != nil {
+		log.DefaultLogger.Error("UpdateReportRun: db.Prepare(): ", err.Error())
+		return err
+	}
+	defer stmt.Close()
+
+	err = retryBusy("UpdateReportRun", func() error {
+		_, err := stmt.Exec(run.FinishedAt, run.Status, run.AttachmentMode, run.Message, run.failedPanelsJSON(), run.MessagesSent, run.EstimatedCost, run.Permanent, run.variableIssuesJSON(), run.ID)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("UpdateReportRun: stmt.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// DeleteReportRuns removes the history of a schedule
+func (datasource *SQLiteDatasource) DeleteReportRuns(scheduleID string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteReportRuns: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM ReportRun WHERE scheduleID = ?", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteReportRuns: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (datasource *SQLiteDatasource) GetReportRuns(scheduleID string) ([]ReportRun, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRuns: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+reportRunColumns+" FROM ReportRun WHERE scheduleID = ? ORDER BY startedAt DESC", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRuns: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ReportRun{}
+	for rows.Next() {
+		run, err := scanReportRun(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetReportRuns: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, nil
+}
+
+// GetReportRunsBetween returns every schedule's runs which started in the time range, most recent first. Test
+// emails aren't runs, so they're left out.
+func (datasource *SQLiteDatasource) GetReportRunsBetween(from int, to int) ([]ReportRun, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRunsBetween: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+reportRunColumns+" FROM ReportRun WHERE startedAt >= ? AND startedAt <= ? AND test = 0 ORDER BY startedAt DESC", from, to)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRunsBetween: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ReportRun{}
+	for rows.Next() {
+		run, err := scanReportRun(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetReportRunsBetween: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, nil
+}
+
+func (datasource *SQLiteDatasource) GetReportRun(id string) (*ReportRun, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRun: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	run, err := scanReportRun(db.QueryRow("SELECT "+reportRunColumns+" FROM ReportRun WHERE id = ?", id))
+	if err != nil {
+		log.DefaultLogger.Error("GetReportRun: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// CountFailedRuns is how many attempts at sending a schedule's report which was due at scheduledAt have failed
+func (datasource *SQLiteDatasource) CountFailedRuns(scheduleID string, scheduledAt int) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CountFailedRuns: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM ReportRun WHERE scheduleID = ? AND scheduledAt = ? AND status = ?", scheduleID, scheduledAt, ReportRunStatusFailed).Scan(&count)
+	if err != nil {
+		log.DefaultLogger.Error("CountFailedRuns: db.QueryRow(): ", err.Error())
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// FailureStreak is how many scheduled runs of a schedule have failed in a row since it was last sent
+func (datasource *SQLiteDatasource) FailureStreak(scheduleID string) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("FailureStreak: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var count int
+	// Ordered by rowid rather than startedAt, as retries can start within the same second. A run skipped for its
+	// condition worked, so it ends the streak as one which was sent does.
+	err = db.QueryRow("SELECT COUNT(*) FROM ReportRun WHERE scheduleID = ? AND scheduledAt > 0 AND status = ? AND rowid > COALESCE((SELECT MAX(rowid) FROM ReportRun WHERE scheduleID = ? AND scheduledAt > 0 AND status IN (?, ?, ?)), 0)",
+		scheduleID, ReportRunStatusFailed, scheduleID, ReportRunStatusSent, ReportRunStatusPartial, ReportRunStatusSkipped).Scan(&count)
+	if err != nil {
+		log.DefaultLogger.Error("FailureStreak: db.QueryRow(): ", err.Error())
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// PermanentFailureStreak is how many of a schedule's most recent scheduled runs in a row failed permanently
+func (datasource *SQLiteDatasource) PermanentFailureStreak(scheduleID string) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("PermanentFailureStreak: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM ReportRun WHERE scheduleID = ? AND scheduledAt > 0 AND status = ? AND permanent = 1 AND rowid > COALESCE((SELECT MAX(rowid) FROM ReportRun WHERE scheduleID = ? AND scheduledAt > 0 AND status != ? AND NOT (status = ? AND permanent = 1)), 0)",
+		scheduleID, ReportRunStatusFailed, scheduleID, ReportRunStatusRunning, ReportRunStatusFailed).Scan(&count)
+	if err != nil {
+		log.DefaultLogger.Error("PermanentFailureStreak: db.QueryRow(): ", err.Error())
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountRuns is how many attempts have been made at sending a schedule's report for the time it was due
+func (datasource *SQLiteDatasource) CountRuns(scheduleID string, scheduledAt int) (int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CountRuns: sql.Open(): ", err.Error())
+		return 0, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM ReportRun WHERE scheduleID = ? AND scheduledAt = ?", scheduleID, scheduledAt).Scan(&count)
+	if err != nil {
+		log.DefaultLogger.Error("CountRuns: db.QueryRow(): ", err.Error())
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// LastDispatchTimes is when each schedule's report was last sent, by schedule ID
+func (datasource *SQLiteDatasource) LastDispatchTimes() (map[string]int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("LastDispatchTimes: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT scheduleID, MAX(finishedAt) FROM ReportRun WHERE status IN (?, ?) AND test = 0 GROUP BY scheduleID", ReportRunStatusSent, ReportRunStatusPartial)
+	if err != nil {
+		log.DefaultLogger.Error("LastDispatchTimes: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	times := make(map[string]int)
+	for rows.Next() {
+		var scheduleID string
+		var finishedAt int
+		err = rows.Scan(&scheduleID, &finishedAt)
+		if err != nil {
+			log.DefaultLogger.Error("LastDispatchTimes: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		times[scheduleID] = finishedAt
+	}
+
+	return times, nil
+}
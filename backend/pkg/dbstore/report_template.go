@@ -0,0 +1,459 @@
+package dbstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+var ErrReportTemplateNotFound = errors.New("there is no report template with this id")
+
+var ErrReportTemplateVersionNotFound = errors.New("the report template has no such version")
+
+var ErrReportTemplateInUse = errors.New("schedules still use this report template, move them off it first")
+
+// ReportTemplate is a reusable report layout: the panels it renders in order, the files it's sent as and the
+// subject and body of its emails. Schedules using it render its content ahead of their own, so changing the template
+// changes every one of them. Each save is kept as a new version, which schedules can pin.
+type ReportTemplate struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Login of who created it, who with admins can change it. Templates from before they had owners have none
+	// and any editor can change them.
+	Owner string `json:"owner"`
+	// The latest version, which schedules not pinned to one use
+	Version int `json:"version"`
+	ReportTemplateVersion
+	// Who last saved it and when, as unix seconds
+	UpdatedBy string `json:"updatedBy"`
+	UpdatedAt int    `json:"updatedAt"`
+}
+
+// ReportTemplateVersion is the layout of a template as it was saved
+type ReportTemplateVersion struct {
+	// The panels and dashboards rendered, in order. Their id and scheduleID are ignored.
+	Content []ReportContent `json:"content"`
+	// Comma separated files the report is sent as, empty keeps the schedule's
+	Formats string `json:"formats"`
+	// Subject and body of the report's emails, empty keeps the schedule's name and description
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// ReportTemplateVersionInfo is a saved version of a template, listed with when and by whom it was saved
+type ReportTemplateVersionInfo struct {
+	TemplateID string `json:"templateID"`
+	Version    int    `json:"version"`
+	ReportTemplateVersion
+	CreatedBy string `json:"createdBy"`
+	CreatedAt int    `json:"createdAt"`
+}
+
+func ReportTemplateFields() string {
+	return "\n{\n\tname string" +
+		"\n\tdescription string" +
+		"\n\tcontent []{panelID int, dashboardID string, type string (panel|dashboard), lookback int, lookbackType string, variables string, ...}" +
+		"\n\tformats string (xlsx,pptx,html,json)" +
+		"\n\tsubject string" +
+		"\n\tbody string\n}"
+}
+
+func (template *ReportTemplate) Validate() error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return errors.New("name is required")
+	}
+	for _, format := range (&Schedule{Formats: template.Formats}).FormatList() {
+		if !isFormat(format) {
+			return errors.New("formats must be a comma separated list of: " + strings.Join(formats, ", "))
+		}
+	}
+	if template.Content == nil {
+		template.Content = []ReportContent{}
+	}
+	for i := range template.Content {
+		content := &template.Content[i]
+		content.ID, content.ScheduleID = "", ""
+		content.Type = contentType(content.Type)
+		if content.DashboardID == "" && !content.IsText() {
+			return fmt.Errorf("content %d: dashboardID is required", i+1)
+		}
+		if err := content.Validate(); err != nil {
+			return fmt.Errorf("content %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ContentFor is the template's content as the schedule's, ahead of its own, in order of position like a schedule's.
+// Each item gets an id from the template's so it's the same from one run to the next.
+func (version *ReportTemplateVersion) ContentFor(templateID string, schedule Schedule) []ReportContent {
+	content := make([]ReportContent, len(version.Content))
+	for i, item := range version.Content {
+		item.ID = fmt.Sprintf("%s-%d", templateID, i+1)
+		item.ScheduleID = schedule.ID
+		content[i] = item
+	}
+	sort.SliceStable(content, func(i, j int) bool { return content[i].Position < content[j].Position })
+	return content
+}
+
+// Apply is the schedule with the template's files and email body in place of its own, where the template has them
+func (version *ReportTemplateVersion) Apply(schedule Schedule) Schedule {
+	if strings.TrimSpace(version.Formats) != "" {
+		schedule.Formats = version.Formats
+	}
+	if version.Body != "" {
+		schedule.Description = version.Body
+	}
+	return schedule
+}
+
+// SubjectFor is the subject of the emails of a schedule's report, the schedule's name unless the template has its own
+func (version *ReportTemplateVersion) SubjectFor(schedule Schedule) string {
+	if version.Subject != "" {
+		return version.Subject
+	}
+	return schedule.Name
+}
+
+func (version *ReportTemplateVersion) contentJSON() (string, error) {
+	content, err := json.Marshal(version.Content)
+	return string(content), err
+}
+
+func scanReportTemplateVersion(row rowScanner) (*ReportTemplateVersionInfo, error) {
+	var version ReportTemplateVersionInfo
+	var content string
+	err := row.Scan(&version.TemplateID, &version.Version, &content, &version.Formats, &version.Subject, &version.Body, &version.CreatedBy, &version.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	version.Content = []ReportContent{}
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), &version.Content); err != nil {
+			return nil, err
+		}
+	}
+	return &version, nil
+}
+
+const reportTemplateVersionColumns = "templateID, version, content, formats, subject, body, createdBy, createdAt"
+
+const reportTemplateQuery = "SELECT t.id, t.name, t.description, t.owner, t.version, v.content, v.formats, v.subject, v.body, v.createdBy, v.createdAt FROM ReportTemplate t JOIN ReportTemplateVersion v ON v.templateID = t.id AND v.version = t.version"
+
+func scanReportTemplate(row rowScanner) (*ReportTemplate, error) {
+	var template ReportTemplate
+	var content string
+	err := row.Scan(&template.ID, &template.Name, &template.Description, &template.Owner, &template.Version, &content, &template.Formats, &template.Subject, &template.Body, &template.UpdatedBy, &template.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	template.Content = []ReportContent{}
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), &template.Content); err != nil {
+			return nil, err
+		}
+	}
+	return &template, nil
+}
+
+// GetReportTemplates is every template at its latest version, by name
+func (datasource *SQLiteDatasource) GetReportTemplates() ([]ReportTemplate, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplates: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query(reportTemplateQuery + " ORDER BY t.name")
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplates: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []ReportTemplate{}
+	for rows.Next() {
+		template, err := scanReportTemplate(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetReportTemplates: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+
+	return templates, nil
+}
+
+// GetReportTemplate is a template at its latest version
+func (datasource *SQLiteDatasource) GetReportTemplate(id string) (*ReportTemplate, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplate: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	template, err := scanReportTemplate(db.QueryRow(reportTemplateQuery+" WHERE t.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrReportTemplateNotFound
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplate: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return template, nil
+}
+
+// GetReportTemplateVersions lists every saved version of a template, newest first
+func (datasource *SQLiteDatasource) GetReportTemplateVersions(id string) ([]ReportTemplateVersionInfo, error) {
+	if _, err := datasource.GetReportTemplate(id); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplateVersions: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+reportTemplateVersionColumns+" FROM ReportTemplateVersion WHERE templateID = ? ORDER BY version DESC", id)
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplateVersions: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []ReportTemplateVersionInfo{}
+	for rows.Next() {
+		version, err := scanReportTemplateVersion(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetReportTemplateVersions: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		versions = append(versions, *version)
+	}
+
+	return versions, nil
+}
+
+// GetReportTemplateVersion is a template as it was saved at a version, its latest for 0
+func (datasource *SQLiteDatasource) GetReportTemplateVersion(id string, version int) (*ReportTemplateVersionInfo, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplateVersion: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	query := "SELECT " + reportTemplateVersionColumns + " FROM ReportTemplateVersion WHERE templateID = ? AND version = ?"
+	args := []interface{}{id, version}
+	if version == 0 {
+		query = "SELECT " + reportTemplateVersionColumns + " FROM ReportTemplateVersion WHERE templateID = ? AND version = (SELECT version FROM ReportTemplate WHERE id = ?)"
+		args = []interface{}{id, id}
+	}
+
+	info, err := scanReportTemplateVersion(db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		if _, err := datasource.GetReportTemplate(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrReportTemplateVersionNotFound
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetReportTemplateVersion: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// CreateReportTemplate saves a new template as its first version, owned by whoever created it
+func (datasource *SQLiteDatasource) CreateReportTemplate(template ReportTemplate, by string) (*ReportTemplate, error) {
+	template.ID = uuid.New().String()
+	template.Owner = by
+	template.Version = 0
+	return datasource.saveReportTemplate("CreateReportTemplate", template, by, true)
+}
+
+// UpdateReportTemplate saves a template as a new version, which every schedule using it and not pinned to a version
+// renders from then on
+func (datasource *SQLiteDatasource) UpdateReportTemplate(id string, template ReportTemplate, by string) (*ReportTemplate, error) {
+	existing, err := datasource.GetReportTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	template.ID = id
+	template.Owner = existing.Owner
+	template.Version = existing.Version
+	return datasource.saveReportTemplate("UpdateReportTemplate", template, by, false)
+}
+
+// RestoreReportTemplateVersion saves an earlier version of a template as its newest, so the versions in between are
+// kept
+func (datasource *SQLiteDatasource) RestoreReportTemplateVersion(id string, version int, by string) (*ReportTemplate, error) {
+	existing, err := datasource.GetReportTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := datasource.GetReportTemplateVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	existing.ReportTemplateVersion = restored.ReportTemplateVersion
+	return datasource.saveReportTemplate("RestoreReportTemplateVersion", *existing, by, false)
+}
+
+// saveReportTemplate writes the template and its next version in one transaction
+func (datasource *SQLiteDatasource) saveReportTemplate(caller string, template ReportTemplate, by string, create bool) (*ReportTemplate, error) {
+	content, err := template.contentJSON()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": json.Marshal(): ", err.Error())
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	template.UpdatedBy = by
+	template.UpdatedAt = int(time.Now().Unix())
+	err = retryBusy(caller, func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Numbered from the table rather than what was read, so saves at the same time don't share a version
+		err = tx.QueryRow("SELECT COALESCE(MAX(version), 0) + 1 FROM ReportTemplateVersion WHERE templateID = ?", template.ID).Scan(&template.Version)
+		if err != nil {
+			return err
+		}
+
+		if create {
+			_, err = tx.Exec("INSERT INTO ReportTemplate (id, name, description, owner, version) VALUES (?,?,?,?,?)", template.ID, template.Name, template.Description, template.Owner, template.Version)
+		} else {
+			_, err = tx.Exec("UPDATE ReportTemplate SET name = ?, description = ?, version = ? WHERE id = ?", template.Name, template.Description, template.Version, template.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO ReportTemplateVersion ("+reportTemplateVersionColumns+") VALUES (?,?,?,?,?,?,?,?)", template.ID, template.Version, content, template.Formats, template.Subject, template.Body, template.UpdatedBy, template.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		log.DefaultLogger.Error(caller+": ", err.Error())
+		return nil, err
+	}
+
+	return &template, nil
+}
+
+// DeleteReportTemplate deletes a template no schedule uses any more, with its versions. Schedules in the trash don't
+// count, they stop using it so they're restored with their own content.
+func (datasource *SQLiteDatasource) DeleteReportTemplate(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteReportTemplate: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("DeleteReportTemplate", func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Counted in the transaction, so a schedule restored from the trash meanwhile isn't left without it
+		var inUse int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM Schedule WHERE templateID = ? AND deletedAt = 0", id).Scan(&inUse); err != nil {
+			return err
+		}
+		if inUse > 0 {
+			return ErrReportTemplateInUse
+		}
+
+		result, err := tx.Exec("DELETE FROM ReportTemplate WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			return ErrReportTemplateNotFound
+		}
+		if _, err := tx.Exec("DELETE FROM ReportTemplateVersion WHERE templateID = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE Schedule SET templateID = '', templateVersion = 0 WHERE templateID = ? AND deletedAt != 0", id); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil && err != ErrReportTemplateNotFound && err != ErrReportTemplateInUse {
+		log.DefaultLogger.Error("DeleteReportTemplate: ", err.Error())
+	}
+	return err
+}
+
+// TemplateSchedule is a schedule using a template, with the version it's pinned to, 0 following the latest
+type TemplateSchedule struct {
+	ID              string `json:"id"`
+	Name            string `json:"name"`
+	TemplateVersion int    `json:"templateVersion"`
+}
+
+// GetTemplateSchedules lists the schedules using a template
+func (datasource *SQLiteDatasource) GetTemplateSchedules(id string) ([]TemplateSchedule, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetTemplateSchedules: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id, name, templateVersion FROM Schedule WHERE templateID = ? AND deletedAt = 0 ORDER BY name", id)
+	if err != nil {
+		log.DefaultLogger.Error("GetTemplateSchedules: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []TemplateSchedule{}
+	for rows.Next() {
+		var schedule TemplateSchedule
+		if err := rows.Scan(&schedule.ID, &schedule.Name, &schedule.TemplateVersion); err != nil {
+			log.DefaultLogger.Error("GetTemplateSchedules: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, nil
+}
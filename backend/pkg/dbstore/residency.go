@@ -0,0 +1,156 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Channels reports can be delivered through, which residency rules can restrict
+var channels = []string{ChannelEmail}
+
+// ResidencyRule keeps the reports of groups with a tag on approved infrastructure: they may only be
+// delivered through the channel to one of AllowedHosts, and not through the channel at all when it's empty
+type ResidencyRule struct {
+	ID      string `json:"id"`
+	Tag     string `json:"tag"`
+	Channel string `json:"channel"`
+	// Comma separated host names, e.g. the organisation's own mail server
+	AllowedHosts string `json:"allowedHosts"`
+}
+
+func ResidencyRuleFields() string {
+	return "\n{\n\ttag string" +
+		"\n\tchannel string (email)" +
+		"\n\tallowedHosts string\n}"
+}
+
+func (rule *ResidencyRule) Validate() error {
+	rule.Tag = strings.ToLower(strings.TrimSpace(rule.Tag))
+	if rule.Tag == "" {
+		return errors.New("tag is required")
+	}
+	for _, channel := range channels {
+		if rule.Channel == channel {
+			return nil
+		}
+	}
+	return errors.New("channel must be one of: " + strings.Join(channels, ", "))
+}
+
+func (rule *ResidencyRule) allows(host string) bool {
+	host = strings.ToLower(strings.TrimSpace(host))
+	for _, allowed := range strings.Split(rule.AllowedHosts, ",") {
+		if allowed = strings.ToLower(strings.TrimSpace(allowed)); allowed != "" && allowed == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (datasource *SQLiteDatasource) GetResidencyRules() ([]ResidencyRule, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetResidencyRules: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id, tag, channel, allowedHosts FROM ResidencyRule ORDER BY tag, channel")
+	if err != nil {
+		log.DefaultLogger.Error("GetResidencyRules: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []ResidencyRule{}
+	for rows.Next() {
+		var rule ResidencyRule
+		err = rows.Scan(&rule.ID, &rule.Tag, &rule.Channel, &rule.AllowedHosts)
+		if err != nil {
+			log.DefaultLogger.Error("GetResidencyRules: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (datasource *SQLiteDatasource) CreateResidencyRule(rule ResidencyRule) (*ResidencyRule, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateResidencyRule: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rule.ID = uuid.New().String()
+	_, err = db.Exec("INSERT INTO ResidencyRule (id, tag, channel, allowedHosts) VALUES (?,?,?,?)", rule.ID, rule.Tag, rule.Channel, rule.AllowedHosts)
+	if err != nil {
+		log.DefaultLogger.Error("CreateResidencyRule: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteResidencyRule(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteResidencyRule: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM ResidencyRule WHERE id = ?", id)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteResidencyRule: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CheckResidency returns an error when a report group's reports may not be delivered through the channel to the host.
+// Every rule for one of the group's tags has to allow it.
+func (datasource *SQLiteDatasource) CheckResidency(reportGroup ReportGroup, channel string, host string) error {
+	tags := reportGroup.TagList()
+	if len(tags) == 0 {
+		return nil
+	}
+
+	rules, err := datasource.GetResidencyRules()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.Channel != channel {
+			continue
+		}
+		for _, tag := range tags {
+			if tag == rule.Tag && !rule.allows(host) {
+				return fmt.Errorf("data residency: report group '%s' is tagged '%s', which may not be sent by %s through %s", reportGroup.Name, tag, channel, host)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckRecipientResidency returns an error when any of the addresses chosen to send a report group's report to,
+// rather than its own recipients, is at a domain the group's reports may not be delivered to through the channel
+func (datasource *SQLiteDatasource) CheckRecipientResidency(reportGroup ReportGroup, channel string, addresses []string) error {
+	for _, address := range addresses {
+		domain := address[strings.LastIndex(address, "@")+1:]
+		if err := datasource.CheckResidency(reportGroup, channel, domain); err != nil {
+			return fmt.Errorf("%s: %w", address, err)
+		}
+	}
+	return nil
+}
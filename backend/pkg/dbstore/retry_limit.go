@@ -0,0 +1,73 @@
+package dbstore
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Stored for a limit of no retries, as 0 was stored for every limit which wasn't set before a limit could be 0
+const noRetriesColumn = -1
+
+// RetryLimit is how many times a failing report is retried before it waits until it is next due. One which isn't
+// set is left to the layer below, so it's null in JSON, while a limit of 0 doesn't retry at all.
+type RetryLimit struct {
+	Retries int
+	Set     bool
+}
+
+// Retries is a limit of some retries, 0 for none
+func Retries(retries int) RetryLimit {
+	return RetryLimit{Retries: retries, Set: true}
+}
+
+func (limit RetryLimit) MarshalJSON() ([]byte, error) {
+	if !limit.Set {
+		return []byte("null"), nil
+	}
+	return json.Marshal(limit.Retries)
+}
+
+func (limit *RetryLimit) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*limit = RetryLimit{}
+		return nil
+	}
+	var retries int
+	if err := json.Unmarshal(data, &retries); err != nil {
+		return err
+	}
+	*limit = Retries(retries)
+	return nil
+}
+
+func (limit *RetryLimit) Scan(value interface{}) error {
+	var stored int64
+	switch value := value.(type) {
+	case nil:
+	case int64:
+		stored = value
+	default:
+		return fmt.Errorf("can't read a retry limit from %T", value)
+	}
+
+	switch {
+	case stored == noRetriesColumn:
+		*limit = Retries(0)
+	case stored > 0:
+		*limit = Retries(int(stored))
+	default:
+		*limit = RetryLimit{}
+	}
+	return nil
+}
+
+func (limit RetryLimit) Value() (driver.Value, error) {
+	switch {
+	case !limit.Set:
+		return int64(0), nil
+	case limit.Retries == 0:
+		return int64(noRetriesColumn), nil
+	}
+	return int64(limit.Retries), nil
+}
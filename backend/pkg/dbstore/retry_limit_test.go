@@ -0,0 +1,37 @@
+package dbstore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNoRetriesIsKeptApartFromUnset(t *testing.T) {
+	datasource := newTestDatasource(t)
+	created, err := datasource.CreateSchedule("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.MaxRetries.Set {
+		t.Fatalf("expected a new schedule to use the default retries, got %+v", created.MaxRetries)
+	}
+
+	var schedule Schedule
+	if err := json.Unmarshal([]byte(`{"maxRetries":0}`), &schedule); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := datasource.UpdateSchedule(created.ID, schedule); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := datasource.GetSchedule(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.MaxRetries != Retries(0) {
+		t.Fatalf("expected no retries to be saved, got %+v", saved.MaxRetries)
+	}
+
+	effective := ResolveSettings(&Settings{DefaultMaxRetries: Retries(3)}, *saved, nil)
+	if effective.MaxRetries != Retries(0) || effective.Sources["maxRetries"] != SettingSourceSchedule {
+		t.Errorf("expected the schedule's no retries to override the default, got %+v from %s", effective.MaxRetries, effective.Sources["maxRetries"])
+	}
+}
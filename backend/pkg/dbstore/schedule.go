@@ -4,15 +4,23 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
 )
 
 type Schedule struct {
-	ID             string `json:"id"`
-	Interval       int    `json:"interval"`
+	ID       string `json:"id"`
+	Interval int    `json:"interval"`
+	// Number of weeks between runs for IntervalWeeks
+	Every int `json:"every"`
+	// Date (YYYY-MM-DD) runs are counted from, so e.g. a quarterly schedule can run in Feb, May, Aug and Nov.
+	// Empty counts from now when the schedule is saved, as schedules always have. Replaces day when set.
+	AnchorDate     string `json:"anchorDate"`
 	NextReportTime int    `json:"nextReportTime"`
 	Name           string `json:"name"`
 	Description    string `json:"description"`
@@ -20,18 +28,293 @@ type Schedule struct {
 	ReportGroupID  string `json:"reportGroupID"`
 	Time           string `json:"time"`
 	Day            int    `json:"day"`
+	// Defaults for rendering panel images, which each content item can override
+	RenderWidth  int     `json:"renderWidth"`
+	RenderHeight int     `json:"renderHeight"`
+	RenderScale  float64 `json:"renderScale"`
+	RenderTheme  string  `json:"renderTheme"`
+	// TriggerTypeTime sends at nextReportTime, TriggerTypeData waits after nextReportTime
+	// until the result of TriggerQuery changes, i.e. new data for the period has synced
+	TriggerType  string `json:"triggerType"`
+	TriggerQuery string `json:"triggerQuery"`
+	// Result of TriggerQuery when the report was last sent, only set by the scheduler
+	TriggerValue string `json:"triggerValue"`
+	// Percentage of reports which should be delivered within SLOWindow seconds of being due, 0 disables tracking
+	SLOTarget float64 `json:"sloTarget"`
+	SLOWindow int     `json:"sloWindow"`
+	// Override the organisation defaults when set
+	Locale     string     `json:"locale"`
+	MaxRetries RetryLimit `json:"maxRetries"`
+	// Login of the Grafana user who created the schedule, empty for schedules created before ownership
+	Owner string `json:"owner"`
+	// Name of the Grafana team whose members can manage the schedule as if they owned it, empty for none
+	OwnerTeam string `json:"ownerTeam"`
+	// Comma separated files the report is sent as, empty sends only the Excel workbook
+	Formats string `json:"formats"`
+	// What to do with runs missed while Grafana was down, empty uses the organisation default
+	CatchUp string `json:"catchUp"`
+	// What to do with runs which fall in one of the schedule's blackouts, empty postpones them
+	BlackoutPolicy string `json:"blackoutPolicy"`
+	// IANA name of the timezone the schedule's times and calendar lookbacks are in, empty uses the server's
+	Timezone string `json:"timezone"`
+	// Override the organisation's sender when set, so replies go to the program team. Headers are merged with the
+	// organisation's, replacing any of the same name.
+	FromName     string `json:"fromName"`
+	ReplyTo      string `json:"replyTo"`
+	EmailHeaders string `json:"emailHeaders"`
+	// The email profile the schedule sends with, empty sends with the account in the settings
+	EmailProfileID string `json:"emailProfileID"`
+	// UID of a Grafana playlist whose dashboards the report renders in order, ahead of the schedule's own content
+	PlaylistUID string `json:"playlistUID"`
+	// Reports of higher priority jump the queue when more are due than can be generated at once, empty is normal
+	Priority string `json:"priority"`
+	// When the schedule was disabled for failing the same way over and over, and why, 0 while it's enabled. It
+	// isn't sent again until someone re-enables it.
+	DisabledAt     int    `json:"disabledAt"`
+	DisabledReason string `json:"disabledReason"`
+	// The report template whose layout the schedule renders ahead of its own content, empty for none, and the
+	// version it's pinned to, 0 following the template's latest
+	TemplateID      string `json:"templateID"`
+	TemplateVersion int    `json:"templateVersion"`
+	// Scheduled reports are only sent when the condition is met, e.g. there are stock-outs to report. Its value is
+	// the first cell of the query's result, or of a panel's data when a dashboard is set, compared to the threshold
+	// with the operator. No operator is no condition.
+	ConditionQuery       string  `json:"conditionQuery"`
+	ConditionDashboardID string  `json:"conditionDashboardID"`
+	ConditionPanelID     int     `json:"conditionPanelID"`
+	ConditionOperator    string  `json:"conditionOperator"`
+	ConditionThreshold   float64 `json:"conditionThreshold"`
+	// Scheduled reports carry a link for each recipient to mark them as reviewed, which is recorded
+	TrackAcknowledgement bool `json:"trackAcknowledgement"`
+}
+
+// How often a schedule is due
+const (
+	IntervalDaily       = 0
+	IntervalWeekly      = 1
+	IntervalFortnightly = 2
+	IntervalMonthly     = 3
+	IntervalQuarterly   = 4
+	IntervalYearly      = 5
+	IntervalBiannual    = 6
+	// Every schedule.Every weeks
+	IntervalWeeks = 7
+)
+
+const anchorDateLayout = "2006-01-02"
+
+const (
+	TriggerTypeTime = "time"
+	TriggerTypeData = "data"
+)
+
+// Files a report can be sent as
+const (
+	FormatXLSX = "xlsx"
+	FormatPPTX = "pptx"
+	FormatHTML = "html"
+	// All of the report's data and the run's details, for other systems to read
+	FormatJSON = "json"
+)
+
+// What the scheduler does with runs which were missed, e.g. because Grafana was down when they were due
+const (
+	// Drop missed runs and wait until the schedule is next due
+	CatchUpSkip = "skip"
+	// Send a single report for the most recent missed run
+	CatchUpOnce = "once"
+	// Send a report for every missed run, oldest first
+	CatchUpAll = "all"
+)
+
+// How soon a schedule's reports are generated when they queue
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// PriorityRank orders priorities, higher ranks going first
+func PriorityRank(priority string) int {
+	switch priority {
+	case PriorityHigh:
+		return 1
+	case PriorityLow:
+		return -1
+	}
+	return 0
+}
+
+func isCatchUp(catchUp string) bool {
+	switch catchUp {
+	case "", CatchUpSkip, CatchUpOnce, CatchUpAll:
+		return true
+	}
+	return false
+}
+
+var formats = []string{FormatXLSX, FormatPPTX, FormatHTML, FormatJSON}
+
+func isFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatList is the files the report is sent as, in the order they are attached
+func (schedule *Schedule) FormatList() []string {
+	var list []string
+	for _, format := range strings.Split(schedule.Formats, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			list = append(list, format)
+		}
+	}
+	if len(list) == 0 {
+		return []string{FormatXLSX}
+	}
+	return list
+}
+
+const scheduleColumns = "id, interval, nextReportTime, name, description, lookback, reportGroupID, time, day, every, anchorDate, renderWidth, renderHeight, renderScale, renderTheme, triggerType, triggerQuery, triggerValue, sloTarget, sloWindow, locale, maxRetries, owner, formats, catchUp, blackoutPolicy, timezone, ownerTeam, fromName, replyTo, emailHeaders, emailProfileID, playlistUID, priority, disabledAt, disabledReason, templateID, templateVersion, conditionQuery, conditionDashboardID, conditionPanelID, conditionOperator, conditionThreshold, trackAcknowledgement"
+
+func scanSchedule(row rowScanner) (*Schedule, error) {
+	var schedule Schedule
+	err := row.Scan(&schedule.ID, &schedule.Interval, &schedule.NextReportTime, &schedule.Name, &schedule.Description, &schedule.Lookback, &schedule.ReportGroupID, &schedule.Time, &schedule.Day, &schedule.Every, &schedule.AnchorDate, &schedule.RenderWidth, &schedule.RenderHeight, &schedule.RenderScale, &schedule.RenderTheme, &schedule.TriggerType, &schedule.TriggerQuery, &schedule.TriggerValue, &schedule.SLOTarget, &schedule.SLOWindow, &schedule.Locale, &schedule.MaxRetries, &schedule.Owner, &schedule.Formats, &schedule.CatchUp, &schedule.BlackoutPolicy, &schedule.Timezone, &schedule.OwnerTeam, &schedule.FromName, &schedule.ReplyTo, &schedule.EmailHeaders, &schedule.EmailProfileID, &schedule.PlaylistUID, &schedule.Priority, &schedule.DisabledAt, &schedule.DisabledReason, &schedule.TemplateID, &schedule.TemplateVersion, &schedule.ConditionQuery, &schedule.ConditionDashboardID, &schedule.ConditionPanelID, &schedule.ConditionOperator, &schedule.ConditionThreshold, &schedule.TrackAcknowledgement)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+// values are the schedule's scheduleColumns, for inserting it
+func (schedule *Schedule) values() []interface{} {
+	return []interface{}{schedule.ID, schedule.Interval, schedule.NextReportTime, schedule.Name, schedule.Description, schedule.Lookback, schedule.ReportGroupID, schedule.Time, schedule.Day, schedule.Every, schedule.AnchorDate, schedule.RenderWidth, schedule.RenderHeight, schedule.RenderScale, schedule.RenderTheme, schedule.TriggerType, schedule.TriggerQuery, schedule.TriggerValue, schedule.SLOTarget, schedule.SLOWindow, schedule.Locale, schedule.MaxRetries, schedule.Owner, schedule.Formats, schedule.CatchUp, schedule.BlackoutPolicy, schedule.Timezone, schedule.OwnerTeam, schedule.FromName, schedule.ReplyTo, schedule.EmailHeaders, schedule.EmailProfileID, schedule.PlaylistUID, schedule.Priority, schedule.DisabledAt, schedule.DisabledReason, schedule.TemplateID, schedule.TemplateVersion, schedule.ConditionQuery, schedule.ConditionDashboardID, schedule.ConditionPanelID, schedule.ConditionOperator, schedule.ConditionThreshold, schedule.TrackAcknowledgement}
 }
 
 func ScheduleFields() string {
 	return "\n{\n\tID string" +
-		"\n\tinterval int" +
+		"\n\tinterval int (0 daily|1 weekly|2 fortnightly|3 monthly|4 quarterly|5 yearly|6 biannual|7 every n weeks)" +
 		"\n\tnextReportTime int\n" +
 		"\n\tname string\n" +
 		"\n\tdescription string\n" +
 		"\n\tlookback int\n" +
 		"\n\treportGroupID string\n" +
 		"\n\time string\n" +
-		"\n\nday int\n}"
+		"\n\nday int\n" +
+		"\n\tevery int\n" +
+		"\n\tanchorDate string (YYYY-MM-DD)\n" +
+		"\n\trenderWidth int\n" +
+		"\n\trenderHeight int\n" +
+		"\n\trenderScale float\n" +
+		"\n\trenderTheme string (light|dark)\n" +
+		"\n\ttriggerType string (time|data)\n" +
+		"\n\ttriggerQuery string\n" +
+		"\n\tsloTarget float (0-100)\n" +
+		"\n\tsloWindow int\n" +
+		"\n\tlocale string\n" +
+		"\n\tmaxRetries int (null uses the default, 0 doesn't retry)\n" +
+		"\n\tformats string (xlsx,pptx,html,json)\n" +
+		"\n\tcatchUp string (skip|once|all)\n" +
+		"\n\tblackoutPolicy string (postpone|skip)\n" +
+		"\n\ttimezone string\n" +
+		"\n\townerTeam string\n" +
+		"\n\tfromName string\n" +
+		"\n\treplyTo string\n" +
+		"\n\temailHeaders string (Name: value, one a line)\n" +
+		"\n\temailProfileID string\n" +
+		"\n\tplaylistUID string\n" +
+		"\n\tpriority string (high|normal|low)\n" +
+		"\n\ttemplateID string\n" +
+		"\n\ttemplateVersion int (0 follows the latest)\n" +
+		"\n\tconditionQuery string\n" +
+		"\n\tconditionDashboardID string\n" +
+		"\n\tconditionPanelID int\n" +
+		"\n\tconditionOperator string (>|>=|<|<=|=|!=, empty for no condition)\n" +
+		"\n\tconditionThreshold float\n" +
+		"\n\ttrackAcknowledgement bool\n}"
+}
+
+// Validate checks the schedule can be run before it is saved
+func (schedule *Schedule) Validate() error {
+	if schedule.Interval < IntervalDaily || schedule.Interval > IntervalWeeks {
+		return errors.New("interval must be between 0 and 7")
+	}
+	if schedule.Interval == IntervalWeeks && (schedule.Every < 1 || schedule.Every > 52) {
+		return errors.New("every must be between 1 and 52 when interval is every n weeks")
+	}
+	if schedule.AnchorDate != "" {
+		if _, err := time.Parse(anchorDateLayout, schedule.AnchorDate); err != nil {
+			return errors.New("anchorDate must be a date formatted YYYY-MM-DD")
+		}
+	}
+
+	switch schedule.TriggerType {
+	case "", TriggerTypeTime:
+	case TriggerTypeData:
+		if strings.TrimSpace(schedule.TriggerQuery) == "" {
+			return errors.New("triggerQuery is required when triggerType is data")
+		}
+	default:
+		return errors.New("triggerType must be one of: time, data")
+	}
+
+	if schedule.SLOTarget < 0 || schedule.SLOTarget > 100 {
+		return errors.New("sloTarget must be between 0 and 100")
+	}
+	if schedule.MaxRetries.Retries < 0 {
+		return errors.New("maxRetries can't be negative")
+	}
+	if schedule.SLOTarget > 0 && schedule.SLOWindow <= 0 {
+		return errors.New("sloWindow is required when sloTarget is set")
+	}
+	for _, format := range schedule.FormatList() {
+		if !isFormat(format) {
+			return errors.New("formats must be a comma separated list of: " + strings.Join(formats, ", "))
+		}
+	}
+	if !isCatchUp(schedule.CatchUp) {
+		return errors.New("catchUp must be one of: skip, once, all")
+	}
+	if err := checkFromName(schedule.FromName); err != nil {
+		return errors.New("fromName " + err.Error())
+	}
+	if schedule.ReplyTo != "" {
+		if err := CheckEmailSyntax(schedule.ReplyTo); err != nil {
+			return errors.New("replyTo " + err.Error())
+		}
+	}
+	if _, err := ParseEmailHeaders(schedule.EmailHeaders); err != nil {
+		return errors.New("emailHeaders: " + err.Error())
+	}
+	if schedule.EmailProfileID == DefaultEmailProfileID {
+		schedule.EmailProfileID = ""
+	}
+	schedule.PlaylistUID = strings.TrimSpace(schedule.PlaylistUID)
+	if schedule.TemplateVersion < 0 || schedule.TemplateID == "" && schedule.TemplateVersion != 0 {
+		return errors.New("templateVersion must be 0 or a version of the schedule's template")
+	}
+	switch schedule.Priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+	default:
+		return errors.New("priority must be one of: high, normal, low")
+	}
+	switch schedule.BlackoutPolicy {
+	case "", BlackoutPolicyPostpone, BlackoutPolicySkip:
+	default:
+		return errors.New("blackoutPolicy must be one of: postpone, skip")
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return errors.New("timezone must be an IANA timezone such as Pacific/Auckland")
+	}
+	if err := schedule.validateCondition(); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func NewSchedule(ID string, interval int, nextReportTime int, name string, description string, lookback int, reportGroupID string, time string, day int) Schedule {
@@ -40,7 +323,28 @@ func NewSchedule(ID string, interval int, nextReportTime int, name string, descr
 }
 
 func (schedule *Schedule) UpdateNextReportTime() {
-	now := time.Now()
+	schedule.NextReportTime = schedule.NextReportTimeAfter(clock.System.Now())
+	log.DefaultLogger.Info(fmt.Sprintf("Setting time of schedule '%s' to '%s'", schedule.Name, time.Unix(int64(schedule.NextReportTime), 0)))
+}
+
+// Location is the schedule's timezone, or the server's when it has none or it can't be loaded
+func (schedule *Schedule) Location() *time.Location {
+	if schedule.Timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		log.DefaultLogger.Warn("Schedule.Location: time.LoadLocation: " + err.Error())
+		return time.Local
+	}
+	return location
+}
+
+// NextReportTimeAfter is when the schedule is due after the given time, which the scheduler uses to work out
+// every run that was missed since nextReportTime
+func (schedule *Schedule) NextReportTimeAfter(now time.Time) int {
+	now = now.In(schedule.Location())
+
 	daysOffset := 1
 	if schedule.Day > 0 {
 		daysOffset = schedule.Day
@@ -50,47 +354,130 @@ func (schedule *Schedule) UpdateNextReportTime() {
 	// there is probably a better way to parse the time string, it didn't work for me though
 	timeOfDay, err := time.Parse(time.RFC3339, "1970-01-01T"+schedule.Time+":00+00:00")
 	if err == nil {
-		log.DefaultLogger.Info(fmt.Sprintf("Adding time of '%s' to '%s'", schedule.Time, reportTime))
 		reportTime = time.Date(reportTime.Year(), reportTime.Month(), reportTime.Day(), timeOfDay.Hour(), timeOfDay.Minute(), 0, 0, now.Location())
 	}
 
+	if anchor, err := time.ParseInLocation(anchorDateLayout, schedule.AnchorDate, now.Location()); err == nil {
+		anchor = time.Date(anchor.Year(), anchor.Month(), anchor.Day(), reportTime.Hour(), reportTime.Minute(), 0, 0, now.Location())
+		return int(schedule.anchoredAfter(anchor, now).Unix())
+	}
+
 	// for the intervals using x Day of y, remove the current Day value
 	daysOffset = (-1 * int(reportTime.Day())) + daysOffset
 
 	switch schedule.Interval {
-	case 5: //yearly
+	case IntervalYearly:
 		if daysOffset > 365 {
 			reportTime = reportTime.AddDate(2, 0, -reportTime.Day())
 		} else {
 			reportTime = reportTime.AddDate(1, 0, daysOffset)
 		}
-	case 4: // quarterly
+	case IntervalBiannual:
+		if daysOffset > 184 {
+			reportTime = reportTime.AddDate(0, 12, -reportTime.Day())
+		} else {
+			reportTime = reportTime.AddDate(0, 6, daysOffset)
+		}
+	case IntervalQuarterly:
 		if daysOffset > 93 {
 			reportTime = reportTime.AddDate(0, 6, -reportTime.Day())
 		} else {
 			reportTime = reportTime.AddDate(0, 3, daysOffset)
 		}
-	case 3: // monthly
+	case IntervalMonthly:
 		if daysOffset > 31 {
 			reportTime = reportTime.AddDate(0, 2, -reportTime.Day())
 		} else {
 			reportTime = reportTime.AddDate(0, 1, daysOffset)
 		}
-	case 2: // fortnightly
+	case IntervalFortnightly:
 		reportTime = reportTime.AddDate(0, 0, 14)
-	case 1: // weekly
+	case IntervalWeekly:
 		reportTime = reportTime.AddDate(0, 0, 7)
-	default: // 0 == daily
-		if reportTime.Unix() < now.Unix() {
+	case IntervalWeeks:
+		reportTime = reportTime.AddDate(0, 0, 7*schedule.weeks())
+	default: // IntervalDaily
+		if !reportTime.After(now) {
 			// run tomorrow
 			reportTime = reportTime.AddDate(0, 0, 1)
 		}
 	}
-	schedule.NextReportTime = int(reportTime.Unix())
-	log.DefaultLogger.Info(fmt.Sprintf("Setting time of schedule '%s' to '%s'", schedule.Name, reportTime))
+	return int(reportTime.Unix())
+}
+
+// weeks between runs of the intervals counted in weeks
+func (schedule *Schedule) weeks() int {
+	switch schedule.Interval {
+	case IntervalWeekly:
+		return 1
+	case IntervalFortnightly:
+		return 2
+	case IntervalWeeks:
+		if schedule.Every > 0 {
+			return schedule.Every
+		}
+	}
+	return 1
+}
+
+// months between runs of the intervals counted in months, 0 for the others
+func (schedule *Schedule) months() int {
+	switch schedule.Interval {
+	case IntervalMonthly:
+		return 1
+	case IntervalQuarterly:
+		return 3
+	case IntervalBiannual:
+		return 6
+	case IntervalYearly:
+		return 12
+	}
+	return 0
+}
+
+// anchoredAfter is the first run after now counting whole intervals from the anchor. Runs counted in months fall on
+// the anchor's day of the month, or the last day of shorter months.
+func (schedule *Schedule) anchoredAfter(anchor time.Time, now time.Time) time.Time {
+	if anchor.After(now) {
+		return anchor
+	}
+
+	months := schedule.months()
+	if months == 0 {
+		days := 1
+		if schedule.Interval != IntervalDaily {
+			days = 7 * schedule.weeks()
+		}
+		// Whole days since the anchor, from the calendar so daylight saving doesn't shift runs
+		elapsed := int(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Sub(time.Date(anchor.Year(), anchor.Month(), anchor.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+		next := anchor.AddDate(0, 0, elapsed/days*days)
+		for !next.After(now) {
+			next = next.AddDate(0, 0, days)
+		}
+		return next
+	}
+
+	elapsed := (now.Year()-anchor.Year())*12 + int(now.Month()) - int(anchor.Month())
+	for n := elapsed / months * months; ; n += months {
+		if next := monthsAfter(anchor, n); next.After(now) {
+			return next
+		}
+	}
+}
+
+// monthsAfter is the same day n months after t, or the last day of that month when it is shorter
+func monthsAfter(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), 0, 0, t.Location())
+	day := t.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
 }
 
 func (datasource *SQLiteDatasource) OverdueSchedules() ([]Schedule, error) {
+	defer metrics.ObserveDB("OverdueSchedules", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -98,7 +485,7 @@ func (datasource *SQLiteDatasource) OverdueSchedules() ([]Schedule, error) {
 		return nil, err
 	}
 
-	rows, err := db.Query("SELECT * FROM Schedule WHERE strftime(\"%s\", \"now\") > nextReportTime")
+	rows, err := db.Query("SELECT "+scheduleColumns+" FROM Schedule WHERE deletedAt = 0 AND disabledAt = 0 AND ? > nextReportTime", clock.System.Now().Unix())
 	if err != nil {
 		log.DefaultLogger.Error("OverdueSchedules: db.Query", err.Error())
 		return nil, err
@@ -106,20 +493,41 @@ func (datasource *SQLiteDatasource) OverdueSchedules() ([]Schedule, error) {
 
 	var schedules []Schedule
 	for rows.Next() {
-		var ID, Name, Description, ReportGroupID, Time string
-		var Day, Interval, NextReportTime, Lookback int
-		err = rows.Scan(&ID, &Interval, &NextReportTime, &Name, &Description, &Lookback, &ReportGroupID, &Time, &Day)
+		schedule, err := scanSchedule(rows)
 		if err != nil {
 			log.DefaultLogger.Error("OverdueSchedules: sql.Open", err.Error())
 			return nil, err
 		}
-		schedules = append(schedules, NewSchedule(ID, Interval, NextReportTime, Name, Description, Lookback, ReportGroupID, Time, Day))
+		schedules = append(schedules, *schedule)
 	}
 
 	return schedules, nil
 }
 
-func (datasource *SQLiteDatasource) CreateSchedule() (*Schedule, error) {
+// SetNextReportTime moves a schedule to the given time without working it out again, which the scheduler
+// uses to step through missed runs or hold a failing run for a retry
+func (datasource *SQLiteDatasource) SetNextReportTime(id string, nextReportTime int) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SetNextReportTime: sql.Open", err.Error())
+		return err
+	}
+
+	err = retryBusy("SetNextReportTime", func() error {
+		_, err := db.Exec("UPDATE Schedule SET nextReportTime = ? WHERE id = ?", nextReportTime, id)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("SetNextReportTime: db.Exec", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CreateSchedule creates a schedule owned by the given Grafana user
+func (datasource *SQLiteDatasource) CreateSchedule(owner string) (*Schedule, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -128,15 +536,15 @@ func (datasource *SQLiteDatasource) CreateSchedule() (*Schedule, error) {
 	}
 
 	newUuid := uuid.New().String()
-	schedule := Schedule{ID: newUuid, NextReportTime: 0, Interval: 0, Name: "", Description: "", Lookback: 0, ReportGroupID: "", Time: "", Day: 1}
+	schedule := Schedule{ID: newUuid, NextReportTime: 0, Interval: 0, Name: "", Description: "", Lookback: 0, ReportGroupID: "", Time: "", Day: 1, TriggerType: TriggerTypeTime, Owner: owner}
 	schedule.UpdateNextReportTime()
-	stmt, err := db.Prepare("INSERT INTO Schedule (ID,  nextReportTime, interval, name, description, lookback, reportGroupID, time, day) VALUES (?,?,?,?,?,?,?,?,?)")
+	stmt, err := db.Prepare("INSERT INTO Schedule (ID,  nextReportTime, interval, name, description, lookback, reportGroupID, time, day, triggerType, owner) VALUES (?,?,?,?,?,?,?,?,?,?,?)")
 	if err != nil {
 		log.DefaultLogger.Error("CreateSchedule: db.Prepare()", err.Error())
 		return nil, err
 	}
 
-	_, err = stmt.Exec(newUuid, 0, 60*60*24, "New report schedule", "", 0, "", "", 1)
+	_, err = stmt.Exec(newUuid, 0, 60*60*24, "New report schedule", "", 0, "", "", 1, schedule.TriggerType, owner)
 	defer stmt.Close()
 	if err != nil {
 		log.DefaultLogger.Error("CreateSchedule: stmt.Exec()", err.Error())
@@ -146,68 +554,130 @@ func (datasource *SQLiteDatasource) CreateSchedule() (*Schedule, error) {
 	return &schedule, nil
 }
 
-func (datasource *SQLiteDatasource) UpdateSchedule(id string, schedule Schedule) (*Schedule, error) {
+type CloneScheduleRequest struct {
+	IncludeReportGroup bool `json:"includeReportGroup"`
+}
+
+func CloneScheduleRequestFields() string {
+	return "\n{\n\tincludeReportGroup bool\n}"
+}
+
+// CloneSchedule copies a schedule and its report content in one transaction, as a new schedule owned by owner and
+// named as the original with " (copy)" after it. The copy is only sent to the original's report group when
+// includeReportGroup is set. Its shares, comments and run history aren't copied.
+func (datasource *SQLiteDatasource) CloneSchedule(id string, owner string, includeReportGroup bool) (*Schedule, error) {
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
-		log.DefaultLogger.Error("UpdateSchedule: sql.Open()", err.Error())
+		log.DefaultLogger.Error("CloneSchedule: sql.Open(): ", err.Error())
 		return nil, err
 	}
 
-	stmt, err := db.Prepare("UPDATE Schedule SET nextReportTime = ?, interval = ?, name = ?, description = ?, lookback = ?, reportGroupID = ?, time = ?, day = ? where id = ?")
-	if err != nil {
-		log.DefaultLogger.Error("UpdateSchedule: db.Prepare()", err.Error())
-		return nil, err
-	}
+	var clone *Schedule
+	err = retryBusy("CloneSchedule", func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
 
-	schedule.UpdateNextReportTime()
-	_, err = stmt.Exec(schedule.NextReportTime, schedule.Interval, schedule.Name, schedule.Description, schedule.Lookback, schedule.ReportGroupID, schedule.Time, schedule.Day, id)
-	defer stmt.Close()
+		clone, err = scanSchedule(tx.QueryRow("SELECT "+scheduleColumns+" FROM Schedule WHERE id = ? AND deletedAt = 0", id))
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query("SELECT "+reportContentColumns+" FROM ReportContent WHERE scheduleID = ? ORDER BY rowid", id)
+		if err != nil {
+			return err
+		}
+		contents := []ReportContent{}
+		for rows.Next() {
+			content, err := scanReportContent(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			contents = append(contents, *content)
+		}
+		rows.Close()
+
+		clone.ID = uuid.New().String()
+		clone.Name += " (copy)"
+		clone.Owner = owner
+		clone.OwnerTeam = ""
+		clone.TriggerValue = ""
+		clone.DisabledAt, clone.DisabledReason = 0, ""
+		if !includeReportGroup {
+			clone.ReportGroupID = ""
+		}
+		clone.UpdateNextReportTime()
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(strings.Split(scheduleColumns, ","))), ",")
+		_, err = tx.Exec("INSERT INTO Schedule ("+scheduleColumns+") VALUES ("+placeholders+")", clone.values()...)
+		if err != nil {
+			return err
+		}
+
+		placeholders = strings.TrimSuffix(strings.Repeat("?,", len(strings.Split(reportContentColumns, ","))), ",")
+		for _, content := range contents {
+			content.ID = uuid.New().String()
+			content.ScheduleID = clone.ID
+			_, err = tx.Exec("INSERT INTO ReportContent ("+reportContentColumns+") VALUES ("+placeholders+")", content.values()...)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	})
 	if err != nil {
-		log.DefaultLogger.Error("UpdateSchedule: stmt.Exec()", err.Error())
+		log.DefaultLogger.Error("CloneSchedule: ", err.Error())
 		return nil, err
 	}
 
-	return &schedule, nil
+	return clone, nil
 }
 
-func (datasource *SQLiteDatasource) DeleteSchedule(id string) error {
+func (datasource *SQLiteDatasource) UpdateSchedule(id string, schedule Schedule) (*Schedule, error) {
+	defer metrics.ObserveDB("UpdateSchedule", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
-		log.DefaultLogger.Error("DeleteSchedule: sql.Open()", err.Error())
-		return err
+		log.DefaultLogger.Error("UpdateSchedule: sql.Open()", err.Error())
+		return nil, err
 	}
 
-	stmt, err := db.Prepare("DELETE FROM Schedule WHERE id = ?")
-	defer stmt.Close()
+	stmt, err := db.Prepare("UPDATE Schedule SET nextReportTime = ?, interval = ?, name = ?, description = ?, lookback = ?, reportGroupID = ?, time = ?, day = ?, every = ?, anchorDate = ?, renderWidth = ?, renderHeight = ?, renderScale = ?, renderTheme = ?, triggerType = ?, triggerQuery = ?, sloTarget = ?, sloWindow = ?, locale = ?, maxRetries = ?, formats = ?, catchUp = ?, blackoutPolicy = ?, timezone = ?, ownerTeam = ?, fromName = ?, replyTo = ?, emailHeaders = ?, emailProfileID = ?, playlistUID = ?, priority = ?, templateID = ?, templateVersion = ?, conditionQuery = ?, conditionDashboardID = ?, conditionPanelID = ?, conditionOperator = ?, conditionThreshold = ?, trackAcknowledgement = ? where id = ?")
 	if err != nil {
-		log.DefaultLogger.Error("DeleteSchedule: db.Prepare()1", err.Error())
-		return err
+		log.DefaultLogger.Error("UpdateSchedule: db.Prepare()", err.Error())
+		return nil, err
 	}
 
-	_, err = stmt.Exec(id)
+	schedule.UpdateNextReportTime()
+	_, err = stmt.Exec(schedule.NextReportTime, schedule.Interval, schedule.Name, schedule.Description, schedule.Lookback, schedule.ReportGroupID, schedule.Time, schedule.Day, schedule.Every, schedule.AnchorDate, schedule.RenderWidth, schedule.RenderHeight, schedule.RenderScale, schedule.RenderTheme, schedule.TriggerType, schedule.TriggerQuery, schedule.SLOTarget, schedule.SLOWindow, schedule.Locale, schedule.MaxRetries, schedule.Formats, schedule.CatchUp, schedule.BlackoutPolicy, schedule.Timezone, schedule.OwnerTeam, schedule.FromName, schedule.ReplyTo, schedule.EmailHeaders, schedule.EmailProfileID, schedule.PlaylistUID, schedule.Priority, schedule.TemplateID, schedule.TemplateVersion, schedule.ConditionQuery, schedule.ConditionDashboardID, schedule.ConditionPanelID, schedule.ConditionOperator, schedule.ConditionThreshold, schedule.TrackAcknowledgement, id)
+	defer stmt.Close()
 	if err != nil {
-		log.DefaultLogger.Error("DeleteSchedule: stmt.Exec()1", err.Error())
-		return err
+		log.DefaultLogger.Error("UpdateSchedule: stmt.Exec()", err.Error())
+		return nil, err
 	}
 
-	stmt, err = db.Prepare("DELETE FROM ReportContent WHERE scheduleID = ?")
-	if err != nil {
-		log.DefaultLogger.Error("DeleteSchedule: db.Prepare()2", err.Error())
-		return err
-	}
+	return &schedule, nil
+}
 
-	stmt.Exec(id)
-	if err != nil {
-		log.DefaultLogger.Error("DeleteSchedule: stmt.Exec()2", err.Error())
-		return err
-	}
+// DeleteSchedule moves a schedule to the trash, where it stops being sent and can be restored until it is purged
+func (datasource *SQLiteDatasource) DeleteSchedule(id string, by string) error {
+	return datasource.moveToTrash("DeleteSchedule", "Schedule", id, by)
+}
 
-	return nil
+// PurgeSchedule deletes a schedule for good, which deletes its content and everything else kept for it too
+func (datasource *SQLiteDatasource) PurgeSchedule(id string) error {
+	return datasource.purge("PurgeSchedule", "Schedule", id)
 }
 
 func (datasource *SQLiteDatasource) GetSchedule(id string) (*Schedule, error) {
+	defer metrics.ObserveDB("GetSchedule", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -217,7 +687,7 @@ func (datasource *SQLiteDatasource) GetSchedule(id string) (*Schedule, error) {
 
 	var schedules []Schedule
 
-	rows, err := db.Query("SELECT * FROM Schedule where id=?", id)
+	rows, err := db.Query("SELECT "+scheduleColumns+" FROM Schedule where id=? AND deletedAt = 0", id)
 	defer rows.Close()
 	if err != nil {
 		log.DefaultLogger.Error("GetSchedules: db.Query(): ", err.Error())
@@ -225,17 +695,13 @@ func (datasource *SQLiteDatasource) GetSchedule(id string) (*Schedule, error) {
 	}
 
 	for rows.Next() {
-		var ID, Name, Description, ReportGroupID, Time string
-		var Day, Interval, NextReportTime, Lookback int
-
-		err = rows.Scan(&ID, &Interval, &NextReportTime, &Name, &Description, &Lookback, &ReportGroupID, &Time, &Day)
+		schedule, err := scanSchedule(rows)
 		if err != nil {
 			log.DefaultLogger.Error("GetSchedules: rows.Scan(): ", err.Error())
 			return nil, err
 		}
 
-		schedule := Schedule{ID, Interval, NextReportTime, Name, Description, Lookback, ReportGroupID, Time, Day}
-		schedules = append(schedules, schedule)
+		schedules = append(schedules, *schedule)
 	}
 
 	if len(schedules) > 0 {
@@ -256,7 +722,7 @@ func (datasource *SQLiteDatasource) GetSchedules() ([]Schedule, error) {
 
 	var schedules []Schedule
 
-	rows, err := db.Query("SELECT * FROM Schedule")
+	rows, err := db.Query("SELECT " + scheduleColumns + " FROM Schedule WHERE deletedAt = 0")
 	defer rows.Close()
 	if err != nil {
 		log.DefaultLogger.Error("GetSchedules: db.Query(): ", err.Error())
@@ -264,18 +730,101 @@ func (datasource *SQLiteDatasource) GetSchedules() ([]Schedule, error) {
 	}
 
 	for rows.Next() {
-		var ID, Name, Description, ReportGroupID, Time string
-		var Day, Interval, NextReportTime, Lookback int
-
-		err = rows.Scan(&ID, &Interval, &NextReportTime, &Name, &Description, &Lookback, &ReportGroupID, &Time, &Day)
+		schedule, err := scanSchedule(rows)
 		if err != nil {
 			log.DefaultLogger.Error("GetSchedules: rows.Scan(): ", err.Error())
 			return nil, err
 		}
 
-		schedule := Schedule{ID, Interval, NextReportTime, Name, Description, Lookback, ReportGroupID, Time, Day}
-		schedules = append(schedules, schedule)
+		schedules = append(schedules, *schedule)
 	}
 
 	return schedules, nil
 }
+
+// scheduleList is what the schedule list can be searched and sorted by
+var scheduleList = listQuery{
+	searches:    []string{"name", "description"},
+	sorts:       map[string]string{"name": "name COLLATE NOCASE", "nextReportTime": "nextReportTime", "interval": "interval", "owner": "owner"},
+	defaultSort: "name",
+}
+
+// ListSchedules returns a page of every schedule, along with how many match the options' search in total
+func (datasource *SQLiteDatasource) ListSchedules(options ListOptions) ([]Schedule, int, error) {
+	return datasource.listSchedules("ListSchedules", " WHERE deletedAt = 0", nil, options)
+}
+
+// GetSchedulesFor returns a page of the schedules a Grafana user owns, which are owned by one of their teams or which
+// have been shared with them, along with any created before schedules had owners, and how many there are in total
+func (datasource *SQLiteDatasource) GetSchedulesFor(login string, teams []string, options ListOptions) ([]Schedule, int, error) {
+	where := " WHERE deletedAt = 0 AND (owner = '' OR owner = ? OR id IN (SELECT scheduleID FROM ScheduleShare WHERE login = ?)"
+	args := []interface{}{login, login}
+	if len(teams) > 0 {
+		where += " OR ownerTeam IN (?" + strings.Repeat(",?", len(teams)-1) + ")"
+		for _, team := range teams {
+			args = append(args, team)
+		}
+	}
+	where += ")"
+
+	return datasource.listSchedules("GetSchedulesFor", where, args, options)
+}
+
+func (datasource *SQLiteDatasource) listSchedules(caller string, where string, args []interface{}, options ListOptions) ([]Schedule, int, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": sql.Open(): ", err.Error())
+		return nil, 0, err
+	}
+
+	where, args = scheduleList.where(options, where, args)
+	orderLimit, pageArgs, err := scheduleList.orderLimit(options, append([]interface{}{}, args...))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = db.QueryRow("SELECT COUNT(*) FROM Schedule"+where, args...).Scan(&total)
+	if err != nil {
+		log.DefaultLogger.Error(caller+": db.QueryRow(): ", err.Error())
+		return nil, 0, err
+	}
+
+	rows, err := db.Query("SELECT "+scheduleColumns+" FROM Schedule"+where+orderLimit, pageArgs...)
+	if err != nil {
+		log.DefaultLogger.Error(caller+": db.Query(): ", err.Error())
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	schedules := []Schedule{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			log.DefaultLogger.Error(caller+": rows.Scan(): ", err.Error())
+			return nil, 0, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+
+	return schedules, total, nil
+}
+
+// SetScheduleTriggerValue records the result of a data triggered schedule's query when its report is sent
+func (datasource *SQLiteDatasource) SetScheduleTriggerValue(id string, value string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("SetScheduleTriggerValue: sql.Open()", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("UPDATE Schedule SET triggerValue = ? WHERE id = ?", value, id)
+	if err != nil {
+		log.DefaultLogger.Error("SetScheduleTriggerValue: db.Exec()", err.Error())
+		return err
+	}
+
+	return nil
+}
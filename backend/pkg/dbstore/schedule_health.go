@@ -0,0 +1,77 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Permanent failures in a row after which a schedule is disabled, when the settings don't say
+const DefaultDisableAfterFailures = 5
+
+var ErrScheduleNotDisabled = errors.New("the schedule isn't disabled")
+
+// DisableAfterFailures is how many permanent failures in a row disable a schedule
+func DisableAfterFailures(settings *Settings) int {
+	if settings == nil || settings.DisableAfterFailures <= 0 {
+		return DefaultDisableAfterFailures
+	}
+	return settings.DisableAfterFailures
+}
+
+// DisableSchedule stops a schedule being sent until it is re-enabled, returning false if it was already disabled
+func (datasource *SQLiteDatasource) DisableSchedule(id string, reason string) (bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DisableSchedule: sql.Open", err.Error())
+		return false, err
+	}
+
+	var disabled int64
+	err = retryBusy("DisableSchedule", func() error {
+		result, err := db.Exec("UPDATE Schedule SET disabledAt = ?, disabledReason = ? WHERE id = ? AND disabledAt = 0", time.Now().Unix(), reason, id)
+		if err != nil {
+			return err
+		}
+		disabled, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Error("DisableSchedule: db.Exec", err.Error())
+		return false, err
+	}
+
+	return disabled > 0, nil
+}
+
+// EnableSchedule lets a disabled schedule be sent again, returning ErrScheduleNotDisabled if it wasn't disabled
+func (datasource *SQLiteDatasource) EnableSchedule(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("EnableSchedule: sql.Open", err.Error())
+		return err
+	}
+
+	var enabled int64
+	err = retryBusy("EnableSchedule", func() error {
+		result, err := db.Exec("UPDATE Schedule SET disabledAt = 0, disabledReason = '' WHERE id = ? AND disabledAt > 0", id)
+		if err != nil {
+			return err
+		}
+		enabled, _ = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Error("EnableSchedule: db.Exec", err.Error())
+		return err
+	}
+	if enabled == 0 {
+		return ErrScheduleNotDisabled
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// ScheduleShare delegates a schedule to another Grafana user, who can then manage it as if they owned it
+type ScheduleShare struct {
+	ScheduleID string `json:"scheduleID"`
+	Login      string `json:"login"`
+	SharedBy   string `json:"sharedBy"`
+	CreatedAt  int    `json:"createdAt"`
+}
+
+func ScheduleShareFields() string {
+	return "\n{\n\tlogin string\n}"
+}
+
+func (share *ScheduleShare) Validate() error {
+	if strings.TrimSpace(share.Login) == "" {
+		return errors.New("login is required")
+	}
+
+	return nil
+}
+
+const scheduleShareColumns = "scheduleID, login, sharedBy, createdAt"
+
+func (datasource *SQLiteDatasource) GetScheduleShares(scheduleID string) ([]ScheduleShare, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetScheduleShares: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+scheduleShareColumns+" FROM ScheduleShare WHERE scheduleID = ? ORDER BY login", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetScheduleShares: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []ScheduleShare{}
+	for rows.Next() {
+		var share ScheduleShare
+		err = rows.Scan(&share.ScheduleID, &share.Login, &share.SharedBy, &share.CreatedAt)
+		if err != nil {
+			log.DefaultLogger.Error("GetScheduleShares: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+
+	return shares, nil
+}
+
+// CreateScheduleShare shares a schedule with a user, replacing any existing share with them
+func (datasource *SQLiteDatasource) CreateScheduleShare(share ScheduleShare) (*ScheduleShare, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateScheduleShare: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	share.CreatedAt = int(time.Now().Unix())
+	_, err = db.Exec("INSERT OR REPLACE INTO ScheduleShare ("+scheduleShareColumns+") VALUES (?,?,?,?)", share.ScheduleID, share.Login, share.SharedBy, share.CreatedAt)
+	if err != nil {
+		log.DefaultLogger.Error("CreateScheduleShare: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &share, nil
+}
+
+func (datasource *SQLiteDatasource) DeleteScheduleShare(scheduleID string, login string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteScheduleShare: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM ScheduleShare WHERE scheduleID = ? AND login = ?", scheduleID, login)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteScheduleShare: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// IsScheduleSharedWith is whether a user has been delegated a schedule
+func (datasource *SQLiteDatasource) IsScheduleSharedWith(scheduleID string, login string) (bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("IsScheduleSharedWith: sql.Open(): ", err.Error())
+		return false, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM ScheduleShare WHERE scheduleID = ? AND login = ?", scheduleID, login).Scan(&count)
+	if err != nil {
+		log.DefaultLogger.Error("IsScheduleSharedWith: db.QueryRow(): ", err.Error())
+		return false, err
+	}
+
+	return count > 0, nil
+}
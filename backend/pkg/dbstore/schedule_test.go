@@ -0,0 +1,65 @@
+package dbstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+)
+
+func TestCreateScheduleSavesItsTriggerType(t *testing.T) {
+	datasource := newTestDatasource(t)
+	schedule, err := datasource.CreateSchedule("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := queryString(t, datasource.Path, "SELECT triggerType FROM Schedule WHERE id = '"+schedule.ID+"'"); got != TriggerTypeTime {
+		t.Errorf("expected a new schedule to be saved as time triggered, got %q", got)
+	}
+}
+
+func TestNextReportTimeAfter(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skip("no timezone database: " + err.Error())
+	}
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, auckland)
+	}
+
+	// Daylight saving in Auckland ends at 3am on 5 April 2026 and starts at 2am on 27 September 2026
+	cases := []struct {
+		name     string
+		schedule Schedule
+		now      clock.Fixed
+		want     time.Time
+	}{
+		{"daily later today", Schedule{Interval: IntervalDaily, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 8, 0)), at(2026, 3, 15, 9, 0)},
+		{"daily tomorrow", Schedule{Interval: IntervalDaily, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 9, 0)), at(2026, 3, 16, 9, 0)},
+		{"daily as the clocks go back", Schedule{Interval: IntervalDaily, Time: "09:00"}, clock.Fixed(at(2026, 4, 4, 10, 0)), at(2026, 4, 5, 9, 0)},
+		{"daily as the clocks go forward", Schedule{Interval: IntervalDaily, Time: "09:00"}, clock.Fixed(at(2026, 9, 26, 10, 0)), at(2026, 9, 27, 9, 0)},
+		{"daily in the hour the clocks skip", Schedule{Interval: IntervalDaily, Time: "02:30"}, clock.Fixed(at(2026, 9, 26, 10, 0)), at(2026, 9, 27, 3, 30)},
+		{"weekly across the clocks going back", Schedule{Interval: IntervalWeekly, Time: "09:00"}, clock.Fixed(at(2026, 3, 30, 9, 0)), at(2026, 4, 6, 9, 0)},
+		{"fortnightly", Schedule{Interval: IntervalFortnightly, Time: "09:00"}, clock.Fixed(at(2026, 3, 30, 9, 0)), at(2026, 4, 13, 9, 0)},
+		{"every 3 weeks", Schedule{Interval: IntervalWeeks, Every: 3, Time: "09:00"}, clock.Fixed(at(2026, 3, 30, 9, 0)), at(2026, 4, 20, 9, 0)},
+		{"monthly on the 1st", Schedule{Interval: IntervalMonthly, Day: 1, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 10, 0)), at(2026, 4, 1, 9, 0)},
+		{"quarterly on the 1st", Schedule{Interval: IntervalQuarterly, Day: 1, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 10, 0)), at(2026, 6, 1, 9, 0)},
+		{"biannual on the 1st", Schedule{Interval: IntervalBiannual, Day: 1, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 10, 0)), at(2026, 9, 1, 9, 0)},
+		{"yearly on the 1st", Schedule{Interval: IntervalYearly, Day: 1, Time: "09:00"}, clock.Fixed(at(2026, 3, 15, 10, 0)), at(2027, 3, 1, 9, 0)},
+		{"anchored every 2 weeks across the clocks going back", Schedule{Interval: IntervalWeeks, Every: 2, AnchorDate: "2026-03-02", Time: "09:00"}, clock.Fixed(at(2026, 4, 4, 12, 0)), at(2026, 4, 13, 9, 0)},
+		{"anchored daily as the clocks go forward", Schedule{Interval: IntervalDaily, AnchorDate: "2026-09-01", Time: "09:00"}, clock.Fixed(at(2026, 9, 26, 10, 0)), at(2026, 9, 27, 9, 0)},
+		{"anchored before the anchor", Schedule{Interval: IntervalWeekly, AnchorDate: "2026-05-04", Time: "09:00"}, clock.Fixed(at(2026, 4, 4, 12, 0)), at(2026, 5, 4, 9, 0)},
+		{"anchored monthly on the 31st in February", Schedule{Interval: IntervalMonthly, AnchorDate: "2026-01-31", Time: "09:00"}, clock.Fixed(at(2026, 2, 1, 10, 0)), at(2026, 2, 28, 9, 0)},
+		{"anchored quarterly", Schedule{Interval: IntervalQuarterly, AnchorDate: "2026-01-15", Time: "09:00"}, clock.Fixed(at(2026, 4, 15, 9, 0)), at(2026, 7, 15, 9, 0)},
+		{"anchored biannual across the clocks going forward", Schedule{Interval: IntervalBiannual, AnchorDate: "2026-03-31", Time: "09:00"}, clock.Fixed(at(2026, 4, 1, 10, 0)), at(2026, 9, 30, 9, 0)},
+		{"anchored yearly", Schedule{Interval: IntervalYearly, AnchorDate: "2024-02-29", Time: "09:00"}, clock.Fixed(at(2026, 3, 1, 10, 0)), at(2027, 2, 28, 9, 0)},
+	}
+	for _, c := range cases {
+		c.schedule.Timezone = "Pacific/Auckland"
+		got := time.Unix(int64(c.schedule.NextReportTimeAfter(c.now.Now())), 0).In(auckland)
+		if !got.Equal(c.want) {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
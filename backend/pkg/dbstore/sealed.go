@@ -0,0 +1,172 @@
+package dbstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Sealed values start with this, anything else was saved before values were sealed and is read as is
+const sealedPrefix = "sealed:"
+
+// keyPath is the file holding the key values such as attachment passwords are sealed with. It's kept next to the
+// database rather than in it, so a copy of the database, e.g. a backup, can't be read without it.
+func (datasource *SQLiteDatasource) keyPath() string {
+	return datasource.Path + ".key"
+}
+
+// sealingKey is the key values are sealed with, created the first time it's needed
+func (datasource *SQLiteDatasource) sealingKey() ([]byte, error) {
+	key, err := ioutil.ReadFile(datasource.keyPath())
+	if err == nil {
+		if len(key) != secretSize {
+			return nil, errors.New(datasource.keyPath() + " isn't a key, it may have been overwritten")
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		log.DefaultLogger.Error("sealingKey: ioutil.ReadFile(): ", err.Error())
+		return nil, err
+	}
+
+	generated := make([]byte, secretSize)
+	if _, err = rand.Read(generated); err != nil {
+		log.DefaultLogger.Error("sealingKey: rand.Read(): ", err.Error())
+		return nil, err
+	}
+
+	// Written in full before it's linked into place, and whichever of two first callers links first wins while the
+	// other reads its key
+	tmp, err := ioutil.TempFile(filepath.Dir(datasource.keyPath()), filepath.Base(datasource.keyPath())+".*")
+	if err != nil {
+		log.DefaultLogger.Error("sealingKey: ioutil.TempFile(): ", err.Error())
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(generated)
+	tmp.Close()
+	if err != nil {
+		log.DefaultLogger.Error("sealingKey: tmp.Write(): ", err.Error())
+		return nil, err
+	}
+	err = os.Link(tmp.Name(), datasource.keyPath())
+	if os.IsExist(err) {
+		return datasource.sealingKey()
+	}
+	if err != nil {
+		log.DefaultLogger.Error("sealingKey: os.Link(): ", err.Error())
+		return nil, err
+	}
+	return generated, nil
+}
+
+func (datasource *SQLiteDatasource) sealingCipher() (cipher.AEAD, error) {
+	key, err := datasource.sealingKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a value to store, empty staying empty so it still reads as not set
+func (datasource *SQLiteDatasource) seal(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	aead, err := datasource.sealingCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return sealedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil)), nil
+}
+
+// unseal decrypts a stored value, returning values saved before they were sealed as they are
+func (datasource *SQLiteDatasource) unseal(value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	aead, err := datasource.sealingCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("the sealed value is too short")
+	}
+	opened, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("the sealed value can't be opened with " + datasource.keyPath() + ", e.g. as the database was restored on another server")
+	}
+	return string(opened), nil
+}
+
+// sealAttachmentPasswords seals the attachment passwords saved before they were sealed
+func (datasource *SQLiteDatasource) sealAttachmentPasswords() error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("sealAttachmentPasswords: sql.Open(): ", err.Error())
+		return err
+	}
+
+	rows, err := db.Query("SELECT id, attachmentPassword FROM ReportGroup WHERE attachmentPassword != '' AND attachmentPassword NOT LIKE ?", sealedPrefix+"%")
+	if err != nil {
+		log.DefaultLogger.Error("sealAttachmentPasswords: db.Query(): ", err.Error())
+		return err
+	}
+	passwords := map[string]string{}
+	for rows.Next() {
+		var id, password string
+		if err = rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			log.DefaultLogger.Error("sealAttachmentPasswords: rows.Scan(): ", err.Error())
+			return err
+		}
+		passwords[id] = password
+	}
+	rows.Close()
+
+	for id, password := range passwords {
+		sealed, err := datasource.seal(password)
+		if err != nil {
+			log.DefaultLogger.Error("sealAttachmentPasswords: seal(): ", err.Error())
+			return err
+		}
+		err = retryBusy("sealAttachmentPasswords", func() error {
+			_, err := db.Exec("UPDATE ReportGroup SET attachmentPassword = ? WHERE id = ? AND attachmentPassword = ?", sealed, id, password)
+			return err
+		})
+		if err != nil {
+			log.DefaultLogger.Error("sealAttachmentPasswords: db.Exec(): ", err.Error())
+			return err
+		}
+	}
+
+	if len(passwords) > 0 {
+		log.DefaultLogger.Info("Sealed the attachment passwords of " + strconv.Itoa(len(passwords)) + " report groups")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package dbstore
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestDatasource(t *testing.T) *SQLiteDatasource {
+	t.Helper()
+	datasource := &SQLiteDatasource{Path: filepath.Join(t.TempDir(), "msupply.db")}
+	datasource.Init()
+	return datasource
+}
+
+func storedAttachmentPassword(t *testing.T, datasource *SQLiteDatasource, id string) string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", datasource.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var stored string
+	if err := db.QueryRow("SELECT attachmentPassword FROM ReportGroup WHERE id = ?", id).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestAttachmentPasswordIsSealed(t *testing.T) {
+	datasource := newTestDatasource(t)
+	group, err := datasource.CreateReportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.EncryptAttachments = true
+	group.AttachmentPassword = "correct horse battery"
+	if _, err := datasource.UpdateReportGroup(group.ID, *group); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := storedAttachmentPassword(t, datasource, group.ID)
+	if !strings.HasPrefix(stored, sealedPrefix) || strings.Contains(stored, "correct horse battery") {
+		t.Errorf("expected the password to be stored sealed, got %q", stored)
+	}
+	if info, err := os.Stat(datasource.keyPath()); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a key only the plugin can read next to the database, got %v, %v", info, err)
+	}
+
+	password, err := datasource.GetAttachmentPassword(group.ID)
+	if err != nil || password != "correct horse battery" {
+		t.Errorf("expected the password back, got %q, %v", password, err)
+	}
+
+	// Saving the group without a password keeps it, still sealed
+	group.AttachmentPassword = ""
+	if _, err := datasource.UpdateReportGroup(group.ID, *group); err != nil {
+		t.Fatal(err)
+	}
+	if storedAttachmentPassword(t, datasource, group.ID) != stored {
+		t.Error("expected the sealed password to be kept")
+	}
+}
+
+// A copy of the database, e.g. a backup, is no use without the key next to the original
+func TestSealedPasswordNeedsKey(t *testing.T) {
+	datasource := newTestDatasource(t)
+	group, err := datasource.CreateReportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.AttachmentPassword = "correct horse battery"
+	if _, err := datasource.UpdateReportGroup(group.ID, *group); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(datasource.keyPath()); err != nil {
+		t.Fatal(err)
+	}
+	if password, err := datasource.GetAttachmentPassword(group.ID); err == nil {
+		t.Errorf("expected the password not to open with another key, got %q", password)
+	}
+}
+
+func TestLegacyAttachmentPasswordsAreSealed(t *testing.T) {
+	datasource := newTestDatasource(t)
+	group, err := datasource.CreateReportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite3", datasource.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE ReportGroup SET attachmentPassword = ? WHERE id = ?", "saved in plain text", group.ID)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read as it is until the plugin next starts, which seals it
+	if password, err := datasource.GetAttachmentPassword(group.ID); err != nil || password != "saved in plain text" {
+		t.Errorf("expected the legacy password as it is, got %q, %v", password, err)
+	}
+	datasource.Init()
+	if stored := storedAttachmentPassword(t, datasource, group.ID); !strings.HasPrefix(stored, sealedPrefix) {
+		t.Errorf("expected the legacy password to be sealed, got %q", stored)
+	}
+	if password, err := datasource.GetAttachmentPassword(group.ID); err != nil || password != "saved in plain text" {
+		t.Errorf("expected the legacy password back, got %q, %v", password, err)
+	}
+}
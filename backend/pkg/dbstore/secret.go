@@ -0,0 +1,46 @@
+package dbstore
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Size in bytes of generated secrets
+const secretSize = 32
+
+// Secret is a random value kept in the database, created the first time it's asked for. It never leaves the
+// server, so anything signed with it can only have come from this install.
+func (datasource *SQLiteDatasource) Secret(name string) ([]byte, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("Secret: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	generated := make([]byte, secretSize)
+	_, err = rand.Read(generated)
+	if err != nil {
+		log.DefaultLogger.Error("Secret: rand.Read(): ", err.Error())
+		return nil, err
+	}
+
+	// Whichever of two first callers inserts first wins, and both read back the same value
+	_, err = db.Exec("INSERT OR IGNORE INTO Secret (name, value) VALUES (?, ?)", name, hex.EncodeToString(generated))
+	if err != nil {
+		log.DefaultLogger.Error("Secret: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	var value string
+	err = db.QueryRow("SELECT value FROM Secret WHERE name = ?", name).Scan(&value)
+	if err != nil {
+		log.DefaultLogger.Error("Secret: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return hex.DecodeString(value)
+}
@@ -2,8 +2,66 @@ package dbstore
 
 import (
 	"database/sql"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+)
+
+const (
+	// Authenticate to Grafana with the configured username and password
+	AuthModeBasic = "basic"
+	// Authenticate to Grafana as the configured user through an auth proxy header
+	AuthModeProxy = "proxy"
+
+	// Authenticate to Grafana with the service account token Grafana provisions for the plugin
+	AuthModeServiceAccount = "serviceAccount"
+
+	DefaultProxyHeader = "X-WEBAUTH-USER"
+
+	// Set by Grafana 10.3+ when the plugin declares externalServiceAccounts permissions in plugin.json
+	ServiceAccountTokenEnv = "GF_PLUGIN_APP_CLIENT_SECRET"
+	ServiceAccountURLEnv   = "GF_APP_URL"
+)
+
+// How panel images are rendered
+const (
+	// Grafana's own renderer, the grafana-image-renderer plugin or the remote renderer Grafana is configured with
+	RendererGrafana = "grafana"
+	// A grafana-image-renderer service the plugin calls itself, which several Grafana servers can share
+	RendererRemote = "remote"
+	// A headless Chrome on the same server as the plugin, where no renderer can be installed in Grafana
+	RendererChrome = "chrome"
+)
+
+// How a report too long to send in one email is sent
+const (
+	// An email for each part, numbered "Part 1 of 3" and so on
+	PartDeliveryEmails = "emails"
+	// One email with a link to download each part
+	PartDeliveryLinks = "links"
+)
+
+// Transports of the syslog server plugin events are sent to
+const (
+	SyslogProtocolUDP = "udp"
+	SyslogProtocolTCP = "tcp"
+	SyslogProtocolTLS = "tls"
+)
+
+// What's done when content sets a variable to a value its dashboard no longer offers, e.g. a store which was renamed
+const (
+	// Sends the report with the value anyway, recording it in the run, the default
+	VariablePolicyWarn = "warn"
+	// Fails the run without sending it
+	VariablePolicyFail = "fail"
+	// Uses the option whose label or value matches it ignoring case, e.g. the store's new name when its code is
+	// unchanged, otherwise sends it with the value anyway
+	VariablePolicyMap = "map"
 )
 
 type Settings struct {
@@ -15,6 +73,90 @@ type Settings struct {
 	EmailPort       int    `json:"emailPort"`
 	EmailHost       string `json:"emailHost"`
 	DatasourceID    int    `json:"datasourceID"`
+	// Largest attachment in bytes the mail server accepts, 0 uses the default of 10MB
+	MaxAttachmentSize  int    `json:"maxAttachmentSize"`
+	GrafanaAuthMode    string `json:"grafanaAuthMode"`
+	GrafanaProxyHeader string `json:"grafanaProxyHeader"`
+	GrafanaProxyUser   string `json:"grafanaProxyUser"`
+	// Number of panels fetched at the same time and the seconds allowed for each request, 0 uses the defaults
+	RenderConcurrency int `json:"renderConcurrency"`
+	RenderTimeout     int `json:"renderTimeout"`
+	// Notified when a report fails or is only partially rendered
+	AdminEmail string `json:"adminEmail"`
+	// Seconds allowed for sending each email and for generating and sending a whole report, 0 uses the defaults
+	EmailTimeout  int `json:"emailTimeout"`
+	ReportTimeout int `json:"reportTimeout"`
+	// Price charged by the email provider per message, used to estimate the cost of each run
+	MessageUnitCost float64 `json:"messageUnitCost"`
+	CostCurrency    string  `json:"costCurrency"`
+	// Organisation defaults, which each schedule can override
+	DefaultRenderWidth  int        `json:"defaultRenderWidth"`
+	DefaultRenderHeight int        `json:"defaultRenderHeight"`
+	DefaultRenderScale  float64    `json:"defaultRenderScale"`
+	DefaultRenderTheme  string     `json:"defaultRenderTheme"`
+	DefaultLocale       string     `json:"defaultLocale"`
+	DefaultMaxRetries   RetryLimit `json:"defaultMaxRetries"`
+	// Blocks every change and delivery while still allowing browsing, for training sessions and demos
+	ReadOnly bool `json:"readOnly"`
+	// Directory database backups are written to, empty uses the plugin's data directory. Backups are made every
+	// BackupInterval hours when set, keeping the BackupRetention most recent, 0 keeps them all.
+	BackupDirectory string `json:"backupDirectory"`
+	BackupInterval  int    `json:"backupInterval"`
+	BackupRetention int    `json:"backupRetention"`
+	// What the scheduler does with runs missed while Grafana was down, which each schedule can override
+	DefaultCatchUp string `json:"defaultCatchUp"`
+	// Checks the domain of email addresses can receive mail when they are saved, as well as their syntax
+	VerifyEmailDomains bool `json:"verifyEmailDomains"`
+	// host:port of the IMAP server, over TLS, holding the bounces sent back to the sending address. It's logged
+	// into with the email address and password. Bounces aren't checked for when it's empty.
+	BounceMailbox string `json:"bounceMailbox"`
+	// Renderer of panel images, empty uses Grafana's. RendererURL and RendererToken are the address and auth token
+	// of the remote renderer, and ChromePath is the Chrome to run, empty finds it on the PATH.
+	Renderer      string `json:"renderer"`
+	RendererURL   string `json:"rendererURL"`
+	RendererToken string `json:"rendererToken"`
+	ChromePath    string `json:"chromePath"`
+	// Most emails sent a minute, for mail servers which throttle, and how many are sent over each connection to
+	// it. 0 sends as fast as the server accepts them, over a connection each.
+	EmailRateLimit int `json:"emailRateLimit"`
+	EmailBatchSize int `json:"emailBatchSize"`
+	// Display name reports are sent from, where replies to them go, and extra headers written one a line as
+	// "Name: value", e.g. X-Program. Each schedule can override them.
+	EmailFromName string `json:"emailFromName"`
+	EmailReplyTo  string `json:"emailReplyTo"`
+	EmailHeaders  string `json:"emailHeaders"`
+	// Reports are sent in parts of at most ReportPartSheets panels and ReportPartSize bytes of files, only ever
+	// breaking between content items, 0 for no limit. Empty ReportPartDelivery sends each part in its own email.
+	ReportPartSheets   int    `json:"reportPartSheets"`
+	ReportPartSize     int    `json:"reportPartSize"`
+	ReportPartDelivery string `json:"reportPartDelivery"`
+	// Days deleted schedules and report groups are kept in the trash before they are purged, 0 uses the default
+	TrashRetention int `json:"trashRetention"`
+	// Reuses the image rendered for a panel by the schedule's previous run when the panel's data hasn't changed,
+	// rather than rendering it again. The image still shows the previous run's period on its time axis.
+	ReuseUnchangedImages bool `json:"reuseUnchangedImages"`
+	// host:port of a syslog server the plugin's runs, failures and changes are sent to as RFC 5424 messages, over
+	// SyslogProtocol, empty for UDP. Nothing is sent when it's empty.
+	SyslogAddress  string `json:"syslogAddress"`
+	SyslogProtocol string `json:"syslogProtocol"`
+	// Most reports generated at the same time, the rest queueing for their turn, 0 generates them one at a time.
+	// Runs due at the same time are spread over SpreadWindow seconds rather than all starting at once, 0 doesn't.
+	MaxConcurrentReports int `json:"maxConcurrentReports"`
+	SpreadWindow         int `json:"spreadWindow"`
+	// Runs in a row which fail in a way trying again won't fix, e.g. a deleted dashboard, after which a schedule is
+	// disabled until someone re-enables it, 0 uses the default
+	DisableAfterFailures int `json:"disableAfterFailures"`
+	// Most seconds a report content item can allow its panels' queries and renders, above the renderTimeout
+	// they get otherwise, 0 uses the default
+	MaxQueryTimeout int `json:"maxQueryTimeout"`
+	// What's done when a report's variables are set to values their dashboards no longer offer, empty warns
+	VariablePolicy string `json:"variablePolicy"`
+	// PEM S/MIME certificate, followed by any intermediates, and private key reports' emails are signed with so
+	// recipients can verify they came from the organisation. Emails aren't signed when they're empty.
+	SigningCertificate string `json:"signingCertificate"`
+	// Never returned by the API, an empty key keeps the saved one while there's a certificate
+	SigningKey    string `json:"signingKey,omitempty"`
+	HasSigningKey bool   `json:"hasSigningKey"`
 }
 
 func SettingsFields() string {
@@ -25,7 +167,188 @@ func SettingsFields() string {
 		"\n\temailPassword string\n}" +
 		"\n\temailPort int\n}" +
 		"\n\temailHost string\n}" +
-		"\n\tDatasourceID int\n}"
+		"\n\tDatasourceID int\n}" +
+		"\n\tmaxAttachmentSize int\n}" +
+		"\n\tgrafanaAuthMode string (basic|proxy|serviceAccount)\n}" +
+		"\n\tgrafanaProxyHeader string\n}" +
+		"\n\tgrafanaProxyUser string\n}" +
+		"\n\trenderConcurrency int\n}" +
+		"\n\trenderTimeout int\n}" +
+		"\n\tadminEmail string\n}" +
+		"\n\temailTimeout int\n}" +
+		"\n\treportTimeout int\n}" +
+		"\n\tmessageUnitCost float\n}" +
+		"\n\tcostCurrency string\n}" +
+		"\n\tdefaultRenderWidth int\n}" +
+		"\n\tdefaultRenderHeight int\n}" +
+		"\n\tdefaultRenderScale float\n}" +
+		"\n\tdefaultRenderTheme string (light|dark)\n}" +
+		"\n\tdefaultLocale string\n}" +
+		"\n\tdefaultMaxRetries int (null retries until sent, 0 doesn't retry)\n}" +
+		"\n\treadOnly bool\n}" +
+		"\n\tbackupDirectory string\n}" +
+		"\n\tbackupInterval int\n}" +
+		"\n\tbackupRetention int\n}" +
+		"\n\tdefaultCatchUp string (skip|once|all)\n}" +
+		"\n\tverifyEmailDomains bool\n}" +
+		"\n\tbounceMailbox string (host:port)\n}" +
+		"\n\trenderer string (grafana|remote|chrome)\n}" +
+		"\n\trendererURL string\n}" +
+		"\n\trendererToken string\n}" +
+		"\n\tchromePath string\n}" +
+		"\n\temailRateLimit int\n}" +
+		"\n\temailBatchSize int\n}" +
+		"\n\temailFromName string\n}" +
+		"\n\temailReplyTo string\n}" +
+		"\n\temailHeaders string (Name: value, one a line)\n}" +
+		"\n\treportPartSheets int\n}" +
+		"\n\treportPartSize int\n}" +
+		"\n\treportPartDelivery string (emails|links)\n}" +
+		"\n\ttrashRetention int\n}" +
+		"\n\treuseUnchangedImages bool\n}" +
+		"\n\tsyslogAddress string\n}" +
+		"\n\tsyslogProtocol string (udp|tcp|tls)\n}" +
+		"\n\tmaxConcurrentReports int\n}" +
+		"\n\tspreadWindow int\n}" +
+		"\n\tdisableAfterFailures int\n}" +
+		"\n\tmaxQueryTimeout int\n}" +
+		"\n\tvariablePolicy string (warn|fail|map)\n}" +
+		"\n\tsigningCertificate string (PEM)\n}" +
+		"\n\tsigningKey string (PEM, empty keeps the saved one)\n}"
+}
+
+var settingsColumns = []string{"grafanaUsername", "grafanaPassword", "email", "emailPassword", "datasourceID", "emailHost", "emailPort", "grafanaURL", "maxAttachmentSize", "grafanaAuthMode", "grafanaProxyHeader", "grafanaProxyUser", "renderConcurrency", "renderTimeout", "adminEmail", "emailTimeout", "reportTimeout", "messageUnitCost", "costCurrency", "defaultRenderWidth", "defaultRenderHeight", "defaultRenderScale", "defaultRenderTheme", "defaultLocale", "defaultMaxRetries", "readOnly", "backupDirectory", "backupInterval", "backupRetention", "defaultCatchUp", "verifyEmailDomains", "bounceMailbox", "renderer", "rendererURL", "rendererToken", "chromePath", "emailRateLimit", "emailBatchSize", "emailFromName", "emailReplyTo", "emailHeaders", "reportPartSheets", "reportPartSize", "reportPartDelivery", "trashRetention", "reuseUnchangedImages", "syslogAddress", "syslogProtocol", "maxConcurrentReports", "spreadWindow", "disableAfterFailures", "maxQueryTimeout", "variablePolicy", "signingCertificate", "signingKey"}
+
+func (settings *Settings) values() []interface{} {
+	return []interface{}{settings.GrafanaUsername, settings.GrafanaPassword, settings.Email, settings.EmailPassword, settings.DatasourceID, settings.EmailHost, settings.EmailPort, settings.GrafanaURL, settings.MaxAttachmentSize, settings.GrafanaAuthMode, settings.GrafanaProxyHeader, settings.GrafanaProxyUser, settings.RenderConcurrency, settings.RenderTimeout, settings.AdminEmail, settings.EmailTimeout, settings.ReportTimeout, settings.MessageUnitCost, settings.CostCurrency, settings.DefaultRenderWidth, settings.DefaultRenderHeight, settings.DefaultRenderScale, settings.DefaultRenderTheme, settings.DefaultLocale, settings.DefaultMaxRetries, settings.ReadOnly, settings.BackupDirectory, settings.BackupInterval, settings.BackupRetention, settings.DefaultCatchUp, settings.VerifyEmailDomains, settings.BounceMailbox, settings.Renderer, settings.RendererURL, settings.RendererToken, settings.ChromePath, settings.EmailRateLimit, settings.EmailBatchSize, settings.EmailFromName, settings.EmailReplyTo, settings.EmailHeaders, settings.ReportPartSheets, settings.ReportPartSize, settings.ReportPartDelivery, settings.TrashRetention, settings.ReuseUnchangedImages, settings.SyslogAddress, settings.SyslogProtocol, settings.MaxConcurrentReports, settings.SpreadWindow, settings.DisableAfterFailures, settings.MaxQueryTimeout, settings.VariablePolicy, settings.SigningCertificate, settings.SigningKey}
+}
+
+func (settings *Settings) fields() []interface{} {
+	return []interface{}{&settings.GrafanaUsername, &settings.GrafanaPassword, &settings.Email, &settings.EmailPassword, &settings.DatasourceID, &settings.EmailHost, &settings.EmailPort, &settings.GrafanaURL, &settings.MaxAttachmentSize, &settings.GrafanaAuthMode, &settings.GrafanaProxyHeader, &settings.GrafanaProxyUser, &settings.RenderConcurrency, &settings.RenderTimeout, &settings.AdminEmail, &settings.EmailTimeout, &settings.ReportTimeout, &settings.MessageUnitCost, &settings.CostCurrency, &settings.DefaultRenderWidth, &settings.DefaultRenderHeight, &settings.DefaultRenderScale, &settings.DefaultRenderTheme, &settings.DefaultLocale, &settings.DefaultMaxRetries, &settings.ReadOnly, &settings.BackupDirectory, &settings.BackupInterval, &settings.BackupRetention, &settings.DefaultCatchUp, &settings.VerifyEmailDomains, &settings.BounceMailbox, &settings.Renderer, &settings.RendererURL, &settings.RendererToken, &settings.ChromePath, &settings.EmailRateLimit, &settings.EmailBatchSize, &settings.EmailFromName, &settings.EmailReplyTo, &settings.EmailHeaders, &settings.ReportPartSheets, &settings.ReportPartSize, &settings.ReportPartDelivery, &settings.TrashRetention, &settings.ReuseUnchangedImages, &settings.SyslogAddress, &settings.SyslogProtocol, &settings.MaxConcurrentReports, &settings.SpreadWindow, &settings.DisableAfterFailures, &settings.MaxQueryTimeout, &settings.VariablePolicy, &settings.SigningCertificate, &settings.SigningKey}
+}
+
+// Validate checks the settings are usable before they are saved
+func (settings *Settings) Validate() error {
+	switch settings.GrafanaAuthMode {
+	case "", AuthModeBasic:
+	case AuthModeProxy:
+		if strings.TrimSpace(settings.GrafanaProxyUser) == "" {
+			return errors.New("grafanaProxyUser is required when grafanaAuthMode is proxy")
+		}
+		if settings.GrafanaProxyHeader != "" && strings.ContainsAny(settings.GrafanaProxyHeader, " :\r\n") {
+			return errors.New("grafanaProxyHeader is not a valid header name")
+		}
+	case AuthModeServiceAccount:
+		if os.Getenv(ServiceAccountTokenEnv) == "" {
+			return errors.New("Grafana has not provided a service account for the plugin, externalServiceAccounts must be enabled (Grafana 10.3+)")
+		}
+	default:
+		return errors.New("grafanaAuthMode must be one of: basic, proxy, serviceAccount")
+	}
+
+	if _, _, _, ok := splitCredentials(settings.GrafanaURL); ok {
+		return errors.New("grafanaURL can't contain credentials, set grafanaUsername and grafanaPassword instead")
+	}
+
+	if settings.RenderConcurrency < 0 || settings.RenderConcurrency > 32 {
+		return errors.New("renderConcurrency must be between 0 and 32")
+	}
+	if settings.RenderTimeout < 0 || settings.EmailTimeout < 0 || settings.ReportTimeout < 0 {
+		return errors.New("timeouts can't be negative")
+	}
+	if settings.EmailRateLimit < 0 || settings.EmailBatchSize < 0 {
+		return errors.New("emailRateLimit and emailBatchSize can't be negative")
+	}
+	if err := checkFromName(settings.EmailFromName); err != nil {
+		return errors.New("emailFromName " + err.Error())
+	}
+	if _, err := ParseEmailHeaders(settings.EmailHeaders); err != nil {
+		return errors.New("emailHeaders: " + err.Error())
+	}
+	if settings.MessageUnitCost < 0 {
+		return errors.New("messageUnitCost can't be negative")
+	}
+	if settings.DefaultRenderWidth < 0 || settings.DefaultRenderHeight < 0 || settings.DefaultRenderScale < 0 || settings.DefaultMaxRetries.Retries < 0 {
+		return errors.New("defaults can't be negative")
+	}
+	if settings.BackupInterval < 0 || settings.BackupRetention < 0 {
+		return errors.New("backupInterval and backupRetention can't be negative")
+	}
+	if !isCatchUp(settings.DefaultCatchUp) {
+		return errors.New("defaultCatchUp must be one of: skip, once, all")
+	}
+	if settings.BounceMailbox != "" {
+		if _, _, err := net.SplitHostPort(settings.BounceMailbox); err != nil {
+			return errors.New("bounceMailbox must be host:port")
+		}
+	}
+	switch settings.Renderer {
+	case "", RendererGrafana, RendererChrome:
+	case RendererRemote:
+		if strings.TrimSpace(settings.RendererURL) == "" {
+			return errors.New("rendererURL is required when renderer is remote")
+		}
+	default:
+		return errors.New("renderer must be one of: grafana, remote, chrome")
+	}
+	if settings.ReportPartSheets < 0 || settings.ReportPartSize < 0 {
+		return errors.New("reportPartSheets and reportPartSize can't be negative")
+	}
+	switch settings.ReportPartDelivery {
+	case "", PartDeliveryEmails, PartDeliveryLinks:
+	default:
+		return errors.New("reportPartDelivery must be one of: emails, links")
+	}
+	if settings.TrashRetention < 0 {
+		return errors.New("trashRetention can't be negative")
+	}
+	if settings.SyslogAddress != "" {
+		if _, _, err := net.SplitHostPort(settings.SyslogAddress); err != nil {
+			return errors.New("syslogAddress must be host:port")
+		}
+	}
+	switch settings.SyslogProtocol {
+	case "", SyslogProtocolUDP, SyslogProtocolTCP, SyslogProtocolTLS:
+	default:
+		return errors.New("syslogProtocol must be one of: udp, tcp, tls")
+	}
+	if settings.MaxConcurrentReports < 0 || settings.MaxConcurrentReports > 32 {
+		return errors.New("maxConcurrentReports must be between 0 and 32")
+	}
+	if settings.SpreadWindow < 0 || settings.SpreadWindow > 24*60*60 {
+		return errors.New("spreadWindow must be between 0 and 86400 seconds")
+	}
+	if settings.DisableAfterFailures < 0 {
+		return errors.New("disableAfterFailures can't be negative")
+	}
+	if settings.MaxQueryTimeout < 0 || settings.MaxQueryTimeout > 24*60*60 {
+		return errors.New("maxQueryTimeout must be between 0 and 86400 seconds")
+	}
+	switch settings.VariablePolicy {
+	case "", VariablePolicyWarn, VariablePolicyFail, VariablePolicyMap:
+	default:
+		return errors.New("variablePolicy must be one of: warn, fail, map")
+	}
+
+	return nil
+}
+
+// CheckSigning checks the signing certificate and key can sign the emails sent. Whether the certificate is still
+// valid is only checked when it or the key is changed, so the rest of the settings can still be saved once it has
+// expired, and whether it's issued for the sending address when that changes too.
+func (settings *Settings) CheckSigning(saved *Settings, now time.Time) error {
+	identity, err := ParseSigningIdentity(settings.SigningCertificate, settings.SigningKey)
+	if err != nil || identity == nil {
+		return err
+	}
+
+	if saved == nil || saved.SigningCertificate != settings.SigningCertificate || saved.SigningKey != settings.SigningKey {
+		return identity.Check(settings.Email, now)
+	}
+	if saved.Email != settings.Email && !identity.Covers(settings.Email) {
+		return errors.New("signingCertificate isn't issued for " + settings.Email + ", which emails are sent from")
+	}
+	return nil
 }
 
 func (datasource *SQLiteDatasource) settingsExists() (bool, error) {
@@ -68,29 +391,34 @@ func (datasource *SQLiteDatasource) CreateOrUpdateSettings(settings Settings) er
 		return err
 	}
 
+	if settings.GrafanaAuthMode == "" {
+		settings.GrafanaAuthMode = AuthModeBasic
+	}
+	values := append([]interface{}{"ID"}, settings.values()...)
+
 	if exists {
-		stmt, err := db.Prepare("UPDATE Config set id = ?, grafanaUsername = ?, grafanaPassword = ?, email = ?, emailPassword = ?, datasourceID = ?, emailHost = ?, emailPort = ?, grafanaURL = ?")
+		stmt, err := db.Prepare("UPDATE Config set id = ?, " + strings.Join(settingsColumns, " = ?, ") + " = ?")
 		defer stmt.Close()
 		if err != nil {
 			log.DefaultLogger.Error("CreateOrUpdateSettings: db.Prepare()1: ", err.Error())
 			return err
 		}
 
-		_, err = stmt.Exec("ID", settings.GrafanaUsername, settings.GrafanaPassword, settings.Email, settings.EmailPassword, settings.DatasourceID, settings.EmailHost, settings.EmailPort, settings.GrafanaURL)
+		_, err = stmt.Exec(values...)
 		if err != nil {
 			log.DefaultLogger.Error("CreateOrUpdateSettings: stmt.Exec()2: ", err.Error())
 			return err
 		}
 
 	} else {
-		stmt, err := db.Prepare("INSERT INTO Config (id, grafanaUsername, grafanaPassword, email, emailPassword, datasourceID, emailHost, emailPort, grafanaURL) VALUES (?,?,?,?,?,?,?,?,?)")
+		stmt, err := db.Prepare("INSERT INTO Config (id, " + strings.Join(settingsColumns, ", ") + ") VALUES (?" + strings.Repeat(",?", len(settingsColumns)) + ")")
 		defer stmt.Close()
 		if err != nil {
 			log.DefaultLogger.Error("CreateOrUpdateSettings: db.Prepare()2: ", err.Error())
 			return err
 		}
 
-		_, err = stmt.Exec("ID", settings.GrafanaUsername, settings.GrafanaPassword, settings.Email, settings.EmailPassword, settings.DatasourceID, settings.EmailHost, settings.EmailPort, settings.GrafanaURL)
+		_, err = stmt.Exec(values...)
 		if err != nil {
 			log.DefaultLogger.Error("CreateOrUpdateSettings: stmt.Exec(): ", err.Error())
 			return err
@@ -101,6 +429,8 @@ func (datasource *SQLiteDatasource) CreateOrUpdateSettings(settings Settings) er
 }
 
 func (datasource *SQLiteDatasource) GetSettings() (*Settings, error) {
+	defer metrics.ObserveDB("GetSettings", time.Now())
+
 	db, err := sql.Open("sqlite3", datasource.Path)
 	defer db.Close()
 	if err != nil {
@@ -108,8 +438,7 @@ func (datasource *SQLiteDatasource) GetSettings() (*Settings, error) {
 		return nil, err
 	}
 
-	var id, grafanaUsername, grafanaPassword, email, emailPassword, emailHost, grafanaURL string
-	var emailPort, datasourceID int
+	settings := Settings{GrafanaAuthMode: AuthModeBasic}
 
 	exists, err := datasource.settingsExists()
 	if err != nil {
@@ -118,7 +447,7 @@ func (datasource *SQLiteDatasource) GetSettings() (*Settings, error) {
 	}
 
 	if exists {
-		rows, err := db.Query("SELECT id, grafanaUsername, grafanaPassword, email, emailPassword, datasourceID, emailHost, emailPort, grafanaURL FROM Config")
+		rows, err := db.Query("SELECT " + strings.Join(settingsColumns, ", ") + " FROM Config")
 		defer rows.Close()
 		if err != nil {
 			log.DefaultLogger.Error("GetSettings: db.Query(): ", err.Error())
@@ -126,14 +455,12 @@ func (datasource *SQLiteDatasource) GetSettings() (*Settings, error) {
 		}
 
 		rows.Next()
-		err = rows.Scan(&id, &grafanaUsername, &grafanaPassword, &email, &emailPassword, &datasourceID, &emailHost, &emailPort, &grafanaURL)
+		err = rows.Scan(settings.fields()...)
 		if err != nil {
 			log.DefaultLogger.Error("GetSettings: rows.Scan(): ", err.Error())
 			return nil, err
 		}
-
-		return &Settings{GrafanaUsername: grafanaUsername, GrafanaPassword: grafanaPassword, Email: email, EmailPassword: emailPassword, DatasourceID: datasourceID, EmailPort: emailPort, EmailHost: emailHost, GrafanaURL: grafanaURL}, nil
 	}
 
-	return &Settings{GrafanaUsername: grafanaUsername, GrafanaPassword: grafanaPassword, Email: email, EmailPassword: emailPassword, DatasourceID: datasourceID, EmailPort: emailPort, EmailHost: emailHost, GrafanaURL: grafanaURL}, nil
+	return &settings, nil
 }
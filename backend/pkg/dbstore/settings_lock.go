@@ -0,0 +1,125 @@
+package dbstore
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// SettingsLock stops a setting, named as in the settings JSON, being changed through the API, for installs whose
+// mail server, renderer and such are managed centrally. Admins can lock settings, only super-admins can unlock them.
+type SettingsLock struct {
+	Setting  string `json:"setting"`
+	LockedBy string `json:"lockedBy"`
+	LockedAt int    `json:"lockedAt"`
+}
+
+// settingName is the name a field of the settings has in their JSON
+func settingName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
+
+// IsSettingName is whether a name is one of the settings, as it appears in their JSON
+func IsSettingName(name string) bool {
+	settingsType := reflect.TypeOf(Settings{})
+	for i := 0; i < settingsType.NumField(); i++ {
+		if settingName(settingsType.Field(i)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangedSettings is the names of the settings which differ between before and after, where no settings
+// before counts as every one of them being empty
+func ChangedSettings(before *Settings, after *Settings) []string {
+	if before == nil {
+		before = &Settings{}
+	}
+
+	beforeValue := reflect.ValueOf(*before)
+	afterValue := reflect.ValueOf(*after)
+	settingsType := beforeValue.Type()
+
+	changed := []string{}
+	for i := 0; i < settingsType.NumField(); i++ {
+		if !reflect.DeepEqual(beforeValue.Field(i).Interface(), afterValue.Field(i).Interface()) {
+			changed = append(changed, settingName(settingsType.Field(i)))
+		}
+	}
+	return changed
+}
+
+func (datasource *SQLiteDatasource) GetSettingsLocks() ([]SettingsLock, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetSettingsLocks: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT setting, lockedBy, lockedAt FROM SettingsLock ORDER BY setting")
+	if err != nil {
+		log.DefaultLogger.Error("GetSettingsLocks: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []SettingsLock{}
+	for rows.Next() {
+		var lock SettingsLock
+		err = rows.Scan(&lock.Setting, &lock.LockedBy, &lock.LockedAt)
+		if err != nil {
+			log.DefaultLogger.Error("GetSettingsLocks: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+// LockSetting locks a setting, leaving who locked it first as it was if it's already locked
+func (datasource *SQLiteDatasource) LockSetting(setting string, lockedBy string) (*SettingsLock, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("LockSetting: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	lock := SettingsLock{Setting: setting, LockedBy: lockedBy, LockedAt: int(time.Now().Unix())}
+	_, err = db.Exec("INSERT OR IGNORE INTO SettingsLock (setting, lockedBy, lockedAt) VALUES (?,?,?)", lock.Setting, lock.LockedBy, lock.LockedAt)
+	if err != nil {
+		log.DefaultLogger.Error("LockSetting: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	err = db.QueryRow("SELECT setting, lockedBy, lockedAt FROM SettingsLock WHERE setting = ?", setting).Scan(&lock.Setting, &lock.LockedBy, &lock.LockedAt)
+	if err != nil {
+		log.DefaultLogger.Error("LockSetting: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &lock, nil
+}
+
+func (datasource *SQLiteDatasource) UnlockSetting(setting string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UnlockSetting: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM SettingsLock WHERE setting = ?", setting)
+	if err != nil {
+		log.DefaultLogger.Error("UnlockSetting: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
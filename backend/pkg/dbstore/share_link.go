@@ -0,0 +1,305 @@
+package dbstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Name of the secret share links are signed with
+const ShareLinkSecret = "share-link"
+
+// How long a share link lasts when no time is given, and the longest it can
+const (
+	DefaultShareLinkHours = 72
+	MaxShareLinkHours     = 30 * 24
+)
+
+// How long the links emailed in place of reports too large to attach last, long enough for recipients to get to them
+const AttachmentLinkHours = 7 * 24
+
+// Directory next to the database the copies of shared files are kept in, one directory per link
+const sharedDirectory = "shared"
+
+// Files which can be shared, the reports' own formats and the zips of those too large to attach. Anything else
+// next to the database, e.g. the database itself, never is.
+var shareableExtensions = map[string]bool{"." + FormatXLSX: true, "." + FormatPPTX: true, "." + FormatHTML: true, "." + FormatJSON: true, ".zip": true}
+
+// ShareLink lets anyone with its URL download one generated report until it expires or is revoked, e.g. a report
+// too large to attach sent on to someone without a Grafana account
+type ShareLink struct {
+	ID string `json:"id"`
+	// Path of the copy of the file made for the link, shared/<id>/<name>, next to the database. Links made before
+	// copies were are to the file in the data directory, e.g. a report too large to attach, or archive/<run id>.json.
+	File      string `json:"file"`
+	CreatedBy string `json:"createdBy"`
+	CreatedAt int    `json:"createdAt"`
+	ExpiresAt int    `json:"expiresAt"`
+	// When the link was revoked and by whom, 0 while it hasn't been
+	RevokedAt int    `json:"revokedAt"`
+	RevokedBy string `json:"revokedBy"`
+	Downloads int    `json:"downloads"`
+}
+
+// ShareLinkRequest is what to share and for how long: a schedule's report file in the data directory, or the JSON
+// archived for a run
+type ShareLinkRequest struct {
+	File       string `json:"file"`
+	ScheduleID string `json:"scheduleID"`
+	RunID      string `json:"runID"`
+	Hours      int    `json:"hours"`
+}
+
+func ShareLinkRequestFields() string {
+	return "\n{\n\tfile string (a report's file name, with)" +
+		"\n\tscheduleID string (the schedule the report is of, or)" +
+		"\n\trunID string (the run whose JSON is shared)" +
+		"\n\thours int (" + strconv.Itoa(DefaultShareLinkHours) + " by default, at most " + strconv.Itoa(MaxShareLinkHours) + ")\n}"
+}
+
+// Validate checks the request is for a report's file, returning its name in the schedule's report directory, or
+// for a run's JSON its path in the data directory
+func (request *ShareLinkRequest) Validate() (string, error) {
+	if request.Hours == 0 {
+		request.Hours = DefaultShareLinkHours
+	}
+	if request.Hours < 0 || request.Hours > MaxShareLinkHours {
+		return "", fmt.Errorf("hours must be from 1 to %d", MaxShareLinkHours)
+	}
+
+	if (request.File == "") == (request.RunID == "") {
+		return "", errors.New("a share link needs one of file or runID")
+	}
+	if request.RunID != "" {
+		if _, err := uuid.Parse(request.RunID); err != nil {
+			return "", errors.New("runID isn't the id of a run")
+		}
+		return filepath.Join("archive", request.RunID+".json"), nil
+	}
+
+	if request.ScheduleID == "" {
+		return "", errors.New("scheduleID is required to share a file")
+	}
+	if err := CheckReportFile(request.File); err != nil {
+		return "", err
+	}
+	return request.File, nil
+}
+
+// CheckReportFile checks a file is named as one of a schedule's reports, by name only rather than a path which could
+// lead anywhere else
+func CheckReportFile(name string) error {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return errors.New("file must be the name of a report's file")
+	}
+	if !shareableExtensions[strings.ToLower(filepath.Ext(name))] {
+		return errors.New("only reports' xlsx, pptx, html, json and zip files can be shared")
+	}
+	return nil
+}
+
+// SignShareLink is the signature of a share link, so its URL only works for the file and expiry it was made with
+func SignShareLink(secret []byte, link ShareLink) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(link.ID + "\n" + link.File + "\n" + strconv.Itoa(link.ExpiresAt)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CheckShareLink(secret []byte, link ShareLink, signature string) bool {
+	expected := SignShareLink(secret, link)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// ShareFile copies a file to share, as reports are written over each time their schedule is sent and a link
+// should always download what was shared. Returns the copy's path for the link.
+func (datasource *SQLiteDatasource) ShareFile(source string) (string, error) {
+	file := filepath.Join(sharedDirectory, uuid.New().String(), filepath.Base(source))
+	target := filepath.Join(filepath.Dir(datasource.Path), file)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		log.DefaultLogger.Error("ShareFile: os.MkdirAll(): ", err.Error())
+		return "", err
+	}
+
+	err := copyFile(source, target)
+	if err != nil {
+		log.DefaultLogger.Error("ShareFile: copyFile(): ", err.Error())
+		os.RemoveAll(filepath.Dir(target))
+		return "", err
+	}
+	return filepath.ToSlash(file), nil
+}
+
+// SharedFilePath is where the link's file is, its copy or for links made before they were copied, the file in the
+// data directory
+func (datasource *SQLiteDatasource) SharedFilePath(link ShareLink) string {
+	if strings.HasPrefix(link.File, sharedDirectory+"/") {
+		return filepath.Join(filepath.Dir(datasource.Path), filepath.FromSlash(link.File))
+	}
+	return filepath.Join(datasource.DataDirectory(), link.File)
+}
+
+// removeSharedFile deletes the copy made for a link once it can't be downloaded any more
+func (datasource *SQLiteDatasource) removeSharedFile(link ShareLink) {
+	if !strings.HasPrefix(link.File, sharedDirectory+"/") {
+		return
+	}
+	if err := os.RemoveAll(filepath.Dir(datasource.SharedFilePath(link))); err != nil {
+		log.DefaultLogger.Error("removeSharedFile: os.RemoveAll(): ", err.Error())
+	}
+}
+
+// PruneShareLinks deletes the copies of the files of links which have expired or been revoked. The links are
+// kept as a record of what was shared.
+func (datasource *SQLiteDatasource) PruneShareLinks() {
+	links, err := datasource.GetShareLinks()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, link := range links {
+		if !link.Active(now) {
+			datasource.removeSharedFile(link)
+		}
+	}
+}
+
+// Active is whether the link can still be used at the time given
+func (link *ShareLink) Active(now time.Time) bool {
+	return link.RevokedAt == 0 && int(now.Unix()) < link.ExpiresAt
+}
+
+const shareLinkColumns = "id, file, createdBy, createdAt, expiresAt, revokedAt, revokedBy, downloads"
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(&link.ID, &link.File, &link.CreatedBy, &link.CreatedAt, &link.ExpiresAt, &link.RevokedAt, &link.RevokedBy, &link.Downloads)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// CreateShareLink shares the file for the hours given
+func (datasource *SQLiteDatasource) CreateShareLink(file string, hours int, by string) (*ShareLink, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateShareLink: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	now := time.Now()
+	link := ShareLink{ID: uuid.New().String(), File: file, CreatedBy: by, CreatedAt: int(now.Unix()), ExpiresAt: int(now.Add(time.Duration(hours) * time.Hour).Unix())}
+	err = retryBusy("CreateShareLink", func() error {
+		_, err := db.Exec("INSERT INTO ShareLink ("+shareLinkColumns+") VALUES (?,?,?,?,?,?,?,?)", link.ID, link.File, link.CreatedBy, link.CreatedAt, link.ExpiresAt, link.RevokedAt, link.RevokedBy, link.Downloads)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CreateShareLink: db.Exec(): ", err.Error())
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+func (datasource *SQLiteDatasource) GetShareLink(id string) (*ShareLink, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetShareLink: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	link, err := scanShareLink(db.QueryRow("SELECT "+shareLinkColumns+" FROM ShareLink WHERE id = ?", id))
+	if err != nil {
+		log.DefaultLogger.Error("GetShareLink: row.Scan(): ", err.Error())
+		return nil, err
+	}
+	return link, nil
+}
+
+// GetShareLinks lists the share links, newest first
+func (datasource *SQLiteDatasource) GetShareLinks() ([]ShareLink, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetShareLinks: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + shareLinkColumns + " FROM ShareLink ORDER BY createdAt DESC")
+	if err != nil {
+		log.DefaultLogger.Error("GetShareLinks: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []ShareLink{}
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetShareLinks: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		links = append(links, *link)
+	}
+	return links, nil
+}
+
+// RevokeShareLink stops a link working before it expires, keeping when it was first revoked
+func (datasource *SQLiteDatasource) RevokeShareLink(id string, by string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RevokeShareLink: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("RevokeShareLink", func() error {
+		_, err := db.Exec("UPDATE ShareLink SET revokedAt = ?, revokedBy = ? WHERE id = ? AND revokedAt = 0", time.Now().Unix(), by, id)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("RevokeShareLink: db.Exec(): ", err.Error())
+		return err
+	}
+	link, err := datasource.GetShareLink(id)
+	if err != nil {
+		return err
+	}
+	datasource.removeSharedFile(*link)
+
+	return nil
+}
+
+// CountShareLinkDownload records that the link was used
+func (datasource *SQLiteDatasource) CountShareLinkDownload(id string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CountShareLinkDownload: sql.Open(): ", err.Error())
+		return err
+	}
+
+	err = retryBusy("CountShareLinkDownload", func() error {
+		_, err := db.Exec("UPDATE ShareLink SET downloads = downloads + 1 WHERE id = ?", id)
+		return err
+	})
+	if err != nil {
+		log.DefaultLogger.Error("CountShareLinkDownload: db.Exec(): ", err.Error())
+		return err
+	}
+	return nil
+}
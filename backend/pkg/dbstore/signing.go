@@ -0,0 +1,147 @@
+package dbstore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"time"
+)
+
+// How long before the signing certificate expires admins are warned, as emails are sent unsigned once it has
+const SigningExpiryWarning = 30 * 24 * time.Hour
+
+// OID of the emailAddress attribute older certificates put in their subject rather than a subject alternative name
+var oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
+
+// SigningIdentity is the S/MIME certificate and private key reports' emails are signed with, so recipients can tell
+// them from phishing emails made to look like them
+type SigningIdentity struct {
+	Certificate *x509.Certificate
+	// Intermediate certificates after the signing certificate, sent with the signature so it can be verified
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// ParseSigningIdentity reads the PEM certificate, followed by any intermediates, and its PEM private key. It's nil
+// when neither is set, as emails aren't signed.
+func ParseSigningIdentity(certificatePEM string, keyPEM string) (*SigningIdentity, error) {
+	if strings.TrimSpace(certificatePEM) == "" && strings.TrimSpace(keyPEM) == "" {
+		return nil, nil
+	}
+	if strings.TrimSpace(certificatePEM) == "" || strings.TrimSpace(keyPEM) == "" {
+		return nil, errors.New("signingCertificate and signingKey are needed together")
+	}
+
+	var certificates []*x509.Certificate
+	rest := []byte(certificatePEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("signingCertificate: " + err.Error())
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("signingCertificate has no PEM certificate")
+	}
+
+	key, err := parseSigningKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := certificates[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(key.Public()) {
+		return nil, errors.New("signingKey isn't the private key of signingCertificate")
+	}
+
+	return &SigningIdentity{Certificate: certificates[0], Chain: certificates[1:], Key: key}, nil
+}
+
+// parseSigningKey reads an RSA or ECDSA private key, in PKCS #8 or its algorithm's own PEM
+func parseSigningKey(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("signingKey has no PEM private key")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "" {
+		return nil, errors.New("signingKey must not be encrypted with a passphrase")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.New("signingKey: " + err.Error())
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, errors.New("signingKey must be an RSA or ECDSA key")
+}
+
+// Covers is whether the certificate was issued for the address, which mail clients check against the sender
+func (identity *SigningIdentity) Covers(address string) bool {
+	address = strings.TrimSpace(address)
+	for _, email := range identity.Certificate.EmailAddresses {
+		if strings.EqualFold(email, address) {
+			return true
+		}
+	}
+	for _, name := range identity.Certificate.Subject.Names {
+		if email, ok := name.Value.(string); ok && name.Type.Equal(oidEmailAddress) && strings.EqualFold(email, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is why the identity can't sign emails sent from the address, if it can't
+func (identity *SigningIdentity) Check(address string, now time.Time) error {
+	if now.After(identity.Certificate.NotAfter) {
+		return errors.New("signingCertificate expired on " + identity.Certificate.NotAfter.Format("2 Jan 2006"))
+	}
+	if now.Before(identity.Certificate.NotBefore) {
+		return errors.New("signingCertificate isn't valid until " + identity.Certificate.NotBefore.Format("2 Jan 2006"))
+	}
+	if !identity.Covers(address) {
+		return errors.New("signingCertificate isn't issued for " + address + ", which emails are sent from")
+	}
+	return nil
+}
+
+// ExpiryWarning is what admins are told when the certificate has expired, or expires within SigningExpiryWarning,
+// empty when it doesn't
+func (identity *SigningIdentity) ExpiryWarning(now time.Time) string {
+	notAfter := identity.Certificate.NotAfter
+	if now.After(notAfter) {
+		return "the signing certificate expired on " + notAfter.Format("2 Jan 2006") + ", reports' emails are being sent unsigned until it's replaced"
+	}
+	if notAfter.Sub(now) <= SigningExpiryWarning {
+		return "the signing certificate expires on " + notAfter.Format("2 Jan 2006") + ", replace it before then or reports' emails will be sent unsigned"
+	}
+	return ""
+}
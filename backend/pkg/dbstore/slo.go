@@ -0,0 +1,204 @@
+package dbstore
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+)
+
+// Compliance is tracked per calendar month in UTC, formatted as e.g. 2021-06
+const sloPeriodFormat = "2006-01"
+
+// SLOCompliance is how many of the times a schedule was due in a month its report was delivered within its SLO window
+type SLOCompliance struct {
+	ScheduleID string `json:"scheduleID"`
+	Period     string `json:"period"`
+	// Number of times the report was due, and how many of those were sent in time
+	Due    int `json:"due"`
+	OnTime int `json:"onTime"`
+	// Percentage of due reports sent in time, compared against the schedule's target
+	Compliance float64 `json:"compliance"`
+	Target     float64 `json:"target"`
+	Met        bool    `json:"met"`
+	UpdatedAt  int     `json:"updatedAt"`
+}
+
+const sloComplianceColumns = "scheduleID, period, due, onTime, compliance, target, met, updatedAt"
+
+// Most times worked out for a schedule in one period, so a schedule due every minute can't hold up the update
+const maxExpectedRuns = 5000
+
+// sloPeriod is the UTC month a time is in, so every server buckets runs the same whatever its timezone
+func sloPeriod(scheduledAt int) (string, int, int) {
+	due := time.Unix(int64(scheduledAt), 0).UTC()
+	start := time.Date(due.Year(), due.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	return start.Format(sloPeriodFormat), int(start.Unix()), int(end.Unix())
+}
+
+// UpdateSLOCompliance recomputes the compliance of the month a run was due in, and of the month before so the runs
+// missed at the end of it count too
+func (datasource *SQLiteDatasource) UpdateSLOCompliance(schedule Schedule, scheduledAt int) error {
+	defer metrics.ObserveDB("UpdateSLOCompliance", time.Now())
+
+	if schedule.SLOTarget <= 0 || scheduledAt <= 0 {
+		return nil
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("UpdateSLOCompliance: sql.Open(): ", err.Error())
+		return err
+	}
+
+	rules, err := datasource.GetBlackoutRules(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateSLOCompliance: GetBlackoutRules(): ", err.Error())
+		return err
+	}
+
+	_, start, _ := sloPeriod(scheduledAt)
+	for _, at := range []int{start - 1, scheduledAt} {
+		if err := updateSLOPeriod(db, schedule, rules, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSLOPeriod works out the compliance of a month from the times the schedule was due in it and its run history.
+// Retries of a report share the time it was due, so it counts as on time if any of them was sent in time, and a
+// time nothing was sent for counts as missed.
+func updateSLOPeriod(db *sql.DB, schedule Schedule, rules *BlackoutRules, scheduledAt int) error {
+	period, start, end := sloPeriod(scheduledAt)
+
+	expected, err := expectedRuns(db, schedule, rules, start, end)
+	if err != nil {
+		return err
+	}
+	due := make(map[int]bool)
+	for _, at := range expected {
+		due[at] = true
+	}
+
+	rows, err := db.Query("SELECT scheduledAt, status, finishedAt FROM ReportRun WHERE scheduleID = ? AND test = 0 AND scheduledAt >= ? AND scheduledAt < ?", schedule.ID, start, end)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateSLOCompliance: db.Query(): ", err.Error())
+		return err
+	}
+	onTime := make(map[int]bool)
+	attempted := make(map[int]bool)
+	var skipped []int
+	for rows.Next() {
+		var at, finishedAt int
+		var status string
+		if err := rows.Scan(&at, &status, &finishedAt); err != nil {
+			rows.Close()
+			log.DefaultLogger.Error("UpdateSLOCompliance: rows.Scan(): ", err.Error())
+			return err
+		}
+
+		// Runs skipped for their condition weren't due to be delivered
+		if status == ReportRunStatusSkipped {
+			skipped = append(skipped, at)
+			continue
+		}
+		// Including runs a blackout postponed, which are due at a time the schedule itself isn't
+		due[at], attempted[at] = true, true
+		if (status == ReportRunStatusSent || status == ReportRunStatusPartial) && finishedAt-at <= schedule.SLOWindow {
+			onTime[at] = true
+		}
+	}
+	rows.Close()
+	for _, at := range skipped {
+		if !attempted[at] {
+			delete(due, at)
+		}
+	}
+
+	compliance := SLOCompliance{ScheduleID: schedule.ID, Period: period, Due: len(due), OnTime: len(onTime), Target: schedule.SLOTarget, UpdatedAt: int(time.Now().Unix())}
+	if compliance.Due == 0 {
+		return nil
+	}
+	compliance.Compliance = float64(compliance.OnTime) / float64(compliance.Due) * 100
+	compliance.Met = compliance.Compliance >= compliance.Target
+
+	_, err = db.Exec("INSERT OR REPLACE INTO SLOCompliance ("+sloComplianceColumns+") VALUES (?,?,?,?,?,?,?,?)",
+		compliance.ScheduleID, compliance.Period, compliance.Due, compliance.OnTime, compliance.Compliance, compliance.Target, compliance.Met, compliance.UpdatedAt)
+	if err != nil {
+		log.DefaultLogger.Error("UpdateSLOCompliance: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// expectedRuns are the times a schedule was due between start and end, leaving out those in its blackouts and those
+// whose SLO window hasn't passed yet. Its times follow on from each other, so they're worked out from the last run
+// before the period, or the first in it. Schedules sent when new data arrives aren't due at set times, so have none.
+func expectedRuns(db *sql.DB, schedule Schedule, rules *BlackoutRules, start int, end int) ([]int, error) {
+	if schedule.TriggerType == TriggerTypeData {
+		return nil, nil
+	}
+
+	var from int
+	err := db.QueryRow("SELECT scheduledAt FROM ReportRun WHERE scheduleID = ? AND test = 0 AND scheduledAt > 0 AND scheduledAt < ? ORDER BY scheduledAt DESC LIMIT 1", schedule.ID, start).Scan(&from)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow("SELECT scheduledAt FROM ReportRun WHERE scheduleID = ? AND test = 0 AND scheduledAt >= ? AND scheduledAt < ? ORDER BY scheduledAt LIMIT 1", schedule.ID, start, end).Scan(&from)
+	}
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("UpdateSLOCompliance: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	passed := int(time.Now().Unix()) - schedule.SLOWindow
+	var times []int
+	for at, i := from, 0; at < end && at <= passed && i < maxExpectedRuns; i++ {
+		if at >= start && rules.Covering(time.Unix(int64(at), 0).In(schedule.Location())) == nil {
+			times = append(times, at)
+		}
+		next := schedule.NextReportTimeAfter(time.Unix(int64(at), 0))
+		if next <= at {
+			break
+		}
+		at = next
+	}
+	return times, nil
+}
+
+// GetSLOCompliance returns the stored compliance of a schedule, most recent month first
+func (datasource *SQLiteDatasource) GetSLOCompliance(scheduleID string) ([]SLOCompliance, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetSLOCompliance: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+sloComplianceColumns+" FROM SLOCompliance WHERE scheduleID = ? ORDER BY period DESC", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetSLOCompliance: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	compliances := []SLOCompliance{}
+	for rows.Next() {
+		var compliance SLOCompliance
+		err = rows.Scan(&compliance.ScheduleID, &compliance.Period, &compliance.Due, &compliance.OnTime, &compliance.Compliance, &compliance.Target, &compliance.Met, &compliance.UpdatedAt)
+		if err != nil {
+			log.DefaultLogger.Error("GetSLOCompliance: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		compliances = append(compliances, compliance)
+	}
+
+	return compliances, nil
+}
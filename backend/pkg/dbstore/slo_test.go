@@ -0,0 +1,49 @@
+package dbstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSLOCountsMissedRunsInUTCMonths(t *testing.T) {
+	datasource := newTestDatasource(t)
+	created, err := datasource.CreateSchedule("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := *created
+	schedule.Interval = IntervalDaily
+	schedule.Time = "23:30"
+	schedule.Timezone = "UTC"
+	schedule.SLOTarget = 90
+	schedule.SLOWindow = 3600
+
+	// Sent on time on the first two days of March, then nothing was sent for the rest of the month
+	var last int
+	for day := 1; day <= 2; day++ {
+		scheduledAt := int(time.Date(2026, 3, day, 23, 30, 0, 0, time.UTC).Unix())
+		run, err := datasource.CreateReportRun(schedule.ID, scheduledAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run.Status, run.FinishedAt = ReportRunStatusSent, scheduledAt+60
+		if err := datasource.UpdateReportRun(*run); err != nil {
+			t.Fatal(err)
+		}
+		last = scheduledAt
+	}
+	if err := datasource.UpdateSLOCompliance(schedule, last); err != nil {
+		t.Fatal(err)
+	}
+
+	compliances, err := datasource.GetSLOCompliance(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compliances) != 1 || compliances[0].Period != "2026-03" {
+		t.Fatalf("expected only March's compliance, got %+v", compliances)
+	}
+	if compliances[0].Due != 31 || compliances[0].OnTime != 2 || compliances[0].Met {
+		t.Errorf("expected 2 of the 31 times it was due to be on time, got %+v", compliances[0])
+	}
+}
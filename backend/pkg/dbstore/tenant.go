@@ -0,0 +1,150 @@
+package dbstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Set to "org" to store each Grafana organisation's data in its own database, for hosted setups serving
+// several countries from one Grafana. Otherwise every organisation shares the one database.
+const TenantIsolationEnv = "MSUPPLY_TENANT_ISOLATION"
+
+const TenantIsolationOrg = "org"
+
+// Directory next to the main database holding a directory of data for each organisation
+const TenantsDirectory = "tenants"
+
+// The default organisation's data stays in the main database, so turning isolation on for an existing
+// install keeps its schedules
+const defaultOrgID = 1
+
+const tenantPrefix = "org-"
+
+// Directory in an organisation's data directory with a directory of the files written for each schedule
+const ReportsDirectory = "reports"
+
+var (
+	tenantMutex sync.Mutex
+	tenants     = make(map[int64]*SQLiteDatasource)
+)
+
+func TenantIsolation() bool {
+	return os.Getenv(TenantIsolationEnv) == TenantIsolationOrg
+}
+
+func tenantDirectory(orgID int64) string {
+	return tenantPrefix + strconv.FormatInt(orgID, 10)
+}
+
+// OrgID is the Grafana organisation whose data the datasource holds, the default organisation for the main database
+func (datasource *SQLiteDatasource) OrgID() int64 {
+	directory := filepath.Base(filepath.Dir(datasource.Path))
+	if orgID, err := strconv.ParseInt(strings.TrimPrefix(directory, tenantPrefix), 10, 64); err == nil && strings.HasPrefix(directory, tenantPrefix) {
+		return orgID
+	}
+	return defaultOrgID
+}
+
+// DataDirectory is where the organisation's files are kept, the directory of its database
+func (datasource *SQLiteDatasource) DataDirectory() string {
+	return filepath.Dir(datasource.Path)
+}
+
+// ReportDirectory is where the schedule's reports are written. Each schedule has its own, so schedules with the
+// same name, or of different organisations, never write over each other's files.
+func (datasource *SQLiteDatasource) ReportDirectory(scheduleID string) string {
+	return filepath.Join(datasource.DataDirectory(), ReportsDirectory, filepath.Base(scheduleID))
+}
+
+// ArchiveDirectory is where the JSON of the organisation's runs is kept after their reports are sent
+func (datasource *SQLiteDatasource) ArchiveDirectory() string {
+	return filepath.Join(datasource.DataDirectory(), "archive")
+}
+
+// Tenant is the datasource holding the organisation's data, creating its database the first time it is used.
+// Without isolation it's always the main datasource.
+func (datasource *SQLiteDatasource) Tenant(orgID int64) (*SQLiteDatasource, error) {
+	if !TenantIsolation() || orgID == defaultOrgID {
+		return datasource, nil
+	}
+	if orgID <= 0 {
+		return nil, errors.New("no organisation to find the database of")
+	}
+
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+
+	if tenant, ok := tenants[orgID]; ok {
+		return tenant, nil
+	}
+
+	dir := filepath.Join(filepath.Dir(datasource.Path), TenantsDirectory, tenantDirectory(orgID))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.DefaultLogger.Error("Tenant: os.MkdirAll(): ", err.Error())
+		return nil, err
+	}
+
+	tenant := &SQLiteDatasource{instanceManager: datasource.instanceManager, Path: filepath.Join(dir, filepath.Base(datasource.Path))}
+	log.DefaultLogger.Info(fmt.Sprintf("Opening the database of organisation %d at %s", orgID, tenant.Path))
+	tenant.Init()
+	tenants[orgID] = tenant
+
+	return tenant, nil
+}
+
+// Tenants are the datasources of every organisation with a database, the main datasource first. Without
+// isolation it's only the main datasource.
+func (datasource *SQLiteDatasource) Tenants() ([]*SQLiteDatasource, error) {
+	all := []*SQLiteDatasource{datasource}
+	if !TenantIsolation() {
+		return all, nil
+	}
+
+	entries, err := filepath.Glob(filepath.Join(filepath.Dir(datasource.Path), TenantsDirectory, tenantPrefix+"*", filepath.Base(datasource.Path)))
+	if err != nil {
+		log.DefaultLogger.Error("Tenants: filepath.Glob(): ", err.Error())
+		return nil, err
+	}
+
+	var orgIDs []int64
+	for _, entry := range entries {
+		orgID, err := strconv.ParseInt(strings.TrimPrefix(filepath.Base(filepath.Dir(entry)), tenantPrefix), 10, 64)
+		if err != nil || orgID == defaultOrgID {
+			continue
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+
+	for _, orgID := range orgIDs {
+		tenant, err := datasource.Tenant(orgID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, tenant)
+	}
+
+	return all, nil
+}
+
+// ForEachTenant runs a job against every organisation's database in turn, e.g. sending their reports
+func (datasource *SQLiteDatasource) ForEachTenant(job func(tenant *SQLiteDatasource)) {
+	all, err := datasource.Tenants()
+	if err != nil {
+		log.DefaultLogger.Error("ForEachTenant: Tenants(): ", err.Error())
+		return
+	}
+
+	for _, tenant := range all {
+		job(tenant)
+	}
+}
@@ -0,0 +1,180 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Days deleted schedules and report groups are kept when the settings don't say
+const DefaultTrashRetention = 30
+
+var ErrNotInTrash = errors.New("there is nothing in the trash with this id")
+
+// TrashItem is a deleted schedule or report group, which can be restored until it is purged
+type TrashItem struct {
+	Kind      string `json:"kind"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	DeletedAt int    `json:"deletedAt"`
+	DeletedBy string `json:"deletedBy"`
+	// When it will be deleted for good, as unix seconds
+	PurgeAt int `json:"purgeAt"`
+}
+
+// The table each kind of item is kept in
+var trashTables = map[string]string{ItemKindSchedule: "Schedule", ItemKindReportGroup: "ReportGroup"}
+
+// TrashRetentionDays is how many days items are kept in the trash
+func TrashRetentionDays(settings *Settings) int {
+	if settings == nil || settings.TrashRetention <= 0 {
+		return DefaultTrashRetention
+	}
+	return settings.TrashRetention
+}
+
+func (datasource *SQLiteDatasource) moveToTrash(caller string, table string, id string, by string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error(caller+": sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("UPDATE "+table+" SET deletedAt = ?, deletedBy = ? WHERE id = ? AND deletedAt = 0", time.Now().Unix(), by, id)
+	if err != nil {
+		log.DefaultLogger.Error(caller+": db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetTrash lists the deleted schedules and report groups, most recently deleted first. Only those deleted by the
+// login are listed, unless it is empty.
+func (datasource *SQLiteDatasource) GetTrash(deletedBy string) ([]TrashItem, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetTrash: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	settings, _ := datasource.GetSettings()
+	retention := int64(TrashRetentionDays(settings)) * 24 * 60 * 60
+
+	rows, err := db.Query("SELECT ?, id, name, deletedAt, deletedBy FROM Schedule WHERE deletedAt > 0 AND (? = '' OR deletedBy = ?)"+
+		" UNION ALL SELECT ?, id, name, deletedAt, deletedBy FROM ReportGroup WHERE deletedAt > 0 AND (? = '' OR deletedBy = ?)"+
+		" ORDER BY deletedAt DESC", ItemKindSchedule, deletedBy, deletedBy, ItemKindReportGroup, deletedBy, deletedBy)
+	if err != nil {
+		log.DefaultLogger.Error("GetTrash: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []TrashItem{}
+	for rows.Next() {
+		var item TrashItem
+		err = rows.Scan(&item.Kind, &item.ID, &item.Name, &item.DeletedAt, &item.DeletedBy)
+		if err != nil {
+			log.DefaultLogger.Error("GetTrash: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		item.PurgeAt = item.DeletedAt + int(retention)
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// GetTrashItem finds a deleted schedule or report group, returning ErrNotInTrash if it isn't in the trash
+func (datasource *SQLiteDatasource) GetTrashItem(kind string, id string) (*TrashItem, error) {
+	table, ok := trashTables[kind]
+	if !ok {
+		return nil, ErrNotInTrash
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetTrashItem: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	item := TrashItem{Kind: kind}
+	err = db.QueryRow("SELECT id, name, deletedAt, deletedBy FROM "+table+" WHERE id = ? AND deletedAt > 0", id).Scan(&item.ID, &item.Name, &item.DeletedAt, &item.DeletedBy)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		log.DefaultLogger.Error("GetTrashItem: db.QueryRow(): ", err.Error())
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// RestoreFromTrash puts a deleted schedule or report group back as it was
+func (datasource *SQLiteDatasource) RestoreFromTrash(kind string, id string) error {
+	table, ok := trashTables[kind]
+	if !ok {
+		return ErrNotInTrash
+	}
+
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("RestoreFromTrash: sql.Open(): ", err.Error())
+		return err
+	}
+
+	result, err := db.Exec("UPDATE "+table+" SET deletedAt = 0, deletedBy = '' WHERE id = ? AND deletedAt > 0", id)
+	if err != nil {
+		log.DefaultLogger.Error("RestoreFromTrash: db.Exec(): ", err.Error())
+		return err
+	}
+	if restored, _ := result.RowsAffected(); restored == 0 {
+		return ErrNotInTrash
+	}
+
+	return nil
+}
+
+// PurgeFromTrash deletes a schedule or report group in the trash for good
+func (datasource *SQLiteDatasource) PurgeFromTrash(kind string, id string) error {
+	if _, err := datasource.GetTrashItem(kind, id); err != nil {
+		return err
+	}
+
+	if kind == ItemKindSchedule {
+		return datasource.PurgeSchedule(id)
+	}
+	return datasource.PurgeReportGroup(id)
+}
+
+// PurgeExpiredTrash deletes for good whatever has been in the trash for longer than the settings keep it
+func (datasource *SQLiteDatasource) PurgeExpiredTrash() {
+	settings, err := datasource.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("PurgeExpiredTrash: GetSettings(): ", err.Error())
+		return
+	}
+
+	items, err := datasource.GetTrash("")
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -TrashRetentionDays(settings)).Unix()
+	for _, item := range items {
+		if int64(item.DeletedAt) > cutoff {
+			continue
+		}
+		log.DefaultLogger.Info("PurgeExpiredTrash: purging " + item.Kind + " " + item.ID + " (" + item.Name + ")")
+		if err := datasource.PurgeFromTrash(item.Kind, item.ID); err != nil {
+			log.DefaultLogger.Error("PurgeExpiredTrash: PurgeFromTrash(): ", err.Error())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package dbstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Name of the secret unsubscribe links are signed with
+const UnsubscribeSecret = "unsubscribe"
+
+// Unsubscribe is a recipient who has opted out of a schedule's report
+type Unsubscribe struct {
+	ScheduleID string `json:"scheduleID"`
+	Address    string `json:"address"`
+	Timestamp  int    `json:"timestamp"`
+}
+
+// SignUnsubscribe is the signature of an unsubscribe link, so a link only opts out the address it was sent to
+func SignUnsubscribe(secret []byte, scheduleID string, address string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(scheduleID + "\n" + strings.ToLower(strings.TrimSpace(address))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CheckUnsubscribe(secret []byte, scheduleID string, address string, signature string) bool {
+	expected := SignUnsubscribe(secret, scheduleID, address)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// CreateUnsubscribe opts the address out of the schedule, doing nothing when it already is
+func (datasource *SQLiteDatasource) CreateUnsubscribe(scheduleID string, address string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CreateUnsubscribe: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("INSERT OR IGNORE INTO Unsubscribe (scheduleID, address, timestamp) VALUES (?, ?, ?)", scheduleID, strings.TrimSpace(address), time.Now().Unix())
+	if err != nil {
+		log.DefaultLogger.Error("CreateUnsubscribe: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// DeleteUnsubscribe opts the address back in to the schedule
+func (datasource *SQLiteDatasource) DeleteUnsubscribe(scheduleID string, address string) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("DeleteUnsubscribe: sql.Open(): ", err.Error())
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM Unsubscribe WHERE scheduleID = ? AND address = ?", scheduleID, address)
+	if err != nil {
+		log.DefaultLogger.Error("DeleteUnsubscribe: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (datasource *SQLiteDatasource) GetUnsubscribes(scheduleID string) ([]Unsubscribe, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetUnsubscribes: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT scheduleID, address, timestamp FROM Unsubscribe WHERE scheduleID = ? ORDER BY address", scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("GetUnsubscribes: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	unsubscribes := []Unsubscribe{}
+	for rows.Next() {
+		var unsubscribe Unsubscribe
+		err = rows.Scan(&unsubscribe.ScheduleID, &unsubscribe.Address, &unsubscribe.Timestamp)
+		if err != nil {
+			log.DefaultLogger.Error("GetUnsubscribes: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+
+	return unsubscribes, nil
+}
+
+// SubscribedAddresses leaves out the addresses which have opted out of the schedule
+func (datasource *SQLiteDatasource) SubscribedAddresses(scheduleID string, addresses []string) ([]string, error) {
+	unsubscribes, err := datasource.GetUnsubscribes(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	optedOut := make(map[string]bool)
+	for _, unsubscribe := range unsubscribes {
+		optedOut[strings.ToLower(unsubscribe.Address)] = true
+	}
+
+	subscribed := []string{}
+	for _, address := range addresses {
+		if !optedOut[strings.ToLower(strings.TrimSpace(address))] {
+			subscribed = append(subscribed, address)
+		}
+	}
+
+	return subscribed, nil
+}
@@ -0,0 +1,406 @@
+package dbstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Adding a column with a constant default only changes the schema, so takes about the same time whatever the
+// table's size. Those SQLite checks against the existing rows, e.g. NOT NULL or CHECK, read the whole table.
+const (
+	migrationOverhead      = 10 * time.Millisecond
+	migrationRowsPerSecond = 200000
+	// Migrations reading more rows than this are reported as long running
+	longMigrationRows = 1000000
+)
+
+type columnMigration struct{ table, column, definition string }
+
+// Columns added since the tables were first created, which CREATE TABLE IF NOT EXISTS leaves out of existing
+// databases. New ones are always appended.
+var columnMigrations = []columnMigration{
+	{"Config", "maxAttachmentSize", "INTEGER DEFAULT 0"},
+	{"Config", "grafanaAuthMode", "TEXT DEFAULT 'basic'"},
+	{"Config", "grafanaProxyHeader", "TEXT DEFAULT ''"},
+	{"Config", "grafanaProxyUser", "TEXT DEFAULT ''"},
+	{"Config", "renderConcurrency", "INTEGER DEFAULT 0"},
+	{"Config", "renderTimeout", "INTEGER DEFAULT 0"},
+	{"Config", "adminEmail", "TEXT DEFAULT ''"},
+	{"Config", "emailTimeout", "INTEGER DEFAULT 0"},
+	{"Config", "reportTimeout", "INTEGER DEFAULT 0"},
+	{"Config", "messageUnitCost", "REAL DEFAULT 0"},
+	{"Config", "costCurrency", "TEXT DEFAULT ''"},
+	{"Config", "defaultRenderWidth", "INTEGER DEFAULT 0"},
+	{"Config", "defaultRenderHeight", "INTEGER DEFAULT 0"},
+	{"Config", "defaultRenderScale", "REAL DEFAULT 0"},
+	{"Config", "defaultRenderTheme", "TEXT DEFAULT ''"},
+	{"Config", "defaultLocale", "TEXT DEFAULT ''"},
+	{"Config", "defaultMaxRetries", "INTEGER DEFAULT 0"},
+	{"Config", "readOnly", "INTEGER DEFAULT 0"},
+	{"Config", "backupDirectory", "TEXT DEFAULT ''"},
+	{"Config", "backupInterval", "INTEGER DEFAULT 0"},
+	{"Config", "backupRetention", "INTEGER DEFAULT 0"},
+	{"Config", "defaultCatchUp", "TEXT DEFAULT ''"},
+	{"ReportRun", "failedPanels", "TEXT DEFAULT ''"},
+	{"ReportRun", "scheduledAt", "INTEGER DEFAULT 0"},
+	{"ReportRun", "messagesSent", "INTEGER DEFAULT 0"},
+	{"ReportRun", "estimatedCost", "REAL DEFAULT 0"},
+	{"ReportContent", "panelTitle", "TEXT DEFAULT ''"},
+	{"ReportContent", "panelType", "TEXT DEFAULT ''"},
+	{"ReportContent", "contentType", "TEXT DEFAULT 'panel'"},
+	{"ReportContent", "renderWidth", "INTEGER DEFAULT 0"},
+	{"ReportContent", "renderHeight", "INTEGER DEFAULT 0"},
+	{"ReportContent", "renderScale", "REAL DEFAULT 0"},
+	{"ReportContent", "renderTheme", "TEXT DEFAULT ''"},
+	{"Schedule", "renderWidth", "INTEGER DEFAULT 0"},
+	{"Schedule", "renderHeight", "INTEGER DEFAULT 0"},
+	{"Schedule", "renderScale", "REAL DEFAULT 0"},
+	{"Schedule", "renderTheme", "TEXT DEFAULT ''"},
+	{"Schedule", "triggerType", "TEXT DEFAULT 'time'"},
+	{"Schedule", "triggerQuery", "TEXT DEFAULT ''"},
+	{"Schedule", "triggerValue", "TEXT DEFAULT ''"},
+	{"Schedule", "sloTarget", "REAL DEFAULT 0"},
+	{"Schedule", "sloWindow", "INTEGER DEFAULT 0"},
+	{"Schedule", "locale", "TEXT DEFAULT ''"},
+	{"Schedule", "maxRetries", "INTEGER DEFAULT 0"},
+	{"Schedule", "owner", "TEXT DEFAULT ''"},
+	{"ReportContent", "chartType", "TEXT DEFAULT ''"},
+	{"Schedule", "formats", "TEXT DEFAULT ''"},
+	{"Schedule", "catchUp", "TEXT DEFAULT ''"},
+	{"Schedule", "blackoutPolicy", "TEXT DEFAULT ''"},
+	{"Schedule", "every", "INTEGER DEFAULT 0"},
+	{"Schedule", "anchorDate", "TEXT DEFAULT ''"},
+	{"ReportContent", "lookbackType", "TEXT DEFAULT ''"},
+	{"Schedule", "timezone", "TEXT DEFAULT ''"},
+	{"ReportGroup", "tags", "TEXT DEFAULT ''"},
+	{"Config", "verifyEmailDomains", "INTEGER DEFAULT 0"},
+	{"Config", "bounceMailbox", "TEXT DEFAULT ''"},
+	{"Config", "renderer", "TEXT DEFAULT ''"},
+	{"Config", "rendererURL", "TEXT DEFAULT ''"},
+	{"Config", "rendererToken", "TEXT DEFAULT ''"},
+	{"Config", "chromePath", "TEXT DEFAULT ''"},
+	{"Config", "emailRateLimit", "INTEGER DEFAULT 0"},
+	{"Config", "emailBatchSize", "INTEGER DEFAULT 0"},
+	{"Config", "emailFromName", "TEXT DEFAULT ''"},
+	{"Config", "emailReplyTo", "TEXT DEFAULT ''"},
+	{"Config", "emailHeaders", "TEXT DEFAULT ''"},
+	{"Schedule", "fromName", "TEXT DEFAULT ''"},
+	{"Schedule", "replyTo", "TEXT DEFAULT ''"},
+	{"Schedule", "emailHeaders", "TEXT DEFAULT ''"},
+	{"Schedule", "emailProfileID", "TEXT DEFAULT ''"},
+	{"Schedule", "ownerTeam", "TEXT DEFAULT ''"},
+	{"ReportGroup", "ownerTeam", "TEXT DEFAULT ''"},
+	{"ReportContent", "datasourceID", "INTEGER DEFAULT 0"},
+	{"Schedule", "playlistUID", "TEXT DEFAULT ''"},
+	{"Schedule", "priority", "TEXT DEFAULT ''"},
+	{"Schedule", "disabledAt", "INTEGER DEFAULT 0"},
+	{"Schedule", "disabledReason", "TEXT DEFAULT ''"},
+	{"ReportRun", "permanent", "INTEGER DEFAULT 0"},
+	{"Config", "reportPartSheets", "INTEGER DEFAULT 0"},
+	{"Config", "reportPartSize", "INTEGER DEFAULT 0"},
+	{"Config", "reportPartDelivery", "TEXT DEFAULT ''"},
+	{"Schedule", "deletedAt", "INTEGER DEFAULT 0"},
+	{"Schedule", "deletedBy", "TEXT DEFAULT ''"},
+	{"ReportGroup", "deletedAt", "INTEGER DEFAULT 0"},
+	{"ReportGroup", "deletedBy", "TEXT DEFAULT ''"},
+	{"Config", "trashRetention", "INTEGER DEFAULT 0"},
+	{"Config", "reuseUnchangedImages", "INTEGER DEFAULT 0"},
+	{"Config", "syslogAddress", "TEXT DEFAULT ''"},
+	{"Config", "syslogProtocol", "TEXT DEFAULT ''"},
+	{"Config", "maxConcurrentReports", "INTEGER DEFAULT 0"},
+	{"Config", "spreadWindow", "INTEGER DEFAULT 0"},
+	{"Config", "disableAfterFailures", "INTEGER DEFAULT 0"},
+	{"ReportContent", "queryTimeout", "INTEGER DEFAULT 0"},
+	{"Config", "maxQueryTimeout", "INTEGER DEFAULT 0"},
+	{"Config", "variablePolicy", "TEXT DEFAULT ''"},
+	{"ReportRun", "variableIssues", "TEXT DEFAULT ''"},
+	{"Schedule", "templateID", "TEXT DEFAULT ''"},
+	{"Schedule", "templateVersion", "INTEGER DEFAULT 0"},
+	{"Schedule", "conditionQuery", "TEXT DEFAULT ''"},
+	{"Schedule", "conditionDashboardID", "TEXT DEFAULT ''"},
+	{"Schedule", "conditionPanelID", "INTEGER DEFAULT 0"},
+	{"Schedule", "conditionOperator", "TEXT DEFAULT ''"},
+	{"Schedule", "conditionThreshold", "REAL DEFAULT 0"},
+	{"Job", "stage", "TEXT DEFAULT ''"},
+	{"Job", "panelsTotal", "INTEGER DEFAULT 0"},
+	{"Job", "panelsRendered", "INTEGER DEFAULT 0"},
+	{"Job", "panelsFailed", "INTEGER DEFAULT 0"},
+	{"Job", "emailsTotal", "INTEGER DEFAULT 0"},
+	{"Job", "emailsSent", "INTEGER DEFAULT 0"},
+	{"Job", "emailsFailed", "INTEGER DEFAULT 0"},
+	{"ReportContent", "sectionTitle", "TEXT DEFAULT ''"},
+	{"ReportContent", "position", "INTEGER DEFAULT 0"},
+	{"ReportContent", "contentText", "TEXT DEFAULT ''"},
+	{"ReportGroup", "memberQuery", "TEXT DEFAULT ''"},
+	{"ReportGroup", "encryptAttachments", "INTEGER DEFAULT 0"},
+	{"ReportGroup", "attachmentPassword", "TEXT DEFAULT ''"},
+	{"Schedule", "trackAcknowledgement", "INTEGER DEFAULT 0"},
+	{"Config", "signingCertificate", "TEXT DEFAULT ''"},
+	{"Config", "signingKey", "TEXT DEFAULT ''"},
+	{"ReportTemplate", "owner", "TEXT DEFAULT ''"},
+	{"ReportRun", "test", "INTEGER DEFAULT 0"},
+}
+
+// Definitions SQLite can't add to a table which already exists, or which read every row when they are added
+var (
+	uniqueColumn      = regexp.MustCompile(`(?i)\b(PRIMARY\s+KEY|UNIQUE)\b`)
+	notNullColumn     = regexp.MustCompile(`(?i)\bNOT\s+NULL\b`)
+	columnDefault     = regexp.MustCompile(`(?i)\bDEFAULT\s+(\S+)`)
+	variableDefault   = regexp.MustCompile(`(?i)^(\(|CURRENT_)`)
+	checkedColumn     = regexp.MustCompile(`(?i)\b(CHECK|REFERENCES)\b`)
+	generatedColumn   = regexp.MustCompile(`(?i)\b(GENERATED|AS\s*\()`)
+	nullDefaultColumn = regexp.MustCompile(`(?i)\bDEFAULT\s+NULL\b`)
+)
+
+// PendingMigration is a column the database doesn't have yet
+type PendingMigration struct {
+	Table      string `json:"table"`
+	Column     string `json:"column"`
+	Definition string `json:"definition"`
+	// Rows in the table, which only matter to the time taken when ScansRows is set
+	Rows      int64 `json:"rows"`
+	ScansRows bool  `json:"scansRows"`
+	// Why the column can't be added, empty when it can
+	Problem          string  `json:"problem,omitempty"`
+	EstimatedSeconds float64 `json:"estimatedSeconds"`
+}
+
+// UpgradePlan is what upgrading a database to this version of the plugin involves, checked before anything changes
+type UpgradePlan struct {
+	Pending []PendingMigration `json:"pending"`
+	// Whether every pending migration can be made, when not the problems are listed
+	Compatible bool     `json:"compatible"`
+	Problems   []string `json:"problems"`
+	// Migrations which read a table big enough to keep the plugin from starting for a while
+	LongRunning      []string `json:"longRunning"`
+	EstimatedSeconds float64  `json:"estimatedSeconds"`
+}
+
+// UpgradeProgress is how far the last upgrade of a database has got
+type UpgradeProgress struct {
+	Running    bool   `json:"running"`
+	Done       int    `json:"done"`
+	Total      int    `json:"total"`
+	Current    string `json:"current"`
+	StartedAt  int    `json:"startedAt"`
+	FinishedAt int    `json:"finishedAt"`
+	Error      string `json:"error"`
+}
+
+var (
+	upgradeMutex sync.Mutex
+	upgrades     = make(map[string]UpgradeProgress)
+)
+
+// UpgradeProgress is how far the upgrade of the database has got since the plugin started
+func (datasource *SQLiteDatasource) UpgradeProgress() UpgradeProgress {
+	upgradeMutex.Lock()
+	defer upgradeMutex.Unlock()
+
+	return upgrades[datasource.Path]
+}
+
+func (datasource *SQLiteDatasource) setUpgradeProgress(update func(progress *UpgradeProgress)) {
+	upgradeMutex.Lock()
+	defer upgradeMutex.Unlock()
+
+	progress := upgrades[datasource.Path]
+	update(&progress)
+	upgrades[datasource.Path] = progress
+}
+
+// PlanUpgrade checks the database at a path, e.g. a backup, against the migrations of this version of the plugin
+// without changing it
+func PlanUpgrade(path string) (*UpgradePlan, error) {
+	if _, err := os.Stat(path); err != nil {
+		log.DefaultLogger.Error("PlanUpgrade: os.Stat(): ", err.Error())
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("PlanUpgrade: sql.Open(): ", err.Error())
+		return nil, err
+	}
+
+	plan, err := planUpgrade(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only checked here, as reading the whole database each time the plugin starts would slow it down
+	var integrity string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&integrity); err != nil {
+		log.DefaultLogger.Error("PlanUpgrade: quick_check: ", err.Error())
+		return nil, err
+	}
+	if integrity != "ok" {
+		plan.Problems = append(plan.Problems, "the database is damaged: "+integrity)
+		plan.Compatible = false
+	}
+
+	return plan, nil
+}
+
+// PlanUpgrade checks the datasource's own database, which is only behind when another version shares it
+func (datasource *SQLiteDatasource) PlanUpgrade() (*UpgradePlan, error) {
+	return PlanUpgrade(datasource.Path)
+}
+
+func planUpgrade(db *sql.DB) (*UpgradePlan, error) {
+	plan := &UpgradePlan{Pending: []PendingMigration{}, Problems: []string{}, LongRunning: []string{}}
+
+	columns := make(map[string]map[string]bool)
+	rows := make(map[string]int64)
+	for _, migration := range columnMigrations {
+		if _, ok := columns[migration.table]; !ok {
+			existing, err := tableColumns(db, migration.table)
+			if err != nil {
+				return nil, err
+			}
+			columns[migration.table] = existing
+
+			// Tables which don't exist yet are created empty
+			if len(existing) > 0 {
+				var count int64
+				if err := db.QueryRow("SELECT COUNT(*) FROM " + migration.table).Scan(&count); err != nil {
+					log.DefaultLogger.Error("planUpgrade: db.QueryRow(): ", err.Error())
+					return nil, err
+				}
+				rows[migration.table] = count
+			}
+		}
+		if columns[migration.table][strings.ToLower(migration.column)] {
+			continue
+		}
+
+		pending := PendingMigration{Table: migration.table, Column: migration.column, Definition: migration.definition, Rows: rows[migration.table]}
+		pending.ScansRows, pending.Problem = checkColumnDefinition(migration.definition)
+
+		estimate := migrationOverhead
+		if pending.ScansRows {
+			estimate += time.Duration(float64(pending.Rows) / migrationRowsPerSecond * float64(time.Second))
+			if pending.Rows > longMigrationRows {
+				plan.LongRunning = append(plan.LongRunning, fmt.Sprintf("%s.%s reads all %d rows of %s", migration.table, migration.column, pending.Rows, migration.table))
+			}
+		}
+		pending.EstimatedSeconds = estimate.Seconds()
+		plan.EstimatedSeconds += pending.EstimatedSeconds
+
+		if pending.Problem != "" {
+			plan.Problems = append(plan.Problems, migration.table+"."+migration.column+": "+pending.Problem)
+		}
+		plan.Pending = append(plan.Pending, pending)
+	}
+
+	plan.Compatible = len(plan.Problems) == 0
+	return plan, nil
+}
+
+// checkColumnDefinition is whether adding a column reads the table's rows, and why SQLite would refuse to add it
+func checkColumnDefinition(definition string) (bool, string) {
+	if uniqueColumn.MatchString(definition) {
+		return false, "a PRIMARY KEY or UNIQUE column can't be added to an existing table"
+	}
+	if generatedColumn.MatchString(definition) {
+		return false, "a generated column can't be added to an existing table"
+	}
+
+	match := columnDefault.FindStringSubmatch(definition)
+	if match != nil && variableDefault.MatchString(match[1]) {
+		return false, "the default " + match[1] + " isn't constant, which can't be added to an existing table"
+	}
+	notNull := notNullColumn.MatchString(definition)
+	if notNull && (match == nil || nullDefaultColumn.MatchString(definition)) {
+		return false, "a NOT NULL column needs a default for the existing rows"
+	}
+
+	return notNull || checkedColumn.MatchString(definition), ""
+}
+
+// tableColumns are the lower-cased names of a table's columns, none when it doesn't exist
+func tableColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		log.DefaultLogger.Error("tableColumns: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue interface{}
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey)
+		if err != nil {
+			log.DefaultLogger.Error("tableColumns: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = true
+	}
+
+	return columns, nil
+}
+
+// migrate adds the columns the database is missing, checking they all can be first so a database this version
+// can't upgrade is left as it was. Progress is logged and kept for the upgrade endpoint as each is added.
+func (datasource *SQLiteDatasource) migrate(db *sql.DB) error {
+	plan, err := planUpgrade(db)
+	if err != nil {
+		return err
+	}
+	if !plan.Compatible {
+		err = errors.New("the database can't be upgraded: " + strings.Join(plan.Problems, "; "))
+		datasource.setUpgradeProgress(func(progress *UpgradeProgress) { progress.Error = err.Error() })
+		return err
+	}
+	if len(plan.Pending) == 0 {
+		return nil
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("Upgrading the database: %d migrations, estimated to take %.1fs", len(plan.Pending), plan.EstimatedSeconds))
+	for _, longRunning := range plan.LongRunning {
+		log.DefaultLogger.Warn("Upgrading the database: " + longRunning + ", which may take a while")
+	}
+
+	started := time.Now()
+	datasource.setUpgradeProgress(func(progress *UpgradeProgress) {
+		*progress = UpgradeProgress{Running: true, Total: len(plan.Pending), StartedAt: int(started.Unix())}
+	})
+
+	for i, pending := range plan.Pending {
+		name := pending.Table + "." + pending.Column
+		datasource.setUpgradeProgress(func(progress *UpgradeProgress) { progress.Current = name })
+		log.DefaultLogger.Info(fmt.Sprintf("Upgrading the database: %d/%d %s (%d rows)", i+1, len(plan.Pending), name, pending.Rows))
+
+		err = addColumn(db, pending.Table, pending.Column, pending.Definition)
+		if err != nil {
+			datasource.setUpgradeProgress(func(progress *UpgradeProgress) {
+				progress.Running = false
+				progress.FinishedAt = int(time.Now().Unix())
+				progress.Error = name + ": " + err.Error()
+			})
+			return err
+		}
+		datasource.setUpgradeProgress(func(progress *UpgradeProgress) { progress.Done = i + 1 })
+	}
+
+	datasource.setUpgradeProgress(func(progress *UpgradeProgress) {
+		progress.Running = false
+		progress.Current = ""
+		progress.FinishedAt = int(time.Now().Unix())
+	})
+	log.DefaultLogger.Info(fmt.Sprintf("Upgraded the database in %.1fs", time.Since(started).Seconds()))
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package dbstore
+
+import (
+	"database/sql"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// ScheduleUsage is a single schedule and the content items of that
+// schedule which reference a given dashboard.
+type ScheduleUsage struct {
+	Schedule      Schedule        `json:"schedule"`
+	ReportContent []ReportContent `json:"reportContent"`
+}
+
+// DashboardUsage lists every schedule which depends on a dashboard, used
+// to check the impact of changing a dashboard before editing it.
+type DashboardUsage struct {
+	DashboardID string          `json:"dashboardID"`
+	PanelIDs    []int           `json:"panelIDs"`
+	Schedules   []ScheduleUsage `json:"schedules"`
+}
+
+// GetDashboardUsage looks up the dashboard in the ReportContent reverse index
+// (ReportContentDashboardIndex) and groups the matching content by schedule.
+func (datasource *SQLiteDatasource) GetDashboardUsage(dashboardID string) (*DashboardUsage, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardUsage: sql.Open: ", err.Error())
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT "+reportContentColumns+" FROM ReportContent WHERE dashboardID = ? ORDER BY scheduleID", dashboardID)
+	if err != nil {
+		log.DefaultLogger.Error("GetDashboardUsage: db.Query(): ", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := DashboardUsage{DashboardID: dashboardID, PanelIDs: []int{}, Schedules: []ScheduleUsage{}}
+	contentBySchedule := make(map[string][]ReportContent)
+	var scheduleIDs []string
+	seenPanels := make(map[int]bool)
+
+	for rows.Next() {
+		content, err := scanReportContent(rows)
+		if err != nil {
+			log.DefaultLogger.Error("GetDashboardUsage: rows.Scan(): ", err.Error())
+			return nil, err
+		}
+
+		if _, ok := contentBySchedule[content.ScheduleID]; !ok {
+			scheduleIDs = append(scheduleIDs, content.ScheduleID)
+		}
+		contentBySchedule[content.ScheduleID] = append(contentBySchedule[content.ScheduleID], *content)
+
+		if !seenPanels[content.PanelID] {
+			seenPanels[content.PanelID] = true
+			usage.PanelIDs = append(usage.PanelIDs, content.PanelID)
+		}
+	}
+
+	for _, scheduleID := range scheduleIDs {
+		schedule, err := datasource.GetSchedule(scheduleID)
+		if err != nil {
+			// Content left behind by a deleted schedule doesn't make the dashboard in use
+			log.DefaultLogger.Warn("GetDashboardUsage: GetSchedule(): " + scheduleID + ": " + err.Error())
+			continue
+		}
+
+		usage.Schedules = append(usage.Schedules, ScheduleUsage{Schedule: *schedule, ReportContent: contentBySchedule[scheduleID]})
+	}
+
+	return &usage, nil
+}
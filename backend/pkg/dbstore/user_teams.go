@@ -0,0 +1,59 @@
+package dbstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How long the Grafana teams a user is in are cached for, so permission checks don't ask Grafana on every request
+const UserTeamsMaxAge = 5 * time.Minute
+
+// CachedUserTeams returns the teams last fetched for a user, and whether they were fetched recently enough to use.
+// Users who have never been looked up have no teams and aren't fresh.
+func (datasource *SQLiteDatasource) CachedUserTeams(login string, now time.Time) ([]string, bool, error) {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CachedUserTeams: sql.Open(): ", err.Error())
+		return nil, false, err
+	}
+
+	var teamsJSON string
+	var fetchedAt int
+	err = db.QueryRow("SELECT teams, fetchedAt FROM UserTeams WHERE login = ?", login).Scan(&teamsJSON, &fetchedAt)
+	if err == sql.ErrNoRows {
+		return []string{}, false, nil
+	}
+	if err != nil {
+		log.DefaultLogger.Error("CachedUserTeams: db.QueryRow(): ", err.Error())
+		return nil, false, err
+	}
+
+	teams := []string{}
+	json.Unmarshal([]byte(teamsJSON), &teams)
+
+	fresh := now.Sub(time.Unix(int64(fetchedAt), 0)) < UserTeamsMaxAge
+	return teams, fresh, nil
+}
+
+// CacheUserTeams stores the teams just fetched for a user from Grafana
+func (datasource *SQLiteDatasource) CacheUserTeams(login string, teams []string, now time.Time) error {
+	db, err := sql.Open("sqlite3", datasource.Path)
+	defer db.Close()
+	if err != nil {
+		log.DefaultLogger.Error("CacheUserTeams: sql.Open(): ", err.Error())
+		return err
+	}
+
+	teamsJSON, _ := json.Marshal(teams)
+	_, err = db.Exec("INSERT OR REPLACE INTO UserTeams (login, teams, fetchedAt) VALUES (?,?,?)", login, string(teamsJSON), now.Unix())
+	if err != nil {
+		log.DefaultLogger.Error("CacheUserTeams: db.Exec(): ", err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package dbstore
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ContentVariables are the values a content item sets its dashboard's template variables to, by variable name.
+// Variables it doesn't set keep the values the dashboard was saved with.
+type ContentVariables map[string][]string
+
+var errContentVariables = errors.New(`variables must be a JSON object of lists of values, e.g. {"store": ["Central"]}`)
+
+// ParseContentVariables reads the variables of a content item, which are stored as a JSON object of lists of values
+func ParseContentVariables(variables string) (ContentVariables, error) {
+	parsed := ContentVariables{}
+	if strings.TrimSpace(variables) == "" {
+		return parsed, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(variables))
+	if err := decoder.Decode(&parsed); err != nil || decoder.More() {
+		return nil, errContentVariables
+	}
+
+	for name := range parsed {
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("variables can't have an empty name")
+		}
+	}
+
+	return parsed, nil
+}
+
+// String is how the variables are stored: compact JSON with the names sorted, leaving out variables without
+// values, or empty when none are set
+func (variables ContentVariables) String() string {
+	set := make(map[string][]string)
+	for name, values := range variables {
+		if len(values) > 0 {
+			set[name] = values
+		}
+	}
+	if len(set) == 0 {
+		return ""
+	}
+
+	bytes, err := json.Marshal(set)
+	if err != nil {
+		return ""
+	}
+	return string(bytes)
+}
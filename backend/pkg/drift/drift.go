@@ -0,0 +1,210 @@
+package drift
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+const (
+	StatusDashboardMissing = "dashboardMissing"
+	StatusPanelMissing     = "panelMissing"
+	StatusPanelChanged     = "panelChanged"
+)
+
+// Only suggestions at least this similar are offered
+const minimumScore = 0.3
+
+type Suggestion struct {
+	DashboardID string  `json:"dashboardID"`
+	PanelID     int     `json:"panelID"`
+	Title       string  `json:"title"`
+	Type        string  `json:"type"`
+	Score       float64 `json:"score"`
+}
+
+// Drift is a content item whose panel no longer matches what was added to the schedule
+type Drift struct {
+	ReportContent dbstore.ReportContent `json:"reportContent"`
+	Status        string                `json:"status"`
+	CurrentTitle  string                `json:"currentTitle"`
+	Suggestion    *Suggestion           `json:"suggestion"`
+}
+
+// Detector caches dashboards so each one is only fetched once per check
+type Detector struct {
+	ctx        context.Context
+	authConfig *auth.AuthConfig
+	dashboards map[string][]api.PanelSummary
+}
+
+func NewDetector(ctx context.Context, authConfig *auth.AuthConfig) *Detector {
+	return &Detector{ctx: ctx, authConfig: authConfig, dashboards: make(map[string][]api.PanelSummary)}
+}
+
+func (d *Detector) panels(dashboardID string) ([]api.PanelSummary, error) {
+	if panels, ok := d.dashboards[dashboardID]; ok {
+		return panels, nil
+	}
+
+	panels, err := api.GetDashboardPanels(d.ctx, d.authConfig, dashboardID)
+	if err != nil && err != api.ErrDashboardNotFound {
+		log.DefaultLogger.Error("Detector.panels: GetDashboardPanels: " + err.Error())
+		return nil, err
+	}
+
+	// A missing dashboard is cached as nil so it isn't requested again
+	d.dashboards[dashboardID] = panels
+	return panels, nil
+}
+
+// Detect returns the drift of each content item which no longer matches its dashboard
+func (d *Detector) Detect(contents []dbstore.ReportContent) ([]Drift, error) {
+	drifts := []Drift{}
+	for _, content := range contents {
+		if content.IsText() {
+			continue
+		}
+
+		panels, err := d.panels(content.DashboardID)
+		if err != nil {
+			return nil, err
+		}
+
+		if panels == nil {
+			drifts = append(drifts, Drift{ReportContent: content, Status: StatusDashboardMissing})
+			continue
+		}
+
+		if content.Type == dbstore.ReportContentTypeDashboard {
+			continue
+		}
+
+		var current *api.PanelSummary
+		for i, panel := range panels {
+			if panel.ID == content.PanelID {
+				current = &panels[i]
+			}
+		}
+
+		drift := Drift{ReportContent: content}
+		if current == nil {
+			drift.Status = StatusPanelMissing
+		} else if content.PanelTitle != "" && current.Title != content.PanelTitle {
+			drift.Status = StatusPanelChanged
+			drift.CurrentTitle = current.Title
+		} else {
+			continue
+		}
+
+		if suggestions := Suggest(content, content.DashboardID, panels); len(suggestions) > 0 {
+			drift.Suggestion = &suggestions[0]
+		}
+		drifts = append(drifts, drift)
+	}
+
+	return drifts, nil
+}
+
+// Suggestions ranks the panels of a dashboard by how similar they are to the content's original panel
+func (d *Detector) Suggestions(content dbstore.ReportContent, dashboardID string) ([]Suggestion, error) {
+	panels, err := d.panels(dashboardID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Suggest(content, dashboardID, panels), nil
+}
+
+// Panel returns the panel of a dashboard, or nil if it doesn't exist
+func (d *Detector) Panel(dashboardID string, panelID int) (*api.PanelSummary, error) {
+	panels, err := d.panels(dashboardID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, panel := range panels {
+		if panel.ID == panelID {
+			return &panels[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func Suggest(content dbstore.ReportContent, dashboardID string, panels []api.PanelSummary) []Suggestion {
+	suggestions := []Suggestion{}
+	for _, panel := range panels {
+		score := similarity(content.PanelTitle, panel.Title)
+		if content.PanelType != "" && content.PanelType == panel.Type {
+			score += 0.2
+		}
+		if score > 1 {
+			score = 1
+		}
+
+		if score >= minimumScore {
+			suggestions = append(suggestions, Suggestion{DashboardID: dashboardID, PanelID: panel.ID, Title: panel.Title, Type: panel.Type, Score: score})
+		}
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Score > suggestions[j].Score
+	})
+
+	return suggestions
+}
+
+// similarity is 1 - the normalised edit distance between two titles, ignoring case
+func similarity(a string, b string) float64 {
+	a = strings.ToLower(strings.TrimSpace(a))
+	b = strings.ToLower(strings.TrimSpace(b))
+	if a == "" || b == "" {
+		return 0
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
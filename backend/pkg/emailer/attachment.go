@@ -0,0 +1,154 @@
+package emailer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// How a report ended up being delivered, recorded in the run history
+const (
+	AttachmentModeAttached  = "attached"
+	AttachmentModeZipped    = "zipped"
+	AttachmentModeEncrypted = "encrypted"
+	AttachmentModeLink      = "link"
+)
+
+type Attachment struct {
+	Path string
+	Mode string
+	Link string
+	// Name of the file the link was copied from, which it is shown as
+	Name string
+}
+
+// PrepareAttachment makes sure the report fits under the mail server's size limit.
+// Reports which are too large are zipped, and if they are still too large they are
+// shared and a download link is sent in their place. Emailers which link attachments
+// send every file as a link, and those with an attachment password send every file as an encrypted zip.
+func (e *Emailer) PrepareAttachment(attachmentPath string) (*Attachment, error) {
+	info, err := os.Stat(attachmentPath)
+	if err != nil {
+		log.DefaultLogger.Error("PrepareAttachment: os.Stat: " + err.Error())
+		return nil, err
+	}
+
+	if e.attachmentPassword != "" {
+		return e.encryptAttachment(attachmentPath)
+	}
+
+	if e.linkAttachments {
+		return e.linkAttachment(attachmentPath)
+	}
+
+	if e.maxAttachmentSize <= 0 || info.Size() <= e.maxAttachmentSize {
+		return &Attachment{Path: attachmentPath, Mode: AttachmentModeAttached}, nil
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("%s is %d bytes which is over the limit of %d, zipping...", attachmentPath, info.Size(), e.maxAttachmentSize))
+	// Keeps the extension, so a report sent in several formats gets a zip per format
+	zipPath := attachmentPath + ".zip"
+	if err := zipFile(attachmentPath, zipPath, ""); err != nil {
+		log.DefaultLogger.Error("PrepareAttachment: zipFile: " + err.Error())
+		return nil, err
+	}
+
+	zipInfo, err := os.Stat(zipPath)
+	if err != nil {
+		log.DefaultLogger.Error("PrepareAttachment: os.Stat: " + err.Error())
+		return nil, err
+	}
+
+	if zipInfo.Size() <= e.maxAttachmentSize {
+		return &Attachment{Path: zipPath, Mode: AttachmentModeZipped}, nil
+	}
+	os.Remove(zipPath)
+
+	log.DefaultLogger.Info(fmt.Sprintf("%s is still over the limit when zipped, sending a download link instead", attachmentPath))
+	return e.linkAttachment(attachmentPath)
+}
+
+// encryptAttachment zips the report with the attachment password. When the encrypted zip is too large to attach,
+// or attachments are linked, the link is to the encrypted zip so the report is never downloadable unencrypted. The
+// report itself is removed once it's zipped, so it isn't left in the data directory for downloads or share links.
+func (e *Emailer) encryptAttachment(attachmentPath string) (*Attachment, error) {
+	zipPath := attachmentPath + ".zip"
+	if err := zipFile(attachmentPath, zipPath, e.attachmentPassword); err != nil {
+		log.DefaultLogger.Error("encryptAttachment: zipFile: " + err.Error())
+		os.Remove(zipPath)
+		return nil, err
+	}
+	if err := os.Remove(attachmentPath); err != nil {
+		log.DefaultLogger.Error("encryptAttachment: os.Remove: " + err.Error())
+		os.Remove(zipPath)
+		return nil, err
+	}
+
+	zipInfo, err := os.Stat(zipPath)
+	if err != nil {
+		log.DefaultLogger.Error("encryptAttachment: os.Stat: " + err.Error())
+		return nil, err
+	}
+
+	if e.linkAttachments || (e.maxAttachmentSize > 0 && zipInfo.Size() > e.maxAttachmentSize) {
+		return e.linkAttachment(zipPath)
+	}
+	return &Attachment{Path: zipPath, Mode: AttachmentModeEncrypted}, nil
+}
+
+// linkAttachment shares a copy of the file for the download link sent in its place, as the report is written over
+// by the next run. The copy is removed once the link expires.
+func (e *Emailer) linkAttachment(attachmentPath string) (*Attachment, error) {
+	if e.share == nil {
+		err := errors.New("there is no way to share " + filepath.Base(attachmentPath) + " to link to it")
+		log.DefaultLogger.Error("linkAttachment: " + err.Error())
+		return nil, err
+	}
+
+	id, signature, err := e.share(attachmentPath)
+	if err != nil {
+		log.DefaultLogger.Error("linkAttachment: share: " + err.Error())
+		return nil, err
+	}
+
+	link := e.SharedLink(e.orgID, id, signature)
+	return &Attachment{Path: attachmentPath, Mode: AttachmentModeLink, Link: link, Name: filepath.Base(attachmentPath)}, nil
+}
+
+// zipFile deflates the file into a zip of its own, encrypted when there's a password
+func zipFile(sourcePath string, zipPath string, password string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	archive := zip.NewWriter(target)
+	header := &zip.FileHeader{Name: filepath.Base(sourcePath), Method: zip.Deflate, Modified: time.Now()}
+	if password != "" {
+		archive.RegisterCompressor(aesMethod, aesCompressor(password))
+		encryptHeader(header)
+	}
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, source); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
@@ -1,49 +1,352 @@
 package emailer
 
 import (
+	"context"
 	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"github.com/grafana/simple-datasource-backend/pkg/progress"
 	"gopkg.in/gomail.v2"
 )
 
 type Emailer struct {
-	email    string
-	password string
-	host     string
-	port     int
+	email             string
+	password          string
+	host              string
+	port              int
+	maxAttachmentSize int64
+	sharedURL         string
+	unsubscribeURL    string
+	acknowledgeURL    string
+	runHistoryURL     string
+	timeout           time.Duration
+	rateLimit         int
+	batchSize         int
+	fromName          string
+	replyTo           string
+	headers           []dbstore.EmailHeader
+	// Sends a download link to every file rather than attaching them, for reports sent in parts in one email
+	linkAttachments bool
+	// Encrypts every file into a zip with the password, for report groups whose reports mustn't travel unencrypted
+	attachmentPassword string
+	// What the emails' own text, e.g. the unsubscribe link, is written in
+	localizer i18n.Localizer
+	// Signs every email with S/MIME, nil when they aren't signed
+	signing *dbstore.SigningIdentity
+	// Shares the files sent as links, nil when they can't be, and the organisation whose database the links are in
+	share Sharer
+	orgID int64
 }
 
+// Sharer shares a copy of a file until it expires, returning the share link's ID and signature
+type Sharer func(path string) (string, string, error)
+
 func New(config *auth.EmailConfig) *Emailer {
-	return &Emailer{email: config.Email, password: config.Password, host: config.Host, port: config.Port}
+	return &Emailer{email: config.Email, password: config.Password, host: config.Host, port: config.Port, maxAttachmentSize: config.MaxAttachmentSize, sharedURL: config.SharedURL, unsubscribeURL: config.UnsubscribeURL, acknowledgeURL: config.AcknowledgeURL, runHistoryURL: config.RunHistoryURL, timeout: config.Timeout, rateLimit: config.RateLimit, batchSize: config.BatchSize, fromName: config.FromName, replyTo: config.ReplyTo, headers: config.Headers, localizer: i18n.For(i18n.DefaultLocale), signing: signingFor(config)}
+}
+
+// signingFor is the identity the config's emails are signed with. An account the certificate wasn't issued for,
+// e.g. an email profile's, sends unsigned, as mail clients would flag its signature as forged.
+func signingFor(config *auth.EmailConfig) *dbstore.SigningIdentity {
+	if config.Signing == nil {
+		return nil
+	}
+	if err := config.Signing.Check(config.Email, time.Now()); err != nil {
+		log.DefaultLogger.Warn("emailer: not signing emails from " + config.Email + ": " + err.Error())
+		return nil
+	}
+	return config.Signing
+}
+
+// WithSender is a copy of the emailer which sends from the display name, with the Reply-To and headers given,
+// for a schedule's own sender
+func (e *Emailer) WithSender(fromName string, replyTo string, headers []dbstore.EmailHeader) *Emailer {
+	sender := *e
+	sender.fromName = fromName
+	sender.replyTo = replyTo
+	sender.headers = headers
+	return &sender
+}
+
+// WithLinkedAttachments is a copy of the emailer which sends a download link to each of a report's files in
+// place of attaching them, for a report sent in parts in one email
+func (e *Emailer) WithLinkedAttachments() *Emailer {
+	linked := *e
+	linked.linkAttachments = true
+	return &linked
+}
+
+// WithSharer is a copy of the emailer which sends the files it links to as share links of the organisation's, so
+// they can only be downloaded with the link and only until it expires
+func (e *Emailer) WithSharer(orgID int64, share Sharer) *Emailer {
+	sharing := *e
+	sharing.orgID = orgID
+	sharing.share = share
+	return &sharing
+}
+
+// WithAttachmentPassword is a copy of the emailer which sends every file as a zip encrypted with the password.
+// The password is never put in the email, recipients are given it separately.
+func (e *Emailer) WithAttachmentPassword(password string) *Emailer {
+	encrypted := *e
+	encrypted.attachmentPassword = password
+	return &encrypted
+}
+
+// WithLocale is a copy of the emailer which writes the text it adds to reports' emails in the locale, for a
+// schedule's language
+func (e *Emailer) WithLocale(locale string) *Emailer {
+	localized := *e
+	localized.localizer = i18n.For(locale)
+	return &localized
+}
+
+// setSender sets who the email is from, where replies to it go and any custom headers
+func (e *Emailer) setSender(m *gomail.Message) {
+	if e.fromName != "" {
+		m.SetAddressHeader("From", e.email, e.fromName)
+	} else {
+		m.SetHeader("From", e.email)
+	}
+	if e.replyTo != "" {
+		m.SetHeader("Reply-To", e.replyTo)
+	}
+	for _, header := range e.headers {
+		m.SetHeader(header.Name, header.Value)
+	}
+}
+
+// dialAndSend gives up once the context is done or the send timeout has passed, closing the connection so nothing
+// is left waiting on a mail server which stopped answering
+func (e *Emailer) dialAndSend(ctx context.Context, m *gomail.Message) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	if err := chaos.SMTP(); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	sender, err := e.dial(ctx)
+	if err == nil {
+		stop := sender.watch(ctx)
+		err = e.send(sender, m)
+		sender.Close()
+		stop()
+	}
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	metrics.ObserveEmail(started, err)
+	return err
+}
+
+// Ping checks the SMTP server accepts the configured credentials without sending anything
+func (e *Emailer) Ping(ctx context.Context) error {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	sender, err := e.dial(ctx)
+	if err != nil {
+		return err
+	}
+	sender.Close()
+	return nil
+}
+
+// UnsubscribeLink is the link a recipient follows to stop receiving a schedule's report, signed for their address.
+// The organisation says whose database the public link is checked against.
+func (e *Emailer) UnsubscribeLink(orgID int64, scheduleID, email, signature string) string {
+	query := url.Values{}
+	query.Set("org", strconv.FormatInt(orgID, 10))
+	query.Set("schedule-id", scheduleID)
+	query.Set("address", email)
+	query.Set("signature", signature)
+	return e.unsubscribeURL + "?" + query.Encode()
+}
+
+// SharedLink is the link a share link's file is downloaded from, signed so it works until the link expires
+func (e *Emailer) SharedLink(orgID int64, id, signature string) string {
+	query := url.Values{}
+	query.Set("org", strconv.FormatInt(orgID, 10))
+	query.Set("signature", signature)
+	return e.sharedURL + url.PathEscape(id) + "?" + query.Encode()
+}
+
+// AcknowledgeLink is the link a recipient follows to mark a run's report as reviewed, signed for their address
+func (e *Emailer) AcknowledgeLink(orgID int64, runID, email, signature string) string {
+	query := url.Values{}
+	query.Set("org", strconv.FormatInt(orgID, 10))
+	query.Set("run-id", runID)
+	query.Set("address", email)
+	query.Set("signature", signature)
+	return e.acknowledgeURL + "?" + query.Encode()
 }
 
-func (e *Emailer) CreateAndSend(attachmentPath, email, subject, body string) error {
+// RunHistoryLink is the link to a schedule's run history, for the admin or owner to see why it is failing
+func (e *Emailer) RunHistoryLink(scheduleID string) string {
+	query := url.Values{}
+	query.Set("schedule-id", scheduleID)
+	return e.runHistoryURL + "?" + query.Encode()
+}
+
+// CreateAndSend sends the report to one address, with a link to unsubscribe when one is given
+func (e *Emailer) CreateAndSend(ctx context.Context, attachments []*Attachment, email, subject, body, unsubscribeLink string) error {
 	log.DefaultLogger.Info(fmt.Sprintf("Sending email to %s...", email))
+	m := e.newReportMessage(attachments, email, subject, body, unsubscribeLink, "")
+
+	if err := e.dialAndSend(ctx, m); err != nil {
+		log.DefaultLogger.Error("CreateAndSend: DialAndSend: " + err.Error())
+		return err
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("Sent email to %s!", email))
+	return nil
+}
+
+func (e *Emailer) newReportMessage(attachments []*Attachment, email, subject, body, unsubscribeLink, acknowledgeLink string) *gomail.Message {
 	m := gomail.NewMessage()
 
-	m.SetHeader("From", e.email)
+	e.setSender(m)
 	m.SetHeader("To", email)
 	m.SetHeader("Subject", subject)
+	if unsubscribeLink != "" {
+		// Shown by mail clients as their own unsubscribe button
+		m.SetHeader("List-Unsubscribe", "<"+unsubscribeLink+">")
+	}
+
+	for _, attachment := range attachments {
+		if attachment.Mode == AttachmentModeLink && e.linkAttachments {
+			link := fmt.Sprintf("<a href=\"%s\">%s</a>", attachment.Link, html.EscapeString(attachment.Name))
+			body = body + "<p>" + e.localizer.HTML("email.download", link) + "</p>"
+		} else if attachment.Mode == AttachmentModeLink {
+			link := fmt.Sprintf("<a href=\"%s\">%s</a>", attachment.Link, e.localizer.HTML("email.tooLargeLink"))
+			body = body + "<p>" + e.localizer.HTML("email.tooLarge", link) + "</p>"
+		} else {
+			m.Attach(attachment.Path)
+		}
+	}
+	if e.attachmentPassword != "" {
+		body = body + "<p>" + e.localizer.HTML("email.encrypted") + "</p>"
+	}
+	if acknowledgeLink != "" {
+		link := fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(acknowledgeLink), e.localizer.HTML("email.acknowledgeLink"))
+		body = body + "<p>" + e.localizer.HTML("email.acknowledge", link) + "</p>"
+	}
+	if unsubscribeLink != "" {
+		link := fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(unsubscribeLink), e.localizer.HTML("email.unsubscribeLink"))
+		body = body + "<p style=\"font-size: small\">" + e.localizer.HTML("email.unsubscribe", link) + "</p>"
+	}
 	m.SetBody("text/html", body)
 
-	m.Attach(attachmentPath)
-	d := gomail.NewDialer(e.host, e.port, e.email, e.password)
+	return m
+}
 
-	if err := d.DialAndSend(m); err != nil {
-		log.DefaultLogger.Error("CreateAndSend: DialAndSend: " + err.Error())
+// Send sends an email without an attachment, used for notifications
+func (e *Emailer) Send(ctx context.Context, email, subject, body string) error {
+	m := gomail.NewMessage()
+
+	e.setSender(m)
+	m.SetHeader("To", email)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", body)
+
+	if err := e.dialAndSend(ctx, m); err != nil {
+		log.DefaultLogger.Error("Send: DialAndSend: " + err.Error())
 		return err
 	}
 
-	log.DefaultLogger.Info(fmt.Sprintf("Sent email to %s!", email))
 	return nil
 }
 
-func (e *Emailer) BulkCreateAndSend(attachmentPath string, emails []string, subject string, body string) {
+// How far each attachment mode is from attaching the report as is
+var attachmentModeRank = map[string]int{AttachmentModeAttached: 0, AttachmentModeZipped: 1, AttachmentModeEncrypted: 1, AttachmentModeLink: 2}
+
+// ReducedMost is whichever of two attachment modes is further from attaching the report as is
+func ReducedMost(mode string, other string) string {
+	if attachmentModeRank[other] > attachmentModeRank[mode] {
+		return other
+	}
+	return mode
+}
+
+// BulkCreateAndSend sends the report's files to each address and returns how the report was delivered,
+// the mode of the file which had to be reduced the most, the addresses sent to, and the addresses the mail server
+// refused, with an error when it couldn't be sent to any of them. Each address is sent its links from unsubscribeLinks and acknowledgeLinks, if it has them, and delivered is called with each
+// address as soon as it has been sent, when it isn't nil. Emails are paced to the rate limit
+// and sent in batches over one connection, and when the mail server throttles them they are held back and tried again.
+func (e *Emailer) BulkCreateAndSend(ctx context.Context, attachmentPaths []string, emails []string, subject string, body string, unsubscribeLinks map[string]string, acknowledgeLinks map[string]string, delivered func(email string)) (string, []string, []dbstore.DeliveryFailure, error) {
+	var attachments []*Attachment
+	mode := AttachmentModeAttached
+	for _, attachmentPath := range attachmentPaths {
+		attachment, err := e.PrepareAttachment(attachmentPath)
+		if err != nil {
+			log.DefaultLogger.Error("BulkCreateAndSend: PrepareAttachment: " + err.Error())
+			return "", nil, nil, err
+		}
+		attachments = append(attachments, attachment)
+		mode = ReducedMost(mode, attachment.Mode)
+	}
+
+	metrics.EmailQueueDepth.Add(float64(len(emails)))
+	queued := len(emails)
+	defer func() { metrics.EmailQueueDepth.Sub(float64(queued)) }()
+
+	tracker := progress.From(ctx)
+	tracker.Emails(len(emails))
+
+	pacer := e.pacer()
+	batch := e.newBatch()
+	defer batch.close()
+
+	sent := []string{}
+	var failures []dbstore.DeliveryFailure
+	var lastErr error
 	for _, email := range emails {
-		if err := e.CreateAndSend(attachmentPath, email, subject, body); err != nil {
-			log.DefaultLogger.Error("BulkCreateAndSend: Could not send to: " + email)
+		if err := ctx.Err(); err != nil {
+			log.DefaultLogger.Error("BulkCreateAndSend: stopped before sending to: " + email + ": " + err.Error())
+			return mode, sent, failures, err
 		}
+
+		log.DefaultLogger.Info(fmt.Sprintf("Sending email to %s...", email))
+		m := e.newReportMessage(attachments, email, subject, body, unsubscribeLinks[email], acknowledgeLinks[email])
+		err := e.sendPaced(ctx, pacer, batch, m, email)
+		queued--
+		metrics.EmailQueueDepth.Dec()
+		tracker.EmailDone(err)
+		if err != nil {
+			log.DefaultLogger.Error("BulkCreateAndSend: Could not send to: " + email + ": " + err.Error())
+			lastErr = err
+			if failure := ParseRejection(email, err); failure != nil {
+				failures = append(failures, *failure)
+			}
+			continue
+		}
+		log.DefaultLogger.Info(fmt.Sprintf("Sent email to %s!", email))
+		sent = append(sent, email)
+		if delivered != nil {
+			delivered(email)
+		}
+	}
+
+	// A report which reached nobody wasn't sent, however many addresses it was tried at
+	if len(sent) == 0 && lastErr != nil {
+		return mode, sent, failures, fmt.Errorf("could not send to any of the %d recipients: %w", len(emails), lastErr)
 	}
+	return mode, sent, failures, nil
 }
@@ -0,0 +1,176 @@
+package emailer
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+// Encrypted zips use WinZip's AES-256 encryption, which 7-Zip, WinZip, macOS's Archive Utility and most other
+// archivers open, unlike the legacy ZipCrypto which is trivially broken
+const (
+	aesMethod     = 99
+	aesExtraID    = 0x9901
+	aesSaltSize   = 16
+	aesKeySize    = 32
+	aesIterations = 1000
+	aesTagSize    = 10
+)
+
+// aesExtra is the extra field marking an entry as AE-1, which keeps the CRC, with AES-256 over deflated data
+var aesExtra = []byte{
+	byte(aesExtraID & 0xff), byte(aesExtraID >> 8),
+	7, 0,
+	1, 0,
+	'A', 'E',
+	3,
+	byte(zip.Deflate), 0,
+}
+
+// encryptHeader marks the header as an encrypted entry, whose data is compressed by the compressor registered for
+// aesMethod
+func encryptHeader(header *zip.FileHeader) {
+	header.Method = aesMethod
+	header.Flags |= 0x1
+	header.Extra = append(header.Extra, aesExtra...)
+}
+
+// aesCompressor deflates then encrypts each entry with the password
+func aesCompressor(password string) zip.Compressor {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return newAESWriter(w, password)
+	}
+}
+
+// aesWriter writes the salt and password verifier, the deflated data encrypted in AES-CTR as it's written, then
+// the authentication code of the encrypted data when closed. The zip writer makes it before writing the entry's
+// header, so the salt and verifier are only written with the first data.
+type aesWriter struct {
+	target   io.Writer
+	preamble []byte
+	deflater *flate.Writer
+	block    cipher.Block
+	counter  [aes.BlockSize]byte
+	stream   [aes.BlockSize]byte
+	used     int
+	mac      hash.Hash
+}
+
+func newAESWriter(target io.Writer, password string) (*aesWriter, error) {
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	keys := pbkdf2SHA1([]byte(password), salt, aesIterations, 2*aesKeySize+2)
+	block, err := aes.NewCipher(keys[:aesKeySize])
+	if err != nil {
+		return nil, err
+	}
+
+	preamble := append(salt, keys[2*aesKeySize:]...)
+	writer := &aesWriter{target: target, preamble: preamble, block: block, used: aes.BlockSize, mac: hmac.New(sha1.New, keys[aesKeySize:2*aesKeySize])}
+	writer.deflater, err = flate.NewWriter(encryptingWriter{writer}, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	return w.deflater.Write(p)
+}
+
+func (w *aesWriter) Close() error {
+	if err := w.deflater.Close(); err != nil {
+		return err
+	}
+	if err := w.writePreamble(); err != nil {
+		return err
+	}
+	_, err := w.target.Write(w.mac.Sum(nil)[:aesTagSize])
+	return err
+}
+
+func (w *aesWriter) writePreamble() error {
+	if w.preamble == nil {
+		return nil
+	}
+	_, err := w.target.Write(w.preamble)
+	w.preamble = nil
+	return err
+}
+
+// encrypt XORs the data with the key stream, whose counter is little endian and starts at 1 unlike the standard
+// library's CTR mode
+func (w *aesWriter) encrypt(p []byte) []byte {
+	encrypted := make([]byte, len(p))
+	for i := range p {
+		if w.used == aes.BlockSize {
+			for j := range w.counter {
+				w.counter[j]++
+				if w.counter[j] != 0 {
+					break
+				}
+			}
+			w.block.Encrypt(w.stream[:], w.counter[:])
+			w.used = 0
+		}
+		encrypted[i] = p[i] ^ w.stream[w.used]
+		w.used++
+	}
+	return encrypted
+}
+
+// encryptingWriter is where the deflater writes, encrypting and authenticating what it's given
+type encryptingWriter struct {
+	w *aesWriter
+}
+
+func (e encryptingWriter) Write(p []byte) (int, error) {
+	if err := e.w.writePreamble(); err != nil {
+		return 0, err
+	}
+	encrypted := e.w.encrypt(p)
+	e.w.mac.Write(encrypted)
+	if _, err := e.w.target.Write(encrypted); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// pbkdf2SHA1 derives keyLength bytes of keys from the password, as RFC 2898 does with HMAC-SHA1
+func pbkdf2SHA1(password []byte, salt []byte, iterations int, keyLength int) []byte {
+	prf := hmac.New(sha1.New, password)
+	size := prf.Size()
+	blocks := (keyLength + size - 1) / size
+
+	derived := make([]byte, 0, blocks*size)
+	var index [4]byte
+	u := make([]byte, size)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], uint32(block))
+		prf.Write(index[:])
+		derived = prf.Sum(derived)
+		t := derived[len(derived)-size:]
+		copy(u, t)
+
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return derived[:keyLength]
+}
@@ -0,0 +1,209 @@
+package emailer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// The PBKDF2-HMAC-SHA1 test vectors of RFC 6070, but for the one of 16777216 iterations
+func TestPBKDF2SHA1(t *testing.T) {
+	vectors := []struct {
+		password   string
+		salt       string
+		iterations int
+		derived    string
+	}{
+		{"password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"pass\x00word", "sa\x00lt", 4096, "56fa6aa75548099dcc37d7f03425e0c3"},
+	}
+	for _, vector := range vectors {
+		want, _ := hex.DecodeString(vector.derived)
+		got := pbkdf2SHA1([]byte(vector.password), []byte(vector.salt), vector.iterations, len(want))
+		if !bytes.Equal(got, want) {
+			t.Errorf("pbkdf2SHA1(%q, %q, %d) = %x, want %s", vector.password, vector.salt, vector.iterations, got, vector.derived)
+		}
+	}
+}
+
+// aesReader decrypts an AE-1 entry as the WinZip AES specification describes it, independently of the writer
+func aesReader(password string) zip.Decompressor {
+	return func(r io.Reader) io.ReadCloser {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errorReader{err}
+		}
+		if len(data) < aesSaltSize+2+aesTagSize {
+			return errorReader{errors.New("entry is too short")}
+		}
+		salt, verifier := data[:aesSaltSize], data[aesSaltSize:aesSaltSize+2]
+		encrypted, tag := data[aesSaltSize+2:len(data)-aesTagSize], data[len(data)-aesTagSize:]
+
+		keys := pbkdf2SHA1([]byte(password), salt, 1000, 2*32+2)
+		if !bytes.Equal(keys[64:], verifier) {
+			return errorReader{errors.New("wrong password")}
+		}
+		mac := hmac.New(sha1.New, keys[32:64])
+		mac.Write(encrypted)
+		if !hmac.Equal(mac.Sum(nil)[:10], tag) {
+			return errorReader{errors.New("authentication code doesn't match")}
+		}
+
+		block, err := aes.NewCipher(keys[:32])
+		if err != nil {
+			return errorReader{err}
+		}
+		deflated := make([]byte, len(encrypted))
+		var counter, stream [16]byte
+		for offset := 0; offset < len(encrypted); offset += 16 {
+			binary.LittleEndian.PutUint64(counter[:8], uint64(offset/16+1))
+			block.Encrypt(stream[:], counter[:])
+			for i := offset; i < offset+16 && i < len(encrypted); i++ {
+				deflated[i] = encrypted[i] ^ stream[i-offset]
+			}
+		}
+		return flate.NewReader(bytes.NewReader(deflated))
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read(p []byte) (int, error) { return 0, e.err }
+func (e errorReader) Close() error               { return nil }
+
+// writeReport writes a report of random bytes, across several AES blocks and deflate blocks
+func writeReport(t *testing.T, directory string) (string, []byte) {
+	t.Helper()
+	content := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(content[:100000])
+	path := filepath.Join(directory, "Stock report.xlsx")
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, content
+}
+
+func TestEncryptedZipRoundTrip(t *testing.T) {
+	path, content := writeReport(t, t.TempDir())
+	zipPath := path + ".zip"
+	if err := zipFile(path, zipPath, "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	archive.RegisterDecompressor(aesMethod, aesReader("correct horse battery"))
+
+	if len(archive.File) != 1 || archive.File[0].Name != "Stock report.xlsx" {
+		t.Fatalf("expected the report alone in the zip, got %v", archive.File)
+	}
+	entry := archive.File[0]
+	if entry.Flags&0x1 == 0 {
+		t.Error("expected the entry to be flagged as encrypted")
+	}
+	if entry.CRC32 != crc32.ChecksumIEEE(content) {
+		t.Errorf("expected the CRC of the report, %08x, got %08x", crc32.ChecksumIEEE(content), entry.CRC32)
+	}
+
+	// The zip reader checks the CRC as the entry is read to its end
+	reader, err := entry.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, content) {
+		t.Error("the decrypted report isn't the report")
+	}
+
+	archive.RegisterDecompressor(aesMethod, aesReader("wrong password"))
+	if reader, err = entry.Open(); err == nil {
+		_, err = ioutil.ReadAll(reader)
+		reader.Close()
+	}
+	if err == nil {
+		t.Error("expected the zip not to open with the wrong password")
+	}
+}
+
+// bsdtar reads WinZip AES zips with libarchive, an implementation entirely separate from this one
+func TestEncryptedZipOpensWithBsdtar(t *testing.T) {
+	bsdtar, err := exec.LookPath("bsdtar")
+	if err != nil {
+		if _, statErr := os.Stat("/root/miniconda/bin/bsdtar"); statErr != nil {
+			t.Skip("bsdtar isn't installed")
+		}
+		bsdtar = "/root/miniconda/bin/bsdtar"
+	}
+
+	directory := t.TempDir()
+	path, content := writeReport(t, directory)
+	if err := zipFile(path, path+".zip", "correct horse battery"); err != nil {
+		t.Fatal(err)
+	}
+
+	extracted := filepath.Join(directory, "extracted")
+	if err := os.Mkdir(extracted, 0755); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command(bsdtar, "-xf", path+".zip", "-C", extracted, "--passphrase", "correct horse battery").CombinedOutput()
+	if err != nil {
+		t.Fatalf("bsdtar couldn't extract the zip: %v: %s", err, output)
+	}
+	decrypted, err := ioutil.ReadFile(filepath.Join(extracted, "Stock report.xlsx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, content) {
+		t.Error("the report bsdtar extracted isn't the report")
+	}
+
+	wrong := filepath.Join(directory, "wrong")
+	if err := os.Mkdir(wrong, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command(bsdtar, "-xf", path+".zip", "-C", wrong, "--passphrase", "wrong password").Run(); err == nil {
+		t.Error("expected bsdtar not to extract the zip with the wrong password")
+	}
+}
+
+func TestEncryptAttachmentRemovesReport(t *testing.T) {
+	path, _ := writeReport(t, t.TempDir())
+	e := &Emailer{attachmentPassword: "correct horse battery"}
+
+	attachment, err := e.PrepareAttachment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Mode != AttachmentModeEncrypted || attachment.Path != path+".zip" {
+		t.Errorf("expected the encrypted zip to be attached, got %+v", attachment)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the unencrypted report to be removed once zipped")
+	}
+}
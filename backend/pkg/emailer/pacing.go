@@ -0,0 +1,220 @@
+package emailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"gopkg.in/gomail.v2"
+)
+
+// How long to wait when the mail server defers an email for sending too many, as relays usually count per minute,
+// and how many times the email is tried again before giving up on it
+const (
+	throttleBackoff = time.Minute
+	throttleRetries = 3
+)
+
+// Replies a mail server defers with when it's throttling the sender and closing the connection. Deferrals of one
+// recipient, e.g. 450 for a full or busy mailbox, say nothing about the others so don't hold them back.
+var throttleReplyCodes = map[int]bool{421: true}
+
+// pacer spaces out the emails sent to a mail server so no more than its rate limit are sent a minute. Pacers are
+// shared by every emailer using the same server and account, as its limit applies to all of them.
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+var (
+	pacersMu sync.Mutex
+	pacers   = map[string]*pacer{}
+)
+
+func pacerFor(key string, perMinute int) *pacer {
+	pacersMu.Lock()
+	defer pacersMu.Unlock()
+
+	interval := time.Minute / time.Duration(perMinute)
+	p, ok := pacers[key]
+	if !ok {
+		p = &pacer{}
+		pacers[key] = p
+	}
+	p.mu.Lock()
+	p.interval = interval
+	p.mu.Unlock()
+	return p
+}
+
+// wait takes the next slot to send in, returning once it comes or early when the context is done
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	metrics.EmailPacingDelay.Observe(delay.Seconds())
+	return sleep(ctx, delay)
+}
+
+// backOff holds back every email to the server for a while, after it has deferred one for sending too many
+func (p *pacer) backOff(delay time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if resume := time.Now().Add(delay); resume.After(p.next) {
+		p.next = resume
+	}
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pacer is the emailer's pacer, which lets every email through straight away when there is no rate limit
+func (e *Emailer) pacer() *pacer {
+	if e.rateLimit <= 0 {
+		return &pacer{}
+	}
+	return pacerFor(fmt.Sprintf("%s:%d:%s", e.host, e.port, e.email), e.rateLimit)
+}
+
+// isThrottled is whether a send failed because the mail server is limiting how many the sender can send
+func isThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	match := smtpReply.FindStringSubmatch(err.Error())
+	if match == nil {
+		return false
+	}
+
+	var code int
+	fmt.Sscanf(match[1], "%d", &code)
+	return throttleReplyCodes[code]
+}
+
+// batch sends several emails over one connection to the mail server, reconnecting once size have been sent
+// or a send fails. A size of 1 or less connects for each email. A connection a send gave up on is closed straight
+// away rather than used again.
+type batch struct {
+	emailer *Emailer
+	size    int
+	sender  *connection
+	sent    int
+}
+
+func (e *Emailer) newBatch() *batch {
+	return &batch{emailer: e, size: e.batchSize}
+}
+
+// send gives up once the context is done or the send timeout has passed, like dialAndSend
+func (b *batch) send(ctx context.Context, m *gomail.Message) error {
+	if b.size <= 1 {
+		return b.emailer.dialAndSend(ctx, m)
+	}
+
+	e := b.emailer
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	if err := chaos.SMTP(); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	reused := b.sender != nil
+	err := b.sendOnce(ctx, m)
+	if reused && errors.Is(err, errConnectionClosed) {
+		err = b.sendOnce(ctx, m)
+	}
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	metrics.ObserveEmail(started, err)
+	if err != nil {
+		return err
+	}
+
+	b.sent++
+	if b.sent >= b.size {
+		b.close()
+	}
+	return nil
+}
+
+// sendOnce sends the email over the batch's connection, connecting first when it has none
+func (b *batch) sendOnce(ctx context.Context, m *gomail.Message) error {
+	if b.sender == nil {
+		sender, err := b.emailer.dial(ctx)
+		if err != nil {
+			return err
+		}
+		b.sender = sender
+		b.sent = 0
+	}
+
+	stop := b.sender.watch(ctx)
+	err := b.emailer.send(b.sender, m)
+	stop()
+	if err != nil {
+		// The server may be part way through the message, so the connection isn't used again
+		b.close()
+	}
+	return err
+}
+
+func (b *batch) close() {
+	if b.sender != nil {
+		b.sender.Close()
+		b.sender = nil
+	}
+	b.sent = 0
+}
+
+// sendPaced sends an email within the rate limit, trying it again after a while when the mail server defers it for
+// sending too many, so a large report group is paced out rather than failing part way through
+func (e *Emailer) sendPaced(ctx context.Context, p *pacer, b *batch, m *gomail.Message, email string) error {
+	for attempt := 0; ; attempt++ {
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+
+		err := b.send(ctx, m)
+		if !isThrottled(err) || attempt >= throttleRetries {
+			return err
+		}
+
+		log.DefaultLogger.Warn(fmt.Sprintf("sendPaced: the mail server is throttling, trying %s again in %s: %s", email, throttleBackoff, err.Error()))
+		metrics.EmailsThrottledTotal.Inc()
+		b.close()
+		p.backOff(throttleBackoff)
+	}
+}
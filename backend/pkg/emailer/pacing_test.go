@@ -0,0 +1,213 @@
+package emailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// fakeSMTP is a mail server which answers each recipient with reply(address), "250 OK" by default, and holds the
+// first message for delay before accepting it
+type fakeSMTP struct {
+	reply func(address string) string
+	delay time.Duration
+
+	mutex       sync.Mutex
+	connections int
+	recipients  []string
+	delivered   int
+}
+
+func (server *fakeSMTP) start(t *testing.T) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber
+}
+
+func (server *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	server.mutex.Lock()
+	server.connections++
+	server.mutex.Unlock()
+
+	reader := bufio.NewReader(conn)
+	write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	write("220 fake ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			write("250 fake")
+		case strings.HasPrefix(command, "MAIL"), strings.HasPrefix(command, "RSET"), strings.HasPrefix(command, "NOOP"):
+			write("250 OK")
+		case strings.HasPrefix(command, "RCPT"):
+			address := strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			server.mutex.Lock()
+			server.recipients = append(server.recipients, address)
+			server.mutex.Unlock()
+			reply := "250 OK"
+			if server.reply != nil {
+				reply = server.reply(address)
+			}
+			write(reply)
+		case command == "DATA":
+			write("354 go ahead")
+			for {
+				data, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+			}
+			server.mutex.Lock()
+			delay := server.delay
+			server.delay = 0
+			server.mutex.Unlock()
+			time.Sleep(delay)
+			server.mutex.Lock()
+			server.delivered++
+			server.mutex.Unlock()
+			write("250 queued")
+		case command == "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("502 unrecognised")
+		}
+	}
+}
+
+func message(to string) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "reports@example.org")
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "Stock")
+	m.SetBody("text/plain", "March")
+	return m
+}
+
+func TestBatchReusesConnection(t *testing.T) {
+	server := &fakeSMTP{}
+	host, port := server.start(t)
+	e := &Emailer{email: "reports@example.org", host: host, port: port, batchSize: 10, timeout: 5 * time.Second}
+
+	b := e.newBatch()
+	for _, to := range []string{"a@example.org", "b@example.org", "c@example.org"} {
+		if err := b.send(context.Background(), message(to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.close()
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.connections != 1 || server.delivered != 3 {
+		t.Errorf("expected 3 emails over 1 connection, got %d over %d", server.delivered, server.connections)
+	}
+}
+
+// Run with -race: the send given up on mustn't hand its connection back to the batch after the caller has moved on
+func TestBatchTimeoutDoesNotReuseConnection(t *testing.T) {
+	server := &fakeSMTP{delay: 300 * time.Millisecond}
+	host, port := server.start(t)
+	e := &Emailer{email: "reports@example.org", host: host, port: port, batchSize: 10, timeout: 100 * time.Millisecond}
+
+	b := e.newBatch()
+	if err := b.send(context.Background(), message("a@example.org")); err == nil {
+		t.Fatal("expected the slow send to time out")
+	}
+	// The slow send finishes before the batch is used again
+	time.Sleep(400 * time.Millisecond)
+	if b.sender != nil {
+		t.Fatal("expected the connection of the send given up on not to be handed back")
+	}
+	if err := b.send(context.Background(), message("b@example.org")); err != nil {
+		t.Fatal(err)
+	}
+	b.close()
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.connections != 2 {
+		t.Errorf("expected a new connection after the timeout, got %d connections", server.connections)
+	}
+}
+
+func TestRecipientDeferralDoesNotBackOff(t *testing.T) {
+	server := &fakeSMTP{reply: func(address string) string {
+		if address == "full@example.org" {
+			return "452 4.2.2 Mailbox full"
+		}
+		return "250 OK"
+	}}
+	host, port := server.start(t)
+	e := &Emailer{email: "reports@example.org", host: host, port: port, batchSize: 10, timeout: 5 * time.Second}
+
+	p := &pacer{}
+	b := e.newBatch()
+	defer b.close()
+	started := time.Now()
+	if err := e.sendPaced(context.Background(), p, b, message("full@example.org"), "full@example.org"); err == nil {
+		t.Fatal("expected the full mailbox to fail")
+	}
+	if err := e.sendPaced(context.Background(), p, b, message("next@example.org"), "next@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected no back off for one recipient's deferral, took %s", elapsed)
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.recipients) != 2 {
+		t.Errorf("expected the deferred recipient to be tried once, got %v", server.recipients)
+	}
+}
+
+func TestIsThrottledOnlyForConnectionReplies(t *testing.T) {
+	for reply, want := range map[string]bool{
+		"421 4.7.0 Try again later, closing connection":                              true,
+		"450 4.2.1 The user you are trying to contact is receiving mail too quickly": false,
+		"451 4.3.0 Temporary local problem":                                          false,
+		"452 4.2.2 Mailbox full":                                                     false,
+		"550 5.1.1 No such user":                                                     false,
+	} {
+		if got := isThrottled(errorString(reply)); got != want {
+			t.Errorf("%s: expected throttled %v, got %v", reply, want, got)
+		}
+	}
+}
+
+type errorString string
+
+func (e errorString) Error() string { return string(e) }
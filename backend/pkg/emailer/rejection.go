@@ -0,0 +1,43 @@
+package emailer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// An SMTP reply as it appears in gomail's errors, e.g. "550 5.1.1 <someone@example.com>: Recipient address rejected",
+// with the enhanced status code being optional
+var smtpReply = regexp.MustCompile(`\b([45][0-9][0-9])[ -](?:([45]\.[0-9]{1,3}\.[0-9]{1,3})\s+)?(.*)`)
+
+// Replies about the connection or the account sending, rather than the recipient
+var senderReplyCodes = map[int]bool{421: true, 454: true, 530: true, 534: true, 535: true, 538: true}
+
+// ParseRejection reads the mail server's reply from a failed send, returning nil when the failure wasn't the
+// server refusing the address, e.g. it couldn't be reached or the login failed
+func ParseRejection(address string, err error) *dbstore.DeliveryFailure {
+	if err == nil {
+		return nil
+	}
+
+	match := smtpReply.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil
+	}
+
+	code, _ := strconv.Atoi(match[1])
+	if senderReplyCodes[code] {
+		return nil
+	}
+
+	return &dbstore.DeliveryFailure{
+		Address:   address,
+		Source:    dbstore.DeliverySourceSMTP,
+		Code:      code,
+		Status:    match[2],
+		Permanent: code >= 500,
+		Message:   strings.TrimSpace(match[3]),
+	}
+}
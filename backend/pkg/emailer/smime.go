@@ -0,0 +1,297 @@
+package emailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"gopkg.in/gomail.v2"
+)
+
+// OIDs of the CMS signature, RFC 5652, and its algorithms
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    algorithmIdentifier
+	SignedAttributes   asn1.RawValue
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+}
+
+type encapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// send sends the message over the connection, signed when the emailer signs its emails
+func (e *Emailer) send(sender gomail.SendCloser, m *gomail.Message) error {
+	if e.signing == nil {
+		return gomail.Send(sender, m)
+	}
+
+	from, to, err := envelope(m)
+	if err != nil {
+		return err
+	}
+	signed, err := signMessage(m, e.signing, time.Now())
+	if err != nil {
+		return errors.New("signing email: " + err.Error())
+	}
+	return sender.Send(from, to, bytes.NewReader(signed))
+}
+
+// envelope is who the message is from and to, as gomail.Send takes them from its headers
+func envelope(m *gomail.Message) (string, []string, error) {
+	from := m.GetHeader("Sender")
+	if len(from) == 0 {
+		from = m.GetHeader("From")
+	}
+	if len(from) == 0 {
+		return "", nil, errors.New("the email has no From header")
+	}
+	sender, err := mail.ParseAddress(from[0])
+	if err != nil {
+		return "", nil, err
+	}
+
+	var to []string
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		for _, value := range m.GetHeader(field) {
+			address, err := mail.ParseAddress(value)
+			if err != nil {
+				return "", nil, err
+			}
+			to = append(to, address.Address)
+		}
+	}
+	return sender.Address, to, nil
+}
+
+// signMessage is the message as multipart/signed, RFC 8551, with its content, i.e. the body and attachments, as
+// the first part and a detached CMS signature of it as the second
+func signMessage(m *gomail.Message, identity *dbstore.SigningIdentity, now time.Time) ([]byte, error) {
+	var raw bytes.Buffer
+	if _, err := m.WriteTo(&raw); err != nil {
+		return nil, err
+	}
+	headers, body, err := splitMessage(raw.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// The content's own headers go with it into the signed part, the rest stay on the message
+	var outer, content []string
+	for _, header := range headers {
+		if strings.HasPrefix(strings.ToLower(header), "content-") {
+			content = append(content, header)
+		} else {
+			outer = append(outer, header)
+		}
+	}
+	entity := canonical(strings.Join(content, "\r\n") + "\r\n\r\n" + body)
+
+	signature, err := signDetached([]byte(entity), identity, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var boundaryBytes [16]byte
+	if _, err := rand.Read(boundaryBytes[:]); err != nil {
+		return nil, err
+	}
+	boundary := "signed-" + hex.EncodeToString(boundaryBytes[:])
+
+	var signed strings.Builder
+	for _, header := range outer {
+		signed.WriteString(header + "\r\n")
+	}
+	signed.WriteString("Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=\"" + boundary + "\"\r\n")
+	signed.WriteString("\r\nThis is a signed message.\r\n")
+	signed.WriteString("--" + boundary + "\r\n")
+	signed.WriteString(entity)
+	signed.WriteString("\r\n--" + boundary + "\r\n")
+	signed.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	signed.WriteString("Content-Transfer-Encoding: base64\r\n")
+	signed.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	for len(encoded) > 76 {
+		signed.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	signed.WriteString(encoded + "\r\n")
+	signed.WriteString("--" + boundary + "--\r\n")
+
+	return []byte(signed.String()), nil
+}
+
+// splitMessage is the message's headers, with any folded onto more than one line kept whole, and its body
+func splitMessage(raw string) ([]string, string, error) {
+	raw = canonical(raw)
+	end := strings.Index(raw, "\r\n\r\n")
+	if end < 0 {
+		return nil, "", errors.New("the email has no body")
+	}
+
+	var headers []string
+	for _, line := range strings.Split(raw[:end], "\r\n") {
+		if len(headers) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, raw[end+4:], nil
+}
+
+// canonical ends every line with CRLF, as the signature is of the content as it's sent
+func canonical(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// signDetached is the DER CMS SignedData of the content, without the content itself, signed by the identity with
+// its certificates attached
+func signDetached(content []byte, identity *dbstore.SigningIdentity, now time.Time) ([]byte, error) {
+	digest := sha256.Sum256(content)
+
+	contentType, err := asn1.Marshal(oidData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signingTime, err := asn1.Marshal(now.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes [][]byte
+	for _, attr := range []attribute{
+		{Type: oidContentType, Values: derSet(contentType)},
+		{Type: oidMessageDigest, Values: derSet(messageDigest)},
+		{Type: oidSigningTime, Values: derSet(signingTime)},
+	} {
+		encoded, err := asn1.Marshal(attr)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+
+	// The signature is of the attributes as a SET, though they're sent tagged [0]
+	attributeSet, err := asn1.Marshal(derSet(attributes...))
+	if err != nil {
+		return nil, err
+	}
+	attributesDigest := sha256.Sum256(attributeSet)
+	signature, err := identity.Key.Sign(rand.Reader, attributesDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureAlgorithm := algorithmIdentifier{Algorithm: oidRSA, Parameters: asn1.NullRawValue}
+	if _, ok := identity.Key.(*ecdsa.PrivateKey); ok {
+		signatureAlgorithm = algorithmIdentifier{Algorithm: oidECDSASHA256}
+	}
+	digestAlgorithm := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+	signer, err := asn1.Marshal(signerInfo{
+		Version:            1,
+		SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: identity.Certificate.RawIssuer}, SerialNumber: identity.Certificate.SerialNumber},
+		DigestAlgorithm:    digestAlgorithm,
+		SignedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: joinDER(sortedDER(attributes))},
+		SignatureAlgorithm: signatureAlgorithm,
+		Signature:          signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := asn1.Marshal(digestAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	certificates := [][]byte{identity.Certificate.Raw}
+	for _, certificate := range identity.Chain {
+		certificates = append(certificates, certificate.Raw)
+	}
+
+	data, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: derSet(algorithm),
+		EncapContentInfo: encapsulatedContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: joinDER(certificates)},
+		SignerInfos:      derSet(signer),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data}})
+}
+
+// derSet is a SET OF the encoded elements, which DER orders by their encoding
+func derSet(elements ...[]byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: joinDER(sortedDER(elements))}
+}
+
+func sortedDER(elements [][]byte) [][]byte {
+	sorted := append([][]byte{}, elements...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return sorted
+}
+
+func joinDER(elements [][]byte) []byte {
+	return bytes.Join(elements, nil)
+}
@@ -0,0 +1,299 @@
+package emailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"gopkg.in/gomail.v2"
+)
+
+const signerAddress = "reports@example.org"
+
+// newSigningIdentity is an S/MIME certificate for signerAddress, with its key, issued by a CA made for the test
+// whose certificate is returned as PEM
+func newSigningIdentity(t *testing.T, key crypto.Signer) (*dbstore.SigningIdentity, []byte) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "Reports"},
+		EmailAddresses: []string{signerAddress},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(24 * time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dbstore.SigningIdentity{Certificate: certificate, Key: key}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func signingKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"RSA": rsaKey, "ECDSA": ecdsaKey}
+}
+
+// verifyCMS checks a detached CMS signature of the content as RFC 5652 describes it, reading the DER without the
+// types the signer uses
+func verifyCMS(signature []byte, content []byte) error {
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(signature, &info); err != nil {
+		return fmt.Errorf("ContentInfo: %v", err)
+	}
+	if !info.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) {
+		return fmt.Errorf("expected signed-data, got %v", info.ContentType)
+	}
+
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo asn1.RawValue
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return fmt.Errorf("SignedData: %v", err)
+	}
+	var encapsulated struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.FullBytes, &encapsulated); err != nil {
+		return fmt.Errorf("EncapsulatedContentInfo: %v", err)
+	}
+	if len(encapsulated.Content.Bytes) > 0 {
+		return errors.New("expected the signature to be detached from the content")
+	}
+	if signed.Certificates.Class != asn1.ClassContextSpecific || signed.Certificates.Tag != 0 {
+		return errors.New("expected the signer's certificate with the signature")
+	}
+	certificates, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return fmt.Errorf("certificates: %v", err)
+	}
+
+	var signer struct {
+		Version int
+		SID     struct {
+			Issuer       asn1.RawValue
+			SerialNumber *big.Int
+		}
+		DigestAlgorithm    asn1.RawValue
+		SignedAttributes   asn1.RawValue
+		SignatureAlgorithm struct {
+			Algorithm asn1.ObjectIdentifier
+		}
+		Signature []byte
+	}
+	if rest, err := asn1.Unmarshal(signed.SignerInfos.Bytes, &signer); err != nil || len(rest) > 0 {
+		return fmt.Errorf("expected one SignerInfo: %v", err)
+	}
+
+	var certificate *x509.Certificate
+	for _, c := range certificates {
+		if c.SerialNumber.Cmp(signer.SID.SerialNumber) == 0 && bytes.Equal(c.RawIssuer, signer.SID.Issuer.FullBytes) {
+			certificate = c
+		}
+	}
+	if certificate == nil {
+		return errors.New("the signer's certificate isn't among those sent")
+	}
+
+	// The message digest attribute has to be the content's
+	digest := sha256.Sum256(content)
+	found := false
+	for rest := signer.SignedAttributes.Bytes; len(rest) > 0; {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue `asn1:"set"`
+		}
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return fmt.Errorf("attribute: %v", err)
+		}
+		if attr.Type.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}) {
+			var value []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+				return fmt.Errorf("messageDigest: %v", err)
+			}
+			found = bytes.Equal(value, digest[:])
+		}
+	}
+	if !found {
+		return errors.New("expected a message digest attribute of the content")
+	}
+
+	// The signature is of the attributes DER encoded as a SET rather than with their implicit [0] tag
+	attributes := append([]byte{0x31}, signer.SignedAttributes.FullBytes[1:]...)
+	algorithm := x509.SHA256WithRSA
+	if _, ok := certificate.PublicKey.(*ecdsa.PublicKey); ok {
+		algorithm = x509.ECDSAWithSHA256
+	}
+	return certificate.CheckSignature(algorithm, attributes, signer.Signature)
+}
+
+func TestSignDetachedVerifies(t *testing.T) {
+	for name, key := range signingKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			identity, _ := newSigningIdentity(t, key)
+			content := []byte("Content-Type: text/plain\r\n\r\nMarch stock levels\r\n")
+			signature, err := signDetached(content, identity, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyCMS(signature, content); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := verifyCMS(signature, []byte("Content-Type: text/plain\r\n\r\nApril stock levels\r\n")); err == nil {
+				t.Error("expected the signature not to verify for other content")
+			}
+			signature[len(signature)-1] ^= 1
+			if err := verifyCMS(signature, content); err == nil {
+				t.Error("expected a changed signature not to verify")
+			}
+		})
+	}
+}
+
+// signedEmail is a report's email, signed by the identity
+func signedEmail(t *testing.T, identity *dbstore.SigningIdentity) []byte {
+	t.Helper()
+	m := gomail.NewMessage()
+	m.SetHeader("From", signerAddress)
+	m.SetHeader("To", "manager@example.org")
+	m.SetHeader("Subject", "Stock report")
+	m.SetBody("text/html", "<p>March stock levels</p>")
+	report := filepath.Join(t.TempDir(), "Stock report.xlsx")
+	if err := ioutil.WriteFile(report, bytes.Repeat([]byte("stock "), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.Attach(report)
+
+	signed, err := signMessage(m, identity, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// openssl is the openssl binary, skipping the test when it isn't installed
+func openssl(t *testing.T) string {
+	t.Helper()
+	path, err := exec.LookPath("openssl")
+	if err == nil {
+		return path
+	}
+	if _, err := os.Stat("/root/miniconda/bin/openssl"); err == nil {
+		return "/root/miniconda/bin/openssl"
+	}
+	t.Skip("openssl isn't installed")
+	return ""
+}
+
+func TestSignedEmailVerifiesWithOpenSSL(t *testing.T) {
+	for name, key := range signingKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			binary := openssl(t)
+			identity, caPEM := newSigningIdentity(t, key)
+			directory := t.TempDir()
+			ca := filepath.Join(directory, "ca.pem")
+			if err := ioutil.WriteFile(ca, caPEM, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The whole email, as a mail client gets it
+			email := filepath.Join(directory, "email.eml")
+			signed := signedEmail(t, identity)
+			if err := ioutil.WriteFile(email, signed, 0644); err != nil {
+				t.Fatal(err)
+			}
+			output, err := exec.Command(binary, "cms", "-verify", "-in", email, "-CAfile", ca, "-out", filepath.Join(directory, "content")).CombinedOutput()
+			if err != nil {
+				t.Fatalf("openssl couldn't verify the email: %v: %s", err, output)
+			}
+
+			// And the bare signature of some content
+			content := []byte("Content-Type: text/plain\r\n\r\nMarch stock levels\r\n")
+			signature, err := signDetached(content, identity, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			contentPath, signaturePath := filepath.Join(directory, "content.txt"), filepath.Join(directory, "smime.p7s")
+			if err := ioutil.WriteFile(contentPath, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(signaturePath, signature, 0644); err != nil {
+				t.Fatal(err)
+			}
+			output, err = exec.Command(binary, "cms", "-verify", "-binary", "-inform", "DER", "-in", signaturePath, "-content", contentPath, "-CAfile", ca, "-out", os.DevNull).CombinedOutput()
+			if err != nil {
+				t.Fatalf("openssl couldn't verify the signature: %v: %s", err, output)
+			}
+
+			if err := ioutil.WriteFile(contentPath, []byte("Content-Type: text/plain\r\n\r\nApril stock levels\r\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := exec.Command(binary, "cms", "-verify", "-binary", "-inform", "DER", "-in", signaturePath, "-content", contentPath, "-CAfile", ca, "-out", os.DevNull).Run(); err == nil {
+				t.Error("expected openssl not to verify the signature of other content")
+			}
+		})
+	}
+}
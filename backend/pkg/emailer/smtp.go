@@ -0,0 +1,185 @@
+package emailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long to wait for the mail server to answer connecting, as gomail did, and saying goodbye
+const (
+	dialTimeout = 10 * time.Second
+	quitTimeout = 5 * time.Second
+)
+
+// A reused connection the mail server had already closed, e.g. after it was idle for too long, before the email was
+// started, so the email can be sent over a new one
+var errConnectionClosed = errors.New("the mail server closed the connection")
+
+// connection is a session with the mail server, which gomail sends emails over. gomail's own can't be interrupted,
+// so a server which stops answering would leave whatever is using it blocked for good. This one is closed as soon as
+// the context of what it's doing is done instead.
+type connection struct {
+	conn   net.Conn
+	client *smtp.Client
+}
+
+// dial connects and authenticates to the mail server the way gomail.Dialer does, giving up once the context is done
+func (e *Emailer) dial(ctx context.Context) (*connection, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(e.host, strconv.Itoa(e.port)))
+	if err != nil {
+		return nil, err
+	}
+
+	ssl := e.port == 465
+	tlsConfig := &tls.Config{ServerName: e.host}
+	if ssl {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c := &connection{conn: conn}
+	stop := c.watch(ctx)
+	defer stop()
+
+	if err := c.open(e, ssl, tlsConfig); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *connection) open(e *Emailer, ssl bool, tlsConfig *tls.Config) error {
+	client, err := smtp.NewClient(c.conn, e.host)
+	if err != nil {
+		return err
+	}
+	c.client = client
+
+	if !ssl {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.email == "" {
+		return nil
+	}
+	ok, auths := client.Extension("AUTH")
+	if !ok {
+		return nil
+	}
+	var auth smtp.Auth
+	switch {
+	case strings.Contains(auths, "CRAM-MD5"):
+		auth = smtp.CRAMMD5Auth(e.email, e.password)
+	case strings.Contains(auths, "LOGIN") && !strings.Contains(auths, "PLAIN"):
+		auth = &loginAuth{username: e.email, password: e.password, host: e.host}
+	default:
+		auth = smtp.PlainAuth("", e.email, e.password, e.host)
+	}
+	return client.Auth(auth)
+}
+
+// watch closes the connection if the context is done before stop is called, which makes whatever is waiting on the
+// mail server fail straight away
+func (c *connection) watch(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Send sends one email, for gomail.Send
+func (c *connection) Send(from string, to []string, msg io.WriterTo) error {
+	if err := c.client.Mail(from); err != nil {
+		if err == io.EOF {
+			return errConnectionClosed
+		}
+		return err
+	}
+	for _, address := range to {
+		if err := c.client.Rcpt(address); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Close says goodbye to the mail server, not waiting long for it to answer, and closes the connection
+func (c *connection) Close() error {
+	c.conn.SetDeadline(time.Now().Add(quitTimeout))
+	if err := c.client.Quit(); err != nil {
+		c.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// loginAuth is the LOGIN mechanism, for servers such as Office 365 which don't offer PLAIN, as gomail has
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		advertised := false
+		for _, mechanism := range server.Auth {
+			if mechanism == "LOGIN" {
+				advertised = true
+				break
+			}
+		}
+		if !advertised {
+			return "", nil, errors.New("unencrypted connection")
+		}
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
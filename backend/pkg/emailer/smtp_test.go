@@ -0,0 +1,43 @@
+package emailer
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A mail server which accepts connections and never answers mustn't keep anything waiting on it after the send
+// gives up
+func TestTimedOutSendClosesItsConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	e := &Emailer{email: "reports@example.org", host: host, port: portNumber, timeout: 100 * time.Millisecond}
+
+	if err := e.dialAndSend(context.Background(), message("a@example.org")); err != context.DeadlineExceeded {
+		t.Fatalf("expected the send to time out, got %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the connection to be closed when the send timed out")
+	}
+}
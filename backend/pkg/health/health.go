@@ -0,0 +1,250 @@
+// Package health checks each part of the system reports depend on, so the datasource's
+// test button shows exactly which one is broken.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+const (
+	StatusOk    = "ok"
+	StatusError = "error"
+	// Not needed by the current configuration, e.g. the renderer when no schedule includes images
+	StatusSkipped = "skipped"
+)
+
+// Time allowed for each subsystem to respond
+const checkTimeout = 10 * time.Second
+
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// Details is returned as the JSON details of the health check result
+type Details struct {
+	Checks []Check `json:"checks"`
+}
+
+type Checker struct {
+	db *dbstore.SQLiteDatasource
+}
+
+func NewChecker(db *dbstore.SQLiteDatasource) *Checker {
+	return &Checker{db: db}
+}
+
+func result(name string, err error) Check {
+	if err != nil {
+		return Check{Name: name, Status: StatusError, Message: err.Error()}
+	}
+
+	return Check{Name: name, Status: StatusOk}
+}
+
+// CheckHealth handles health checks sent from Grafana to the plugin, used by the
+// test button on the datasource configuration page.
+func (checker *Checker) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	details := Details{Checks: checker.Checks(ctx)}
+
+	var broken []string
+	for _, check := range details.Checks {
+		if check.Status == StatusError {
+			broken = append(broken, check.Name+": "+check.Message)
+		}
+	}
+
+	status := backend.HealthStatusOk
+	message := "Yeah, nah, All good"
+	if len(broken) > 0 {
+		status = backend.HealthStatusError
+		message = strings.Join(broken, "; ")
+	}
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		log.DefaultLogger.Error("CheckHealth: json.Marshal: " + err.Error())
+		return nil, err
+	}
+
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     message,
+		JSONDetails: jsonDetails,
+	}, nil
+}
+
+// Checks checks each subsystem in turn
+func (checker *Checker) Checks(ctx context.Context) []Check {
+	database := result("database", checker.withTimeout(ctx, checker.db.Ping))
+	checks := []Check{database, checkContention()}
+
+	// Everything else is configured in the database
+	if database.Status == StatusOk {
+		checks = append(checks,
+			result("grafana", checker.withTimeout(ctx, checker.checkGrafana)),
+			checker.checkRenderer(ctx),
+			result("smtp", checker.withTimeout(ctx, checker.checkSMTP)),
+			checker.checkScheduler(),
+			checker.checkSigning(),
+		)
+	}
+	return checks
+}
+
+func (checker *Checker) withTimeout(ctx context.Context, check func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	return check(ctx)
+}
+
+// checkScheduler reports which instance holds the scheduler lease, an expired lease means no instance is sending reports
+func (checker *Checker) checkScheduler() Check {
+	lease, err := checker.db.GetLease(scheduler.LeaseName)
+	if err != nil {
+		return result("scheduler", err)
+	}
+	if lease == nil {
+		return Check{Name: "scheduler", Status: StatusError, Message: "no instance has started running schedules"}
+	}
+	if int64(lease.ExpiresAt) < time.Now().Unix() {
+		return Check{Name: "scheduler", Status: StatusError, Message: fmt.Sprintf("the lease held by '%s' expired at %s", lease.Holder, time.Unix(int64(lease.ExpiresAt), 0).Format(time.RFC3339))}
+	}
+
+	return Check{Name: "scheduler", Status: StatusOk, Message: fmt.Sprintf("schedules are run by '%s'", lease.Holder)}
+}
+
+// checkContention reports writes finding SQLite locked by other connections often enough, or for long enough to give
+// up, that the database should be moved to a server such as Postgres
+func checkContention() Check {
+	stats := dbstore.Contention()
+	if stats.Persistent() {
+		return Check{Name: "database contention", Status: StatusError, Message: stats.String() + ", consider moving to Postgres"}
+	}
+
+	return Check{Name: "database contention", Status: StatusOk, Message: stats.String()}
+}
+
+func (checker *Checker) checkGrafana(ctx context.Context) error {
+	authConfig, err := auth.NewAuthConfig(checker.db)
+	if err != nil {
+		return err
+	}
+
+	response, err := authConfig.GetWithContext(ctx, "/api/search?limit=1")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Grafana API returned %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// rendersImages is whether the organisation defaults, any schedule or any content item would request panel images
+func (checker *Checker) rendersImages() (bool, error) {
+	settings, err := checker.db.GetSettings()
+	if err != nil {
+		return false, err
+	}
+	if settings.DefaultRenderWidth > 0 {
+		return true, nil
+	}
+
+	schedules, err := checker.db.GetSchedules()
+	if err != nil {
+		return false, err
+	}
+	for _, schedule := range schedules {
+		if schedule.RenderWidth > 0 {
+			return true, nil
+		}
+	}
+
+	contents, err := checker.db.GetAllReportContent()
+	if err != nil {
+		return false, err
+	}
+	for _, content := range contents {
+		if content.RenderWidth > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (checker *Checker) checkRenderer(ctx context.Context) Check {
+	needed, err := checker.rendersImages()
+	if err != nil {
+		return result("renderer", err)
+	}
+
+	err = checker.withTimeout(ctx, func(ctx context.Context) error {
+		settings, err := checker.db.GetSettings()
+		if err != nil {
+			return err
+		}
+
+		authConfig, err := auth.NewAuthConfig(checker.db)
+		if err != nil {
+			return err
+		}
+
+		return api.NewRenderer(settings).Ping(ctx, authConfig)
+	})
+
+	if err != nil && !needed {
+		return Check{Name: "renderer", Status: StatusSkipped, Message: err.Error()}
+	}
+
+	return result("renderer", err)
+}
+
+func (checker *Checker) checkSMTP(ctx context.Context) error {
+	emailConfig, err := auth.NewEmailConfig(checker.db)
+	if err != nil {
+		return err
+	}
+
+	return emailer.New(emailConfig).Ping(ctx)
+}
+
+// checkSigning warns when the certificate emails are signed with has expired or is about to
+func (checker *Checker) checkSigning() Check {
+	settings, err := checker.db.GetSettings()
+	if err != nil {
+		return result("signing", err)
+	}
+	identity, err := dbstore.ParseSigningIdentity(settings.SigningCertificate, settings.SigningKey)
+	if err != nil {
+		return result("signing", err)
+	}
+	if identity == nil {
+		return Check{Name: "signing", Status: StatusSkipped, Message: "emails aren't signed"}
+	}
+	if warning := identity.ExpiryWarning(time.Now()); warning != "" {
+		return Check{Name: "signing", Status: StatusError, Message: warning}
+	}
+	return Check{Name: "signing", Status: StatusOk, Message: "the signing certificate expires on " + identity.Certificate.NotAfter.Format("2 Jan 2006")}
+}
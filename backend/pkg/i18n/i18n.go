@@ -0,0 +1,205 @@
+// Package i18n translates the text of report emails and generated documents, and formats their dates, in the
+// locale a report is sent in. Messages come from catalogs: the built in one, with English, French and Lao, and any
+// added with Use, e.g. the JSON files of a directory, which are looked in first so a deployment can add a language
+// or correct a translation without a new release.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Locale everything falls back to, which every message is in
+const DefaultLocale = "en"
+
+// Directory translations are loaded from by default, a file for each locale named e.g. fr.json, next to the
+// plugin's database
+var Directory = filepath.Join("..", "data", "locales")
+
+// Catalog is a source of translated messages
+type Catalog interface {
+	// Message is the text of the message in the locale, false when the catalog doesn't have it
+	Message(locale string, key string) (string, bool)
+	// Locales the catalog has messages in
+	Locales() []string
+}
+
+// Messages is a catalog held in memory, the messages of each locale by their key
+type Messages map[string]map[string]string
+
+func (messages Messages) Message(locale string, key string) (string, bool) {
+	text, ok := messages[locale][key]
+	return text, ok
+}
+
+func (messages Messages) Locales() []string {
+	locales := []string{}
+	for locale := range messages {
+		locales = append(locales, locale)
+	}
+	return locales
+}
+
+var (
+	catalogsMutex sync.RWMutex
+	catalogs      = []Catalog{builtin}
+)
+
+// Use adds a catalog, whose messages are used ahead of those of the catalogs already added
+func Use(catalog Catalog) {
+	catalogsMutex.Lock()
+	defer catalogsMutex.Unlock()
+
+	catalogs = append([]Catalog{catalog}, catalogs...)
+}
+
+// LoadDirectory reads a catalog from a directory of JSON files, each an object of messages by their key named for
+// its locale, e.g. fr.json. A directory which doesn't exist is an empty catalog.
+func LoadDirectory(dir string) (Messages, error) {
+	messages := Messages{}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		locale := Normalize(strings.TrimSuffix(filepath.Base(path), ".json"))
+		var localeMessages map[string]string
+		if err := json.Unmarshal(contents, &localeMessages); err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Base(path), err.Error())
+		}
+		messages[locale] = localeMessages
+	}
+
+	return messages, nil
+}
+
+// UseDirectory adds the catalog of a directory's JSON files, when it has any
+func UseDirectory(dir string) error {
+	messages, err := LoadDirectory(dir)
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		Use(messages)
+	}
+	return nil
+}
+
+// Locales are those which have messages in any catalog, in order
+func Locales() []string {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	seen := map[string]bool{}
+	locales := []string{}
+	for _, catalog := range catalogs {
+		for _, locale := range catalog.Locales() {
+			if !seen[locale] {
+				seen[locale] = true
+				locales = append(locales, locale)
+			}
+		}
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// Normalize writes a locale the way catalogs know it, lower case with a hyphen, e.g. fr_CA as fr-ca
+func Normalize(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+// Localizer translates messages into one locale
+type Localizer struct {
+	// Tried in turn for each message: the locale, its language without a region, then the default
+	locales []string
+}
+
+// For is the localizer of a locale, e.g. from a schedule's effective settings. Empty is the default locale.
+func For(locale string) Localizer {
+	locale = Normalize(locale)
+	locales := []string{}
+	if locale != "" {
+		locales = append(locales, locale)
+		if language := strings.SplitN(locale, "-", 2)[0]; language != locale {
+			locales = append(locales, language)
+		}
+	}
+	if locale != DefaultLocale {
+		locales = append(locales, DefaultLocale)
+	}
+	return Localizer{locales: locales}
+}
+
+// Language is the locale's language without a region, e.g. for a document's lang attribute
+func (localizer Localizer) Language() string {
+	return strings.SplitN(localizer.locales[0], "-", 2)[0]
+}
+
+func (localizer Localizer) message(key string) string {
+	catalogsMutex.RLock()
+	defer catalogsMutex.RUnlock()
+
+	for _, locale := range localizer.locales {
+		for _, catalog := range catalogs {
+			if text, ok := catalog.Message(locale, key); ok {
+				return text
+			}
+		}
+	}
+	return key
+}
+
+// Text is the message translated, formatted with the arguments as by fmt.Sprintf when there are any
+func (localizer Localizer) Text(key string, args ...interface{}) string {
+	text := localizer.message(key)
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// HTML is the message translated and escaped to go in an email, formatted with arguments which are already HTML,
+// e.g. links
+func (localizer Localizer) HTML(key string, args ...interface{}) string {
+	text := html.EscapeString(localizer.message(key))
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Date is the day as written in the locale, e.g. 2 Jan 2006
+func (localizer Localizer) Date(t time.Time) string {
+	return strings.NewReplacer(
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", localizer.message("month."+strconv.Itoa(int(t.Month()))),
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(localizer.message("format.date"))
+}
+
+// DateTime is the day and time to the minute as written in the locale, e.g. 2 Jan 2006 15:04
+func (localizer Localizer) DateTime(t time.Time) string {
+	return strings.NewReplacer(
+		"{date}", localizer.Date(t),
+		"{time}", t.Format("15:04"),
+	).Replace(localizer.message("format.dateTime"))
+}
@@ -0,0 +1,99 @@
+package i18n
+
+// The messages the plugin ships with. Every message is in English, which the other locales fall back to.
+// Formatted messages keep their verbs in the same order, as they're filled in positionally.
+var builtin = Messages{
+	"en": {
+		"format.date":     "{day} {month} {year}",
+		"format.dateTime": "{date} {time}",
+		"month.1":         "Jan",
+		"month.2":         "Feb",
+		"month.3":         "Mar",
+		"month.4":         "Apr",
+		"month.5":         "May",
+		"month.6":         "Jun",
+		"month.7":         "Jul",
+		"month.8":         "Aug",
+		"month.9":         "Sep",
+		"month.10":        "Oct",
+		"month.11":        "Nov",
+		"month.12":        "Dec",
+
+		"email.download":        "Download %s.",
+		"email.tooLarge":        "This report is too large to attach, it can be downloaded %s.",
+		"email.tooLargeLink":    "here",
+		"email.unsubscribe":     "Don't want this report any more? %s.",
+		"email.unsubscribeLink": "Unsubscribe",
+		"email.part":            "%s (Part %d of %d)",
+		"email.acknowledge":     "Please confirm you have reviewed this report: %s.",
+		"email.acknowledgeLink": "Mark as reviewed",
+		"email.encrypted":       "The files are protected with a password, which is given to you separately.",
+
+		"report.generated": "Generated %s",
+		"report.noData":    "No data",
+		"report.period":    "%s - %s",
+		"report.truncated": "Showing the first %d of %d rows and %d of %d columns",
+	},
+	"fr": {
+		"format.date":     "{day} {month} {year}",
+		"format.dateTime": "{date} à {time}",
+		"month.1":         "janv.",
+		"month.2":         "févr.",
+		"month.3":         "mars",
+		"month.4":         "avr.",
+		"month.5":         "mai",
+		"month.6":         "juin",
+		"month.7":         "juil.",
+		"month.8":         "août",
+		"month.9":         "sept.",
+		"month.10":        "oct.",
+		"month.11":        "nov.",
+		"month.12":        "déc.",
+
+		"email.download":        "Télécharger %s.",
+		"email.tooLarge":        "Ce rapport est trop volumineux pour être joint, il peut être téléchargé %s.",
+		"email.tooLargeLink":    "ici",
+		"email.unsubscribe":     "Vous ne souhaitez plus recevoir ce rapport ? %s.",
+		"email.unsubscribeLink": "Se désabonner",
+		"email.part":            "%s (partie %d sur %d)",
+		"email.acknowledge":     "Merci de confirmer que vous avez consulté ce rapport : %s.",
+		"email.acknowledgeLink": "Marquer comme consulté",
+		"email.encrypted":       "Les fichiers sont protégés par un mot de passe, qui vous est communiqué séparément.",
+
+		"report.generated": "Généré le %s",
+		"report.noData":    "Aucune donnée",
+		"report.period":    "Du %s au %s",
+		"report.truncated": "Affichage des %d premières lignes sur %d et des %d premières colonnes sur %d",
+	},
+	"lo": {
+		"format.date":     "{day} {month} {year}",
+		"format.dateTime": "{date} {time}",
+		"month.1":         "ມັງກອນ",
+		"month.2":         "ກຸມພາ",
+		"month.3":         "ມີນາ",
+		"month.4":         "ເມສາ",
+		"month.5":         "ພຶດສະພາ",
+		"month.6":         "ມິຖຸນາ",
+		"month.7":         "ກໍລະກົດ",
+		"month.8":         "ສິງຫາ",
+		"month.9":         "ກັນຍາ",
+		"month.10":        "ຕຸລາ",
+		"month.11":        "ພະຈິກ",
+		"month.12":        "ທັນວາ",
+
+		"email.download":        "ດາວໂຫລດ %s.",
+		"email.tooLarge":        "ລາຍງານນີ້ໃຫຍ່ເກີນໄປທີ່ຈະແນບ, ສາມາດດາວໂຫລດໄດ້ %s.",
+		"email.tooLargeLink":    "ທີ່ນີ້",
+		"email.unsubscribe":     "ບໍ່ຕ້ອງການຮັບລາຍງານນີ້ອີກບໍ? %s.",
+		"email.unsubscribeLink": "ຍົກເລີກການຮັບ",
+		"email.part":            "%s (ພາກທີ %d ຈາກ %d)",
+		"email.acknowledge":     "ກະລຸນາຢືນຢັນວ່າທ່ານໄດ້ກວດເບິ່ງລາຍງານນີ້ແລ້ວ: %s.",
+		"email.acknowledgeLink": "ໝາຍວ່າກວດເບິ່ງແລ້ວ",
+		"email.encrypted":       "ໄຟລ໌ຖືກປ້ອງກັນດ້ວຍລະຫັດຜ່ານ, ເຊິ່ງຈະແຈ້ງໃຫ້ທ່ານແຍກຕ່າງຫາກ.",
+
+		"report.generated": "ສ້າງເມື່ອ %s",
+		"report.noData":    "ບໍ່ມີຂໍ້ມູນ",
+		"report.period":    "%s - %s",
+		"report.truncated": "ສະແດງ %d ແຖວທຳອິດຈາກ %d ແຖວ ແລະ %d ຖັນທຳອິດຈາກ %d ຖັນ",
+	},
+}
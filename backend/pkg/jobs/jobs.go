@@ -0,0 +1,280 @@
+// Package jobs runs reports through a queue kept in the plugin's database, so a report queued before a restart is
+// still generated after it, and every instance sharing the database takes its share of the queue without going
+// over the settings' limit on reports generated at the same time.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/progress"
+)
+
+// Kinds of job
+const (
+	KindScheduled = "scheduled"
+	KindTest      = "test"
+)
+
+// Kinds of job which can be run again after stopping part way through sending, as scheduled jobs only send to
+// those still waiting in the outbox. Test emails have no outbox, so aren't sent twice.
+var Resumable = []string{KindScheduled}
+
+// A running job whose instance hasn't said it's still running it for this long is queued again, as the instance
+// has stopped. Instances say so every time they work through the queue, which is well within it.
+const StaleAfter = 2 * time.Minute
+
+// How long finished jobs are kept to be listed
+const KeepFinished = 7 * 24 * time.Hour
+
+// How many finished jobs are listed with the queue
+const FinishedListed = 20
+
+// Instance identifies this instance in the jobs it runs, unique even when instances share a host name
+var Instance = instanceID()
+
+func instanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "grafana"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])
+}
+
+// Handler does a job, stopping when ctx is cancelled
+type Handler func(ctx context.Context, job dbstore.Job) error
+
+// Pool works through the queue of a database, running each job with the handler of its kind
+type Pool struct {
+	sql *dbstore.SQLiteDatasource
+
+	mutex     sync.Mutex
+	handlers  map[string]Handler
+	cancelled map[string]func(job dbstore.Job)
+	// Cancels each job this instance is running
+	running map[string]context.CancelFunc
+}
+
+func NewPool(sql *dbstore.SQLiteDatasource) *Pool {
+	return &Pool{sql: sql, handlers: make(map[string]Handler), cancelled: make(map[string]func(job dbstore.Job)), running: make(map[string]context.CancelFunc)}
+}
+
+// Handle sets the handler running jobs of the kind, and what's done when one is cancelled before it started
+func (pool *Pool) Handle(kind string, handler Handler, cancelled func(job dbstore.Job)) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.handlers[kind] = handler
+	if cancelled != nil {
+		pool.cancelled[kind] = cancelled
+	}
+}
+
+// Limit is how many jobs can run at the same time across every instance
+func Limit(settings *dbstore.Settings) int {
+	if settings != nil && settings.MaxConcurrentReports > 0 {
+		return settings.MaxConcurrentReports
+	}
+	return 1
+}
+
+// Enqueue queues a job and starts it straight away if there's a free slot
+func (pool *Pool) Enqueue(job dbstore.Job) (*dbstore.Job, error) {
+	queued, err := pool.sql.CreateJob(job)
+	if err != nil {
+		return nil, err
+	}
+
+	go pool.Work()
+	return queued, nil
+}
+
+// Work says the jobs this instance is running are still running, stopping those which have been cancelled, then
+// starts the queued jobs which are due while there are free slots. Nothing is started in read-only mode, the queue
+// waiting until it is switched off.
+func (pool *Pool) Work() {
+	if _, err := pool.sql.RequeueStaleJobs(time.Now().Add(-StaleAfter), Resumable); err != nil {
+		log.DefaultLogger.Error("Pool.Work: RequeueStaleJobs: " + err.Error())
+	}
+	if _, err := pool.sql.DeleteFinishedJobs(time.Now().Add(-KeepFinished)); err != nil {
+		log.DefaultLogger.Error("Pool.Work: DeleteFinishedJobs: " + err.Error())
+	}
+
+	pool.mutex.Lock()
+	ids := []string{}
+	for id := range pool.running {
+		ids = append(ids, id)
+	}
+	pool.mutex.Unlock()
+
+	cancelled, err := pool.sql.Heartbeat(ids)
+	if err != nil {
+		log.DefaultLogger.Error("Pool.Work: Heartbeat: " + err.Error())
+	}
+	for _, id := range cancelled {
+		pool.stop(id)
+	}
+
+	settings, err := pool.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("Pool.Work: GetSettings: " + err.Error())
+		return
+	}
+	if settings.ReadOnly {
+		return
+	}
+
+	for {
+		job, err := pool.sql.ClaimJob(Instance, Limit(settings))
+		if err != nil {
+			log.DefaultLogger.Error("Pool.Work: ClaimJob: " + err.Error())
+			return
+		}
+		if job == nil {
+			return
+		}
+		pool.start(*job)
+	}
+}
+
+// start runs a job which has been claimed, recording how it ended and moving on to the next in the queue
+func (pool *Pool) start(job dbstore.Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pool.mutex.Lock()
+	handler := pool.handlers[job.Kind]
+	pool.running[job.ID] = cancel
+	pool.mutex.Unlock()
+
+	// The job's handler counts its progress in the context it's given
+	tracker := progress.NewTracker(func(counts progress.Progress) {
+		if err := pool.sql.UpdateJobProgress(job.ID, counts); err != nil {
+			log.DefaultLogger.Error("Pool.start: UpdateJobProgress: " + err.Error())
+		}
+	})
+
+	log.DefaultLogger.Info(fmt.Sprintf("Starting %s job %s of '%s'", job.Kind, job.ID, job.ScheduleName))
+	go func() {
+		status, message := dbstore.JobStatusSucceeded, ""
+		err := pool.run(progress.WithTracker(ctx, tracker), handler, job)
+		tracker.Flush()
+		if ctx.Err() != nil {
+			status, message = dbstore.JobStatusCancelled, ""
+		} else if err != nil {
+			status, message = dbstore.JobStatusFailed, err.Error()
+		}
+
+		pool.mutex.Lock()
+		delete(pool.running, job.ID)
+		pool.mutex.Unlock()
+		cancel()
+
+		if err := pool.sql.FinishJob(job.ID, status, message); err != nil {
+			log.DefaultLogger.Error("Pool.start: FinishJob: " + err.Error())
+		}
+		log.DefaultLogger.Info(fmt.Sprintf("The %s job %s of '%s' %s", job.Kind, job.ID, job.ScheduleName, status))
+
+		pool.Work()
+	}()
+}
+
+// run calls the handler, turning a panic into the job failing so it doesn't take the plugin down with it
+func (pool *Pool) run(ctx context.Context, handler Handler, job dbstore.Job) (err error) {
+	if handler == nil {
+		return errors.New("there is nothing to run jobs of the kind " + job.Kind)
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.DefaultLogger.Error(fmt.Sprintf("Pool.run: the %s job %s panicked: %v", job.Kind, job.ID, recovered))
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// stop cancels a job if this instance is running it
+func (pool *Pool) stop(id string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if cancel, ok := pool.running[id]; ok {
+		cancel()
+	}
+}
+
+// Cancel cancels a queued job, or stops a running one. One running on another instance stops the next time that
+// instance works through the queue.
+func (pool *Pool) Cancel(id string) (*dbstore.Job, error) {
+	before, err := pool.sql.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := pool.sql.CancelJob(id)
+	if err != nil {
+		return job, err
+	}
+
+	if before.Status == dbstore.JobStatusQueued && job.Status == dbstore.JobStatusCancelled {
+		pool.mutex.Lock()
+		cancelled := pool.cancelled[job.Kind]
+		pool.mutex.Unlock()
+		if cancelled != nil {
+			cancelled(*job)
+		}
+	}
+	pool.stop(id)
+	return job, nil
+}
+
+// QueuedJob is a job with its place in the queue, 1 being next, for queued jobs which are due
+type QueuedJob struct {
+	dbstore.Job
+	Position int `json:"position,omitempty"`
+}
+
+// Queue is the running jobs, then the queued jobs in the order they will start, then the recently finished jobs
+type Queue struct {
+	Limit    int           `json:"limit"`
+	Jobs     []QueuedJob   `json:"jobs"`
+	Finished []dbstore.Job `json:"finished"`
+}
+
+// Queue lists the jobs of the database, with the most which can run at the same time
+func (pool *Pool) Queue() (*Queue, error) {
+	settings, err := pool.sql.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := pool.sql.GetActiveJobs()
+	if err != nil {
+		return nil, err
+	}
+	finished, err := pool.sql.GetFinishedJobs(FinishedListed)
+	if err != nil {
+		return nil, err
+	}
+
+	queue := &Queue{Limit: Limit(settings), Jobs: []QueuedJob{}, Finished: finished}
+	now := int(time.Now().Unix())
+	position := 0
+	for _, job := range active {
+		queued := QueuedJob{Job: job}
+		// Those spread out to start later don't have a place until they're due
+		if job.Status == dbstore.JobStatusQueued && job.StartAt <= now {
+			position++
+			queued.Position = position
+		}
+		queue.Jobs = append(queue.Jobs, queued)
+	}
+	return queue, nil
+}
@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/progress"
+)
+
+// stoppedWhileSending is a job of the kind which its instance stopped running as it was sending
+func stoppedWhileSending(t *testing.T, store *dbstore.SQLiteDatasource, kind string) string {
+	t.Helper()
+	job, err := store.CreateJob(dbstore.Job{Kind: kind, ScheduleID: "weekly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimed, err := store.ClaimJob("stopped", 10)
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("expected to claim %s, got %v %v", job.ID, claimed, err)
+	}
+	if err := store.UpdateJobProgress(job.ID, progress.Progress{Stage: progress.StageSending, EmailsTotal: 3, EmailsSent: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return job.ID
+}
+
+func TestRequeueStaleJobsOnlyResumesFromOutbox(t *testing.T) {
+	store := &dbstore.SQLiteDatasource{Path: filepath.Join(t.TempDir(), "msupply.db")}
+	store.Init()
+
+	scheduled := stoppedWhileSending(t, store, KindScheduled)
+	test := stoppedWhileSending(t, store, KindTest)
+
+	// Long enough later that neither instance has said it's still running them
+	if _, err := store.RequeueStaleJobs(time.Now().Add(time.Minute), Resumable); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := store.GetJob(scheduled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != dbstore.JobStatusQueued {
+		t.Errorf("expected the scheduled job to be queued to resume from the outbox, got %s", job.Status)
+	}
+
+	job, err = store.GetJob(test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != dbstore.JobStatusFailed {
+		t.Errorf("expected the test email which had started sending not to be sent again, got %s", job.Status)
+	}
+}
@@ -0,0 +1,320 @@
+// Package loadtest drives synthetic schedules through the report pipeline against mock delivery,
+// to find how many reports a deployment can handle and which stage limits it. The schedules are made in a database
+// of the load test's own, so the plugin's scheduler never sees them.
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/reporter"
+)
+
+// Stages of the pipeline which are timed
+const (
+	StageDatabase   = "database"
+	StageWrite      = "write"
+	StageAttachment = "attachment"
+	StageDelivery   = "delivery"
+)
+
+const maxSchedules = 1000
+
+type Options struct {
+	Schedules         int `json:"schedules"`
+	PanelsPerSchedule int `json:"panelsPerSchedule"`
+	RowsPerPanel      int `json:"rowsPerPanel"`
+	Recipients        int `json:"recipients"`
+	// Number of schedules run at the same time
+	Concurrency int `json:"concurrency"`
+	// Milliseconds the mock mail server takes to accept each email
+	SendDelay int `json:"sendDelay"`
+	// Leave the load test's database, with the synthetic schedules and their run history, afterwards
+	Keep bool `json:"keep"`
+}
+
+func OptionsFields() string {
+	return "\n{\n\tschedules int" +
+		"\n\tpanelsPerSchedule int" +
+		"\n\trowsPerPanel int" +
+		"\n\trecipients int" +
+		"\n\tconcurrency int" +
+		"\n\tsendDelay int" +
+		"\n\tkeep bool\n}"
+}
+
+func (options *Options) Validate() error {
+	if options.Schedules <= 0 || options.Schedules > maxSchedules {
+		return fmt.Errorf("schedules must be between 1 and %d", maxSchedules)
+	}
+	if options.PanelsPerSchedule < 0 || options.RowsPerPanel < 0 || options.Recipients < 0 || options.Concurrency < 0 || options.SendDelay < 0 {
+		return errors.New("options can't be negative")
+	}
+
+	return nil
+}
+
+func (options *Options) applyDefaults() {
+	if options.PanelsPerSchedule == 0 {
+		options.PanelsPerSchedule = 5
+	}
+	if options.RowsPerPanel == 0 {
+		options.RowsPerPanel = 100
+	}
+	if options.Recipients == 0 {
+		options.Recipients = 10
+	}
+	if options.Concurrency == 0 {
+		options.Concurrency = 1
+	}
+}
+
+// StageTiming is how long a stage took across every schedule, in milliseconds
+type StageTiming struct {
+	Total float64 `json:"total"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+}
+
+type Result struct {
+	Options          Options                `json:"options"`
+	Reports          int                    `json:"reports"`
+	Failed           int                    `json:"failed"`
+	Errors           []string               `json:"errors"`
+	Duration         float64                `json:"duration"`
+	ReportsPerSecond float64                `json:"reportsPerSecond"`
+	EmailsPerSecond  float64                `json:"emailsPerSecond"`
+	Stages           map[string]StageTiming `json:"stages"`
+	// The stage which took the most time overall
+	Bottleneck string `json:"bottleneck"`
+	// Where the load test's database was left, when it was kept
+	Database string `json:"database,omitempty"`
+}
+
+type timings struct {
+	mutex     sync.Mutex
+	durations map[string][]time.Duration
+}
+
+func (t *timings) record(stage string, started time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.durations[stage] = append(t.durations[stage], time.Since(started))
+}
+
+func (t *timings) stages() map[string]StageTiming {
+	stages := make(map[string]StageTiming)
+	for stage, durations := range t.durations {
+		var timing StageTiming
+		for _, duration := range durations {
+			ms := float64(duration) / float64(time.Millisecond)
+			timing.Total += ms
+			if ms > timing.Max {
+				timing.Max = ms
+			}
+		}
+		timing.Mean = timing.Total / float64(len(durations))
+		stages[stage] = timing
+	}
+
+	return stages
+}
+
+type LoadTest struct {
+	// The load test's own database and the directory it and the reports are written in
+	db        *dbstore.SQLiteDatasource
+	directory string
+	options   Options
+	timings   *timings
+}
+
+func New(options Options) *LoadTest {
+	options.applyDefaults()
+	return &LoadTest{options: options, timings: &timings{durations: make(map[string][]time.Duration)}}
+}
+
+// Run creates the synthetic schedules in a temporary database and sends each of their reports, stopping early if
+// the context is done. The database is removed afterwards unless it's kept.
+func (lt *LoadTest) Run(ctx context.Context) (*Result, error) {
+	if err := lt.options.Validate(); err != nil {
+		return nil, err
+	}
+
+	directory, err := ioutil.TempDir("", "loadtest")
+	if err != nil {
+		log.DefaultLogger.Error("LoadTest.Run: ioutil.TempDir(): " + err.Error())
+		return nil, err
+	}
+	lt.directory = directory
+	lt.db = &dbstore.SQLiteDatasource{Path: filepath.Join(directory, "msupply.db")}
+	lt.db.Init()
+
+	log.DefaultLogger.Info(fmt.Sprintf("Starting load test in %s: %+v", directory, lt.options))
+	started := time.Now()
+
+	result := Result{Options: lt.options, Errors: []string{}}
+	if lt.options.Keep {
+		result.Database = lt.db.Path
+	} else {
+		defer os.RemoveAll(directory)
+	}
+	var mutex sync.Mutex
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < lt.options.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				err := lt.runSchedule(ctx, i)
+
+				mutex.Lock()
+				result.Reports++
+				if err != nil {
+					result.Failed++
+					result.Errors = append(result.Errors, err.Error())
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < lt.options.Schedules && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	result.Duration = time.Since(started).Seconds()
+	if result.Duration > 0 {
+		sent := float64(result.Reports - result.Failed)
+		result.ReportsPerSecond = sent / result.Duration
+		result.EmailsPerSecond = sent * float64(lt.options.Recipients) / result.Duration
+	}
+
+	result.Stages = lt.timings.stages()
+	var stages []string
+	for stage := range result.Stages {
+		stages = append(stages, stage)
+	}
+	sort.Slice(stages, func(i, j int) bool { return result.Stages[stages[i]].Total > result.Stages[stages[j]].Total })
+	if len(stages) > 0 {
+		result.Bottleneck = stages[0]
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("Load test finished: %d reports in %.1fs, bottleneck: %s", result.Reports, result.Duration, result.Bottleneck))
+	return &result, nil
+}
+
+func mockPanels(name string, panelCount int, rowCount int) []api.TablePanel {
+	columns := []api.Column{{Text: "Item"}, {Text: "Store"}, {Text: "Date"}, {Text: "Quantity"}, {Text: "Value"}}
+
+	var panels []api.TablePanel
+	for p := 0; p < panelCount; p++ {
+		var rows [][]interface{}
+		for r := 0; r < rowCount; r++ {
+			date := time.Now().AddDate(0, 0, -r).Format(time.RFC3339)
+			rows = append(rows, []interface{}{fmt.Sprintf("Item %d", r), fmt.Sprintf("Store %d", r%7), date, float64(r * 3), float64(r) * 1.25})
+		}
+
+		panel := api.TablePanel{ID: p + 1, Title: fmt.Sprintf("%s panel %d", name, p+1)}
+		panel.SetColumns(columns)
+		panel.SetRows(rows)
+		panels = append(panels, panel)
+	}
+
+	return panels
+}
+
+// runSchedule takes one synthetic schedule through the same steps as a real report
+func (lt *LoadTest) runSchedule(ctx context.Context, index int) error {
+	stageStarted := time.Now()
+	schedule, err := lt.db.CreateSchedule("loadtest")
+	if err != nil {
+		return err
+	}
+	schedule.Name = fmt.Sprintf("Load test %d %s", index+1, schedule.ID[:8])
+	if _, err := lt.db.UpdateSchedule(schedule.ID, *schedule); err != nil {
+		return err
+	}
+	for p := 0; p < lt.options.PanelsPerSchedule; p++ {
+		content := dbstore.ReportContent{ScheduleID: schedule.ID, DashboardID: "loadtest", PanelID: p + 1, PanelTitle: fmt.Sprintf("Panel %d", p+1)}
+		if _, err := lt.db.CreateReportContent(content); err != nil {
+			return err
+		}
+	}
+	run, err := lt.db.CreateReportRun(schedule.ID, 0)
+	if err != nil {
+		return err
+	}
+	lt.timings.record(StageDatabase, stageStarted)
+
+	err = lt.sendReport(ctx, *schedule, run)
+
+	stageStarted = time.Now()
+	run.FinishedAt = int(time.Now().Unix())
+	run.Status = dbstore.ReportRunStatusSent
+	if err != nil {
+		run.Status = dbstore.ReportRunStatusFailed
+		run.Message = err.Error()
+	}
+	if updateErr := lt.db.UpdateReportRun(*run); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	lt.timings.record(StageDatabase, stageStarted)
+
+	return err
+}
+
+func (lt *LoadTest) sendReport(ctx context.Context, schedule dbstore.Schedule, run *dbstore.ReportRun) error {
+	stageStarted := time.Now()
+	panelReporter := reporter.NewReporter(reporter.GetFilePath("template"))
+	options := reporter.DefaultOptions()
+	options.Prefetched = true
+	options.Directory = lt.directory
+	panelReporter.SetOptions(options)
+
+	report := panelReporter.CreateNewReport(schedule.ID, schedule.Name)
+	report.SetSheets(mockPanels(schedule.Name, lt.options.PanelsPerSchedule, lt.options.RowsPerPanel))
+	if err := report.Write(ctx, auth.AuthConfig{}); err != nil {
+		return err
+	}
+	lt.timings.record(StageWrite, stageStarted)
+
+	stageStarted = time.Now()
+	em := emailer.New(&auth.EmailConfig{MaxAttachmentSize: auth.DefaultMaxAttachmentSize})
+	attachment, err := em.PrepareAttachment(report.FilePath("xlsx"))
+	if err != nil {
+		return err
+	}
+	run.AttachmentMode = attachment.Mode
+	lt.timings.record(StageAttachment, stageStarted)
+
+	stageStarted = time.Now()
+	delay := time.Duration(lt.options.SendDelay) * time.Millisecond
+	for r := 0; r < lt.options.Recipients; r++ {
+		select {
+		case <-time.After(delay):
+			run.MessagesSent++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	lt.timings.record(StageDelivery, stageStarted)
+
+	return nil
+}
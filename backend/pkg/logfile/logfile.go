@@ -0,0 +1,273 @@
+// Package logfile writes the plugin's logs to a file of its own, rotated by size, so long running installs don't
+// fill Grafana's log with the plugin's output. It is set up for the whole process from the environment, as
+// organisations' settings can't each have their own file.
+package logfile
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The path of the plugin's log file. Without it the plugin logs to Grafana's log as before.
+const FileEnv = "MSUPPLY_LOG_FILE"
+
+// Megabytes the log file grows to before it is rotated
+const MaxSizeEnv = "MSUPPLY_LOG_MAX_SIZE"
+
+// Number of rotated files kept, the oldest being deleted beyond it
+const MaxFilesEnv = "MSUPPLY_LOG_MAX_FILES"
+
+// Set to "false" to keep rotated files as they are, rather than gzipping them
+const CompressEnv = "MSUPPLY_LOG_COMPRESS"
+
+const (
+	DefaultMaxSize  = 10
+	DefaultMaxFiles = 5
+)
+
+// Rotated files are named after the log file and the time they were rotated, which sorts oldest first
+const rotatedTimeLayout = "20060102-150405.000000"
+
+// Configure sends the plugin's logs to the file named in the environment, if there is one. Errors are still logged
+// to Grafana's log too, so they aren't missed by those only watching Grafana.
+func Configure() error {
+	path := os.Getenv(FileEnv)
+	if path == "" {
+		return nil
+	}
+
+	maxSize, err := envInt(MaxSizeEnv, DefaultMaxSize)
+	if err != nil {
+		return err
+	}
+	maxFiles, err := envInt(MaxFilesEnv, DefaultMaxFiles)
+	if err != nil {
+		return err
+	}
+
+	writer, err := NewWriter(path, int64(maxSize)*1024*1024, maxFiles, os.Getenv(CompressEnv) != "false")
+	if err != nil {
+		return err
+	}
+
+	log.DefaultLogger = &Logger{out: writer, grafana: log.DefaultLogger}
+	return nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("%s must be a whole number above 0", name)
+	}
+	return number, nil
+}
+
+// Writer appends to a log file, moving it aside once it reaches maxSize bytes and keeping at most maxFiles of those
+// moved aside, gzipped when compress is set
+type Writer struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	// Held while rotated files are compressed and pruned in the background
+	tidying sync.Mutex
+}
+
+func NewWriter(path string, maxSize int64, maxFiles int, compress bool) (*Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	writer := &Writer{path: path, maxSize: maxSize, maxFiles: maxFiles, compress: compress}
+	if err := writer.open(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *Writer) open() error {
+	file, err := os.OpenFile(writer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer.file, writer.size = file, info.Size()
+	return nil
+}
+
+// Write appends to the log file, rotating it first when p would take it past its size. A single write larger than
+// the size is still written whole.
+func (writer *Writer) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.size > 0 && writer.size+int64(len(p)) > writer.maxSize {
+		if err := writer.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := writer.file.Write(p)
+	writer.size += int64(n)
+	return n, err
+}
+
+func (writer *Writer) rotate() error {
+	if err := writer.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := writer.path + "." + time.Now().Format(rotatedTimeLayout)
+	if err := os.Rename(writer.path, rotated); err != nil {
+		return err
+	}
+	if err := writer.open(); err != nil {
+		return err
+	}
+
+	go writer.tidy(rotated)
+	return nil
+}
+
+// tidy compresses a file which has just been rotated and deletes the oldest beyond maxFiles. Failures can't be
+// logged, as that would write to the file being tidied, so they go to Grafana's log.
+func (writer *Writer) tidy(rotated string) {
+	writer.tidying.Lock()
+	defer writer.tidying.Unlock()
+
+	if writer.compress {
+		if err := compress(rotated); err != nil {
+			fmt.Fprintln(os.Stderr, "logfile: compressing "+rotated+": "+err.Error())
+		}
+	}
+
+	if err := writer.prune(); err != nil {
+		fmt.Fprintln(os.Stderr, "logfile: deleting old log files: "+err.Error())
+	}
+}
+
+// compress gzips a file next to it, deleting it once it's written
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zipped := gzip.NewWriter(out)
+	if _, err = io.Copy(zipped, in); err == nil {
+		err = zipped.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	in.Close()
+	return os.Remove(path)
+}
+
+// prune deletes the oldest rotated files beyond maxFiles
+func (writer *Writer) prune() error {
+	rotated, err := filepath.Glob(writer.path + ".*")
+	if err != nil {
+		return err
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return strings.TrimSuffix(rotated[i], ".gz") < strings.TrimSuffix(rotated[j], ".gz")
+	})
+
+	for len(rotated) > writer.maxFiles {
+		if err := os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Logger writes each message to the log file as a line of JSON, in the form Grafana logs the plugin's messages
+type Logger struct {
+	out     io.Writer
+	grafana log.Logger
+}
+
+func (logger *Logger) Debug(msg string, args ...interface{}) {
+	logger.write("debug", msg, args)
+}
+
+func (logger *Logger) Info(msg string, args ...interface{}) {
+	logger.write("info", msg, args)
+}
+
+func (logger *Logger) Warn(msg string, args ...interface{}) {
+	logger.write("warn", msg, args)
+}
+
+func (logger *Logger) Error(msg string, args ...interface{}) {
+	logger.write("error", msg, args)
+	logger.grafana.Error(msg, args...)
+}
+
+// write logs the message with its arguments as pairs of keys and values. A leftover argument is logged as
+// EXTRA_VALUE_AT_END, as Grafana's logger does.
+func (logger *Logger) write(level string, msg string, args []interface{}) {
+	line := map[string]interface{}{}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			line["EXTRA_VALUE_AT_END"] = fmt.Sprint(args[i])
+			break
+		}
+		line[fmt.Sprint(args[i])] = logValue(args[i+1])
+	}
+	line["@level"] = level
+	line["@message"] = msg
+	line["@timestamp"] = time.Now().Format("2006-01-02T15:04:05.000000Z07:00")
+
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		encoded, _ = json.Marshal(map[string]interface{}{"@level": level, "@message": msg, "@timestamp": line["@timestamp"]})
+	}
+	logger.out.Write(append(encoded, '\n'))
+}
+
+func logValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return value
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
-	"os"
-
 	"github.com/bugsnag/bugsnag-go"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/bounce"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+	"github.com/grafana/simple-datasource-backend/pkg/logfile"
 	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+	"github.com/grafana/simple-datasource-backend/pkg/server"
+	"github.com/grafana/simple-datasource-backend/pkg/usersync"
 	"github.com/robfig/cron"
 )
 
@@ -27,6 +32,16 @@ func main() {
 		ReleaseStage: "production",
 	})
 
+	// Logs to the plugin's own rotated file instead of Grafana's log, when one is configured
+	if err := logfile.Configure(); err != nil {
+		log.DefaultLogger.Error("Could not open the plugin's log file, logging to Grafana's log: " + err.Error())
+	}
+
+	// Translations added by the deployment, e.g. for a language the plugin doesn't ship with
+	if err := i18n.UseDirectory(i18n.Directory); err != nil {
+		log.DefaultLogger.Error("Could not load the translations in " + i18n.Directory + ": " + err.Error())
+	}
+
 	log.DefaultLogger.Info("Starting up")
 	serveOptions, sql, err := getServeOptions()
 	if err != nil {
@@ -45,23 +60,55 @@ func main() {
 		return nil
 	})
 
-	re := reportEmailer.NewReportEmailer(sql)
+	// Sends the reports of every organisation's database, when their data is kept apart
+	re := reportEmailer.NewTenantEmailers(sql)
+	// When several Grafana servers share the database, only the one holding the lease runs the jobs below
+	leader := scheduler.NewLeader(sql)
+
+	// Backups used to be written to the data directory, and are moved out of it
+	sql.ForEachTenant((*dbstore.SQLiteDatasource).MoveLegacyBackups)
 
-	// Try to send reports on loading
-	re.CreateReports()
+	// Try to send reports on loading, catching up on any which were missed while Grafana was down
+	leader.Run(re.CreateReports)()
+
+	// Copies the active mSupply users for the report group member picker, in the background as Grafana may
+	// still be starting
+	syncUsers := leader.Run(func() { sql.ForEachTenant(func(tenant *dbstore.SQLiteDatasource) { usersync.New(tenant).Run() }) })
+	go syncUsers()
 
 	// Set up scheduler which will try to send reports every 10 minutes
 	c := cron.New()
-	c.AddFunc("@every 10m", re.CreateReports)
+	c.AddFunc("@every 10m", leader.Run(re.CreateReports))
+	// Backs up the database when the configured backup interval has passed
+	c.AddFunc("@every 10m", leader.Run(func() { sql.ForEachTenant((*dbstore.SQLiteDatasource).CreateScheduledBackup) }))
+	c.AddFunc("@every 1h", syncUsers)
+	// Deletes for good the schedules and report groups which have been in the trash for longer than the settings keep them
+	c.AddFunc("@every 1h", leader.Run(func() { sql.ForEachTenant((*dbstore.SQLiteDatasource).PurgeExpiredTrash) }))
+	// Deletes the copies of shared reports once their links have expired or been revoked
+	c.AddFunc("@every 1h", leader.Run(func() { sql.ForEachTenant((*dbstore.SQLiteDatasource).PruneShareLinks) }))
+	// Cleans up rows left behind by schedules and report groups deleted before deletes cascaded
+	c.AddFunc("@every 24h", leader.Run(func() { sql.ForEachTenant((*dbstore.SQLiteDatasource).CleanOrphansJob) }))
+	// Lets the admin know the certificate emails are signed with is about to expire, while there's time to replace it
+	c.AddFunc("@every 24h", leader.Run(re.WarnSigningExpiry))
+	// Records the bounces sent back to the sending address, when a bounce mailbox is configured
+	c.AddFunc("@every 15m", leader.Run(func() { sql.ForEachTenant(func(tenant *dbstore.SQLiteDatasource) { bounce.New(tenant).Run() }) }))
+	// Generates the queued reports. Every instance takes its share of the queue, not just the leader, as each job
+	// can only be claimed once.
+	c.AddFunc("@every 15s", func() { sql.ForEachTenant(func(tenant *dbstore.SQLiteDatasource) { reportEmailer.Workers(tenant).Work() }) })
+	// Keeps hold of the lease, or takes it over when the leader has stopped renewing it
+	c.AddFunc("@every 30s", func() { leader.Renew() })
 	c.Start()
 
+	// Serves the links in reports' emails to recipients who aren't Grafana users
+	go server.ServePublic(sql)
+
 	// Start listening to requests sent from Grafana. This call is blocking and
 	// and waits until Grafana shutsdown or the plugin exits.
 	err = datasource.Serve(serveOptions)
+	leader.Release()
 
 	if err != nil {
 		log.DefaultLogger.Error("FATAL. Plugin datasource.Serve() returned an error: " + err.Error())
 		panic(err)
-		os.Exit(1)
 	}
 }
@@ -0,0 +1,129 @@
+// Package metrics exposes Prometheus metrics for the report pipeline, so operators
+// can alert when reports fail or silently stop being sent.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "msupply_reports"
+
+var (
+	// Reports finished, by status of the run: sent, partial or failed
+	ReportsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reports_total",
+		Help:      "Reports generated, by the status of the run.",
+	}, []string{"status"})
+
+	LastReportSent = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_report_sent_timestamp_seconds",
+		Help:      "Unix time the last report was sent.",
+	})
+
+	RenderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "render_duration_seconds",
+		Help:      "Time taken to fetch a panel's data and image from Grafana.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"result"})
+
+	EmailSendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "email_send_duration_seconds",
+		Help:      "Time taken to send a single email.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"result"})
+
+	EmailQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "email_queue_depth",
+		Help:      "Emails waiting to be sent by the reports being sent now.",
+	})
+
+	EmailPacingDelay = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "email_pacing_delay_seconds",
+		Help:      "Time emails waited to keep under the send rate limit, or for the mail server to stop throttling.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	})
+
+	EmailsThrottledTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_throttled_total",
+		Help:      "Emails the mail server deferred for sending too many, which were tried again.",
+	})
+
+	ImagesReusedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "images_reused_total",
+		Help:      "Panel images reused from the schedule's previous run as the panel's data hadn't changed.",
+	})
+
+	SchedulerLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduler_lag_seconds",
+		Help:      "Time between a report being due and starting to generate it.",
+		Buckets:   []float64{60, 300, 600, 1800, 3600, 6 * 3600, 24 * 3600},
+	})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"operation"})
+
+	DBBusyTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_busy_total",
+		Help:      "Writes which found the SQLite database locked by another connection, by operation.",
+	}, []string{"operation"})
+
+	DBBusyFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_busy_failures_total",
+		Help:      "Writes which gave up as the SQLite database was still locked after every retry, by operation.",
+	}, []string{"operation"})
+)
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// ObserveRender records how long a panel took, call with the time it started
+func ObserveRender(started time.Time, err error) {
+	RenderDuration.WithLabelValues(result(err)).Observe(time.Since(started).Seconds())
+}
+
+func ObserveEmail(started time.Time, err error) {
+	EmailSendDuration.WithLabelValues(result(err)).Observe(time.Since(started).Seconds())
+}
+
+// ObserveDB is deferred at the start of a database operation
+func ObserveDB(operation string, started time.Time) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(started).Seconds())
+}
+
+// ObserveDBBusy records a write finding the database locked, and whether it gave up
+func ObserveDBBusy(operation string, failed bool) {
+	DBBusyTotal.WithLabelValues(operation).Inc()
+	if failed {
+		DBBusyFailuresTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// ReportFinished records a run of a report by its status, and whether its report was sent
+func ReportFinished(status string, sent bool) {
+	ReportsTotal.WithLabelValues(status).Inc()
+	if sent {
+		LastReportSent.SetToCurrentTime()
+	}
+}
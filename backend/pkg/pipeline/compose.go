@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+// GrafanaComposer composes reports from the panels of Grafana's dashboards, checked against the dashboards as they
+// are now. The schedule's owner must still be able to view each dashboard, and variables which have gone stale are
+// handled under the settings' policy.
+type GrafanaComposer struct {
+	// Where the masking rules are read from
+	DB         *dbstore.SQLiteDatasource
+	AuthConfig *auth.AuthConfig
+	// Only queried by panels using their dashboard's default datasource
+	DatasourceID int
+	Settings     *dbstore.Settings
+}
+
+func (composer GrafanaComposer) Compose(ctx context.Context, schedule dbstore.Schedule, reportContent []dbstore.ReportContent, run *dbstore.ReportRun) (*Composition, error) {
+	// Without the masking rules the report isn't composed at all, rather than risk writing what they'd hide
+	masker, err := composer.DB.Masker()
+	if err != nil {
+		log.DefaultLogger.Error("GrafanaComposer.Compose: Masker: " + err.Error())
+		return nil, err
+	}
+
+	panels := []api.TablePanel{}
+	// Where the panels of each content item start, which the report can be split between. Text is kept with the
+	// content after it, which it's usually introducing, unless that starts a section of its own.
+	sections := []int{}
+	afterText := false
+	// Heading of the section the next panel starts, as the content with it may have no panels
+	section := ""
+	add := func(panel api.TablePanel) {
+		panel.Section, section = section, ""
+		panels = append(panels, panel)
+	}
+	checked := make(map[string]bool)
+	variables := newVariableChecker(composer.AuthConfig, composer.Settings, run)
+
+	for _, content := range reportContent {
+		if (!afterText || content.SectionTitle != "") && (len(sections) == 0 || sections[len(sections)-1] < len(panels)) {
+			sections = append(sections, len(panels))
+		}
+		afterText = content.IsText()
+		if content.SectionTitle != "" {
+			section = content.SectionTitle
+		}
+		if content.IsText() {
+			add(*api.NewTextPanel(content.Text))
+			continue
+		}
+
+		if err := checkOwnerAccess(ctx, schedule, composer.AuthConfig, content.DashboardID, checked); err != nil {
+			return nil, err
+		}
+		from, to := reportPeriod(schedule, content, run)
+
+		// Panels query the datasource they use on their dashboard, unless the content is pinned to one, e.g. a
+		// country's database
+		var dashboard *api.Dashboard
+		var err error
+		if content.DatasourceID > 0 {
+			dashboard, err = api.NewDashboardUsing(ctx, composer.AuthConfig, content.DashboardID, from, to, content.DatasourceID)
+		} else {
+			dashboard, err = api.NewDashboard(ctx, composer.AuthConfig, content.DashboardID, from, to, composer.DatasourceID)
+		}
+
+		if err != nil {
+			log.DefaultLogger.Error("GrafanaComposer.Compose: NewDashboard: " + err.Error())
+			return nil, err
+		}
+
+		content.Variables = variables.check(ctx, dashboard, content, composer.DatasourceID)
+		renderOptions := resolveRenderOptions(composer.Settings, schedule, content)
+		queryTimeout := dbstore.ResolveSettings(composer.Settings, schedule, &content).QueryTimeout
+
+		// The whole dashboard as it's saved, its charts and other panels without a query only being included when
+		// images are rendered
+		if content.Type == dbstore.ReportContentTypeDashboard {
+			for _, panel := range dashboard.Layout {
+				if panel.ImageOnly && renderOptions == nil {
+					continue
+				}
+				panel.Masker = masker
+				panel.UseDashboardValues = true
+				panel.PrepSql(dashboard.Variables, content.Variables)
+				panel.RenderOptions = renderOptions
+				panel.ChartType = content.ChartType
+				panel.QueryTimeout = queryTimeout
+				add(panel)
+			}
+			continue
+		}
+
+		panel := dashboard.Panel(content.PanelID)
+		if panel != nil {
+			panel.Masker = masker
+			panel.PrepSql(dashboard.Variables, content.Variables)
+			panel.RenderOptions = renderOptions
+			panel.ChartType = content.ChartType
+			panel.QueryTimeout = queryTimeout
+			add(*panel)
+		}
+	}
+
+	if err := variables.err(); err != nil {
+		log.DefaultLogger.Error("GrafanaComposer.Compose: " + err.Error())
+		return nil, err
+	}
+
+	return &Composition{Panels: panels, Sections: sections, Masker: masker}, nil
+}
+
+// resolveRenderOptions layers the organisation defaults, schedule and content render settings.
+// Images are only rendered when a width has been configured by one of them.
+func resolveRenderOptions(settings *dbstore.Settings, schedule dbstore.Schedule, content dbstore.ReportContent) *api.RenderOptions {
+	effective := dbstore.ResolveSettings(settings, schedule, &content)
+	if effective.RenderWidth <= 0 {
+		return nil
+	}
+
+	options := api.RenderOptions{Width: effective.RenderWidth, Height: effective.RenderHeight, Scale: effective.RenderScale, Theme: effective.RenderTheme}
+	capped := options.Capped()
+	return &capped
+}
+
+// checkOwnerAccess checks the schedule's owner can still view a dashboard it reports on, as the report is generated
+// with the plugin's own Grafana user which can see every dashboard. Each dashboard is only checked once a report.
+// Schedules without an owner aren't checked. The report isn't generated while the owner's access can't be checked,
+// e.g. as the plugin's Grafana user isn't an admin able to read permissions.
+func checkOwnerAccess(ctx context.Context, schedule dbstore.Schedule, authConfig *auth.AuthConfig, dashboardID string, checked map[string]bool) error {
+	if schedule.Owner == "" || checked[dashboardID] {
+		return nil
+	}
+	checked[dashboardID] = true
+
+	err := api.CheckDashboardAccess(ctx, authConfig, schedule.Owner, "", dashboardID)
+	if errors.Is(err, api.ErrDashboardForbidden) {
+		log.DefaultLogger.Error("checkOwnerAccess: " + err.Error())
+		return fmt.Errorf("the schedule's owner %w", err)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("checkOwnerAccess: could not check " + schedule.Owner + " can view " + dashboardID + ": " + err.Error())
+		return fmt.Errorf("could not check the schedule's owner %s can view dashboard %s: %w", schedule.Owner, dashboardID, err)
+	}
+	return nil
+}
+
+// reportEnd is the end of the period a report covers. Runs sent late to catch up cover the period up until they
+// were due, so each missed report has the data it would have had, everything else covers up until now.
+func reportEnd(run *dbstore.ReportRun) int64 {
+	if run.ScheduledAt > 0 && time.Since(time.Unix(int64(run.ScheduledAt), 0)) > scheduler.MissedAfter {
+		return int64(run.ScheduledAt)
+	}
+	return time.Now().Unix()
+}
+
+// reportPeriod is the from and to, as unix seconds, of the data a content item's panels are queried and rendered for
+func reportPeriod(schedule dbstore.Schedule, content dbstore.ReportContent, run *dbstore.ReportRun) (string, string) {
+	end := reportEnd(run)
+	if from, to, ok := dbstore.LookbackPeriod(content.LookbackType, time.Unix(end, 0), schedule.Location()); ok {
+		return strconv.FormatInt(from.Unix(), 10), strconv.FormatInt(to.Unix(), 10)
+	}
+
+	// The lookback is chosen in milliseconds
+	lookback := int64(content.Lookback / 1000)
+	if lookback <= 0 {
+		lookback = int64(schedule.Interval)
+	}
+	return strconv.FormatInt(end-lookback, 10), strconv.FormatInt(end, 10)
+}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func TestCheckOwnerAccessFailsWhenAccessCantBeChecked(t *testing.T) {
+	// The plugin's Grafana user can't read users or permissions
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		http.Error(rw, "Forbidden", http.StatusForbidden)
+	}))
+	defer grafana.Close()
+	authConfig := &auth.AuthConfig{URL: grafana.URL, Username: "reports", Password: "s3cret", Mode: dbstore.AuthModeBasic}
+
+	schedule := dbstore.Schedule{ID: "weekly", Owner: "alice"}
+	if err := checkOwnerAccess(context.Background(), schedule, authConfig, "stock", make(map[string]bool)); err == nil {
+		t.Error("expected the report not to be generated while the owner's access can't be checked")
+	}
+
+	// Schedules from before owners are still generated
+	if err := checkOwnerAccess(context.Background(), dbstore.Schedule{ID: "old"}, authConfig, "stock", make(map[string]bool)); err != nil {
+		t.Errorf("expected ownerless schedules not to be checked, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// ErrConditionNotMet stops a report whose schedule's condition isn't met, which is recorded as skipped rather
+// than failed
+var ErrConditionNotMet = errors.New("condition not met")
+
+// CheckCondition evaluates a schedule's condition for a run before anything is rendered, returning an error
+// wrapping ErrConditionNotMet when the report shouldn't be sent. A panel in the condition is queried for the
+// period of content without a lookback of its own, with its dashboard's saved variables. A query or panel with no
+// result counts as 0.
+func CheckCondition(ctx context.Context, schedule dbstore.Schedule, run *dbstore.ReportRun, authConfig *auth.AuthConfig, datasourceID int) error {
+	if !schedule.HasCondition() {
+		return nil
+	}
+
+	value, err := conditionValue(ctx, schedule, run, authConfig, datasourceID)
+	if err != nil {
+		log.DefaultLogger.Error("CheckCondition: " + schedule.Name + ": " + err.Error())
+		return err
+	}
+
+	number := 0.0
+	if value != "" {
+		number, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("the condition's value %q isn't a number", value)
+		}
+	}
+
+	threshold := strconv.FormatFloat(schedule.ConditionThreshold, 'f', -1, 64)
+	if !schedule.ConditionMet(number) {
+		log.DefaultLogger.Info(fmt.Sprintf("Not sending '%s', its condition %v %s %s isn't met", schedule.Name, number, schedule.ConditionOperator, threshold))
+		return fmt.Errorf("%w: %v is not %s %s", ErrConditionNotMet, number, schedule.ConditionOperator, threshold)
+	}
+	return nil
+}
+
+// conditionValue is the first cell of the condition's query or panel, empty when it has none
+func conditionValue(ctx context.Context, schedule dbstore.Schedule, run *dbstore.ReportRun, authConfig *auth.AuthConfig, datasourceID int) (string, error) {
+	if schedule.ConditionDashboardID == "" {
+		return api.QueryValue(ctx, *authConfig, schedule.ConditionQuery, datasourceID)
+	}
+
+	from, to := reportPeriod(schedule, dbstore.ReportContent{}, run)
+	dashboard, err := api.NewDashboard(ctx, authConfig, schedule.ConditionDashboardID, from, to, datasourceID)
+	if err != nil {
+		return "", err
+	}
+	panel := dashboard.Panel(schedule.ConditionPanelID)
+	if panel == nil {
+		return "", fmt.Errorf("the condition's panel %d is no longer on its dashboard", schedule.ConditionPanelID)
+	}
+
+	// The condition selects no variables, so its panel queries what's selected on the dashboard
+	panel.UseDashboardValues = true
+	panel.PrepSql(dashboard.Variables, "")
+	if err := panel.GetData(ctx, *authConfig); err != nil {
+		return "", err
+	}
+	if len(panel.Rows) == 0 || len(panel.Rows[0]) == 0 || panel.Rows[0][0] == nil {
+		return "", nil
+	}
+	return fmt.Sprint(panel.Rows[0][0]), nil
+}
@@ -0,0 +1,56 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// StoreContent is a schedule's content as saved in the plugin's database. Its playlist's dashboards come first, then
+// its template's content when it uses one, then its own.
+type StoreContent struct {
+	DB         *dbstore.SQLiteDatasource
+	AuthConfig *auth.AuthConfig
+	// Version of the template the schedule uses, nil when it doesn't
+	Template *dbstore.ReportTemplateVersionInfo
+}
+
+func (source StoreContent) Content(ctx context.Context, schedule dbstore.Schedule) ([]dbstore.ReportContent, error) {
+	reportContent, err := source.DB.GetReportContent(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("StoreContent.Content: GetReportContent: " + err.Error())
+		return nil, err
+	}
+	if source.Template != nil {
+		reportContent = append(source.Template.ContentFor(source.Template.TemplateID, schedule), reportContent...)
+	}
+
+	if schedule.PlaylistUID != "" {
+		playlistContent, err := playlistContent(ctx, schedule, source.AuthConfig)
+		if err != nil {
+			log.DefaultLogger.Error("StoreContent.Content: playlistContent: " + err.Error())
+			return nil, err
+		}
+		reportContent = append(playlistContent, reportContent...)
+	}
+
+	return reportContent, nil
+}
+
+// playlistContent is every dashboard in the schedule's playlist as whole dashboard content, in the playlist's order,
+// queried for the schedule's lookback with the variables saved on each dashboard
+func playlistContent(ctx context.Context, schedule dbstore.Schedule, authConfig *auth.AuthConfig) ([]dbstore.ReportContent, error) {
+	uids, err := api.GetPlaylistDashboards(ctx, authConfig, schedule.PlaylistUID)
+	if err != nil {
+		return nil, err
+	}
+
+	content := []dbstore.ReportContent{}
+	for _, uid := range uids {
+		content = append(content, dbstore.ReportContent{ScheduleID: schedule.ID, DashboardID: uid, Type: dbstore.ReportContentTypeDashboard})
+	}
+	return content, nil
+}
@@ -0,0 +1,17 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+)
+
+// EmailChannel emails a report's files to its recipients, each on their own
+type EmailChannel struct {
+	Emailer emailer.Emailer
+}
+
+func (channel EmailChannel) Deliver(ctx context.Context, delivery Delivery) (DeliveryResult, error) {
+	mode, sent, failures, err := channel.Emailer.BulkCreateAndSend(ctx, delivery.Files, delivery.Recipients, delivery.Subject, delivery.Body, delivery.UnsubscribeLinks, delivery.AcknowledgeLinks, delivery.Delivered)
+	return DeliveryResult{Sent: sent, Failures: failures, AttachmentMode: mode}, err
+}
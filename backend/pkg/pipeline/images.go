@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// panelImages reuses the images a schedule's previous run rendered, and keeps this run's for the next
+type panelImages struct {
+	sql        *dbstore.SQLiteDatasource
+	scheduleID string
+	mutex      sync.Mutex
+	kept       map[string][]byte
+}
+
+func newPanelImages(sql *dbstore.SQLiteDatasource, scheduleID string) *panelImages {
+	return &panelImages{sql: sql, scheduleID: scheduleID, kept: map[string][]byte{}}
+}
+
+// Image is the previous run's image of a panel, which is rendered again if it can't be read
+func (images *panelImages) Image(fingerprint string) []byte {
+	image, err := images.sql.GetPanelImage(images.scheduleID, fingerprint)
+	if err != nil {
+		return nil
+	}
+	return image
+}
+
+func (images *panelImages) Keep(fingerprint string, image []byte) {
+	images.mutex.Lock()
+	defer images.mutex.Unlock()
+	images.kept[fingerprint] = image
+}
+
+// save replaces the previous run's images with this run's. Failing to only means panels are rendered again next time.
+func (images *panelImages) save() {
+	images.mutex.Lock()
+	defer images.mutex.Unlock()
+	if err := images.sql.ReplacePanelImages(images.scheduleID, images.kept); err != nil {
+		log.DefaultLogger.Warn("panelImages.save: ReplacePanelImages: " + err.Error())
+	}
+}
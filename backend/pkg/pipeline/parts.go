@@ -0,0 +1,195 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/reporter"
+)
+
+// reportPart is the panels from start up to end of a report too long to send in one
+type reportPart struct {
+	start int
+	end   int
+}
+
+// PartName is the name a part of a report is written and sent as, e.g. "Stock levels (Part 1 of 3)"
+func PartName(name string, part int, parts int) string {
+	return fmt.Sprintf("%s (Part %d of %d)", name, part, parts)
+}
+
+// planParts splits a report into parts of at most the settings' number of panels and size of files, only ever
+// breaking between sections, which start at the given panels. A section over the limits is a part on its own.
+// The report's files are paths, when it's sent whole.
+func planParts(ctx context.Context, report *reporter.Report, schedule dbstore.Schedule, name string, settings *dbstore.Settings, sections []int, paths []string) ([]reportPart, error) {
+	parts := partsOfSections(sections, report.PanelCount(), settings.ReportPartSheets)
+	if settings.ReportPartSize <= 0 {
+		return parts, nil
+	}
+
+	maxSize := int64(settings.ReportPartSize)
+	if len(parts) == 1 {
+		size, err := filesSize(paths)
+		if err != nil {
+			return nil, err
+		}
+		if size <= maxSize {
+			return parts, nil
+		}
+	}
+
+	sized := []reportPart{}
+	for _, part := range parts {
+		split, err := splitBySize(ctx, report, schedule, name, part, sections, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		sized = append(sized, split...)
+	}
+	return sized, nil
+}
+
+// partsOfSections groups the sections into parts of at most maxPanels panels, or just the one part when there's
+// no limit
+func partsOfSections(sections []int, panels int, maxPanels int) []reportPart {
+	if maxPanels <= 0 || panels <= maxPanels {
+		return []reportPart{{0, panels}}
+	}
+
+	parts := []reportPart{}
+	part := reportPart{}
+	for i, start := range sections {
+		end := panels
+		if i+1 < len(sections) {
+			end = sections[i+1]
+		}
+		if part.end > part.start && end-part.start > maxPanels {
+			parts = append(parts, part)
+			part = reportPart{start, start}
+		}
+		part.end = end
+	}
+
+	return append(parts, part)
+}
+
+// splitBySize halves a part at the section break nearest its middle for as long as its files are larger than
+// maxSize, writing it out to measure it
+func splitBySize(ctx context.Context, report *reporter.Report, schedule dbstore.Schedule, name string, part reportPart, sections []int, maxSize int64) ([]reportPart, error) {
+	middle, ok := middleBreak(sections, part)
+	if !ok {
+		return []reportPart{part}, nil
+	}
+
+	measuring := name + " (measuring)"
+	paths, err := writePart(ctx, report, schedule, measuring, &dbstore.ReportRun{}, part, 0, 0)
+	size, sizeErr := filesSize(paths)
+	removeFiles(append(paths, reporter.ReportPath(report.Directory(), measuring, dbstore.FormatXLSX)))
+	if err != nil {
+		return nil, err
+	}
+	if sizeErr != nil {
+		return nil, sizeErr
+	}
+	if size <= maxSize {
+		return []reportPart{part}, nil
+	}
+
+	first, err := splitBySize(ctx, report, schedule, name, reportPart{part.start, middle}, sections, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	second, err := splitBySize(ctx, report, schedule, name, reportPart{middle, part.end}, sections, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+// middleBreak is the start of the section nearest the middle of a part, other than its first
+func middleBreak(sections []int, part reportPart) (int, bool) {
+	middle, found := 0, false
+	for _, start := range sections {
+		if start <= part.start || start >= part.end {
+			continue
+		}
+		if !found || abs(2*start-part.start-part.end) < abs(2*middle-part.start-part.end) {
+			middle, found = start, true
+		}
+	}
+	return middle, found
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// writeParts writes each part of the report in each of the schedule's formats, returning each part's files
+func writeParts(ctx context.Context, report *reporter.Report, schedule dbstore.Schedule, name string, run *dbstore.ReportRun, parts []reportPart) ([][]string, error) {
+	partPaths := [][]string{}
+	for i, part := range parts {
+		paths, err := writePart(ctx, report, schedule, PartName(name, i+1, len(parts)), run, part, i+1, len(parts))
+		if err != nil {
+			RemoveParts(report.Directory(), name, partPaths)
+			return nil, err
+		}
+		partPaths = append(partPaths, paths)
+	}
+	return partPaths, nil
+}
+
+// writePart writes a part from the panels the report has already fetched, so nothing is asked of Grafana again
+func writePart(ctx context.Context, report *reporter.Report, schedule dbstore.Schedule, name string, run *dbstore.ReportRun, part reportPart, number int, parts int) ([]string, error) {
+	partReport := report.Part(name, part.start, part.end)
+	// Nothing is fetched, and the panels which failed were already reported when the whole report was written
+	err := partReport.Write(ctx, auth.AuthConfig{})
+	if _, failedPanels := err.(reporter.PanelErrors); err != nil && !failedPanels {
+		return nil, err
+	}
+
+	return writeArtifacts(partReport, schedule, name, run, number, parts)
+}
+
+// RemoveParts deletes the parts' files in the directory they were written to once they've been sent, along with
+// their workbooks and any zips made of them
+func RemoveParts(directory string, name string, parts [][]string) {
+	for i, paths := range parts {
+		for _, path := range append(paths, reporter.ReportPath(directory, PartName(name, i+1, len(parts)), dbstore.FormatXLSX)) {
+			os.Remove(path)
+			os.Remove(path + ".zip")
+		}
+	}
+}
+
+// FlattenParts is the files of every part, for sending them together
+func FlattenParts(parts [][]string) []string {
+	paths := []string{}
+	for _, part := range parts {
+		paths = append(paths, part...)
+	}
+	return paths
+}
+
+func filesSize(paths []string) (int64, error) {
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,106 @@
+// Package pipeline generates a schedule's report in stages, each behind an interface so other tools, e.g. a command
+// line exporter or a server side job, can generate the same reports the plugin sends, swapping out the stages they
+// need to:
+//
+//   - a ContentSource finds what the report is made of, e.g. a schedule's content, its template's and its playlist's
+//   - a Composer turns the content into the panels of the report, in order and split into sections
+//   - a Renderer fetches the panels' data and images and writes the report's files in the schedule's formats
+//   - a DeliveryChannel sends the files, e.g. by email
+//
+// Pipeline runs the first three, which is everything up to sending. The stages used by the plugin are StoreContent,
+// GrafanaComposer, WorkbookRenderer and EmailChannel.
+package pipeline
+
+import (
+	"context"
+
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// ContentSource finds the content of a schedule's report, in the order it's written
+type ContentSource interface {
+	Content(ctx context.Context, schedule dbstore.Schedule) ([]dbstore.ReportContent, error)
+}
+
+// Composition is the panels of a report, ready to be fetched and written
+type Composition struct {
+	Panels []api.TablePanel
+	// Where the panels of each content item start, which the report can be split between
+	Sections []int
+	// Masks identifying data in the panels before any of it is written, nothing is masked when nil
+	Masker *dbstore.Masker
+}
+
+// Fetched is the number of panels fetched from Grafana, those other than text
+func (composition *Composition) Fetched() int {
+	fetched := 0
+	for i := range composition.Panels {
+		if !composition.Panels[i].IsText() {
+			fetched++
+		}
+	}
+	return fetched
+}
+
+// Composer turns a report's content into its panels. What's found while composing, e.g. variables which have gone
+// stale, is recorded in the run.
+type Composer interface {
+	Compose(ctx context.Context, schedule dbstore.Schedule, content []dbstore.ReportContent, run *dbstore.ReportRun) (*Composition, error)
+}
+
+// Renderer writes a composed report as name in each of the schedule's formats, returning the files of each part
+// it's sent in, which is just the one unless it's too long to send whole. Panels which fail on their own are listed
+// in the run rather than failing the report.
+type Renderer interface {
+	Render(ctx context.Context, schedule dbstore.Schedule, name string, composition *Composition, run *dbstore.ReportRun) ([][]string, error)
+}
+
+// Delivery is a report's files on their way to its recipients
+type Delivery struct {
+	Files      []string
+	Recipients []string
+	Subject    string
+	Body       string
+	// Link each recipient can unsubscribe with, those without one aren't offered it
+	UnsubscribeLinks map[string]string
+	// Link each recipient can mark the report as reviewed with, for schedules tracking acknowledgements
+	AcknowledgeLinks map[string]string
+	// Called as each recipient is sent to, when set
+	Delivered func(recipient string)
+}
+
+// DeliveryResult is who a delivery reached, and how
+type DeliveryResult struct {
+	Sent     []string
+	Failures []dbstore.DeliveryFailure
+	// How the files were included, e.g. attached or linked, as the channel may reduce them to fit
+	AttachmentMode string
+}
+
+// DeliveryChannel sends a report's files to its recipients. The result holds those reached before any error.
+type DeliveryChannel interface {
+	Deliver(ctx context.Context, delivery Delivery) (DeliveryResult, error)
+}
+
+// Pipeline generates reports from its stages
+type Pipeline struct {
+	Source   ContentSource
+	Composer Composer
+	Renderer Renderer
+}
+
+// Generate writes a schedule's report as name, returning the files of each part of it
+func (pipeline Pipeline) Generate(ctx context.Context, schedule dbstore.Schedule, name string, run *dbstore.ReportRun) ([][]string, error) {
+	content, err := pipeline.Source.Content(ctx, schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	composition, err := pipeline.Composer.Compose(ctx, schedule, content, run)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeline.Renderer.Render(ctx, schedule, name, composition, run)
+}
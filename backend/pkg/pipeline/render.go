@@ -0,0 +1,123 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/reporter"
+)
+
+// WorkbookRenderer fetches the panels from Grafana and writes the report as an Excel workbook, which is always
+// written, and in each of the schedule's other formats. Reports over the settings' limits are split into parts.
+type WorkbookRenderer struct {
+	// Where the images of the schedule's last run are kept, when the settings reuse them
+	DB         *dbstore.SQLiteDatasource
+	AuthConfig *auth.AuthConfig
+	Settings   *dbstore.Settings
+	// Where the report's files are written, the schedule's report directory when empty
+	Directory string
+}
+
+func (renderer WorkbookRenderer) Render(ctx context.Context, schedule dbstore.Schedule, name string, composition *Composition, run *dbstore.ReportRun) ([][]string, error) {
+	templatePath := reporter.GetFilePath("template")
+	panelReporter := reporter.NewReporter(templatePath)
+	options := reporter.NewOptions(renderer.Settings)
+	options.Masker = composition.Masker
+	branding, err := renderer.DB.GetBranding()
+	if err != nil {
+		log.DefaultLogger.Error("WorkbookRenderer.Render: GetBranding: " + err.Error())
+		return nil, err
+	}
+	options.Branding = branding
+	options.Locale = dbstore.ResolveSettings(renderer.Settings, schedule, nil).Locale
+	options.Directory = renderer.Directory
+	if options.Directory == "" {
+		options.Directory = renderer.DB.ReportDirectory(schedule.ID)
+	}
+	options.ArchiveDirectory = renderer.DB.ArchiveDirectory()
+	var images *panelImages
+	if renderer.Settings.ReuseUnchangedImages && schedule.ID != "" {
+		images = newPanelImages(renderer.DB, schedule.ID)
+		options.Images = images
+	}
+	panelReporter.SetOptions(options)
+
+	report := panelReporter.CreateNewReport(schedule.ID, name)
+	report.SetSheets(composition.Panels)
+	err = report.Write(ctx, *renderer.AuthConfig)
+	// The panels left when it was cancelled weren't rendered, so there's nothing worth writing
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// Panels failing because Grafana is restarting aren't worth sending without, they'll work once it's back
+	if panelErrors, ok := err.(reporter.PanelErrors); ok && len(panelErrors) < composition.Fetched() && api.GrafanaAvailable(ctx, renderer.AuthConfig) == nil {
+		// Some panels worked, so the report is still worth sending
+		log.DefaultLogger.Warn("WorkbookRenderer.Render: report.Write: " + composition.Masker.Text(err.Error()))
+		run.Message = composition.Masker.Text(err.Error())
+		run.FailedPanels = panelErrors.Titles()
+	} else if err != nil {
+		log.DefaultLogger.Error("WorkbookRenderer.Render: report.Write: " + composition.Masker.Text(err.Error()))
+		return nil, err
+	}
+	if images != nil {
+		images.save()
+	}
+
+	paths, err := writeArtifacts(report, schedule, name, run, 0, 0)
+	if err != nil {
+		log.DefaultLogger.Error("WorkbookRenderer.Render: writeArtifacts: " + err.Error())
+		return nil, err
+	}
+
+	parts, err := planParts(ctx, report, schedule, name, renderer.Settings, composition.Sections, paths)
+	if err != nil {
+		log.DefaultLogger.Error("WorkbookRenderer.Render: planParts: " + err.Error())
+		return nil, err
+	}
+	if len(parts) == 1 {
+		return [][]string{paths}, nil
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("WorkbookRenderer.Render: %s is over the limits, sending it in %d parts", name, len(parts)))
+	partPaths, err := writeParts(ctx, report, schedule, name, run, parts)
+	if err != nil {
+		log.DefaultLogger.Error("WorkbookRenderer.Render: writeParts: " + err.Error())
+		return nil, err
+	}
+
+	return partPaths, nil
+}
+
+// writeArtifacts writes the report in each of the schedule's formats other than the workbook, which
+// is always written, and returns the files to send. part and parts number the report when it is one of several.
+func writeArtifacts(report *reporter.Report, schedule dbstore.Schedule, name string, run *dbstore.ReportRun, part int, parts int) ([]string, error) {
+	var paths []string
+	for _, format := range schedule.FormatList() {
+		switch format {
+		case dbstore.FormatXLSX:
+			paths = append(paths, report.FilePath(dbstore.FormatXLSX))
+		case dbstore.FormatPPTX:
+			if err := report.WritePPTX(); err != nil {
+				return nil, err
+			}
+			paths = append(paths, report.FilePath(dbstore.FormatPPTX))
+		case dbstore.FormatHTML:
+			if err := report.WriteHTML(); err != nil {
+				return nil, err
+			}
+			paths = append(paths, report.FilePath(dbstore.FormatHTML))
+		case dbstore.FormatJSON:
+			metadata := reporter.JSONMetadata{RunID: run.ID, ScheduleID: schedule.ID, Schedule: schedule.Name, Description: schedule.Description, ScheduledAt: run.ScheduledAt, Part: part, Parts: parts}
+			if err := report.WriteJSON(metadata); err != nil {
+				return nil, err
+			}
+			paths = append(paths, report.FilePath(dbstore.FormatJSON))
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// ErrStaleVariables fails a run whose content sets variables to values their dashboards no longer offer, under the
+// settings' fail policy. Trying again won't help until someone updates the content.
+var ErrStaleVariables = errors.New("variables are set to values their dashboards no longer offer")
+
+// variableChecker checks the values a report's content sets variables to against what their dashboards offer when
+// it's generated, as stored values go stale, e.g. when a store is renamed. Each variable's options are only looked
+// up once a report.
+type variableChecker struct {
+	authConfig *auth.AuthConfig
+	policy     string
+	run        *dbstore.ReportRun
+	// Options of each variable by dashboard, variable and datasource, nil when they can't be known
+	options map[string][]api.VariableOption
+	// Issues failing the run under the fail policy
+	failed []string
+}
+
+func newVariableChecker(authConfig *auth.AuthConfig, settings *dbstore.Settings, run *dbstore.ReportRun) *variableChecker {
+	return &variableChecker{authConfig: authConfig, policy: settings.VariablePolicy, run: run, options: make(map[string][]api.VariableOption)}
+}
+
+// check is the variables content is generated with, each value its dashboard no longer offers being recorded in
+// the run and mapped to the option matching it under the map policy. Variables whose options can't be known, e.g.
+// free text or queries depending on other variables, are used as they are.
+func (checker *variableChecker) check(ctx context.Context, dashboard *api.Dashboard, content dbstore.ReportContent, datasourceID int) string {
+	selected, err := dbstore.ParseContentVariables(content.Variables)
+	if err != nil || len(selected) == 0 {
+		return content.Variables
+	}
+
+	byName := make(map[string]api.TemplateVariable)
+	for _, variable := range dashboard.Variables.List {
+		byName[variable.Name] = variable
+	}
+
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mapped := false
+	for _, name := range names {
+		variable, ok := byName[name]
+		if !ok {
+			for _, value := range selected[name] {
+				checker.record(content.DashboardID, name, value, "")
+			}
+			continue
+		}
+
+		options := checker.variableOptions(ctx, dashboard, content, variable, datasourceID)
+		if len(options) == 0 {
+			continue
+		}
+
+		for i, value := range selected[name] {
+			if value == "$__all" && variable.IncludeAll || offered(options, value) {
+				continue
+			}
+
+			match := ""
+			if checker.policy == dbstore.VariablePolicyMap {
+				match = matchOption(options, value)
+			}
+			checker.record(content.DashboardID, name, value, match)
+			if match != "" {
+				selected[name][i] = match
+				mapped = true
+			}
+		}
+	}
+
+	if mapped {
+		return selected.String()
+	}
+	return content.Variables
+}
+
+// variableOptions looks up what a variable can be set to, nil when it can't be known
+func (checker *variableChecker) variableOptions(ctx context.Context, dashboard *api.Dashboard, content dbstore.ReportContent, variable api.TemplateVariable, datasourceID int) []api.VariableOption {
+	pinned := content.DatasourceID > 0
+	if pinned {
+		datasourceID = content.DatasourceID
+	}
+
+	key := fmt.Sprintf("%s/%s/%d", content.DashboardID, variable.Name, datasourceID)
+	if options, ok := checker.options[key]; ok {
+		return options
+	}
+
+	options, known, err := api.CurrentVariableOptions(ctx, checker.authConfig, dashboard, variable, datasourceID, pinned)
+	if err != nil {
+		// The panels using it will fail the same way if Grafana or the datasource is down, which is reported then
+		log.DefaultLogger.Warn("variableChecker.variableOptions: could not look up the options of " + variable.Name + " on " + content.DashboardID + ": " + err.Error())
+	}
+	if err != nil || !known {
+		options = nil
+	}
+	checker.options[key] = options
+	return options
+}
+
+// record adds a value the dashboard no longer offers to the run, with what's done about it
+func (checker *variableChecker) record(dashboardID string, name string, value string, match string) {
+	issue := dbstore.VariableIssue{DashboardID: dashboardID, Variable: name, Value: value, Outcome: dbstore.VariableOutcomeKept}
+	switch {
+	case match != "":
+		issue.Outcome = dbstore.VariableOutcomeMapped
+		issue.MappedTo = match
+	case checker.policy == dbstore.VariablePolicyFail:
+		issue.Outcome = dbstore.VariableOutcomeFailed
+		checker.failed = append(checker.failed, name+"="+value)
+	}
+
+	log.DefaultLogger.Warn(fmt.Sprintf("variableChecker.record: %s on %s is set to %s which it no longer offers, %s", name, dashboardID, value, issue.Outcome))
+	checker.run.VariableIssues = append(checker.run.VariableIssues, issue)
+}
+
+// err fails the run if a value isn't offered any more under the fail policy
+func (checker *variableChecker) err() error {
+	if len(checker.failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrStaleVariables, strings.Join(checker.failed, ", "))
+}
+
+func offered(options []api.VariableOption, value string) bool {
+	for _, option := range options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOption is the value of the option whose label or value is the same as value ignoring case and spacing, empty
+// when there's none
+func matchOption(options []api.VariableOption, value string) string {
+	normalised := normaliseOption(value)
+	for _, option := range options {
+		if normaliseOption(option.Text) == normalised || normaliseOption(option.Value) == normalised {
+			return option.Value
+		}
+	}
+	return ""
+}
+
+func normaliseOption(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
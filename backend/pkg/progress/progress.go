@@ -0,0 +1,132 @@
+// Package progress counts how far a report job has got, the panels it has rendered and the emails it has sent, so
+// those waiting on it can follow along. The counts are carried in the context the job runs with, so the reporter
+// and emailer can add to them without knowing about jobs.
+package progress
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Stages a job goes through
+const (
+	StageRendering = "rendering"
+	StageSending   = "sending"
+)
+
+// Most often the counts are saved while they're changing, a new stage is always saved straight away
+const SaveInterval = time.Second
+
+// Progress is how far a job has got. A job sending several reports adds each one's panels and emails to the totals
+// as it gets to them.
+type Progress struct {
+	Stage          string `json:"stage"`
+	PanelsTotal    int    `json:"panelsTotal"`
+	PanelsRendered int    `json:"panelsRendered"`
+	PanelsFailed   int    `json:"panelsFailed"`
+	EmailsTotal    int    `json:"emailsTotal"`
+	EmailsSent     int    `json:"emailsSent"`
+	EmailsFailed   int    `json:"emailsFailed"`
+}
+
+// Tracker counts a job's progress, saving it as it changes. A nil Tracker counts nothing, for work done outside a
+// job.
+type Tracker struct {
+	save func(Progress)
+
+	mutex    sync.Mutex
+	progress Progress
+	savedAt  time.Time
+	unsaved  bool
+}
+
+func NewTracker(save func(Progress)) *Tracker {
+	return &Tracker{save: save}
+}
+
+type contextKey struct{}
+
+// WithTracker is a context which the work done with it counts its progress in
+func WithTracker(ctx context.Context, tracker *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, tracker)
+}
+
+// From is the tracker of the context, nil when there isn't one
+func From(ctx context.Context) *Tracker {
+	tracker, _ := ctx.Value(contextKey{}).(*Tracker)
+	return tracker
+}
+
+// Panels starts rendering a report's panels
+func (tracker *Tracker) Panels(total int) {
+	tracker.update(true, func(progress *Progress) {
+		progress.Stage = StageRendering
+		progress.PanelsTotal += total
+	})
+}
+
+// PanelDone counts a panel which was rendered or failed
+func (tracker *Tracker) PanelDone(err error) {
+	tracker.update(false, func(progress *Progress) {
+		if err != nil {
+			progress.PanelsFailed++
+		} else {
+			progress.PanelsRendered++
+		}
+	})
+}
+
+// Emails starts sending a report to its recipients
+func (tracker *Tracker) Emails(total int) {
+	tracker.update(true, func(progress *Progress) {
+		progress.Stage = StageSending
+		progress.EmailsTotal += total
+	})
+}
+
+// EmailDone counts an email which was sent or failed
+func (tracker *Tracker) EmailDone(err error) {
+	tracker.update(false, func(progress *Progress) {
+		if err != nil {
+			progress.EmailsFailed++
+		} else {
+			progress.EmailsSent++
+		}
+	})
+}
+
+// Flush saves the counts if they've changed since they were last saved, once the job is done
+func (tracker *Tracker) Flush() {
+	if tracker == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.unsaved {
+		tracker.saveLocked()
+	}
+}
+
+func (tracker *Tracker) update(now bool, change func(progress *Progress)) {
+	if tracker == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	change(&tracker.progress)
+	tracker.unsaved = true
+	if now || time.Since(tracker.savedAt) >= SaveInterval {
+		tracker.saveLocked()
+	}
+}
+
+// saveLocked saves the counts. The mutex must be held, which keeps saves in order.
+func (tracker *Tracker) saveLocked() {
+	tracker.save(tracker.progress)
+	tracker.savedAt = time.Now()
+	tracker.unsaved = false
+}
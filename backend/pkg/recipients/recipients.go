@@ -0,0 +1,110 @@
+// Package recipients resolves the addresses a report is sent to into people, so a person
+// reachable through several addresses or channels only receives each report once.
+package recipients
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+type Recipient struct {
+	Channel string
+	Address string
+}
+
+// IdentityFunc returns a key which is the same for every recipient that reaches the same inbox
+type IdentityFunc func(Recipient) string
+
+// AddressIdentity hashes the channel and the normalised address, e.g. emails ignore case
+func AddressIdentity(recipient Recipient) string {
+	address := strings.TrimSpace(recipient.Address)
+	if recipient.Channel == dbstore.ChannelEmail {
+		address = strings.ToLower(address)
+	}
+
+	hash := sha256.Sum256([]byte(recipient.Channel + ":" + address))
+	return hex.EncodeToString(hash[:])
+}
+
+type contactRef struct {
+	personID string
+	priority int
+}
+
+type Resolver struct {
+	identity IdentityFunc
+	contacts map[string]contactRef
+}
+
+func NewResolver(contacts []dbstore.Contact, identity IdentityFunc) *Resolver {
+	if identity == nil {
+		identity = AddressIdentity
+	}
+
+	resolver := &Resolver{identity: identity, contacts: make(map[string]contactRef)}
+	for _, contact := range contacts {
+		key := identity(Recipient{Channel: contact.Channel, Address: contact.Address})
+		resolver.contacts[key] = contactRef{personID: contact.PersonID, priority: contact.Priority}
+	}
+
+	return resolver
+}
+
+// Resolve keeps a single recipient per person, in the order people first appear. Recipients without
+// a contact record are their own person, so only exact duplicates of their address are removed.
+func (resolver *Resolver) Resolve(recipients []Recipient) []Recipient {
+	type choice struct {
+		recipient Recipient
+		priority  int
+	}
+
+	var people []string
+	chosen := make(map[string]choice)
+
+	for _, recipient := range recipients {
+		key := resolver.identity(recipient)
+		person := "address:" + key
+		priority := 0
+		if contact, ok := resolver.contacts[key]; ok {
+			person = "person:" + contact.personID
+			priority = contact.priority
+		}
+
+		current, seen := chosen[person]
+		if !seen {
+			people = append(people, person)
+		}
+		if !seen || priority < current.priority {
+			chosen[person] = choice{recipient: recipient, priority: priority}
+		}
+	}
+
+	resolved := []Recipient{}
+	for _, person := range people {
+		resolved = append(resolved, chosen[person].recipient)
+	}
+
+	return resolved
+}
+
+func FromEmails(emails []string) []Recipient {
+	var recipients []Recipient
+	for _, email := range emails {
+		recipients = append(recipients, Recipient{Channel: dbstore.ChannelEmail, Address: email})
+	}
+	return recipients
+}
+
+// Addresses of the recipients using a channel
+func Addresses(recipients []Recipient, channel string) []string {
+	var addresses []string
+	for _, recipient := range recipients {
+		if recipient.Channel == channel {
+			addresses = append(addresses, recipient.Address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,81 @@
+package reportEmailer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/pipeline"
+	"github.com/grafana/simple-datasource-backend/pkg/syslog"
+)
+
+// permanentFailure is whether a run failed because something the schedule reports on is gone, e.g. its dashboard or
+// report group or template was deleted, its owner can no longer view a dashboard, or its variables are set to values
+// their dashboards no longer offer, so trying again will only fail the same way
+func permanentFailure(err error) bool {
+	return errors.Is(err, api.ErrDashboardNotFound) || errors.Is(err, api.ErrDashboardForbidden) || errors.Is(err, pipeline.ErrStaleVariables) || errors.Is(err, dbstore.ErrReportTemplateNotFound) || errors.Is(err, dbstore.ErrReportTemplateVersionNotFound) || errors.Is(err, sql.ErrNoRows)
+}
+
+// checkHealth disables a schedule once its runs have failed permanently as many times in a row as the settings
+// allow, telling its owner and the admin, so it stops failing noisily until someone fixes it and re-enables it
+func (re *ReportEmailer) checkHealth(ctx context.Context, schedule dbstore.Schedule, run dbstore.ReportRun, authConfig *auth.AuthConfig, em emailer.Emailer) {
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.checkHealth: GetSettings: " + err.Error())
+		return
+	}
+
+	streak, err := re.sql.PermanentFailureStreak(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.checkHealth: PermanentFailureStreak: " + err.Error())
+		return
+	}
+	if streak < dbstore.DisableAfterFailures(settings) {
+		return
+	}
+
+	reason := fmt.Sprintf("Failed %d times in a row: %s", streak, run.Message)
+	disabled, err := re.sql.DisableSchedule(schedule.ID, reason)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.checkHealth: DisableSchedule: " + err.Error())
+		return
+	}
+	if !disabled {
+		return
+	}
+
+	message := fmt.Sprintf("Report '%s' has been disabled after failing %d times in a row", schedule.Name, streak)
+	log.DefaultLogger.Warn(message + ": " + run.Message)
+	syslog.Send(settings, syslog.Event{
+		Kind:     syslog.EventDisabled,
+		Severity: syslog.SeverityError,
+		Message:  message,
+		Fields:   map[string]string{"scheduleID": schedule.ID, "schedule": schedule.Name, "failures": strconv.Itoa(streak)},
+	})
+
+	addresses := ownerAndAdmin(ctx, schedule, settings, authConfig)
+	if len(addresses) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("Report '%s' needs attention", schedule.Name)
+	body := fmt.Sprintf("<p>The report <b>%s</b> has failed %d times in a row in a way which won't fix itself, so it has been disabled and won't be sent until it is re-enabled.</p>", html.EscapeString(schedule.Name), streak)
+	body += "<p>The last error was: " + html.EscapeString(run.Message) + "</p>"
+	body += "<p>Fix the schedule, e.g. by replacing content whose dashboard was deleted, then re-enable it.</p>"
+	link := em.RunHistoryLink(schedule.ID)
+	body += fmt.Sprintf("<p><a href=\"%s\">See the run history</a></p>", html.EscapeString(link))
+
+	for _, address := range addresses {
+		if err := em.Send(ctx, address, subject, body); err != nil {
+			log.DefaultLogger.Error("ReportEmailer.checkHealth: Send: " + err.Error())
+		}
+	}
+}
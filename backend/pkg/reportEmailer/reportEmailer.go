@@ -1,75 +1,326 @@
 package reportEmailer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/bugsnag/bugsnag-go"
-
+	"github.com/google/uuid"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/api"
 	"github.com/grafana/simple-datasource-backend/pkg/auth"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
 	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"github.com/grafana/simple-datasource-backend/pkg/pipeline"
+	"github.com/grafana/simple-datasource-backend/pkg/recipients"
 	"github.com/grafana/simple-datasource-backend/pkg/reporter"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+	"github.com/grafana/simple-datasource-backend/pkg/syslog"
 )
 
+// Time allowed for generating and sending a single report
+const DefaultReportTimeout = 15 * time.Minute
+
+// How many scheduled runs in a row can fail before the schedule's owner and the admin are alerted
+const FailureStreakAlert = 3
+
 type ReportEmailer struct {
-	sql        *dbstore.SQLiteDatasource
-	inProgress bool
+	sql       *dbstore.SQLiteDatasource
+	scheduler *scheduler.Scheduler
 }
 
 func NewReportEmailer(sql *dbstore.SQLiteDatasource) *ReportEmailer {
-	return &ReportEmailer{sql: sql, inProgress: false}
+	return &ReportEmailer{sql: sql, scheduler: scheduler.New(sql)}
 }
 
-func (re *ReportEmailer) configs() (*auth.AuthConfig, *auth.EmailConfig, int, error) {
+func (re *ReportEmailer) configs() (*auth.AuthConfig, *auth.EmailConfig, *dbstore.Settings, error) {
 	authConfig, err := auth.NewAuthConfig(re.sql)
 	if err != nil {
 		log.DefaultLogger.Error("ReportEmailer.configs: NewAuthConfig: " + err.Error())
-		return nil, nil, 0, err
+		return nil, nil, nil, err
 	}
 
 	emailConfig, err := auth.NewEmailConfig(re.sql)
 	if err != nil {
 		log.DefaultLogger.Error("ReportEmailer.configs: NewEmailConfig: " + err.Error())
-		return nil, nil, 0, err
+		return nil, nil, nil, err
 	}
 
 	settings, err := re.sql.GetSettings()
 	if err != nil {
 		log.DefaultLogger.Error("ReportEmailer.configs: GetSettings: " + err.Error())
-		return nil, nil, 0, err
+		return nil, nil, nil, err
 	}
 
-	return authConfig, emailConfig, settings.DatasourceID, nil
+	return authConfig, emailConfig, settings, nil
 }
 
 func (re *ReportEmailer) cleanup(schedules []dbstore.Schedule) {
 	log.DefaultLogger.Info("Starting Clean up...")
 	for _, schedule := range schedules {
 
-		log.DefaultLogger.Info(fmt.Sprintf("Deleting %s.xlsx...", schedule.Name))
-		path := reporter.GetFilePath(schedule.Name)
-		err := os.Remove(path)
+		// Every file written for the schedule, in each format, zipped or in parts, is in its own directory
+		log.DefaultLogger.Info(fmt.Sprintf("Deleting the reports of %s...", schedule.Name))
+		err := os.RemoveAll(re.sql.ReportDirectory(schedule.ID))
+		if err != nil {
+			// Failure case shouldn't be much of a problem as the next run writes to the same files
+			log.DefaultLogger.Error(fmt.Sprintf("Could not delete the reports of %s... : %s", schedule.Name, err.Error()))
+		}
+
+		re.scheduler.Advance(schedule)
+		if err := re.sql.ClearOutbox(schedule.ID); err != nil {
+			log.DefaultLogger.Error("ReportEmailer.cleanup: ClearOutbox: " + err.Error())
+		}
+	}
+}
+
+// finishRun records the outcome of a report run in the run history, letting the admin know if it didn't fully succeed
+func (re *ReportEmailer) finishRun(ctx context.Context, schedule dbstore.Schedule, run *dbstore.ReportRun, err error, authConfig *auth.AuthConfig, em emailer.Emailer) {
+	run.FinishedAt = int(time.Now().Unix())
+	if errors.Is(err, context.Canceled) {
+		run.Status = dbstore.ReportRunStatusCancelled
+		run.Message = "cancelled before it was sent"
+	} else if errors.Is(err, pipeline.ErrConditionNotMet) {
+		run.Status = dbstore.ReportRunStatusSkipped
+		run.Message = err.Error()
+	} else if err != nil {
+		// Panels' errors can quote their queries, which may hold what the masking rules hide
+		masker, maskErr := re.sql.Masker()
+		if maskErr != nil {
+			log.DefaultLogger.Warn("ReportEmailer.finishRun: Masker: " + maskErr.Error())
+		}
+		run.Status = dbstore.ReportRunStatusFailed
+		run.Message = masker.Text(err.Error())
+		run.Permanent = permanentFailure(err)
+	} else if len(run.FailedPanels) > 0 {
+		run.Status = dbstore.ReportRunStatusPartial
+	} else {
+		run.Status = dbstore.ReportRunStatusSent
+	}
+
+	if err := re.sql.UpdateReportRun(*run); err != nil {
+		log.DefaultLogger.Error("ReportEmailer.finishRun: UpdateReportRun: " + err.Error())
+	}
+	re.logRun(schedule, *run)
+	// Whoever sent the test sees how it went, it says nothing about how the schedule's runs are doing
+	if run.Test {
+		return
+	}
+	metrics.ReportFinished(run.Status, run.Status == dbstore.ReportRunStatusSent || run.Status == dbstore.ReportRunStatusPartial)
+
+	if err := re.sql.UpdateSLOCompliance(schedule, run.ScheduledAt); err != nil {
+		log.DefaultLogger.Error("ReportEmailer.finishRun: UpdateSLOCompliance: " + err.Error())
+	}
+
+	if run.Status != dbstore.ReportRunStatusSent && run.Status != dbstore.ReportRunStatusCancelled && run.Status != dbstore.ReportRunStatusSkipped {
+		re.notifyAdmin(ctx, schedule, *run, em)
+	}
+
+	if run.Status == dbstore.ReportRunStatusFailed && run.ScheduledAt > 0 {
+		re.checkFailureStreak(ctx, schedule, *run, authConfig, em)
+		if run.Permanent {
+			re.checkHealth(ctx, schedule, *run, authConfig, em)
+		}
+	}
+}
+
+// checkFailureStreak alerts the schedule's owner and the admin once its runs have failed FailureStreakAlert times in
+// a row. Only the run which reaches the streak alerts, so a schedule which keeps failing doesn't send one every run.
+func (re *ReportEmailer) checkFailureStreak(ctx context.Context, schedule dbstore.Schedule, run dbstore.ReportRun, authConfig *auth.AuthConfig, em emailer.Emailer) {
+	streak, err := re.sql.FailureStreak(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.checkFailureStreak: FailureStreak: " + err.Error())
+		return
+	}
+	if streak != FailureStreakAlert {
+		return
+	}
+
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.checkFailureStreak: GetSettings: " + err.Error())
+		return
+	}
+
+	addresses := ownerAndAdmin(ctx, schedule, settings, authConfig)
+
+	log.DefaultLogger.Warn(fmt.Sprintf("Report '%s' has failed %d times in a row", schedule.Name, streak))
+	syslog.Send(settings, syslog.Event{
+		Kind:     syslog.EventFailureStreak,
+		Severity: syslog.SeverityError,
+		Message:  fmt.Sprintf("Report '%s' has failed %d times in a row", schedule.Name, streak),
+		Fields:   map[string]string{"scheduleID": schedule.ID, "schedule": schedule.Name, "failures": strconv.Itoa(streak)},
+	})
+	if len(addresses) == 0 {
+		return
+	}
+
+	subject := fmt.Sprintf("Report '%s' has failed %d times in a row", schedule.Name, streak)
+	body := fmt.Sprintf("<p>The last %d runs of the report <b>%s</b> have failed, so its recipients haven't received it since.</p>", streak, html.EscapeString(schedule.Name))
+	if run.Message != "" {
+		body += "<p>The last error was: " + html.EscapeString(run.Message) + "</p>"
+	}
+	link := em.RunHistoryLink(schedule.ID)
+	body += fmt.Sprintf("<p><a href=\"%s\">See the run history</a></p>", html.EscapeString(link))
+
+	for _, address := range addresses {
+		if err := em.Send(ctx, address, subject, body); err != nil {
+			log.DefaultLogger.Error("ReportEmailer.checkFailureStreak: Send: " + err.Error())
+		}
+	}
+}
+
+// ownerAndAdmin are the email addresses of the admin in the settings and the schedule's owner, those it has
+func ownerAndAdmin(ctx context.Context, schedule dbstore.Schedule, settings *dbstore.Settings, authConfig *auth.AuthConfig) []string {
+	addresses := []string{}
+	if settings.AdminEmail != "" {
+		addresses = append(addresses, settings.AdminEmail)
+	}
+	if schedule.Owner != "" {
+		ownerEmail, err := api.GetUserEmail(ctx, authConfig, schedule.Owner)
 		if err != nil {
-			// Failure case shouldn't be much of a problem since we're using the schedule ID for the report name, at the moment
-			// as it will just write to the same file and not create infinitely many if deleting always fails.
-			log.DefaultLogger.Error(fmt.Sprintf("Could not delete %s... : %s.xlsx", schedule.Name, err.Error()))
+			log.DefaultLogger.Warn("ReportEmailer.ownerAndAdmin: GetUserEmail: " + schedule.Owner + ": " + err.Error())
+		} else if ownerEmail != "" && !strings.EqualFold(ownerEmail, settings.AdminEmail) {
+			addresses = append(addresses, ownerEmail)
+		}
+	}
+	return addresses
+}
+
+// logRun sends the outcome of a run to the syslog server in the settings, if there is one
+func (re *ReportEmailer) logRun(schedule dbstore.Schedule, run dbstore.ReportRun) {
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.logRun: GetSettings: " + err.Error())
+		return
+	}
+
+	severity := syslog.SeverityInfo
+	switch run.Status {
+	case dbstore.ReportRunStatusPartial:
+		severity = syslog.SeverityWarning
+	case dbstore.ReportRunStatusFailed:
+		severity = syslog.SeverityError
+	}
+
+	message := fmt.Sprintf("Report '%s' %s", schedule.Name, run.Status)
+	if run.Message != "" {
+		message += ": " + run.Message
+	}
+	syslog.Send(settings, syslog.Event{
+		Kind:     syslog.EventReportRun,
+		Severity: severity,
+		Message:  message,
+		Fields: map[string]string{
+			"scheduleID":   schedule.ID,
+			"schedule":     schedule.Name,
+			"runID":        run.ID,
+			"status":       run.Status,
+			"scheduledAt":  strconv.Itoa(run.ScheduledAt),
+			"messagesSent": strconv.Itoa(run.MessagesSent),
+			"failedPanels": strings.Join(run.FailedPanels, ", "),
+		},
+	})
+}
+
+func (re *ReportEmailer) notifyAdmin(ctx context.Context, schedule dbstore.Schedule, run dbstore.ReportRun, em emailer.Emailer) {
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.notifyAdmin: GetSettings: " + err.Error())
+		return
+	}
+
+	if settings.AdminEmail == "" {
+		return
+	}
+
+	subject := fmt.Sprintf("Report '%s' %s", schedule.Name, run.Status)
+	body := fmt.Sprintf("<p>The report <b>%s</b> finished with the status <b>%s</b>.</p>", html.EscapeString(schedule.Name), run.Status)
+	if len(run.FailedPanels) > 0 {
+		body += "<p>These panels could not be rendered and were replaced with a placeholder: " + html.EscapeString(strings.Join(run.FailedPanels, ", ")) + "</p>"
+	}
+	if run.Message != "" {
+		body += "<p>" + html.EscapeString(run.Message) + "</p>"
+	}
+
+	if err := em.Send(ctx, settings.AdminEmail, subject, body); err != nil {
+		log.DefaultLogger.Error("ReportEmailer.notifyAdmin: Send: " + err.Error())
+	}
+}
+
+// CreateReport generates and sends a schedule's report, scheduledAt is when it was due or 0 when sent on demand
+func (re *ReportEmailer) CreateReport(ctx context.Context, schedule dbstore.Schedule, scheduledAt int, authConfig *auth.AuthConfig, datasourceID int, em emailer.Emailer) error {
+	run, err := re.sql.CreateReportRun(schedule.ID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.CreateReport: CreateReportRun: " + err.Error())
+		return err
+	}
+	return re.sendReport(ctx, schedule, run, authConfig, datasourceID, em, nil)
+}
+
+// sendReport sends to the addresses in to when there are any, otherwise to the schedule's report group
+func (re *ReportEmailer) sendReport(ctx context.Context, schedule dbstore.Schedule, run *dbstore.ReportRun, authConfig *auth.AuthConfig, datasourceID int, em emailer.Emailer, to []string) error {
+	err := re.createReport(ctx, schedule, authConfig, datasourceID, em, run, to)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// Stopped by someone, so it's neither retried nor reported as failing
+		err = ctx.Err()
+	} else if retryable(run, err) && api.GrafanaAvailable(ctx, authConfig) != nil {
+		// Grafana went down part way through, so the panels failed together rather than on their own merits
+		waitErr := api.WaitForGrafana(ctx, authConfig, api.GrafanaRestartWindow)
+		if waitErr != nil {
+			err = waitErr
+		} else {
+			resetAttempt(run)
+			err = re.createReport(ctx, schedule, authConfig, datasourceID, em, run, to)
 		}
+	}
+	// The admin should still hear about a report which ran out of time
+	re.finishRun(context.Background(), schedule, run, err, authConfig, em)
 
-		schedule.UpdateNextReportTime()
-		re.sql.UpdateSchedule(schedule.ID, schedule)
+	// The schedule has done what it was due to, so it moves on as if the report had been sent
+	if errors.Is(err, pipeline.ErrConditionNotMet) {
+		return nil
 	}
+	return err
+}
 
-	re.inProgress = false
+// retryable is whether a report which failed can be generated and sent again once Grafana is back. Only a report
+// which failed before any of it went out can be, as sends which skip the outbox, e.g. test emails, would otherwise
+// reach everyone they already had again.
+func retryable(run *dbstore.ReportRun, err error) bool {
+	if err == nil || errors.Is(err, pipeline.ErrConditionNotMet) {
+		return false
+	}
+	return run.MessagesSent == 0 && run.PartsDelivered == 0
 }
 
-func (re *ReportEmailer) CreateReport(schedule dbstore.Schedule, authConfig *auth.AuthConfig, datasourceID int, em emailer.Emailer) error {
-	emails := make(map[string][]string)
-	panels := make(map[string][]api.TablePanel)
+// resetAttempt clears what a failed attempt recorded on the run, so trying it again doesn't add to it
+func resetAttempt(run *dbstore.ReportRun) {
+	run.Message, run.FailedPanels = "", nil
+	run.MessagesSent, run.EstimatedCost, run.PartsDelivered = 0, 0, 0
+	run.AttachmentMode = ""
+	run.VariableIssues = []dbstore.VariableIssue{}
+}
+
+func (re *ReportEmailer) createReport(ctx context.Context, schedule dbstore.Schedule, authConfig *auth.AuthConfig, datasourceID int, em emailer.Emailer, run *dbstore.ReportRun, to []string) error {
+	// Checked before anything else is done, so a report with nothing to say costs one query. Reports sent on
+	// demand, e.g. test emails, are always sent.
+	if run.ScheduledAt > 0 {
+		if err := pipeline.CheckCondition(ctx, schedule, run, authConfig, datasourceID); err != nil {
+			return err
+		}
+	}
 
 	reportGroup, err := re.sql.ReportGroupFromSchedule(schedule)
 	if err != nil {
@@ -77,92 +328,455 @@ func (re *ReportEmailer) CreateReport(schedule dbstore.Schedule, authConfig *aut
 		return err
 	}
 
-	userIDs, err := re.sql.GroupMemberUserIDs(*reportGroup)
+	emails, unsubscribeLinks := to, map[string]string{}
+	if len(to) == 0 {
+		emails, unsubscribeLinks, err = re.groupRecipients(ctx, schedule, *reportGroup, authConfig, datasourceID, em)
+		if err != nil {
+			return err
+		}
+	}
+
+	settings, err := re.sql.GetSettings()
 	if err != nil {
-		log.DefaultLogger.Error("ReportEmailer.createReport: GroupMemberUserIDs: " + err.Error())
+		log.DefaultLogger.Error("ReportEmailer.createReport: GetSettings: " + err.Error())
 		return err
 	}
 
-	emailsFromUsers, err := api.GetEmails(*authConfig, userIDs, datasourceID)
+	// Schedules with an email profile send from its account and server rather than the settings'
+	if schedule.EmailProfileID != "" {
+		profile, err := re.sql.GetEmailProfile(schedule.EmailProfileID)
+		if err != nil {
+			log.DefaultLogger.Error("ReportEmailer.createReport: GetEmailProfile: " + err.Error())
+			return err
+		}
+		profileSettings := profile.Apply(*settings)
+		settings = &profileSettings
+		em = *emailer.New(auth.NewSettingsEmailConfig(settings))
+	}
+
+	// A schedule using a template sends its files, with its subject and body, and renders its content first
+	schedule, template, err := re.applyTemplate(schedule)
 	if err != nil {
-		log.DefaultLogger.Error("ReportEmailer.createReport: emailsFromUsers: " + err.Error())
 		return err
 	}
+	subject := schedule.Name
+	if template != nil {
+		subject = template.SubjectFor(schedule)
+	}
 
-	emails[schedule.ID] = emailsFromUsers
+	parts, err := re.renderReport(ctx, schedule, template, schedule.Name, "", authConfig, datasourceID, settings, run)
+	if err != nil {
+		return err
+	}
+	// Nothing of a group's reports is kept unencrypted, so the run's data isn't archived either
+	if reportGroup.EncryptAttachments {
+		os.Remove(reporter.ArchivePath(re.sql.ArchiveDirectory(), run.ID))
+	}
+	if len(parts) > 1 {
+		defer pipeline.RemoveParts(re.sql.ReportDirectory(schedule.ID), schedule.Name, parts)
+	}
 
-	reportContent, err := re.sql.GetReportContent(schedule.ID)
+	// Checked as the report is dispatched, so rules added while it was being generated still apply
+	err = re.sql.CheckResidency(*reportGroup, dbstore.ChannelEmail, settings.EmailHost)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.createReport: CheckResidency: " + err.Error())
+		return err
+	}
+	err = re.sql.CheckRecipientResidency(*reportGroup, dbstore.ChannelEmail, to)
 	if err != nil {
-		log.DefaultLogger.Error("ReportEmailer.createReport: GetReportContent: " + err.Error())
+		log.DefaultLogger.Error("ReportEmailer.createReport: CheckRecipientResidency: " + err.Error())
 		return err
 	}
 
-	panels[schedule.ID] = []api.TablePanel{}
+	// Scheduled reports go through the outbox, so a run which stopped part way through, even as the plugin
+	// restarted, only sends to those it hadn't reached yet
+	var delivered func(email string)
+	if run.ScheduledAt > 0 && len(to) == 0 {
+		emails, err = re.outbox(schedule, run.ScheduledAt, emails)
+		if err != nil {
+			return err
+		}
+		delivered = func(email string) {
+			if err := re.sql.MarkMessageSent(schedule.ID, run.ScheduledAt, email); err != nil {
+				log.DefaultLogger.Error("ReportEmailer.createReport: MarkMessageSent: " + err.Error())
+			}
+		}
+	}
 
-	for _, content := range reportContent {
-		interval := int64(schedule.Interval)
-		to := strconv.FormatInt(time.Now().Unix(), 10)
-		from := strconv.FormatInt(time.Now().Unix()-interval, 10)
+	// Scheduled reports of a schedule tracking acknowledgements ask each recipient to mark them as reviewed, and
+	// who they were sent to is recorded as each is sent
+	acknowledgeLinks := map[string]string{}
+	if schedule.TrackAcknowledgement && run.ScheduledAt > 0 && len(to) == 0 {
+		acknowledgeLinks, err = re.acknowledgeLinks(run, emails, em)
+		if err != nil {
+			log.DefaultLogger.Error("ReportEmailer.createReport: acknowledgeLinks: " + err.Error())
+			return err
+		}
+		outboxDelivered := delivered
+		delivered = func(email string) {
+			if outboxDelivered != nil {
+				outboxDelivered(email)
+			}
+			if err := re.sql.ExpectAcknowledgements(run.ID, schedule.ID, []string{email}); err != nil {
+				log.DefaultLogger.Error("ReportEmailer.createReport: ExpectAcknowledgements: " + err.Error())
+			}
+		}
+	}
 
-		dashboard, err := api.NewDashboard(authConfig, content.DashboardID, from, to, datasourceID)
+	// Cancelled while it was being generated, so it isn't sent
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The schedule's own sender, so replies go to its program team rather than the sending account
+	sender := dbstore.ResolveSettings(settings, schedule, nil)
+	em = *em.WithSender(sender.FromName, sender.ReplyTo, sender.EmailHeaders).WithLocale(sender.Locale).WithSharer(re.sql.OrgID(), re.sharer(schedule))
 
+	// Groups whose reports mustn't travel unencrypted get them as encrypted zips. Without a password, e.g. when the
+	// group was imported, the report isn't sent at all rather than unencrypted.
+	if reportGroup.EncryptAttachments {
+		password, err := re.sql.GetAttachmentPassword(reportGroup.ID)
 		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer.createReport: NewDashboard: " + err.Error())
+			log.DefaultLogger.Error("ReportEmailer.createReport: GetAttachmentPassword: " + err.Error())
+			return err
+		}
+		if password == "" {
+			err = errors.New("report group '" + reportGroup.Name + "' encrypts its attachments but has no attachment password")
+			log.DefaultLogger.Error("ReportEmailer.createReport: " + err.Error())
 			return err
 		}
+		em = *em.WithAttachmentPassword(password)
+	}
 
-		panel := dashboard.Panel(content.PanelID)
-		if panel != nil {
-			panel.PrepSql(dashboard.Variables, content.Variables)
-			panels[schedule.ID] = append(panels[schedule.ID], *panel)
+	// A report in parts is sent in an email for each, unless the settings link to them all from one
+	subjects := []string{subject}
+	if len(parts) > 1 && settings.ReportPartDelivery == dbstore.PartDeliveryLinks {
+		parts = [][]string{pipeline.FlattenParts(parts)}
+		em = *em.WithLinkedAttachments()
+	} else if len(parts) > 1 {
+		subjects = []string{}
+		for i := range parts {
+			subjects = append(subjects, i18n.For(sender.Locale).Text("email.part", subject, i+1, len(parts)))
 		}
 	}
 
-	templatePath := reporter.GetFilePath("template")
-	reporter := reporter.NewReporter(templatePath)
+	attachmentMode := emailer.AttachmentModeAttached
+	for i, paths := range parts {
+		// Recipients are only done with once they have every part, a run resumed part way through sends them all again
+		var partDelivered func(email string)
+		if i == len(parts)-1 {
+			partDelivered = delivered
+		}
 
-	for scheduleID, reportSheetPanels := range panels {
-		schedule, err := re.sql.GetSchedule(scheduleID)
+		result, err := pipeline.EmailChannel{Emailer: em}.Deliver(ctx, pipeline.Delivery{Files: paths, Recipients: emails, Subject: subjects[i], Body: schedule.Description, UnsubscribeLinks: unsubscribeLinks, AcknowledgeLinks: acknowledgeLinks, Delivered: partDelivered})
+		sent := len(result.Sent)
+		for i := range result.Failures {
+			result.Failures[i].ScheduleID = schedule.ID
+		}
+		if recordErr := re.sql.RecordDeliveryFailures(result.Failures); recordErr != nil {
+			log.DefaultLogger.Error("ReportEmailer.createReport: RecordDeliveryFailures: " + recordErr.Error())
+		}
+		run.MessagesSent += sent
+		if sent > 0 {
+			run.PartsDelivered++
+		}
+		if !run.Test {
+			run.EstimatedCost += float64(sent) * settings.MessageUnitCost
+		}
 		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer: GetSchedule: Could not create report to send.", err.Error())
-			bugsnag.Notify(err)
-		} else {
-			report := reporter.CreateNewReport(scheduleID, schedule.Name)
-			report.SetSheets(reportSheetPanels)
-			err := report.Write(*authConfig)
-			if err != nil {
-				log.DefaultLogger.Error("ReportEmailer.createReports: report.Write: " + err.Error())
-				return err
-			}
+			log.DefaultLogger.Error("ReportEmailer.createReport: Deliver: " + err.Error())
+			return err
 		}
+		attachmentMode = emailer.ReducedMost(attachmentMode, result.AttachmentMode)
 	}
+	run.AttachmentMode = attachmentMode
+
+	return nil
+}
 
-	for scheduleID, recipientEmails := range emails {
-		schedule, err := re.sql.GetSchedule(scheduleID)
+// outbox enqueues the recipients of a schedule's report which was due at scheduledAt the first time it is sent,
+// returning those still waiting for it. A resumed run sends to the recipients it was first enqueued for, less any
+// who have since left the group or unsubscribed.
+func (re *ReportEmailer) outbox(schedule dbstore.Schedule, scheduledAt int, emails []string) ([]string, error) {
+	queued, enqueued, err := re.sql.QueuedAddresses(schedule.ID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.outbox: QueuedAddresses: " + err.Error())
+		return nil, err
+	}
+
+	if !enqueued {
+		err = re.sql.EnqueueMessages(schedule.ID, scheduledAt, emails)
 		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer: GetSchedule: Could not create report to send.", err.Error())
-			bugsnag.Notify(err)
-		} else {
-			attachmentPath := reporter.GetFilePath(schedule.Name)
-			em.BulkCreateAndSend(attachmentPath, recipientEmails, schedule.Name, schedule.Description)
+			log.DefaultLogger.Error("ReportEmailer.outbox: EnqueueMessages: " + err.Error())
+			return nil, err
 		}
+		return emails, nil
 	}
 
-	return nil
-	// re.cleanup(schedules)
+	// Addresses are the same whatever their case, e.g. as a member's email was changed to lower case since
+	recipient := make(map[string]string)
+	for _, email := range emails {
+		recipient[strings.ToLower(email)] = email
+	}
+	remaining := []string{}
+	for _, email := range queued {
+		if current, ok := recipient[strings.ToLower(email)]; ok {
+			remaining = append(remaining, current)
+		}
+	}
+	log.DefaultLogger.Info(fmt.Sprintf("Resuming '%s', %d recipients are still waiting for it", schedule.Name, len(remaining)))
+	return remaining, nil
+}
+
+// groupRecipients is the addresses of the report group's members who haven't unsubscribed from the schedule, with
+// the link each can unsubscribe with
+func (re *ReportEmailer) groupRecipients(ctx context.Context, schedule dbstore.Schedule, reportGroup dbstore.ReportGroup, authConfig *auth.AuthConfig, datasourceID int, em emailer.Emailer) ([]string, map[string]string, error) {
+	userIDs, err := re.sql.GroupMemberUserIDs(reportGroup)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.groupRecipients: GroupMemberUserIDs: " + err.Error())
+		return nil, nil, err
+	}
+
+	// The member query is run now, so the group is whoever it returns at the time of sending
+	emails, err := api.GroupEmails(ctx, *authConfig, userIDs, reportGroup.MemberQuery, datasourceID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.groupRecipients: GroupEmails: " + err.Error())
+		return nil, nil, err
+	}
+
+	contacts, err := re.sql.GetContacts()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.groupRecipients: GetContacts: " + err.Error())
+		return nil, nil, err
+	}
+	resolved := recipients.NewResolver(contacts, recipients.AddressIdentity).Resolve(recipients.FromEmails(emails))
+	emails = recipients.Addresses(resolved, dbstore.ChannelEmail)
+
+	emails, err = re.sql.SubscribedAddresses(schedule.ID, emails)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.groupRecipients: SubscribedAddresses: " + err.Error())
+		return nil, nil, err
+	}
+
+	links, err := re.unsubscribeLinks(schedule, emails, em)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.groupRecipients: unsubscribeLinks: " + err.Error())
+		return nil, nil, err
+	}
+
+	return emails, links, nil
+}
+
+// renderReport generates a schedule's report as name in each of its formats, returning the files of each part it is
+// sent in. datasourceID is only queried by panels using their dashboard's default datasource. The template's content,
+// when the schedule uses one, comes ahead of the schedule's own. The files are written to directory, the schedule's
+// own report directory when empty.
+func (re *ReportEmailer) renderReport(ctx context.Context, schedule dbstore.Schedule, template *dbstore.ReportTemplateVersionInfo, name string, directory string, authConfig *auth.AuthConfig, datasourceID int, settings *dbstore.Settings, run *dbstore.ReportRun) ([][]string, error) {
+	reportPipeline := pipeline.Pipeline{
+		Source:   pipeline.StoreContent{DB: re.sql, AuthConfig: authConfig, Template: template},
+		Composer: pipeline.GrafanaComposer{DB: re.sql, AuthConfig: authConfig, DatasourceID: datasourceID, Settings: settings},
+		Renderer: pipeline.WorkbookRenderer{DB: re.sql, AuthConfig: authConfig, Settings: settings, Directory: directory},
+	}
+	return reportPipeline.Generate(ctx, schedule, name, run)
+}
+
+// applyTemplate is the schedule with its template's files and email body, and the version of the template it
+// renders, nil when it doesn't use one
+func (re *ReportEmailer) applyTemplate(schedule dbstore.Schedule) (dbstore.Schedule, *dbstore.ReportTemplateVersionInfo, error) {
+	if schedule.TemplateID == "" {
+		return schedule, nil, nil
+	}
+
+	template, err := re.sql.GetReportTemplateVersion(schedule.TemplateID, schedule.TemplateVersion)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.applyTemplate: GetReportTemplateVersion: " + err.Error())
+		return schedule, nil, fmt.Errorf("report template %s: %w", schedule.TemplateID, err)
+	}
+
+	return template.Apply(schedule), template, nil
+}
+
+// Preview is a report generated without being sent, so its layout and variables can be checked first
+type Preview struct {
+	ID string `json:"id"`
+	// Names of the files written in the preview's directory, and the links to download them
+	Files        []string `json:"files"`
+	Links        []string `json:"links"`
+	FailedPanels []string `json:"failedPanels"`
+	Message      string   `json:"message"`
+}
+
+// PreviewName is the name a schedule's report is written as when previewed, so it doesn't overwrite one being sent
+func PreviewName(schedule dbstore.Schedule) string {
+	return schedule.Name + " (preview)"
+}
+
+// PreviewsDirectory is where previews are written. It's a temporary directory rather than the data directory, so
+// nothing previewed, e.g. the report of a group encrypting its attachments, is left alongside what's sent.
+var PreviewsDirectory = filepath.Join(os.TempDir(), "msupply-previews")
+
+// How long a preview's files are kept to be downloaded
+const PreviewLifetime = time.Hour
+
+// PreviewDirectory is where a preview of the schedule is written
+func PreviewDirectory(scheduleID string, previewID string) string {
+	return filepath.Join(PreviewsDirectory, filepath.Base(scheduleID), filepath.Base(previewID))
+}
+
+// PrunePreviews deletes the previews made longer ago than they're kept for
+func PrunePreviews(now time.Time) {
+	directories, err := filepath.Glob(filepath.Join(PreviewsDirectory, "*", "*"))
+	if err != nil {
+		log.DefaultLogger.Error("PrunePreviews: filepath.Glob: " + err.Error())
+		return
+	}
+	for _, directory := range directories {
+		if info, err := os.Stat(directory); err == nil && now.Sub(info.ModTime()) > PreviewLifetime {
+			os.RemoveAll(directory)
+		}
+	}
+}
+
+// PreviewReport runs the whole render pipeline for a schedule as if it were due now, without emailing anyone or
+// recording a run. The files are written to a directory of their own, kept until they're pruned.
+func (re *ReportEmailer) PreviewReport(ctx context.Context, schedule dbstore.Schedule, authConfig *auth.AuthConfig, datasourceID int) (*Preview, error) {
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.PreviewReport: GetSettings: " + err.Error())
+		return nil, err
+	}
+
+	schedule, template, err := re.applyTemplate(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	PrunePreviews(time.Now())
+	preview := Preview{ID: uuid.New().String(), Files: []string{}, Links: []string{}, FailedPanels: []string{}}
+	directory := PreviewDirectory(schedule.ID, preview.ID)
+
+	run := &dbstore.ReportRun{ScheduleID: schedule.ID}
+	parts, err := re.renderReport(ctx, schedule, template, PreviewName(schedule), directory, authConfig, datasourceID, settings, run)
+	if err != nil {
+		os.RemoveAll(directory)
+		return nil, err
+	}
+
+	preview.Message = run.Message
+	for _, paths := range parts {
+		for _, path := range paths {
+			preview.Files = append(preview.Files, filepath.Base(path))
+		}
+	}
+	if run.FailedPanels != nil {
+		preview.FailedPanels = run.FailedPanels
+	}
+
+	return &preview, nil
+}
+
+// sharer shares the files of a schedule's report which are linked to rather than attached, as links its owner can
+// revoke. The copies shared are removed once the links expire.
+func (re *ReportEmailer) sharer(schedule dbstore.Schedule) emailer.Sharer {
+	return func(path string) (string, string, error) {
+		secret, err := re.sql.Secret(dbstore.ShareLinkSecret)
+		if err != nil {
+			return "", "", err
+		}
+		copied, err := re.sql.ShareFile(path)
+		if err != nil {
+			return "", "", err
+		}
+		link, err := re.sql.CreateShareLink(copied, dbstore.AttachmentLinkHours, schedule.Owner)
+		if err != nil {
+			return "", "", err
+		}
+		return link.ID, dbstore.SignShareLink(secret, *link), nil
+	}
+}
+
+// unsubscribeLinks signs a link for each address to opt out of the schedule with
+func (re *ReportEmailer) unsubscribeLinks(schedule dbstore.Schedule, emails []string, em emailer.Emailer) (map[string]string, error) {
+	secret, err := re.sql.Secret(dbstore.UnsubscribeSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[string]string)
+	for _, email := range emails {
+		links[email] = em.UnsubscribeLink(re.sql.OrgID(), schedule.ID, email, dbstore.SignUnsubscribe(secret, schedule.ID, email))
+	}
+	return links, nil
+}
+
+// acknowledgeLinks signs a link for each address to mark the run's report as reviewed with
+func (re *ReportEmailer) acknowledgeLinks(run *dbstore.ReportRun, emails []string, em emailer.Emailer) (map[string]string, error) {
+	secret, err := re.sql.Secret(dbstore.AcknowledgeSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make(map[string]string)
+	for _, email := range emails {
+		links[email] = em.AcknowledgeLink(re.sql.OrgID(), run.ID, email, dbstore.SignAcknowledgement(secret, run.ID, email))
+	}
+	return links, nil
+}
+
+// dataArrived checks whether an overdue schedule should be sent now. Time triggered schedules always are,
+// data triggered schedules stay overdue until their trigger query returns something new. An empty result is a value
+// like any other, so data being cleared out is new too.
+func (re *ReportEmailer) dataArrived(ctx context.Context, schedule dbstore.Schedule, authConfig *auth.AuthConfig, datasourceID int) (string, bool) {
+	if schedule.TriggerType != dbstore.TriggerTypeData || schedule.TriggerQuery == "" {
+		return "", true
+	}
+
+	value, err := api.QueryValue(ctx, *authConfig, schedule.TriggerQuery, datasourceID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.dataArrived: QueryValue: " + schedule.Name + ": " + err.Error())
+		return "", false
+	}
+
+	if value == schedule.TriggerValue {
+		log.DefaultLogger.Info(fmt.Sprintf("Waiting for new data for '%s', trigger value is still '%s'", schedule.Name, value))
+		return value, false
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("New data for '%s', trigger value changed from '%s' to '%s'", schedule.Name, schedule.TriggerValue, value))
+	return value, true
+}
+
+// retriesExhausted is whether a failing report has used up its retries, so it should wait until it is next due.
+// The first attempt isn't a retry, so a limit of 0 gives up after it.
+func (re *ReportEmailer) retriesExhausted(settings *dbstore.Settings, schedule dbstore.Schedule, scheduledAt int) bool {
+	limit := dbstore.ResolveSettings(settings, schedule, nil).MaxRetries
+	if !limit.Set {
+		return false
+	}
+
+	failed, err := re.sql.CountFailedRuns(schedule.ID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.retriesExhausted: CountFailedRuns: " + err.Error())
+		return false
+	}
+
+	return failed > limit.Retries
 }
 
 func (re *ReportEmailer) CreateReports() {
 	log.DefaultLogger.Info("Creating Reports...")
-	re.inProgress = true
 
-	authConfig, emailConfig, datasourceID, err := re.configs()
+	authConfig, _, settings, err := re.configs()
 	if err != nil {
 		log.DefaultLogger.Error("ReportEmailer.createReports: re.configs: " + err.Error())
 		return
 	}
 
-	em := emailer.New(emailConfig)
+	// Overdue schedules are left as they are, and sent once read-only mode is switched off
+	if settings.ReadOnly {
+		log.DefaultLogger.Info("Read-only mode is on, no reports will be sent")
+		return
+	}
 
 	schedules, err := re.sql.OverdueSchedules()
 	if err != nil {
@@ -180,91 +794,79 @@ func (re *ReportEmailer) CreateReports() {
 		return
 	}
 
-	emails := make(map[string][]string)
-	panels := make(map[string][]api.TablePanel)
+	// Runs starting while Grafana is restarting wait a short while for it, then stay overdue until the next pass
+	ctx, cancel := context.WithTimeout(context.Background(), api.GrafanaRestartWindow+time.Minute)
+	err = api.WaitForGrafana(ctx, authConfig, api.GrafanaRestartWindow)
+	cancel()
+	if err != nil {
+		log.DefaultLogger.Warn("ReportEmailer.createReports: " + err.Error() + ", overdue reports are queued until it's back")
+		return
+	}
+
+	var due []dueSchedule
 	for _, schedule := range schedules {
-		reportGroup, err := re.sql.ReportGroupFromSchedule(schedule)
+		// A job still queued or running from an earlier pass moves the schedule on once it's done
+		active, err := re.sql.HasActiveJob(jobs.KindScheduled, schedule.ID)
 		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer.createReports: ReportGroupFromSchedule: " + err.Error())
-			return
+			log.DefaultLogger.Error("ReportEmailer.createReports: HasActiveJob: " + err.Error())
+			continue
 		}
-
-		userIDs, err := re.sql.GroupMemberUserIDs(*reportGroup)
-		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer.createReports: GroupMemberUserIDs: " + err.Error())
-			return
+		if active {
+			log.DefaultLogger.Info(fmt.Sprintf("'%s' is still queued from an earlier pass", schedule.Name))
+			continue
 		}
-
-		emailsFromUsers, err := api.GetEmails(*authConfig, userIDs, datasourceID)
-		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer.createReports: emailsFromUsers: " + err.Error())
-			return
+		runs := re.scheduler.Due(settings, schedule)
+		if len(runs) == 0 {
+			continue
 		}
+		due = append(due, dueSchedule{schedule: schedule, runs: runs})
+	}
+	spread(due, settings.SpreadWindow, re.scheduler.Now())
 
-		emails[schedule.ID] = emailsFromUsers
-
-		reportContent, err := re.sql.GetReportContent(schedule.ID)
+	workers := Workers(re.sql)
+	for _, d := range due {
+		payload, err := json.Marshal(d.runs)
 		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer.createReports: GetReportContent: " + err.Error())
-			return
+			log.DefaultLogger.Error("ReportEmailer.createReports: json.Marshal: " + err.Error())
+			continue
 		}
-
-		panels[schedule.ID] = []api.TablePanel{}
-
-		for _, content := range reportContent {
-			interval := int64(schedule.Interval)
-			to := strconv.FormatInt(time.Now().Unix(), 10)
-			from := strconv.FormatInt(time.Now().Unix()-interval, 10)
-
-			dashboard, err := api.NewDashboard(authConfig, content.DashboardID, from, to, datasourceID)
-
-			if err != nil {
-				log.DefaultLogger.Error("ReportEmailer.createReports: NewDashboard: " + err.Error())
-				return
-			}
-
-			panel := dashboard.Panel(content.PanelID)
-
-			if panel != nil {
-				panel.PrepSql(dashboard.Variables, content.Variables)
-				panels[schedule.ID] = append(panels[schedule.ID], *panel)
-			}
-
+		job := dbstore.Job{Kind: jobs.KindScheduled, ScheduleID: d.schedule.ID, ScheduleName: d.schedule.Name, Priority: d.schedule.Priority, Payload: string(payload), StartAt: int(d.startAt.Unix())}
+		if _, err := workers.Enqueue(job); err != nil {
+			log.DefaultLogger.Error("ReportEmailer.createReports: Enqueue: " + d.schedule.Name + ": " + err.Error())
 		}
 	}
+}
 
-	templatePath := reporter.GetFilePath("template")
-	reporter := reporter.NewReporter(templatePath)
-
-	for scheduleID, reportSheetPanels := range panels {
-		schedule, err := re.sql.GetSchedule(scheduleID)
-		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer: GetSchedule: Could not create report to send.", err.Error())
-			bugsnag.Notify(err)
-		} else {
-			report := reporter.CreateNewReport(scheduleID, schedule.Name)
-
-			report.SetSheets(reportSheetPanels)
-			err := report.Write(*authConfig)
-			if err != nil {
-				log.DefaultLogger.Error("ReportEmailer.createReports: report.Write: " + err.Error())
-				return
-			}
-		}
+// dueSchedule is a schedule's runs to send in this pass, from startAt once spread out
+type dueSchedule struct {
+	schedule dbstore.Schedule
+	runs     []scheduler.Run
+	startAt  time.Time
+}
 
+// spread staggers the schedules due at the same time evenly over window seconds from when they were due, highest
+// priority first, so they don't all start at once. Schedules which have already waited past their turn start
+// straight away.
+func spread(due []dueSchedule, window int, now time.Time) {
+	sort.SliceStable(due, func(i, j int) bool {
+		return dbstore.PriorityRank(due[i].schedule.Priority) > dbstore.PriorityRank(due[j].schedule.Priority)
+	})
+
+	together := make(map[int][]int)
+	for i, d := range due {
+		together[d.runs[0].ScheduledAt] = append(together[d.runs[0].ScheduledAt], i)
 	}
 
-	for scheduleID, recipientEmails := range emails {
-		schedule, err := re.sql.GetSchedule(scheduleID)
-		if err != nil {
-			log.DefaultLogger.Error("ReportEmailer: GetSchedule: Could not create report to send.", err.Error())
-			bugsnag.Notify(err)
-		} else {
-			attachmentPath := reporter.GetFilePath(schedule.Name)
-			em.BulkCreateAndSend(attachmentPath, recipientEmails, schedule.Name, schedule.Description)
+	for scheduledAt, indexes := range together {
+		for position, i := range indexes {
+			offset := 0
+			if window > 0 {
+				offset = position * window / len(indexes)
+			}
+			due[i].startAt = time.Unix(int64(scheduledAt+offset), 0)
+			if due[i].startAt.Before(now) {
+				due[i].startAt = now
+			}
 		}
 	}
-
-	re.cleanup(schedules)
-
 }
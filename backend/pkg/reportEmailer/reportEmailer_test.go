@@ -0,0 +1,42 @@
+package reportEmailer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/pipeline"
+)
+
+func TestOnlyReportsNotYetSentAreRetried(t *testing.T) {
+	failed := errors.New("panel failed")
+	cases := []struct {
+		name string
+		run  dbstore.ReportRun
+		err  error
+		want bool
+	}{
+		{"failed before sending", dbstore.ReportRun{}, failed, true},
+		{"failed after sending to some", dbstore.ReportRun{MessagesSent: 3, EstimatedCost: 0.3, PartsDelivered: 1}, failed, false},
+		{"failed after a part went out", dbstore.ReportRun{PartsDelivered: 1}, failed, false},
+		{"condition not met", dbstore.ReportRun{}, pipeline.ErrConditionNotMet, false},
+		{"sent", dbstore.ReportRun{MessagesSent: 3}, nil, false},
+	}
+	for _, c := range cases {
+		if got := retryable(&c.run, c.err); got != c.want {
+			t.Errorf("%s: expected retryable %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestRetriedAttemptStartsFromNothing(t *testing.T) {
+	run := dbstore.ReportRun{Message: "panel failed", FailedPanels: []string{"Stock"}, MessagesSent: 3, EstimatedCost: 0.3, PartsDelivered: 1, AttachmentMode: "attached"}
+	resetAttempt(&run)
+
+	if run.MessagesSent != 0 || run.EstimatedCost != 0 || run.PartsDelivered != 0 {
+		t.Errorf("expected the retry not to add to the first attempt's sends, got %d sent costing %f", run.MessagesSent, run.EstimatedCost)
+	}
+	if run.Message != "" || len(run.FailedPanels) != 0 || run.AttachmentMode != "" {
+		t.Errorf("expected the first attempt's outcome to be cleared, got %+v", run)
+	}
+}
@@ -0,0 +1,45 @@
+package reportEmailer
+
+import (
+	"context"
+	"html"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+)
+
+// WarnSigningExpiry lets the admin know, each time it's run, when the certificate emails are signed with has expired
+// or expires within dbstore.SigningExpiryWarning
+func (re *ReportEmailer) WarnSigningExpiry(now time.Time) {
+	settings, err := re.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.WarnSigningExpiry: GetSettings: " + err.Error())
+		return
+	}
+	identity, err := dbstore.ParseSigningIdentity(settings.SigningCertificate, settings.SigningKey)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.WarnSigningExpiry: ParseSigningIdentity: " + err.Error())
+		return
+	}
+	if identity == nil {
+		return
+	}
+
+	warning := identity.ExpiryWarning(now)
+	if warning == "" {
+		return
+	}
+	log.DefaultLogger.Warn("ReportEmailer.WarnSigningExpiry: " + warning)
+	if settings.AdminEmail == "" {
+		return
+	}
+
+	em := emailer.New(auth.NewSettingsEmailConfig(settings))
+	body := "<p>The S/MIME certificate reports' emails are signed with, issued to " + html.EscapeString(identity.Certificate.Subject.String()) + ": " + html.EscapeString(warning) + ".</p>"
+	if err := em.Send(context.Background(), settings.AdminEmail, "Signing certificate expiry", body); err != nil {
+		log.DefaultLogger.Error("ReportEmailer.WarnSigningExpiry: Send: " + err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package reportEmailer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// TenantEmailers sends the reports in every organisation's database, each with its own ReportEmailer so
+// a run in progress for one organisation doesn't hold up the others
+type TenantEmailers struct {
+	sql      *dbstore.SQLiteDatasource
+	mutex    sync.Mutex
+	emailers map[*dbstore.SQLiteDatasource]*ReportEmailer
+}
+
+func NewTenantEmailers(sql *dbstore.SQLiteDatasource) *TenantEmailers {
+	return &TenantEmailers{sql: sql, emailers: make(map[*dbstore.SQLiteDatasource]*ReportEmailer)}
+}
+
+func (te *TenantEmailers) emailer(tenant *dbstore.SQLiteDatasource) *ReportEmailer {
+	te.mutex.Lock()
+	defer te.mutex.Unlock()
+
+	emailer, ok := te.emailers[tenant]
+	if !ok {
+		emailer = NewReportEmailer(tenant)
+		te.emailers[tenant] = emailer
+	}
+	return emailer
+}
+
+func (te *TenantEmailers) CreateReports() {
+	te.sql.ForEachTenant(func(tenant *dbstore.SQLiteDatasource) {
+		te.emailer(tenant).CreateReports()
+	})
+}
+
+func (te *TenantEmailers) WarnSigningExpiry() {
+	te.sql.ForEachTenant(func(tenant *dbstore.SQLiteDatasource) {
+		te.emailer(tenant).WarnSigningExpiry(time.Now())
+	})
+}
@@ -0,0 +1,184 @@
+package reportEmailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+var (
+	workersMutex sync.Mutex
+	workers      = make(map[string]*jobs.Pool)
+)
+
+// Workers is the pool generating the reports of a database, shared by the scheduler and the test emails
+func Workers(sql *dbstore.SQLiteDatasource) *jobs.Pool {
+	workersMutex.Lock()
+	defer workersMutex.Unlock()
+
+	pool, ok := workers[sql.Path]
+	if !ok {
+		re := NewReportEmailer(sql)
+		pool = jobs.NewPool(sql)
+		pool.Handle(jobs.KindScheduled, re.runScheduled, re.skipScheduled)
+		pool.Handle(jobs.KindTest, re.runTest, nil)
+		workers[sql.Path] = pool
+	}
+	return pool
+}
+
+// TestPayload is who a test job sends to, its report group when there's no one
+type TestPayload struct {
+	To []string `json:"to"`
+}
+
+// ReportTimeout is how long a report can take to generate before it is given up on
+func ReportTimeout(settings *dbstore.Settings) time.Duration {
+	if settings.ReportTimeout > 0 {
+		return time.Duration(settings.ReportTimeout) * time.Second
+	}
+	return DefaultReportTimeout
+}
+
+// runScheduled sends a schedule's due runs, then moves it on to when it's next due. It is held at a run which
+// fails, so it is retried. A run which used up its retries is given up on, failing the job once the later runs have
+// been sent. Cancelling it skips the runs which haven't been sent.
+func (re *ReportEmailer) runScheduled(ctx context.Context, job dbstore.Job) error {
+	var runs []scheduler.Run
+	if err := json.Unmarshal([]byte(job.Payload), &runs); err != nil {
+		return err
+	}
+
+	schedule, err := re.sql.GetSchedule(job.ScheduleID)
+	if err != nil {
+		return err
+	}
+
+	authConfig, emailConfig, settings, err := re.configs()
+	if err != nil {
+		return err
+	}
+	em := emailer.New(emailConfig)
+	timeout := ReportTimeout(settings)
+
+	// Each report gets its own deadline so one slow report can't hold up the rest
+	readyCtx, cancel := context.WithTimeout(ctx, timeout)
+	triggerValue, ready := re.dataArrived(readyCtx, *schedule, authConfig, settings.DatasourceID)
+	cancel()
+	if ctx.Err() != nil {
+		re.cleanup([]dbstore.Schedule{*schedule})
+		return ctx.Err()
+	}
+	if !ready {
+		return nil
+	}
+
+	sent := false
+	// Why the job fails once its runs have been dealt with and the schedule cleaned up
+	var failed error
+	for _, run := range runs {
+		// Sent to everyone before the job stopped and was queued again, so it isn't generated again for no one
+		queued, enqueued, err := re.sql.QueuedAddresses(schedule.ID, run.ScheduledAt)
+		if err != nil {
+			return err
+		}
+		if enqueued && len(queued) == 0 {
+			log.DefaultLogger.Info(fmt.Sprintf("The run of '%s' due at %s was already sent", schedule.Name, time.Unix(int64(run.ScheduledAt), 0)))
+			sent = true
+			continue
+		}
+
+		metrics.SchedulerLag.Observe(time.Since(time.Unix(int64(run.ScheduledAt), 0)).Seconds())
+
+		runCtx, cancel := context.WithTimeout(ctx, timeout)
+		err = re.CreateReport(runCtx, *schedule, run.ScheduledAt, authConfig, settings.DatasourceID, *em)
+		cancel()
+		if ctx.Err() != nil {
+			log.DefaultLogger.Info(fmt.Sprintf("'%s' was cancelled, skipping its remaining runs", schedule.Name))
+			break
+		}
+		if err == nil {
+			sent = true
+			continue
+		}
+
+		log.DefaultLogger.Error("ReportEmailer.runScheduled: CreateReport: " + schedule.Name + ": " + err.Error())
+		// Grafana being down says nothing about the report, so it doesn't use up the schedule's retries
+		if errors.Is(err, api.ErrGrafanaUnavailable) {
+			re.scheduler.Hold(*schedule, run.ScheduledAt)
+			return err
+		}
+		if !re.retriesExhausted(settings, *schedule, run.ScheduledAt) {
+			re.scheduler.Hold(*schedule, run.ScheduledAt)
+			return err
+		}
+		log.DefaultLogger.Warn(fmt.Sprintf("Giving up on the run of '%s' due at %s", schedule.Name, time.Unix(int64(run.ScheduledAt), 0)))
+		failed = fmt.Errorf("gave up on the run due at %s after it used up its retries: %w", time.Unix(int64(run.ScheduledAt), 0).Format(time.RFC3339), err)
+	}
+
+	if sent && schedule.TriggerType == dbstore.TriggerTypeData {
+		// Left at the old value, the same data would be sent again on the next pass
+		if err := re.sql.SetScheduleTriggerValue(schedule.ID, triggerValue); err != nil {
+			log.DefaultLogger.Error("ReportEmailer.runScheduled: SetScheduleTriggerValue: " + schedule.Name + ": " + err.Error())
+			if failed == nil {
+				failed = err
+			}
+		}
+	}
+	// Cleaned up before the job finishes, so a later pass can't find it still overdue once it's no longer queued
+	re.cleanup([]dbstore.Schedule{*schedule})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return failed
+}
+
+// skipScheduled moves a schedule whose job was cancelled before it started on to when it's next due, rather than
+// leaving it overdue to be queued again
+func (re *ReportEmailer) skipScheduled(job dbstore.Job) {
+	schedule, err := re.sql.GetSchedule(job.ScheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.skipScheduled: GetSchedule: " + err.Error())
+		return
+	}
+	re.cleanup([]dbstore.Schedule{*schedule})
+}
+
+// runTest sends a schedule's report now, to the addresses in the job or otherwise to its report group
+func (re *ReportEmailer) runTest(ctx context.Context, job dbstore.Job) error {
+	var payload TestPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return err
+	}
+
+	schedule, err := re.sql.GetSchedule(job.ScheduleID)
+	if err != nil {
+		return err
+	}
+
+	authConfig, emailConfig, settings, err := re.configs()
+	if err != nil {
+		return err
+	}
+
+	run, err := re.sql.CreateTestRun(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("ReportEmailer.runTest: CreateTestRun: " + err.Error())
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ReportTimeout(settings))
+	defer cancel()
+	return re.sendReport(ctx, *schedule, run, authConfig, settings.DatasourceID, *emailer.New(emailConfig), payload.To)
+}
@@ -0,0 +1,122 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+)
+
+// Excel chart types used for each chart type of the report content. Grafana draws bars
+// vertically, which Excel calls a column chart.
+var excelChartTypes = map[string]string{"line": "line", "bar": "col"}
+
+// Pixels of a default row, used to place a chart below the panel's image
+const rowHeight = 20
+
+type chartSeries struct {
+	Name       string `json:"name"`
+	Categories string `json:"categories,omitempty"`
+	Values     string `json:"values"`
+}
+
+type chartFormat struct {
+	Type      string        `json:"type"`
+	Series    []chartSeries `json:"series"`
+	Title     chartTitle    `json:"title"`
+	Dimension chartSize     `json:"dimension"`
+}
+
+type chartTitle struct {
+	Name string `json:"name"`
+}
+
+type chartSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// chartColumns finds the column to label the chart's points with, the first which isn't
+// a number, and the columns holding numbers, which become its series
+func chartColumns(columns []api.Column, rows [][]interface{}) (int, []int) {
+	category := -1
+	var series []int
+
+	for i := range columns {
+		numeric, hasValue := true, false
+		for _, row := range rows {
+			if i >= len(row) || row[i] == nil {
+				continue
+			}
+			hasValue = true
+			if _, ok := row[i].(float64); !ok {
+				numeric = false
+				break
+			}
+			if _, ok := toDateString(row[i]); ok {
+				numeric = false
+				break
+			}
+		}
+
+		if numeric && hasValue {
+			series = append(series, i)
+		} else if category < 0 {
+			category = i
+		}
+	}
+
+	return category, series
+}
+
+func absoluteRange(sheetName string, column int, firstRow int, lastRow int) string {
+	sheet := "'" + strings.Replace(sheetName, "'", "''", -1) + "'"
+	col := intToCol(column)
+	if firstRow == lastRow {
+		return fmt.Sprintf("%s!$%s$%d", sheet, col, firstRow)
+	}
+	return fmt.Sprintf("%s!$%s$%d:$%s$%d", sheet, col, firstRow, col, lastRow)
+}
+
+// writeChart adds a native Excel chart of the panel's numeric columns, read from the cells
+// its data was written to, so recipients can work with the numbers behind it
+func (r *Report) writeChart(sheetName string, panel api.TablePanel, headerRow int, firstRow int) error {
+	chartType, ok := excelChartTypes[panel.ChartType]
+	if !ok || len(panel.Rows) == 0 {
+		return nil
+	}
+
+	category, series := chartColumns(panel.Columns, panel.Rows)
+	if len(series) == 0 {
+		return nil
+	}
+
+	lastRow := firstRow + len(panel.Rows) - 1
+	format := chartFormat{Type: chartType, Title: chartTitle{Name: panel.Title}, Dimension: chartSize{Width: 640, Height: 360}}
+	for _, column := range series {
+		s := chartSeries{Name: absoluteRange(sheetName, column, headerRow, headerRow), Values: absoluteRange(sheetName, column, firstRow, lastRow)}
+		if category >= 0 {
+			s.Categories = absoluteRange(sheetName, category, firstRow, lastRow)
+		}
+		format.Series = append(format.Series, s)
+	}
+
+	formatJSON, err := json.Marshal(format)
+	if err != nil {
+		return err
+	}
+
+	// To the right of the data, below the panel's image if it has one
+	row := 1
+	if len(panel.Image) > 0 && panel.RenderOptions != nil {
+		options := panel.RenderOptions.Capped()
+		scale := options.Scale
+		if scale <= 0 {
+			scale = 1
+		}
+		row += int(float64(options.Height)*scale)/rowHeight + 2
+	}
+
+	return r.file.AddChart(sheetName, r.createCellRef(len(panel.Columns)+1, row), string(formatJSON))
+}
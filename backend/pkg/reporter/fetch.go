@@ -0,0 +1,269 @@
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+	"github.com/grafana/simple-datasource-backend/pkg/metrics"
+	"github.com/grafana/simple-datasource-backend/pkg/progress"
+)
+
+const (
+	DefaultConcurrency = 4
+	DefaultTimeout     = 60 * time.Second
+)
+
+// Options control how the panels of a report are fetched
+type Options struct {
+	// Number of panels fetched from Grafana at the same time
+	Concurrency int
+	// Time allowed for each request to Grafana
+	Timeout time.Duration
+	// The panels already have their data and nothing is fetched from Grafana, used by the load test
+	Prefetched bool
+	// Renders the panels' images, Grafana's renderer when nil
+	Renderer api.Renderer
+	// Masks identifying data in the panels' data before any of it is written, nothing is masked when nil
+	Masker *dbstore.Masker
+	// Images rendered before for panels whose data hasn't changed since, every panel is rendered when nil
+	Images ImageCache
+	// Marks the report as the organisation's, unbranded when nil
+	Branding *dbstore.Branding
+	// What the report's own text, e.g. its dates and headings, is written in, the default locale when empty
+	Locale string
+	// Where the report's files are written, the data directory when empty
+	Directory string
+	// Where the JSON of the report's run is archived, the data directory's archive when empty
+	ArchiveDirectory string
+}
+
+// ImageCache keeps panels' images by their fingerprint, so a panel is only rendered again once its data changes.
+// It's used by the panels' workers at the same time.
+type ImageCache interface {
+	// Image is the image rendered for a panel with the fingerprint, nil if there isn't one
+	Image(fingerprint string) []byte
+	// Keep is given each image rendered or reused
+	Keep(fingerprint string, image []byte)
+}
+
+// localizer writes the report's own text in the locale of its options
+func (r *Report) localizer() i18n.Localizer {
+	return i18n.For(r.options.Locale)
+}
+
+func DefaultOptions() Options {
+	return Options{Concurrency: DefaultConcurrency, Timeout: DefaultTimeout}
+}
+
+// NewOptions uses the configured settings, falling back to the defaults for any which aren't set
+func NewOptions(settings *dbstore.Settings) Options {
+	options := DefaultOptions()
+	if settings.RenderConcurrency > 0 {
+		options.Concurrency = settings.RenderConcurrency
+	}
+	if settings.RenderTimeout > 0 {
+		options.Timeout = time.Duration(settings.RenderTimeout) * time.Second
+	}
+	options.Renderer = api.NewRenderer(settings)
+	return options
+}
+
+type PanelError struct {
+	Index int
+	Title string
+	Err   error
+}
+
+// Titles of the panels which failed
+func (errs PanelErrors) Titles() []string {
+	titles := []string{}
+	for _, err := range errs {
+		titles = append(titles, err.Title)
+	}
+	return titles
+}
+
+// PanelErrors are the panels of a report which could not be fetched. The rest of the report
+// is still written, with the failed panels' sheets explaining what went wrong.
+type PanelErrors []PanelError
+
+func (errs PanelErrors) Error() string {
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Title, err.Err.Error()))
+	}
+	return fmt.Sprintf("%d panel(s) failed: %s", len(errs), strings.Join(messages, "; "))
+}
+
+func (errs PanelErrors) forPanel(index int) *PanelError {
+	for i, err := range errs {
+		if err.Index == index {
+			return &errs[i]
+		}
+	}
+	return nil
+}
+
+// fetchPanels gets the data and image of every panel using a bounded pool of workers. Text has nothing to fetch.
+func (r *Report) fetchPanels(ctx context.Context, authConfig auth.AuthConfig) PanelErrors {
+	fetching := []int{}
+	for i := range r.sheets {
+		if !r.sheets[i].IsText() {
+			fetching = append(fetching, i)
+		}
+	}
+
+	concurrency := r.options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > len(fetching) {
+		concurrency = len(fetching)
+	}
+
+	tracker := progress.From(ctx)
+	tracker.Panels(len(fetching))
+
+	errs := make([]error, len(r.sheets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = r.fetchPanel(ctx, authConfig, i)
+				tracker.PanelDone(errs[i])
+			}
+		}()
+	}
+
+	for _, i := range fetching {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var panelErrors PanelErrors
+	for i, err := range errs {
+		if err != nil {
+			log.DefaultLogger.Error(fmt.Sprintf("fetchPanels: %s: %s", r.sheets[i].Title, r.options.Masker.Text(err.Error())))
+			panelErrors = append(panelErrors, PanelError{Index: i, Title: r.sheets[i].Title, Err: err})
+		}
+	}
+
+	return panelErrors
+}
+
+func (r *Report) fetchPanel(ctx context.Context, authConfig auth.AuthConfig, index int) (err error) {
+	panel := &r.sheets[index]
+	defer func(started time.Time) { metrics.ObserveRender(started, err) }(time.Now())
+
+	// The report as a whole may have been cancelled while this panel was waiting for a worker
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Panels only included as images have no data, so nothing to tell whether their image could be reused
+	if panel.ImageOnly {
+		return r.renderPanel(ctx, authConfig, panel)
+	}
+
+	dataCtx, dataCancel := context.WithTimeout(ctx, r.timeout(panel))
+	defer dataCancel()
+	if err := panel.GetData(dataCtx, authConfig); err != nil {
+		return err
+	}
+
+	if r.options.Images == nil || panel.RenderOptions == nil {
+		return r.renderPanel(ctx, authConfig, panel)
+	}
+
+	fingerprint, err := panel.Fingerprint()
+	if err != nil {
+		log.DefaultLogger.Warn("fetchPanel: " + panel.Title + ": Fingerprint(): " + err.Error())
+		return r.renderPanel(ctx, authConfig, panel)
+	}
+	if image := r.options.Images.Image(fingerprint); image != nil {
+		log.DefaultLogger.Debug("fetchPanel: " + panel.Title + " is unchanged, reusing its image")
+		metrics.ImagesReusedTotal.Inc()
+		panel.Image = image
+		r.options.Images.Keep(fingerprint, image)
+		return nil
+	}
+
+	if err := r.renderPanel(ctx, authConfig, panel); err != nil {
+		return err
+	}
+	r.options.Images.Keep(fingerprint, panel.Image)
+	return nil
+}
+
+// timeout is the time allowed for each of a panel's requests, its own when its content sets one
+func (r *Report) timeout(panel *api.TablePanel) time.Duration {
+	if panel.QueryTimeout > 0 {
+		return time.Duration(panel.QueryTimeout) * time.Second
+	}
+	return r.options.Timeout
+}
+
+func (r *Report) renderPanel(ctx context.Context, authConfig auth.AuthConfig, panel *api.TablePanel) error {
+	// Each request gets the full timeout, rendering is often slower than querying
+	imageCtx, imageCancel := context.WithTimeout(ctx, r.timeout(panel))
+	defer imageCancel()
+	return panel.GetImage(imageCtx, authConfig, r.options.Renderer)
+}
+
+// maskSheets masks the panels' data in place, so the report and every other format written from it only
+// ever hold it masked. Images are left as they are, the masking rules can't see into them. Panels are only masked
+// once, however many times the report is written.
+func (r *Report) maskSheets() {
+	if r.options.Masker == nil || r.masked {
+		return
+	}
+	r.masked = true
+	for i := range r.sheets {
+		columns := make([]string, len(r.sheets[i].Columns))
+		for j, column := range r.sheets[i].Columns {
+			columns[j] = column.Text
+		}
+		r.options.Masker.Table(columns, r.sheets[i].Rows)
+	}
+}
+
+// writeError fills a panel's sheet with the reason it couldn't be fetched, and a
+// placeholder image if the panel was meant to be rendered
+func (r *Report) writeError(sheetName string, panel api.TablePanel, err error) error {
+	idx, placeholderErr := r.placeholderRowRef(sheetName, "{{headers}}")
+	if placeholderErr != nil {
+		return placeholderErr
+	}
+	r.writeCell(sheetName, r.createCellRef(0, idx), "Unable to render this panel: "+r.options.Masker.Text(err.Error()))
+
+	if panel.RenderOptions != nil {
+		image, imageErr := placeholderImage(*panel.RenderOptions)
+		if imageErr != nil {
+			return imageErr
+		}
+		if imageErr := r.writeImage(sheetName, 1, image); imageErr != nil {
+			return imageErr
+		}
+	}
+
+	idx, placeholderErr = r.placeholderRowRef(sheetName, "{{rows}}")
+	if placeholderErr != nil {
+		return placeholderErr
+	}
+	r.writeCell(sheetName, r.createCellRef(0, idx), "")
+
+	return nil
+}
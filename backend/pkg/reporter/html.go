@@ -0,0 +1,203 @@
+package reporter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Everything is inlined so the file can be posted on an intranet or opened offline. Printed, e.g. to a PDF, the
+// header is a cover page, each section starts on a new page and the branding's logo and text are on every page.
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; margin: 0 auto; padding: 16px; max-width: 1200px; color: #222; }
+header { border-bottom: 2px solid {{.Accent}}; margin-bottom: 24px; }
+h1 { margin: 0 0 4px; }
+h1, h2.section { color: {{.Accent}}; }
+h2.section { border-bottom: 1px solid #ddd; padding-bottom: 4px; margin-top: 48px; }
+.brand { display: flex; align-items: center; gap: 12px; color: #444; margin-bottom: 12px; }
+.brand img { max-height: 64px; width: auto; margin: 0; }
+footer { border-top: 1px solid #ddd; color: #666; font-size: 13px; padding-top: 8px; }
+.period, .generated { color: #666; margin: 0 0 8px; }
+section { margin-bottom: 40px; }
+nav ul { padding-left: 20px; }
+.text { margin-bottom: 24px; line-height: 1.5; }
+@media print {
+.brand, footer { position: fixed; left: 0; right: 0; background: #fff; }
+.brand { top: 0; }
+footer { bottom: 0; }
+body { padding: 96px 0 48px; }
+header { page-break-after: always; border-bottom: none; }
+h2.section { page-break-before: always; margin-top: 0; }
+section { page-break-inside: avoid; }
+}
+img { max-width: 100%; height: auto; display: block; margin: 12px 0; }
+.table { overflow-x: auto; }
+table { border-collapse: collapse; width: 100%; font-size: 14px; }
+th, td { border: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+th { background: #f0f3f9; position: sticky; top: 0; }
+tr:nth-child(even) td { background: #fafafa; }
+td.number { text-align: right; }
+.empty { color: #666; font-style: italic; }
+</style>
+</head>
+<body>
+{{if or .Logo .HeaderText}}<div class="brand">{{if .Logo}}<img src="{{.Logo}}" alt="">{{end}}{{if .HeaderText}}<span>{{.HeaderText}}</span>{{end}}</div>{{end}}
+<header>
+<h1>{{.Title}}</h1>
+{{if .Period}}<p class="period">{{.Period}}</p>{{end}}
+<p class="generated">{{.Generated}}</p>
+{{if gt .Links 1}}<nav><ul>{{range .Contents}}{{if .Title}}<li><a href="#{{.ID}}">{{.Title}}</a>{{if .Panels}}<ul>{{range .Panels}}<li><a href="#{{.ID}}">{{.Title}}</a></li>{{end}}</ul>{{end}}</li>{{else}}{{range .Panels}}<li><a href="#{{.ID}}">{{.Title}}</a></li>{{end}}{{end}}{{end}}</ul></nav>{{end}}
+</header>
+{{range $i, $panel := .Panels}}
+{{if $panel.Section}}<h2 class="section" id="section-{{$i}}">{{$panel.Section}}</h2>{{end}}
+{{if $panel.Text}}<div class="text">{{$panel.Text}}</div>{{else}}
+<section id="panel-{{$i}}">
+{{if $.Sectioned}}<h3>{{$panel.Title}}</h3>{{else}}<h2>{{$panel.Title}}</h2>{{end}}
+{{if $panel.Image}}<img src="{{$panel.Image}}" alt="{{$panel.Title}}">{{end}}
+{{if $panel.Rows}}
+<div class="table"><table>
+<thead><tr>{{range $panel.Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>{{range $panel.Rows}}<tr>{{range .}}<td{{if .Number}} class="number"{{end}}>{{.Text}}</td>{{end}}</tr>{{end}}</tbody>
+</table></div>
+{{else}}<p class="empty">{{$.NoData}}</p>{{end}}
+</section>
+{{end}}
+{{end}}
+{{if .FooterText}}<footer>{{.FooterText}}</footer>{{end}}
+</body>
+</html>
+`))
+
+type htmlCell struct {
+	Text   string
+	Number bool
+}
+
+type htmlPanel struct {
+	Title   string
+	Section string
+	// Text content, in place of the rest
+	Text    template.HTML
+	Image   template.URL
+	Columns []string
+	Rows    [][]htmlCell
+}
+
+type htmlLink struct {
+	ID    string
+	Title string
+}
+
+// htmlContents is a section of the report in its table of contents, the panels before the first heading have none
+type htmlContents struct {
+	htmlLink
+	Panels []htmlLink
+}
+
+type htmlPage struct {
+	Title     string
+	Period    string
+	Generated string
+	// Language the page is in, and its text shown in place of an empty table
+	Lang     string
+	NoData   string
+	Panels   []htmlPanel
+	Contents []htmlContents
+	// Entries in the contents, which are only shown when there's more than one
+	Links int
+	// Panels' titles are headings within their sections' headings
+	Sectioned bool
+	// From the branding, the accent is always set
+	Logo       template.URL
+	HeaderText string
+	FooterText string
+	Accent     template.CSS
+}
+
+// Colour of the report's headings and rules when the branding doesn't set one
+const defaultAccent = "#4472c4"
+
+// WriteHTML writes the report as a single HTML file with its images and styles inlined, using the
+// panels fetched by Write
+func (r *Report) WriteHTML() error {
+	path := r.FilePath("html")
+	log.DefaultLogger.Info("Writing HTML report " + path)
+
+	localizer := r.localizer()
+	page := htmlPage{
+		Title:     r.name,
+		Period:    period(localizer, r.sheets),
+		Generated: localizer.Text("report.generated", localizer.DateTime(time.Now())),
+		Lang:      localizer.Language(),
+		NoData:    localizer.Text("report.noData"),
+		Accent:    defaultAccent,
+	}
+	if branding := r.options.Branding; branding != nil {
+		page.HeaderText, page.FooterText = branding.HeaderText, branding.FooterText
+		// The colour was checked to be one when it was saved, so it's safe in the styles
+		if branding.AccentColor != "" {
+			page.Accent = template.CSS(branding.AccentColor)
+		}
+		if len(branding.Logo) > 0 {
+			page.Logo = template.URL("data:" + branding.LogoType + ";base64," + base64.StdEncoding.EncodeToString(branding.Logo))
+		}
+	}
+	for i, sheet := range r.sheets {
+		panel := htmlPanel{Title: sheet.Title, Section: sheet.Section}
+		if sheet.Section != "" || len(page.Contents) == 0 {
+			page.Contents = append(page.Contents, htmlContents{htmlLink: htmlLink{ID: fmt.Sprintf("section-%d", i), Title: sheet.Section}})
+			if sheet.Section != "" {
+				page.Sectioned = true
+				page.Links++
+			}
+		}
+		if sheet.IsText() {
+			panel.Text = markdownHTML(sheet.Text)
+			page.Panels = append(page.Panels, panel)
+			continue
+		}
+		contents := &page.Contents[len(page.Contents)-1]
+		contents.Panels = append(contents.Panels, htmlLink{ID: fmt.Sprintf("panel-%d", i), Title: sheet.Title})
+		page.Links++
+
+		if len(sheet.Image) > 0 {
+			panel.Image = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(sheet.Image))
+		}
+		for _, column := range sheet.Columns {
+			panel.Columns = append(panel.Columns, column.Text)
+		}
+		for _, row := range sheet.Rows {
+			var cells []htmlCell
+			for _, value := range row {
+				_, isNumber := value.(float64)
+				_, isDate := toDateString(value)
+				cells = append(cells, htmlCell{Text: cellText(value), Number: isNumber && !isDate})
+			}
+			panel.Rows = append(panel.Rows, cells)
+		}
+		page.Panels = append(page.Panels, panel)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.DefaultLogger.Error("WriteHTML: os.Create: " + err.Error())
+		return err
+	}
+	defer file.Close()
+
+	if err := htmlReport.Execute(file, page); err != nil {
+		log.DefaultLogger.Error("WriteHTML: htmlReport.Execute: " + err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package reporter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Version of the JSON artifact, increased when a change would break systems reading older artifacts
+const JSONVersion = 1
+
+// JSONMetadata identifies the run a JSON artifact was generated by
+type JSONMetadata struct {
+	RunID       string `json:"runID"`
+	ScheduleID  string `json:"scheduleID"`
+	Schedule    string `json:"schedule"`
+	Description string `json:"description"`
+	// When the schedule was due, as unix seconds
+	ScheduledAt int `json:"scheduledAt"`
+	// Which of the parts a report too long to send in one this is, 0 when it was sent whole
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
+}
+
+type jsonPanel struct {
+	ID           int    `json:"id"`
+	DashboardUID string `json:"dashboardUID"`
+	Title        string `json:"title"`
+	// Heading of the section of the report the panel starts
+	Section string `json:"section,omitempty"`
+	// Time range of the query, as unix seconds
+	From      int64           `json:"from"`
+	To        int64           `json:"to"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+	Columns   []string        `json:"columns"`
+	// Values as returned by the query, so they match the workbook without its formatting
+	Rows [][]interface{} `json:"rows"`
+}
+
+type jsonReport struct {
+	Version     int `json:"version"`
+	GeneratedAt int `json:"generatedAt"`
+	JSONMetadata
+	Panels []jsonPanel `json:"panels"`
+}
+
+// ArchivePath is where the JSON artifact of a run is kept in the archive directory after the report has been
+// sent, the data directory's archive when the directory is empty
+func ArchivePath(directory string, runID string) string {
+	if directory == "" {
+		directory = filepath.Join("..", "data", "archive")
+	}
+	return filepath.Join(directory, runID+".json")
+}
+
+// WriteJSON writes all of the report's data as JSON, for systems which take in the same numbers people are sent.
+// Text content isn't data, so it's left out. A copy of the whole report is kept in the archive so it can be fetched for the run later.
+func (r *Report) WriteJSON(metadata JSONMetadata) error {
+	path := r.FilePath("json")
+	log.DefaultLogger.Info("Writing JSON report " + path)
+
+	report := jsonReport{Version: JSONVersion, GeneratedAt: int(time.Now().Unix()), JSONMetadata: metadata, Panels: []jsonPanel{}}
+	section := ""
+	for _, sheet := range r.sheets {
+		if sheet.Section != "" {
+			section = sheet.Section
+		}
+		if sheet.IsText() || sheet.ImageOnly {
+			continue
+		}
+		panel := jsonPanel{ID: sheet.ID, DashboardUID: sheet.DashboardUID, Title: sheet.Title, Section: section, Columns: []string{}, Rows: sheet.Rows}
+		section = ""
+		panel.From, _ = strconv.ParseInt(sheet.From, 10, 64)
+		panel.To, _ = strconv.ParseInt(sheet.To, 10, 64)
+		if json.Valid([]byte(sheet.ContentVariables)) {
+			panel.Variables = json.RawMessage(sheet.ContentVariables)
+		}
+		for _, column := range sheet.Columns {
+			panel.Columns = append(panel.Columns, column.Text)
+		}
+		if panel.Rows == nil {
+			panel.Rows = [][]interface{}{}
+		}
+		report.Panels = append(report.Panels, panel)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.DefaultLogger.Error("WriteJSON: json.MarshalIndent: " + err.Error())
+		return err
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		log.DefaultLogger.Error("WriteJSON: ioutil.WriteFile: " + err.Error())
+		return err
+	}
+
+	// Only the whole report is archived, each part's data is in it
+	if metadata.RunID == "" || metadata.Part > 0 {
+		return nil
+	}
+	archivePath := ArchivePath(r.options.ArchiveDirectory, metadata.RunID)
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		log.DefaultLogger.Error("WriteJSON: os.MkdirAll: " + err.Error())
+		return err
+	}
+	if err := ioutil.WriteFile(archivePath, data, 0644); err != nil {
+		log.DefaultLogger.Error("WriteJSON: ioutil.WriteFile: " + err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package reporter
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The markdown of text content is the common subset people write in notes: headings, paragraphs, lists, bold,
+// italics, code and links. Anything else is shown as it was written.
+var (
+	markdownHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	markdownBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	markdownNumbered = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrong   = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	markdownEmphasis = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	markdownSafeLink = regexp.MustCompile(`(?i)^(https?://|mailto:)`)
+)
+
+// Kinds of markdown block
+const (
+	blockParagraph = "p"
+	blockBullets   = "ul"
+	blockNumbered  = "ol"
+	blockHeading   = "h"
+)
+
+type markdownBlock struct {
+	kind string
+	// 1 to 6, for headings
+	level int
+	// The paragraph's lines, the heading, or a list's items
+	lines []string
+}
+
+// markdownBlocks splits markdown into its headings, paragraphs and lists, which blank lines and changes of kind end
+func markdownBlocks(text string) []markdownBlock {
+	blocks := []markdownBlock{}
+	var current *markdownBlock
+	end := func() {
+		if current != nil {
+			blocks = append(blocks, *current)
+			current = nil
+		}
+	}
+	add := func(kind string, line string) {
+		if current == nil || current.kind != kind {
+			end()
+			current = &markdownBlock{kind: kind}
+		}
+		current.lines = append(current.lines, line)
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			end()
+			continue
+		}
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			end()
+			blocks = append(blocks, markdownBlock{kind: blockHeading, level: len(match[1]), lines: []string{match[2]}})
+			continue
+		}
+		if match := markdownBullet.FindStringSubmatch(line); match != nil {
+			add(blockBullets, match[1])
+			continue
+		}
+		if match := markdownNumbered.FindStringSubmatch(line); match != nil {
+			add(blockNumbered, match[1])
+			continue
+		}
+		// A line which isn't an item carries on the one before it, as in a list item wrapped onto the next line
+		if current != nil && current.kind != blockParagraph {
+			current.lines[len(current.lines)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		add(blockParagraph, strings.TrimSpace(line))
+	}
+	end()
+
+	return blocks
+}
+
+// markdownHTML is markdown as HTML, with anything in it which is already HTML escaped. Headings are shown two levels
+// down, below the report's title and its sections' headings.
+func markdownHTML(text string) template.HTML {
+	var out strings.Builder
+	for _, block := range markdownBlocks(text) {
+		switch block.kind {
+		case blockHeading:
+			level := strconv.Itoa(Min(block.level+2, 6))
+			out.WriteString("<h" + level + ">" + markdownInline(block.lines[0]) + "</h" + level + ">\n")
+		case blockBullets, blockNumbered:
+			out.WriteString("<" + block.kind + ">")
+			for _, item := range block.lines {
+				out.WriteString("<li>" + markdownInline(item) + "</li>")
+			}
+			out.WriteString("</" + block.kind + ">\n")
+		default:
+			out.WriteString("<p>" + markdownInline(strings.Join(block.lines, " ")) + "</p>\n")
+		}
+	}
+	return template.HTML(out.String())
+}
+
+// markdownInline escapes a line and formats its code, links, bold and italics. Code is left as written.
+func markdownInline(line string) string {
+	var out strings.Builder
+	for i, segment := range strings.Split(line, "`") {
+		segment = html.EscapeString(segment)
+		// Odd segments are between backticks, unless the last has no closing one
+		if i%2 == 1 && i < strings.Count(line, "`") {
+			out.WriteString("<code>" + segment + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			out.WriteString("`")
+		}
+
+		segment = markdownLink.ReplaceAllStringFunc(segment, func(link string) string {
+			match := markdownLink.FindStringSubmatch(link)
+			// Only links out of the report, not scripts
+			if !markdownSafeLink.MatchString(html.UnescapeString(match[2])) {
+				return match[1]
+			}
+			return `<a href="` + match[2] + `">` + match[1] + `</a>`
+		})
+		segment = markdownStrong.ReplaceAllString(segment, "<strong>$1$2</strong>")
+		segment = markdownEmphasis.ReplaceAllString(segment, "<em>$1$2</em>")
+		out.WriteString(segment)
+	}
+	return out.String()
+}
+
+// markdownParagraphs is markdown as plain text, a paragraph for each of its headings, paragraphs and list items, for
+// formats which can't show it formatted
+func markdownParagraphs(text string) []string {
+	paragraphs := []string{}
+	for _, block := range markdownBlocks(text) {
+		for i, line := range block.lines {
+			if block.kind == blockParagraph {
+				line = strings.Join(block.lines, " ")
+			}
+			line = markdownLink.ReplaceAllString(line, "$1 ($2)")
+			line = markdownStrong.ReplaceAllString(line, "$1$2")
+			line = markdownEmphasis.ReplaceAllString(line, "$1$2")
+			line = strings.ReplaceAll(line, "`", "")
+			switch block.kind {
+			case blockBullets:
+				line = "• " + line
+			case blockNumbered:
+				line = strconv.Itoa(i+1) + ". " + line
+			}
+			paragraphs = append(paragraphs, line)
+			if block.kind == blockParagraph {
+				break
+			}
+		}
+	}
+	return paragraphs
+}
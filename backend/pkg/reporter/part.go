@@ -0,0 +1,25 @@
+package reporter
+
+// PanelCount is the number of panels in the report, each of which is a sheet of the workbook and a slide
+func (r *Report) PanelCount() int {
+	return len(r.sheets)
+}
+
+// Part is a report of the panels from start up to end, for sending a long report in several parts. It is written
+// from the panels this report has already fetched, masking them only if this report hasn't, along with the errors of any which failed.
+func (r *Report) Part(name string, start int, end int) *Report {
+	part := NewReport(r.id, name, r.templatePath)
+	part.options = r.options
+	part.options.Prefetched = true
+	part.sheets = r.sheets[start:end]
+	part.masked = r.masked
+
+	for _, panelErr := range r.panelErrors {
+		if panelErr.Index >= start && panelErr.Index < end {
+			panelErr.Index -= start
+			part.panelErrors = append(part.panelErrors, panelErr)
+		}
+	}
+
+	return part
+}
@@ -0,0 +1,49 @@
+package reporter
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+)
+
+// placeholderImage is shown in place of a panel which could not be rendered: a grey box crossed through
+func placeholderImage(options api.RenderOptions) ([]byte, error) {
+	options = options.Capped()
+	width, height := options.Width, options.Height
+
+	background := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+	foreground := color.RGBA{R: 180, G: 180, B: 180, A: 255}
+	if options.Theme == api.RenderThemeDark {
+		background = color.RGBA{R: 24, G: 27, B: 31, A: 255}
+		foreground = color.RGBA{R: 80, G: 80, B: 80, A: 255}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, background)
+		}
+	}
+
+	for x := 0; x < width; x++ {
+		y := x * height / width
+		img.Set(x, y, foreground)
+		img.Set(x, height-1-y, foreground)
+		img.Set(x, 0, foreground)
+		img.Set(x, height-1, foreground)
+	}
+	for y := 0; y < height; y++ {
+		img.Set(0, y, foreground)
+		img.Set(width-1, y, foreground)
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,362 @@
+package reporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+)
+
+// Slides are 16:9, measured in EMUs (914400 per inch)
+const (
+	slideWidth  = 12192000
+	slideHeight = 6858000
+	slideMargin = 457200
+	// Space above the panel for its title and the period
+	slideHeader = 1371600
+)
+
+// Panels without an image are shown as a table of their first rows and columns
+const (
+	slideTableRows    = 15
+	slideTableColumns = 8
+)
+
+const pptxNamespaces = `xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main"`
+
+const emptyShapeTree = `<p:nvGrpSpPr><p:cNvPr id="1" name=""/><p:cNvGrpSpPr/><p:nvPr/></p:nvGrpSpPr><p:grpSpPr/>`
+
+func xmlEscape(text string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
+
+// cellText is how a value from a panel's rows is shown outside of Excel
+func cellText(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if date, ok := toDateString(value); ok {
+		return date
+	}
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// period is the time range of the report's data, e.g. 1 Jun 2021 - 30 Jun 2021, or empty if it isn't known
+func period(localizer i18n.Localizer, panels []api.TablePanel) string {
+	first := -1
+	for i := range panels {
+		if !panels[i].IsText() {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	from, fromErr := strconv.ParseInt(panels[first].From, 10, 64)
+	to, toErr := strconv.ParseInt(panels[first].To, 10, 64)
+	if fromErr != nil || toErr != nil {
+		return ""
+	}
+
+	return localizer.Text("report.period", localizer.Date(time.Unix(from, 0)), localizer.Date(time.Unix(to, 0)))
+}
+
+func textBox(id int, name string, y int, height int, size int, bold bool, text string) string {
+	return paragraphsBox(id, name, y, height, size, bold, []string{text})
+}
+
+// paragraphsBox is a text box of several paragraphs, shrunk to fit when there's too much to
+func paragraphsBox(id int, name string, y int, height int, size int, bold bool, paragraphs []string) string {
+	b := "0"
+	if bold {
+		b = "1"
+	}
+	var body strings.Builder
+	for _, paragraph := range paragraphs {
+		body.WriteString(fmt.Sprintf(`<a:p><a:pPr><a:spcAft><a:spcPts val="600"/></a:spcAft></a:pPr><a:r><a:rPr lang="en-US" sz="%d" b="%s"/><a:t>%s</a:t></a:r></a:p>`, size, b, xmlEscape(paragraph)))
+	}
+	return fmt.Sprintf(`<p:sp><p:nvSpPr><p:cNvPr id="%d" name="%s"/><p:cNvSpPr txBox="1"/><p:nvPr/></p:nvSpPr>`+
+		`<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></p:spPr>`+
+		`<p:txBody><a:bodyPr wrap="square"><a:normAutofit/></a:bodyPr><a:lstStyle/>%s</p:txBody></p:sp>`,
+		id, name, slideMargin, y, slideWidth-2*slideMargin, height, body.String())
+}
+
+// picture fits the panel's image into the space below the header, keeping its aspect ratio
+func picture(id int, relID string, img []byte) string {
+	boxWidth := slideWidth - 2*slideMargin
+	boxHeight := slideHeight - slideHeader - slideMargin
+	width, height := boxWidth, boxHeight
+
+	if config, _, err := image.DecodeConfig(bytes.NewReader(img)); err == nil && config.Width > 0 && config.Height > 0 {
+		ratio := float64(config.Width) / float64(config.Height)
+		if float64(boxWidth)/float64(boxHeight) > ratio {
+			width = int(float64(boxHeight) * ratio)
+		} else {
+			height = int(float64(boxWidth) / ratio)
+		}
+	}
+
+	x := slideMargin + (boxWidth-width)/2
+	return fmt.Sprintf(`<p:pic><p:nvPicPr><p:cNvPr id="%d" name="Panel"/><p:cNvPicPr><a:picLocks noChangeAspect="1"/></p:cNvPicPr><p:nvPr/></p:nvPicPr>`+
+		`<p:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></p:blipFill>`+
+		`<p:spPr><a:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></p:spPr></p:pic>`,
+		id, relID, x, slideHeader, width, height)
+}
+
+func tableCell(text string, bold bool) string {
+	b := "0"
+	if bold {
+		b = "1"
+	}
+	return fmt.Sprintf(`<a:tc><a:txBody><a:bodyPr/><a:lstStyle/><a:p><a:r><a:rPr lang="en-US" sz="1000" b="%s"/><a:t>%s</a:t></a:r></a:p></a:txBody><a:tcPr/></a:tc>`, b, xmlEscape(text))
+}
+
+// table shows the first rows and columns of a panel's data
+func table(id int, panel api.TablePanel) string {
+	columns := panel.Columns
+	if len(columns) > slideTableColumns {
+		columns = columns[:slideTableColumns]
+	}
+	rows := panel.Rows
+	if len(rows) > slideTableRows {
+		rows = rows[:slideTableRows]
+	}
+	if len(columns) == 0 {
+		return ""
+	}
+
+	width := slideWidth - 2*slideMargin
+	rowHeight := 300000
+
+	var grid, header, body strings.Builder
+	for _, column := range columns {
+		grid.WriteString(fmt.Sprintf(`<a:gridCol w="%d"/>`, width/len(columns)))
+		header.WriteString(tableCell(column.Text, true))
+	}
+	for _, row := range rows {
+		body.WriteString(fmt.Sprintf(`<a:tr h="%d">`, rowHeight))
+		for i := range columns {
+			var value interface{}
+			if i < len(row) {
+				value = row[i]
+			}
+			body.WriteString(tableCell(cellText(value), false))
+		}
+		body.WriteString(`</a:tr>`)
+	}
+
+	return fmt.Sprintf(`<p:graphicFrame><p:nvGraphicFramePr><p:cNvPr id="%d" name="Data"/><p:cNvGraphicFramePr><a:graphicFrameLocks noGrp="1"/></p:cNvGraphicFramePr><p:nvPr/></p:nvGraphicFramePr>`+
+		`<p:xfrm><a:off x="%d" y="%d"/><a:ext cx="%d" cy="%d"/></p:xfrm>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/table"><a:tbl><a:tblPr firstRow="1" bandRow="1"/><a:tblGrid>%s</a:tblGrid>`+
+		`<a:tr h="%d">%s</a:tr>%s</a:tbl></a:graphicData></a:graphic></p:graphicFrame>`,
+		id, slideMargin, slideHeader, width, rowHeight*(len(rows)+1), grid.String(), rowHeight, header.String(), body.String())
+}
+
+// slide is one panel, with its title and the period above its image, or its data when it wasn't rendered
+func slide(localizer i18n.Localizer, panel api.TablePanel, period string) string {
+	var shapes strings.Builder
+	shapes.WriteString(textBox(2, "Title", slideMargin/2, 609600, 2800, true, panel.Title))
+	if period != "" {
+		shapes.WriteString(textBox(3, "Period", slideMargin/2+609600, 457200, 1400, false, period))
+	}
+
+	if len(panel.Image) > 0 {
+		shapes.WriteString(picture(4, "rId2", panel.Image))
+	} else if len(panel.Rows) == 0 {
+		shapes.WriteString(textBox(4, "Data", slideHeader, 457200, 1400, false, localizer.Text("report.noData")))
+	} else {
+		shapes.WriteString(table(4, panel))
+		if len(panel.Rows) > slideTableRows || len(panel.Columns) > slideTableColumns {
+			note := localizer.Text("report.truncated", Min(len(panel.Rows), slideTableRows), len(panel.Rows), Min(len(panel.Columns), slideTableColumns), len(panel.Columns))
+			shapes.WriteString(textBox(5, "Note", slideHeight-slideMargin, 304800, 1000, false, note))
+		}
+	}
+
+	return slideXML(shapes.String())
+}
+
+// textSlide is a heading and paragraphs of text, e.g. the report's cover or a section's introduction. A heading on its
+// own is in the middle of the slide, as it starts a section.
+func textSlide(title string, paragraphs []string) string {
+	if len(paragraphs) == 0 {
+		return slideXML(textBox(2, "Title", slideHeight/2-457200, 914400, 3600, true, title))
+	}
+
+	var shapes strings.Builder
+	y := slideMargin
+	if title != "" {
+		shapes.WriteString(textBox(2, "Title", slideMargin/2, 609600, 2800, true, title))
+		y = slideHeader
+	}
+	shapes.WriteString(paragraphsBox(3, "Text", y, slideHeight-y-slideMargin, 1600, false, paragraphs))
+	return slideXML(shapes.String())
+}
+
+func slideXML(shapes string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<p:sld ` + pptxNamespaces + `><p:cSld><p:spTree>` + emptyShapeTree + shapes + `</p:spTree></p:cSld><p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sld>`
+}
+
+// pptxSlide is a slide and the image on it, if it has one
+type pptxSlide struct {
+	xml   string
+	image []byte
+}
+
+// pptxSlides are a slide per panel and text content, with one starting each section. A report with sections or text
+// starts with a cover.
+func pptxSlides(localizer i18n.Localizer, panels []api.TablePanel, title string, period string) []pptxSlide {
+	slides := []pptxSlide{}
+	for _, panel := range panels {
+		if panel.Section != "" || panel.IsText() {
+			cover := []string{}
+			if period != "" {
+				cover = append(cover, period)
+			}
+			slides = append(slides, pptxSlide{xml: textSlide(title, cover)})
+			break
+		}
+	}
+
+	for _, panel := range panels {
+		if panel.IsText() {
+			slides = append(slides, pptxSlide{xml: textSlide(panel.Section, markdownParagraphs(panel.Text))})
+			continue
+		}
+		if panel.Section != "" {
+			slides = append(slides, pptxSlide{xml: textSlide(panel.Section, nil)})
+		}
+		slides = append(slides, pptxSlide{xml: slide(localizer, panel, period), image: panel.Image})
+	}
+	return slides
+}
+
+// WritePPTX writes a PowerPoint presentation of the report with one slide per panel and text content, using the
+// panels fetched by Write
+func (r *Report) WritePPTX() error {
+	path := r.FilePath("pptx")
+	log.DefaultLogger.Info("Writing presentation " + path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.DefaultLogger.Error("WritePPTX: os.Create: " + err.Error())
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	localizer := r.localizer()
+	parts := pptxParts(pptxSlides(localizer, r.sheets, r.name, period(localizer, r.sheets)))
+	for _, part := range parts {
+		writer, err := archive.Create(part.name)
+		if err != nil {
+			log.DefaultLogger.Error("WritePPTX: archive.Create: " + err.Error())
+			return err
+		}
+		if _, err := writer.Write(part.content); err != nil {
+			log.DefaultLogger.Error("WritePPTX: writer.Write: " + err.Error())
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.DefaultLogger.Error("WritePPTX: archive.Close: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+type pptxPart struct {
+	name    string
+	content []byte
+}
+
+const relationshipType = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+
+func relationships(targets ...[2]string) []byte {
+	var rels strings.Builder
+	rels.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, target := range targets {
+		rels.WriteString(fmt.Sprintf(`<Relationship Id="rId%d" Type="%s%s" Target="%s"/>`, i+1, relationshipType, target[0], target[1]))
+	}
+	rels.WriteString(`</Relationships>`)
+	return []byte(rels.String())
+}
+
+// pptxParts are the files making up the presentation: a single blank layout and theme, and the slides
+func pptxParts(slides []pptxSlide) []pptxPart {
+	var contentTypes, slideIDs strings.Builder
+	presentationRels := [][2]string{{"slideMaster", "slideMasters/slideMaster1.xml"}, {"theme", "theme/theme1.xml"}}
+
+	parts := []pptxPart{}
+	for i, slide := range slides {
+		n := i + 1
+		slideRels := [][2]string{{"slideLayout", "../slideLayouts/slideLayout1.xml"}}
+		if len(slide.image) > 0 {
+			slideRels = append(slideRels, [2]string{"image", fmt.Sprintf("../media/image%d.png", n)})
+			parts = append(parts, pptxPart{fmt.Sprintf("ppt/media/image%d.png", n), slide.image})
+		}
+
+		parts = append(parts,
+			pptxPart{fmt.Sprintf("ppt/slides/slide%d.xml", n), []byte(slide.xml)},
+			pptxPart{fmt.Sprintf("ppt/slides/_rels/slide%d.xml.rels", n), relationships(slideRels...)})
+
+		contentTypes.WriteString(fmt.Sprintf(`<Override PartName="/ppt/slides/slide%d.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.slide+xml"/>`, n))
+		presentationRels = append(presentationRels, [2]string{"slide", fmt.Sprintf("slides/slide%d.xml", n)})
+		slideIDs.WriteString(fmt.Sprintf(`<p:sldId id="%d" r:id="rId%d"/>`, 255+n, len(presentationRels)))
+	}
+
+	// The content types come first, as some readers expect
+	return append([]pptxPart{
+		{"[Content_Types].xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Default Extension="png" ContentType="image/png"/>` +
+			`<Override PartName="/ppt/presentation.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.presentation.main+xml"/>` +
+			`<Override PartName="/ppt/slideMasters/slideMaster1.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.slideMaster+xml"/>` +
+			`<Override PartName="/ppt/slideLayouts/slideLayout1.xml" ContentType="application/vnd.openxmlformats-officedocument.presentationml.slideLayout+xml"/>` +
+			`<Override PartName="/ppt/theme/theme1.xml" ContentType="application/vnd.openxmlformats-officedocument.theme+xml"/>` +
+			contentTypes.String() + `</Types>`)},
+		{"_rels/.rels", relationships([2]string{"officeDocument", "ppt/presentation.xml"})},
+		{"ppt/presentation.xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><p:presentation ` + pptxNamespaces + `>` +
+			`<p:sldMasterIdLst><p:sldMasterId id="2147483648" r:id="rId1"/></p:sldMasterIdLst><p:sldIdLst>` + slideIDs.String() + `</p:sldIdLst>` +
+			fmt.Sprintf(`<p:sldSz cx="%d" cy="%d"/><p:notesSz cx="6858000" cy="9144000"/></p:presentation>`, slideWidth, slideHeight))},
+		{"ppt/_rels/presentation.xml.rels", relationships(presentationRels...)},
+		{"ppt/slideMasters/slideMaster1.xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><p:sldMaster ` + pptxNamespaces + `>` +
+			`<p:cSld><p:spTree>` + emptyShapeTree + `</p:spTree></p:cSld>` +
+			`<p:clrMap bg1="lt1" tx1="dk1" bg2="lt2" tx2="dk2" accent1="accent1" accent2="accent2" accent3="accent3" accent4="accent4" accent5="accent5" accent6="accent6" hlink="hlink" folHlink="folHlink"/>` +
+			`<p:sldLayoutIdLst><p:sldLayoutId id="2147483649" r:id="rId1"/></p:sldLayoutIdLst></p:sldMaster>`)},
+		{"ppt/slideMasters/_rels/slideMaster1.xml.rels", relationships([2]string{"slideLayout", "../slideLayouts/slideLayout1.xml"}, [2]string{"theme", "../theme/theme1.xml"})},
+		{"ppt/slideLayouts/slideLayout1.xml", []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><p:sldLayout ` + pptxNamespaces + ` type="blank" preserve="1">` +
+			`<p:cSld name="Blank"><p:spTree>` + emptyShapeTree + `</p:spTree></p:cSld><p:clrMapOvr><a:masterClrMapping/></p:clrMapOvr></p:sldLayout>`)},
+		{"ppt/slideLayouts/_rels/slideLayout1.xml.rels", relationships([2]string{"slideMaster", "../slideMasters/slideMaster1.xml"})},
+		{"ppt/theme/theme1.xml", []byte(pptxTheme)},
+	}, parts...)
+}
+
+// pptxTheme is the minimal theme PowerPoint requires, with the Office colours and fonts
+const pptxTheme = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><a:theme xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" name="Office Theme"><a:themeElements>` +
+	`<a:clrScheme name="Office"><a:dk1><a:sysClr val="windowText" lastClr="000000"/></a:dk1><a:lt1><a:sysClr val="window" lastClr="FFFFFF"/></a:lt1>` +
+	`<a:dk2><a:srgbClr val="44546A"/></a:dk2><a:lt2><a:srgbClr val="E7E6E6"/></a:lt2><a:accent1><a:srgbClr val="4472C4"/></a:accent1><a:accent2><a:srgbClr val="ED7D31"/></a:accent2>` +
+	`<a:accent3><a:srgbClr val="A5A5A5"/></a:accent3><a:accent4><a:srgbClr val="FFC000"/></a:accent4><a:accent5><a:srgbClr val="5B9BD5"/></a:accent5><a:accent6><a:srgbClr val="70AD47"/></a:accent6>` +
+	`<a:hlink><a:srgbClr val="0563C1"/></a:hlink><a:folHlink><a:srgbClr val="954F72"/></a:folHlink></a:clrScheme>` +
+	`<a:fontScheme name="Office"><a:majorFont><a:latin typeface="Calibri Light"/><a:ea typeface=""/><a:cs typeface=""/></a:majorFont><a:minorFont><a:latin typeface="Calibri"/><a:ea typeface=""/><a:cs typeface=""/></a:minorFont></a:fontScheme>` +
+	`<a:fmtScheme name="Office"><a:fillStyleLst><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:fillStyleLst>` +
+	`<a:lnStyleLst><a:ln w="6350"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln><a:ln w="12700"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln><a:ln w="19050"><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:ln></a:lnStyleLst>` +
+	`<a:effectStyleLst><a:effectStyle><a:effectLst/></a:effectStyle><a:effectStyle><a:effectLst/></a:effectStyle><a:effectStyle><a:effectLst/></a:effectStyle></a:effectStyleLst>` +
+	`<a:bgFillStyleLst><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill><a:solidFill><a:schemeClr val="phClr"/></a:solidFill></a:bgFillStyleLst></a:fmtScheme>` +
+	`</a:themeElements></a:theme>`
@@ -1,10 +1,12 @@
 package reporter
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	_ "image/png"
 	"math"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -38,6 +40,7 @@ func intToCol(i int) string {
 type Reporter struct {
 	templatePath string
 	reports      map[string]Report
+	options      Options
 }
 
 type Report struct {
@@ -46,10 +49,14 @@ type Report struct {
 	templatePath string
 	file         *excelize.File
 	sheets       []api.TablePanel
+	options      Options
+	// Panels which couldn't be fetched, kept for writing the report again in parts
+	panelErrors PanelErrors
+	masked      bool
 }
 
 func NewReport(id string, name string, templatePath string) *Report {
-	return &Report{id: id, name: name, templatePath: templatePath}
+	return &Report{id: id, name: name, templatePath: templatePath, options: DefaultOptions()}
 }
 
 func (r *Report) openTemplate() error {
@@ -114,7 +121,7 @@ func (r *Report) writeDate(sheetName string) error {
 		return err
 	}
 
-	r.file.SetCellValue(sheetName, refs[0], time.Now().Format("Mon Jan 2 15:04:05"))
+	r.file.SetCellValue(sheetName, refs[0], r.localizer().DateTime(time.Now()))
 
 	return nil
 }
@@ -249,7 +256,7 @@ func (r *Report) writeRows(sheetName string, rows [][]interface{}) error {
 		}
 	} else {
 		cellRef := r.createCellRef(0, idx)
-		r.writeCell(sheetName, cellRef, "No data")
+		r.writeCell(sheetName, cellRef, r.localizer().Text("report.noData"))
 	}
 
 	return nil
@@ -295,7 +302,17 @@ func (r *Report) setColumnWidths(sheetName string, columns []api.Column, rows []
 	return nil
 }
 
-func (r *Report) Write(auth auth.AuthConfig) error {
+// writeImage places the panel's image to the right of its data
+func (r *Report) writeImage(sheetName string, columnCount int, image []byte) error {
+	if len(image) == 0 {
+		return nil
+	}
+
+	cellRef := r.createCellRef(columnCount+1, 1)
+	return r.file.AddPictureFromBytes(sheetName, cellRef, `{"x_scale": 1, "y_scale": 1, "positioning": "absolute"}`, sheetName, ".png", image)
+}
+
+func (r *Report) Write(ctx context.Context, auth auth.AuthConfig) error {
 	log.DefaultLogger.Info(fmt.Sprintf("Starting to create report %s...", r.id))
 	if r.file == nil {
 		if err := r.openTemplate(); err != nil {
@@ -303,7 +320,18 @@ func (r *Report) Write(auth auth.AuthConfig) error {
 		}
 	}
 
-	for _, s := range r.sheets {
+	panelErrors := r.panelErrors
+	if !r.options.Prefetched {
+		panelErrors = r.fetchPanels(ctx, auth)
+		r.panelErrors = panelErrors
+	}
+	r.maskSheets()
+
+	for i, s := range r.sheets {
+		// Text is for reading, the workbook is the data
+		if s.IsText() {
+			continue
+		}
 		log.DefaultLogger.Info(fmt.Sprintf("Creating new sheet %s", s.Title))
 		sIdx := r.file.NewSheet(s.Title)
 
@@ -312,11 +340,6 @@ func (r *Report) Write(auth auth.AuthConfig) error {
 			return err
 		}
 
-		if err := s.GetData(auth); err != nil {
-			log.DefaultLogger.Error("Write: GetData: " + err.Error())
-			return err
-		}
-
 		if err := r.writeTitle(s.Title); err != nil {
 			log.DefaultLogger.Error("Write: writeTitle: " + err.Error())
 			return err
@@ -327,6 +350,18 @@ func (r *Report) Write(auth auth.AuthConfig) error {
 			return err
 		}
 
+		if panelErr := panelErrors.forPanel(i); panelErr != nil {
+			if err := r.writeError(s.Title, s, panelErr.Err); err != nil {
+				log.DefaultLogger.Error("Write: writeError: " + err.Error())
+				return err
+			}
+			continue
+		}
+
+		// Rows the data is written to, which the chart refers to
+		headerRow, _ := r.placeholderRowRef(s.Title, "{{headers}}")
+		firstRow, _ := r.placeholderRowRef(s.Title, "{{rows}}")
+
 		if err := r.writeHeaders(s.Title, s.Columns); err != nil {
 			log.DefaultLogger.Error("Write: writeHeaders: " + err.Error())
 			return err
@@ -341,25 +376,43 @@ func (r *Report) Write(auth auth.AuthConfig) error {
 			log.DefaultLogger.Error("Write: setColumnWidths: " + err.Error())
 			return err
 		}
+
+		if err := r.writeImage(s.Title, len(s.Columns), s.Image); err != nil {
+			log.DefaultLogger.Error("Write: writeImage: " + err.Error())
+			return err
+		}
+
+		if err := r.writeChart(s.Title, s, headerRow, firstRow); err != nil {
+			log.DefaultLogger.Error("Write: writeChart: " + err.Error())
+			return err
+		}
 	}
 
 	r.file.DeleteSheet("templateSheet")
 
 	log.DefaultLogger.Info("Saving report...")
 
-	savePath := GetFilePath(r.name)
+	savePath := r.FilePath("xlsx")
+	if err := os.MkdirAll(filepath.Dir(savePath), 0755); err != nil {
+		log.DefaultLogger.Error("Write: os.MkdirAll: " + err.Error())
+		return err
+	}
 	if err := r.file.SaveAs(savePath); err != nil {
 		log.DefaultLogger.Error("Write: ", err.Error())
 	}
 
 	log.DefaultLogger.Info(fmt.Sprintf("Report finished! %s :tada", r.id))
 
+	if len(panelErrors) > 0 {
+		return panelErrors
+	}
+
 	return nil
 }
 
-func (r *Reporter) ExportPanel(authConfig *auth.AuthConfig, datasourceID int, dashboardID string, panelID int, query string, title string) (string, error) {
+func (r *Reporter) ExportPanel(ctx context.Context, authConfig *auth.AuthConfig, datasourceID int, dashboardID string, panelID int, query string, title string) (string, error) {
 
-	dashboard, err := api.NewDashboard(authConfig, dashboardID, "", "", datasourceID)
+	dashboard, err := api.NewDashboard(ctx, authConfig, dashboardID, "", "", datasourceID)
 	if err != nil {
 		log.DefaultLogger.Error("Reporter.ExportPanel: NewDashboard: " + err.Error())
 		return "", err
@@ -372,14 +425,14 @@ func (r *Reporter) ExportPanel(authConfig *auth.AuthConfig, datasourceID int, da
 	}
 
 	panel.SetSql(query)
-	log.DefaultLogger.Debug("Reporter.ExportPanel: Query=" + query);
+	log.DefaultLogger.Debug("Reporter.ExportPanel: Query=" + r.options.Masker.Text(query));
 	panel.SetTitle(title)
 
 	reportSheetPanels := []api.TablePanel{*panel}
 	report := r.CreateNewReport(strconv.Itoa(panelID), panel.Title)
 	report.SetSheets(reportSheetPanels)
 
-	err = report.Write(*authConfig)
+	err = report.Write(ctx, *authConfig)
 	if err != nil {
 		log.DefaultLogger.Error("ReportEmailer.createReports: report.Write: " + err.Error())
 		return "", err
@@ -390,18 +443,46 @@ func (r *Reporter) ExportPanel(authConfig *auth.AuthConfig, datasourceID int, da
 
 func GetFilePath(fileName string) string {
 
-	filePath := filepath.Join("..", "data", fileName+".xlsx")
+	filePath := ReportPath("", fileName, "xlsx")
 
 	log.DefaultLogger.Debug("mSupply App: ReportFilePath=" + filePath)
 	return filePath
 }
 
+// GetArtifactPath is where the report is written in formats other than the Excel workbook
+func GetArtifactPath(fileName string, extension string) string {
+	return ReportPath("", fileName, extension)
+}
+
+// ReportPath is where a report is written in the directory, the data directory when it's empty
+func ReportPath(directory string, fileName string, extension string) string {
+	if directory == "" {
+		directory = filepath.Join("..", "data")
+	}
+	return filepath.Join(directory, fileName+"."+extension)
+}
+
+// FilePath is where the report is written in the format, in the directory of its options
+func (r *Report) FilePath(extension string) string {
+	return ReportPath(r.options.Directory, r.name, extension)
+}
+
+// Directory is where the report's files are written
+func (r *Report) Directory() string {
+	return filepath.Dir(r.FilePath("xlsx"))
+}
+
 func NewReporter(templatePath string) *Reporter {
-	return &Reporter{templatePath: templatePath}
+	return &Reporter{templatePath: templatePath, options: DefaultOptions()}
+}
+
+func (r *Reporter) SetOptions(options Options) {
+	r.options = options
 }
 
 func (r *Reporter) CreateNewReport(scheduleID string, scheduleName string) *Report {
 	report := NewReport(scheduleID, scheduleName, r.templatePath)
+	report.options = r.options
 	return report
 }
 
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Most times a run is moved on to get it out of blackouts, so a schedule blacked out on every day it
+// could run doesn't loop forever
+const maxBlackoutShifts = 400
+
+// blackoutRules are the schedule's blackouts. A schedule whose blackouts can't be loaded is sent as usual,
+// as a report sent on a holiday is better than one which is never sent.
+func (s *Scheduler) blackoutRules(schedule dbstore.Schedule) *dbstore.BlackoutRules {
+	rules, err := s.sql.GetBlackoutRules(schedule.ID)
+	if err != nil {
+		log.DefaultLogger.Error("Scheduler.blackoutRules: GetBlackoutRules: " + err.Error())
+		return &dbstore.BlackoutRules{}
+	}
+	return rules
+}
+
+// covering is the blackout a run falls in, nil if none. Blackout dates and weekends are days in the schedule's
+// timezone, as its recipients see them.
+func covering(schedule dbstore.Schedule, rules *dbstore.BlackoutRules, scheduledAt int) *dbstore.Blackout {
+	return rules.Covering(time.Unix(int64(scheduledAt), 0).In(schedule.Location()))
+}
+
+// outsideBlackouts moves a run out of any blackouts it falls in, to the next day or the next time the
+// schedule is due depending on its blackout policy. Returns 0 if there's no time outside its blackouts.
+func outsideBlackouts(schedule dbstore.Schedule, rules *dbstore.BlackoutRules, scheduledAt int) int {
+	t := time.Unix(int64(scheduledAt), 0).In(schedule.Location())
+	for i := 0; i < maxBlackoutShifts; i++ {
+		if rules.Covering(t) == nil {
+			return int(t.Unix())
+		}
+
+		if schedule.BlackoutPolicy == dbstore.BlackoutPolicySkip {
+			next := schedule.NextReportTimeAfter(t)
+			if int64(next) <= t.Unix() {
+				return 0
+			}
+			t = time.Unix(int64(next), 0).In(t.Location())
+		} else {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return 0
+}
+
+// postponedRun is the earliest run since the schedule's nextReportTime which a blackout postponed until after now,
+// 0 if there isn't one. Schedules which skip blacked out runs never have one.
+func postponedRun(schedule dbstore.Schedule, rules *dbstore.BlackoutRules, now time.Time) int {
+	if schedule.BlackoutPolicy == dbstore.BlackoutPolicySkip {
+		return 0
+	}
+
+	earliest := 0
+	for _, scheduledAt := range occurrences(schedule, now) {
+		if covering(schedule, rules, scheduledAt) == nil {
+			continue
+		}
+		if moved := outsideBlackouts(schedule, rules, scheduledAt); moved > int(now.Unix()) && (earliest == 0 || moved < earliest) {
+			earliest = moved
+		}
+	}
+	return earliest
+}
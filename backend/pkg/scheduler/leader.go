@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Name of the lease held by the instance which runs schedules
+const LeaseName = "scheduler"
+
+// How long the leader has to renew its lease before another instance takes over. It is renewed every
+// LeaseRenewInterval, so a leader which dies is replaced within LeaseTTL.
+const (
+	LeaseTTL           = 2 * time.Minute
+	LeaseRenewInterval = 30 * time.Second
+)
+
+// Leader elects a single instance to run schedules when several Grafana servers share the plugin database,
+// so reports aren't sent once by each of them
+type Leader struct {
+	sql *dbstore.SQLiteDatasource
+	// Identifies this instance in the lease, unique even when instances share a host name
+	ID string
+
+	mutex    sync.Mutex
+	isLeader bool
+}
+
+func NewLeader(sql *dbstore.SQLiteDatasource) *Leader {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "grafana"
+	}
+	return &Leader{sql: sql, ID: fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])}
+}
+
+// Renew takes or renews the lease, returning whether this instance is the leader. Failing to reach
+// the database counts as losing the lease, as another instance may have taken over in the meantime.
+func (leader *Leader) Renew() bool {
+	acquired, err := leader.sql.AcquireLease(LeaseName, leader.ID, LeaseTTL)
+	if err != nil {
+		log.DefaultLogger.Error("Leader.Renew: AcquireLease: " + err.Error())
+		acquired = false
+	}
+
+	leader.mutex.Lock()
+	defer leader.mutex.Unlock()
+	if acquired && !leader.isLeader {
+		log.DefaultLogger.Info(fmt.Sprintf("Instance '%s' is now running schedules", leader.ID))
+	} else if !acquired && leader.isLeader {
+		log.DefaultLogger.Warn(fmt.Sprintf("Instance '%s' lost the scheduler lease and has stopped running schedules", leader.ID))
+	}
+	leader.isLeader = acquired
+
+	return acquired
+}
+
+// Release hands the lease over on shutdown, so another instance can take over without waiting for it to expire
+func (leader *Leader) Release() {
+	leader.mutex.Lock()
+	defer leader.mutex.Unlock()
+	if !leader.isLeader {
+		return
+	}
+
+	if err := leader.sql.ReleaseLease(LeaseName, leader.ID); err != nil {
+		log.DefaultLogger.Error("Leader.Release: ReleaseLease: " + err.Error())
+		return
+	}
+	leader.isLeader = false
+	log.DefaultLogger.Info(fmt.Sprintf("Instance '%s' released the scheduler lease", leader.ID))
+}
+
+// Run calls job only while this instance is the leader, renewing the lease first so a job never starts on
+// an instance whose lease has just expired
+func (leader *Leader) Run(job func()) func() {
+	return func() {
+		if !leader.Renew() {
+			log.DefaultLogger.Debug(fmt.Sprintf("Instance '%s' is not the leader, leaving schedules to the leader", leader.ID))
+			return
+		}
+		job()
+	}
+}
@@ -0,0 +1,55 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Most planned runs worked out for a single schedule, enough for a daily schedule over a year
+const maxPlanned = 400
+
+// PlannedRun is a time a schedule is still to be sent
+type PlannedRun struct {
+	ScheduledAt int
+	// The blackout the run falls in, when the schedule's policy skips it rather than postponing it
+	Blackout string
+	// When the run was due before a blackout postponed it, 0 if it wasn't
+	PostponedFrom int
+}
+
+// Planned is when a schedule will be sent between from and to, from now on, after applying its blackouts. Runs
+// the blackouts skip are included, with the blackout which skips them. Disabled schedules have none.
+func (s *Scheduler) Planned(schedule dbstore.Schedule, from time.Time, to time.Time) []PlannedRun {
+	if now := s.clock.Now(); from.Before(now) {
+		from = now
+	}
+	if schedule.NextReportTime == 0 || schedule.DisabledAt > 0 || to.Before(from) {
+		return nil
+	}
+
+	rules := s.blackoutRules(schedule)
+	planned := []PlannedRun{}
+	for next := schedule.NextReportTime; next <= int(to.Unix()) && len(planned) < maxPlanned; {
+		if next >= int(from.Unix()) {
+			run := PlannedRun{ScheduledAt: next}
+			if blackout := covering(schedule, rules, next); blackout != nil {
+				if schedule.BlackoutPolicy == dbstore.BlackoutPolicySkip {
+					run.Blackout = blackout.Name
+				} else if moved := outsideBlackouts(schedule, rules, next); moved != 0 {
+					run = PlannedRun{ScheduledAt: moved, PostponedFrom: next}
+				} else {
+					run.Blackout = blackout.Name
+				}
+			}
+			planned = append(planned, run)
+		}
+
+		following := schedule.NextReportTimeAfter(time.Unix(int64(next), 0))
+		if following <= next {
+			break
+		}
+		next = following
+	}
+	return planned
+}
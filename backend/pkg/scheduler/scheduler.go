@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// A run still waiting this long after it was due was missed, e.g. because Grafana was down, rather than
+// just waiting for the next pass of the scheduler
+const MissedAfter = 30 * time.Minute
+
+// Most runs worked out for a single schedule, so one which was off for years can't flood its recipients
+const maxRuns = 50
+
+// Run is a single time a schedule was due
+type Run struct {
+	ScheduledAt int
+	// Sent because of the catch-up policy, after it was missed
+	CatchUp bool
+}
+
+// Scheduler decides which runs of an overdue schedule are sent and keeps nextReportTime up to date, so runs
+// missed while Grafana was down are handled according to the schedule's catch-up policy and no runs are sent
+// during its blackouts
+type Scheduler struct {
+	sql   *dbstore.SQLiteDatasource
+	clock clock.Clock
+}
+
+func New(sql *dbstore.SQLiteDatasource) *Scheduler {
+	return NewWithClock(sql, clock.System)
+}
+
+// NewWithClock is a scheduler working to another clock, e.g. one fixed at a DST change
+func NewWithClock(sql *dbstore.SQLiteDatasource, clock clock.Clock) *Scheduler {
+	return &Scheduler{sql: sql, clock: clock}
+}
+
+// Now is the time on the scheduler's clock
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// occurrences are the times the schedule has been due from its nextReportTime up until now, oldest first
+func occurrences(schedule dbstore.Schedule, now time.Time) []int {
+	var times []int
+	for next := schedule.NextReportTime; next <= int(now.Unix()) && len(times) < maxRuns; {
+		times = append(times, next)
+		following := schedule.NextReportTimeAfter(time.Unix(int64(next), 0))
+		if following <= next {
+			break
+		}
+		next = following
+	}
+	return times
+}
+
+// missed is whether a run is well past due and nothing has tried sending it yet. Runs being retried
+// after failing aren't missed, they're left to the retry limit.
+func (s *Scheduler) missed(schedule dbstore.Schedule, scheduledAt int, now time.Time) bool {
+	if now.Sub(time.Unix(int64(scheduledAt), 0)) < MissedAfter {
+		return false
+	}
+
+	attempts, err := s.sql.CountRuns(schedule.ID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("Scheduler.missed: CountRuns: " + err.Error())
+		return false
+	}
+	return attempts == 0
+}
+
+// retrying is whether a run has already been tried and failed, so the schedule was held at it to try it again
+func (s *Scheduler) retrying(schedule dbstore.Schedule, scheduledAt int) bool {
+	attempts, err := s.sql.CountRuns(schedule.ID, scheduledAt)
+	if err != nil {
+		log.DefaultLogger.Error("Scheduler.retrying: CountRuns: " + err.Error())
+		return false
+	}
+	return attempts > 0
+}
+
+func formatTime(unix int) string {
+	return time.Unix(int64(unix), 0).Format(time.RFC3339)
+}
+
+// Due is the runs of an overdue schedule which should be sent now, oldest first, after applying its blackouts and
+// catch-up policy. Each run which is dropped or moved is logged. When none are left to send now, the schedule is
+// moved on to its next run, which is a run a blackout postponed when one is still to come.
+func (s *Scheduler) Due(settings *dbstore.Settings, schedule dbstore.Schedule) []Run {
+	now := s.clock.Now()
+	rules := s.blackoutRules(schedule)
+
+	var times []int
+	seen := make(map[int]bool)
+	for _, scheduledAt := range occurrences(schedule, now) {
+		if blackout := covering(schedule, rules, scheduledAt); blackout != nil {
+			moved := 0
+			if schedule.BlackoutPolicy != dbstore.BlackoutPolicySkip {
+				moved = outsideBlackouts(schedule, rules, scheduledAt)
+			}
+			switch {
+			case schedule.BlackoutPolicy == dbstore.BlackoutPolicySkip:
+				log.DefaultLogger.Info(fmt.Sprintf("Skipping the run of '%s' due at %s during blackout '%s'", schedule.Name, formatTime(scheduledAt), blackout.Name))
+				continue
+			case moved == 0:
+				log.DefaultLogger.Warn(fmt.Sprintf("'%s' is due at %s during blackout '%s' and has no time outside its blackouts to move to", schedule.Name, formatTime(scheduledAt), blackout.Name))
+				continue
+			case moved > int(now.Unix()):
+				// Still to come, so it's the schedule's next run once these have been dealt with
+				log.DefaultLogger.Info(fmt.Sprintf("'%s' is due at %s during blackout '%s', postponing it to %s", schedule.Name, formatTime(scheduledAt), blackout.Name, formatTime(moved)))
+				continue
+			}
+			log.DefaultLogger.Info(fmt.Sprintf("'%s' was due at %s during blackout '%s', sending it as postponed to %s", schedule.Name, formatTime(scheduledAt), blackout.Name, formatTime(moved)))
+			scheduledAt = moved
+		}
+		if !seen[scheduledAt] {
+			seen[scheduledAt] = true
+			times = append(times, scheduledAt)
+		}
+	}
+	sort.Ints(times)
+	if len(times) == 0 {
+		s.Advance(schedule)
+		return nil
+	}
+
+	var missed []int
+	for _, scheduledAt := range times {
+		if s.missed(schedule, scheduledAt, now) {
+			missed = append(missed, scheduledAt)
+		}
+	}
+	if len(missed) == 0 && len(times) == 1 {
+		return []Run{{ScheduledAt: times[0]}}
+	}
+
+	policy := dbstore.ResolveSettings(settings, schedule, nil).CatchUp
+	isMissed := make(map[int]bool)
+	for _, scheduledAt := range missed {
+		isMissed[scheduledAt] = true
+	}
+	log.DefaultLogger.Info(fmt.Sprintf("'%s' has %d runs due since %s, %d of them missed, catching up with policy '%s'", schedule.Name, len(times), formatTime(times[0]), len(missed), policy))
+
+	var runs []Run
+	switch policy {
+	case dbstore.CatchUpSkip:
+		for _, scheduledAt := range times {
+			if isMissed[scheduledAt] {
+				log.DefaultLogger.Info(fmt.Sprintf("Catch up: skipping the missed run of '%s' due at %s", schedule.Name, formatTime(scheduledAt)))
+				continue
+			}
+			runs = append(runs, Run{ScheduledAt: scheduledAt})
+		}
+	case dbstore.CatchUpAll:
+		for _, scheduledAt := range times {
+			if isMissed[scheduledAt] {
+				log.DefaultLogger.Info(fmt.Sprintf("Catch up: sending the missed run of '%s' due at %s", schedule.Name, formatTime(scheduledAt)))
+			}
+			runs = append(runs, Run{ScheduledAt: scheduledAt, CatchUp: isMissed[scheduledAt]})
+		}
+	default:
+		// A run held to be retried after failing isn't caught up on, it's retried whatever is due after it
+		var pending []int
+		for _, scheduledAt := range times {
+			if s.retrying(schedule, scheduledAt) {
+				runs = append(runs, Run{ScheduledAt: scheduledAt})
+				continue
+			}
+			pending = append(pending, scheduledAt)
+		}
+		if len(pending) > 0 {
+			latest := pending[len(pending)-1]
+			for _, scheduledAt := range pending[:len(pending)-1] {
+				log.DefaultLogger.Info(fmt.Sprintf("Catch up: skipping the run of '%s' due at %s in favour of the latest at %s", schedule.Name, formatTime(scheduledAt), formatTime(latest)))
+			}
+			runs = append(runs, Run{ScheduledAt: latest, CatchUp: isMissed[latest]})
+		}
+	}
+
+	if len(runs) == 0 {
+		s.Advance(schedule)
+	}
+	return runs
+}
+
+// Hold keeps a schedule due at a run which failed, so it is retried on the next pass before any later runs
+func (s *Scheduler) Hold(schedule dbstore.Schedule, scheduledAt int) {
+	if err := s.sql.SetNextReportTime(schedule.ID, scheduledAt); err != nil {
+		log.DefaultLogger.Error("Scheduler.Hold: SetNextReportTime: " + err.Error())
+	}
+}
+
+// Advance moves a schedule on to the first time it is due after now outside its blackouts, once its due runs
+// have been dealt with. A run since its nextReportTime which a blackout postponed until after now comes first when
+// it's earlier.
+func (s *Scheduler) Advance(schedule dbstore.Schedule) {
+	now := s.clock.Now()
+	next := schedule.NextReportTimeAfter(now)
+	rules := s.blackoutRules(schedule)
+	if blackout := covering(schedule, rules, next); blackout != nil {
+		if moved := outsideBlackouts(schedule, rules, next); moved != 0 {
+			log.DefaultLogger.Info(fmt.Sprintf("'%s' would be due at %s during blackout '%s', moving it to %s", schedule.Name, formatTime(next), blackout.Name, formatTime(moved)))
+			next = moved
+		}
+	}
+	if postponed := postponedRun(schedule, rules, now); postponed != 0 && postponed < next {
+		log.DefaultLogger.Info(fmt.Sprintf("'%s' has a run postponed by a blackout to %s", schedule.Name, formatTime(postponed)))
+		next = postponed
+	}
+	log.DefaultLogger.Info(fmt.Sprintf("Setting time of schedule '%s' to '%s'", schedule.Name, formatTime(next)))
+	if err := s.sql.SetNextReportTime(schedule.ID, next); err != nil {
+		log.DefaultLogger.Error("Scheduler.Advance: SetNextReportTime: " + err.Error())
+	}
+}
@@ -0,0 +1,234 @@
+package scheduler
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// newTestStore is an empty database in a temporary directory
+func newTestStore(t *testing.T) *dbstore.SQLiteDatasource {
+	t.Helper()
+	store := &dbstore.SQLiteDatasource{Path: filepath.Join(t.TempDir(), "msupply.db")}
+	store.Init()
+	return store
+}
+
+// blackedOutSchedule is a weekly schedule sent at 9am in Auckland, first due on Monday 5 January 2026 which is
+// blacked out
+func blackedOutSchedule(t *testing.T, store *dbstore.SQLiteDatasource, policy string) (dbstore.Schedule, *time.Location) {
+	t.Helper()
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skip("no timezone database: " + err.Error())
+	}
+
+	created, err := store.CreateSchedule("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := *created
+	schedule.Name = "Weekly stock"
+	schedule.Interval = dbstore.IntervalWeekly
+	schedule.Time = "09:00"
+	schedule.Timezone = "Pacific/Auckland"
+	schedule.BlackoutPolicy = policy
+	schedule.NextReportTime = int(time.Date(2026, 1, 5, 9, 0, 0, 0, auckland).Unix())
+	if err := store.SetNextReportTime(schedule.ID, schedule.NextReportTime); err != nil {
+		t.Fatal(err)
+	}
+
+	blackout := dbstore.Blackout{ScheduleID: schedule.ID, Name: "Stocktake", Type: dbstore.BlackoutTypeRange, StartDate: "2026-01-05", EndDate: "2026-01-05"}
+	if _, err := store.CreateBlackout(blackout); err != nil {
+		t.Fatal(err)
+	}
+	return schedule, auckland
+}
+
+// withLocalTime runs the test with the server in UTC, where the Auckland run on 5 January is still 4 January
+func withLocalTime(t *testing.T, location *time.Location) {
+	local := time.Local
+	time.Local = location
+	t.Cleanup(func() { time.Local = local })
+}
+
+func nextReportTime(t *testing.T, store *dbstore.SQLiteDatasource, id string) time.Time {
+	t.Helper()
+	schedule, err := store.GetSchedule(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return time.Unix(int64(schedule.NextReportTime), 0)
+}
+
+func TestDuePostponesRunToAfterBlackout(t *testing.T) {
+	withLocalTime(t, time.UTC)
+	store := newTestStore(t)
+	schedule, auckland := blackedOutSchedule(t, store, dbstore.BlackoutPolicyPostpone)
+
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 5, 9, 1, 0, 0, auckland)))
+	if runs := s.Due(&dbstore.Settings{}, schedule); len(runs) != 0 {
+		t.Fatalf("expected no runs during the blackout, got %v", runs)
+	}
+
+	// The postponed run is the next one, not the following week's
+	want := time.Date(2026, 1, 6, 9, 0, 0, 0, auckland)
+	if got := nextReportTime(t, store, schedule.ID); !got.Equal(want) {
+		t.Errorf("expected the run postponed to %s, got %s", want, got.In(auckland))
+	}
+}
+
+func TestDueSendsPostponedRunOnceDue(t *testing.T) {
+	withLocalTime(t, time.UTC)
+	store := newTestStore(t)
+	schedule, auckland := blackedOutSchedule(t, store, dbstore.BlackoutPolicyPostpone)
+
+	// Nothing has dealt with the blacked out run yet, e.g. Grafana was down
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 6, 9, 5, 0, 0, auckland)))
+	runs := s.Due(&dbstore.Settings{}, schedule)
+	want := int(time.Date(2026, 1, 6, 9, 0, 0, 0, auckland).Unix())
+	if len(runs) != 1 || runs[0].ScheduledAt != want {
+		t.Fatalf("expected the run postponed to %d, got %v", want, runs)
+	}
+}
+
+func TestDueSkipsBlackedOutRun(t *testing.T) {
+	withLocalTime(t, time.UTC)
+	store := newTestStore(t)
+	schedule, auckland := blackedOutSchedule(t, store, dbstore.BlackoutPolicySkip)
+
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 5, 9, 1, 0, 0, auckland)))
+	if runs := s.Due(&dbstore.Settings{}, schedule); len(runs) != 0 {
+		t.Fatalf("expected no runs during the blackout, got %v", runs)
+	}
+
+	want := time.Date(2026, 1, 12, 9, 0, 0, 0, auckland)
+	if got := nextReportTime(t, store, schedule.ID); !got.Equal(want) {
+		t.Errorf("expected the following week's run at %s, got %s", want, got.In(auckland))
+	}
+}
+
+func TestDueChecksBlackoutsInScheduleTimezone(t *testing.T) {
+	withLocalTime(t, time.UTC)
+	store := newTestStore(t)
+	schedule, auckland := blackedOutSchedule(t, store, dbstore.BlackoutPolicySkip)
+
+	// 9am on the 12th in Auckland is still the 11th in UTC, so a blackout on the 12th only covers it in Auckland
+	schedule.NextReportTime = int(time.Date(2026, 1, 12, 9, 0, 0, 0, auckland).Unix())
+	blackout := dbstore.Blackout{ScheduleID: schedule.ID, Name: "Holiday", Type: dbstore.BlackoutTypeRange, StartDate: "2026-01-12", EndDate: "2026-01-12"}
+	if _, err := store.CreateBlackout(blackout); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 12, 9, 1, 0, 0, auckland)))
+	if runs := s.Due(&dbstore.Settings{}, schedule); len(runs) != 0 {
+		t.Fatalf("expected the run on the 12th in Auckland to be blacked out, got %v", runs)
+	}
+}
+
+func TestPlannedPostponesInScheduleTimezone(t *testing.T) {
+	withLocalTime(t, time.UTC)
+	store := newTestStore(t)
+	schedule, auckland := blackedOutSchedule(t, store, dbstore.BlackoutPolicyPostpone)
+
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 1, 0, 0, 0, 0, auckland)))
+	planned := s.Planned(schedule, time.Date(2026, 1, 1, 0, 0, 0, 0, auckland), time.Date(2026, 1, 13, 0, 0, 0, 0, auckland))
+	want := []PlannedRun{
+		{ScheduledAt: int(time.Date(2026, 1, 6, 9, 0, 0, 0, auckland).Unix()), PostponedFrom: schedule.NextReportTime},
+		{ScheduledAt: int(time.Date(2026, 1, 12, 9, 0, 0, 0, auckland).Unix())},
+	}
+	if len(planned) != len(want) {
+		t.Fatalf("expected %v, got %v", want, planned)
+	}
+	for i := range want {
+		if planned[i] != want[i] {
+			t.Errorf("run %d: expected %v, got %v", i, want[i], planned[i])
+		}
+	}
+}
+
+func TestDueRetriesHeldRunBeforeCatchingUp(t *testing.T) {
+	store := newTestStore(t)
+	created, err := store.CreateSchedule("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := *created
+	schedule.Name = "Daily stock"
+	schedule.Interval = dbstore.IntervalDaily
+	schedule.Time = "09:00"
+	schedule.Timezone = "UTC"
+
+	// The run on the 5th failed and the schedule was held at it, while the 6th and 7th came due
+	failed := int(time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC).Unix())
+	schedule.NextReportTime = failed
+	if _, err := store.CreateReportRun(schedule.ID, failed); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithClock(store, clock.Fixed(time.Date(2026, 1, 7, 9, 5, 0, 0, time.UTC)))
+	runs := s.Due(&dbstore.Settings{}, schedule)
+	latest := int(time.Date(2026, 1, 7, 9, 0, 0, 0, time.UTC).Unix())
+	if len(runs) != 2 || runs[0].ScheduledAt != failed || runs[1].ScheduledAt != latest {
+		t.Fatalf("expected the held run to be retried before the latest, got %v", runs)
+	}
+	if runs[0].CatchUp {
+		t.Error("expected the held run to be a retry rather than caught up on")
+	}
+}
+
+func TestDueCatchesUpAfterDowntime(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Skip("no timezone database: " + err.Error())
+	}
+	nineAM := func(day int) int {
+		return int(time.Date(2026, 4, day, 9, 0, 0, 0, auckland).Unix())
+	}
+
+	// Down from before the run on 3 April until after the one on the 7th, over daylight saving ending on the 5th
+	cases := []struct {
+		policy string
+		want   []Run
+		next   int
+	}{
+		{dbstore.CatchUpSkip, nil, nineAM(8)},
+		{dbstore.CatchUpOnce, []Run{{ScheduledAt: nineAM(7), CatchUp: true}}, nineAM(3)},
+		{dbstore.CatchUpAll, []Run{{ScheduledAt: nineAM(3), CatchUp: true}, {ScheduledAt: nineAM(4), CatchUp: true}, {ScheduledAt: nineAM(5), CatchUp: true}, {ScheduledAt: nineAM(6), CatchUp: true}, {ScheduledAt: nineAM(7), CatchUp: true}}, nineAM(3)},
+	}
+	for _, c := range cases {
+		store := newTestStore(t)
+		created, err := store.CreateSchedule("admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule := *created
+		schedule.Name = "Daily stock"
+		schedule.Interval = dbstore.IntervalDaily
+		schedule.Time = "09:00"
+		schedule.Timezone = "Pacific/Auckland"
+		schedule.CatchUp = c.policy
+		schedule.NextReportTime = nineAM(3)
+		if err := store.SetNextReportTime(schedule.ID, schedule.NextReportTime); err != nil {
+			t.Fatal(err)
+		}
+
+		s := NewWithClock(store, clock.Fixed(time.Date(2026, 4, 7, 12, 0, 0, 0, auckland)))
+		runs := s.Due(&dbstore.Settings{}, schedule)
+		if len(runs) != len(c.want) {
+			t.Fatalf("%s: expected %v, got %v", c.policy, c.want, runs)
+		}
+		for i := range c.want {
+			if runs[i] != c.want[i] {
+				t.Errorf("%s: run %d: expected %v, got %v", c.policy, i, c.want[i], runs[i])
+			}
+		}
+		// Runs left to send keep the schedule where it is, so it only moves on once they're done
+		if got := nextReportTime(t, store, schedule.ID); int(got.Unix()) != c.next {
+			t.Errorf("%s: expected the next run at %s, got %s", c.policy, time.Unix(int64(c.next), 0).In(auckland), got.In(auckland))
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/health"
 	"github.com/grafana/simple-datasource-backend/pkg/server"
 )
 
@@ -21,7 +22,7 @@ func getServeOptions() (datasource.ServeOpts, *dbstore.SQLiteDatasource, error)
 
 	return datasource.ServeOpts{
 		QueryDataHandler:    sqlDatasource,
-		CheckHealthHandler:  sqlDatasource,
+		CheckHealthHandler:  health.NewChecker(sqlDatasource),
 		CallResourceHandler: server.ResourceHandler(sqlDatasource),
 	}, sqlDatasource, nil
 }
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Days of acknowledgements summarised without ?days
+const defaultAcknowledgementDays = 90
+
+// checkAcknowledgeLink is the run and address of a "mark as reviewed" link, writing the error response when its
+// signature doesn't match. Like an unsubscribe link, the signature rather than the user's role is what allows it.
+func (server *HttpServer) checkAcknowledgeLink(rw http.ResponseWriter, request *http.Request) (string, string, bool) {
+	query := request.URL.Query()
+	runID := query.Get("run-id")
+	address := query.Get("address")
+
+	secret, err := server.db.Secret(dbstore.AcknowledgeSecret)
+	if err != nil {
+		log.DefaultLogger.Error("checkAcknowledgeLink: db.Secret(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if runID == "" || address == "" || !dbstore.CheckAcknowledgement(secret, runID, address, query.Get("signature")) {
+		err = errors.New("this link is not valid, it may have been changed or cut short")
+		log.DefaultLogger.Warn("checkAcknowledgeLink: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return "", "", false
+	}
+	return runID, address, true
+}
+
+// confirmAcknowledge is the page the "mark as reviewed" link in the reports of schedules tracking acknowledgements
+// opens. Following the link doesn't acknowledge the report, as mail scanners open every link in an email, the
+// recipient has to press the button which posts it back.
+func (server *HttpServer) confirmAcknowledge(rw http.ResponseWriter, request *http.Request) {
+	runID, address, ok := server.checkAcknowledgeLink(rw, request)
+	if !ok {
+		return
+	}
+
+	name := "the report"
+	if run, err := server.db.GetReportRun(runID); err == nil {
+		if schedule, err := server.db.GetSchedule(run.ScheduleID); err == nil {
+			name = schedule.Name
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "<html><body><form method=\"post\"><p>Mark %s as reviewed by %s?</p><button type=\"submit\">Mark as reviewed</button></form></body></html>", html.EscapeString(name), html.EscapeString(address))
+}
+
+// acknowledge records the recipient reviewed the report, when they confirm the link they followed
+func (server *HttpServer) acknowledge(rw http.ResponseWriter, request *http.Request) {
+	runID, address, ok := server.checkAcknowledgeLink(rw, request)
+	if !ok {
+		return
+	}
+
+	acknowledgement, err := server.db.Acknowledge(runID, address)
+	if errors.Is(err, dbstore.ErrNotSentTo) {
+		http.Error(rw, "this report is no longer being tracked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("acknowledge: db.Acknowledge(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	name := "the report"
+	if schedule, err := server.db.GetSchedule(acknowledgement.ScheduleID); err == nil {
+		name = schedule.Name
+	}
+	reviewed := time.Unix(int64(acknowledgement.AcknowledgedAt), 0).Format("2 Jan 2006 15:04")
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "<html><body><p>Thank you, %s was marked as reviewed by %s on %s.</p></body></html>", html.EscapeString(name), html.EscapeString(address), html.EscapeString(reviewed))
+}
+
+// acknowledgementSince reads ?days, how far back acknowledgements are summarised
+func acknowledgementSince(request *http.Request) (int, error) {
+	days := defaultAcknowledgementDays
+	if value := request.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 {
+			return 0, errors.New("days must be a whole number of days from 1")
+		}
+	}
+	return int(time.Now().AddDate(0, 0, -days).Unix()), nil
+}
+
+// fetchAcknowledgements summarises how many recipients marked each schedule tracking acknowledgements as reviewed
+// over the last ?days, 90 by default
+func (server *HttpServer) fetchAcknowledgements(rw http.ResponseWriter, request *http.Request) {
+	since, err := acknowledgementSince(request)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only the schedules the user can see, as the summaries say who hasn't reviewed them
+	schedules, err := server.visibleSchedules(request)
+	if err != nil {
+		log.DefaultLogger.Error("fetchAcknowledgements: visibleSchedules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	summaries := []dbstore.AcknowledgementSummary{}
+	for _, schedule := range schedules {
+		if !schedule.TrackAcknowledgement {
+			continue
+		}
+		summary, err := server.db.GetAcknowledgementSummary(schedule, since)
+		if err != nil {
+			log.DefaultLogger.Error("fetchAcknowledgements: db.GetAcknowledgementSummary(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		summaries = append(summaries, *summary)
+	}
+
+	err = json.NewEncoder(rw).Encode(summaries)
+	if err != nil {
+		log.DefaultLogger.Error("fetchAcknowledgements: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// fetchScheduleAcknowledgements summarises the acknowledgements of one schedule's runs, with who has yet to
+// acknowledge the latest
+func (server *HttpServer) fetchScheduleAcknowledgements(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	scheduleID := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, scheduleID, false) {
+		return
+	}
+
+	since, err := acknowledgementSince(request)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedule, err := server.db.GetSchedule(scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("fetchScheduleAcknowledgements: db.GetSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	summary, err := server.db.GetAcknowledgementSummary(*schedule, since)
+	if err != nil {
+		log.DefaultLogger.Error("fetchScheduleAcknowledgements: db.GetAcknowledgementSummary(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(summary)
+	if err != nil {
+		log.DefaultLogger.Error("fetchScheduleAcknowledgements: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
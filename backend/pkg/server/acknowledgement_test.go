@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// trackedRun is a run of a schedule of alice's tracking acknowledgements, sent to a nurse
+func trackedRun(t *testing.T, server *HttpServer) (*dbstore.Schedule, *dbstore.ReportRun) {
+	t.Helper()
+	schedule := createSchedule(t, server, alice.Login)
+	schedule.Name = "Cold chain"
+	schedule.TrackAcknowledgement = true
+	if _, err := server.db.UpdateSchedule(schedule.ID, *schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := server.db.CreateReportRun(schedule.ID, 1767600000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.db.ExpectAcknowledgements(run.ID, schedule.ID, []string{"nurse@clinic.org"}); err != nil {
+		t.Fatal(err)
+	}
+	return schedule, run
+}
+
+func acknowledgeTarget(t *testing.T, server *HttpServer, runID string, address string) string {
+	t.Helper()
+	secret, err := server.db.Secret(dbstore.AcknowledgeSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"run-id": {runID}, "address": {address}, "signature": {dbstore.SignAcknowledgement(secret, runID, address)}}
+	return "/acknowledge?" + query.Encode()
+}
+
+func outstanding(t *testing.T, server *HttpServer, schedule *dbstore.Schedule) []string {
+	t.Helper()
+	summary, err := server.db.GetAcknowledgementSummary(*schedule, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary.Outstanding
+}
+
+func TestFollowingAcknowledgeLinkOnlyAsksToConfirm(t *testing.T) {
+	server := newTestServer(t)
+	schedule, run := trackedRun(t, server)
+
+	response := callPublic(server, http.MethodGet, acknowledgeTarget(t, server, run.ID, "nurse@clinic.org"))
+	expectStatus(t, response.Code, http.StatusOK, "following the link")
+	if got := outstanding(t, server, schedule); len(got) != 1 {
+		t.Errorf("expected following the link not to acknowledge the report, outstanding: %v", got)
+	}
+}
+
+func TestConfirmingAcknowledgeLinkRecordsIt(t *testing.T) {
+	server := newTestServer(t)
+	schedule, run := trackedRun(t, server)
+
+	response := callPublic(server, http.MethodPost, acknowledgeTarget(t, server, run.ID, "nurse@clinic.org"))
+	expectStatus(t, response.Code, http.StatusOK, "confirming the link")
+	if got := outstanding(t, server, schedule); len(got) != 0 {
+		t.Errorf("expected the report to be acknowledged, outstanding: %v", got)
+	}
+}
+
+func TestAcknowledgeLinkForAnotherAddressIsRefused(t *testing.T) {
+	server := newTestServer(t)
+	schedule, run := trackedRun(t, server)
+
+	target := acknowledgeTarget(t, server, run.ID, "doctor@clinic.org")
+	forged, _ := url.Parse(target)
+	query := forged.Query()
+	query.Set("address", "nurse@clinic.org")
+	forged.RawQuery = query.Encode()
+
+	expectStatus(t, callPublic(server, http.MethodPost, forged.String()).Code, http.StatusForbidden, "link signed for another address")
+	if got := outstanding(t, server, schedule); len(got) != 1 {
+		t.Errorf("expected the report not to be acknowledged, outstanding: %v", got)
+	}
+}
+
+func TestAcknowledgementSummariesOnlyOfVisibleSchedules(t *testing.T) {
+	server := newTestServer(t)
+	schedule, _ := trackedRun(t, server)
+
+	summaries := func(user *backend.User) []dbstore.AcknowledgementSummary {
+		var summaries []dbstore.AcknowledgementSummary
+		response := callAs(t, server, user, http.MethodGet, "/acknowledgement", nil)
+		expectStatus(t, response.Status, http.StatusOK, "summaries for "+user.Login)
+		if err := json.Unmarshal(response.Body, &summaries); err != nil {
+			t.Fatal(err)
+		}
+		return summaries
+	}
+
+	if got := summaries(alice); len(got) != 1 || got[0].ScheduleID != schedule.ID {
+		t.Errorf("expected the owner to see their schedule's summary, got %v", got)
+	}
+	if got := summaries(admin); len(got) != 1 {
+		t.Errorf("expected an admin to see every summary, got %v", got)
+	}
+	if got := summaries(bob); len(got) != 0 {
+		t.Errorf("expected another editor to see no summaries, got %v", got)
+	}
+
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/schedule/"+schedule.ID+"/acknowledgement", nil).Status, http.StatusForbidden, "another editor's schedule acknowledgements")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/acknowledgement", nil).Status, http.StatusOK, "the owner's schedule acknowledgements")
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func (server *HttpServer) fetchMonthlyCosts(rw http.ResponseWriter, request *http.Request) {
+	scheduleID := request.URL.Query().Get("schedule-id")
+
+	costs, err := server.db.GetMonthlyCosts(scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("fetchMonthlyCosts: db.GetMonthlyCosts(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(costs)
+	if err != nil {
+		log.DefaultLogger.Error("fetchMonthlyCosts: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
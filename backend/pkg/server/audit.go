@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/syslog"
+)
+
+// Types of entity recorded in the audit log
+const (
+	auditSettings              = "settings"
+	auditSettingsLock          = "settingsLock"
+	auditSchedule              = "schedule"
+	auditReportGroup           = "reportGroup"
+	auditReportGroupMembership = "reportGroupMembership"
+	auditReportGroupPermission = "reportGroupPermission"
+	auditReportContent         = "reportContent"
+	auditContact               = "contact"
+	auditScheduleShare         = "scheduleShare"
+	auditBundle                = "bundle"
+	auditBackup                = "backup"
+	auditBlackout              = "blackout"
+	auditHolidayCalendar       = "holidayCalendar"
+	auditDataSubject           = "dataSubject"
+	auditResidencyRule         = "residencyRule"
+	auditMaskingRule           = "maskingRule"
+	auditEmailProfile          = "emailProfile"
+	auditDeliveryProblem       = "deliveryProblem"
+	auditUnsubscribe           = "unsubscribe"
+	auditComment               = "comment"
+	auditRequest               = "request"
+	auditJob                   = "job"
+	auditReportTemplate        = "reportTemplate"
+	auditBranding              = "branding"
+	auditShareLink             = "shareLink"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 500
+)
+
+// actor is the Grafana user making the request, or who a super-admin is impersonating. Only the user Grafana
+// forwards is trusted, never a header the client could set, as it decides who owns what.
+func actor(request *http.Request) string {
+	if fromPublicLink(request) {
+		return publicActor
+	}
+	if user := requestUser(request); user != nil && user.Login != "" {
+		return user.Login
+	}
+	return "unknown"
+}
+
+func auditJSON(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(bytes)
+}
+
+// redactSettings hides the stored passwords and keys from the audit log, only whether they changed is recorded
+func redactSettings(settings *dbstore.Settings) *dbstore.Settings {
+	if settings == nil {
+		return nil
+	}
+
+	redacted := *settings
+	if redacted.GrafanaPassword != "" {
+		redacted.GrafanaPassword = "******"
+	}
+	if redacted.EmailPassword != "" {
+		redacted.EmailPassword = "******"
+	}
+	if redacted.RendererToken != "" {
+		redacted.RendererToken = "******"
+	}
+	if redacted.SigningKey != "" {
+		redacted.SigningKey = "******"
+	}
+	return &redacted
+}
+
+// redactEmailProfile hides a profile's password from the audit log like those of the settings
+func redactEmailProfile(profile *dbstore.EmailProfile) *dbstore.EmailProfile {
+	if profile == nil {
+		return nil
+	}
+
+	redacted := *profile
+	if redacted.Password != "" {
+		redacted.Password = "******"
+	}
+	return &redacted
+}
+
+// audit records a configuration change. Failing to write the audit log doesn't fail the change itself.
+func (server *HttpServer) audit(request *http.Request, action string, entityType string, entityID string, before interface{}, after interface{}) {
+	login := actor(request)
+	if target := impersonationFrom(request); target != nil && target.User != target.By {
+		login = target.By.Login + " as " + login
+	}
+	entry := dbstore.AuditEntry{Actor: login, Action: action, EntityType: entityType, EntityID: entityID, Before: auditJSON(before), After: auditJSON(after)}
+	if err := server.db.CreateAuditEntry(entry); err != nil {
+		log.DefaultLogger.Error("audit: db.CreateAuditEntry(): " + err.Error())
+	}
+
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("audit: db.GetSettings(): " + err.Error())
+		return
+	}
+	syslog.Send(settings, syslog.Event{
+		Kind:     syslog.EventChange,
+		Severity: syslog.SeverityNotice,
+		Message:  fmt.Sprintf("%s: %s %s %s", login, action, entityType, entityID),
+		Fields:   map[string]string{"actor": login, "action": action, "entityType": entityType, "entityID": entityID},
+	})
+}
+
+func (server *HttpServer) fetchAuditLog(rw http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = defaultAuditLogLimit
+	}
+	if limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+
+	filter := dbstore.AuditLogFilter{EntityType: query.Get("entity-type"), EntityID: query.Get("entity-id"), Actor: query.Get("actor")}
+	page, err := server.db.GetAuditLog(filter, offset, limit)
+	if err != nil {
+		log.DefaultLogger.Error("fetchAuditLog: db.GetAuditLog(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(page)
+	if err != nil {
+		log.DefaultLogger.Error("fetchAuditLog: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) backupDirectory() string {
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		return server.db.BackupDirectory(nil)
+	}
+	return server.db.BackupDirectory(settings)
+}
+
+func (server *HttpServer) fetchBackups(rw http.ResponseWriter, request *http.Request) {
+	backups, err := server.db.GetBackups(server.backupDirectory())
+	if err != nil {
+		log.DefaultLogger.Error("fetchBackups: db.GetBackups(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(backups)
+	if err != nil {
+		log.DefaultLogger.Error("fetchBackups: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createBackup(rw http.ResponseWriter, request *http.Request) {
+	backup, err := server.db.CreateBackup(server.backupDirectory(), "")
+	if err != nil {
+		log.DefaultLogger.Error("createBackup: db.CreateBackup(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(backup)
+	if err != nil {
+		log.DefaultLogger.Error("createBackup: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// restoreBackup replaces the database with a backup, responding with the backup taken of the database it replaced
+func (server *HttpServer) restoreBackup(rw http.ResponseWriter, request *http.Request) {
+	var restore dbstore.RestoreRequest
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("restoreBackup: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("restoreBackup: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &restore)
+	if err != nil {
+		log.DefaultLogger.Error("restoreBackup: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.RestoreRequestFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	preRestore, err := server.db.RestoreBackup(server.backupDirectory(), restore.Name)
+	if err != nil {
+		log.DefaultLogger.Error("restoreBackup: db.RestoreBackup(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+	// Recorded in the restored database, as the previous one's log is in the pre-restore backup
+	server.audit(request, dbstore.AuditActionRestore, auditBackup, restore.Name, preRestore, restore)
+
+	err = json.NewEncoder(rw).Encode(preRestore)
+	if err != nil {
+		log.DefaultLogger.Error("restoreBackup: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
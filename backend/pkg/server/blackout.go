@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchBlackouts(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	blackouts, err := server.db.GetBlackouts(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchBlackouts: db.GetBlackouts(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(blackouts)
+	if err != nil {
+		log.DefaultLogger.Error("fetchBlackouts: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createBlackout(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	var blackout dbstore.Blackout
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &blackout)
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.BlackoutFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = blackout.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: blackout.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	if blackout.Type == dbstore.BlackoutTypeCalendar {
+		calendar, err := server.db.GetHolidayCalendar(blackout.CalendarID)
+		if err != nil {
+			log.DefaultLogger.Error("createBlackout: db.GetHolidayCalendar(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		if calendar == nil {
+			http.Error(rw, "holiday calendar "+blackout.CalendarID+" does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
+	blackout.ScheduleID = id
+	created, err := server.db.CreateBlackout(blackout)
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: db.CreateBlackout(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditBlackout, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createBlackout: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteBlackout(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	blackoutID := vars["blackout-id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	err := server.db.DeleteBlackout(id, blackoutID)
+	if err != nil {
+		log.DefaultLogger.Error("deleteBlackout: db.DeleteBlackout(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditBlackout, blackoutID, dbstore.Blackout{ID: blackoutID, ScheduleID: id}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchHolidayCalendars(rw http.ResponseWriter, request *http.Request) {
+	calendars, err := server.db.GetHolidayCalendars()
+	if err != nil {
+		log.DefaultLogger.Error("fetchHolidayCalendars: db.GetHolidayCalendars(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(calendars)
+	if err != nil {
+		log.DefaultLogger.Error("fetchHolidayCalendars: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// saveHolidayCalendar creates a calendar, or replaces one when an id is given in the path
+func (server *HttpServer) saveHolidayCalendar(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	var before *dbstore.HolidayCalendar
+	if id != "" {
+		existing, err := server.db.GetHolidayCalendar(id)
+		if err != nil {
+			log.DefaultLogger.Error("saveHolidayCalendar: db.GetHolidayCalendar(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		if existing == nil {
+			http.Error(rw, "holiday calendar "+id+" does not exist", http.StatusNotFound)
+			return
+		}
+		before = existing
+	}
+
+	var calendar dbstore.HolidayCalendar
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &calendar)
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.HolidayCalendarFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = calendar.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: calendar.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	calendar.ID = id
+	saved, err := server.db.SaveHolidayCalendar(calendar)
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: db.SaveHolidayCalendar(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	if before == nil {
+		server.audit(request, dbstore.AuditActionCreate, auditHolidayCalendar, saved.ID, nil, saved)
+	} else {
+		server.audit(request, dbstore.AuditActionUpdate, auditHolidayCalendar, saved.ID, before, saved)
+	}
+
+	err = json.NewEncoder(rw).Encode(saved)
+	if err != nil {
+		log.DefaultLogger.Error("saveHolidayCalendar: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteHolidayCalendar(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	before, err := server.db.GetHolidayCalendar(id)
+	if err != nil {
+		log.DefaultLogger.Error("deleteHolidayCalendar: db.GetHolidayCalendar(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = server.db.DeleteHolidayCalendar(id)
+	if err != nil {
+		log.DefaultLogger.Error("deleteHolidayCalendar: db.DeleteHolidayCalendar(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditHolidayCalendar, id, before, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchBranding(rw http.ResponseWriter, request *http.Request) {
+	branding, err := server.db.GetBranding()
+	if err != nil {
+		log.DefaultLogger.Error("fetchBranding: db.GetBranding(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(branding)
+	if err != nil {
+		log.DefaultLogger.Error("fetchBranding: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) updateBranding(rw http.ResponseWriter, request *http.Request) {
+	var branding dbstore.Branding
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &branding)
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.BrandingFields()).Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = branding.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: branding.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, _ := server.db.GetBranding()
+
+	updated, err := server.db.UpdateBranding(branding, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: db.UpdateBranding(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditBranding, "", before, updated)
+
+	err = json.NewEncoder(rw).Encode(updated)
+	if err != nil {
+		log.DefaultLogger.Error("updateBranding: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchBrandingLogo(rw http.ResponseWriter, request *http.Request) {
+	branding, err := server.db.GetBranding()
+	if err != nil {
+		log.DefaultLogger.Error("fetchBrandingLogo: db.GetBranding(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if len(branding.Logo) == 0 {
+		http.Error(rw, "no logo has been uploaded", http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", branding.LogoType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(branding.Logo)))
+	rw.Write(branding.Logo)
+}
+
+// updateBrandingLogo replaces the logo with the image in the request's body
+func (server *HttpServer) updateBrandingLogo(rw http.ResponseWriter, request *http.Request) {
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("updateBrandingLogo: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	// One byte over the limit is enough to know it's too large
+	logo, err := ioutil.ReadAll(io.LimitReader(requestBody, dbstore.MaxLogoSize+1))
+	if err != nil {
+		log.DefaultLogger.Error("updateBrandingLogo: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	logoType, err := dbstore.LogoType(logo)
+	if errors.Is(err, dbstore.ErrLogoTooLarge) {
+		http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	server.saveBrandingLogo(rw, request, logo, logoType)
+}
+
+func (server *HttpServer) deleteBrandingLogo(rw http.ResponseWriter, request *http.Request) {
+	server.saveBrandingLogo(rw, request, nil, "")
+}
+
+func (server *HttpServer) saveBrandingLogo(rw http.ResponseWriter, request *http.Request, logo []byte, logoType string) {
+	before, _ := server.db.GetBranding()
+
+	updated, err := server.db.SetBrandingLogo(logo, logoType, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("saveBrandingLogo: db.SetBrandingLogo(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditBranding, "logo", before, updated)
+
+	err = json.NewEncoder(rw).Encode(updated)
+	if err != nil {
+		log.DefaultLogger.Error("saveBrandingLogo: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
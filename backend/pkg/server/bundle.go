@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// exportBundle returns the report configuration as a bundle which can be imported into another
+// server, limited to the schedules given as a comma separated schedule-id
+func (server *HttpServer) exportBundle(rw http.ResponseWriter, request *http.Request) {
+	var scheduleIDs []string
+	if ids := request.URL.Query().Get("schedule-id"); ids != "" {
+		scheduleIDs = strings.Split(ids, ",")
+	}
+
+	bundle, err := server.db.ExportBundle(scheduleIDs)
+	if err != nil {
+		log.DefaultLogger.Error("exportBundle: db.ExportBundle(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.Header().Set("Content-Disposition", "attachment; filename=\"msupply-reports.json\"")
+	err = json.NewEncoder(rw).Encode(bundle)
+	if err != nil {
+		log.DefaultLogger.Error("exportBundle: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// importBundle writes a bundle, resolving entities which already exist as given by conflict, skipping them by default
+func (server *HttpServer) importBundle(rw http.ResponseWriter, request *http.Request) {
+	conflict := request.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = dbstore.ConflictSkip
+	}
+
+	var bundle dbstore.Bundle
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &bundle)
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.BundleFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = bundle.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: bundle.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	result, err := server.db.ImportBundle(bundle, conflict)
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: db.ImportBundle(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionImport, auditBundle, "", nil, result)
+
+	err = json.NewEncoder(rw).Encode(result)
+	if err != nil {
+		log.DefaultLogger.Error("importBundle: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+// Longest calendar worked out at once, a year of days
+const maxCalendarDays = 366
+
+const calendarDateLayout = "2006-01-02"
+
+// Statuses of runs still to come. Past runs have their run's status: running, sent, partial or failed.
+const (
+	CalendarStatusPlanned = "planned"
+	// Planned, but skipped by one of the schedule's blackouts
+	CalendarStatusBlackout = "blackout"
+)
+
+// CalendarEntry is a run of a schedule on a day of the calendar, which has happened or is planned
+type CalendarEntry struct {
+	ScheduleID   string `json:"scheduleID"`
+	ScheduleName string `json:"scheduleName"`
+	// When the run started, or is planned to
+	Time   int    `json:"time"`
+	Status string `json:"status"`
+	// The run's ID, for past runs
+	RunID string `json:"runID,omitempty"`
+	// The blackout skipping a planned run, or the time a blackout postponed it from
+	Blackout      string `json:"blackout,omitempty"`
+	PostponedFrom int    `json:"postponedFrom,omitempty"`
+}
+
+type CalendarDay struct {
+	Date    string          `json:"date"`
+	Entries []CalendarEntry `json:"entries"`
+}
+
+// Calendar is every day from From to To, with the runs on each in time order
+type Calendar struct {
+	From     string        `json:"from"`
+	To       string        `json:"to"`
+	Timezone string        `json:"timezone"`
+	Days     []CalendarDay `json:"days"`
+}
+
+// calendarRange reads the calendar's dates, e.g. ?from=2024-03-01&to=2024-03-31&timezone=Africa/Nairobi. Both
+// dates are included, and the current month on the server's clock in its timezone is used without them.
+func calendarRange(request *http.Request) (time.Time, time.Time, *time.Location, error) {
+	query := request.URL.Query()
+
+	location := time.Local
+	if timezone := query.Get("timezone"); timezone != "" {
+		var err error
+		location, err = time.LoadLocation(timezone)
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, errors.New("timezone must be an IANA timezone such as Pacific/Auckland")
+		}
+	}
+
+	now := clock.System.Now().In(location)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, location)
+	to := from.AddDate(0, 1, -1)
+
+	var err error
+	if query.Get("from") != "" {
+		from, err = time.ParseInLocation(calendarDateLayout, query.Get("from"), location)
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, errors.New("from must be a date such as 2024-03-01")
+		}
+	}
+	if query.Get("to") != "" {
+		to, err = time.ParseInLocation(calendarDateLayout, query.Get("to"), location)
+		if err != nil {
+			return time.Time{}, time.Time{}, nil, errors.New("to must be a date such as 2024-03-31")
+		}
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, nil, errors.New("to can't be before from")
+	}
+	if to.Sub(from) >= maxCalendarDays*24*time.Hour {
+		return time.Time{}, time.Time{}, nil, errors.New("the calendar can't be longer than a year")
+	}
+
+	return from, to, location, nil
+}
+
+// fetchCalendar lists the runs of the user's schedules, or every schedule with ?all=true for admins,
+// on each day of the calendar: those which have happened with their status, and those still to come
+func (server *HttpServer) fetchCalendar(rw http.ResponseWriter, request *http.Request) {
+	from, to, location, err := calendarRange(request)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The end of the last day
+	end := to.AddDate(0, 0, 1).Add(-time.Second)
+
+	schedules, err := server.calendarSchedules(request)
+	if errors.Is(err, errAllSchedules) {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchCalendar: calendarSchedules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	runs, err := server.db.GetReportRunsBetween(int(from.Unix()), int(end.Unix()))
+	if err != nil {
+		log.DefaultLogger.Error("fetchCalendar: db.GetReportRunsBetween(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	names := make(map[string]string)
+	for _, schedule := range schedules {
+		names[schedule.ID] = schedule.Name
+	}
+
+	entries := []CalendarEntry{}
+	for _, run := range runs {
+		if name, ok := names[run.ScheduleID]; ok {
+			entries = append(entries, CalendarEntry{ScheduleID: run.ScheduleID, ScheduleName: name, Time: run.StartedAt, Status: run.Status, RunID: run.ID})
+		}
+	}
+
+	planner := scheduler.New(server.db)
+	for _, schedule := range schedules {
+		for _, planned := range planner.Planned(schedule, from, end) {
+			status := CalendarStatusPlanned
+			if planned.Blackout != "" {
+				status = CalendarStatusBlackout
+			}
+			entries = append(entries, CalendarEntry{ScheduleID: schedule.ID, ScheduleName: schedule.Name, Time: planned.ScheduledAt, Status: status, Blackout: planned.Blackout, PostponedFrom: planned.PostponedFrom})
+		}
+	}
+
+	calendar := newCalendar(from, to, location, entries)
+	err = json.NewEncoder(rw).Encode(calendar)
+	if err != nil {
+		log.DefaultLogger.Error("fetchCalendar: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// errAllSchedules is returned for ?all=true from anyone but an admin
+var errAllSchedules = errors.New("Forbidden: only admins can see every schedule on the calendar")
+
+// calendarSchedules are the user's schedules, or every schedule with ?all=true for admins
+func (server *HttpServer) calendarSchedules(request *http.Request) ([]dbstore.Schedule, error) {
+	return server.schedulesOnCalendar(request.Context(), actor(request), isAdmin(request), request.URL.Query().Get("all") == "true")
+}
+
+// schedulesOnCalendar are the schedules a user owns, shares or their team owns, or every schedule when an admin asks
+// for all of them
+func (server *HttpServer) schedulesOnCalendar(ctx context.Context, login string, admin bool, all bool) ([]dbstore.Schedule, error) {
+	if all {
+		if !admin {
+			return nil, errAllSchedules
+		}
+		return server.db.GetSchedules()
+	}
+	schedules, _, err := server.db.GetSchedulesFor(login, server.teamsOf(ctx, login), dbstore.ListOptions{})
+	return schedules, err
+}
+
+// newCalendar puts the entries on their days, leaving out any which a postponement has moved past the last day
+func newCalendar(from time.Time, to time.Time, location *time.Location, entries []CalendarEntry) Calendar {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time < entries[j].Time })
+
+	calendar := Calendar{From: from.Format(calendarDateLayout), To: to.Format(calendarDateLayout), Timezone: location.String(), Days: []CalendarDay{}}
+	days := make(map[string]int)
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(calendarDateLayout)
+		days[date] = len(calendar.Days)
+		calendar.Days = append(calendar.Days, CalendarDay{Date: date, Entries: []CalendarEntry{}})
+	}
+
+	for _, entry := range entries {
+		if i, ok := days[time.Unix(int64(entry.Time), 0).In(location).Format(calendarDateLayout)]; ok {
+			calendar.Days[i].Entries = append(calendar.Days[i].Entries, entry)
+		}
+	}
+
+	return calendar
+}
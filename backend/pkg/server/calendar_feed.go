@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+// Days of runs in the feed without ?days, enough for a monthly schedule to always show its next report
+const defaultFeedDays = 35
+
+// How long each run is shown for in a calendar, as reports have no end time of their own
+const feedEventDuration = 15 * time.Minute
+
+const icsTimeLayout = "20060102T150405Z"
+
+// Longest line of a feed in octets, after which lines are folded onto the next
+const icsLineLength = 75
+
+// Path of the feed on the public address, where calendar clients read it with the user's token
+const publicCalendarFeedPath = "/calendar.ics"
+
+// CalendarFeedLink is the URL a user subscribes to their feed with, only ever shown when it's made
+type CalendarFeedLink struct {
+	URL string `json:"url"`
+}
+
+// fetchCalendarFeed is the upcoming runs of the schedules on the calendar as an iCalendar feed, for signed in
+// users. Calendar clients can't send Grafana's credentials, so subscribe to the URL of createCalendarFeedToken.
+func (server *HttpServer) fetchCalendarFeed(rw http.ResponseWriter, request *http.Request) {
+	days, err := upcomingDays(request, defaultFeedDays)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedules, err := server.calendarSchedules(request)
+	if errors.Is(err, errAllSchedules) {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchCalendarFeed: calendarSchedules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	server.writeCalendarFeed(rw, schedules, days)
+}
+
+// fetchPublicCalendarFeed is the feed of the user whose token is in the URL, e.g. for program managers to subscribe
+// to in Outlook to know when their reports are due. It's one of the public links, allowed by the token alone, and
+// covers what the user would see signed in, with ?all=true only for a token made by an admin.
+func (server *HttpServer) fetchPublicCalendarFeed(rw http.ResponseWriter, request *http.Request) {
+	days, err := upcomingDays(request, defaultFeedDays)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token := request.URL.Query().Get("token")
+	if token == "" {
+		http.Error(rw, "this link is not valid", http.StatusForbidden)
+		return
+	}
+	feedToken, err := server.db.GetCalendarFeedToken(token)
+	if err != nil {
+		log.DefaultLogger.Error("fetchPublicCalendarFeed: db.GetCalendarFeedToken(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	if feedToken == nil {
+		http.Error(rw, "this link is not valid", http.StatusForbidden)
+		return
+	}
+
+	admin := roleLevels[feedToken.Role] >= roleLevels[RoleAdmin]
+	schedules, err := server.schedulesOnCalendar(request.Context(), feedToken.Login, admin, request.URL.Query().Get("all") == "true")
+	if errors.Is(err, errAllSchedules) {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchPublicCalendarFeed: schedulesOnCalendar(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	server.writeCalendarFeed(rw, schedules, days)
+}
+
+// createCalendarFeedToken makes the user a new URL for their feed on the public address, which stops any they had
+// before working
+func (server *HttpServer) createCalendarFeedToken(rw http.ResponseWriter, request *http.Request) {
+	publicURL := dbstore.PublicURL()
+	if publicURL == "" {
+		http.Error(rw, "calendar feeds are served on the public address, and "+dbstore.PublicURLEnv+" isn't set", http.StatusConflict)
+		return
+	}
+
+	role := ""
+	if user := requestUser(request); user != nil {
+		role = user.Role
+	}
+	token, err := server.db.CreateCalendarFeedToken(actor(request), role)
+	if err != nil {
+		log.DefaultLogger.Error("createCalendarFeedToken: db.CreateCalendarFeedToken(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	query := url.Values{}
+	query.Set("org", strconv.FormatInt(server.db.OrgID(), 10))
+	query.Set("token", token)
+	err = json.NewEncoder(rw).Encode(CalendarFeedLink{URL: publicURL + publicCalendarFeedPath + "?" + query.Encode()})
+	if err != nil {
+		log.DefaultLogger.Error("createCalendarFeedToken: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteCalendarFeedToken stops the user's feed URL working
+func (server *HttpServer) deleteCalendarFeedToken(rw http.ResponseWriter, request *http.Request) {
+	err := server.db.DeleteCalendarFeedToken(actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("deleteCalendarFeedToken: db.DeleteCalendarFeedToken(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// writeCalendarFeed writes the schedules' runs over the coming days as an iCalendar feed
+func (server *HttpServer) writeCalendarFeed(rw http.ResponseWriter, schedules []dbstore.Schedule, days int) {
+	now := clock.System.Now()
+	stamp := now.UTC().Format(icsTimeLayout)
+	planner := scheduler.New(server.db)
+
+	var feed strings.Builder
+	writeICSLine(&feed, "BEGIN:VCALENDAR")
+	writeICSLine(&feed, "VERSION:2.0")
+	writeICSLine(&feed, "PRODID:-//mSupply//Dashboard App Reports//EN")
+	writeICSLine(&feed, "CALSCALE:GREGORIAN")
+	writeICSLine(&feed, "METHOD:PUBLISH")
+	writeICSLine(&feed, "X-WR-CALNAME:mSupply reports")
+	for _, schedule := range schedules {
+		for _, planned := range planner.Planned(schedule, now, now.AddDate(0, 0, days)) {
+			start := time.Unix(int64(planned.ScheduledAt), 0).UTC()
+			description := schedule.Description
+			if planned.PostponedFrom != 0 {
+				description = strings.TrimSpace(description + "\nPostponed by a blackout from " + time.Unix(int64(planned.PostponedFrom), 0).In(schedule.Location()).Format("2006-01-02 15:04 MST"))
+			}
+
+			writeICSLine(&feed, "BEGIN:VEVENT")
+			// Stays the same as long as the run does, so clients update rather than duplicate it
+			writeICSLine(&feed, fmt.Sprintf("UID:%s-%d@msupply-reports", schedule.ID, planned.ScheduledAt))
+			writeICSLine(&feed, "DTSTAMP:"+stamp)
+			writeICSLine(&feed, "DTSTART:"+start.Format(icsTimeLayout))
+			writeICSLine(&feed, "DTEND:"+start.Add(feedEventDuration).Format(icsTimeLayout))
+			writeICSLine(&feed, "SUMMARY:"+icsText(schedule.Name))
+			if description != "" {
+				writeICSLine(&feed, "DESCRIPTION:"+icsText(description))
+			}
+			if planned.Blackout != "" {
+				writeICSLine(&feed, "STATUS:CANCELLED")
+				writeICSLine(&feed, "COMMENT:"+icsText("Skipped by the blackout "+planned.Blackout))
+			} else {
+				writeICSLine(&feed, "STATUS:CONFIRMED")
+			}
+			writeICSLine(&feed, "TRANSP:TRANSPARENT")
+			writeICSLine(&feed, "END:VEVENT")
+		}
+	}
+	writeICSLine(&feed, "END:VCALENDAR")
+
+	rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	rw.Header().Set("Content-Disposition", "inline; filename=\"msupply-reports.ics\"")
+	rw.WriteHeader(http.StatusOK)
+	_, err := rw.Write([]byte(feed.String()))
+	if err != nil {
+		log.DefaultLogger.Error("writeCalendarFeed: rw.Write(): " + err.Error())
+	}
+}
+
+// icsText escapes text for a property's value
+func icsText(text string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\r\n", "\\n", "\n", "\\n").Replace(text)
+}
+
+// writeICSLine ends a line with CRLF, folding it onto lines starting with a space when it's too long, without
+// splitting a UTF-8 character
+func writeICSLine(feed *strings.Builder, line string) {
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > icsLineLength {
+			feed.WriteString("\r\n ")
+			length = 1
+		}
+		feed.WriteRune(r)
+		length += size
+	}
+	feed.WriteString("\r\n")
+}
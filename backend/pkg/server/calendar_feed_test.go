@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// withPublicURL serves the public links at an address for the test
+func withPublicURL(t *testing.T) {
+	t.Setenv(dbstore.PublicAddressEnv, ":0")
+	t.Setenv(dbstore.PublicURLEnv, "https://reports.example.org")
+}
+
+// scheduleDueSoon is a daily schedule next sent in an hour, so it's on the feed
+func scheduleDueSoon(t *testing.T, server *HttpServer, owner string) *dbstore.Schedule {
+	t.Helper()
+	schedule := createSchedule(t, server, owner)
+	if err := server.db.SetNextReportTime(schedule.ID, int(time.Now().Add(time.Hour).Unix())); err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+// feedTarget is the path and query of the user's new feed URL, as requested of the public links
+func feedTarget(t *testing.T, server *HttpServer, user *backend.User) string {
+	t.Helper()
+	response := callAs(t, server, user, http.MethodPost, "/calendar/feed-token", nil)
+	expectStatus(t, response.Status, http.StatusOK, "making a feed URL")
+	var link CalendarFeedLink
+	if err := json.Unmarshal(response.Body, &link); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link.URL, "https://reports.example.org"+publicCalendarFeedPath+"?") {
+		t.Fatalf("expected the feed on the public address, got %s", link.URL)
+	}
+	return parsed.Path + "?" + parsed.RawQuery
+}
+
+func TestCalendarFeedServedPubliclyWithToken(t *testing.T) {
+	withPublicURL(t)
+	server := newTestServer(t)
+	own := scheduleDueSoon(t, server, alice.Login)
+	other := scheduleDueSoon(t, server, bob.Login)
+
+	feed := callPublic(server, http.MethodGet, feedTarget(t, server, alice))
+	expectStatus(t, feed.Code, http.StatusOK, "reading the feed without Grafana credentials")
+	if !strings.Contains(feed.Body.String(), own.ID) {
+		t.Errorf("expected the user's own schedule on their feed")
+	}
+	if strings.Contains(feed.Body.String(), other.ID) {
+		t.Errorf("expected another user's schedule not to be on the feed")
+	}
+}
+
+func TestCalendarFeedRejectsUnknownAndReplacedTokens(t *testing.T) {
+	withPublicURL(t)
+	server := newTestServer(t)
+
+	expectStatus(t, callPublic(server, http.MethodGet, publicCalendarFeedPath).Code, http.StatusForbidden, "no token")
+	expectStatus(t, callPublic(server, http.MethodGet, publicCalendarFeedPath+"?token=guess").Code, http.StatusForbidden, "made up token")
+
+	first := feedTarget(t, server, alice)
+	second := feedTarget(t, server, alice)
+	expectStatus(t, callPublic(server, http.MethodGet, first).Code, http.StatusForbidden, "replaced token")
+	expectStatus(t, callPublic(server, http.MethodGet, second).Code, http.StatusOK, "new token")
+
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, "/calendar/feed-token", nil).Status, http.StatusOK, "revoking the feed")
+	expectStatus(t, callPublic(server, http.MethodGet, second).Code, http.StatusForbidden, "revoked token")
+}
+
+func TestCalendarFeedAllSchedulesOnlyForAdmins(t *testing.T) {
+	withPublicURL(t)
+	server := newTestServer(t)
+	other := scheduleDueSoon(t, server, bob.Login)
+
+	expectStatus(t, callAs(t, server, viewer, http.MethodGet, "/calendar?all=true", nil).Status, http.StatusForbidden, "viewer's calendar of every schedule")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/calendar.ics?all=true", nil).Status, http.StatusForbidden, "editor's feed of every schedule")
+	expectStatus(t, callPublic(server, http.MethodGet, feedTarget(t, server, viewer)+"&all=true").Code, http.StatusForbidden, "viewer's token for every schedule")
+
+	feed := callPublic(server, http.MethodGet, feedTarget(t, server, admin)+"&all=true")
+	expectStatus(t, feed.Code, http.StatusOK, "admin's token for every schedule")
+	if !strings.Contains(feed.Body.String(), other.ID) {
+		t.Errorf("expected every schedule on an admin's feed with all=true")
+	}
+}
+
+func TestCalendarFeedTokenNeedsPublicURL(t *testing.T) {
+	t.Setenv(dbstore.PublicAddressEnv, "")
+	server := newTestServer(t)
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/calendar/feed-token", nil).Status, http.StatusConflict, "feed URL without public links")
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
+)
+
+func (server *HttpServer) fetchChaos(rw http.ResponseWriter, request *http.Request) {
+	err := json.NewEncoder(rw).Encode(chaos.Get())
+	if err != nil {
+		log.DefaultLogger.Error("fetchChaos: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) updateChaos(rw http.ResponseWriter, request *http.Request) {
+	var faults chaos.Faults
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("updateChaos: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("updateChaos: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &faults)
+	if err != nil {
+		log.DefaultLogger.Error("updateChaos: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, chaos.FaultsFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = faults.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateChaos: faults.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = chaos.Set(faults)
+	if err != nil {
+		log.DefaultLogger.Error("updateChaos: chaos.Set(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		panic(err)
+	}
+
+	log.DefaultLogger.Warn("Injecting faults", "faults", faults)
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) resetChaos(rw http.ResponseWriter, request *http.Request) {
+	chaos.Reset()
+	rw.WriteHeader(http.StatusOK)
+}
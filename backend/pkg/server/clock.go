@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+)
+
+func (server *HttpServer) fetchClock(rw http.ResponseWriter, request *http.Request) {
+	err := json.NewEncoder(rw).Encode(clock.Get())
+	if err != nil {
+		log.DefaultLogger.Error("fetchClock: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// updateClock moves the time schedules are worked out against, so what they do at a given time can be checked
+func (server *HttpServer) updateClock(rw http.ResponseWriter, request *http.Request) {
+	var travel clock.Travel
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("updateClock: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("updateClock: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &travel)
+	if err != nil {
+		log.DefaultLogger.Error("updateClock: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, clock.TravelFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = travel.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateClock: travel.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = clock.Set(travel)
+	if err != nil {
+		log.DefaultLogger.Error("updateClock: clock.Set(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		panic(err)
+	}
+
+	log.DefaultLogger.Warn("Moved the clock", "clock", clock.Get())
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) resetClock(rw http.ResponseWriter, request *http.Request) {
+	clock.Reset()
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// fetchComments lists a schedule's comments, or only those on one run with the run-id query
+func (server *HttpServer) fetchComments(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	comments, err := server.db.GetComments(id, request.URL.Query().Get("run-id"))
+	if err != nil {
+		log.DefaultLogger.Error("fetchComments: db.GetComments(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(comments)
+	if err != nil {
+		log.DefaultLogger.Error("fetchComments: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createComment(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if _, err := server.db.GetSchedule(id); err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var comment dbstore.Comment
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createComment: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createComment: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &comment)
+	if err != nil {
+		log.DefaultLogger.Error("createComment: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.CommentFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = comment.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createComment: comment.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if comment.RunID != "" {
+		run, err := server.db.GetReportRun(comment.RunID)
+		if err != nil || run.ScheduleID != id {
+			http.Error(rw, "runID isn't a run of this schedule", http.StatusBadRequest)
+			return
+		}
+	}
+
+	comment.ScheduleID = id
+	comment.Author = actor(request)
+	created, err := server.db.CreateComment(comment)
+	if err != nil {
+		log.DefaultLogger.Error("createComment: db.CreateComment(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditComment, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createComment: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteComment removes a comment, which only its author or an admin can do
+func (server *HttpServer) deleteComment(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	commentID := vars["comment-id"]
+
+	before, err := server.db.GetComment(id, commentID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if before.Author != actor(request) && !isAdmin(request) {
+		log.DefaultLogger.Warn("deleteComment: " + actor(request) + " can't delete a comment by " + before.Author)
+		http.Error(rw, "Forbidden: only the author or an admin can delete a comment", http.StatusForbidden)
+		return
+	}
+
+	err = server.db.DeleteComment(id, commentID)
+	if err != nil {
+		log.DefaultLogger.Error("deleteComment: db.DeleteComment(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditComment, commentID, before, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchContacts(rw http.ResponseWriter, request *http.Request) {
+	contacts, err := server.db.GetContacts()
+	if err != nil {
+		log.DefaultLogger.Error("fetchContacts: db.GetContacts(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(contacts)
+	if err != nil {
+		log.DefaultLogger.Error("fetchContacts: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createContact(rw http.ResponseWriter, request *http.Request) {
+	var contact dbstore.Contact
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createContact: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createContact: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &contact)
+	if err != nil {
+		log.DefaultLogger.Error("createContact: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ContactFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = contact.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createContact: contact.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	if contact.Channel == dbstore.ChannelEmail {
+		verifyDomains := false
+		if settings, err := server.db.GetSettings(); err == nil {
+			verifyDomains = settings.VerifyEmailDomains
+		}
+		err = checkEmailAddresses(rw, request, map[string]string{"address": contact.Address}, verifyDomains)
+		if err != nil {
+			log.DefaultLogger.Error("createContact: checkEmailAddresses(): " + err.Error())
+			panic(err)
+		}
+	}
+
+	created, err := server.db.CreateContact(contact)
+	if err != nil {
+		log.DefaultLogger.Error("createContact: db.CreateContact(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditContact, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createContact: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteContact(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	err := server.db.DeleteContact(id)
+	if err != nil {
+		log.DefaultLogger.Error("deleteContact: db.DeleteContact(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditContact, id, nil, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/bounce"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+const (
+	defaultDeliveryProblemLimit = 50
+	maxDeliveryProblemLimit     = 500
+)
+
+type BounceCheckResult struct {
+	Failures int `json:"failures"`
+}
+
+func deliveryPageParams(request *http.Request) (int, int) {
+	query := request.URL.Query()
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = defaultDeliveryProblemLimit
+	}
+	if limit > maxDeliveryProblemLimit {
+		limit = maxDeliveryProblemLimit
+	}
+
+	return offset, limit
+}
+
+// fetchDeliveryProblems lists the recipients whose reports are bouncing
+func (server *HttpServer) fetchDeliveryProblems(rw http.ResponseWriter, request *http.Request) {
+	offset, limit := deliveryPageParams(request)
+
+	page, err := server.db.GetDeliveryProblems(offset, limit)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDeliveryProblems: db.GetDeliveryProblems(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(page)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDeliveryProblems: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteDeliveryProblem stops showing an address as bouncing, once the recipient's mailbox has been fixed
+func (server *HttpServer) deleteDeliveryProblem(rw http.ResponseWriter, request *http.Request) {
+	address := mux.Vars(request)["address"]
+
+	err := server.db.ClearBouncing(address)
+	if err != nil {
+		log.DefaultLogger.Error("deleteDeliveryProblem: db.ClearBouncing(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditDeliveryProblem, address, nil, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// checkBounces reads the bounce mailbox straight away, rather than waiting for the next check
+func (server *HttpServer) checkBounces(rw http.ResponseWriter, request *http.Request) {
+	failures, err := bounce.New(server.db).Poll(request.Context())
+	if err != nil {
+		log.DefaultLogger.Error("checkBounces: Poll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(BounceCheckResult{Failures: failures})
+	if err != nil {
+		log.DefaultLogger.Error("checkBounces: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// fetchDeliveryFailures lists the failed deliveries, to the address given or to anyone
+func (server *HttpServer) fetchDeliveryFailures(rw http.ResponseWriter, request *http.Request) {
+	offset, limit := deliveryPageParams(request)
+
+	page, err := server.db.GetDeliveryFailures(request.URL.Query().Get("address"), offset, limit)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDeliveryFailures: db.GetDeliveryFailures(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(page)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDeliveryFailures: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// createDeliveryFailures is the webhook an email provider reports bounces to. Failures with a 5xx code or
+// status are permanent, whether or not the provider says so.
+func (server *HttpServer) createDeliveryFailures(rw http.ResponseWriter, request *http.Request) {
+	var failures []dbstore.DeliveryFailure
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createDeliveryFailures: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createDeliveryFailures: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &failures)
+	if err != nil {
+		log.DefaultLogger.Error("createDeliveryFailures: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.DeliveryFailureFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	for i := range failures {
+		err = failures[i].Validate()
+		if err != nil {
+			log.DefaultLogger.Error("createDeliveryFailures: failure.Validate(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			panic(err)
+		}
+		failures[i].Source = dbstore.DeliverySourceWebhook
+		failures[i].Timestamp = 0
+		failures[i].Permanent = failures[i].Permanent || failures[i].Code >= 500 || strings.HasPrefix(failures[i].Status, "5")
+	}
+
+	err = server.db.RecordDeliveryFailures(failures)
+	if err != nil {
+		log.DefaultLogger.Error("createDeliveryFailures: db.RecordDeliveryFailures(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/usersync"
+)
+
+const (
+	defaultDirectoryUserLimit = 50
+	maxDirectoryUserLimit     = 500
+)
+
+type DirectoryUserSyncResult struct {
+	Users int `json:"users"`
+}
+
+func (server *HttpServer) fetchDirectoryUsers(rw http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = defaultDirectoryUserLimit
+	}
+	if limit > maxDirectoryUserLimit {
+		limit = maxDirectoryUserLimit
+	}
+
+	page, err := server.db.SearchDirectoryUsers(query.Get("prefix"), offset, limit)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDirectoryUsers: db.SearchDirectoryUsers(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(page)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDirectoryUsers: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// syncDirectoryUsers pulls the active users from mSupply straight away, rather than waiting for the next sync
+func (server *HttpServer) syncDirectoryUsers(rw http.ResponseWriter, request *http.Request) {
+	users, err := usersync.New(server.db).Sync(request.Context())
+	if err != nil {
+		log.DefaultLogger.Error("syncDirectoryUsers: Sync(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(DirectoryUserSyncResult{Users: users})
+	if err != nil {
+		log.DefaultLogger.Error("syncDirectoryUsers: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/drift"
+)
+
+type RemapArgs struct {
+	ID          string `json:"id"`
+	DashboardID string `json:"dashboardID"`
+	PanelID     int    `json:"panelID"`
+}
+
+func RemapArgsFields() string {
+	return "\n[{\n\tID string\n\t" +
+		"DashboardID string\n\t" +
+		"PanelID int" +
+		"\n}]"
+}
+
+type RemapResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+func (server *HttpServer) fetchReportContentDrift(rw http.ResponseWriter, request *http.Request) {
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentDrift: auth.NewAuthConfig: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	var contents []dbstore.ReportContent
+	if scheduleID := request.URL.Query().Get("schedule-id"); scheduleID != "" {
+		contents, err = server.db.GetReportContent(scheduleID)
+	} else {
+		contents, err = server.db.GetAllReportContent()
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentDrift: db.GetReportContent(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	drifts, err := drift.NewDetector(request.Context(), authConfig).Detect(contents)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentDrift: Detect(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(drifts)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentDrift: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchReportContentSuggestions(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	content, err := server.db.GetReportContentByID(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentSuggestions: db.GetReportContentByID(): " + id + ": " + err.Error())
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		panic(err)
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentSuggestions: auth.NewAuthConfig: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	// Suggestions can come from the dashboard a panel was moved to, rather than the original
+	dashboardID := request.URL.Query().Get("dashboard-id")
+	if dashboardID == "" {
+		dashboardID = content.DashboardID
+	}
+
+	suggestions, err := drift.NewDetector(request.Context(), authConfig).Suggestions(*content, dashboardID)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentSuggestions: Suggestions(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(suggestions)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportContentSuggestions: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) remapReportContent(rw http.ResponseWriter, request *http.Request) {
+	var args []RemapArgs
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("remapReportContent: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("remapReportContent: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &args)
+	if err != nil {
+		log.DefaultLogger.Error("remapReportContent: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, RemapArgsFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("remapReportContent: auth.NewAuthConfig: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	// Each item is remapped on its own so one bad target doesn't stop the rest of the batch
+	detector := drift.NewDetector(request.Context(), authConfig)
+	results := []RemapResult{}
+	for _, arg := range args {
+		result := RemapResult{ID: arg.ID}
+
+		panel, err := detector.Panel(arg.DashboardID, arg.PanelID)
+		if err != nil {
+			result.Error = err.Error()
+		} else if panel == nil {
+			result.Error = fmt.Sprintf("panel %d not found on dashboard %s", arg.PanelID, arg.DashboardID)
+		} else if err := server.checkDashboardAccess(request, arg.DashboardID); err != nil {
+			result.Error = err.Error()
+		} else if before, err := server.db.GetReportContentByID(arg.ID); err != nil {
+			result.Error = err.Error()
+		} else if _, err := server.scheduleAccess(request, before.ScheduleID, false); err != nil {
+			result.Error = err.Error()
+		} else {
+			if err := server.db.RemapReportContent(arg.ID, arg.DashboardID, panel.ID, panel.Title, panel.Type); err != nil {
+				result.Error = err.Error()
+			} else {
+				after, _ := server.db.GetReportContentByID(arg.ID)
+				server.audit(request, dbstore.AuditActionUpdate, auditReportContent, arg.ID, before, after)
+			}
+		}
+
+		if result.Error != "" {
+			log.DefaultLogger.Warn("remapReportContent: " + arg.ID + ": " + result.Error)
+		}
+		results = append(results, result)
+	}
+
+	err = json.NewEncoder(rw).Encode(results)
+	if err != nil {
+		log.DefaultLogger.Error("remapReportContent: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
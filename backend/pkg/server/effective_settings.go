@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// fetchEffectiveSettings returns the settings a schedule's report is generated with and where each came from.
+// Passing a content-id includes that content item's overrides.
+func (server *HttpServer) fetchEffectiveSettings(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	contentID := request.URL.Query().Get("content-id")
+
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("fetchEffectiveSettings: db.GetSettings(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	schedule, err := server.db.GetSchedule(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchEffectiveSettings: db.GetSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		panic(err)
+	}
+
+	var content *dbstore.ReportContent
+	if contentID != "" {
+		content, err = server.db.GetReportContentByID(contentID)
+		if err != nil {
+			log.DefaultLogger.Error("fetchEffectiveSettings: db.GetReportContentByID(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			panic(err)
+		}
+	}
+
+	err = json.NewEncoder(rw).Encode(dbstore.ResolveSettings(settings, *schedule, content))
+	if err != nil {
+		log.DefaultLogger.Error("fetchEffectiveSettings: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
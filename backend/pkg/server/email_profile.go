@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchEmailProfiles(rw http.ResponseWriter, request *http.Request) {
+	profiles, err := server.db.GetEmailProfiles()
+	if err != nil {
+		log.DefaultLogger.Error("fetchEmailProfiles: db.GetEmailProfiles(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(profiles)
+	if err != nil {
+		log.DefaultLogger.Error("fetchEmailProfiles: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// readEmailProfile reads and validates the profile in a request's body, writing the error response if it isn't one
+func readEmailProfile(rw http.ResponseWriter, request *http.Request) (*dbstore.EmailProfile, bool) {
+	var profile dbstore.EmailProfile
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("readEmailProfile: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("readEmailProfile: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &profile)
+	if err != nil {
+		log.DefaultLogger.Error("readEmailProfile: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.EmailProfileFields()).Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	err = profile.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("readEmailProfile: profile.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &profile, true
+}
+
+func (server *HttpServer) createEmailProfile(rw http.ResponseWriter, request *http.Request) {
+	profile, ok := readEmailProfile(rw, request)
+	if !ok {
+		return
+	}
+
+	created, err := server.db.CreateEmailProfile(*profile)
+	if err != nil {
+		log.DefaultLogger.Error("createEmailProfile: db.CreateEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditEmailProfile, created.ID, nil, redactEmailProfile(created))
+
+	created.Password = ""
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createEmailProfile: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) updateEmailProfile(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if id == dbstore.DefaultEmailProfileID {
+		http.Error(rw, "the "+dbstore.DefaultEmailProfileName+" profile is changed in the settings", http.StatusBadRequest)
+		return
+	}
+
+	before, err := server.db.GetEmailProfile(id)
+	if err != nil {
+		log.DefaultLogger.Warn("updateEmailProfile: db.GetEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	profile, ok := readEmailProfile(rw, request)
+	if !ok {
+		return
+	}
+
+	_, err = server.db.UpdateEmailProfile(id, *profile)
+	if err != nil {
+		log.DefaultLogger.Error("updateEmailProfile: db.UpdateEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	updated, err := server.db.GetEmailProfile(id)
+	if err != nil {
+		log.DefaultLogger.Error("updateEmailProfile: db.GetEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditEmailProfile, id, redactEmailProfile(before), redactEmailProfile(updated))
+
+	updated.Password = ""
+	err = json.NewEncoder(rw).Encode(updated)
+	if err != nil {
+		log.DefaultLogger.Error("updateEmailProfile: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteEmailProfile(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if id == dbstore.DefaultEmailProfileID {
+		http.Error(rw, "the "+dbstore.DefaultEmailProfileName+" profile can't be deleted", http.StatusBadRequest)
+		return
+	}
+
+	before, _ := server.db.GetEmailProfile(id)
+
+	err := server.db.DeleteEmailProfile(id)
+	if errors.Is(err, dbstore.ErrEmailProfileInUse) {
+		log.DefaultLogger.Warn("deleteEmailProfile: db.DeleteEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("deleteEmailProfile: db.DeleteEmailProfile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditEmailProfile, id, redactEmailProfile(before), nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
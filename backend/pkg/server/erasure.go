@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// eraseDataSubject purges or anonymizes a person's personal data for a data-protection request,
+// returning what was erased. Only the pseudonym of the person is audited.
+func (server *HttpServer) eraseDataSubject(rw http.ResponseWriter, request *http.Request) {
+	var erasure dbstore.ErasureRequest
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &erasure)
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ErasureRequestFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = erasure.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: erasure.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	report, err := server.db.EraseDataSubject(erasure, server.backupDirectory())
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: db.EraseDataSubject(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	if !report.DryRun {
+		server.audit(request, dbstore.AuditActionErase, auditDataSubject, report.Subject, nil, report)
+	}
+
+	err = json.NewEncoder(rw).Encode(report)
+	if err != nil {
+		log.DefaultLogger.Error("eraseDataSubject: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -1,6 +1,12 @@
 package server
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
 
 type RequestBodyError struct {
 	m string
@@ -14,3 +20,25 @@ func NewRequestBodyError(err error, bodyShouldBe string) *RequestBodyError {
 	m := fmt.Sprintf("Error: %s\nExpecting a body with the shape: %s", err.Error(), bodyShouldBe)
 	return &RequestBodyError{m: m}
 }
+
+// FieldErrorsResponse is the body of a request rejected for the values of particular fields, so the frontend can
+// show each message against its field
+type FieldErrorsResponse struct {
+	Message string              `json:"message"`
+	Fields  dbstore.FieldErrors `json:"fields"`
+}
+
+func writeFieldErrors(rw http.ResponseWriter, errs dbstore.FieldErrors) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(rw).Encode(FieldErrorsResponse{Message: errs.Error(), Fields: errs})
+}
+
+// checkEmailAddresses rejects the request with the fields' errors when any address isn't valid
+func checkEmailAddresses(rw http.ResponseWriter, request *http.Request, addresses map[string]string, verifyDomains bool) error {
+	err := dbstore.CheckEmailAddresses(request.Context(), addresses, verifyDomains)
+	if errs, ok := err.(dbstore.FieldErrors); ok {
+		writeFieldErrors(rw, errs)
+	}
+	return err
+}
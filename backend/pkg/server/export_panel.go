@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
 	"github.com/grafana/simple-datasource-backend/pkg/reporter"
 )
 
+// How long the link to an exported panel lasts, as it's downloaded as soon as it's exported
+const exportLinkHours = 1
+
 type ExportPanelArgs struct {
 	DashboardID string `json:"dashboardID"`
 	PanelID     int    `json:"panelID"`
@@ -64,16 +70,56 @@ func (server *HttpServer) exportPanel(rw http.ResponseWriter, request *http.Requ
 		panic(err)
 	}
 
+	masker, err := server.db.Masker()
+	if err != nil {
+		log.DefaultLogger.Error("exportPanel: db.Masker: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	// Written somewhere of its own, and downloaded from a share link to a copy of it
+	directory, err := ioutil.TempDir("", "export-panel")
+	if err != nil {
+		log.DefaultLogger.Error("exportPanel: ioutil.TempDir: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	options := reporter.DefaultOptions()
+	options.Masker = masker
+	options.Directory = directory
+
 	templatePath := reporter.GetFilePath("template")
 	reporter := reporter.NewReporter(templatePath)
+	reporter.SetOptions(options)
 
-	url, err := reporter.ExportPanel(authConfig, settings.DatasourceID, args.DashboardID, args.PanelID, args.Query, args.Title)
+	name, err := reporter.ExportPanel(request.Context(), authConfig, settings.DatasourceID, args.DashboardID, args.PanelID, args.Query, args.Title)
 	if err != nil {
 		log.DefaultLogger.Error("exportPanel: reporter.SaveReport: ", err.Error())
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
 
-	fmt.Fprint(rw, url)
+	copied, err := server.db.ShareFile(filepath.Join(directory, name))
+	if err != nil {
+		log.DefaultLogger.Error("exportPanel: db.ShareFile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	link, err := server.db.CreateShareLink(copied, exportLinkHours, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("exportPanel: db.CreateShareLink(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	shared, err := server.sharedLinks([]dbstore.ShareLink{*link})
+	if err != nil {
+		log.DefaultLogger.Error("exportPanel: sharedLinks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	fmt.Fprint(rw, shared[0].URL)
 	rw.WriteHeader(http.StatusOK)
 }
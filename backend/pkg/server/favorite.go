@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Favorites, recent items and calendar feed tokens are the user's own, so viewers can keep them and read-only mode
+// doesn't block them
+var personalPaths = []string{"/favorite/", "/recent/", "/calendar/feed-token"}
+
+// authorizeItem checks an item to star or record as used exists and the user can see it, writing the error
+// response if not
+func (server *HttpServer) authorizeItem(rw http.ResponseWriter, request *http.Request, kind string, itemID string) bool {
+	switch kind {
+	case dbstore.ItemKindSchedule:
+		if _, err := server.db.GetSchedule(itemID); err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return false
+		}
+		return true
+	case dbstore.ItemKindReportGroup:
+		if _, err := server.db.GetReportGroup(itemID); err != nil {
+			http.Error(rw, err.Error(), http.StatusNotFound)
+			return false
+		}
+		return server.authorizeReportGroup(rw, request, server.newGroupAccess(request), itemID, dbstore.GroupPermissionView)
+	}
+
+	http.Error(rw, "kind must be one of: "+dbstore.ItemKindSchedule+", "+dbstore.ItemKindReportGroup, http.StatusBadRequest)
+	return false
+}
+
+// visibleItems drops report groups the user can no longer view, e.g. after a grant was taken away
+func (server *HttpServer) visibleItems(request *http.Request, items []dbstore.UserItem) ([]dbstore.UserItem, error) {
+	access := server.newGroupAccess(request)
+	visible := []dbstore.UserItem{}
+	for _, item := range items {
+		if item.Kind == dbstore.ItemKindReportGroup {
+			permission, err := access.permission(item.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			if !dbstore.GroupPermissionIncludes(permission, dbstore.GroupPermissionView) {
+				continue
+			}
+		}
+		visible = append(visible, item)
+	}
+	return visible, nil
+}
+
+// recordRecentItem notes the user used an item, which doesn't fail the request it's part of
+func (server *HttpServer) recordRecentItem(request *http.Request, kind string, itemID string) {
+	if err := server.db.RecordRecentItem(actor(request), kind, itemID); err != nil {
+		log.DefaultLogger.Warn("recordRecentItem: db.RecordRecentItem(): " + err.Error())
+	}
+}
+
+func (server *HttpServer) fetchFavorites(rw http.ResponseWriter, request *http.Request) {
+	favorites, err := server.db.GetFavorites(actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("fetchFavorites: db.GetFavorites(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	favorites, err = server.visibleItems(request, favorites)
+	if err != nil {
+		log.DefaultLogger.Error("fetchFavorites: visibleItems(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(favorites)
+	if err != nil {
+		log.DefaultLogger.Error("fetchFavorites: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createFavorite(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	kind := vars["kind"]
+	id := vars["id"]
+
+	if !server.authorizeItem(rw, request, kind, id) {
+		return
+	}
+
+	err := server.db.AddFavorite(actor(request), kind, id)
+	if err != nil {
+		log.DefaultLogger.Error("createFavorite: db.AddFavorite(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteFavorite(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	err := server.db.DeleteFavorite(actor(request), vars["kind"], vars["id"])
+	if err != nil {
+		log.DefaultLogger.Error("deleteFavorite: db.DeleteFavorite(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchRecentItems(rw http.ResponseWriter, request *http.Request) {
+	items, err := server.db.GetRecentItems(actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("fetchRecentItems: db.GetRecentItems(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	items, err = server.visibleItems(request, items)
+	if err != nil {
+		log.DefaultLogger.Error("fetchRecentItems: visibleItems(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(items)
+	if err != nil {
+		log.DefaultLogger.Error("fetchRecentItems: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// createRecentItem records the user viewed an item, edits are recorded as they're saved
+func (server *HttpServer) createRecentItem(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	kind := vars["kind"]
+	id := vars["id"]
+
+	if !server.authorizeItem(rw, request, kind, id) {
+		return
+	}
+
+	server.recordRecentItem(request, kind, id)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Comma separated logins of the support staff who can impersonate other organisations and users. They must
+// also be admins of the organisation they make the request from.
+const SuperAdminsEnv = "MSUPPLY_SUPER_ADMINS"
+
+// Headers a super-admin sets to see the plugin as someone else: the organisation, whose database is used
+// when each organisation's data is kept apart, and the user, an admin unless another role is given
+const (
+	impersonateOrgHeader  = "X-Impersonate-Org"
+	impersonateUserHeader = "X-Impersonate-User"
+	impersonateRoleHeader = "X-Impersonate-Role"
+)
+
+type impersonationKey struct{}
+
+// impersonation is who a super-admin is making a request as
+type impersonation struct {
+	By    *backend.User
+	User  *backend.User
+	OrgID int64
+}
+
+func isSuperAdmin(user *backend.User) bool {
+	if user == nil || user.Login == "" || user.Role != RoleAdmin {
+		return false
+	}
+	for _, login := range strings.Split(os.Getenv(SuperAdminsEnv), ",") {
+		if strings.TrimSpace(login) == user.Login {
+			return true
+		}
+	}
+	return false
+}
+
+// impersonate makes the request as the organisation and user in its impersonation headers, which only
+// super-admins can set
+func impersonate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		org := request.Header.Get(impersonateOrgHeader)
+		login := request.Header.Get(impersonateUserHeader)
+		if org == "" && login == "" {
+			next.ServeHTTP(rw, request)
+			return
+		}
+
+		by := httpadapter.UserFromContext(request.Context())
+		if !isSuperAdmin(by) {
+			log.DefaultLogger.Warn("impersonate: " + actor(request) + " isn't a super-admin, refusing to impersonate " + org + "/" + login)
+			http.Error(rw, "Forbidden: only super-admins can impersonate", http.StatusForbidden)
+			return
+		}
+
+		target := impersonation{By: by, User: by, OrgID: httpadapter.PluginConfigFromContext(request.Context()).OrgID}
+		if org != "" {
+			orgID, err := strconv.ParseInt(org, 10, 64)
+			if err != nil || orgID <= 0 {
+				http.Error(rw, impersonateOrgHeader+" must be an organisation ID", http.StatusBadRequest)
+				return
+			}
+			target.OrgID = orgID
+		}
+		if login != "" {
+			role := request.Header.Get(impersonateRoleHeader)
+			if role == "" {
+				role = RoleAdmin
+			}
+			if _, ok := roleLevels[role]; !ok {
+				http.Error(rw, impersonateRoleHeader+" must be one of: Viewer, Editor, Admin", http.StatusBadRequest)
+				return
+			}
+			target.User = &backend.User{Login: login, Role: role}
+		}
+
+		log.DefaultLogger.Info("impersonate: " + by.Login + " as " + target.User.Login + " of organisation " + strconv.FormatInt(target.OrgID, 10) + " for " + request.Method + " " + request.URL.Path)
+		next.ServeHTTP(rw, request.WithContext(context.WithValue(request.Context(), impersonationKey{}, &target)))
+	})
+}
+
+func impersonationFrom(request *http.Request) *impersonation {
+	target, _ := request.Context().Value(impersonationKey{}).(*impersonation)
+	return target
+}
+
+// requestUser is the Grafana user the request is made as, who is being impersonated if there is one
+func requestUser(request *http.Request) *backend.User {
+	if target := impersonationFrom(request); target != nil {
+		return target.User
+	}
+	return httpadapter.UserFromContext(request.Context())
+}
+
+// requestOrgID is the Grafana organisation the request is made as
+func requestOrgID(request *http.Request) int64 {
+	if target := impersonationFrom(request); target != nil {
+		return target.OrgID
+	}
+	return httpadapter.PluginConfigFromContext(request.Context()).OrgID
+}
+
+// auditImpersonation records every impersonated request in the audit log of the organisation it is made as,
+// including those which only read
+func (server *HttpServer) auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		if target := impersonationFrom(request); target != nil {
+			server.audit(request, dbstore.AuditActionImpersonate, auditRequest, request.Method+" "+request.URL.RequestURI(), nil, map[string]interface{}{"orgID": target.OrgID, "user": target.User.Login, "role": target.User.Role})
+		}
+		next.ServeHTTP(rw, request)
+	})
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
+	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
+)
+
+// fetchJobs lists the reports being generated and those queued or spread out waiting their turn, with the most
+// which can be generated at the same time and the jobs which finished recently. Those who aren't admins only see
+// the jobs of the schedules they can see and those they asked for, with their place in the whole queue.
+func (server *HttpServer) fetchJobs(rw http.ResponseWriter, request *http.Request) {
+	queue, err := reportEmailer.Workers(server.db).Queue()
+	if err != nil {
+		log.DefaultLogger.Error("fetchJobs: Queue(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if !isAdmin(request) {
+		schedules, err := server.visibleSchedules(request)
+		if err != nil {
+			log.DefaultLogger.Error("fetchJobs: visibleSchedules(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		visible := make(map[string]bool)
+		for _, schedule := range schedules {
+			visible[schedule.ID] = true
+		}
+		login := actor(request)
+		canSee := func(job dbstore.Job) bool {
+			return (job.ScheduleID != "" && visible[job.ScheduleID]) || job.RequestedBy == login
+		}
+
+		listed := []jobs.QueuedJob{}
+		for _, job := range queue.Jobs {
+			if canSee(job.Job) {
+				listed = append(listed, job)
+			}
+		}
+		finished := []dbstore.Job{}
+		for _, job := range queue.Finished {
+			if canSee(job) {
+				finished = append(finished, job)
+			}
+		}
+		queue.Jobs, queue.Finished = listed, finished
+	}
+
+	err = json.NewEncoder(rw).Encode(queue)
+	if err != nil {
+		log.DefaultLogger.Error("fetchJobs: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// authorizeJob checks the user can follow or cancel a job, writing the error response if not: those who can
+// change its schedule, and whoever asked for a job which isn't of a schedule
+func (server *HttpServer) authorizeJob(rw http.ResponseWriter, request *http.Request, job *dbstore.Job) bool {
+	if isAdmin(request) {
+		return true
+	}
+	if job.ScheduleID == "" {
+		if job.RequestedBy == actor(request) {
+			return true
+		}
+		http.Error(rw, "Forbidden: job was requested by "+job.RequestedBy, http.StatusForbidden)
+		return false
+	}
+	return server.authorizeSchedule(rw, request, job.ScheduleID, false)
+}
+
+func (server *HttpServer) fetchJob(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	job, err := server.db.GetJob(id)
+	if errors.Is(err, dbstore.ErrJobNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchJob: db.GetJob(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if !server.authorizeJob(rw, request, job) {
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(job)
+	if err != nil {
+		log.DefaultLogger.Error("fetchJob: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// cancelJob cancels a queued job, or stops a running one, by those who can change its schedule. A running job stops
+// rendering the panels it has left and isn't sent. A cancelled scheduled job skips the runs it hadn't sent yet.
+func (server *HttpServer) cancelJob(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	before, err := server.db.GetJob(id)
+	if errors.Is(err, dbstore.ErrJobNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("cancelJob: db.GetJob(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if !server.authorizeJob(rw, request, before) {
+		return
+	}
+
+	job, err := reportEmailer.Workers(server.db).Cancel(id)
+	if errors.Is(err, dbstore.ErrJobFinished) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("cancelJob: Cancel(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	server.audit(request, dbstore.AuditActionCancel, auditJob, id, before, job)
+
+	err = json.NewEncoder(rw).Encode(job)
+	if err != nil {
+		log.DefaultLogger.Error("cancelJob: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// How often a job's progress is checked for changes while it's followed
+const jobEventsInterval = time.Second
+
+// jobFinished is whether a job won't change any more
+func jobFinished(job *dbstore.Job) bool {
+	return job.Status != dbstore.JobStatusQueued && job.Status != dbstore.JobStatusRunning
+}
+
+// fetchJobEvents follows a job as server-sent events, each "progress" event being the job as it is after it changed,
+// with how many of its panels have been rendered and emails sent. The stream ends after the job finishes, or when
+// the request is closed.
+func (server *HttpServer) fetchJobEvents(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	job, err := server.db.GetJob(id)
+	if errors.Is(err, dbstore.ErrJobNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchJobEvents: db.GetJob(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if !server.authorizeJob(rw, request, job) {
+		return
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(jobEventsInterval)
+	defer ticker.Stop()
+
+	var sent []byte
+	for {
+		data, err := json.Marshal(job)
+		if err != nil {
+			log.DefaultLogger.Error("fetchJobEvents: json.Marshal(): " + err.Error())
+			return
+		}
+		if !bytes.Equal(data, sent) {
+			if _, err := fmt.Fprintf(rw, "event: progress\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+			sent = data
+		}
+		if jobFinished(job) {
+			return
+		}
+
+		select {
+		case <-request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		job, err = server.db.GetJob(id)
+		if err != nil {
+			// Deleted once it had finished long enough ago, or the database couldn't be read
+			log.DefaultLogger.Warn("fetchJobEvents: db.GetJob(): " + err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
+)
+
+func createJob(t *testing.T, server *HttpServer, scheduleID string, requestedBy string) *dbstore.Job {
+	t.Helper()
+	// Far in the future, so it stays queued
+	job, err := server.db.CreateJob(dbstore.Job{Kind: jobs.KindTest, ScheduleID: scheduleID, RequestedBy: requestedBy, StartAt: 1 << 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobsOnlyForThoseWhoCanChangeTheirSchedule(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	job := createJob(t, server, schedule.ID, alice.Login)
+
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/jobs/"+job.ID, nil).Status, http.StatusOK, "owner following the job")
+	expectStatus(t, callAs(t, server, admin, http.MethodGet, "/jobs/"+job.ID, nil).Status, http.StatusOK, "admin following the job")
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/jobs/"+job.ID, nil).Status, http.StatusForbidden, "another editor following the job")
+	expectStatus(t, callAs(t, server, viewer, http.MethodGet, "/jobs/"+job.ID+"/events", nil).Status, http.StatusForbidden, "a viewer following the job's events")
+	expectStatus(t, callAs(t, server, bob, http.MethodDelete, "/jobs/"+job.ID, nil).Status, http.StatusForbidden, "another editor cancelling the job")
+}
+
+func TestJobListOnlyHasVisibleSchedules(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	job := createJob(t, server, schedule.ID, alice.Login)
+
+	listed := func(user string, response []byte) bool {
+		var queue jobs.Queue
+		if err := json.Unmarshal(response, &queue); err != nil {
+			t.Fatal(err)
+		}
+		for _, queued := range queue.Jobs {
+			if queued.ID == job.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	response := callAs(t, server, alice, http.MethodGet, "/jobs", nil)
+	expectStatus(t, response.Status, http.StatusOK, "owner listing jobs")
+	if !listed(alice.Login, response.Body) {
+		t.Errorf("expected the owner to see their schedule's job")
+	}
+	response = callAs(t, server, bob, http.MethodGet, "/jobs", nil)
+	expectStatus(t, response.Status, http.StatusOK, "another editor listing jobs")
+	if listed(bob.Login, response.Body) {
+		t.Errorf("expected another editor not to see the job of a schedule they can't see")
+	}
+}
+
+func TestReadOnlyOnlyAllowsCancellingJobs(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.db.CreateOrUpdateSettings(dbstore.Settings{ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	schedule := createSchedule(t, server, alice.Login)
+	job := createJob(t, server, schedule.ID, alice.Login)
+
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, "/jobs/"+job.ID, nil).Status, http.StatusOK, "cancelling in read-only mode")
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/jobs/"+job.ID+"/cancel", nil).Status, http.StatusNotFound, "the second cancel route")
+
+	// Routes added under /jobs/ later aren't let through with cancelling
+	allowed := server.readOnly(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {}))
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		recorder := httptest.NewRecorder()
+		allowed.ServeHTTP(recorder, httptest.NewRequest(method, "/jobs/"+job.ID+"/retry", nil))
+		expectStatus(t, recorder.Code, http.StatusServiceUnavailable, method+" under /jobs/ in read-only mode")
+	}
+}
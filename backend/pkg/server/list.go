@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Lists return everything unless a limit is asked for, as the app's pages expect, and at most this many rows when one is
+const maxListLimit = 500
+
+// listOptions reads a list endpoint's search, sort and page from its query, e.g.
+// ?search=stock&sort=-nextReportTime&offset=50&limit=25. A sort prefixed with - or given with order=desc is descending.
+func listOptions(request *http.Request) (dbstore.ListOptions, error) {
+	query := request.URL.Query()
+	options := dbstore.ListOptions{Search: strings.TrimSpace(query.Get("search")), Sort: query.Get("sort")}
+
+	if strings.HasPrefix(options.Sort, "-") {
+		options.Sort = strings.TrimPrefix(options.Sort, "-")
+		options.Desc = true
+	}
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		options.Desc = true
+	default:
+		return options, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	if offset := query.Get("offset"); offset != "" {
+		options.Offset, err = strconv.Atoi(offset)
+		if err != nil || options.Offset < 0 {
+			return options, errors.New("offset must be a whole number of at least 0")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		options.Limit, err = strconv.Atoi(limit)
+		if err != nil || options.Limit < 1 {
+			return options, errors.New("limit must be a whole number of at least 1")
+		}
+		if options.Limit > maxListLimit {
+			options.Limit = maxListLimit
+		}
+	}
+
+	return options, nil
+}
+
+// setTotalCount tells clients how many rows match the search across every page, as lists stay plain arrays
+func setTotalCount(rw http.ResponseWriter, total int) {
+	rw.Header().Set("X-Total-Count", strconv.Itoa(total))
+}
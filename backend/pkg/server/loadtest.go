@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/loadtest"
+)
+
+func (server *HttpServer) runLoadTest(rw http.ResponseWriter, request *http.Request) {
+	var options loadtest.Options
+
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &options)
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, loadtest.OptionsFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = options.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: options.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	result, err := loadtest.New(options).Run(request.Context())
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: Run(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(result)
+	if err != nil {
+		log.DefaultLogger.Error("runLoadTest: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
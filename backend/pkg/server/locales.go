@@ -0,0 +1,21 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/i18n"
+)
+
+// fetchLocales lists the locales reports can be sent in, those with messages in a catalog
+func (server *HttpServer) fetchLocales(rw http.ResponseWriter, request *http.Request) {
+	err := json.NewEncoder(rw).Encode(i18n.Locales())
+	if err != nil {
+		log.DefaultLogger.Error("fetchLocales: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
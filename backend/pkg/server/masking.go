@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchMaskingRules(rw http.ResponseWriter, request *http.Request) {
+	rules, err := server.db.GetMaskingRules()
+	if err != nil {
+		log.DefaultLogger.Error("fetchMaskingRules: db.GetMaskingRules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(rules)
+	if err != nil {
+		log.DefaultLogger.Error("fetchMaskingRules: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createMaskingRule(rw http.ResponseWriter, request *http.Request) {
+	var rule dbstore.MaskingRule
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &rule)
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.MaskingRuleFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: rule.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	created, err := server.db.CreateMaskingRule(rule)
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: db.CreateMaskingRule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditMaskingRule, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createMaskingRule: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteMaskingRule(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	err := server.db.DeleteMaskingRule(id)
+	if err != nil {
+		log.DefaultLogger.Error("deleteMaskingRule: db.DeleteMaskingRule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditMaskingRule, id, dbstore.MaskingRule{ID: id}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+func (server *HttpServer) fetchPlaylists(rw http.ResponseWriter, request *http.Request) {
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("fetchPlaylists: auth.NewAuthConfig(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	playlists, err := api.GetPlaylists(request.Context(), authConfig)
+	if err != nil {
+		log.DefaultLogger.Error("fetchPlaylists: api.GetPlaylists(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(playlists)
+	if err != nil {
+		log.DefaultLogger.Error("fetchPlaylists: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// checkPlaylist checks a schedule's playlist is in Grafana, writing the error response if not. Grafana failing
+// to answer is a bad gateway rather than a bad request, as the playlist may well be there.
+func (server *HttpServer) checkPlaylist(rw http.ResponseWriter, request *http.Request, uid string) bool {
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("checkPlaylist: auth.NewAuthConfig(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	_, err = api.GetPlaylist(request.Context(), authConfig, uid)
+	if errors.Is(err, api.ErrPlaylistNotFound) {
+		http.Error(rw, "playlistUID: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.DefaultLogger.Error("checkPlaylist: api.GetPlaylist(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return false
+	}
+
+	return true
+}
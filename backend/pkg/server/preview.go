@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
+)
+
+// Where previews are downloaded from, through Grafana
+const schedulePath = "/api/plugins/msupplyfoundation-datasource/resources/schedule/"
+
+// previewSchedule renders a schedule's report without sending it, returning links to download the files
+func (server *HttpServer) previewSchedule(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	schedule, err := server.db.GetSchedule(id)
+	if err != nil {
+		log.DefaultLogger.Error("previewSchedule: db.GetSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("previewSchedule: auth.NewAuthConfig(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("previewSchedule: db.GetSettings(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	re := reportEmailer.NewReportEmailer(server.db)
+	preview, err := re.PreviewReport(request.Context(), *schedule, authConfig, settings.DatasourceID)
+	if err != nil {
+		log.DefaultLogger.Error("previewSchedule: PreviewReport(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := strings.TrimRight(settings.GrafanaURL, "/") + schedulePath + url.PathEscape(schedule.ID) + "/preview/" + preview.ID + "/"
+	for _, file := range preview.Files {
+		preview.Links = append(preview.Links, base+url.PathEscape(file))
+	}
+
+	err = json.NewEncoder(rw).Encode(preview)
+	if err != nil {
+		log.DefaultLogger.Error("previewSchedule: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// downloadPreview serves a file of a preview to those who can preview the schedule, until the preview is pruned
+func (server *HttpServer) downloadPreview(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	previewID := vars["preview"]
+	name := vars["file"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+	// Only the preview's own files, by name, rather than a path which could lead anywhere else
+	if _, err := uuid.Parse(previewID); err != nil || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.Error(rw, "there is no such preview file", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(reportEmailer.PreviewDirectory(id, previewID), name))
+	if err != nil {
+		http.Error(rw, "the preview has expired, preview the schedule again", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.DefaultLogger.Error("downloadPreview: file.Stat(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	http.ServeContent(rw, request, name, info.ModTime(), file)
+}
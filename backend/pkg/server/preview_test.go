@@ -0,0 +1,88 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
+)
+
+// withPreviewsDirectory keeps the test's previews in a directory of its own
+func withPreviewsDirectory(t *testing.T) {
+	original := reportEmailer.PreviewsDirectory
+	reportEmailer.PreviewsDirectory = t.TempDir()
+	t.Cleanup(func() { reportEmailer.PreviewsDirectory = original })
+}
+
+func writePreview(t *testing.T, scheduleID string, name string, content string) string {
+	t.Helper()
+	previewID := uuid.New().String()
+	directory := reportEmailer.PreviewDirectory(scheduleID, previewID)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeReport(t, directory, name, content)
+	return previewID
+}
+
+func TestPreviewsAreOnlyDownloadedByThoseWhoCanPreview(t *testing.T) {
+	withPreviewsDirectory(t)
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	previewID := writePreview(t, schedule.ID, "Stock (preview).xlsx", "March stock")
+	path := "/schedule/" + schedule.ID + "/preview/" + previewID + "/"
+
+	response := callAs(t, server, alice, http.MethodGet, path+"Stock%20(preview).xlsx", nil)
+	expectStatus(t, response.Status, http.StatusOK, "owner downloading their preview")
+	if string(response.Body) != "March stock" {
+		t.Errorf("expected the preview's file, got %q", string(response.Body))
+	}
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, path+"Stock%20(preview).xlsx", nil).Status, http.StatusForbidden, "another editor downloading the preview")
+	other := createSchedule(t, server, bob.Login)
+	otherPreviewID := writePreview(t, other.ID, "Stock (preview).xlsx", "Bob's stock")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/preview/"+otherPreviewID+"/Stock%20(preview).xlsx", nil).Status, http.StatusNotFound, "another schedule's preview through their own")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/preview/latest/Stock%20(preview).xlsx", nil).Status, http.StatusNotFound, "a preview that isn't one")
+}
+
+func TestOldPreviewsArePruned(t *testing.T) {
+	withPreviewsDirectory(t)
+	kept := writePreview(t, "schedule", "Stock (preview).xlsx", "April stock")
+	pruned := writePreview(t, "schedule", "Stock (preview).xlsx", "March stock")
+	old := time.Now().Add(-2 * reportEmailer.PreviewLifetime)
+	if err := os.Chtimes(reportEmailer.PreviewDirectory("schedule", pruned), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	reportEmailer.PrunePreviews(time.Now())
+
+	if _, err := ioutil.ReadDir(reportEmailer.PreviewDirectory("schedule", kept)); err != nil {
+		t.Errorf("expected the recent preview to be kept, got %v", err)
+	}
+	if _, err := os.Stat(reportEmailer.PreviewDirectory("schedule", pruned)); !os.IsNotExist(err) {
+		t.Errorf("expected the old preview to be deleted, got %v", err)
+	}
+}
+
+func TestReadOnlyOnlyAllowsPreviewingSchedules(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.db.CreateOrUpdateSettings(dbstore.Settings{ReadOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := server.readOnly(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {}))
+	for path, want := range map[string]int{
+		"/schedule/1/preview":     http.StatusOK,
+		"/report-group/1/preview": http.StatusServiceUnavailable,
+		"/schedule/1/x/preview":   http.StatusServiceUnavailable,
+	} {
+		recorder := httptest.NewRecorder()
+		allowed.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+		expectStatus(t, recorder.Code, want, "POST "+path+" in read-only mode")
+	}
+}
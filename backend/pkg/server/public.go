@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bugsnag/bugsnag-go"
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Who the audit log records as making changes through public links, as there's no Grafana user
+const publicActor = "public link"
+
+type publicLinkKey struct{}
+
+// fromPublicLink is whether the request came through the public links rather than Grafana
+func fromPublicLink(request *http.Request) bool {
+	public, _ := request.Context().Value(publicLinkKey{}).(bool)
+	return public
+}
+
+// publicHandler serves the links in reports' emails to recipients who aren't signed in to Grafana. Nothing
+// about the request is trusted but the signature in its link, which is checked against the secrets of the
+// organisation the link names.
+type publicHandler struct {
+	db      *dbstore.SQLiteDatasource
+	mutex   sync.Mutex
+	routers map[*dbstore.SQLiteDatasource]http.Handler
+}
+
+func NewPublicHandler(sqliteDatasource *dbstore.SQLiteDatasource) http.Handler {
+	return &publicHandler{db: sqliteDatasource, routers: make(map[*dbstore.SQLiteDatasource]http.Handler)}
+}
+
+func (handler *publicHandler) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	// Recorded as the public link's doing, whatever the request says about who made it
+	request = request.WithContext(context.WithValue(request.Context(), publicLinkKey{}, true))
+
+	tenant := handler.tenant(request.URL.Query().Get("org"))
+	if tenant == nil {
+		http.Error(rw, "this link is not valid", http.StatusNotFound)
+		return
+	}
+
+	handler.mutex.Lock()
+	router, ok := handler.routers[tenant]
+	if !ok {
+		router = NewServer(tenant).publicRouter()
+		handler.routers[tenant] = router
+	}
+	handler.mutex.Unlock()
+
+	router.ServeHTTP(rw, request)
+}
+
+// tenant is the database of the organisation a link names, only ever one which already exists so links can't
+// create them. Links without one are from before organisations were kept apart.
+func (handler *publicHandler) tenant(org string) *dbstore.SQLiteDatasource {
+	if org == "" {
+		return handler.db
+	}
+	orgID, err := strconv.ParseInt(org, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	tenants, err := handler.db.Tenants()
+	if err != nil {
+		log.DefaultLogger.Error("publicHandler.tenant: db.Tenants(): " + err.Error())
+		return nil
+	}
+	for _, tenant := range tenants {
+		if tenant.OrgID() == orgID {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// publicRouter has only the routes whose signed links are sent outside Grafana, and the calendar feeds read with a
+// user's token, without Grafana's roles
+func (server *HttpServer) publicRouter() http.Handler {
+	mux := mux.NewRouter()
+
+	mux.HandleFunc("/unsubscribe", bugsnag.HandlerFunc(server.unsubscribe)).Methods("GET")
+	mux.HandleFunc("/acknowledge", bugsnag.HandlerFunc(server.confirmAcknowledge)).Methods("GET")
+	mux.HandleFunc("/acknowledge", bugsnag.HandlerFunc(server.acknowledge)).Methods("POST")
+	mux.HandleFunc("/shared/{id}", bugsnag.HandlerFunc(server.downloadShared)).Methods("GET")
+	mux.HandleFunc(publicCalendarFeedPath, bugsnag.HandlerFunc(server.fetchPublicCalendarFeed)).Methods("GET")
+
+	return mux
+}
+
+// ServePublic serves the public links on the address in MSUPPLY_PUBLIC_ADDRESS, blocking until it fails. Without
+// an address they aren't served and recipients need to sign in to Grafana to follow them.
+func ServePublic(sqliteDatasource *dbstore.SQLiteDatasource) {
+	address := os.Getenv(dbstore.PublicAddressEnv)
+	if address == "" {
+		log.DefaultLogger.Info("Not serving public links, as " + dbstore.PublicAddressEnv + " isn't set")
+		return
+	}
+
+	log.DefaultLogger.Info("Serving public links on " + address)
+	public := &http.Server{Addr: address, Handler: NewPublicHandler(sqliteDatasource), ReadHeaderTimeout: 10 * time.Second}
+	if err := public.ListenAndServe(); err != nil {
+		log.DefaultLogger.Error("ServePublic: ListenAndServe(): " + err.Error())
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Grafana org roles, in order of increasing access
+const (
+	RoleViewer = "Viewer"
+	RoleEditor = "Editor"
+	RoleAdmin  = "Admin"
+)
+
+var roleLevels = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Routes which only admins can use at all, as they expose or change the plugin's configuration, or what every
+// schedule costs and how every schedule is doing
+var adminPaths = []string{"/settings", "/contact", "/audit-log", "/chaos", "/clock", "/load-test", "/export", "/import", "/backup", "/data-subject", "/residency-rule", "/masking-rule", "/upgrade", "/validate-all", "/analytics/costs", "/metrics"}
+
+// Routes everyone can read but only admins can change, e.g. the email profiles schedules pick from. Deleting a holiday
+// calendar removes every schedule's blackouts using it, so calendars are kept by admins too.
+var adminWritePaths = []string{"/email-profile", "/branding", "/holiday-calendar"}
+
+// Routes whose method doesn't reflect whether they change anything: sending a test email
+// needs an editor, while exporting a panel only reads it. The upcoming runs are of every schedule, so are for admins,
+// and the share links list carries working URLs, so is for those who can make them. Acknowledging is allowed by
+// the link's signature, whoever follows it.
+var pathRoles = map[string]string{"/test-email": RoleEditor, "/export-panel": RoleViewer, "/trash": RoleEditor, "/schedule/upcoming": RoleAdmin, "/share-link": RoleEditor, "/acknowledge": RoleViewer}
+
+// requiredRole is the minimum role for a request: viewers can read, editors can manage
+// schedules, report groups and content, and admins can manage the settings
+func requiredRole(request *http.Request) string {
+	for _, path := range adminPaths {
+		if request.URL.Path == path || strings.HasPrefix(request.URL.Path, path+"/") {
+			return RoleAdmin
+		}
+	}
+
+	for _, path := range adminWritePaths {
+		isPath := request.URL.Path == path || strings.HasPrefix(request.URL.Path, path+"/")
+		if isPath && request.Method != http.MethodGet && request.Method != http.MethodHead && request.Method != http.MethodOptions {
+			return RoleAdmin
+		}
+	}
+
+	if role, ok := pathRoles[request.URL.Path]; ok {
+		return role
+	}
+	for _, path := range personalPaths {
+		if strings.HasPrefix(request.URL.Path, path) {
+			return RoleViewer
+		}
+	}
+
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleViewer
+	default:
+		return RoleEditor
+	}
+}
+
+// authorize enforces the role of the Grafana user forwarded with each resource call
+func (server *HttpServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		user := requestUser(request)
+		if user == nil {
+			log.DefaultLogger.Warn("authorize: no user forwarded by Grafana for " + request.Method + " " + request.URL.Path)
+			http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		required := requiredRole(request)
+		if roleLevels[user.Role] < roleLevels[required] {
+			log.DefaultLogger.Warn("authorize: " + user.Login + " (" + user.Role + ") needs " + required + " for " + request.Method + " " + request.URL.Path)
+			http.Error(rw, "Forbidden: requires the "+required+" role", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(rw, request)
+	})
+}
+
+// visibleSchedules are the schedules the user can see the details of: every schedule for admins, otherwise those
+// they own, share or their team owns, as authorizeSchedule allows
+func (server *HttpServer) visibleSchedules(request *http.Request) ([]dbstore.Schedule, error) {
+	if isAdmin(request) {
+		return server.db.GetSchedules()
+	}
+	schedules, _, err := server.db.GetSchedulesFor(actor(request), server.userTeams(request), dbstore.ListOptions{})
+	return schedules, err
+}
+
+func isAdmin(request *http.Request) bool {
+	user := requestUser(request)
+	return user != nil && roleLevels[user.Role] >= roleLevels[RoleAdmin]
+}
+
+// authorizeSchedule checks the user can change a schedule and its content, writing the error response if not.
+// Admins can change any schedule and editors only those they own, their team owns or which have been shared with them.
+// Schedules created before they had owners can be changed by any editor. Only the owner or an admin can
+// change who a schedule is shared with or which team owns it.
+func (server *HttpServer) authorizeSchedule(rw http.ResponseWriter, request *http.Request, scheduleID string, sharing bool) bool {
+	if status, err := server.scheduleAccess(request, scheduleID, sharing); err != nil {
+		http.Error(rw, err.Error(), status)
+		return false
+	}
+	return true
+}
+
+// authorizeScheduleReports checks the user can see what a schedule's reports hold, its runs' data and files, writing
+// the error response if not. They need to be able to change the schedule, and to view its report group.
+func (server *HttpServer) authorizeScheduleReports(rw http.ResponseWriter, request *http.Request, scheduleID string) bool {
+	if !server.authorizeSchedule(rw, request, scheduleID, false) {
+		return false
+	}
+
+	schedule, err := server.db.GetSchedule(scheduleID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if schedule.ReportGroupID == "" {
+		return true
+	}
+	return server.authorizeReportGroup(rw, request, server.newGroupAccess(request), schedule.ReportGroupID, dbstore.GroupPermissionView)
+}
+
+// scheduleAccess is authorizeSchedule for requests changing several schedules' items at once, each of which is
+// allowed or refused on its own. It returns the status to refuse the change with and why, nil if it's allowed.
+func (server *HttpServer) scheduleAccess(request *http.Request, scheduleID string, sharing bool) (int, error) {
+	if isAdmin(request) {
+		return http.StatusOK, nil
+	}
+
+	schedule, err := server.db.GetSchedule(scheduleID)
+	if err != nil {
+		return http.StatusNotFound, err
+	}
+
+	login := actor(request)
+	if schedule.Owner == "" || schedule.Owner == login {
+		return http.StatusOK, nil
+	}
+
+	if !sharing {
+		if schedule.OwnerTeam != "" && isInTeam(server.userTeams(request), schedule.OwnerTeam) {
+			return http.StatusOK, nil
+		}
+
+		shared, err := server.db.IsScheduleSharedWith(scheduleID, login)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if shared {
+			return http.StatusOK, nil
+		}
+	}
+
+	log.DefaultLogger.Warn("authorizeSchedule: " + login + " can't change schedule " + scheduleID + " owned by " + schedule.Owner)
+	return http.StatusForbidden, errors.New("Forbidden: schedule is owned by " + schedule.Owner)
+}
+
+// authorizeReportTemplate checks the user can change a template, writing the error response if not and returning it
+// as it is if so. Admins can change any template and editors those they created. Templates created before they had
+// owners can be changed by any editor.
+func (server *HttpServer) authorizeReportTemplate(rw http.ResponseWriter, request *http.Request, templateID string) (*dbstore.ReportTemplate, bool) {
+	template, err := server.db.GetReportTemplate(templateID)
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.DefaultLogger.Error("authorizeReportTemplate: db.GetReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	login := actor(request)
+	if isAdmin(request) || template.Owner == "" || template.Owner == login {
+		return template, true
+	}
+
+	log.DefaultLogger.Warn("authorizeReportTemplate: " + login + " can't change report template " + templateID + " owned by " + template.Owner)
+	http.Error(rw, "Forbidden: report template is owned by "+template.Owner, http.StatusForbidden)
+	return nil, false
+}
+
+// groupAccess works out a user's permissions on report groups, looking up their Grafana teams at most once and
+// only when a group is owned by or grants anything to a team
+type groupAccess struct {
+	server      *HttpServer
+	request     *http.Request
+	teams       []string
+	teamsLoaded bool
+}
+
+func (server *HttpServer) newGroupAccess(request *http.Request) *groupAccess {
+	return &groupAccess{server: server, request: request}
+}
+
+func (access *groupAccess) userTeams() []string {
+	if !access.teamsLoaded {
+		access.teams = access.server.userTeams(access.request)
+		access.teamsLoaded = true
+	}
+	return access.teams
+}
+
+// permission is the highest permission the user has on a group, which is edit for admins and unrestricted groups.
+// Members of the team which owns a group can edit it.
+func (access *groupAccess) permission(groupID string) (string, error) {
+	if isAdmin(access.request) {
+		return dbstore.GroupPermissionEdit, nil
+	}
+
+	permissions, err := access.server.db.GetReportGroupPermissions(groupID)
+	if err != nil {
+		return "", err
+	}
+
+	if group, err := access.server.db.GetReportGroup(groupID); err == nil && group.OwnerTeam != "" {
+		permissions = append(permissions, dbstore.ReportGroupPermission{ReportGroupID: groupID, GranteeType: dbstore.GranteeTeam, Grantee: group.OwnerTeam, Permission: dbstore.GroupPermissionEdit})
+	}
+
+	login := actor(access.request)
+	has, restricted := dbstore.ReportGroupAccess(permissions, login, nil)
+	if !restricted || has == dbstore.GroupPermissionEdit {
+		return has, nil
+	}
+
+	for _, permission := range permissions {
+		if permission.GranteeType == dbstore.GranteeTeam {
+			has, _ = dbstore.ReportGroupAccess(permissions, login, access.userTeams())
+			break
+		}
+	}
+	return has, nil
+}
+
+// authorizeReportGroup checks the user has a permission on a report group, writing the error response if not.
+// Groups nobody has been granted anything on are open to every user the roles allow.
+func (server *HttpServer) authorizeReportGroup(rw http.ResponseWriter, request *http.Request, access *groupAccess, groupID string, needs string) bool {
+	has, err := access.permission(groupID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	if dbstore.GroupPermissionIncludes(has, needs) {
+		return true
+	}
+
+	log.DefaultLogger.Warn("authorizeReportGroup: " + actor(request) + " needs " + needs + " permission on report group " + groupID)
+	http.Error(rw, "Forbidden: requires the "+needs+" permission on the report group", http.StatusForbidden)
+	return false
+}
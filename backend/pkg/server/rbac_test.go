@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func TestTestEmailNeedsScheduleAccess(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+
+	path := "/test-email?schedule-id=" + schedule.ID + "&to=bob@example.org"
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, path, nil).Status, http.StatusForbidden, "sending someone else's report to yourself")
+
+	// Alice gets as far as her recipients being checked, without a test being queued to send
+	path = "/test-email?schedule-id=" + schedule.ID + "&to=not-an-address"
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, path, nil).Status, http.StatusBadRequest, "testing your own report")
+}
+
+func TestRemapOnlyChangesContentOfSchedulesYouCanChange(t *testing.T) {
+	server := newTestServer(t)
+	withGrafana(t, server)
+	schedule := createSchedule(t, server, "alice")
+	content, err := server.db.CreateReportContent(dbstore.ReportContent{ScheduleID: schedule.ID, DashboardID: "old", PanelID: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remap := func(user *backend.User) RemapResult {
+		t.Helper()
+		body, _ := json.Marshal([]RemapArgs{{ID: content.ID, DashboardID: "stock", PanelID: 1}})
+		response := callAs(t, server, user, http.MethodPost, "/report-content/remap", body)
+		expectStatus(t, response.Status, http.StatusOK, "remapping content")
+		var results []RemapResult
+		if err := json.Unmarshal(response.Body, &results); err != nil || len(results) != 1 {
+			t.Fatalf("expected a result for the content, got %s", response.Body)
+		}
+		return results[0]
+	}
+
+	if result := remap(bob); result.Error == "" {
+		t.Error("expected bob not to remap the content of alice's schedule")
+	}
+	if saved, _ := server.db.GetReportContentByID(content.ID); saved.DashboardID != "old" {
+		t.Errorf("expected the content to be left as it was, got %s", saved.DashboardID)
+	}
+
+	if result := remap(alice); result.Error != "" {
+		t.Errorf("expected alice to remap her own content, got %s", result.Error)
+	}
+	if saved, _ := server.db.GetReportContentByID(content.ID); saved.DashboardID != "stock" || saved.PanelID != 1 {
+		t.Errorf("expected the content to be remapped, got %s %d", saved.DashboardID, saved.PanelID)
+	}
+}
+
+func TestOnlyTeamMembersMoveGroupsBetweenTeams(t *testing.T) {
+	server := newTestServer(t)
+	group, err := server.db.CreateReportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.db.CacheUserTeams("bob", []string{"Stores"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(ownerTeam string) int {
+		t.Helper()
+		group.Name, group.OwnerTeam = "Managers", ownerTeam
+		body, _ := json.Marshal(group)
+		return callAs(t, server, bob, http.MethodPut, "/report-group/"+group.ID, body).Status
+	}
+
+	expectStatus(t, update("Pharmacy"), http.StatusForbidden, "handing a group to a team you're not in")
+	expectStatus(t, update("Stores"), http.StatusOK, "handing a group to your own team")
+	expectStatus(t, update("Stores"), http.StatusOK, "saving the group without changing its team")
+
+	if err := server.db.CacheUserTeams("bob", []string{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Still able to edit it, as it was granted to bob directly
+	if _, err := server.db.SetReportGroupPermission(dbstore.ReportGroupPermission{ReportGroupID: group.ID, GranteeType: dbstore.GranteeUser, Grantee: "bob", Permission: dbstore.GroupPermissionEdit}); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, update(""), http.StatusForbidden, "taking a group from a team you've left")
+}
+
+func TestDataDirectoryIsNotServed(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, path := range []string{"/download/msupply.db", "/download/backups/", "/download/archive/"} {
+		expectStatus(t, callAs(t, server, viewer, http.MethodGet, path, nil).Status, http.StatusNotFound, "downloading "+path)
+		expectStatus(t, callAs(t, server, admin, http.MethodGet, path, nil).Status, http.StatusNotFound, "downloading "+path+" as an admin")
+	}
+}
+
+func TestRunsOnlyReadByThoseWhoCanSeeTheirReports(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+	run, err := server.db.CreateReportRun(schedule.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/report-run?schedule-id="+schedule.ID, nil).Status, http.StatusForbidden, "listing the runs of someone else's schedule")
+	expectStatus(t, callAs(t, server, viewer, http.MethodGet, "/report-run/"+run.ID+"/data", nil).Status, http.StatusForbidden, "reading the data of someone else's run")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/report-run?schedule-id="+schedule.ID, nil).Status, http.StatusOK, "listing the runs of your own schedule")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/report-run/"+run.ID+"/data", nil).Status, http.StatusNotFound, "reading your own run, which archived nothing")
+}
+
+func TestScheduleSharesAndAllSchedulesNeedAccess(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/schedule/"+schedule.ID+"/share", nil).Status, http.StatusForbidden, "listing who someone else's schedule is shared with")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/share", nil).Status, http.StatusOK, "listing who your own schedule is shared with")
+
+	count := func(user *backend.User) int {
+		t.Helper()
+		response := callAs(t, server, user, http.MethodGet, "/schedule?all=true", nil)
+		expectStatus(t, response.Status, http.StatusOK, "listing all schedules")
+		var schedules []dbstore.Schedule
+		if err := json.Unmarshal(response.Body, &schedules); err != nil {
+			t.Fatal(err)
+		}
+		return len(schedules)
+	}
+	if got := count(bob); got != 0 {
+		t.Errorf("expected bob to only see his own schedules, got %d", got)
+	}
+	if got := count(admin); got != 1 {
+		t.Errorf("expected an admin to see every schedule, got %d", got)
+	}
+}
+
+func TestUserHeaderDoesntChangeWhoIsActing(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+
+	request := &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{OrgID: 1, User: bob},
+		Path:          "/schedule/" + schedule.ID + "/share",
+		Method:        http.MethodGet,
+		URL:           "/schedule/" + schedule.ID + "/share",
+		Headers:       map[string][]string{"X-Grafana-User": {"alice"}},
+	}
+	sender := &responseSender{}
+	handler := httpadapter.New(resourcePath(impersonate(server.router())))
+	if err := handler.CallResource(context.Background(), request, sender); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, sender.response.Status, http.StatusForbidden, "claiming to be the owner in a header")
+}
+
+func TestHolidayCalendarsChangedByAdminsAndBlackoutsByOwners(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/holiday-calendar", []byte(`{"name":"Holidays"}`)).Status, http.StatusForbidden, "an editor creating a holiday calendar")
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, "/holiday-calendar/calendar", nil).Status, http.StatusForbidden, "an editor deleting a holiday calendar")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/holiday-calendar", nil).Status, http.StatusOK, "an editor listing holiday calendars")
+
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/schedule/"+schedule.ID+"/blackout", nil).Status, http.StatusForbidden, "listing someone else's schedule's blackouts")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/blackout", nil).Status, http.StatusOK, "listing your own schedule's blackouts")
+}
+
+func TestSLOComplianceNeedsAccessToTheSchedule(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/schedule/"+schedule.ID+"/slo", nil).Status, http.StatusForbidden, "reading someone else's schedule's SLO compliance")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/slo", nil).Status, http.StatusOK, "reading your own schedule's SLO compliance")
+}
+
+func TestCostsOnlyReadByAdmins(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/analytics/costs", nil).Status, http.StatusForbidden, "an editor reading the costs")
+	expectStatus(t, callAs(t, server, admin, http.MethodGet, "/analytics/costs", nil).Status, http.StatusOK, "an admin reading the costs")
+}
+
+func TestMetricsOnlyReadByAdmins(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, callAs(t, server, viewer, http.MethodGet, "/metrics", nil).Status, http.StatusForbidden, "a viewer reading the metrics")
+	expectStatus(t, callAs(t, server, admin, http.MethodGet, "/metrics", nil).Status, http.StatusOK, "an admin reading the metrics")
+}
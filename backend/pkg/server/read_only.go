@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Routes still allowed in read-only mode: the settings so it can be switched off again, exporting a panel, backing
+// up the database or validating everything as they don't change anything, and recipients acknowledging reports
+var readOnlyAllowed = map[string]bool{"/settings": true, "/export-panel": true, "/backup": true, "/validate-all": true, "/acknowledge": true}
+
+// readOnlyRoute is a route with an ID in it, allowed in read-only mode with one method
+type readOnlyRoute struct {
+	method string
+	path   *regexp.Regexp
+}
+
+// Routes with IDs in them which are still allowed in read-only mode, as cancelling a job only stops a delivery
+// and previews are never sent
+var readOnlyAllowedRoutes = []readOnlyRoute{
+	{http.MethodDelete, regexp.MustCompile(`^/jobs/[^/]+$`)},
+	{http.MethodPost, regexp.MustCompile(`^/schedule/[^/]+/preview$`)},
+}
+
+// Routes which deliver something despite their method
+var readOnlyDeliveries = map[string]bool{"/test-email": true}
+
+// readOnly blocks every change and delivery while read-only mode is on in the settings
+func (server *HttpServer) readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		if readOnlyAllowed[request.URL.Path] {
+			next.ServeHTTP(rw, request)
+			return
+		}
+		for _, path := range personalPaths {
+			if strings.HasPrefix(request.URL.Path, path) {
+				next.ServeHTTP(rw, request)
+				return
+			}
+		}
+		for _, route := range readOnlyAllowedRoutes {
+			if request.Method == route.method && route.path.MatchString(request.URL.Path) {
+				next.ServeHTTP(rw, request)
+				return
+			}
+		}
+
+		switch request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if !readOnlyDeliveries[request.URL.Path] {
+				next.ServeHTTP(rw, request)
+				return
+			}
+		}
+
+		settings, err := server.db.GetSettings()
+		if err != nil {
+			log.DefaultLogger.Error("readOnly: db.GetSettings(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if settings.ReadOnly {
+			log.DefaultLogger.Info("readOnly: blocked " + request.Method + " " + request.URL.Path + " by " + actor(request))
+			http.Error(rw, "Read-only mode is on, changes and deliveries are disabled", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(rw, request)
+	})
+}
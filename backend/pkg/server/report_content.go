@@ -1,13 +1,19 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/drift"
 )
 
 func (server *HttpServer) fetchReportContent(rw http.ResponseWriter, request *http.Request) {
@@ -55,12 +61,43 @@ func (server *HttpServer) createReportContent(rw http.ResponseWriter, request *h
 		panic(err)
 	}
 
+	err = reportContent.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createReportContent: reportContent.Validate: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	if !server.authorizeSchedule(rw, request, reportContent.ScheduleID, false) {
+		return
+	}
+
+	// Text content has no dashboard to check
+	if !reportContent.IsText() {
+		err = server.checkDashboardAccess(request, reportContent.DashboardID)
+		if err != nil {
+			log.DefaultLogger.Warn("createReportContent: checkDashboardAccess: " + err.Error())
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		err = server.checkContentVariables(request.Context(), reportContent.DashboardID, reportContent.Variables)
+		if err != nil {
+			log.DefaultLogger.Error("createReportContent: checkContentVariables: " + err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			panic(err)
+		}
+
+		server.fillPanelDetails(request.Context(), &reportContent)
+	}
+
 	result, err := server.db.CreateReportContent(reportContent)
 	if err != nil {
 		log.DefaultLogger.Error("createReportContent: db.CreateReportContent: " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionCreate, auditReportContent, result.ID, nil, result)
 
 	err = json.NewEncoder(rw).Encode(result)
 	if err != nil {
@@ -76,12 +113,18 @@ func (server *HttpServer) deleteReportContent(rw http.ResponseWriter, request *h
 	vars := mux.Vars(request)
 	id := vars["id"]
 
+	before, _ := server.db.GetReportContentByID(id)
+	if before != nil && !server.authorizeSchedule(rw, request, before.ScheduleID, false) {
+		return
+	}
+
 	err := server.db.DeleteReportContent(id)
 	if err != nil {
 		log.DefaultLogger.Error("deleteReportContent: db.DeleteReportContent(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionDelete, auditReportContent, id, before, nil)
 
 	rw.WriteHeader(http.StatusOK)
 
@@ -113,12 +156,52 @@ func (server *HttpServer) updateReportContent(rw http.ResponseWriter, request *h
 		panic(err)
 	}
 
-	reportContent, err := server.db.UpdateReportContent(id, group)
+	err = group.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateReportContent: group.Validate: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	before, _ := server.db.GetReportContentByID(id)
+	if before != nil && !server.authorizeSchedule(rw, request, before.ScheduleID, false) {
+		return
+	}
+	if group.ScheduleID != "" && (before == nil || group.ScheduleID != before.ScheduleID) && !server.authorizeSchedule(rw, request, group.ScheduleID, false) {
+		return
+	}
+
+	// The dashboard can't be changed here, only by remapping the content, nor can content be changed to or from
+	// text. A panel can be switched to its whole dashboard and back, content saved without a type keeping its own.
+	dashboardID, text := group.DashboardID, group.IsText()
+	if before != nil {
+		dashboardID, text = before.DashboardID, before.IsText()
+		if group.Type == "" || text || group.IsText() {
+			group.Type = before.Type
+		}
+	}
+	if !text {
+		err = server.checkContentVariables(request.Context(), dashboardID, group.Variables)
+	}
+	if err != nil {
+		log.DefaultLogger.Error("updateReportContent: checkContentVariables: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	_, err = server.db.UpdateReportContent(id, group)
 	if err != nil {
 		log.DefaultLogger.Error("updateReportContent: db.UpdateReportContent: " + err.Error())
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	reportContent, err := server.db.GetReportContentByID(id)
+	if err != nil {
+		log.DefaultLogger.Error("updateReportContent: db.GetReportContentByID: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditReportContent, id, before, reportContent)
 
 	err = json.NewEncoder(rw).Encode(reportContent)
 	if err != nil {
@@ -129,3 +212,49 @@ func (server *HttpServer) updateReportContent(rw http.ResponseWriter, request *h
 
 	rw.WriteHeader(http.StatusOK)
 }
+
+// checkDashboardAccess checks the user can view the dashboard they're adding content from, as the report is
+// generated with the plugin's own Grafana user which can see every dashboard. Content can't be saved while their
+// access can't be checked, e.g. while Grafana is unavailable or its permissions are unreadable.
+func (server *HttpServer) checkDashboardAccess(request *http.Request, dashboardID string) error {
+	user := requestUser(request)
+	if user == nil {
+		return errors.New("no Grafana user to check can view dashboard " + dashboardID)
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("checkDashboardAccess: auth.NewAuthConfig: " + err.Error())
+		return err
+	}
+
+	err = api.CheckDashboardAccess(request.Context(), authConfig, user.Login, user.Role, dashboardID)
+	if err != nil && !errors.Is(err, api.ErrDashboardForbidden) {
+		log.DefaultLogger.Warn("checkDashboardAccess: could not check " + user.Login + " can view " + dashboardID + ": " + err.Error())
+		return fmt.Errorf("could not check %s can view dashboard %s: %w", user.Login, dashboardID, err)
+	}
+	return err
+}
+
+// fillPanelDetails records the title and type of the panel so it can be found again if the dashboard
+// is changed. Grafana being unavailable shouldn't stop content being added, so failures are only logged.
+func (server *HttpServer) fillPanelDetails(ctx context.Context, reportContent *dbstore.ReportContent) {
+	if reportContent.PanelTitle != "" || reportContent.Type == dbstore.ReportContentTypeDashboard {
+		return
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Warn("fillPanelDetails: auth.NewAuthConfig: " + err.Error())
+		return
+	}
+
+	panel, err := drift.NewDetector(ctx, authConfig).Panel(reportContent.DashboardID, reportContent.PanelID)
+	if err != nil || panel == nil {
+		log.DefaultLogger.Warn("fillPanelDetails: could not find panel details for " + reportContent.DashboardID)
+		return
+	}
+
+	reportContent.PanelTitle = panel.Title
+	reportContent.PanelType = panel.Type
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func updateContentAs(t *testing.T, server *HttpServer, content dbstore.ReportContent) string {
+	t.Helper()
+	body, _ := json.Marshal(content)
+	expectStatus(t, callAs(t, server, alice, http.MethodPut, "/report-content/"+content.ID, body).Status, http.StatusOK, "updating the content")
+	saved, err := server.db.GetReportContentByID(content.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return saved.Type
+}
+
+func TestUpdatingContentSavesItsType(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+	content, err := server.db.CreateReportContent(dbstore.ReportContent{ScheduleID: schedule.ID, DashboardID: "stock", PanelID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content.Type = dbstore.ReportContentTypeDashboard
+	if saved := updateContentAs(t, server, *content); saved != dbstore.ReportContentTypeDashboard {
+		t.Errorf("expected the panel to be switched to its whole dashboard, got %q", saved)
+	}
+
+	// Saved without a type, e.g. by an older client, it keeps its own
+	content.Type = ""
+	if saved := updateContentAs(t, server, *content); saved != dbstore.ReportContentTypeDashboard {
+		t.Errorf("expected the content to stay a whole dashboard, got %q", saved)
+	}
+
+	// It can't be made text, which has no dashboard
+	content.Type = dbstore.ReportContentTypeText
+	content.Text = "Notes"
+	if saved := updateContentAs(t, server, *content); saved != dbstore.ReportContentTypeDashboard {
+		t.Errorf("expected the content not to be made text, got %q", saved)
+	}
+
+	content.Type = dbstore.ReportContentTypePanel
+	content.Text = ""
+	if saved := updateContentAs(t, server, *content); saved != dbstore.ReportContentTypePanel {
+		t.Errorf("expected the content to be switched back to its panel, got %q", saved)
+	}
+}
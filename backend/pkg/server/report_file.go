@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// downloadReport serves a file of a schedule's latest report, until the run's cleanup removes it. Only the
+// schedule's own report directory is served from, to those who can change the schedule and view its report group.
+func (server *HttpServer) downloadReport(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	name := vars["file"]
+
+	if !server.authorizeScheduleReports(rw, request, id) {
+		return
+	}
+	if err := dbstore.CheckReportFile(name); err != nil {
+		http.Error(rw, "there is no such report file", http.StatusNotFound)
+		return
+	}
+	if !server.shareableUnencrypted(rw, id, name) {
+		return
+	}
+
+	file, err := os.Open(filepath.Join(server.db.ReportDirectory(id), name))
+	if err != nil {
+		http.Error(rw, "there is no report file "+name+", it may have been removed", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.Error(rw, "there is no report file "+name, http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	http.ServeContent(rw, request, name, info.ModTime(), file)
+}
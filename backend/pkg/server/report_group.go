@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 
@@ -13,14 +14,65 @@ import (
 func (server *HttpServer) fetchReportGroup(rw http.ResponseWriter, request *http.Request) {
 	var groups []dbstore.ReportGroup
 
-	groups, err := server.db.GetReportGroups()
+	options, err := listOptions(request)
 	if err != nil {
-		log.DefaultLogger.Error("fetchReportGroup: db.GetReportGroups")
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Admins see every group so the database can page them, while other users' pages are cut from the groups they
+	// can view
+	paged := options
+	if !isAdmin(request) {
+		paged.Offset, paged.Limit = 0, 0
+	}
+
+	groups, total, err := server.db.ListReportGroups(paged)
+	if errors.Is(err, dbstore.ErrUnknownSort) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportGroup: db.ListReportGroups(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
 
-	err = json.NewEncoder(rw).Encode(groups)
+	if isAdmin(request) {
+		setTotalCount(rw, total)
+		writeReportGroups(rw, groups)
+		return
+	}
+
+	// Only the groups the user can view, so program teams don't see each other's distribution lists
+	access := server.newGroupAccess(request)
+	visible := []dbstore.ReportGroup{}
+	for _, group := range groups {
+		permission, err := access.permission(group.ID)
+		if err != nil {
+			log.DefaultLogger.Error("fetchReportGroup: access.permission(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		if dbstore.GroupPermissionIncludes(permission, dbstore.GroupPermissionView) {
+			visible = append(visible, group)
+		}
+	}
+
+	setTotalCount(rw, len(visible))
+	if options.Offset >= len(visible) {
+		visible = []dbstore.ReportGroup{}
+	} else {
+		visible = visible[options.Offset:]
+	}
+	if options.Limit > 0 && options.Limit < len(visible) {
+		visible = visible[:options.Limit]
+	}
+	writeReportGroups(rw, visible)
+}
+
+func writeReportGroups(rw http.ResponseWriter, groups []dbstore.ReportGroup) {
+	err := json.NewEncoder(rw).Encode(groups)
 	if err != nil {
 		log.DefaultLogger.Error("fetchReportGroup: json.NewEncoder().Encode()", err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -37,6 +89,7 @@ func (server *HttpServer) createReportGroup(rw http.ResponseWriter, request *htt
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionCreate, auditReportGroup, result.ID, nil, result)
 
 	err = json.NewEncoder(rw).Encode(result)
 	if err != nil {
@@ -52,6 +105,10 @@ func (server *HttpServer) updateReportGroup(rw http.ResponseWriter, request *htt
 	vars := mux.Vars(request)
 	id := vars["id"]
 
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionEdit) {
+		return
+	}
+
 	var group dbstore.ReportGroup
 	requestBody, err := request.GetBody()
 	if err != nil {
@@ -74,14 +131,39 @@ func (server *HttpServer) updateReportGroup(rw http.ResponseWriter, request *htt
 		panic(err)
 	}
 
+	before, _ := server.db.GetReportGroup(id)
+
+	if err := group.Validate(before != nil && before.HasAttachmentPassword); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The team owning a group can edit it, so editors can only hand it to or take it from teams they're in
+	if before != nil && group.OwnerTeam != before.OwnerTeam && !isAdmin(request) {
+		teams := server.userTeams(request)
+		if (before.OwnerTeam != "" && !isInTeam(teams, before.OwnerTeam)) || (group.OwnerTeam != "" && !isInTeam(teams, group.OwnerTeam)) {
+			log.DefaultLogger.Warn("updateReportGroup: " + actor(request) + " can't move report group " + id + " from team " + before.OwnerTeam + " to " + group.OwnerTeam)
+			http.Error(rw, "Forbidden: only admins and members of both teams can change which team owns the report group", http.StatusForbidden)
+			return
+		}
+	}
+
 	_, err = server.db.UpdateReportGroup(id, group)
 	if err != nil {
 		log.DefaultLogger.Error("updateReportGroup: db.UpdateReportGroup: " + err.Error())
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	updated, err := server.db.GetReportGroup(id)
+	if err != nil {
+		log.DefaultLogger.Error("updateReportGroup: db.GetReportGroup: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditReportGroup, id, before, updated)
+	server.recordRecentItem(request, dbstore.ItemKindReportGroup, id)
 
-	err = json.NewEncoder(rw).Encode(group)
+	err = json.NewEncoder(rw).Encode(updated)
 	if err != nil {
 		log.DefaultLogger.Error("updateReportGroup: json.NewEncoder().Encode(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -96,12 +178,19 @@ func (server *HttpServer) deleteReportGroup(rw http.ResponseWriter, request *htt
 	vars := mux.Vars(request)
 	id := vars["id"]
 
-	err := server.db.DeleteReportGroup(id)
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionEdit) {
+		return
+	}
+
+	before, _ := server.db.GetReportGroup(id)
+
+	err := server.db.DeleteReportGroup(id, actor(request))
 	if err != nil {
 		log.DefaultLogger.Error("deleteReportGroup: db.DeleteReportGroup(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionDelete, auditReportGroup, id, before, nil)
 
 	rw.WriteHeader(http.StatusOK)
 
@@ -14,6 +14,10 @@ func (server *HttpServer) fetchReportGroupMembership(rw http.ResponseWriter, req
 	vars := mux.Vars(request)
 	id := vars["group-id"]
 
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionView) {
+		return
+	}
+
 	var assignment []dbstore.ReportGroupMembership
 
 	assignment, err := server.db.GetReportGroupMemberships(id)
@@ -57,12 +61,27 @@ func (server *HttpServer) createReportGroupMembership(rw http.ResponseWriter, re
 		panic(err)
 	}
 
+	access := server.newGroupAccess(request)
+	authorized := make(map[string]bool)
+	for _, member := range membership {
+		if authorized[member.ReportGroupID] {
+			continue
+		}
+		if !server.authorizeReportGroup(rw, request, access, member.ReportGroupID, dbstore.GroupPermissionEdit) {
+			return
+		}
+		authorized[member.ReportGroupID] = true
+	}
+
 	result, err := server.db.CreateReportGroupMembership(membership)
 	if err != nil {
 		log.DefaultLogger.Error("createReportGroupMembership: db.CreateReportGroupMembership: ", err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	for _, member := range result {
+		server.audit(request, dbstore.AuditActionCreate, auditReportGroupMembership, member.ID, nil, member)
+	}
 
 	err = json.NewEncoder(rw).Encode(result)
 	if err != nil {
@@ -78,12 +97,23 @@ func (server *HttpServer) deleteReportGroupMembership(rw http.ResponseWriter, re
 	vars := mux.Vars(request)
 	id := vars["id"]
 
-	err := server.db.DeleteReportGroupMembership(id)
+	before, err := server.db.GetReportGroupMembership(id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), before.ReportGroupID, dbstore.GroupPermissionEdit) {
+		return
+	}
+
+	err = server.db.DeleteReportGroupMembership(id)
 	if err != nil {
 		log.DefaultLogger.Error("deleteReportGroupMembership: db.DeleteReportGroupMembership(): ", err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionDelete, auditReportGroupMembership, id, before, nil)
 
 	rw.WriteHeader(http.StatusOK)
 
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchReportGroupPermissions(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionView) {
+		return
+	}
+
+	permissions, err := server.db.GetReportGroupPermissions(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportGroupPermissions: db.GetReportGroupPermissions(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(permissions)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportGroupPermissions: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// createReportGroupPermission grants a user or team a permission on a group. Only those who can edit the group can
+// change its grants, and an editor restricting an open group is granted edit themselves so they keep access to it.
+func (server *HttpServer) createReportGroupPermission(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionEdit) {
+		return
+	}
+
+	var permission dbstore.ReportGroupPermission
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &permission)
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ReportGroupPermissionFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = permission.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: permission.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	existing, err := server.db.GetReportGroupPermissions(id)
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: db.GetReportGroupPermissions(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	login := actor(request)
+	if len(existing) == 0 && !isAdmin(request) && !(permission.GranteeType == dbstore.GranteeUser && permission.Grantee == login) {
+		own := dbstore.ReportGroupPermission{ReportGroupID: id, GranteeType: dbstore.GranteeUser, Grantee: login, Permission: dbstore.GroupPermissionEdit, GrantedBy: login}
+		created, err := server.db.SetReportGroupPermission(own)
+		if err != nil {
+			log.DefaultLogger.Error("createReportGroupPermission: db.SetReportGroupPermission(own): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		server.audit(request, dbstore.AuditActionCreate, auditReportGroupPermission, id+"/"+created.GranteeType+"/"+created.Grantee, nil, created)
+	}
+
+	var before *dbstore.ReportGroupPermission
+	for i := range existing {
+		if existing[i].GranteeType == permission.GranteeType && existing[i].Grantee == permission.Grantee {
+			before = &existing[i]
+		}
+	}
+
+	permission.ReportGroupID = id
+	permission.GrantedBy = login
+	created, err := server.db.SetReportGroupPermission(permission)
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: db.SetReportGroupPermission(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditReportGroupPermission, id+"/"+created.GranteeType+"/"+created.Grantee, before, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createReportGroupPermission: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteReportGroupPermission(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	granteeType := vars["grantee-type"]
+	grantee := vars["grantee"]
+
+	if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), id, dbstore.GroupPermissionEdit) {
+		return
+	}
+
+	err := server.db.DeleteReportGroupPermission(id, granteeType, grantee)
+	if err != nil {
+		log.DefaultLogger.Error("deleteReportGroupPermission: db.DeleteReportGroupPermission(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditReportGroupPermission, id+"/"+granteeType+"/"+grantee, dbstore.ReportGroupPermission{ReportGroupID: id, GranteeType: granteeType, Grantee: grantee}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/reporter"
+)
+
+// fetchReportRuns lists a schedule's runs, most recent first, to those who can see its reports
+func (server *HttpServer) fetchReportRuns(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	scheduleID := vars["schedule-id"]
+
+	if !server.authorizeScheduleReports(rw, request, scheduleID) {
+		return
+	}
+
+	runs, err := server.db.GetReportRuns(scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportRuns: db.GetReportRuns(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(runs)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportRuns: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// fetchReportRunData returns the JSON artifact archived for a run, for schedules sending their report as JSON, to
+// those who can see the schedule's reports
+func (server *HttpServer) fetchReportRunData(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	// Run IDs are used in the archive's file names, so anything else is rejected before touching the disk
+	if _, err := uuid.Parse(id); err != nil {
+		http.Error(rw, "invalid run id", http.StatusBadRequest)
+		return
+	}
+
+	run, err := server.db.GetReportRun(id)
+	if err != nil {
+		http.Error(rw, "there is no run "+id, http.StatusNotFound)
+		return
+	}
+	if !server.authorizeScheduleReports(rw, request, run.ScheduleID) {
+		return
+	}
+
+	data, err := ioutil.ReadFile(reporter.ArchivePath(server.db.ArchiveDirectory(), id))
+	if os.IsNotExist(err) {
+		http.Error(rw, "no JSON data was archived for this run", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportRunData: ioutil.ReadFile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+id+".json\"")
+	_, err = rw.Write(data)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportRunData: rw.Write(): " + err.Error())
+		panic(err)
+	}
+}
@@ -0,0 +1,333 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// reportTemplateDetail is a template with the schedules using it, so those changing it know what they'll affect
+type reportTemplateDetail struct {
+	dbstore.ReportTemplate
+	Schedules []dbstore.TemplateSchedule `json:"schedules"`
+}
+
+func (server *HttpServer) fetchReportTemplates(rw http.ResponseWriter, request *http.Request) {
+	templates, err := server.db.GetReportTemplates()
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplates: db.GetReportTemplates(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(templates)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplates: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchReportTemplate(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	template, err := server.db.GetReportTemplate(id)
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplate: db.GetReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	schedules, err := server.db.GetTemplateSchedules(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplate: db.GetTemplateSchedules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(reportTemplateDetail{ReportTemplate: *template, Schedules: schedules})
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplate: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// readReportTemplate reads and validates the template in a request's body, writing the error response if it isn't
+// one. Its content is checked like content added to a schedule: the user must be able to view each dashboard, and its
+// variables must be ones the dashboard has.
+func (server *HttpServer) readReportTemplate(rw http.ResponseWriter, request *http.Request) (*dbstore.ReportTemplate, bool) {
+	var template dbstore.ReportTemplate
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("readReportTemplate: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("readReportTemplate: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &template)
+	if err != nil {
+		log.DefaultLogger.Error("readReportTemplate: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ReportTemplateFields()).Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	err = template.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("readReportTemplate: template.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !server.checkTemplateDashboards(rw, request, template.Content) {
+		return nil, false
+	}
+	for i := range template.Content {
+		content := &template.Content[i]
+		if content.IsText() {
+			continue
+		}
+		if err := server.checkContentVariables(request.Context(), content.DashboardID, content.Variables); err != nil {
+			log.DefaultLogger.Error("readReportTemplate: checkContentVariables: " + err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		server.fillPanelDetails(request.Context(), content)
+	}
+
+	return &template, true
+}
+
+// checkTemplateDashboards checks the user can view every dashboard of a template's content, writing the error
+// response if not
+func (server *HttpServer) checkTemplateDashboards(rw http.ResponseWriter, request *http.Request, content []dbstore.ReportContent) bool {
+	for _, item := range content {
+		if item.IsText() {
+			continue
+		}
+		if err := server.checkDashboardAccess(request, item.DashboardID); err != nil {
+			log.DefaultLogger.Warn("checkTemplateDashboards: checkDashboardAccess: " + err.Error())
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+func (server *HttpServer) createReportTemplate(rw http.ResponseWriter, request *http.Request) {
+	template, ok := server.readReportTemplate(rw, request)
+	if !ok {
+		return
+	}
+
+	created, err := server.db.CreateReportTemplate(*template, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("createReportTemplate: db.CreateReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditReportTemplate, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createReportTemplate: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// updateReportTemplate saves a template as its next version, changing the reports of every schedule using it which
+// isn't pinned to an earlier version
+func (server *HttpServer) updateReportTemplate(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	before, ok := server.authorizeReportTemplate(rw, request, id)
+	if !ok {
+		return
+	}
+
+	template, ok := server.readReportTemplate(rw, request)
+	if !ok {
+		return
+	}
+
+	updated, err := server.db.UpdateReportTemplate(id, *template, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("updateReportTemplate: db.UpdateReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditReportTemplate, id, before, updated)
+
+	err = json.NewEncoder(rw).Encode(updated)
+	if err != nil {
+		log.DefaultLogger.Error("updateReportTemplate: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteReportTemplate(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	before, ok := server.authorizeReportTemplate(rw, request, id)
+	if !ok {
+		return
+	}
+
+	err := server.db.DeleteReportTemplate(id)
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, dbstore.ErrReportTemplateInUse) {
+		log.DefaultLogger.Warn("deleteReportTemplate: db.DeleteReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("deleteReportTemplate: db.DeleteReportTemplate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditReportTemplate, id, before, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) fetchReportTemplateVersions(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+
+	versions, err := server.db.GetReportTemplateVersions(id)
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplateVersions: db.GetReportTemplateVersions(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(versions)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplateVersions: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// templateVersion is the version in a request's path, writing the error response if it isn't one
+func templateVersion(rw http.ResponseWriter, request *http.Request) (int, bool) {
+	version, err := strconv.Atoi(mux.Vars(request)["version"])
+	if err != nil || version < 1 {
+		http.Error(rw, "version must be a positive number", http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+func (server *HttpServer) fetchReportTemplateVersion(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+	version, ok := templateVersion(rw, request)
+	if !ok {
+		return
+	}
+
+	info, err := server.db.GetReportTemplateVersion(id, version)
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) || errors.Is(err, dbstore.ErrReportTemplateVersionNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplateVersion: db.GetReportTemplateVersion(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(info)
+	if err != nil {
+		log.DefaultLogger.Error("fetchReportTemplateVersion: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// restoreReportTemplateVersion saves an earlier version of a template as its next one, undoing the changes since
+func (server *HttpServer) restoreReportTemplateVersion(rw http.ResponseWriter, request *http.Request) {
+	id := mux.Vars(request)["id"]
+	version, ok := templateVersion(rw, request)
+	if !ok {
+		return
+	}
+
+	before, ok := server.authorizeReportTemplate(rw, request, id)
+	if !ok {
+		return
+	}
+
+	// The version may use dashboards the user can't view, which they could otherwise send out by restoring it
+	info, err := server.db.GetReportTemplateVersion(id, version)
+	if errors.Is(err, dbstore.ErrReportTemplateVersionNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("restoreReportTemplateVersion: db.GetReportTemplateVersion(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	if !server.checkTemplateDashboards(rw, request, info.Content) {
+		return
+	}
+
+	restored, err := server.db.RestoreReportTemplateVersion(id, version, actor(request))
+	if errors.Is(err, dbstore.ErrReportTemplateNotFound) || errors.Is(err, dbstore.ErrReportTemplateVersionNotFound) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("restoreReportTemplateVersion: db.RestoreReportTemplateVersion(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionRestore, auditReportTemplate, id, before, restored)
+
+	err = json.NewEncoder(rw).Encode(restored)
+	if err != nil {
+		log.DefaultLogger.Error("restoreReportTemplateVersion: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func createTemplateAs(t *testing.T, server *HttpServer, owner string, dashboardID string) *dbstore.ReportTemplate {
+	t.Helper()
+	template := dbstore.ReportTemplate{Name: "Stock", ReportTemplateVersion: dbstore.ReportTemplateVersion{Content: []dbstore.ReportContent{{DashboardID: dashboardID, PanelID: 1, PanelTitle: "Stock"}}}}
+	created, err := server.db.CreateReportTemplate(template, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func TestOnlyOwnersAndAdminsChangeTemplates(t *testing.T) {
+	server := newTestServer(t)
+	withGrafana(t, server)
+
+	body, _ := json.Marshal(dbstore.ReportTemplate{Name: "Stock", ReportTemplateVersion: dbstore.ReportTemplateVersion{Content: []dbstore.ReportContent{{DashboardID: "stock", PanelID: 1, PanelTitle: "Stock"}}}})
+	response := callAs(t, server, alice, http.MethodPost, "/report-template", body)
+	expectStatus(t, response.Status, http.StatusOK, "creating a template")
+	var created dbstore.ReportTemplate
+	if err := json.Unmarshal(response.Body, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Owner != "alice" {
+		t.Errorf("expected the template to be owned by its creator, got %q", created.Owner)
+	}
+
+	path := "/report-template/" + created.ID
+	expectStatus(t, callAs(t, server, bob, http.MethodPut, path, body).Status, http.StatusForbidden, "updating someone else's template")
+	expectStatus(t, callAs(t, server, bob, http.MethodPost, path+"/versions/1/restore", nil).Status, http.StatusForbidden, "restoring someone else's template")
+	expectStatus(t, callAs(t, server, bob, http.MethodDelete, path, nil).Status, http.StatusForbidden, "deleting someone else's template")
+
+	expectStatus(t, callAs(t, server, alice, http.MethodPut, path, body).Status, http.StatusOK, "updating your own template")
+	if template, _ := server.db.GetReportTemplate(created.ID); template.Owner != "alice" {
+		t.Errorf("expected updating the template to keep its owner, got %q", template.Owner)
+	}
+	expectStatus(t, callAs(t, server, admin, http.MethodPut, path, body).Status, http.StatusOK, "an admin updating any template")
+
+	// Templates from before they had owners can still be changed by any editor
+	legacy := createTemplateAs(t, server, "", "stock")
+	expectStatus(t, callAs(t, server, bob, http.MethodPut, "/report-template/"+legacy.ID, body).Status, http.StatusOK, "updating a template without an owner")
+}
+
+func TestRestoringTemplateVersionChecksDashboardAccess(t *testing.T) {
+	server := newTestServer(t)
+	withGrafana(t, server)
+
+	// Bob used to have the payroll dashboard on a template alice now owns
+	template := createTemplateAs(t, server, "alice", "payroll")
+	template.Content[0].DashboardID = "stock"
+	if _, err := server.db.UpdateReportTemplate(template.ID, *template, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/report-template/" + template.ID + "/versions/1/restore"
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, path, nil).Status, http.StatusForbidden, "restoring a version with a dashboard alice can't view")
+	if latest, _ := server.db.GetReportTemplate(template.ID); latest.Content[0].DashboardID != "stock" {
+		t.Error("expected the template not to be restored")
+	}
+
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/report-template/"+template.ID+"/versions/2/restore", nil).Status, http.StatusOK, "restoring a version with dashboards alice can view")
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, path, nil).Status, http.StatusOK, "an admin restoring any version")
+}
+
+func TestTrashedSchedulesDontKeepTemplatesInUse(t *testing.T) {
+	server := newTestServer(t)
+	template := createTemplateAs(t, server, "alice", "stock")
+
+	schedule := createSchedule(t, server, "alice")
+	schedule.TemplateID = template.ID
+	if _, err := server.db.UpdateSchedule(schedule.ID, *schedule); err != nil {
+		t.Fatal(err)
+	}
+	path := "/report-template/" + template.ID
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, path, nil).Status, http.StatusConflict, "deleting a template a schedule uses")
+
+	if err := server.db.DeleteSchedule(schedule.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, path, nil).Status, http.StatusOK, "deleting a template only a trashed schedule uses")
+
+	// Restored, it's sent with its own content rather than failing for want of the template
+	if err := server.db.RestoreFromTrash(dbstore.ItemKindSchedule, schedule.ID); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := server.db.GetSchedule(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.TemplateID != "" {
+		t.Errorf("expected the trashed schedule to stop using the deleted template, got %q", restored.TemplateID)
+	}
+}
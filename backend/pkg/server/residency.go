@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchResidencyRules(rw http.ResponseWriter, request *http.Request) {
+	rules, err := server.db.GetResidencyRules()
+	if err != nil {
+		log.DefaultLogger.Error("fetchResidencyRules: db.GetResidencyRules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(rules)
+	if err != nil {
+		log.DefaultLogger.Error("fetchResidencyRules: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createResidencyRule(rw http.ResponseWriter, request *http.Request) {
+	var rule dbstore.ResidencyRule
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &rule)
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ResidencyRuleFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = rule.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: rule.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	created, err := server.db.CreateResidencyRule(rule)
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: db.CreateResidencyRule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditResidencyRule, created.ID, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createResidencyRule: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteResidencyRule(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	err := server.db.DeleteResidencyRule(id)
+	if err != nil {
+		log.DefaultLogger.Error("deleteResidencyRule: db.DeleteResidencyRule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditResidencyRule, id, dbstore.ResidencyRule{ID: id}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -2,23 +2,42 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
 )
 
 func (server *HttpServer) fetchSchedules(rw http.ResponseWriter, request *http.Request) {
 	var schedules []dbstore.Schedule
+	var total int
 
-	schedules, err := server.db.GetSchedules()
+	options, err := listOptions(request)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only the user's own and shared schedules are listed, unless an admin asks for all of them
+	if request.URL.Query().Get("all") == "true" && isAdmin(request) {
+		schedules, total, err = server.db.ListSchedules(options)
+	} else {
+		schedules, total, err = server.db.GetSchedulesFor(actor(request), server.userTeams(request), options)
+	}
+	if errors.Is(err, dbstore.ErrUnknownSort) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.DefaultLogger.Error("fetchSchedules: db.GetSchedules(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	setTotalCount(rw, total)
 
 	err = json.NewEncoder(rw).Encode(schedules)
 	if err != nil {
@@ -31,12 +50,13 @@ func (server *HttpServer) fetchSchedules(rw http.ResponseWriter, request *http.R
 }
 
 func (server *HttpServer) createSchedule(rw http.ResponseWriter, request *http.Request) {
-	schedule, err := server.db.CreateSchedule()
+	schedule, err := server.db.CreateSchedule(actor(request))
 	if err != nil {
 		log.DefaultLogger.Error("createSchedule: db.CreateSchedule(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionCreate, auditSchedule, schedule.ID, nil, schedule)
 
 	err = json.NewEncoder(rw).Encode(schedule)
 	if err != nil {
@@ -48,16 +68,136 @@ func (server *HttpServer) createSchedule(rw http.ResponseWriter, request *http.R
 	rw.WriteHeader(http.StatusOK)
 }
 
+// cloneSchedule copies a schedule and its report content, for setting up several similar reports. The copy belongs
+// to whoever made it, and is only attached to the original's report group when asked for.
+func (server *HttpServer) cloneSchedule(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	var cloneRequest dbstore.CloneScheduleRequest
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("cloneSchedule: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("cloneSchedule: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	// The body is optional, without one the copy isn't attached to a report group
+	if len(bodyAsBytes) > 0 {
+		err = json.Unmarshal(bodyAsBytes, &cloneRequest)
+		if err != nil {
+			log.DefaultLogger.Error("cloneSchedule: json.Unmarshal: " + err.Error())
+			http.Error(rw, NewRequestBodyError(err, dbstore.CloneScheduleRequestFields()).Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	original, err := server.db.GetSchedule(id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Attaching the copy sends the group's members another report, so it needs the attach permission like any other
+	if cloneRequest.IncludeReportGroup && original.ReportGroupID != "" {
+		if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), original.ReportGroupID, dbstore.GroupPermissionAttach) {
+			return
+		}
+	}
+
+	clone, err := server.db.CloneSchedule(id, actor(request), cloneRequest.IncludeReportGroup)
+	if err != nil {
+		log.DefaultLogger.Error("cloneSchedule: db.CloneSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditSchedule, clone.ID, nil, clone)
+	server.recordRecentItem(request, dbstore.ItemKindSchedule, clone.ID)
+
+	err = json.NewEncoder(rw).Encode(clone)
+	if err != nil {
+		log.DefaultLogger.Error("cloneSchedule: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// enableSchedule lets a schedule disabled for failing over and over be sent again, from when it is next due rather
+// than catching up on the runs it missed while disabled
+func (server *HttpServer) enableSchedule(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	before, err := server.db.GetSchedule(id)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = server.db.EnableSchedule(id)
+	if errors.Is(err, dbstore.ErrScheduleNotDisabled) {
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("enableSchedule: db.EnableSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	scheduler.New(server.db).Advance(*before)
+
+	after, err := server.db.GetSchedule(id)
+	if err != nil {
+		log.DefaultLogger.Error("enableSchedule: db.GetSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionEnable, auditSchedule, id, before, after)
+
+	err = json.NewEncoder(rw).Encode(after)
+	if err != nil {
+		log.DefaultLogger.Error("enableSchedule: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
 func (server *HttpServer) deleteSchedule(rw http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	id := vars["id"]
 
-	err := server.db.DeleteSchedule(id)
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	before, _ := server.db.GetSchedule(id)
+
+	err := server.db.DeleteSchedule(id, actor(request))
 	if err != nil {
 		log.DefaultLogger.Error("deleteSchedule: db.DeleteSchedule(): " + id + " : " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
+	server.audit(request, dbstore.AuditActionDelete, auditSchedule, id, before, nil)
 
 	rw.WriteHeader(http.StatusOK)
 }
@@ -66,6 +206,10 @@ func (server *HttpServer) updateSchedule(rw http.ResponseWriter, request *http.R
 	vars := mux.Vars(request)
 	id := vars["id"]
 
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
 	var schedule dbstore.Schedule
 	requestBody, err := request.GetBody()
 	if err != nil {
@@ -88,6 +232,50 @@ func (server *HttpServer) updateSchedule(rw http.ResponseWriter, request *http.R
 		panic(err)
 	}
 
+	err = schedule.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateSchedule: schedule.Validate: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	if schedule.EmailProfileID != "" {
+		if _, err := server.db.GetEmailProfile(schedule.EmailProfileID); err != nil {
+			log.DefaultLogger.Warn("updateSchedule: db.GetEmailProfile: " + err.Error())
+			http.Error(rw, "emailProfileID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if schedule.TemplateID != "" {
+		if _, err := server.db.GetReportTemplateVersion(schedule.TemplateID, schedule.TemplateVersion); err != nil {
+			log.DefaultLogger.Warn("updateSchedule: db.GetReportTemplateVersion: " + err.Error())
+			http.Error(rw, "templateID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	before, _ := server.db.GetSchedule(id)
+
+	// Handing a schedule to a team is up to its owner, like sharing it
+	if before != nil && schedule.OwnerTeam != before.OwnerTeam && !server.authorizeSchedule(rw, request, id, true) {
+		return
+	}
+
+	// Only a newly chosen playlist is checked, so schedules can still be saved while Grafana is restarting
+	if schedule.PlaylistUID != "" && (before == nil || before.PlaylistUID != schedule.PlaylistUID) {
+		if !server.checkPlaylist(rw, request, schedule.PlaylistUID) {
+			return
+		}
+	}
+
+	// Attaching a schedule sends the group's members its reports, so it needs the attach permission on the group
+	if schedule.ReportGroupID != "" && (before == nil || before.ReportGroupID != schedule.ReportGroupID) {
+		if !server.authorizeReportGroup(rw, request, server.newGroupAccess(request), schedule.ReportGroupID, dbstore.GroupPermissionAttach) {
+			return
+		}
+	}
+
 	_, err = server.db.UpdateSchedule(id, schedule)
 
 	if err != nil {
@@ -95,8 +283,17 @@ func (server *HttpServer) updateSchedule(rw http.ResponseWriter, request *http.R
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	// The schedule as it was saved, rather than as it was sent
+	updated, err := server.db.GetSchedule(id)
+	if err != nil {
+		log.DefaultLogger.Error("updateSchedule: db.GetSchedule: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditSchedule, id, before, updated)
+	server.recordRecentItem(request, dbstore.ItemKindSchedule, id)
 
-	err = json.NewEncoder(rw).Encode(schedule)
+	err = json.NewEncoder(rw).Encode(updated)
 	if err != nil {
 		log.DefaultLogger.Error("updateSchedule: json.NewEncoder().Encode(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// fetchScheduleShares lists who a schedule is shared with, to those who can change it
+func (server *HttpServer) fetchScheduleShares(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	shares, err := server.db.GetScheduleShares(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchScheduleShares: db.GetScheduleShares(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(shares)
+	if err != nil {
+		log.DefaultLogger.Error("fetchScheduleShares: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createScheduleShare(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, true) {
+		return
+	}
+
+	var share dbstore.ScheduleShare
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = json.Unmarshal(bodyAsBytes, &share)
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ScheduleShareFields()).Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	err = share.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: share.Validate(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	share.ScheduleID = id
+	share.SharedBy = actor(request)
+	created, err := server.db.CreateScheduleShare(share)
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: db.CreateScheduleShare(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditScheduleShare, id+"/"+created.Login, nil, created)
+
+	err = json.NewEncoder(rw).Encode(created)
+	if err != nil {
+		log.DefaultLogger.Error("createScheduleShare: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) deleteScheduleShare(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	login := vars["login"]
+
+	if !server.authorizeSchedule(rw, request, id, true) {
+		return
+	}
+
+	err := server.db.DeleteScheduleShare(id, login)
+	if err != nil {
+		log.DefaultLogger.Error("deleteScheduleShare: db.DeleteScheduleShare(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditScheduleShare, id+"/"+login, dbstore.ScheduleShare{ScheduleID: id, Login: login}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
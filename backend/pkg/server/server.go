@@ -2,12 +2,15 @@ package server
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/bugsnag/bugsnag-go"
 	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/simple-datasource-backend/pkg/chaos"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type HttpServer struct {
@@ -18,35 +21,197 @@ func NewServer(sqliteDatasource *dbstore.SQLiteDatasource) *HttpServer {
 	return &HttpServer{db: sqliteDatasource}
 }
 
+// ResourceHandler serves the API as the plugin's resources, so every call goes through Grafana's auth and proxy at
+// /api/plugins/msupplyfoundation-datasource/resources/...
 func (server *HttpServer) ResourceHandler(sqliteDatasource *dbstore.SQLiteDatasource) backend.CallResourceHandler {
+	if dbstore.TenantIsolation() {
+		return httpadapter.New(resourcePath(impersonate(newTenantHandler(sqliteDatasource))))
+	}
 
+	return httpadapter.New(resourcePath(impersonate(server.router())))
+}
+
+// resourcePath drops the trailing slash some clients add to resource paths, e.g. report-group-membership/?group-id=,
+// so they reach the same route and need the same role as without it
+func resourcePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		path := request.URL.Path
+		if len(path) > 1 && strings.HasSuffix(path, "/") {
+			request.URL.Path = "/" + strings.Trim(path, "/")
+			request.URL.RawPath = strings.TrimRight(request.URL.RawPath, "/")
+		}
+
+		next.ServeHTTP(rw, request)
+	})
+}
+
+func (server *HttpServer) router() http.Handler {
 	mux := mux.NewRouter()
+	mux.Use(server.auditImpersonation)
+	mux.Use(server.authorize)
+	mux.Use(server.readOnly)
 
 	mux.HandleFunc("/settings", bugsnag.HandlerFunc(server.updateSettings)).Methods("POST")
 	mux.HandleFunc("/settings", bugsnag.HandlerFunc(server.fetchSettings)).Methods("GET")
+	mux.HandleFunc("/settings/lock", bugsnag.HandlerFunc(server.fetchSettingsLocks)).Methods("GET")
+	mux.HandleFunc("/settings/lock/{setting}", bugsnag.HandlerFunc(server.createSettingsLock)).Methods("POST")
+	mux.HandleFunc("/settings/lock/{setting}", bugsnag.HandlerFunc(server.deleteSettingsLock)).Methods("DELETE")
 
 	mux.HandleFunc("/schedule", bugsnag.HandlerFunc(server.createSchedule)).Methods("POST")
 	mux.HandleFunc("/schedule/{id}", bugsnag.HandlerFunc(server.updateSchedule)).Methods("PUT")
 	mux.HandleFunc("/schedule", bugsnag.HandlerFunc(server.fetchSchedules)).Methods("GET")
+	mux.HandleFunc("/schedule/upcoming", bugsnag.HandlerFunc(server.fetchUpcomingRuns)).Methods("GET")
 	mux.HandleFunc("/schedule/{id}", bugsnag.HandlerFunc(server.deleteSchedule)).Methods("DELETE")
+	mux.HandleFunc("/schedule/{id}/effective-settings", bugsnag.HandlerFunc(server.fetchEffectiveSettings)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/clone", bugsnag.HandlerFunc(server.cloneSchedule)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/enable", bugsnag.HandlerFunc(server.enableSchedule)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/preview", bugsnag.HandlerFunc(server.previewSchedule)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/preview/{preview}/{file}", bugsnag.HandlerFunc(server.downloadPreview)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/report/{file}", bugsnag.HandlerFunc(server.downloadReport)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/slo", bugsnag.HandlerFunc(server.fetchSLOCompliance)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/share", bugsnag.HandlerFunc(server.fetchScheduleShares)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/share", bugsnag.HandlerFunc(server.createScheduleShare)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/share/{login}", bugsnag.HandlerFunc(server.deleteScheduleShare)).Methods("DELETE")
+	mux.HandleFunc("/schedule/{id}/unsubscribe", bugsnag.HandlerFunc(server.fetchUnsubscribes)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/unsubscribe/{address}", bugsnag.HandlerFunc(server.deleteUnsubscribe)).Methods("DELETE")
+	mux.HandleFunc("/schedule/{id}/acknowledgement", bugsnag.HandlerFunc(server.fetchScheduleAcknowledgements)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/comment", bugsnag.HandlerFunc(server.fetchComments)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/comment", bugsnag.HandlerFunc(server.createComment)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/comment/{comment-id}", bugsnag.HandlerFunc(server.deleteComment)).Methods("DELETE")
+	mux.HandleFunc("/schedule/{id}/blackout", bugsnag.HandlerFunc(server.fetchBlackouts)).Methods("GET")
+	mux.HandleFunc("/schedule/{id}/blackout", bugsnag.HandlerFunc(server.createBlackout)).Methods("POST")
+	mux.HandleFunc("/schedule/{id}/blackout/{blackout-id}", bugsnag.HandlerFunc(server.deleteBlackout)).Methods("DELETE")
+
+	mux.HandleFunc("/holiday-calendar", bugsnag.HandlerFunc(server.fetchHolidayCalendars)).Methods("GET")
+	mux.HandleFunc("/holiday-calendar", bugsnag.HandlerFunc(server.saveHolidayCalendar)).Methods("POST")
+	mux.HandleFunc("/holiday-calendar/{id}", bugsnag.HandlerFunc(server.saveHolidayCalendar)).Methods("PUT")
+	mux.HandleFunc("/holiday-calendar/{id}", bugsnag.HandlerFunc(server.deleteHolidayCalendar)).Methods("DELETE")
 
 	mux.HandleFunc("/report-group", bugsnag.HandlerFunc(server.fetchReportGroup)).Methods("GET")
 	mux.HandleFunc("/report-group/{id}", bugsnag.HandlerFunc(server.updateReportGroup)).Methods("PUT")
 	mux.HandleFunc("/report-group", bugsnag.HandlerFunc(server.createReportGroup)).Methods("POST")
 	mux.HandleFunc("/report-group/{id}", bugsnag.HandlerFunc(server.deleteReportGroup)).Methods("DELETE")
+	mux.HandleFunc("/report-group/{id}/permission", bugsnag.HandlerFunc(server.fetchReportGroupPermissions)).Methods("GET")
+	mux.HandleFunc("/report-group/{id}/permission", bugsnag.HandlerFunc(server.createReportGroupPermission)).Methods("POST")
+	mux.HandleFunc("/report-group/{id}/permission/{grantee-type}/{grantee}", bugsnag.HandlerFunc(server.deleteReportGroupPermission)).Methods("DELETE")
 
 	mux.HandleFunc("/report-group-membership", bugsnag.HandlerFunc(server.fetchReportGroupMembership)).Queries("group-id", "{group-id}").Methods("GET")
 	mux.HandleFunc("/report-group-membership", bugsnag.HandlerFunc(server.createReportGroupMembership)).Methods("POST")
 	mux.HandleFunc("/report-group-membership/{id}", bugsnag.HandlerFunc(server.deleteReportGroupMembership)).Methods("DELETE")
 
+	mux.HandleFunc("/team", bugsnag.HandlerFunc(server.fetchTeams)).Methods("GET")
+	mux.HandleFunc("/playlist", bugsnag.HandlerFunc(server.fetchPlaylists)).Methods("GET")
+	mux.HandleFunc("/trash", bugsnag.HandlerFunc(server.fetchTrash)).Methods("GET")
+	mux.HandleFunc("/trash/{kind}/{id}/restore", bugsnag.HandlerFunc(server.restoreTrashItem)).Methods("POST")
+	mux.HandleFunc("/trash/{kind}/{id}", bugsnag.HandlerFunc(server.purgeTrashItem)).Methods("DELETE")
+
+	mux.HandleFunc("/favorite", bugsnag.HandlerFunc(server.fetchFavorites)).Methods("GET")
+	mux.HandleFunc("/favorite/{kind}/{id}", bugsnag.HandlerFunc(server.createFavorite)).Methods("POST")
+	mux.HandleFunc("/favorite/{kind}/{id}", bugsnag.HandlerFunc(server.deleteFavorite)).Methods("DELETE")
+	mux.HandleFunc("/recent", bugsnag.HandlerFunc(server.fetchRecentItems)).Methods("GET")
+	mux.HandleFunc("/recent/{kind}/{id}", bugsnag.HandlerFunc(server.createRecentItem)).Methods("POST")
+
+	mux.HandleFunc("/directory-user", bugsnag.HandlerFunc(server.fetchDirectoryUsers)).Methods("GET")
+	mux.HandleFunc("/directory-user/sync", bugsnag.HandlerFunc(server.syncDirectoryUsers)).Methods("POST")
+
+	mux.HandleFunc("/contact", bugsnag.HandlerFunc(server.fetchContacts)).Methods("GET")
+	mux.HandleFunc("/contact", bugsnag.HandlerFunc(server.createContact)).Methods("POST")
+	mux.HandleFunc("/contact/{id}", bugsnag.HandlerFunc(server.deleteContact)).Methods("DELETE")
+
+	mux.HandleFunc("/unsubscribe", bugsnag.HandlerFunc(server.unsubscribe)).Methods("GET")
+	mux.HandleFunc("/acknowledge", bugsnag.HandlerFunc(server.confirmAcknowledge)).Methods("GET")
+	mux.HandleFunc("/acknowledge", bugsnag.HandlerFunc(server.acknowledge)).Methods("POST")
+	mux.HandleFunc("/acknowledgement", bugsnag.HandlerFunc(server.fetchAcknowledgements)).Methods("GET")
+
+	mux.HandleFunc("/delivery-problem", bugsnag.HandlerFunc(server.fetchDeliveryProblems)).Methods("GET")
+	mux.HandleFunc("/delivery-problem/check", bugsnag.HandlerFunc(server.checkBounces)).Methods("POST")
+	mux.HandleFunc("/delivery-problem/{address}", bugsnag.HandlerFunc(server.deleteDeliveryProblem)).Methods("DELETE")
+	mux.HandleFunc("/delivery-failure", bugsnag.HandlerFunc(server.fetchDeliveryFailures)).Methods("GET")
+	mux.HandleFunc("/delivery-failure", bugsnag.HandlerFunc(server.createDeliveryFailures)).Methods("POST")
+
+	mux.HandleFunc("/report-content/drift", bugsnag.HandlerFunc(server.fetchReportContentDrift)).Methods("GET")
+	mux.HandleFunc("/report-content/remap", bugsnag.HandlerFunc(server.remapReportContent)).Methods("POST")
+	mux.HandleFunc("/report-content/{id}/suggestions", bugsnag.HandlerFunc(server.fetchReportContentSuggestions)).Methods("GET")
 	mux.HandleFunc("/report-content", bugsnag.HandlerFunc(server.fetchReportContent)).Queries("schedule-id", "{schedule-id}").Methods("GET")
 	mux.HandleFunc("/report-content", bugsnag.HandlerFunc(server.createReportContent)).Methods("POST")
 	mux.HandleFunc("/report-content/{id}", bugsnag.HandlerFunc(server.updateReportContent)).Methods("PUT")
 	mux.HandleFunc("/report-content/{id}", bugsnag.HandlerFunc(server.deleteReportContent)).Methods("DELETE")
 
+	mux.HandleFunc("/dashboard/{uid}/variables", bugsnag.HandlerFunc(server.fetchDashboardVariables)).Methods("GET")
+
+	mux.HandleFunc("/report-run", bugsnag.HandlerFunc(server.fetchReportRuns)).Queries("schedule-id", "{schedule-id}").Methods("GET")
+	mux.HandleFunc("/calendar", bugsnag.HandlerFunc(server.fetchCalendar)).Methods("GET")
+	mux.HandleFunc("/calendar.ics", bugsnag.HandlerFunc(server.fetchCalendarFeed)).Methods("GET")
+	mux.HandleFunc("/calendar/feed-token", bugsnag.HandlerFunc(server.createCalendarFeedToken)).Methods("POST")
+	mux.HandleFunc("/calendar/feed-token", bugsnag.HandlerFunc(server.deleteCalendarFeedToken)).Methods("DELETE")
+	mux.HandleFunc("/jobs", bugsnag.HandlerFunc(server.fetchJobs)).Methods("GET")
+	mux.HandleFunc("/jobs/{id}", bugsnag.HandlerFunc(server.fetchJob)).Methods("GET")
+	mux.HandleFunc("/jobs/{id}", bugsnag.HandlerFunc(server.cancelJob)).Methods("DELETE")
+	mux.HandleFunc("/jobs/{id}/events", bugsnag.HandlerFunc(server.fetchJobEvents)).Methods("GET")
+	mux.HandleFunc("/validate-all", bugsnag.HandlerFunc(server.validateAll)).Methods("POST")
+	mux.HandleFunc("/report-run/{id}/data", bugsnag.HandlerFunc(server.fetchReportRunData)).Methods("GET")
+
+	mux.HandleFunc("/audit-log", bugsnag.HandlerFunc(server.fetchAuditLog)).Methods("GET")
+	mux.HandleFunc("/data-subject/erase", bugsnag.HandlerFunc(server.eraseDataSubject)).Methods("POST")
+
+	mux.HandleFunc("/residency-rule", bugsnag.HandlerFunc(server.fetchResidencyRules)).Methods("GET")
+	mux.HandleFunc("/residency-rule", bugsnag.HandlerFunc(server.createResidencyRule)).Methods("POST")
+	mux.HandleFunc("/residency-rule/{id}", bugsnag.HandlerFunc(server.deleteResidencyRule)).Methods("DELETE")
+
+	mux.HandleFunc("/masking-rule", bugsnag.HandlerFunc(server.fetchMaskingRules)).Methods("GET")
+	mux.HandleFunc("/masking-rule", bugsnag.HandlerFunc(server.createMaskingRule)).Methods("POST")
+	mux.HandleFunc("/masking-rule/{id}", bugsnag.HandlerFunc(server.deleteMaskingRule)).Methods("DELETE")
+	mux.HandleFunc("/email-profile", bugsnag.HandlerFunc(server.fetchEmailProfiles)).Methods("GET")
+	mux.HandleFunc("/email-profile", bugsnag.HandlerFunc(server.createEmailProfile)).Methods("POST")
+	mux.HandleFunc("/email-profile/{id}", bugsnag.HandlerFunc(server.updateEmailProfile)).Methods("PUT")
+	mux.HandleFunc("/email-profile/{id}", bugsnag.HandlerFunc(server.deleteEmailProfile)).Methods("DELETE")
+	mux.HandleFunc("/report-template", bugsnag.HandlerFunc(server.fetchReportTemplates)).Methods("GET")
+	mux.HandleFunc("/report-template", bugsnag.HandlerFunc(server.createReportTemplate)).Methods("POST")
+	mux.HandleFunc("/report-template/{id}", bugsnag.HandlerFunc(server.fetchReportTemplate)).Methods("GET")
+	mux.HandleFunc("/report-template/{id}", bugsnag.HandlerFunc(server.updateReportTemplate)).Methods("PUT")
+	mux.HandleFunc("/report-template/{id}", bugsnag.HandlerFunc(server.deleteReportTemplate)).Methods("DELETE")
+	mux.HandleFunc("/report-template/{id}/versions", bugsnag.HandlerFunc(server.fetchReportTemplateVersions)).Methods("GET")
+	mux.HandleFunc("/report-template/{id}/versions/{version}", bugsnag.HandlerFunc(server.fetchReportTemplateVersion)).Methods("GET")
+	mux.HandleFunc("/report-template/{id}/versions/{version}/restore", bugsnag.HandlerFunc(server.restoreReportTemplateVersion)).Methods("POST")
+	mux.HandleFunc("/branding", bugsnag.HandlerFunc(server.fetchBranding)).Methods("GET")
+	mux.HandleFunc("/branding", bugsnag.HandlerFunc(server.updateBranding)).Methods("PUT")
+	mux.HandleFunc("/branding/logo", bugsnag.HandlerFunc(server.fetchBrandingLogo)).Methods("GET")
+	mux.HandleFunc("/branding/logo", bugsnag.HandlerFunc(server.updateBrandingLogo)).Methods("PUT")
+	mux.HandleFunc("/branding/logo", bugsnag.HandlerFunc(server.deleteBrandingLogo)).Methods("DELETE")
+
+	mux.HandleFunc("/locales", bugsnag.HandlerFunc(server.fetchLocales)).Methods("GET")
+
+	mux.HandleFunc("/export", bugsnag.HandlerFunc(server.exportBundle)).Methods("GET")
+	mux.HandleFunc("/import", bugsnag.HandlerFunc(server.importBundle)).Methods("POST")
+
+	mux.HandleFunc("/backup", bugsnag.HandlerFunc(server.fetchBackups)).Methods("GET")
+	mux.HandleFunc("/backup", bugsnag.HandlerFunc(server.createBackup)).Methods("POST")
+	mux.HandleFunc("/backup/restore", bugsnag.HandlerFunc(server.restoreBackup)).Methods("POST")
+	mux.HandleFunc("/upgrade", bugsnag.HandlerFunc(server.fetchUpgrade)).Methods("GET")
+
+	mux.HandleFunc("/analytics/costs", bugsnag.HandlerFunc(server.fetchMonthlyCosts)).Methods("GET")
+
+	mux.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	mux.HandleFunc("/usage/dashboards/{uid}", bugsnag.HandlerFunc(server.fetchDashboardUsage)).Methods("GET")
+
 	mux.HandleFunc("/test-email", bugsnag.HandlerFunc(server.testEmail)).Queries("schedule-id", "{schedule-id}").Methods("GET")
 	mux.HandleFunc("/export-panel", bugsnag.HandlerFunc(server.exportPanel)).Methods("POST")
-	mux.PathPrefix("/download/").Handler(http.StripPrefix("/download/", http.FileServer(http.Dir("../data")))).Methods("GET")
+	mux.HandleFunc("/share-link", bugsnag.HandlerFunc(server.fetchShareLinks)).Methods("GET")
+	mux.HandleFunc("/share-link", bugsnag.HandlerFunc(server.createShareLink)).Methods("POST")
+	mux.HandleFunc("/share-link/{id}", bugsnag.HandlerFunc(server.revokeShareLink)).Methods("DELETE")
+	mux.HandleFunc("/shared/{id}", bugsnag.HandlerFunc(server.downloadShared)).Methods("GET")
+
+	// Failure injection, time travel and load testing for QA, only available when chaos is turned on
+	if chaos.Enabled() {
+		mux.HandleFunc("/chaos", bugsnag.HandlerFunc(server.fetchChaos)).Methods("GET")
+		mux.HandleFunc("/chaos", bugsnag.HandlerFunc(server.updateChaos)).Methods("PUT")
+		mux.HandleFunc("/chaos", bugsnag.HandlerFunc(server.resetChaos)).Methods("DELETE")
+		mux.HandleFunc("/clock", bugsnag.HandlerFunc(server.fetchClock)).Methods("GET")
+		mux.HandleFunc("/clock", bugsnag.HandlerFunc(server.updateClock)).Methods("PUT")
+		mux.HandleFunc("/clock", bugsnag.HandlerFunc(server.resetClock)).Methods("DELETE")
+		mux.HandleFunc("/load-test", bugsnag.HandlerFunc(server.runLoadTest)).Methods("POST")
+	}
 
-	return httpadapter.New(mux)
+	return mux
 }
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Grafana users the tests make requests as
+var (
+	admin  = &backend.User{Login: "admin", Role: RoleAdmin}
+	alice  = &backend.User{Login: "alice", Role: RoleEditor}
+	bob    = &backend.User{Login: "bob", Role: RoleEditor}
+	viewer = &backend.User{Login: "victor", Role: RoleViewer}
+)
+
+// newTestServer is a server with an empty database in a temporary directory
+func newTestServer(t *testing.T) *HttpServer {
+	t.Helper()
+	store := &dbstore.SQLiteDatasource{Path: filepath.Join(t.TempDir(), "msupply.db")}
+	store.Init()
+	return NewServer(store)
+}
+
+// callAs makes a request to the API as Grafana forwards it for the user, nil for none
+func callAs(t *testing.T, server *HttpServer, user *backend.User, method string, path string, body []byte) *backend.CallResourceResponse {
+	t.Helper()
+	request := &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{OrgID: 1, User: user},
+		Path:          strings.SplitN(path, "?", 2)[0],
+		Method:        method,
+		URL:           path,
+		Body:          body,
+	}
+
+	sender := &responseSender{}
+	handler := httpadapter.New(resourcePath(impersonate(server.router())))
+	if err := handler.CallResource(context.Background(), request, sender); err != nil {
+		t.Fatal(err)
+	}
+	return sender.response
+}
+
+// responseSender collects a streamed response into one
+type responseSender struct {
+	response *backend.CallResourceResponse
+}
+
+func (sender *responseSender) Send(response *backend.CallResourceResponse) error {
+	if sender.response == nil {
+		sender.response = response
+		return nil
+	}
+	sender.response.Body = append(sender.response.Body, response.Body...)
+	return nil
+}
+
+// callPublic makes a request to the public links, as someone without a Grafana account
+func callPublic(server *HttpServer, method string, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	NewPublicHandler(server.db).ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+func createSchedule(t *testing.T, server *HttpServer, owner string) *dbstore.Schedule {
+	t.Helper()
+	created, err := server.db.CreateSchedule(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := server.db.GetSchedule(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func expectStatus(t *testing.T, got int, want int, what string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: expected status %d (%s), got %d (%s)", what, want, http.StatusText(want), got, http.StatusText(got))
+	}
+}
+
+// withGrafana points the server at a Grafana where alice (user 2) and bob (user 3) can view the stock dashboard and
+// only bob can view the payroll one. Each has a table panel 1.
+func withGrafana(t *testing.T, server *HttpServer) {
+	t.Helper()
+	viewers := map[string][]int{"stock": {2, 3}, "payroll": {3}}
+	grafana := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/api/org/users/search":
+			json.NewEncoder(rw).Encode(map[string]interface{}{"orgUsers": []map[string]interface{}{
+				{"userId": 1, "login": "admin", "role": RoleAdmin},
+				{"userId": 2, "login": "alice", "role": RoleEditor},
+				{"userId": 3, "login": "bob", "role": RoleEditor},
+			}})
+		case strings.HasPrefix(request.URL.Path, "/api/dashboards/uid/") && strings.HasSuffix(request.URL.Path, "/permissions"):
+			uid := strings.TrimSuffix(strings.TrimPrefix(request.URL.Path, "/api/dashboards/uid/"), "/permissions")
+			permissions := []map[string]int{}
+			for _, userID := range viewers[uid] {
+				permissions = append(permissions, map[string]int{"userId": userID, "permission": 1})
+			}
+			json.NewEncoder(rw).Encode(permissions)
+		case strings.HasPrefix(request.URL.Path, "/api/dashboards/uid/") && viewers[strings.TrimPrefix(request.URL.Path, "/api/dashboards/uid/")] != nil:
+			uid := strings.TrimPrefix(request.URL.Path, "/api/dashboards/uid/")
+			json.NewEncoder(rw).Encode(map[string]interface{}{"dashboard": map[string]interface{}{"uid": uid, "panels": []interface{}{
+				map[string]interface{}{"id": 1, "type": "table", "title": "Stock", "targets": []interface{}{map[string]string{"rawSql": "SELECT * FROM stock"}}},
+			}}})
+		default:
+			http.NotFound(rw, request)
+		}
+	}))
+	t.Cleanup(grafana.Close)
+
+	err := server.db.CreateOrUpdateSettings(dbstore.Settings{GrafanaURL: grafana.URL, GrafanaUsername: "reports", GrafanaPassword: "s3cret", GrafanaAuthMode: dbstore.AuthModeBasic})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
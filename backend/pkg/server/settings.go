@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
@@ -18,7 +19,7 @@ func (server *HttpServer) fetchSettings(rw http.ResponseWriter, request *http.Re
 		panic(err)
 	}
 
-	err = json.NewEncoder(rw).Encode(settings)
+	err = json.NewEncoder(rw).Encode(withoutSigningKey(settings))
 	if err != nil {
 		log.DefaultLogger.Error("fetchSettings: json.NewEncoder().Encode(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -51,14 +52,55 @@ func (server *HttpServer) updateSettings(rw http.ResponseWriter, request *http.R
 		panic(err)
 	}
 
+	before, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("updateSettings: db.GetSettings(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	// The key is never sent back, so it's kept unless a new one is given or signing is switched off
+	if settings.SigningKey == "" && settings.SigningCertificate != "" {
+		settings.SigningKey = before.SigningKey
+	}
+
+	err = settings.Validate()
+	if err != nil {
+		log.DefaultLogger.Error("updateSettings: settings.Validate: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+	err = settings.CheckSigning(before, time.Now())
+	if err != nil {
+		log.DefaultLogger.Error("updateSettings: settings.CheckSigning: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = checkEmailAddresses(rw, request, map[string]string{"email": settings.Email, "adminEmail": settings.AdminEmail, "emailReplyTo": settings.EmailReplyTo}, settings.VerifyEmailDomains)
+	if err != nil {
+		log.DefaultLogger.Error("updateSettings: checkEmailAddresses: " + err.Error())
+		panic(err)
+	}
+
+	if !server.checkSettingsLocks(rw, request, before, &settings) {
+		return
+	}
+
 	err = server.db.CreateOrUpdateSettings(settings)
 	if err != nil {
 		log.DefaultLogger.Error("updateSettings: db.CreateOrUpdateSettings: " + err.Error())
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
+	updated, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("updateSettings: db.GetSettings(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionUpdate, auditSettings, "", redactSettings(before), redactSettings(updated))
 
-	err = json.NewEncoder(rw).Encode(settings)
+	err = json.NewEncoder(rw).Encode(withoutSigningKey(updated))
 	if err != nil {
 		log.DefaultLogger.Error("updateSettings: json.NewEncoder().Encode(): " + err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -67,3 +109,11 @@ func (server *HttpServer) updateSettings(rw http.ResponseWriter, request *http.R
 
 	rw.WriteHeader(http.StatusOK)
 }
+
+// withoutSigningKey is the settings as the API returns them, saying whether there's a signing key but never what it is
+func withoutSigningKey(settings *dbstore.Settings) *dbstore.Settings {
+	returned := *settings
+	returned.HasSigningKey = returned.SigningKey != ""
+	returned.SigningKey = ""
+	return &returned
+}
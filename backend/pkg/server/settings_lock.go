@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// isRequestSuperAdmin is whether the user really making the request, rather than anyone they're impersonating,
+// is a super-admin
+func isRequestSuperAdmin(request *http.Request) bool {
+	return isSuperAdmin(httpadapter.UserFromContext(request.Context()))
+}
+
+// checkSettingsLocks refuses changes to locked settings, writing the error response, unless a super-admin makes them
+func (server *HttpServer) checkSettingsLocks(rw http.ResponseWriter, request *http.Request, before *dbstore.Settings, after *dbstore.Settings) bool {
+	if isRequestSuperAdmin(request) {
+		return true
+	}
+
+	locks, err := server.db.GetSettingsLocks()
+	if err != nil {
+		log.DefaultLogger.Error("checkSettingsLocks: db.GetSettingsLocks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	locked := make(map[string]bool)
+	for _, lock := range locks {
+		locked[lock.Setting] = true
+	}
+
+	var refused []string
+	for _, setting := range dbstore.ChangedSettings(before, after) {
+		if locked[setting] {
+			refused = append(refused, setting)
+		}
+	}
+	if len(refused) == 0 {
+		return true
+	}
+
+	log.DefaultLogger.Warn("checkSettingsLocks: " + actor(request) + " tried to change locked settings: " + strings.Join(refused, ", "))
+	http.Error(rw, "Forbidden: these settings are locked and can only be changed by a super-admin: "+strings.Join(refused, ", "), http.StatusForbidden)
+	return false
+}
+
+func (server *HttpServer) fetchSettingsLocks(rw http.ResponseWriter, request *http.Request) {
+	locks, err := server.db.GetSettingsLocks()
+	if err != nil {
+		log.DefaultLogger.Error("fetchSettingsLocks: db.GetSettingsLocks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(locks)
+	if err != nil {
+		log.DefaultLogger.Error("fetchSettingsLocks: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (server *HttpServer) createSettingsLock(rw http.ResponseWriter, request *http.Request) {
+	setting := mux.Vars(request)["setting"]
+	if !dbstore.IsSettingName(setting) {
+		http.Error(rw, "There is no setting "+setting, http.StatusBadRequest)
+		return
+	}
+
+	lock, err := server.db.LockSetting(setting, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("createSettingsLock: db.LockSetting(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditSettingsLock, setting, nil, lock)
+
+	err = json.NewEncoder(rw).Encode(lock)
+	if err != nil {
+		log.DefaultLogger.Error("createSettingsLock: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteSettingsLock unlocks a setting, which only super-admins can do so local admins can't undo central management
+func (server *HttpServer) deleteSettingsLock(rw http.ResponseWriter, request *http.Request) {
+	setting := mux.Vars(request)["setting"]
+
+	if !isRequestSuperAdmin(request) {
+		log.DefaultLogger.Warn("deleteSettingsLock: " + actor(request) + " isn't a super-admin, refusing to unlock " + setting)
+		http.Error(rw, "Forbidden: only super-admins can unlock settings", http.StatusForbidden)
+		return
+	}
+
+	err := server.db.UnlockSetting(setting)
+	if err != nil {
+		log.DefaultLogger.Error("deleteSettingsLock: db.UnlockSetting(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditSettingsLock, setting, dbstore.SettingsLock{Setting: setting}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// signingPEM is a self-signed S/MIME certificate for the address valid until notAfter, and its key
+func signingPEM(t *testing.T, address string, notAfter time.Time) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{CommonName: "Reports"},
+		EmailAddresses: []string{address},
+		NotBefore:      notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:       notAfter,
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+}
+
+func fetchSettingsAs(t *testing.T, server *HttpServer) dbstore.Settings {
+	t.Helper()
+	response := callAs(t, server, admin, http.MethodGet, "/settings", nil)
+	expectStatus(t, response.Status, http.StatusOK, "fetching the settings")
+	var settings dbstore.Settings
+	if err := json.Unmarshal(response.Body, &settings); err != nil {
+		t.Fatal(err)
+	}
+	return settings
+}
+
+func TestSettingsNeverReturnSigningKey(t *testing.T) {
+	server := newTestServer(t)
+	certificate, key := signingPEM(t, "reports@example.org", time.Now().Add(365*24*time.Hour))
+
+	body, _ := json.Marshal(dbstore.Settings{Email: "reports@example.org", SigningCertificate: certificate, SigningKey: key})
+	response := callAs(t, server, admin, http.MethodPost, "/settings", body)
+	expectStatus(t, response.Status, http.StatusOK, "saving a signing certificate and key")
+	var saved map[string]interface{}
+	if err := json.Unmarshal(response.Body, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved["signingKey"]; ok {
+		t.Error("expected the saved settings not to return the signing key")
+	}
+
+	settings := fetchSettingsAs(t, server)
+	if settings.SigningKey != "" || !settings.HasSigningKey {
+		t.Errorf("expected only whether there's a signing key, got %q, %v", settings.SigningKey, settings.HasSigningKey)
+	}
+
+	// The settings as they were fetched are saved again, keeping the key
+	settings.EmailFromName = "Stock reports"
+	body, _ = json.Marshal(settings)
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, "/settings", body).Status, http.StatusOK, "saving the settings as they were fetched")
+	stored, err := server.db.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SigningKey != key {
+		t.Error("expected the signing key to be kept when it isn't sent")
+	}
+
+	// Clearing the certificate switches signing off, key and all
+	settings.SigningCertificate = ""
+	body, _ = json.Marshal(settings)
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, "/settings", body).Status, http.StatusOK, "switching signing off")
+	if stored, _ = server.db.GetSettings(); stored.SigningKey != "" {
+		t.Error("expected the signing key to be cleared with the certificate")
+	}
+}
+
+func TestExpiredSigningCertificateOnlyCheckedWhenChanged(t *testing.T) {
+	server := newTestServer(t)
+	certificate, key := signingPEM(t, "reports@example.org", time.Now().Add(-24*time.Hour))
+
+	body, _ := json.Marshal(dbstore.Settings{Email: "reports@example.org", SigningCertificate: certificate, SigningKey: key})
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, "/settings", body).Status, http.StatusBadRequest, "saving an expired certificate")
+
+	// Saved while it was valid, and since expired
+	if err := server.db.CreateOrUpdateSettings(dbstore.Settings{Email: "reports@example.org", SigningCertificate: certificate, SigningKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	settings := fetchSettingsAs(t, server)
+	settings.EmailFromName = "Stock reports"
+	body, _ = json.Marshal(settings)
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, "/settings", body).Status, http.StatusOK, "saving other settings with the expired certificate")
+
+	// The sending address still has to be one the certificate is issued for
+	settings.Email = "other@example.org"
+	body, _ = json.Marshal(settings)
+	expectStatus(t, callAs(t, server, admin, http.MethodPost, "/settings", body).Status, http.StatusBadRequest, "sending from an address the certificate isn't for")
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Where shared files are downloaded from, through Grafana when public links aren't served
+const (
+	sharedPath       = "/api/plugins/msupplyfoundation-datasource/resources/shared/"
+	publicSharedPath = "/shared/"
+)
+
+// SharedLink is a share link with the URL it's downloaded from
+type SharedLink struct {
+	dbstore.ShareLink
+	URL    string `json:"url"`
+	Active bool   `json:"active"`
+}
+
+// sharedLinks signs each link's URL, which is only given to those who can manage links
+func (server *HttpServer) sharedLinks(links []dbstore.ShareLink) ([]SharedLink, error) {
+	secret, err := server.db.Secret(dbstore.ShareLinkSecret)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimRight(settings.GrafanaURL, "/") + sharedPath
+	if publicURL := dbstore.PublicURL(); publicURL != "" {
+		base = publicURL + publicSharedPath
+	}
+
+	now := time.Now()
+	shared := []SharedLink{}
+	for _, link := range links {
+		query := url.Values{}
+		query.Set("org", strconv.FormatInt(server.db.OrgID(), 10))
+		query.Set("signature", dbstore.SignShareLink(secret, link))
+		shared = append(shared, SharedLink{ShareLink: link, URL: base + link.ID + "?" + query.Encode(), Active: link.Active(now)})
+	}
+	return shared, nil
+}
+
+// createShareLink makes a link to download a generated report until it expires, e.g. to pass on a report too
+// large to attach to someone outside Grafana. Only those who can change a schedule can share its reports, and the
+// link is to a copy of the report as it is now, as the next run writes over it.
+func (server *HttpServer) createShareLink(rw http.ResponseWriter, request *http.Request) {
+	requestBody, err := request.GetBody()
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: request.GetBody(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	bodyAsBytes, err := ioutil.ReadAll(requestBody)
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: ioutil.ReadAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		panic(err)
+	}
+
+	var shareRequest dbstore.ShareLinkRequest
+	err = json.Unmarshal(bodyAsBytes, &shareRequest)
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: json.Unmarshal: " + err.Error())
+		http.Error(rw, NewRequestBodyError(err, dbstore.ShareLinkRequestFields()).Error(), http.StatusBadRequest)
+		return
+	}
+
+	file, err := shareRequest.Validate()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !server.authorizeShare(rw, request, shareRequest, file) {
+		return
+	}
+	source := server.sharedSource(shareRequest, file)
+	if info, err := os.Stat(source); err != nil || info.IsDir() {
+		http.Error(rw, "there is no report file "+file+", it may have been removed", http.StatusNotFound)
+		return
+	}
+
+	copied, err := server.db.ShareFile(source)
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: db.ShareFile(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	link, err := server.db.CreateShareLink(copied, shareRequest.Hours, actor(request))
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: db.CreateShareLink(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditShareLink, link.ID, nil, link)
+
+	shared, err := server.sharedLinks([]dbstore.ShareLink{*link})
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: sharedLinks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(shared[0])
+	if err != nil {
+		log.DefaultLogger.Error("createShareLink: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// authorizeShare checks the user can change the schedule whose report they're sharing, writing the error response
+// if not
+func (server *HttpServer) authorizeShare(rw http.ResponseWriter, request *http.Request, shareRequest dbstore.ShareLinkRequest, file string) bool {
+	if shareRequest.RunID != "" {
+		run, err := server.db.GetReportRun(shareRequest.RunID)
+		if err != nil {
+			http.Error(rw, "there is no run "+shareRequest.RunID, http.StatusNotFound)
+			return false
+		}
+		return server.authorizeSchedule(rw, request, run.ScheduleID, false) && server.shareableUnencrypted(rw, run.ScheduleID, file)
+	}
+
+	schedule, err := server.db.GetSchedule(shareRequest.ScheduleID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if !server.authorizeSchedule(rw, request, schedule.ID, false) {
+		return false
+	}
+	return server.shareableUnencrypted(rw, schedule.ID, file)
+}
+
+// sharedSource is where the file to share is, in the schedule's own report directory, so only its reports can be
+// shared through it, or in the archive for a run's JSON
+func (server *HttpServer) sharedSource(shareRequest dbstore.ShareLinkRequest, file string) string {
+	if shareRequest.RunID != "" {
+		return filepath.Join(server.db.DataDirectory(), file)
+	}
+	return filepath.Join(server.db.ReportDirectory(shareRequest.ScheduleID), file)
+}
+
+// shareableUnencrypted checks a file of the schedule's reports can be shared as it is. The reports of a group
+// encrypting its attachments are only ever shared as their encrypted zips.
+func (server *HttpServer) shareableUnencrypted(rw http.ResponseWriter, scheduleID string, file string) bool {
+	schedule, err := server.db.GetSchedule(scheduleID)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return false
+	}
+	if schedule.ReportGroupID == "" || strings.EqualFold(filepath.Ext(file), ".zip") {
+		return true
+	}
+
+	reportGroup, err := server.db.ReportGroupFromSchedule(*schedule)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(rw, "the report group of "+schedule.Name+" is in the trash", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		log.DefaultLogger.Error("shareableUnencrypted: db.ReportGroupFromSchedule(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if reportGroup.EncryptAttachments {
+		http.Error(rw, "the reports of "+reportGroup.Name+" are encrypted, only their zips can be shared", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// canManageShareLink is whether the user can see a link's URL or revoke it: whoever made it, or an admin
+func canManageShareLink(request *http.Request, link dbstore.ShareLink) bool {
+	return isAdmin(request) || link.CreatedBy == actor(request)
+}
+
+func (server *HttpServer) fetchShareLinks(rw http.ResponseWriter, request *http.Request) {
+	all, err := server.db.GetShareLinks()
+	if err != nil {
+		log.DefaultLogger.Error("fetchShareLinks: db.GetShareLinks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	links := []dbstore.ShareLink{}
+	for _, link := range all {
+		if canManageShareLink(request, link) {
+			links = append(links, link)
+		}
+	}
+
+	shared, err := server.sharedLinks(links)
+	if err != nil {
+		log.DefaultLogger.Error("fetchShareLinks: sharedLinks(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(shared)
+	if err != nil {
+		log.DefaultLogger.Error("fetchShareLinks: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// revokeShareLink stops a link working before it expires. It's kept, revoked, as a record of what was shared.
+func (server *HttpServer) revokeShareLink(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	before, err := server.db.GetShareLink(id)
+	if err != nil {
+		http.Error(rw, "share link not found", http.StatusNotFound)
+		return
+	}
+	if !canManageShareLink(request, *before) {
+		log.DefaultLogger.Warn("revokeShareLink: " + actor(request) + " can't revoke share link " + id + " made by " + before.CreatedBy)
+		http.Error(rw, "Forbidden: share link was made by "+before.CreatedBy, http.StatusForbidden)
+		return
+	}
+
+	err = server.db.RevokeShareLink(id, actor(request))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(rw, "share link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("revokeShareLink: db.RevokeShareLink(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	after, _ := server.db.GetShareLink(id)
+	server.audit(request, dbstore.AuditActionCancel, auditShareLink, id, before, after)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// downloadShared serves the file of a share link, on the public links and through Grafana. The signature, rather
+// than the user's role, is what allows it, so anyone with the link can download it until it expires or is revoked.
+func (server *HttpServer) downloadShared(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	secret, err := server.db.Secret(dbstore.ShareLinkSecret)
+	if err != nil {
+		log.DefaultLogger.Error("downloadShared: db.Secret(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	link, err := server.db.GetShareLink(id)
+	if err != nil || !dbstore.CheckShareLink(secret, *link, request.URL.Query().Get("signature")) {
+		log.DefaultLogger.Warn("downloadShared: invalid link " + id)
+		http.Error(rw, "this link is not valid, it may have been changed or cut short", http.StatusForbidden)
+		return
+	}
+	if !link.Active(time.Now()) {
+		http.Error(rw, "this link has expired or been revoked, ask whoever shared it for a new one", http.StatusGone)
+		return
+	}
+
+	file, err := os.Open(server.db.SharedFilePath(*link))
+	if err != nil {
+		http.Error(rw, "the shared report has since been removed", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.DefaultLogger.Error("downloadShared: file.Stat(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if err := server.db.CountShareLinkDownload(id); err != nil {
+		log.DefaultLogger.Error("downloadShared: db.CountShareLinkDownload(): " + err.Error())
+	}
+
+	rw.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(link.File)+"\"")
+	http.ServeContent(rw, request, filepath.Base(link.File), info.ModTime(), file)
+}
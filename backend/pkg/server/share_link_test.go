@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// reportDirectory is the schedule's report directory, made as a run would
+func reportDirectory(t *testing.T, server *HttpServer, scheduleID string) string {
+	t.Helper()
+	directory := server.db.ReportDirectory(scheduleID)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return directory
+}
+
+func writeReport(t *testing.T, directory string, name string, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// publicTarget is the path and query of a link's URL, as requested of the public links
+func publicTarget(t *testing.T, link string) string {
+	t.Helper()
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicSharedPath + filepath.Base(parsed.Path) + "?" + parsed.RawQuery
+}
+
+func TestShareLinkServesReportAsShared(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	directory := reportDirectory(t, server, schedule.ID)
+	writeReport(t, directory, schedule.Name+".xlsx", "March stock")
+
+	body, _ := json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx", "scheduleID": schedule.ID})
+	response := callAs(t, server, alice, http.MethodPost, "/share-link", body)
+	expectStatus(t, response.Status, http.StatusOK, "owner sharing their report")
+	var link SharedLink
+	if err := json.Unmarshal(response.Body, &link); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run writes over the report
+	writeReport(t, directory, schedule.Name+".xlsx", "April stock")
+
+	download := callPublic(server, http.MethodGet, publicTarget(t, link.URL))
+	expectStatus(t, download.Code, http.StatusOK, "downloading without signing in")
+	if download.Body.String() != "March stock" {
+		t.Errorf("expected the report as it was shared, got %q", download.Body.String())
+	}
+
+	forged := callPublic(server, http.MethodGet, publicSharedPath+link.ID+"?signature=00")
+	expectStatus(t, forged.Code, http.StatusForbidden, "download with a forged signature")
+}
+
+func TestShareLinkNeedsScheduleAccess(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	directory := reportDirectory(t, server, schedule.ID)
+	writeReport(t, directory, schedule.Name+".xlsx", "March stock")
+
+	body, _ := json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx", "scheduleID": schedule.ID})
+	expectStatus(t, callAs(t, server, bob, http.MethodPost, "/share-link", body).Status, http.StatusForbidden, "another editor sharing the report")
+
+	other := createSchedule(t, server, bob.Login)
+	other.Name = "Expiries"
+	if _, err := server.db.UpdateSchedule(other.ID, *other); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx", "scheduleID": other.ID})
+	expectStatus(t, callAs(t, server, bob, http.MethodPost, "/share-link", body).Status, http.StatusNotFound, "sharing another schedule's report through their own")
+}
+
+func TestShareLinkRejectsMalformedRequest(t *testing.T) {
+	server := newTestServer(t)
+
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/share-link", []byte("{")).Status, http.StatusBadRequest, "malformed JSON")
+}
+
+func TestOnlyCreatorOrAdminManagesShareLink(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	directory := reportDirectory(t, server, schedule.ID)
+	writeReport(t, directory, schedule.Name+".xlsx", "March stock")
+
+	body, _ := json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx", "scheduleID": schedule.ID})
+	var link SharedLink
+	if err := json.Unmarshal(callAs(t, server, alice, http.MethodPost, "/share-link", body).Body, &link); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []SharedLink
+	if err := json.Unmarshal(callAs(t, server, bob, http.MethodGet, "/share-link", nil).Body, &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("expected another editor not to see the link, got %v", listed)
+	}
+
+	expectStatus(t, callAs(t, server, bob, http.MethodDelete, "/share-link/"+link.ID, nil).Status, http.StatusForbidden, "another editor revoking the link")
+	expectStatus(t, callAs(t, server, alice, http.MethodDelete, "/share-link/"+link.ID, nil).Status, http.StatusOK, "creator revoking the link")
+
+	expectStatus(t, callPublic(server, http.MethodGet, publicTarget(t, link.URL)).Code, http.StatusGone, "downloading a revoked link")
+	if _, err := os.Stat(filepath.Join(filepath.Dir(server.db.Path), filepath.FromSlash(link.File))); !os.IsNotExist(err) {
+		t.Errorf("expected the copy of the revoked link's report to be deleted, got %v", err)
+	}
+}
+
+func TestEncryptedGroupsReportsAreOnlySharedZipped(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	directory := reportDirectory(t, server, schedule.ID)
+	group, err := server.db.CreateReportGroup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group.EncryptAttachments = true
+	group.AttachmentPassword = "correct horse battery"
+	if _, err := server.db.UpdateReportGroup(group.ID, *group); err != nil {
+		t.Fatal(err)
+	}
+	schedule.ReportGroupID = group.ID
+	if _, err := server.db.UpdateSchedule(schedule.ID, *schedule); err != nil {
+		t.Fatal(err)
+	}
+	writeReport(t, directory, schedule.Name+".xlsx", "March stock")
+	writeReport(t, directory, schedule.Name+".xlsx.zip", "March stock, encrypted")
+
+	body, _ := json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx", "scheduleID": schedule.ID})
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/share-link", body).Status, http.StatusBadRequest, "sharing an encrypted group's report unencrypted")
+
+	body, _ = json.Marshal(map[string]interface{}{"file": schedule.Name + ".xlsx.zip", "scheduleID": schedule.ID})
+	expectStatus(t, callAs(t, server, alice, http.MethodPost, "/share-link", body).Status, http.StatusOK, "sharing an encrypted group's zip")
+}
+
+func TestSchedulesWithTheSameNameShareTheirOwnReports(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+	other := createSchedule(t, server, bob.Login)
+	other.Name = schedule.Name
+	if _, err := server.db.UpdateSchedule(other.ID, *other); err != nil {
+		t.Fatal(err)
+	}
+	writeReport(t, reportDirectory(t, server, schedule.ID), schedule.Name+".xlsx", "Alice's stock")
+	writeReport(t, reportDirectory(t, server, other.ID), other.Name+".xlsx", "Bob's stock")
+
+	body, _ := json.Marshal(map[string]interface{}{"file": other.Name + ".xlsx", "scheduleID": other.ID})
+	response := callAs(t, server, bob, http.MethodPost, "/share-link", body)
+	expectStatus(t, response.Status, http.StatusOK, "owner sharing their report")
+	var link SharedLink
+	if err := json.Unmarshal(response.Body, &link); err != nil {
+		t.Fatal(err)
+	}
+
+	download := callPublic(server, http.MethodGet, publicTarget(t, link.URL))
+	expectStatus(t, download.Code, http.StatusOK, "downloading the shared report")
+	if download.Body.String() != "Bob's stock" {
+		t.Errorf("expected the report of the schedule shared, got %q", download.Body.String())
+	}
+}
+
+func TestReportFilesOnlyDownloadedFromTheirSchedule(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, "alice")
+	writeReport(t, reportDirectory(t, server, schedule.ID), "Stock.xlsx", "March stock")
+
+	path := "/schedule/" + schedule.ID + "/report/"
+	response := callAs(t, server, alice, http.MethodGet, path+"Stock.xlsx", nil)
+	expectStatus(t, response.Status, http.StatusOK, "owner downloading their report")
+	if string(response.Body) != "March stock" {
+		t.Errorf("expected the report, got %q", response.Body)
+	}
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, path+"Stock.xlsx", nil).Status, http.StatusForbidden, "another editor downloading the report")
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, path+"msupply.db", nil).Status, http.StatusNotFound, "downloading a file which isn't a report")
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func (server *HttpServer) fetchSLOCompliance(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	scheduleID := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, scheduleID, false) {
+		return
+	}
+
+	compliances, err := server.db.GetSLOCompliance(scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("fetchSLOCompliance: db.GetSLOCompliance(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(compliances)
+	if err != nil {
+		log.DefaultLogger.Error("fetchSLOCompliance: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+)
+
+// userTeams is the Grafana teams the user making the request is in. They are cached for a few minutes, and the
+// teams last fetched are used when Grafana can't be asked, so team members aren't locked out while it restarts.
+func (server *HttpServer) userTeams(request *http.Request) []string {
+	return server.teamsOf(request.Context(), actor(request))
+}
+
+// teamsOf is the Grafana teams a user is in, looked up by their login, e.g. for requests with a token for them
+// rather than from Grafana
+func (server *HttpServer) teamsOf(ctx context.Context, login string) []string {
+	now := time.Now()
+
+	cached, fresh, err := server.db.CachedUserTeams(login, now)
+	if err != nil {
+		log.DefaultLogger.Warn("teamsOf: db.CachedUserTeams(): " + err.Error())
+	}
+	if fresh {
+		return cached
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Warn("teamsOf: auth.NewAuthConfig(): " + err.Error())
+		return cached
+	}
+
+	teams, err := api.GetUserTeams(ctx, authConfig, login)
+	if err != nil {
+		log.DefaultLogger.Warn("teamsOf: could not look up the teams of " + login + ": " + err.Error())
+		return cached
+	}
+
+	if err := server.db.CacheUserTeams(login, teams, now); err != nil {
+		log.DefaultLogger.Warn("teamsOf: db.CacheUserTeams(): " + err.Error())
+	}
+	return teams
+}
+
+func isInTeam(teams []string, team string) bool {
+	for _, t := range teams {
+		if t == team {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchTeams lists the organisation's Grafana teams, which schedules and report groups can be owned by
+func (server *HttpServer) fetchTeams(rw http.ResponseWriter, request *http.Request) {
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("fetchTeams: auth.NewAuthConfig(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	teams, err := api.GetTeams(request.Context(), authConfig)
+	if err != nil {
+		log.DefaultLogger.Error("fetchTeams: api.GetTeams(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	err = json.NewEncoder(rw).Encode(teams)
+	if err != nil {
+		log.DefaultLogger.Error("fetchTeams: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
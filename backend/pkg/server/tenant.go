@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// tenantHandler sends each request to a server using the database of the Grafana organisation it came from,
+// when each organisation's data is kept apart
+type tenantHandler struct {
+	db       *dbstore.SQLiteDatasource
+	mutex    sync.Mutex
+	handlers map[*dbstore.SQLiteDatasource]http.Handler
+}
+
+func newTenantHandler(sqliteDatasource *dbstore.SQLiteDatasource) *tenantHandler {
+	return &tenantHandler{db: sqliteDatasource, handlers: make(map[*dbstore.SQLiteDatasource]http.Handler)}
+}
+
+func (handler *tenantHandler) ServeHTTP(rw http.ResponseWriter, request *http.Request) {
+	tenant, err := handler.db.Tenant(requestOrgID(request))
+	if err != nil {
+		log.DefaultLogger.Error("tenantHandler.ServeHTTP: db.Tenant(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	handler.mutex.Lock()
+	tenantHandler, ok := handler.handlers[tenant]
+	if !ok {
+		tenantHandler = NewServer(tenant).router()
+		handler.handlers[tenant] = tenantHandler
+	}
+	handler.mutex.Unlock()
+
+	tenantHandler.ServeHTTP(rw, request)
+}
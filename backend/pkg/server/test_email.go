@@ -1,50 +1,106 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
-	"github.com/grafana/simple-datasource-backend/pkg/auth"
-	"github.com/grafana/simple-datasource-backend/pkg/emailer"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/jobs"
 	"github.com/grafana/simple-datasource-backend/pkg/reportEmailer"
 )
 
+// testEmail queues a schedule's report to be sent now, returning the job which sends it
 func (server *HttpServer) testEmail(rw http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	id := vars["schedule-id"]
 
-	emailConfig, err := auth.NewEmailConfig(server.db)
+	// Sending it anywhere shares the schedule's data as much as changing its recipients would
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	schedule, err := server.db.GetSchedule(id)
 	if err != nil {
-		log.DefaultLogger.Error("testEmail: auth.NewEmailConfig: ", err.Error())
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		log.DefaultLogger.Error("testData: server.db.GetSchedule: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		panic(err)
 	}
 
-	authConfig, err := auth.NewAuthConfig(server.db)
+	to, err := testRecipients(request)
+	if err != nil {
+		log.DefaultLogger.Warn("testEmail: testRecipients: ", err.Error())
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Checked again as it's sent, but caught here rather than after the report has been generated
+	if len(to) > 0 {
+		reportGroup, err := server.db.ReportGroupFromSchedule(*schedule)
+		if err != nil {
+			log.DefaultLogger.Error("testEmail: db.ReportGroupFromSchedule: ", err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := server.db.CheckRecipientResidency(*reportGroup, dbstore.ChannelEmail, to); err != nil {
+			http.Error(rw, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	payload, err := json.Marshal(reportEmailer.TestPayload{To: to})
 	if err != nil {
-		log.DefaultLogger.Error("textEmail: auth.NewAuthConfig: ", err.Error())
+		log.DefaultLogger.Error("testEmail: json.Marshal: ", err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
 
-	settings, err := server.db.GetSettings()
+	// Queues behind the scheduled reports being generated, rather than adding to their load on the renderer
+	job, err := reportEmailer.Workers(server.db).Enqueue(dbstore.Job{Kind: jobs.KindTest, ScheduleID: schedule.ID, ScheduleName: schedule.Name, Priority: schedule.Priority, Payload: string(payload), RequestedBy: actor(request)})
 	if err != nil {
-		log.DefaultLogger.Error("testEmail: db.GetSettings: ", err.Error())
+		log.DefaultLogger.Error("testEmail: Enqueue: ", err.Error())
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
 		panic(err)
 	}
 
-	schedule, err := server.db.GetSchedule(id)
+	rw.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(rw).Encode(job)
 	if err != nil {
-		log.DefaultLogger.Error("testData: server.db.GetSchedule: ", err.Error())
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+		log.DefaultLogger.Error("testEmail: json.NewEncoder().Encode(): " + err.Error())
 		panic(err)
 	}
+}
+
+// testRecipients is who a test email goes to instead of the report group: the addresses in the to query, which can
+// be repeated or comma separated, and the calling user's own address with to-me=true. None sends to the group.
+func testRecipients(request *http.Request) ([]string, error) {
+	query := request.URL.Query()
+
+	to := []string{}
+	for _, value := range query["to"] {
+		for _, address := range strings.Split(value, ",") {
+			address = strings.TrimSpace(address)
+			if address == "" {
+				continue
+			}
+			if err := dbstore.CheckEmailSyntax(address); err != nil {
+				return nil, fmt.Errorf("to: %s %s", address, err.Error())
+			}
+			to = append(to, address)
+		}
+	}
+
+	if query.Get("to-me") == "true" {
+		user := requestUser(request)
+		if user == nil || user.Email == "" {
+			return nil, errors.New("to-me: your Grafana user doesn't have an email address")
+		}
+		to = append(to, user.Email)
+	}
 
-	em := emailer.New(emailConfig)
-	re := reportEmailer.NewReportEmailer(server.db)
-	re.CreateReport(*schedule, authConfig, settings.DatasourceID, *em)
-	rw.WriteHeader(http.StatusOK)
+	return to, nil
 }
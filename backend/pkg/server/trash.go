@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// The audit log's entity type for each kind of item in the trash
+var trashAuditTypes = map[string]string{dbstore.ItemKindSchedule: auditSchedule, dbstore.ItemKindReportGroup: auditReportGroup}
+
+// fetchTrash lists what has been deleted, everything for admins and only what they deleted themselves for editors
+func (server *HttpServer) fetchTrash(rw http.ResponseWriter, request *http.Request) {
+	deletedBy := actor(request)
+	if isAdmin(request) {
+		deletedBy = ""
+	}
+
+	items, err := server.db.GetTrash(deletedBy)
+	if err != nil {
+		log.DefaultLogger.Error("fetchTrash: db.GetTrash(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(items)
+	if err != nil {
+		log.DefaultLogger.Error("fetchTrash: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// authorizeTrashItem checks the user can restore or purge an item in the trash, which admins can do to anything and
+// editors only to what they deleted, writing the error response if not
+func (server *HttpServer) authorizeTrashItem(rw http.ResponseWriter, request *http.Request, kind string, id string) (*dbstore.TrashItem, bool) {
+	item, err := server.db.GetTrashItem(kind, id)
+	if errors.Is(err, dbstore.ErrNotInTrash) {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.DefaultLogger.Error("authorizeTrashItem: db.GetTrashItem(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !isAdmin(request) && item.DeletedBy != actor(request) {
+		log.DefaultLogger.Warn("authorizeTrashItem: " + actor(request) + " can't change " + kind + " " + id + " deleted by " + item.DeletedBy)
+		http.Error(rw, "Forbidden: deleted by "+item.DeletedBy, http.StatusForbidden)
+		return nil, false
+	}
+
+	return item, true
+}
+
+func (server *HttpServer) restoreTrashItem(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	kind, id := vars["kind"], vars["id"]
+
+	item, ok := server.authorizeTrashItem(rw, request, kind, id)
+	if !ok {
+		return
+	}
+
+	err := server.db.RestoreFromTrash(kind, id)
+	if err != nil {
+		log.DefaultLogger.Error("restoreTrashItem: db.RestoreFromTrash(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionRestore, trashAuditTypes[kind], id, item, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// purgeTrashItem deletes an item in the trash for good, rather than waiting for it to expire
+func (server *HttpServer) purgeTrashItem(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	kind, id := vars["kind"], vars["id"]
+
+	item, ok := server.authorizeTrashItem(rw, request, kind, id)
+	if !ok {
+		return
+	}
+
+	err := server.db.PurgeFromTrash(kind, id)
+	if err != nil {
+		log.DefaultLogger.Error("purgeTrashItem: db.PurgeFromTrash(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionPurge, trashAuditTypes[kind], id, item, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
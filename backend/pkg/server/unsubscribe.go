@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// unsubscribe handles the link in each report's email, on the public links and for links sent before they were
+// served also through Grafana. The signature, rather than the user's role, is what allows the address to be opted out.
+func (server *HttpServer) unsubscribe(rw http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	scheduleID := query.Get("schedule-id")
+	address := query.Get("address")
+
+	secret, err := server.db.Secret(dbstore.UnsubscribeSecret)
+	if err != nil {
+		log.DefaultLogger.Error("unsubscribe: db.Secret(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	if scheduleID == "" || address == "" || !dbstore.CheckUnsubscribe(secret, scheduleID, address, query.Get("signature")) {
+		err = errors.New("this unsubscribe link is not valid, it may have been changed or cut short")
+		log.DefaultLogger.Warn("unsubscribe: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	schedule, err := server.db.GetSchedule(scheduleID)
+	if err != nil {
+		log.DefaultLogger.Error("unsubscribe: db.GetSchedule(): " + err.Error())
+		http.Error(rw, "this report no longer exists", http.StatusNotFound)
+		return
+	}
+
+	err = server.db.CreateUnsubscribe(scheduleID, address)
+	if err != nil {
+		log.DefaultLogger.Error("unsubscribe: db.CreateUnsubscribe(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionCreate, auditUnsubscribe, scheduleID+"/"+address, nil, dbstore.Unsubscribe{ScheduleID: scheduleID, Address: address})
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintf(rw, "<html><body><p>%s won't be sent %s any more.</p></body></html>", html.EscapeString(address), html.EscapeString(schedule.Name))
+}
+
+func (server *HttpServer) fetchUnsubscribes(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	unsubscribes, err := server.db.GetUnsubscribes(id)
+	if err != nil {
+		log.DefaultLogger.Error("fetchUnsubscribes: db.GetUnsubscribes(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(unsubscribes)
+	if err != nil {
+		log.DefaultLogger.Error("fetchUnsubscribes: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// deleteUnsubscribe subscribes an address to the schedule again, e.g. when they unsubscribed by mistake
+func (server *HttpServer) deleteUnsubscribe(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	id := vars["id"]
+	address := vars["address"]
+
+	if !server.authorizeSchedule(rw, request, id, false) {
+		return
+	}
+
+	err := server.db.DeleteUnsubscribe(id, address)
+	if err != nil {
+		log.DefaultLogger.Error("deleteUnsubscribe: db.DeleteUnsubscribe(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+	server.audit(request, dbstore.AuditActionDelete, auditUnsubscribe, id+"/"+address, dbstore.Unsubscribe{ScheduleID: id, Address: address}, nil)
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func unsubscribeTarget(t *testing.T, server *HttpServer, scheduleID string, address string) string {
+	t.Helper()
+	secret, err := server.db.Secret(dbstore.UnsubscribeSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := url.Values{"schedule-id": {scheduleID}, "address": {address}, "signature": {dbstore.SignUnsubscribe(secret, scheduleID, address)}}
+	return "/unsubscribe?" + query.Encode()
+}
+
+func TestPublicUnsubscribeNeedsOnlySignature(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+
+	response := callPublic(server, http.MethodGet, unsubscribeTarget(t, server, schedule.ID, "nurse@clinic.org"))
+	expectStatus(t, response.Code, http.StatusOK, "signed unsubscribe link")
+
+	unsubscribes, err := server.db.GetUnsubscribes(schedule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsubscribes) != 1 || unsubscribes[0].Address != "nurse@clinic.org" {
+		t.Errorf("expected nurse@clinic.org to be unsubscribed, got %v", unsubscribes)
+	}
+}
+
+func TestPublicUnsubscribeRejectsOtherAddress(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+
+	target := unsubscribeTarget(t, server, schedule.ID, "nurse@clinic.org")
+	forged, _ := url.Parse(target)
+	query := forged.Query()
+	query.Set("address", "doctor@clinic.org")
+	forged.RawQuery = query.Encode()
+
+	response := callPublic(server, http.MethodGet, forged.String())
+	expectStatus(t, response.Code, http.StatusForbidden, "unsubscribe link for another address")
+}
+
+func TestPublicLinksDontCreateOrganisations(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+
+	response := callPublic(server, http.MethodGet, unsubscribeTarget(t, server, schedule.ID, "nurse@clinic.org")+"&org=42")
+	expectStatus(t, response.Code, http.StatusNotFound, "link naming an unknown organisation")
+}
+
+func TestPublicLinksServeNoOtherRoutes(t *testing.T) {
+	server := newTestServer(t)
+
+	response := callPublic(server, http.MethodGet, "/settings")
+	expectStatus(t, response.Code, http.StatusNotFound, "settings on the public links")
+}
+
+func TestFetchUnsubscribesNeedsScheduleAccess(t *testing.T) {
+	server := newTestServer(t)
+	schedule := createSchedule(t, server, alice.Login)
+
+	expectStatus(t, callAs(t, server, alice, http.MethodGet, "/schedule/"+schedule.ID+"/unsubscribe", nil).Status, http.StatusOK, "owner")
+	expectStatus(t, callAs(t, server, admin, http.MethodGet, "/schedule/"+schedule.ID+"/unsubscribe", nil).Status, http.StatusOK, "admin")
+	expectStatus(t, callAs(t, server, bob, http.MethodGet, "/schedule/"+schedule.ID+"/unsubscribe", nil).Status, http.StatusForbidden, "another editor")
+	expectStatus(t, callAs(t, server, viewer, http.MethodGet, "/schedule/"+schedule.ID+"/unsubscribe", nil).Status, http.StatusForbidden, "a viewer")
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/clock"
+	"github.com/grafana/simple-datasource-backend/pkg/scheduler"
+)
+
+// Days of upcoming runs listed without ?days
+const defaultUpcomingDays = 7
+
+// UpcomingRun is a run of a schedule still to come
+type UpcomingRun struct {
+	ScheduleID   string `json:"scheduleID"`
+	ScheduleName string `json:"scheduleName"`
+	Time         int    `json:"time"`
+	// Time in the schedule's timezone, e.g. 2024-03-04 08:00 +0300
+	LocalTime string `json:"localTime"`
+	Timezone  string `json:"timezone"`
+	// The blackout skipping the run, or the time a blackout postponed it from
+	Blackout      string `json:"blackout,omitempty"`
+	PostponedFrom int    `json:"postponedFrom,omitempty"`
+	// IDs of the other schedules due at the same time, which are generated alongside this one
+	CollidesWith []string `json:"collidesWith"`
+}
+
+// Upcoming is every schedule's runs from now until Days from now, in time order
+type Upcoming struct {
+	From int           `json:"from"`
+	To   int           `json:"to"`
+	Days int           `json:"days"`
+	Runs []UpcomingRun `json:"runs"`
+}
+
+// upcomingDays reads ?days, from 1 to a year, which is days without it
+func upcomingDays(request *http.Request, days int) (int, error) {
+	if value := request.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxCalendarDays {
+			return 0, errors.New("days must be a number from 1 to " + strconv.Itoa(maxCalendarDays))
+		}
+	}
+	return days, nil
+}
+
+// fetchUpcomingRuns lists when every schedule will next be sent over the coming days, so admins can see where
+// reports pile up on the same minute
+func (server *HttpServer) fetchUpcomingRuns(rw http.ResponseWriter, request *http.Request) {
+	days, err := upcomingDays(request, defaultUpcomingDays)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	schedules, err := server.db.GetSchedules()
+	if err != nil {
+		log.DefaultLogger.Error("fetchUpcomingRuns: db.GetSchedules(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	from := clock.System.Now()
+	to := from.AddDate(0, 0, days)
+	planner := scheduler.New(server.db)
+	runs := []UpcomingRun{}
+	for _, schedule := range schedules {
+		location := schedule.Location()
+		for _, planned := range planner.Planned(schedule, from, to) {
+			runs = append(runs, UpcomingRun{
+				ScheduleID:    schedule.ID,
+				ScheduleName:  schedule.Name,
+				Time:          planned.ScheduledAt,
+				LocalTime:     time.Unix(int64(planned.ScheduledAt), 0).In(location).Format("2006-01-02 15:04 -0700"),
+				Timezone:      location.String(),
+				Blackout:      planned.Blackout,
+				PostponedFrom: planned.PostponedFrom,
+			})
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Time < runs[j].Time })
+	markCollisions(runs)
+
+	upcoming := Upcoming{From: int(from.Unix()), To: int(to.Unix()), Days: days, Runs: runs}
+	err = json.NewEncoder(rw).Encode(upcoming)
+	if err != nil {
+		log.DefaultLogger.Error("fetchUpcomingRuns: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// markCollisions lists, on each run, the other schedules due at the same time. Runs a blackout skips aren't sent,
+// so don't collide with anything.
+func markCollisions(runs []UpcomingRun) {
+	byTime := make(map[int][]string)
+	for _, run := range runs {
+		if run.Blackout == "" {
+			byTime[run.Time] = append(byTime[run.Time], run.ScheduleID)
+		}
+	}
+
+	for i := range runs {
+		runs[i].CollidesWith = []string{}
+		if runs[i].Blackout != "" {
+			continue
+		}
+		for _, scheduleID := range byTime[runs[i].Time] {
+			if scheduleID != runs[i].ScheduleID {
+				runs[i].CollidesWith = append(runs[i].CollidesWith, scheduleID)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// upgradeStatus is what upgrading a database to this version involves, and how far its last upgrade got
+type upgradeStatus struct {
+	Plan     *dbstore.UpgradePlan     `json:"plan"`
+	Progress *dbstore.UpgradeProgress `json:"progress,omitempty"`
+}
+
+// fetchUpgrade is the pre-flight check of the database, or of the backup named in the backup query before it's
+// restored, against this version's migrations
+func (server *HttpServer) fetchUpgrade(rw http.ResponseWriter, request *http.Request) {
+	var status upgradeStatus
+	var err error
+	if backup := request.URL.Query().Get("backup"); backup != "" {
+		status.Plan, err = server.db.PlanBackupUpgrade(server.backupDirectory(), backup)
+		if err != nil {
+			log.DefaultLogger.Warn("fetchUpgrade: db.PlanBackupUpgrade(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		status.Plan, err = server.db.PlanUpgrade()
+		if err != nil {
+			log.DefaultLogger.Error("fetchUpgrade: db.PlanUpgrade(): " + err.Error())
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			panic(err)
+		}
+		progress := server.db.UpgradeProgress()
+		status.Progress = &progress
+	}
+
+	err = json.NewEncoder(rw).Encode(status)
+	if err != nil {
+		log.DefaultLogger.Error("fetchUpgrade: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func (server *HttpServer) fetchDashboardUsage(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	uid := vars["uid"]
+
+	usage, err := server.db.GetDashboardUsage(uid)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDashboardUsage: db.GetDashboardUsage(): " + uid + ": " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(usage)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDashboardUsage: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/validation"
+)
+
+// validateAll checks the systems reports depend on and every schedule, returning everything wrong in one report.
+// It's meant to be run after moving the server or upgrading Grafana, so it takes a while on large installations.
+func (server *HttpServer) validateAll(rw http.ResponseWriter, request *http.Request) {
+	settings, err := server.db.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("validateAll: db.GetSettings(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("validateAll: auth.NewAuthConfig: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	report, err := validation.NewValidator(request.Context(), server.db, authConfig, settings).ValidateAll(request.Context())
+	if err != nil {
+		log.DefaultLogger.Error("validateAll: ValidateAll(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(report)
+	if err != nil {
+		log.DefaultLogger.Error("validateAll: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+func (server *HttpServer) fetchDashboardVariables(rw http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	uid := vars["uid"]
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDashboardVariables: auth.NewAuthConfig: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	variables, err := api.GetDashboardVariables(request.Context(), authConfig, uid)
+	if err == api.ErrDashboardNotFound {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.DefaultLogger.Error("fetchDashboardVariables: api.GetDashboardVariables: " + err.Error())
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		panic(err)
+	}
+
+	err = json.NewEncoder(rw).Encode(variables)
+	if err != nil {
+		log.DefaultLogger.Error("fetchDashboardVariables: json.NewEncoder().Encode(): " + err.Error())
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		panic(err)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+// checkContentVariables checks the variables a content item sets against its dashboard's template variables.
+// Grafana being unavailable shouldn't stop content being saved, so they're only checked when the dashboard
+// can be fetched.
+func (server *HttpServer) checkContentVariables(ctx context.Context, dashboardID string, variables string) error {
+	selected, err := dbstore.ParseContentVariables(variables)
+	if err != nil || len(selected) == 0 {
+		return err
+	}
+
+	authConfig, err := auth.NewAuthConfig(server.db)
+	if err != nil {
+		log.DefaultLogger.Warn("checkContentVariables: auth.NewAuthConfig: " + err.Error())
+		return nil
+	}
+
+	dashboardVariables, err := api.GetDashboardVariables(ctx, authConfig, dashboardID)
+	if err == api.ErrDashboardNotFound {
+		return err
+	}
+	if err != nil {
+		log.DefaultLogger.Warn("checkContentVariables: could not fetch the variables of " + dashboardID + ": " + err.Error())
+		return nil
+	}
+
+	return api.CheckContentVariables(dashboardVariables, selected)
+}
@@ -0,0 +1,156 @@
+// Package syslog sends the plugin's activity to a syslog server as RFC 5424 messages, for organisations which
+// collect their logs centrally through syslog only
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+const (
+	appName = "msupply-dashboard"
+	// Messages are sent as the local0 facility
+	facility = 16
+	// Structured data is named within the enterprise number set aside for documentation and examples, as the
+	// project doesn't have one of its own
+	structuredDataID = "event@32473"
+	sendTimeout      = 5 * time.Second
+)
+
+// Severities events are sent with
+const (
+	SeverityError   = 3
+	SeverityWarning = 4
+	SeverityNotice  = 5
+	SeverityInfo    = 6
+)
+
+// Kinds of event, sent as the message's MSGID
+const (
+	EventReportRun     = "report-run"
+	EventFailureStreak = "failure-streak"
+	EventChange        = "change"
+	// A schedule disabled for failing permanently over and over
+	EventDisabled = "schedule-disabled"
+)
+
+// Event is something the plugin did. Fields are sent as the message's structured data, so syslog servers can
+// filter on them.
+type Event struct {
+	Kind     string
+	Severity int
+	Message  string
+	Fields   map[string]string
+}
+
+// Send sends an event to the syslog server in the settings, if there is one. It's sent in the background, so a
+// slow or unreachable server doesn't hold up reports or requests, and failing to send it is only logged.
+func Send(settings *dbstore.Settings, event Event) {
+	if settings == nil || settings.SyslogAddress == "" {
+		return
+	}
+
+	address, protocol := settings.SyslogAddress, settings.SyslogProtocol
+	message := Format(event, time.Now())
+	go func() {
+		if err := send(address, protocol, message); err != nil {
+			log.DefaultLogger.Warn("syslog.Send: " + address + ": " + err.Error())
+		}
+	}()
+}
+
+func send(address string, protocol string, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	switch protocol {
+	case dbstore.SyslogProtocolTLS:
+		host, _, splitErr := net.SplitHostPort(address)
+		if splitErr != nil {
+			return splitErr
+		}
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: host}}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	case dbstore.SyslogProtocolTCP:
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	default:
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "udp", address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Over a stream each message is framed by its length (RFC 6587), a datagram holds just the one
+	if protocol == dbstore.SyslogProtocolTCP || protocol == dbstore.SyslogProtocolTLS {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	_, err = conn.Write([]byte(message))
+	return err
+}
+
+// Format is an event as an RFC 5424 message, e.g.
+// <134>1 2024-03-01T09:00:05Z grafana msupply-dashboard 42 report-run [event@32473 status="sent"] Report 'Stock levels' sent
+func Format(event Event, at time.Time) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", facility*8+event.Severity, at.UTC().Format(time.RFC3339Nano),
+		header(hostname, 255), appName, os.Getpid(), header(event.Kind, 32), structuredData(event.Fields), event.Message)
+}
+
+// header is a header field, which is printable ASCII without spaces, or - when it's empty
+func header(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+var paramValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData is the fields as the event's structured data element, in the order of their names
+func structuredData(fields map[string]string) string {
+	if len(fields) == 0 {
+		return "-"
+	}
+
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := []string{}
+	for _, name := range names {
+		params = append(params, fmt.Sprintf(`%s="%s"`, header(strings.NewReplacer("=", "", "]", "", `"`, "").Replace(name), 32), paramValueEscaper.Replace(fields[name])))
+	}
+	return "[" + structuredDataID + " " + strings.Join(params, " ") + "]"
+}
@@ -0,0 +1,89 @@
+// Package usersync keeps a local copy of mSupply's active users, so the report group member picker can
+// search them without querying mSupply, and without the duplicate and inactive users mSupply returns.
+package usersync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+)
+
+// Longest a sync can take, so a slow mSupply can't hold up the next one
+const timeout = 2 * time.Minute
+
+type Syncer struct {
+	sql *dbstore.SQLiteDatasource
+}
+
+func New(sql *dbstore.SQLiteDatasource) *Syncer {
+	return &Syncer{sql: sql}
+}
+
+// deduplicate keeps the first of the users with the same ID, and of those with the same email address
+// ignoring case, as mSupply can have several accounts for the same person
+func deduplicate(users []api.DirectoryUser) []dbstore.DirectoryUser {
+	ids := make(map[string]bool)
+	emails := make(map[string]bool)
+
+	unique := []dbstore.DirectoryUser{}
+	for _, user := range users {
+		id := strings.TrimSpace(user.ID)
+		email := strings.ToLower(strings.TrimSpace(user.Email))
+		if id == "" || ids[id] || (email != "" && emails[email]) {
+			continue
+		}
+		ids[id] = true
+		if email != "" {
+			emails[email] = true
+		}
+
+		unique = append(unique, dbstore.DirectoryUser{ID: id, Name: strings.TrimSpace(user.Name), FirstName: strings.TrimSpace(user.FirstName), LastName: strings.TrimSpace(user.LastName), Email: strings.TrimSpace(user.Email)})
+	}
+	return unique
+}
+
+// Sync replaces the local users with mSupply's active users, returning how many there are. The local users
+// are left as they were when mSupply can't be reached. Syncs can overlap, the last to finish wins.
+func (s *Syncer) Sync(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	settings, err := s.sql.GetSettings()
+	if err != nil {
+		log.DefaultLogger.Error("Syncer.Sync: GetSettings: " + err.Error())
+		return 0, err
+	}
+
+	authConfig, err := auth.NewAuthConfig(s.sql)
+	if err != nil {
+		log.DefaultLogger.Error("Syncer.Sync: NewAuthConfig: " + err.Error())
+		return 0, err
+	}
+
+	users, err := api.GetActiveUsers(ctx, *authConfig, settings.DatasourceID)
+	if err != nil {
+		log.DefaultLogger.Error("Syncer.Sync: GetActiveUsers: " + err.Error())
+		return 0, err
+	}
+
+	unique := deduplicate(users)
+	err = s.sql.ReplaceDirectoryUsers(unique)
+	if err != nil {
+		log.DefaultLogger.Error("Syncer.Sync: ReplaceDirectoryUsers: " + err.Error())
+		return 0, err
+	}
+
+	log.DefaultLogger.Info(fmt.Sprintf("Synced %d active mSupply users, %d duplicates left out", len(unique), len(users)-len(unique)))
+	return len(unique), nil
+}
+
+// Run syncs in the background, for the scheduler
+func (s *Syncer) Run() {
+	s.Sync(context.Background())
+}
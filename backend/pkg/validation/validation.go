@@ -0,0 +1,218 @@
+// Package validation checks every schedule of an installation at once, along with the systems reports depend on,
+// so problems left by a server migration or Grafana upgrade show up together rather than one failed run at a time.
+package validation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grafana/simple-datasource-backend/pkg/api"
+	"github.com/grafana/simple-datasource-backend/pkg/auth"
+	"github.com/grafana/simple-datasource-backend/pkg/dbstore"
+	"github.com/grafana/simple-datasource-backend/pkg/drift"
+	"github.com/grafana/simple-datasource-backend/pkg/health"
+)
+
+const (
+	SeverityError = "error"
+	// Won't stop the report being sent, but is probably a mistake
+	SeverityWarning = "warning"
+)
+
+// The checks made of each schedule
+const (
+	CheckConfiguration = "configuration"
+	CheckDashboards    = "dashboards"
+	CheckRecipients    = "recipients"
+	CheckEmailProfile  = "emailProfile"
+	CheckResidency     = "residency"
+)
+
+// Problem is something wrong with a schedule, found by one of the checks
+type Problem struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// ScheduleReport is the problems found with a schedule, none when it's fine
+type ScheduleReport struct {
+	ScheduleID   string    `json:"scheduleID"`
+	ScheduleName string    `json:"scheduleName"`
+	Problems     []Problem `json:"problems"`
+}
+
+// Report is the outcome of validating the whole installation. Valid is false when a system check or any schedule
+// has an error, warnings alone leave it valid.
+type Report struct {
+	CheckedAt int              `json:"checkedAt"`
+	Valid     bool             `json:"valid"`
+	Errors    int              `json:"errors"`
+	Warnings  int              `json:"warnings"`
+	Systems   []health.Check   `json:"systems"`
+	Schedules []ScheduleReport `json:"schedules"`
+}
+
+type Validator struct {
+	db         *dbstore.SQLiteDatasource
+	authConfig *auth.AuthConfig
+	settings   *dbstore.Settings
+	detector   *drift.Detector
+	// Recipients of each report group, so groups shared by several schedules are only looked up once
+	recipients map[string][]string
+}
+
+func NewValidator(ctx context.Context, db *dbstore.SQLiteDatasource, authConfig *auth.AuthConfig, settings *dbstore.Settings) *Validator {
+	return &Validator{db: db, authConfig: authConfig, settings: settings, detector: drift.NewDetector(ctx, authConfig), recipients: make(map[string][]string)}
+}
+
+// ValidateAll checks the systems reports depend on, then every schedule
+func (validator *Validator) ValidateAll(ctx context.Context) (*Report, error) {
+	report := &Report{CheckedAt: int(time.Now().Unix()), Systems: health.NewChecker(validator.db).Checks(ctx), Schedules: []ScheduleReport{}}
+	for _, check := range report.Systems {
+		if check.Status == health.StatusError {
+			report.Errors++
+		}
+	}
+
+	schedules, err := validator.db.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		scheduleReport, err := validator.Validate(ctx, schedule)
+		if err != nil {
+			return nil, err
+		}
+		for _, problem := range scheduleReport.Problems {
+			if problem.Severity == SeverityError {
+				report.Errors++
+			} else {
+				report.Warnings++
+			}
+		}
+		report.Schedules = append(report.Schedules, scheduleReport)
+	}
+
+	report.Valid = report.Errors == 0
+	return report, nil
+}
+
+// Validate checks a single schedule. An error is only returned when the plugin's own database can't be read,
+// problems with what the schedule refers to are reported as its problems.
+func (validator *Validator) Validate(ctx context.Context, schedule dbstore.Schedule) (ScheduleReport, error) {
+	report := ScheduleReport{ScheduleID: schedule.ID, ScheduleName: schedule.Name, Problems: []Problem{}}
+	add := func(check string, severity string, message string) {
+		report.Problems = append(report.Problems, Problem{Check: check, Severity: severity, Message: message})
+	}
+
+	copied := schedule
+	if err := copied.Validate(); err != nil {
+		add(CheckConfiguration, SeverityError, err.Error())
+	}
+	if schedule.DisabledAt > 0 {
+		add(CheckConfiguration, SeverityWarning, "disabled: "+schedule.DisabledReason)
+	}
+
+	if err := validator.checkDashboards(ctx, schedule, add); err != nil {
+		return report, err
+	}
+
+	reportGroup, err := validator.db.ReportGroupFromSchedule(schedule)
+	if errors.Is(err, sql.ErrNoRows) {
+		add(CheckRecipients, SeverityError, "the schedule isn't sent to a report group")
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+	validator.checkRecipients(ctx, *reportGroup, add)
+	if reportGroup.EncryptAttachments && !reportGroup.HasAttachmentPassword {
+		add(CheckConfiguration, SeverityError, "report group '"+reportGroup.Name+"' encrypts its attachments but has no attachment password")
+	}
+
+	settings := *validator.settings
+	if schedule.EmailProfileID != "" {
+		profile, err := validator.db.GetEmailProfile(schedule.EmailProfileID)
+		if err != nil {
+			add(CheckEmailProfile, SeverityError, "email profile "+schedule.EmailProfileID+": "+err.Error())
+			return report, nil
+		}
+		settings = profile.Apply(settings)
+	}
+	if err := validator.db.CheckResidency(*reportGroup, dbstore.ChannelEmail, settings.EmailHost); err != nil {
+		add(CheckResidency, SeverityError, err.Error())
+	}
+
+	return report, nil
+}
+
+// checkDashboards checks the dashboards and panels the schedule's content and playlist refer to still exist
+func (validator *Validator) checkDashboards(ctx context.Context, schedule dbstore.Schedule, add func(string, string, string)) error {
+	contents, err := validator.db.GetReportContent(schedule.ID)
+	if err != nil {
+		return err
+	}
+
+	if schedule.PlaylistUID != "" {
+		if _, err := api.GetPlaylist(ctx, validator.authConfig, schedule.PlaylistUID); err != nil {
+			add(CheckDashboards, SeverityError, "playlist: "+err.Error())
+		}
+	} else if len(contents) == 0 {
+		add(CheckDashboards, SeverityWarning, "the schedule has no content")
+	}
+
+	drifts, err := validator.detector.Detect(contents)
+	if err != nil {
+		add(CheckDashboards, SeverityError, "Grafana: "+err.Error())
+		return nil
+	}
+	for _, found := range drifts {
+		content := found.ReportContent
+		switch found.Status {
+		case drift.StatusDashboardMissing:
+			add(CheckDashboards, SeverityError, "dashboard "+content.DashboardID+" no longer exists")
+		case drift.StatusPanelMissing:
+			add(CheckDashboards, SeverityError, "panel '"+content.PanelTitle+"' is no longer on dashboard "+content.DashboardID)
+		case drift.StatusPanelChanged:
+			add(CheckDashboards, SeverityWarning, "panel '"+content.PanelTitle+"' on dashboard "+content.DashboardID+" is now called '"+found.CurrentTitle+"'")
+		}
+	}
+	return nil
+}
+
+// checkRecipients checks the report group has someone to send to, at addresses which look deliverable. Their
+// domains are only looked up when the settings verify them.
+func (validator *Validator) checkRecipients(ctx context.Context, reportGroup dbstore.ReportGroup, add func(string, string, string)) {
+	emails, ok := validator.recipients[reportGroup.ID]
+	if !ok {
+		userIDs, err := validator.db.GroupMemberUserIDs(reportGroup)
+		if err == nil {
+			emails, err = api.GroupEmails(ctx, *validator.authConfig, userIDs, reportGroup.MemberQuery, validator.settings.DatasourceID)
+		}
+		if err != nil {
+			add(CheckRecipients, SeverityError, "report group '"+reportGroup.Name+"': "+err.Error())
+			return
+		}
+		validator.recipients[reportGroup.ID] = emails
+	}
+
+	if len(emails) == 0 {
+		add(CheckRecipients, SeverityError, "report group '"+reportGroup.Name+"' has no one to send to")
+		return
+	}
+
+	addresses := make(map[string]string)
+	for _, email := range emails {
+		addresses[email] = email
+	}
+	var fieldErrors dbstore.FieldErrors
+	if errors.As(dbstore.CheckEmailAddresses(ctx, addresses, validator.settings.VerifyEmailDomains), &fieldErrors) {
+		for _, fieldError := range fieldErrors {
+			add(CheckRecipients, SeverityError, fieldError.Message)
+		}
+	}
+}
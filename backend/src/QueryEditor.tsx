@@ -1,13 +1,48 @@
 import React, { PureComponent } from 'react';
+import { defaults } from 'lodash';
 
-import { QueryEditorProps } from '@grafana/data';
+import { QueryEditorProps, SelectableValue } from '@grafana/data';
+import { InlineFormLabel, Select } from '@grafana/ui';
 import { DataSource } from './DataSource';
-import { MyDataSourceOptions, MyQuery } from './types';
+import { defaultQuery, MyDataSourceOptions, MyQuery, QueryType } from './types';
 
 type Props = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
 
+const queryTypeOptions: Array<SelectableValue<QueryType>> = [
+  { label: 'Schedules', value: 'schedules' },
+  { label: 'Report runs', value: 'reportRuns', description: 'Runs started in the dashboard time range' },
+  { label: 'Upcoming runs', value: 'upcomingRuns' },
+  { label: 'Report group membership', value: 'reportGroupMembership' },
+  { label: 'SLO compliance', value: 'sloCompliance' },
+  {
+    label: 'Dispatch status',
+    value: 'dispatchStatus',
+    description: 'Minutes each schedule is overdue, for alerting when reports stop being sent',
+  },
+];
+
 export class QueryEditor extends PureComponent<Props> {
+  onQueryTypeChange = (option: SelectableValue<QueryType>) => {
+    const { onChange, onRunQuery } = this.props;
+    // Keeps the query's other fields, e.g. constant and queryText, as they are
+    const query = defaults(this.props.query, defaultQuery);
+    onChange({ ...query, queryType: option.value });
+    onRunQuery();
+  };
+
   render() {
-    return <div className="gf-form"></div>;
+    const query = defaults(this.props.query, defaultQuery);
+
+    return (
+      <div className="gf-form">
+        <InlineFormLabel width={10}>Query type</InlineFormLabel>
+        <Select
+          width={30}
+          options={queryTypeOptions}
+          value={queryTypeOptions.find(option => option.value === query.queryType)}
+          onChange={this.onQueryTypeChange}
+        />
+      </div>
+    );
   }
 }
@@ -1,12 +1,16 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
+export type QueryType = 'schedules' | 'reportRuns' | 'upcomingRuns' | 'reportGroupMembership' | 'sloCompliance' | 'dispatchStatus';
+
 export interface MyQuery extends DataQuery {
   queryText?: string;
   constant: number;
+  queryType?: QueryType;
 }
 
 export const defaultQuery: Partial<MyQuery> = {
   constant: 6.5,
+  queryType: 'schedules',
 };
 
 /**